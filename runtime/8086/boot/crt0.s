@@ -0,0 +1,82 @@
+#
+#	C runtime module for boot sectors
+#
+
+# Calling conventions: stack, return in %ax
+#
+# The BIOS loads the boot sector to 0x7c00 and jumps to it. The code
+# runs in segment 0 with the stack below the boot sector and the heap
+# from the end of the bss to the top of the segment, which is the tiny
+# model that the code is compiled for. The console functions and the
+# system calls are in the library, so the ones a program doesn't use
+# take no room in the sector.
+
+	.code16
+	.arch	i8086
+
+	.data
+	.globl	Cenviron
+Cenviron:
+	.short	noargs
+noargs:	.short	0		# argv[] and environ[] are empty
+
+	.weak	C_init		# only there when stdio is used
+
+	.text
+	.globl	_start
+_start:
+	cli
+	xorw	%ax, %ax	# CS, DS, ES and SS are segment 0
+	movw	%ax, %ds
+	movw	%ax, %es
+	movw	%ax, %ss
+	movw	$0x7c00, %sp
+	ljmp	$0, $go
+go:	sti
+	cld
+
+	movw	$__bss_start, %di	# clear the bss
+	movw	$_end, %cx
+	subw	%di, %cx
+	xorb	%al, %al
+	rep stosb
+
+	movw	$C_init, %ax	#INIT
+	orw	%ax, %ax
+	jz	nolib
+	call	*%ax
+
+nolib:	movw	$noargs, %ax	# call main(0, argv)
+	pushw	%ax
+	xorw	%ax, %ax
+	pushw	%ax
+	call	Cmain
+
+# void _exit(int rc);
+# there is nothing to return to, so the machine is stopped
+
+	.globl	C_exit
+C_exit:	cli
+	hlt
+	jmp	C_exit
+
+# internal switch(expr) routine
+# %si = switch table, %ax = expr
+
+	.globl	switch
+switch:	movw	(%si), %cx	# count
+	incw	%si
+	incw	%si
+	jcxz	dflt
+nxcase:	movw	(%si), %dx	# fetch value from table
+	incw	%si
+	incw	%si
+	movw	(%si), %bx	# fetch address from table
+	incw	%si
+	incw	%si
+	cmpw	%dx, %ax	# right case?
+	je	docase
+	decw	%cx
+	jnz	nxcase
+dflt:	movw	(%si), %bx
+docase:	jmp	*%bx
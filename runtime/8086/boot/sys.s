@@ -0,0 +1,107 @@
+#
+#	system calls of boot sectors
+#
+
+# There are no files and no processes, the calls that would need them
+# fail. The heap is the memory from the end of the bss to the top of
+# the segment.
+
+	.code16
+	.arch	i8086
+
+	.set	MAXMEM, 0xfff0
+
+	.data
+curbrk:	.short	_end
+
+abort:	.ascii	"crt0: aborted.\r\n"
+	.set	ABORTLEN, .-abort
+
+	.text
+
+# int setjmp(jmp_buf env);
+
+	.globl	Csetjmp
+Csetjmp:
+	movw	%sp, %bx
+	movw	2(%bx), %bx	# env
+	movw	%sp, %ax
+	addw	$2, %ax
+	movw	%ax, (%bx)
+	movw	%bp, 2(%bx)
+	movw	%sp, %si
+	movw	(%si), %ax
+	movw	%ax, 4(%bx)
+	xorw	%ax, %ax
+	ret
+
+# void longjmp(jmp_buf env, int v);
+
+	.globl	Clongjmp
+Clongjmp:
+	movw	%sp, %bx
+	movw	4(%bx), %ax	# v
+	orw	%ax, %ax
+	jnz	vok
+	incw	%ax
+vok:	movw	2(%bx), %bx	# env
+	movw	(%bx), %sp
+	movw	2(%bx), %bp
+	movw	4(%bx), %si
+	jmp	*%si
+
+# void *_sbrk(int size);
+
+	.globl	C_sbrk
+C_sbrk:	movw	%sp, %bx
+	movw	2(%bx), %ax	# size
+	addw	curbrk, %ax
+	cmpw	$MAXMEM, %ax
+	ja	fail
+	cmpw	$_end, %ax
+	jb	fail
+	movw	curbrk, %dx
+	movw	%ax, curbrk
+	movw	%dx, %ax
+	ret
+
+# int raise(int sig);
+
+	.globl	Craise
+Craise:	movw	$ABORTLEN, %ax
+	pushw	%ax
+	movw	$abort, %ax
+	pushw	%ax
+	pushw	%ax
+	call	C_write
+	jmp	C_exit
+
+# int signal(int sig, int (*handler)())
+
+	.globl	Csignal
+Csignal:
+	movw	$-2, %ax	# dummy, return SIG_ERR
+	ret
+
+# int _time(void);
+
+	.globl	C_time
+C_time:	xorw	%ax, %ax	# dummy, return 0
+	ret
+
+# the calls on files and processes return -1
+
+	.globl	C_creat, C_open, C_close, C_lseek, C_unlink, C_rename
+	.globl	C_fork, C_wait, C_execve, C_system
+C_creat:
+C_open:
+C_close:
+C_lseek:
+C_unlink:
+C_rename:
+C_fork:
+C_wait:
+C_execve:
+C_system:
+fail:	movw	$-1, %ax
+	ret
@@ -0,0 +1,71 @@
+#
+#	console of boot sectors, through the BIOS
+#
+
+# All the files are the screen and the keyboard: _write writes to the
+# screen with the teletype output of the BIOS, and _read reads a line
+# from the keyboard, echoing it.
+
+	.code16
+	.arch	i8086
+
+	.set	VIDEO, 0x10
+	.set	KEYBOARD, 0x16
+
+	.text
+
+# int _write(int fd, void *buf, int len);
+
+	.globl	C_write
+C_write:
+	movw	%sp, %bx
+	movw	6(%bx), %cx	# len
+	movw	4(%bx), %si	# buf
+	pushw	%cx
+	jcxz	wrdone
+wrnext:	lodsb
+	cmpb	$10, %al	# \n starts a line too
+	jne	wrch
+	movb	$13, %al
+	call	putch
+	movb	$10, %al
+wrch:	call	putch
+	loop	wrnext
+wrdone:	popw	%ax
+	ret
+
+# int _read(int fd, void *buf, int len);
+
+	.globl	C_read
+C_read:	movw	%sp, %bx
+	movw	6(%bx), %cx	# len
+	movw	4(%bx), %di	# buf
+	xorw	%dx, %dx	# count
+	jcxz	rddone
+rdnext:	xorb	%ah, %ah	# wait for a key
+	int	$KEYBOARD
+	cmpb	$13, %al	# Enter ends the line
+	je	rdeol
+	call	putch
+	stosb
+	incw	%dx
+	loop	rdnext
+	jmp	rddone
+rdeol:	call	putch
+	movb	$10, %al
+	call	putch
+	stosb
+	incw	%dx
+rddone:	movw	%dx, %ax
+	ret
+
+# write the character in %al
+
+putch:	pushw	%ax
+	pushw	%bx
+	movb	$0x0e, %ah	# teletype output
+	movw	$7, %bx		# page 0, light gray
+	int	$VIDEO
+	popw	%bx
+	popw	%ax
+	ret
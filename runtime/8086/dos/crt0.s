@@ -0,0 +1,431 @@
+#
+#	C runtime module for DOS .com programs, after the one of
+#	NMH's Simple C Compiler for DOS/8086
+#
+
+# Calling conventions: stack, return in %ax
+# System call: %ah=call#, arguments in call-specific registers,
+#              carry indicates error,
+#              return/error value in %ax
+#
+# A .com program is loaded at 0x100 of a segment, after the PSP, with
+# CS, DS, ES and SS all pointing at the segment and the stack at the
+# top of it. This is the tiny model that the code is compiled for.
+
+	.code16
+	.arch	i8086
+
+	.set	DOS, 0x21
+	.set	CMDLEN, 128	# the command line in the PSP
+	.set	PSPCL, 0x81
+	.set	ENVPTR, 0x2c	# the segment of the environment in the PSP
+	.set	STKLEN, 0x1000	# the stack left below the environment
+
+	.data
+	.globl	Cenviron
+Cenviron:
+	.short	0
+	.globl	C_faddcr
+C_faddcr:
+	.short	1		# write \n to the console as \r\n
+
+# memory from the end of the bss to the stack is the heap
+
+curbrk:	.short	0
+maxmem:	.short	0
+
+argc:	.short	0
+argv:	.short	0
+noname:	.byte	0
+
+memerror:
+	.ascii	"crt0: out of memory.\r\n$"
+abort:	.ascii	"crt0: aborted.\r\n$"
+
+	.lcomm	cmdline, CMDLEN+1
+	.lcomm	args, CMDLEN+4	# argv[], no more than one arg in two bytes
+
+	.text
+	.globl	_start
+_start:
+	cld
+	movw	$0x1000, %bx	# keep 64K of memory, the rest is free
+	movb	$0x4a, %ah	# for running the programs of _system
+	int	$DOS
+	jc	nomem
+
+	movw	$__bss_start, %di	# clear the bss
+	movw	$_end, %cx
+	subw	%di, %cx
+	xorb	%al, %al
+	rep stosb
+
+# copy the command line from the PSP and build argv
+
+	movw	$PSPCL, %si
+	movw	$cmdline, %di
+	movw	$CMDLEN-1, %cx
+	rep movsb
+	movb	$0x0d, (%di)	# in case it fills the PSP
+	movw	$cmdline, %bx	# bx = command line
+	movw	$args, %si	# si = argv[argc]
+	movw	%si, argv
+	movw	$noname, (%si)	# argv[0] is "", DOS doesn't tell
+	incw	%si
+	incw	%si
+	movw	$1, %cx		# preset argc
+
+nextch:	movb	(%bx), %al	# get char from cmd line
+	incw	%bx
+	cmpb	$0x0d, %al	# end of line ?
+	je	argsdone
+	cmpb	$0x20, %al	# skip white spaces
+	je	nextch
+	cmpb	$9, %al
+	je	nextch
+
+	decw	%bx
+	movw	%bx, (%si)	# save argv[n]
+	incw	%bx
+	incw	%si
+	incw	%si
+	incw	%cx		# increment argc
+
+getend:	movb	(%bx), %al	# search end of current arg
+	cmpb	$0x20, %al
+	je	endarg
+	cmpb	$9, %al
+	je	endarg
+	cmpb	$0x0d, %al	# CR: end of line reached, stop parsing
+	je	argsdone
+	incw	%bx
+	jmp	getend
+
+endarg:	movb	$0, (%bx)
+	incw	%bx
+	jmp	nextch
+
+argsdone:
+	movb	$0, (%bx)	# terminate last arg
+	movw	$0, (%si)	# terminate argv[] with NULL
+	movw	%cx, argc
+
+# copy the environment below the stack
+
+	movw	ENVPTR, %es	# es = environment
+	xorw	%bx, %bx	# get length of env
+	xorw	%dx, %dx	# and number of entries
+nxtenv:	movb	%es:(%bx), %al
+	incw	%bx
+	orb	%al, %al
+	jnz	nxtenv
+	incw	%dx
+	movb	%es:(%bx), %al
+	incw	%bx
+	orb	%al, %al
+	jnz	nxtenv
+
+	movw	%sp, %ax	# space for the env and environ[]
+	subw	%bx, %ax
+	subw	%dx, %ax
+	subw	%dx, %ax
+	subw	$2, %ax
+	andw	$-2, %ax
+	movw	%ax, %sp
+
+	movw	%sp, %di	# copy environment
+	xorw	%si, %si
+	movw	%bx, %cx
+	pushw	%ds
+	pushw	%es
+	popw	%ds
+	popw	%es
+	rep movsb
+	pushw	%es
+	popw	%ds
+
+	movw	%di, Cenviron
+	movw	%sp, %si	# build environ[]
+	incw	%si
+store:	decw	%si
+	movw	%si, %ax
+	stosw
+scan:	lodsb
+	orb	%al, %al
+	jnz	scan
+	lodsb
+	orb	%al, %al
+	jnz	store
+	xorw	%ax, %ax
+	stosw
+
+	movw	$_end, curbrk	# the heap ends STKLEN below the stack
+	movw	%sp, %ax
+	subw	$STKLEN, %ax
+	jc	nomem
+	movw	%ax, maxmem
+	cmpw	$_end, %ax
+	jb	nomem
+
+# initialize run time library
+
+	call	C_init		#INIT
+
+# call main() and exit
+
+	pushw	argv
+	pushw	argc
+	call	Cmain
+	addw	$4, %sp
+	pushw	%ax
+x:	call	Cexit		#EXIT
+	xorw	%bx, %bx
+	divw	%bx
+	jmp	x
+
+nomem:	pushw	%cs
+	popw	%ds
+	movw	$memerror, %dx
+	movb	$9, %ah		# write $-terminated
+	int	$DOS
+	movw	$0x4c7f, %ax	# exit(127)
+	int	$DOS
+
+# internal switch(expr) routine
+# %si = switch table, %ax = expr
+
+	.globl	switch
+switch:	movw	(%si), %cx	# count
+	incw	%si
+	incw	%si
+	jcxz	dflt
+nxcase:	movw	(%si), %dx	# fetch value from table
+	incw	%si
+	incw	%si
+	movw	(%si), %bx	# fetch address from table
+	incw	%si
+	incw	%si
+	cmpw	%dx, %ax	# right case?
+	je	docase
+	decw	%cx
+	jnz	nxcase
+dflt:	movw	(%si), %bx
+docase:	jmp	*%bx
+
+# int setjmp(jmp_buf env);
+
+	.globl	Csetjmp
+Csetjmp:
+	movw	%sp, %bx
+	movw	2(%bx), %bx	# env
+	movw	%sp, %ax
+	addw	$2, %ax
+	movw	%ax, (%bx)
+	movw	%bp, 2(%bx)
+	movw	%sp, %si
+	movw	(%si), %ax
+	movw	%ax, 4(%bx)
+	xorw	%ax, %ax
+	ret
+
+# void longjmp(jmp_buf env, int v);
+
+	.globl	Clongjmp
+Clongjmp:
+	movw	%sp, %bx
+	movw	4(%bx), %ax	# v
+	orw	%ax, %ax
+	jnz	vok
+	incw	%ax
+vok:	movw	2(%bx), %bx	# env
+	movw	(%bx), %sp
+	movw	2(%bx), %bp
+	movw	4(%bx), %si
+	jmp	*%si
+
+# void *_sbrk(int size);
+
+	.globl	C_sbrk
+C_sbrk:	movw	%sp, %bx
+	movw	2(%bx), %ax	# size
+	addw	curbrk, %ax
+	cmpw	maxmem, %ax
+	ja	sbfail
+	cmpw	$_end, %ax
+	jb	sbfail
+	movw	curbrk, %dx
+	movw	%ax, curbrk
+	movw	%dx, %ax
+	ret
+sbfail:	movw	$-1, %ax
+	ret
+
+# void _exit(int rc);
+
+	.globl	C_exit
+C_exit:	movw	%sp, %bx
+	movw	2(%bx), %ax	# rc
+	movb	$0x4c, %ah	# terminate program
+	int	$DOS
+
+# int _creat(char *name, int perms);
+
+	.globl	C_creat
+C_creat:
+	movw	%sp, %bx
+	xorw	%cx, %cx
+	movw	2(%bx), %dx	# name
+	movb	$0x3c, %ah
+	int	$DOS
+	jc	fail
+	ret
+
+# int _open(char *name, int mode);
+
+	.globl	C_open
+C_open:	movw	%sp, %bx
+	movw	4(%bx), %ax	# mode
+	movb	$0x3d, %ah
+	movw	2(%bx), %dx	# name
+	int	$DOS
+	jc	fail
+	ret
+
+# int _close(int fd);
+
+	.globl	C_close
+C_close:
+	movw	%sp, %bx
+	movw	2(%bx), %bx	# fd
+	movb	$0x3e, %ah
+	int	$DOS
+	jc	fail
+	xorw	%ax, %ax
+	ret
+
+# int _read(int fd, void *buf, int len);
+
+	.globl	C_read
+C_read:	movw	%sp, %bx
+	movw	6(%bx), %cx	# len
+	movw	4(%bx), %dx	# buf
+	movw	2(%bx), %bx	# fd
+	movb	$0x3f, %ah
+	int	$DOS
+	jc	fail
+	ret
+
+# int _write(int fd, void *buf, int len);
+
+	.globl	C_write
+C_write:
+	movw	%sp, %bx
+	movw	6(%bx), %cx	# len
+	movw	4(%bx), %dx	# buf
+	movw	2(%bx), %bx	# fd
+	movb	$0x40, %ah
+	int	$DOS
+	jc	fail
+	ret
+
+# the system calls return -1 when DOS sets the carry
+
+fail:	movw	$-1, %ax
+	ret
+
+# int raise(int sig);
+
+	.globl	Craise
+Craise:	movw	$abort, %dx
+	movb	$9, %ah
+	int	$DOS
+	movw	$0x4c7f, %ax
+	int	$DOS
+	ret
+
+# int signal(int sig, int (*handler)())
+
+	.globl	Csignal
+Csignal:
+	movw	$-2, %ax	# dummy, return SIG_ERR
+	ret
+
+# int _lseek(int fd, int where, int how);
+
+	.globl	C_lseek
+C_lseek:
+	movw	%sp, %bx
+	movw	4(%bx), %ax	# where
+	cwd
+	movw	%dx, %cx
+	movw	%ax, %dx
+	movw	6(%bx), %ax	# how
+	movw	2(%bx), %bx	# fd
+	movb	$0x42, %ah
+	int	$DOS
+	jc	fail
+	ret
+
+# int _unlink(char *name);
+
+	.globl	C_unlink
+C_unlink:
+	movw	%sp, %bx
+	movw	2(%bx), %dx	# name
+	movb	$0x41, %ah
+	int	$DOS
+	jc	fail
+	xorw	%ax, %ax
+	ret
+
+# int _rename(char *old, char *new);
+
+	.globl	C_rename
+C_rename:
+	movw	%sp, %bx
+	movw	2(%bx), %dx	# old
+	movw	4(%bx), %di	# new
+	movb	$0x56, %ah
+	int	$DOS
+	jc	fail
+	xorw	%ax, %ax
+	ret
+
+# int _fork(void);
+
+	.globl	C_fork
+C_fork:	jmp	fail		# dummy, return -1
+
+# int _wait(int *rc);
+
+	.globl	C_wait
+C_wait:	jmp	fail		# dummy, return -1
+
+# int _execve(char *prog, char **argv, char **env);
+
+	.globl	C_execve
+C_execve:
+	jmp	fail		# dummy, return -1
+
+# int _system(char *shell, void *parmb);
+
+	.globl	C_system
+C_system:
+	movw	%sp, %bx
+	movw	2(%bx), %dx	# shell
+	movw	4(%bx), %bx	# parmb
+	movw	%ds, %di
+	movw	%di, 4(%bx)
+	movw	%di, 8(%bx)
+	movw	%di, 12(%bx)
+	movw	$0x4b00, %ax
+	int	$DOS
+	jc	fail
+	xorw	%ax, %ax
+	ret
+
+# int _time(void);
+
+	.globl	C_time
+C_time:	xorw	%ax, %ax	# dummy, return 0
+	ret
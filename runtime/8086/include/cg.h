@@ -0,0 +1,6 @@
+/*
+ *	8086 target description
+ */
+
+#define CPU	"8086"
+#define BPW	2
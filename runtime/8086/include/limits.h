@@ -0,0 +1,10 @@
+/*
+ *	NMH's Simple C Compiler, 2013
+ *	limits.h on 16-bit systems
+ */
+
+#define CHAR_BIT	8
+#define CHAR_MAX	255
+
+#define INT_MIN		(-0x7fff-1)
+#define INT_MAX		 0x7fff
@@ -6,5 +6,5 @@
 #define CHAR_BIT	8
 #define CHAR_MAX	255
 
-#define INT_MIN		-0x80000000
+#define INT_MIN		(-0x7fffffff-1)
 #define INT_MAX		 0x7fffffff
@@ -6,5 +6,10 @@
 #define CHAR_BIT	8
 #define CHAR_MAX	255
 
-#define INT_MIN		-0x80000000
+#ifdef __INT16__
+#define INT_MIN		(-0x7fff-1)
+#define INT_MAX		 0x7fff
+#else
+#define INT_MIN		(-0x7fffffff-1)
 #define INT_MAX		 0x7fffffff
+#endif
@@ -5,6 +5,7 @@
 
 struct _jmp_buf {
 	void	*sp, *fp, *ip;
+	void	*regs[4];	/* register variables on x86-64 */
 };
 
 #define jmp_buf	struct _jmp_buf
@@ -0,0 +1,4 @@
+#define NULL      (void *)0
+#define size_t    __SIZE_TYPE__
+#define ptrdiff_t __PTRDIFF_TYPE__
+#define wchar_t   __WCHAR_TYPE__
@@ -0,0 +1,6 @@
+/*
+ *	mips target description
+ */
+
+#define CPU	"mips"
+#define BPW	4
@@ -0,0 +1,263 @@
+#
+#	C runtime module for Linux/mips, after the one of
+#	NMH's Simple C Compiler for Linux/386
+#
+
+# Calling conventions: stack, return in $v0, the arguments of
+#              a routine start at 0($sp) as $ra has the return
+# System call: $v0=call#, arguments in $a0-$a3,
+#              $a3 indicates error,
+#              return/error value in $v0
+#
+# The system calls may change $t0-$t9, $v1 and $a3 and return the
+# error as a positive number, which is negated like the other targets
+# return it. The assembler is in reorder mode and fills the delay slots.
+
+	.data
+	.globl	Cenviron
+Cenviron:
+	.word	0
+
+	.text
+	.globl	_start
+_start:
+	lw	$t0, 0($sp)	# argc
+	addiu	$t1, $sp, 4	# argv
+	sll	$t2, $t0, 2	# environ = &argv[argc+1]
+	addu	$t2, $t2, $t1
+	addiu	$t2, $t2, 4
+	lui	$t3, %hi(Cenviron)
+	sw	$t2, %lo(Cenviron)($t3)
+	addiu	$sp, $sp, -8
+	sw	$t1, 4($sp)
+	sw	$t0, 0($sp)
+	jal	C_init		#INIT
+	jal	Cmain
+	addiu	$sp, $sp, 4
+	sw	$v0, 0($sp)
+x:	jal	Cexit		#EXIT
+	b	x
+
+# internal switch(expr) routine
+# $v1 = switch table, $v0 = expr
+
+	.globl	switch
+switch:	lw	$t0, 0($v1)	# count
+	addiu	$v1, $v1, 4
+	beq	$t0, $zero, dflt
+next:	lw	$t1, 0($v1)	# fetch value from table
+	lw	$t2, 4($v1)	# fetch address from table
+	addiu	$v1, $v1, 8
+	bne	$t1, $v0, no
+	jr	$t2
+no:	addiu	$t0, $t0, -1
+	bne	$t0, $zero, next
+dflt:	lw	$t2, 0($v1)
+	jr	$t2
+
+# int setjmp(jmp_buf env);
+
+	.globl	Csetjmp
+Csetjmp:
+	lw	$t0, 0($sp)	# env
+	sw	$sp, 0($t0)
+	sw	$fp, 4($t0)
+	sw	$ra, 8($t0)
+	move	$v0, $zero
+	jr	$ra
+
+# void longjmp(jmp_buf env, int v);
+
+	.globl	Clongjmp
+Clongjmp:
+	lw	$v0, 4($sp)	# v
+	bne	$v0, $zero, vok
+	li	$v0, 1
+vok:	lw	$t0, 0($sp)	# env
+	lw	$sp, 0($t0)
+	lw	$fp, 4($t0)
+	lw	$t1, 8($t0)
+	jr	$t1
+
+# the system calls return the error negated
+
+sysret:	bne	$a3, $zero, syserr
+	jr	$ra
+syserr:	subu	$v0, $zero, $v0
+	jr	$ra
+
+# void _exit(int rc);
+
+	.globl	C_exit
+C_exit:	lw	$a0, 0($sp)	# rc
+	li	$v0, 4001
+	syscall
+	jr	$ra
+
+# int _sbrk(int size);
+
+	.data
+curbrk:	.word	0
+
+	.text
+	.globl	C_sbrk
+C_sbrk:
+	lui	$t3, %hi(curbrk)
+	lw	$v0, %lo(curbrk)($t3)
+	bne	$v0, $zero, sbrk
+	move	$a0, $zero	# get break
+	li	$v0, 4045	# brk
+	syscall
+	lui	$t3, %hi(curbrk)
+	sw	$v0, %lo(curbrk)($t3)
+sbrk:	lw	$t0, 0($sp)	# size
+	bne	$t0, $zero, setbrk
+	jr	$ra		# size==0, return break
+setbrk:	addu	$a0, $v0, $t0	# set new break
+	li	$v0, 4045	# brk
+	syscall
+	lui	$t3, %hi(curbrk)
+	lw	$t0, %lo(curbrk)($t3)
+	bne	$v0, $t0, sbrkok	# brk(x)==curbrk -> error
+	li	$v0, -1
+	jr	$ra
+sbrkok:	sw	$v0, %lo(curbrk)($t3)	# update curr. break
+	move	$v0, $t0
+	jr	$ra
+
+# int _write(int fd, void *buf, int len);
+
+	.globl	C_write
+C_write:
+	lw	$a2, 8($sp)	# len
+	lw	$a1, 4($sp)	# buf
+	lw	$a0, 0($sp)	# fd
+	li	$v0, 4004
+	syscall
+	b	sysret
+
+# int _read(int fd, void *buf, int len);
+
+	.globl	C_read
+C_read:	lw	$a2, 8($sp)	# len
+	lw	$a1, 4($sp)	# buf
+	lw	$a0, 0($sp)	# fd
+	li	$v0, 4003
+	syscall
+	b	sysret
+
+# int _lseek(int fd, int pos, int how);
+
+	.globl	C_lseek
+C_lseek:
+	lw	$a2, 8($sp)	# how
+	lw	$a1, 4($sp)	# pos
+	lw	$a0, 0($sp)	# fd
+	li	$v0, 4019
+	syscall
+	b	sysret
+
+# int _creat(char *path, int mode);
+
+	.globl	C_creat
+C_creat:
+	lw	$a1, 4($sp)	# mode
+	lw	$a0, 0($sp)	# path
+	li	$v0, 4008
+	syscall
+	b	sysret
+
+# int _open(char *path, int flags);
+
+	.globl	C_open
+C_open:	lw	$a1, 4($sp)	# flags
+	lw	$a0, 0($sp)	# path
+	li	$v0, 4005
+	syscall
+	b	sysret
+
+# int _close(int fd);
+
+	.globl	C_close
+C_close:
+	lw	$a0, 0($sp)	# fd
+	li	$v0, 4006
+	syscall
+	b	sysret
+
+# int _unlink(char *path);
+
+	.globl	C_unlink
+C_unlink:
+	lw	$a0, 0($sp)	# path
+	li	$v0, 4010
+	syscall
+	b	sysret
+
+# int _rename(char *old, char *new);
+
+	.globl	C_rename
+C_rename:
+	lw	$a1, 4($sp)	# new
+	lw	$a0, 0($sp)	# old
+	li	$v0, 4038
+	syscall
+	b	sysret
+
+# int _fork(void);
+
+	.globl	C_fork
+C_fork:	li	$v0, 4002
+	syscall
+	b	sysret
+
+# int _wait(int *rc);
+
+	.globl	C_wait
+C_wait:	li	$a0, -1
+	lw	$a1, 0($sp)	# rc
+	move	$a2, $zero
+	li	$v0, 4007	# waitpid
+	syscall
+	b	sysret
+
+# int _execve(char *path, char *argv[], char *envp[]);
+
+	.globl	C_execve
+C_execve:
+	lw	$a2, 8($sp)	# envp
+	lw	$a1, 4($sp)	# argv
+	lw	$a0, 0($sp)	# path
+	li	$v0, 4011
+	syscall
+	b	sysret
+
+# int _time(void);
+
+	.globl	C_time
+C_time:	move	$a0, $zero
+	li	$v0, 4013
+	syscall
+	b	sysret
+
+# int raise(int sig);
+
+	.globl	Craise
+Craise:
+	li	$v0, 4020	# getpid
+	syscall
+	move	$a0, $v0
+	lw	$a1, 0($sp)	# sig
+	li	$v0, 4037	# kill
+	syscall
+	b	sysret
+
+# int signal(int sig, int (*fn)());
+
+	.globl	Csignal
+Csignal:
+	lw	$a1, 4($sp)	# fn
+	lw	$a0, 0($sp)	# sig
+	li	$v0, 4048
+	syscall
+	b	sysret
@@ -0,0 +1,81 @@
+//go:build js && wasm
+
+package main
+
+import (
+	"syscall/js"
+	"text/scanner"
+
+	"subc/scan"
+)
+
+func main() {
+	subc := js.Global().Get("Object").New()
+	subc.Set("compile", js.FuncOf(jsCompile))
+	js.Global().Set("subc", subc)
+	select {}
+}
+
+// jsCompile implements subc.compile for JavaScript.
+func jsCompile(this js.Value, args []js.Value) interface{} {
+	opts := options{
+		Arch:  "amd64",
+		OS:    "linux",
+		Files: make(map[string][]byte),
+	}
+	if len(args) < 1 || args[0].Type() != js.TypeString {
+		return map[string]interface{}{
+			"asm":         "",
+			"diagnostics": jsDiagnostics(scan.Diagnostics{{Message: "subc.compile: expected source string"}}),
+		}
+	}
+	if len(args) > 1 && args[1].Type() == js.TypeObject {
+		o := args[1]
+		if v := o.Get("arch"); v.Type() == js.TypeString {
+			opts.Arch = v.String()
+		}
+		if v := o.Get("os"); v.Type() == js.TypeString {
+			opts.OS = v.String()
+		}
+		if v := o.Get("files"); v.Type() == js.TypeObject {
+			keys := js.Global().Get("Object").Call("keys", v)
+			for i := 0; i < keys.Length(); i++ {
+				name := keys.Index(i).String()
+				opts.Files[name] = []byte(v.Get(name).String())
+			}
+		}
+	}
+
+	res := compileSource(args[0].String(), opts)
+	return map[string]interface{}{
+		"asm":         res.Asm,
+		"diagnostics": jsDiagnostics(res.Diagnostics),
+	}
+}
+
+// jsDiagnostics converts the diagnostics to the objects of subc.compile.
+func jsDiagnostics(l scan.Diagnostics) []interface{} {
+	diags := []interface{}{}
+	for _, d := range l {
+		notes := []interface{}{}
+		for _, n := range d.Notes {
+			notes = append(notes, jsPos(n.Pos, map[string]interface{}{
+				"message": n.Message,
+			}))
+		}
+		diags = append(diags, jsPos(d.Pos, map[string]interface{}{
+			"severity": d.Severity.String(),
+			"message":  d.Message,
+			"notes":    notes,
+		}))
+	}
+	return diags
+}
+
+// jsPos adds the file, line and column of pos to the object o.
+func jsPos(pos scanner.Position, o map[string]interface{}) map[string]interface{} {
+	o["file"] = pos.Filename
+	o["line"] = pos.Line
+	o["column"] = pos.Column
+	return o
+}
@@ -0,0 +1,28 @@
+//go:build !(js && wasm)
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// main compiles a program from standard input, so the
+// playground can be tried out without a browser.
+func main() {
+	src, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	res := compileSource(string(src), options{Arch: "amd64", OS: "linux"})
+	for _, d := range res.Diagnostics {
+		fmt.Fprintln(os.Stderr, d)
+	}
+	fmt.Print(res.Asm)
+	if res.Asm == "" {
+		os.Exit(1)
+	}
+}
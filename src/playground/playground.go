@@ -0,0 +1,149 @@
+// Command playground builds the compiler as a WebAssembly module
+// for running SubC programs in a browser.
+//
+// Build it with GOOS=js GOARCH=wasm, loading the module defines a
+// global subc object with the following function:
+//
+//	subc.compile(source, options) -> {asm, diagnostics}
+//
+// options is an optional object with the arch and os to compile
+// for and a files object mapping header names to their contents,
+// which is used to resolve #include directives.
+//
+// diagnostics is an array of the errors and warnings of the compile,
+// each an object with the severity ("error" or "warning"), the file,
+// line and column it is about, the message and an array of notes
+// with a file, line, column and message of their own.
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"text/scanner"
+
+	"subc/compile"
+	"subc/compile/arch"
+	"subc/compile/arch/amd64"
+	"subc/compile/arch/arm6"
+	"subc/compile/arch/darwinamd64"
+	"subc/compile/arch/i386"
+	"subc/parse"
+	"subc/scan"
+	"subc/types"
+	"subc/vfs"
+)
+
+// options are the options for compiling a program.
+type options struct {
+	Arch  string
+	OS    string
+	Files map[string][]byte
+}
+
+// result is the output of compiling a program.
+type result struct {
+	Asm         string
+	Diagnostics scan.Diagnostics
+}
+
+// source is the file name given to the program being compiled.
+const source = "main.c"
+
+// compileSource compiles src and returns the assembly generated along
+// with any errors and warnings, nothing touches the host file system.
+func compileSource(src string, opts options) result {
+	var res result
+	err := build(src, opts, &res)
+	res.Diagnostics = append(res.Diagnostics, scan.DiagnosticsOf(err)...)
+	return res
+}
+
+func build(src string, opts options, res *result) error {
+	fsys := vfs.NewMemFS(opts.Files)
+
+	buf := new(bytes.Buffer)
+	emitter, err := newArchEmitter(buf, opts.Arch, opts.OS)
+	if err != nil {
+		return err
+	}
+
+	scanConfig := scan.DefaultConfig
+	scanConfig.Loader = scan.FSLoader(fsys)
+	scanConfig.Macros = append(scanConfig.Macros[:len(scanConfig.Macros):len(scanConfig.Macros)],
+		[2]string{"__" + opts.OS + "__", ""})
+	scanConfig.Macros = append(scanConfig.Macros, emitter.TypeMacros()...)
+	reader := scan.StringReader(scanner.Position{
+		Filename: source,
+		Line:     1,
+		Column:   1,
+	}, src, false)
+	scanner := scan.New(scanConfig, source, reader)
+	defer scanner.Close()
+
+	prog, err := parse.Parse(parse.Config{Predecl: true}, scanner)
+	if err = frontEndError(err, res); err != nil {
+		return err
+	}
+
+	info, err := types.Check(types.Config{Sizes: emitter.Sizes, StdTypes: emitter.StdTypes}, prog)
+	if err = frontEndError(err, res); err != nil {
+		return err
+	}
+
+	emitter.Verbose = arch.Commented
+	var sched *arch.ScheduleSink
+	next := emitter.Out
+	if emitter.Scheduling != nil {
+		sched = arch.NewScheduleSink(next, emitter.Scheduling)
+		next = sched
+	}
+	branches := arch.NewBranchSink(next, emitter.Branches)
+	emitter.Out = branches
+	var fold *arch.FoldSink
+	if emitter.Folding != nil {
+		fold = arch.NewFoldSink(branches, emitter.Folding)
+		emitter.Out = fold
+	}
+	err = compile.Compile(context.Background(), compile.Config{Emitter: emitter, Common: true, Pool: true, Hoist: true, Reduce: true, Layout: true, Extend: true, Bools: true, Truncate: true, Index: true, Align: true}, prog, info)
+	if err != nil {
+		return err
+	}
+	if fold != nil {
+		fold.Flush()
+	}
+	branches.Flush()
+	if sched != nil {
+		sched.Flush()
+	}
+
+	res.Asm = buf.String()
+	return nil
+}
+
+// frontEndError returns err if it has errors in it,
+// warnings alone are kept as diagnostics.
+func frontEndError(err error, res *result) error {
+	l, _ := err.(*scan.ErrorList)
+	if l == nil || l.NumErrors > 0 {
+		return err
+	}
+
+	res.Diagnostics = append(res.Diagnostics, l.Diagnostics()...)
+	return nil
+}
+
+func newArchEmitter(buf *bytes.Buffer, arch_, os_ string) (*arch.Emitter, error) {
+	switch arch_ {
+	case "amd64":
+		if os_ == "darwin" {
+			return darwinamd64.NewEmitter(buf), nil
+		}
+		return amd64.NewEmitter(buf), nil
+	case "i386":
+		return i386.NewEmitter(buf), nil
+	case "arm6":
+		return arm6.NewEmitter(buf), nil
+	}
+	return nil, fmt.Errorf("unknown architecture %v", arch_)
+}
@@ -5,12 +5,16 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+
+	"subc/asm"
 )
 
 var flags struct {
-	Output string
-	Arch   string
-	OS     string
+	Output         string
+	Arch           string
+	OS             string
+	MaxSectionSize int64
+	Hash           bool
 }
 
 func init() {
@@ -21,8 +25,10 @@ func init() {
 	if theArch == "arm" {
 		theArch = "arm6"
 	}
-	flag.StringVar(&flags.Arch, "arch", theArch, "specify machine architecture to compile for [amd64]")
-	flag.StringVar(&flags.OS, "os", theOS, "specify os to compile for [linux]")
+	flag.StringVar(&flags.Arch, "arch", theArch, "specify machine architecture to compile for [amd64 | i386 | mips | arm64 | riscv64]")
+	flag.StringVar(&flags.OS, "os", theOS, "specify os to compile for [linux | freebsd | netbsd | openbsd | windows]")
+	flag.BoolVar(&flags.Hash, "hash", false, "print the hash of the content of the object that the linker sees, for the build systems that cache the objects")
+	flag.Int64Var(&flags.MaxSectionSize, "max-section-size", asm.DefaultMaxSectionSize, "largest size of a section in bytes")
 
 	flag.Usage = usage
 	flag.Parse()
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"flag"
 	"fmt"
+	"io/fs"
 	"io/ioutil"
 	"log"
 	"os"
@@ -10,10 +13,13 @@ import (
 	"strings"
 
 	"subc/asm"
+	"subc/obj"
+	"subc/vfs"
 )
 
 var (
 	status = 0
+	fsys   = vfs.OS()
 )
 
 func main() {
@@ -30,7 +36,7 @@ func main() {
 		src, err = ioutil.ReadAll(os.Stdin)
 	} else {
 		input = flag.Arg(0)
-		src, err = ioutil.ReadFile(input)
+		src, err = fs.ReadFile(fsys, input)
 	}
 	ck(err)
 
@@ -49,14 +55,25 @@ func main() {
 		}
 	}
 
-	fd, err := os.Create(output)
+	fd, err := fsys.Create(output)
 	ck(err)
 
-	err = asm.Assemble(flags.Arch, flags.OS, input, fd, src)
+	var object bytes.Buffer
+	conf := asm.Config{Arch: flags.Arch, OS: flags.OS, MaxSectionSize: flags.MaxSectionSize}
+	err = asm.Assemble(context.Background(), conf, input, &object, src)
 	if ek(err) {
-		os.Remove(output)
+		fsys.Remove(output)
+	} else {
+		_, err = fd.Write(object.Bytes())
+		ck(err)
 	}
 	ck(fd.Close())
+
+	if status == 0 && flags.Hash {
+		sum, err := obj.Hash(object.Bytes())
+		ck(err)
+		fmt.Printf("%s  %s\n", sum, output)
+	}
 	os.Exit(status)
 }
 
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// runtimeObjs returns the startup object and the library of the runtime
+// that are linked into the binaries. The ones installed in the runtime
+// directory by make are used when they are there, otherwise the runtime
+// is built from its sources under the root into the cache, where it is
+// kept for the builds that follow. The runtime installed has the int of
+// a word, so with -int-size the one of the size is always in the cache,
+// as the library stores the ints through the pointers it is passed.
+func runtimeObjs(ctx context.Context) (crt0, lib string, err error) {
+	installed := filepath.Join(flags.RuntimeDir, flags.Arch, flags.OS)
+	key := runtimeKey{installed, flags.CacheDir, flags.IntSize}
+	dir, found := runtimes[key]
+	if !found {
+		dir = installed
+		if flags.IntSize != 0 || !exists(filepath.Join(dir, "crt0.o")) || !exists(filepath.Join(dir, "libscc.a")) {
+			if dir, err = cachedRuntime(ctx); err != nil {
+				return "", "", err
+			}
+		}
+		runtimes[key] = dir
+	}
+	return filepath.Join(dir, "crt0.o"), filepath.Join(dir, "libscc.a"), nil
+}
+
+// runtimeKey is where a runtime is looked for and the bits of its int,
+// 0 for the ones of a word.
+type runtimeKey struct {
+	installed, cache string
+	intSize          int
+}
+
+// runtimes are the directories of the runtimes found, kept for the
+// jobs of the daemon that follow.
+var runtimes = make(map[runtimeKey]string)
+
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// cacheDir returns the directory that holds the runtime built for the
+// target and the size of the int, from the version of gosubc that builds
+// it. A devel version doesn't tell the builds apart, so the executable is
+// stamped on it.
+func cacheDir() (string, error) {
+	root := flags.CacheDir
+	if root == "" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return "", fmt.Errorf("no cache directory for the runtime: %v", err)
+		}
+		root = filepath.Join(dir, "gosubc")
+	}
+
+	key := version
+	if version == "devel" {
+		exe, err := os.Executable()
+		if err != nil {
+			return "", err
+		}
+		fi, err := os.Stat(exe)
+		if err != nil {
+			return "", err
+		}
+		key = fmt.Sprintf("devel-%x-%x", fi.ModTime().UnixNano(), fi.Size())
+	}
+	name := target()
+	if flags.IntSize != 0 {
+		name += fmt.Sprintf("-int%d", flags.IntSize)
+	}
+	return filepath.Join(root, key, name), nil
+}
+
+// cachedRuntime returns the directory of the runtime in the cache,
+// building it first if it isn't there. The runtime is built next to
+// it and renamed into place, so a build running at the same time
+// never sees a runtime that is only built in part.
+func cachedRuntime(ctx context.Context) (string, error) {
+	dir, err := cacheDir()
+	if err != nil {
+		return "", err
+	}
+	if exists(filepath.Join(dir, "crt0.o")) && exists(filepath.Join(dir, "libscc.a")) {
+		return dir, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return "", err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), "build-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := buildRuntime(ctx, tmp); err != nil {
+		return "", fmt.Errorf("building the runtime for %s: %v", target(), err)
+	}
+	if err := os.Rename(tmp, dir); err != nil && !exists(filepath.Join(dir, "libscc.a")) {
+		return "", err
+	}
+	return dir, nil
+}
+
+// runtimeMachines are the names of the targets in the sources of the runtime.
+var runtimeMachines = map[string]string{
+	"amd64": "x86-64",
+	"i386":  "386",
+	"arm6":  "armv6",
+	"8086":  "8086",
+	"mips":  "mips",
+}
+
+// buildRuntime builds the runtime into dir like make does: the library
+// is compiled by this compiler with the default options for the target
+// and the size of the int, and crt0 is assembled by the assembler. The
+// targets that SubC has no startup code in the syntax of the assembler
+// for have it in the runtime directory, with the other assembly of the
+// library.
+func buildRuntime(ctx context.Context, dir string) error {
+	src := filepath.Join(flags.RootDir, "subc", "src")
+	var asmFiles []string
+	crt0 := filepath.Join(flags.RuntimeDir, flags.Arch, flags.OS, "crt0.s")
+	if exists(crt0) {
+		names, err := filepath.Glob(filepath.Join(filepath.Dir(crt0), "*.s"))
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if name != crt0 {
+				asmFiles = append(asmFiles, name)
+			}
+		}
+	} else {
+		system := filepath.Join(src, "targets", flags.OS+"-"+runtimeMachines[flags.Arch])
+		crt0 = filepath.Join(system, "crt0-"+filepath.Base(system)+".s")
+		if !exists(crt0) {
+			return fmt.Errorf("no startup code %s", crt0)
+		}
+	}
+
+	// init.c and system.c are links made by configure for the host,
+	// the ones of the target are taken instead
+	initHost, systemHost := "unix", "unix"
+	switch flags.OS {
+	case "windows":
+		initHost, systemHost = "windows", "windows"
+	case "dos":
+		systemHost = "dos"
+	}
+	sources, err := filepath.Glob(filepath.Join(src, "lib", "*.c"))
+	if err != nil {
+		return err
+	}
+	var files []string
+	for _, name := range sources {
+		switch filepath.Base(name) {
+		case "init.c", "system.c":
+		default:
+			files = append(files, name)
+		}
+	}
+	files = append(files,
+		filepath.Join(src, "targets", "lib", "init-"+initHost+".c"),
+		filepath.Join(src, "targets", "lib", "system-"+systemHost+".c"))
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	objs := filepath.Join(dir, "obj")
+	args := []string{exe, "-c", "-T", objs, "-arch", flags.Arch, "-os", flags.OS, "-root", flags.RootDir}
+	if flags.IntSize != 0 {
+		args = append(args, "-int-size", strconv.Itoa(flags.IntSize))
+	}
+	if err := run(ctx, append(args, files...)); err != nil {
+		return err
+	}
+	for _, name := range asmFiles {
+		obj := filepath.Join(objs, strings.TrimSuffix(filepath.Base(name), ".s")+".o")
+		args := append(getCmdArgs("AS", "as"), "-o", obj, name)
+		if err := run(ctx, args); err != nil {
+			return err
+		}
+	}
+
+	objFiles, err := filepath.Glob(filepath.Join(objs, "*.o"))
+	if err != nil {
+		return err
+	}
+	args = append(getCmdArgs("AR", "ar"), "-rc", filepath.Join(dir, "libscc.a"))
+	if err := run(ctx, append(args, objFiles...)); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(objs); err != nil {
+		return err
+	}
+
+	args = append(getCmdArgs("AS", "as"), "-o", filepath.Join(dir, "crt0.o"), crt0)
+	return run(ctx, args)
+}
+
+// run runs a command of the build of the runtime, its output is only
+// shown if it fails, the warnings on the runtime aren't the user's.
+func run(ctx context.Context, args []string) error {
+	echo(args)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		os.Stderr.Write(out)
+		return fmt.Errorf("%s: %v", args[0], err)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strings"
+
+	"subc/scan"
+)
+
+// phase is the phase that the compilation of an input is in,
+// for the report of a crash of the compiler.
+var phase string
+
+// guard runs f for input, a panic of the compiler is reported as an
+// internal compiler error and a reproducer of it written to a file.
+func guard(input string, f func() error) (err error) {
+	defer func() {
+		e := recover()
+		if e == nil {
+			return
+		}
+		stack := debug.Stack()
+		if p, ok := e.(*scan.Panic); ok {
+			phase, e, stack = "preprocess", p.Value, p.Stack
+		}
+		err = reportCrash(input, e, stack)
+	}()
+	phase = "preprocess"
+	return f()
+}
+
+// reportCrash prints the stack of a crash with where the reproducer of it
+// was written, and returns the error that the compilation fails with.
+func reportCrash(input string, e interface{}, stack []byte) error {
+	fmt.Fprintf(os.Stderr, "%s", stack)
+	name, err := writeReproducer(input, e, stack)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "could not write a reproducer:", err)
+	} else {
+		fmt.Fprintln(os.Stderr, "a reproducer of the crash was written to", name, "please attach it to the bug report")
+	}
+	return fmt.Errorf("%s: internal compiler error in the %s phase: %v", input, phase, e)
+}
+
+// writeReproducer writes the preprocessed source of input to a temporary
+// file, with the options and the phase that the compiler crashed with
+// in a comment. The source is written as it is if preprocessing it
+// crashes again.
+func writeReproducer(input string, e interface{}, stack []byte) (string, error) {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "/*\n")
+	fmt.Fprintf(buf, " * internal compiler error in the %s phase: %v\n", phase, e)
+	fmt.Fprintf(buf, " *\n")
+	fmt.Fprintf(buf, " * command: %s\n", strings.Join(os.Args, " "))
+	fmt.Fprintf(buf, " * arch: %s, os: %s\n", flags.Arch, flags.OS)
+	for _, env := range []string{"SCCROOT", "SCCINC", "CPP"} {
+		if v := os.Getenv(env); v != "" {
+			fmt.Fprintf(buf, " * %s=%s\n", env, v)
+		}
+	}
+	fmt.Fprintf(buf, " *\n")
+	for _, line := range strings.Split(strings.TrimSpace(string(stack)), "\n") {
+		fmt.Fprintf(buf, " * %s\n", strings.ReplaceAll(line, "*/", "* /"))
+	}
+	fmt.Fprintf(buf, " */\n")
+
+	if err := preprocessed(buf, input); err != nil {
+		src, err := readSource(input)
+		if err != nil {
+			return "", err
+		}
+		buf.Write(src)
+	}
+
+	fd, err := os.CreateTemp(flags.TempDir, "scc-crash-*.c")
+	if err != nil {
+		return "", err
+	}
+	_, err = fd.Write(buf.Bytes())
+	if xerr := fd.Close(); err == nil {
+		err = xerr
+	}
+	return fd.Name(), err
+}
+
+// preprocessed appends the preprocessed source of input to buf, leaving
+// buf as it was if the preprocessor fails.
+func preprocessed(buf *bytes.Buffer, input string) (err error) {
+	n := buf.Len()
+	defer func() {
+		if e := recover(); e != nil {
+			buf.Truncate(n)
+			err = fmt.Errorf("%v", e)
+		}
+	}()
+
+	scanner, err := newScanner(context.Background(), input, nil)
+	if err != nil {
+		return err
+	}
+	defer scanner.Close()
+
+	writeCpp(buf, scanner.Tokens)
+
+	// the tokens end early if the scanner panicked,
+	// scanning again raises the panic
+	scanner.Scan()
+	return nil
+}
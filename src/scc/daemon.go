@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"subc/scan"
+	"subc/vfs"
+)
+
+// job is a compile sent to the daemon, it is run as if scc was run
+// with the arguments in the directory and the environment of the client.
+type job struct {
+	Dir  string
+	Args []string
+	Env  []string
+}
+
+// reply is a message of the daemon to the client of a job, the output
+// of the job as it is written and at the end the exit status of it.
+type reply struct {
+	Stdout []byte `json:",omitempty"`
+	Stderr []byte `json:",omitempty"`
+	Status *int   `json:",omitempty"`
+}
+
+// jobs serializes the jobs of the daemon, the flags, the working
+// directory and the environment of the compiler are those of one job.
+var jobs sync.Mutex
+
+// serve runs the daemon on the unix socket addr until ctx is done or
+// it is terminated. The contents of the files read are kept in memory,
+// so are the runtimes linked, and the jobs don't pay for starting the
+// compiler. Each job still preprocesses and parses the headers again.
+func serve(ctx context.Context, addr string) int {
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGTERM)
+	defer stop()
+
+	if conn, err := net.Dial("unix", addr); err == nil {
+		conn.Close()
+		fmt.Fprintf(os.Stderr, "a daemon is already serving on %s\n", addr)
+		return 1
+	}
+	// the socket of a daemon that is gone
+	os.Remove(addr)
+
+	l, err := net.Listen("unix", addr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer l.Close()
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	fsys = vfs.Cached(fsys)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return 0
+			}
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		go handle(ctx, conn)
+	}
+}
+
+// handle runs the job sent on conn, it is canceled if the client goes away.
+func handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	var j job
+	if err := json.NewDecoder(conn).Decode(&j); err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		io.Copy(io.Discard, conn)
+		cancel()
+	}()
+
+	var mu sync.Mutex
+	enc := json.NewEncoder(conn)
+	send := func(r reply) {
+		mu.Lock()
+		defer mu.Unlock()
+		enc.Encode(r)
+	}
+
+	jobs.Lock()
+	status := runJob(ctx, j, send)
+	jobs.Unlock()
+	send(reply{Status: &status})
+}
+
+// exitJob is the panic of a job that exits.
+type exitJob int
+
+// runJob runs the job j, the output of it is sent as it is written.
+func runJob(ctx context.Context, j job, send func(reply)) (status int) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return 1
+	}
+	env := os.Environ()
+
+	// the output goes to the client, the commands that the job
+	// runs write to the pipes too
+	outr, outw, err := os.Pipe()
+	if err != nil {
+		return 1
+	}
+	errr, errw, err := os.Pipe()
+	if err != nil {
+		outr.Close()
+		outw.Close()
+		return 1
+	}
+	var wg sync.WaitGroup
+	forward := func(r *os.File, out func([]byte) reply) {
+		defer wg.Done()
+		defer r.Close()
+		buf := make([]byte, 4096)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				send(out(append([]byte(nil), buf[:n]...)))
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+	wg.Add(2)
+	go forward(outr, func(b []byte) reply { return reply{Stdout: b} })
+	go forward(errr, func(b []byte) reply { return reply{Stderr: b} })
+	stdout, stderr, sink := os.Stdout, os.Stderr, diag
+	os.Stdout, os.Stderr = outw, errw
+	diag = scan.NewWriterSink(errw)
+
+	defer func() {
+		if e := recover(); e != nil {
+			code, ok := e.(exitJob)
+			if !ok {
+				fmt.Fprintln(os.Stderr, "internal compiler error:", e)
+				code = 1
+			}
+			status = int(code)
+		}
+		outw.Close()
+		errw.Close()
+		wg.Wait()
+		os.Stdout, os.Stderr, diag = stdout, stderr, sink
+		exit = os.Exit
+		os.Clearenv()
+		setenv(env)
+		os.Chdir(wd)
+	}()
+
+	if err := os.Chdir(j.Dir); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	os.Clearenv()
+	setenv(j.Env)
+
+	exit = func(code int) { panic(exitJob(code)) }
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	parseFlags(j.Args)
+	globals = newGlobalSet()
+	callGraph, includes, summaries, diagnostics = nil, nil, nil, nil
+	return build(ctx)
+}
+
+// setenv sets the variables of env, given as key=value.
+func setenv(env []string) {
+	for _, kv := range env {
+		if i := strings.IndexByte(kv, '='); i > 0 {
+			os.Setenv(kv[:i], kv[i+1:])
+		}
+	}
+}
+
+// connect sends the compile to the daemon on the unix socket addr and
+// returns its exit status, the compile is run here if there is no
+// daemon to send it to.
+func connect(ctx context.Context, addr string) int {
+	// the daemon doesn't get the stdin of the client
+	if readsStdin() {
+		return build(ctx)
+	}
+	conn, err := net.Dial("unix", addr)
+	if err != nil {
+		echo([]string{"no daemon on", addr})
+		return build(ctx)
+	}
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	dir, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := json.NewEncoder(conn).Encode(job{Dir: dir, Args: os.Args[1:], Env: os.Environ()}); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	dec := json.NewDecoder(conn)
+	for {
+		var r reply
+		if err := dec.Decode(&r); err != nil {
+			fmt.Fprintf(os.Stderr, "the daemon on %s went away: %v\n", addr, err)
+			return 1
+		}
+		os.Stdout.Write(r.Stdout)
+		os.Stderr.Write(r.Stderr)
+		if r.Status != nil {
+			return *r.Status
+		}
+	}
+}
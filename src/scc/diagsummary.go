@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+
+	"subc/scan"
+)
+
+// diagSummary counts the errors and the warnings of all the inputs by
+// their kind for -fdiagnostics-summary, to see what stands between a
+// program written for another compiler and a clean compile before going
+// through the diagnostics one by one.
+type diagSummary struct {
+	counts map[diagGroup]int
+	files  map[diagGroup]map[string]bool // the files that have diagnostics of the group
+	capped bool                          // an input stopped at the -errors limit, its other errors aren't counted
+}
+
+// diagGroup is the diagnostics of a kind and severity.
+type diagGroup struct {
+	severity scan.Severity
+	kind     string
+}
+
+// diagKind is a kind of diagnostic, by the messages it is reported with,
+// and what to do about it.
+type diagKind struct {
+	message *regexp.Regexp
+	name    string
+	hint    string
+}
+
+// diagKinds are the kinds of the diagnostics that are summed up, the
+// messages of the others are counted with their quoted text left out.
+var diagKinds = []diagKind{
+	{regexp.MustCompile(`^implicit declaration of function `), "implicit declarations of functions", "declare them or include their headers, -compat allows them and -strict makes them errors"},
+	{regexp.MustCompile(`defaults to int in declaration of `), "implicit ints", "give the declarations a type, -compat allows them and -strict makes them errors"},
+	{regexp.MustCompile(`^undeclared name: `), "undeclared names", "declare them or include their headers"},
+	{regexp.MustCompile(`is a GNU extension$|are a GNU extension$`), "GNU extensions", "-fgnu-extensions allows them, -ansi makes them errors"},
+	{regexp.MustCompile(`^(trigraph|digraph) `), "trigraphs and digraphs", "-trigraphs replaces them"},
+	{regexp.MustCompile(`^unsigned suffix on integer constant `), "unsigned suffixes", "there are no unsigned types, the constants are signed"},
+	{regexp.MustCompile(`^octal constant `), "octal constants with digits 8 or 9", "write them in decimal"},
+	{regexp.MustCompile(`^shift count `), "shift counts out of range", "the results of the shifts are undefined"},
+	{regexp.MustCompile(`^unknown attribute |attribute of local variable |^attributes of declarations other than`), "unknown or misplaced attributes", "they are ignored"},
+	{regexp.MustCompile(`^malformed #pragma `), "malformed pragmas", "they are ignored"},
+	{regexp.MustCompile(`^long double `), "long doubles", "they are treated as double"},
+	{regexp.MustCompile(`^frame of .* bytes, more than `), "frames larger than -max-frame", "-max-frame sets the limit"},
+	{regexp.MustCompile(`^#warning: `), "#warning directives", ""},
+}
+
+// quoted matches the quoted text of a message, the tokens and the names
+// that the messages of a kind differ by.
+var quoted = regexp.MustCompile(`(["'])[^"']*["']`)
+
+// diagnostics collects the diagnostics of the inputs for
+// -fdiagnostics-summary.
+var diagnostics *diagSummary
+
+func newDiagSummary() *diagSummary {
+	return &diagSummary{counts: make(map[diagGroup]int), files: make(map[diagGroup]map[string]bool)}
+}
+
+// add counts the diagnostics of the list l.
+func (s *diagSummary) add(l *scan.ErrorList) {
+	for _, d := range l.Diagnostics() {
+		if d.Message == "too many errors" {
+			s.capped = true
+			continue
+		}
+		g := diagGroup{d.Severity, quoted.ReplaceAllString(d.Message, `$1...$1`)}
+		for _, k := range diagKinds {
+			if k.message.MatchString(d.Message) {
+				g.kind = k.name
+				break
+			}
+		}
+		s.counts[g]++
+		if s.files[g] == nil {
+			s.files[g] = make(map[string]bool)
+		}
+		if d.Pos.IsValid() {
+			s.files[g][d.Pos.Filename] = true
+		}
+	}
+}
+
+// write writes the summary to w, the errors and then the warnings, the
+// kinds with the most diagnostics first.
+func (s *diagSummary) write(w io.Writer) {
+	if len(s.counts) == 0 {
+		return
+	}
+	groups := make([]diagGroup, 0, len(s.counts))
+	for g := range s.counts {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		x, y := groups[i], groups[j]
+		switch {
+		case x.severity != y.severity:
+			return x.severity < y.severity
+		case s.counts[x] != s.counts[y]:
+			return s.counts[x] > s.counts[y]
+		}
+		return x.kind < y.kind
+	})
+
+	fmt.Fprintln(w, "diagnostics summary:")
+	for _, g := range groups {
+		n := s.counts[g]
+		severity := g.severity.String()
+		if n > 1 {
+			severity += "s"
+		}
+		fmt.Fprintf(w, "\t%d %s, %s", n, severity, g.kind)
+		if files := len(s.files[g]); files > 0 {
+			fmt.Fprintf(w, ", in %d %s", files, plural(files, "file", "files"))
+		}
+		if hint := kindHint(g.kind); hint != "" {
+			fmt.Fprintf(w, ": %s", hint)
+		}
+		fmt.Fprintln(w)
+	}
+	if s.capped {
+		fmt.Fprintf(w, "\tthe inputs stopped after %d errors are counted up to there, -errors 0 counts all of them\n", flags.MaxErrors)
+	}
+}
+
+// kindHint returns the hint of the kind of diagnostic named name, empty
+// if there is none.
+func kindHint(name string) string {
+	for _, k := range diagKinds {
+		if k.name == name {
+			return k.hint
+		}
+	}
+	return ""
+}
+
+// plural returns one if n is 1, else many.
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
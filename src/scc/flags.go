@@ -6,7 +6,10 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+
+	"subc/compile"
 )
 
 type MultiFlag []string
@@ -20,46 +23,198 @@ func (m *MultiFlag) Set(s string) error {
 	return nil
 }
 
+// IRFlag is the -fdump-ir flag, given alone it dumps the trees around
+// all of the passes of compile.Passes and given one of them as
+// -fdump-ir=pass only around it.
+type IRFlag struct {
+	On   bool
+	Pass string
+}
+
+func (f *IRFlag) String() string {
+	if f.Pass != "" {
+		return f.Pass
+	}
+	return fmt.Sprint(f.On)
+}
+
+func (f *IRFlag) Set(s string) error {
+	switch s {
+	case "true":
+		*f = IRFlag{On: true}
+		return nil
+	case "false":
+		*f = IRFlag{}
+		return nil
+	}
+	for _, pass := range compile.Passes() {
+		if s == pass {
+			*f = IRFlag{On: true, Pass: s}
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown pass %q, the passes are %s", s, strings.Join(compile.Passes(), ", "))
+}
+
+func (f *IRFlag) IsBoolFlag() bool {
+	return true
+}
+
+// SanitizeFlag is the -fsanitize flag, a comma separated list of the
+// checks that the code is instrumented with at run time.
+type SanitizeFlag struct {
+	Null bool // the pointers are checked against null before they are dereferenced
+}
+
+// sanitizers are the checks that -fsanitize takes.
+var sanitizers = []string{"null"}
+
+func (f *SanitizeFlag) String() string {
+	var l []string
+	if f.Null {
+		l = append(l, "null")
+	}
+	return strings.Join(l, ",")
+}
+
+func (f *SanitizeFlag) Set(s string) error {
+	for _, name := range strings.Split(s, ",") {
+		switch name {
+		case "null":
+			f.Null = true
+		default:
+			return fmt.Errorf("unknown sanitizer %q, the sanitizers are %s", name, strings.Join(sanitizers, ", "))
+		}
+	}
+	return nil
+}
+
+// VerboseFlag is the -asm-verbose flag, the level of the comments in the
+// asm. Left out, the level depends on -S and -compat.
+type VerboseFlag struct {
+	Level int
+	Given bool
+}
+
+func (f *VerboseFlag) String() string {
+	if !f.Given {
+		return ""
+	}
+	return strconv.Itoa(f.Level)
+}
+
+func (f *VerboseFlag) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > 2 {
+		return fmt.Errorf("the levels are 0, 1 and 2")
+	}
+	*f = VerboseFlag{Level: n, Given: true}
+	return nil
+}
+
 var flags struct {
-	Includes       MultiFlag
-	Defines        MultiFlag
-	UseCpp         bool
-	CompileOnly    bool
-	PrintAsm       bool
-	RemoveOnFinish bool
-	NoWarnings     bool
-	CpuProfile     string
-	MemProfile     string
-	Output         string
-	TempDir        string
-	MaxErrors      int
+	Includes           MultiFlag
+	Defines            MultiFlag
+	UseCpp             bool
+	Trigraphs          bool
+	CompileOnly        bool
+	PrintAsm           bool
+	Annotate           bool
+	StackReport        bool
+	FrameReport        bool
+	Instrument         bool
+	Summary            bool
+	WholeProgram       bool
+	DiagnosticsSummary bool
+	MaxFrame           int
+	Direct             bool
+	RemoveOnFinish     bool
+	NoWarnings         bool
+	Strict             bool
+	Ansi               bool
+	GNUExtensions      bool
+	CpuProfile         string
+	MemProfile         string
+	Output             string
+	Lang               string
+	HTML               string
+	TempDir            string
+	MaxErrors          int
+	AsmVerbose         VerboseFlag
+	Opt                int
+	IntSize            int
+	ImageBase          string
+	LabelPrefix        string
+	MaxImageSize       string
 
 	Arch       string
 	OS         string
 	RootDir    string
 	RuntimeDir string
+	CacheDir   string
+
+	DumpCpp      bool
+	DumpMacros   bool
+	DumpIncludes string
+	DumpTokens   bool
+	DumpAST      bool
+	DumpTypes    bool
+	DumpIR       IRFlag
 
-	DumpCpp    bool
-	DumpTokens bool
-	DumpAST    bool
-	DumpTypes  bool
+	Sanitize SanitizeFlag
 
 	Compat bool
+
+	Version bool
+	Verbose bool
+
+	Daemon  string
+	Connect string
 }
 
+// exit exits with status, a job of the daemon replaces it so the
+// daemon goes on.
+var exit = os.Exit
+
 func init() {
+	parseFlags(os.Args[1:])
+}
+
+// parseFlags sets the flags from the arguments args. All of the flags
+// are set again, so a job of the daemon doesn't see the ones of the
+// job before it.
+func parseFlags(args []string) {
+	flags.Includes, flags.Defines = nil, nil
+	flags.DumpIR, flags.Sanitize = IRFlag{}, SanitizeFlag{}
 	flag.Var(&flags.Includes, "I", "include paths, also settable via SCCINC environment variable")
 	flag.Var(&flags.Defines, "D", "define a macro of the form macro=expansion")
 	flag.BoolVar(&flags.UseCpp, "cpp", false, "use external C preprocessor, settable via CPP environment variable")
+	flag.BoolVar(&flags.Trigraphs, "trigraphs", false, "replace trigraphs and digraphs instead of warning about them")
 	flag.BoolVar(&flags.CompileOnly, "c", false, "compile only")
 	flag.BoolVar(&flags.PrintAsm, "S", false, "print asm only")
+	flag.BoolVar(&flags.StackReport, "stack-report", false, "report the stack usage and call graph of the functions")
+	flag.BoolVar(&flags.FrameReport, "frame-report", false, "report the layout of the frame of each function: the parameters, the locals and the spill area")
+	flag.BoolVar(&flags.Annotate, "annotate", false, "annotate the asm with the source lines it was generated from")
+	flag.BoolVar(&flags.Direct, "direct", false, "emit object files with the builtin assembler instead of writing the assembly for as (amd64 and i386 on linux and the BSDs, and mips linux only)")
 	flag.BoolVar(&flags.RemoveOnFinish, "R", true, "remove generated files on finish")
 	flag.BoolVar(&flags.NoWarnings, "no-warnings", false, "treat warnings as errors")
-	flag.StringVar(&flags.Output, "o", "", "output file (for one file input only)")
+	flag.BoolVar(&flags.Strict, "strict", false, "treat implicit int and implicit function declarations as errors")
+	flag.BoolVar(&flags.Ansi, "ansi", false, "treat the GNU extensions as errors, as -fgnu-extensions=false does")
+	flag.BoolVar(&flags.GNUExtensions, "fgnu-extensions", true, "allow the GNU extensions: binary constants, case ranges, statement expressions, and &&label and goto *")
+	flag.StringVar(&flags.Output, "o", "", "output file (for one file input only), - for the assembly of -S on stdout")
+	flag.StringVar(&flags.Lang, "x", "c", "language of the inputs, c is the only one")
+	flag.StringVar(&flags.HTML, "html", "", "write a html page interleaving the source with the asm to file (for one file input only)")
 	flag.StringVar(&flags.TempDir, "T", "", "temporary directory to use for work")
 	flag.StringVar(&flags.CpuProfile, "cpuprofile", "", "generate cpu profiling output to file")
 	flag.StringVar(&flags.MemProfile, "memprofile", "", "generate memory profiling output to file")
 	flag.IntVar(&flags.MaxErrors, "errors", 10, "max errors (use a number <= 0 for all the errors) ")
+	flag.StringVar(&flags.LabelPrefix, "label-prefix", "L", "prefix of the labels the compiler makes up: L as SubC does, or .L to keep them out of the symbol table of the ELF objects")
+	flag.StringVar(&flags.ImageBase, "image-base", "", "address to link the image at, aligned to a page unless the image is flat (default the one of the linker, or where the 8086 loads the image)")
+	flag.StringVar(&flags.MaxImageSize, "max-image-size", "", "fail the link if the image takes more addresses than this, sizes can end in K, M or G")
+	flag.IntVar(&flags.MaxFrame, "max-frame", 0, "warn about the functions whose frames take more than this many bytes (default no limit)")
+	flag.IntVar(&flags.IntSize, "int-size", 0, "bits in an int, 16 or 32 on amd64 and 16 on i386, long gets twice as many up to 64 (default the bits of a word, not with -direct)")
+	flag.IntVar(&flags.Opt, "O", 1, "optimization level, 2 also aligns the function entries and the innermost loops to 16 bytes and copies and fills the arrays of loops in line (ignored with -compat)")
+	flag.Var(&flags.AsmVerbose, "asm-verbose", "the `level` of the comments in the asm: 0 for none, 1 to explain the code, 2 to also trace the code synthesizer, by default 1 with -S unless -compat is used and 0 otherwise")
 
 	theArch := runtime.GOARCH
 	theOS := runtime.GOOS
@@ -74,19 +229,43 @@ func init() {
 		}
 	}
 
-	flag.StringVar(&flags.Arch, "arch", theArch, "specify machine architecture to compile for [amd64 | i386 | arm6]")
-	flag.StringVar(&flags.OS, "os", theOS, "specify os to compile for [linux | windows | darwin]")
+	flag.StringVar(&flags.Arch, "arch", theArch, "specify machine architecture to compile for [amd64 | i386 | arm6 | 8086 | mips]")
+	flag.StringVar(&flags.OS, "os", theOS, "specify os to compile for [linux | freebsd | netbsd | openbsd | windows | darwin], or for the 8086 [dos | boot] for a .com program or a boot sector")
 	flag.StringVar(&flags.RootDir, "root", rootdir, "specify the root directory, also settable via SCCROOT environment variable")
+	flag.StringVar(&flags.CacheDir, "cache", os.Getenv("SCCCACHE"), "directory that keeps the runtime built when it isn't installed, also settable via SCCCACHE environment variable (default gosubc in the user cache directory)")
 
 	flag.BoolVar(&flags.DumpCpp, "dump-cpp", false, "dump preprocessor text for debugging")
+	flag.BoolVar(&flags.DumpMacros, "dump-macros", false, "dump macro definitions and expansions for debugging")
+	flag.StringVar(&flags.DumpIncludes, "dump-includes", "", "dump the include graph of the inputs in the format [dot | json]")
 	flag.BoolVar(&flags.DumpTokens, "dump-tokens", false, "dump lexical tokens for debugging")
 	flag.BoolVar(&flags.DumpAST, "dump-ast", false, "dump ast tree for debugging")
 	flag.BoolVar(&flags.DumpTypes, "dump-types", false, "dump types for debugging")
+	flag.Var(&flags.DumpIR, "fdump-ir", "dump the expression trees to stderr before and after the passes that optimize them, fold, reorder and bools, or only around one with -fdump-ir=[fold | reorder | bools], the loop optimizations are planned before the trees are made and not dumped")
+	flag.BoolVar(&flags.Instrument, "finstrument-functions", false, "call __cyg_profile_func_enter and __cyg_profile_func_exit with the address of the function and of its call site when the functions are entered and return")
+	flag.BoolVar(&flags.WholeProgram, "fwhole-program", false, "parse all of the inputs before compiling them and leave the functions and the variables that main can't reach out of the objects, or the ones the public globals can't reach with -c or -S")
+	flag.BoolVar(&flags.Summary, "fsummary", false, "print a line of JSON for each input once its object is written: the sections and their sizes, the symbols defined and undefined, and the functions and the bytes of their code")
+	flag.BoolVar(&flags.DiagnosticsSummary, "fdiagnostics-summary", false, "print the errors and warnings of all the inputs at the end, counted by their kind with what to do about them or the flag that changes them")
+	flag.Var(&flags.Sanitize, "fsanitize", "instrument the code with the run time checks of a comma separated list: null checks the pointers before they are dereferenced")
 	flag.BoolVar(&flags.Compat, "compat", false, "compatibility mode with the scc compiler")
+	flag.BoolVar(&flags.Version, "version", false, "print the version and the target and exit")
+	flag.BoolVar(&flags.Verbose, "v", false, "print the version and the configuration, and the commands that are run")
+	flag.StringVar(&flags.Daemon, "daemon", "", "serve the compiles sent with -connect on the local socket, keeping the contents of the headers and the runtime in memory between them")
+	flag.StringVar(&flags.Connect, "connect", os.Getenv("SCCDAEMON"), "send the compile to the daemon on the local socket, or compile here if there is none, also settable via SCCDAEMON environment variable")
 
-	flag.Usage = usage
-	flag.Parse()
-	if flag.NArg() == 0 {
+	flag.CommandLine.Usage = usage
+	// the inputs can come between the flags, - is the input read from
+	// stdin rather than the end of the flags
+	var inputs []string
+	for {
+		flag.CommandLine.Parse(args)
+		if flag.NArg() == 0 {
+			break
+		}
+		inputs = append(inputs, flag.Arg(0))
+		args = flag.Args()[1:]
+	}
+	flag.CommandLine.Parse(append([]string{"--"}, inputs...))
+	if flag.NArg() == 0 && !flags.Version && !flags.Verbose && flags.Daemon == "" {
 		usage()
 	}
 
@@ -94,10 +273,14 @@ func init() {
 		flags.MaxErrors = 0
 	}
 
-	if flags.Output == "" && !flags.CompileOnly {
+	if flags.Output == "" && !flags.CompileOnly && !flags.PrintAsm {
 		flags.Output = "a.out"
 	}
 
+	if !flags.AsmVerbose.Given && flags.PrintAsm && !flags.Compat {
+		flags.AsmVerbose.Level = 1
+	}
+
 	flags.RuntimeDir = filepath.Join(flags.RootDir, "runtime")
 	flags.Includes = append(flags.Includes, filepath.Join(flags.RuntimeDir, "include"))
 	flags.Includes = append(flags.Includes, filepath.Join(flags.RuntimeDir, flags.Arch, "include"))
@@ -108,14 +291,22 @@ func init() {
 			flags.Includes = append(flags.Includes, include)
 		}
 	}
+
+	if flags.Version || (flag.NArg() == 0 && flags.Daemon == "") {
+		printVersion(os.Stdout)
+		exit(0)
+	}
+	if flags.Verbose {
+		printVersion(os.Stderr)
+	}
 }
 
 func usage() {
-	fmt.Fprintln(os.Stderr, "usage:", os.Args[0], "[options] file ...")
+	fmt.Fprintln(os.Stderr, "usage:", os.Args[0], "[options] file ... (- for stdin)")
 	flag.PrintDefaults()
-	os.Exit(0)
+	exit(0)
 }
 
 func dumping() bool {
-	return flags.DumpCpp || flags.DumpTokens || flags.DumpAST || flags.DumpTypes
+	return flags.DumpCpp || flags.DumpMacros || flags.DumpIncludes != "" || flags.DumpTokens || flags.DumpAST || flags.DumpTypes
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+)
+
+// The 8086 targets have no format of executable, a program is a flat
+// image of its bytes that is loaded at a fixed address with the code of
+// crt0 first. The image is linked to an ELF executable at that address,
+// and objcopy copies the bytes of its sections out of it.
+
+// flatTarget describes the image of a target that runs flat images.
+type flatTarget struct {
+	base uint64 // address the image is loaded at
+	boot bool   // the image is a boot sector, which ends with the boot signature
+}
+
+// flatTargets are the targets that run flat images, by arch/os.
+var flatTargets = map[string]flatTarget{
+	"8086/dos":  {base: 0x100},              // a .com program, after the PSP
+	"8086/boot": {base: 0x7c00, boot: true}, // a boot sector that the BIOS loads
+}
+
+// flatImage returns the image of the target if it runs flat images.
+func flatImage() (flatTarget, bool) {
+	t, ok := flatTargets[flags.Arch+"/"+flags.OS]
+	return t, ok
+}
+
+// segmentSize is the memory that the tiny model addresses, the image
+// and its bss have to end in it.
+const segmentSize = 0x10000
+
+// bootSize is the size of a boot sector, the last two bytes of it are
+// the signature that the BIOS looks for.
+const bootSize = 512
+
+// copyFlat copies the flat image of the executable exe to output, a boot
+// sector is padded to its size and signed, failing if it doesn't fit.
+func copyFlat(ctx context.Context, flat flatTarget, exe, output string) error {
+	args := getCmdArgs("OBJCOPY", "objcopy")
+	args = append(args, "-O", "binary", exe, output)
+	echo(args)
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+	cmd.Stdout = os.Stdout
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+	if !flat.boot {
+		return nil
+	}
+
+	image, err := ioutil.ReadFile(output)
+	if err == nil && len(image) > bootSize-2 {
+		err = fmt.Errorf("%s: image of %d bytes doesn't fit in the %d bytes of a boot sector before the signature", output, len(image), bootSize-2)
+	}
+	if err == nil {
+		sector := make([]byte, bootSize)
+		copy(sector, image)
+		sector[bootSize-2], sector[bootSize-1] = 0x55, 0xaa
+		err = ioutil.WriteFile(output, sector, 0666)
+	}
+	if err != nil {
+		os.Remove(output)
+	}
+	return err
+}
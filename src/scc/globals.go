@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"text/scanner"
+
+	"subc/ast"
+	"subc/scan"
+	"subc/types"
+)
+
+// globalDecl is how a global symbol is declared in one input.
+type globalDecl struct {
+	obj     types.Object
+	pos     scanner.Position
+	defined bool // it has an initializer or a function body
+}
+
+// globalSet tracks the global symbols declared by each input, so
+// declarations that conflict between the inputs of a multi-file
+// compile are diagnosed before the linker sees them.
+type globalSet struct {
+	decls map[string]*globalDecl
+}
+
+// globals collects the global symbols of all the inputs.
+var globals = newGlobalSet()
+
+func newGlobalSet() *globalSet {
+	return &globalSet{decls: make(map[string]*globalDecl)}
+}
+
+// add adds the public symbols of one input, returning an error
+// if they conflict with the symbols of the inputs added before.
+func (g *globalSet) add(prog *ast.Prog, info *types.Info) error {
+	// merge all the declarations in the input first, the
+	// type checker has made sure they agree with each other
+	file := make(map[string]*globalDecl)
+	var names []string
+	for _, d := range prog.Decls {
+		var (
+			id      *ast.Ident
+			defined bool
+		)
+		switch d := d.(type) {
+		case *ast.VarDecl:
+			id, defined = d.Name, d.Value != nil
+		case *ast.FuncDecl:
+			id, defined = d.Name, d.Body != nil
+		default:
+			continue
+		}
+
+		obj := info.Defs[id]
+		if obj == nil || !public(obj) {
+			continue
+		}
+
+		x := file[id.Name]
+		if x == nil {
+			x = &globalDecl{obj: obj, pos: id.Pos}
+			file[id.Name] = x
+			names = append(names, id.Name)
+		}
+		if defined && !x.defined {
+			x.obj, x.pos, x.defined = obj, id.Pos, true
+		}
+	}
+
+	var errors scan.ErrorList
+	for _, name := range names {
+		x := file[name]
+		y := g.decls[name]
+		switch {
+		case y == nil:
+			g.decls[name] = x
+			continue
+		case isFunc(x.obj) != isFunc(y.obj):
+			errors.Add(scan.ErrorMessage{Pos: x.pos, Text: fmt.Sprintf("%s redeclared as a different kind of symbol", name)})
+		case !types.Compatible(x.obj.Type(), y.obj.Type()):
+			errors.Add(scan.ErrorMessage{Pos: x.pos, Text: fmt.Sprintf("conflicting types for %s", name)})
+		case x.defined && y.defined:
+			errors.Add(scan.ErrorMessage{Pos: x.pos, Text: fmt.Sprintf("multiple definition of %s", name)})
+		default:
+			if x.defined {
+				g.decls[name] = x
+			}
+			continue
+		}
+		errors.Add(scan.ErrorMessage{Pos: y.pos, Text: fmt.Sprintf("\tother declaration of %s", name)})
+	}
+	return errors.Err()
+}
+
+// public reports whether obj is visible to the other inputs.
+func public(obj types.Object) bool {
+	switch obj := obj.(type) {
+	case *types.Var:
+		return obj.Storage() == types.Public || obj.Storage() == types.Extern
+	case *types.Func:
+		return obj.Storage() == types.Public || obj.Storage() == types.Extern
+	case *types.Fwrd:
+		objs := obj.Objs()
+		return len(objs) > 0 && public(objs[len(objs)-1])
+	}
+	return false
+}
+
+func isFunc(obj types.Object) bool {
+	_, ok := obj.Type().(*types.Signature)
+	return ok
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"io/fs"
+	"strconv"
+	"strings"
+	"text/scanner"
+
+	"subc/compile/arch"
+)
+
+// htmlSink passes the code on to another sink while collecting the
+// assembly generated for each source line, so it can be written out
+// as a html page with the source and the assembly side by side.
+type htmlSink struct {
+	out    arch.Sink
+	text   *arch.TextSink
+	groups []*htmlGroup
+	lines  map[htmlLine]*htmlGroup
+}
+
+// htmlLine is a line in a source file.
+type htmlLine struct {
+	file string
+	line int
+}
+
+// htmlGroup is the assembly generated for a source line.
+type htmlGroup struct {
+	htmlLine
+	asm bytes.Buffer
+}
+
+func newHTMLSink(out arch.Sink) *htmlSink {
+	h := &htmlSink{
+		out:   out,
+		lines: make(map[htmlLine]*htmlGroup),
+	}
+	h.text = arch.NewTextSink(nil)
+	h.Pos(scanner.Position{})
+	return h
+}
+
+func (h *htmlSink) Label(name string, inline bool) {
+	h.out.Label(name, inline)
+	h.text.Label(name, inline)
+}
+
+func (h *htmlSink) Inst(op, operands string) {
+	h.out.Inst(op, operands)
+	h.text.Inst(op, operands)
+}
+
+func (h *htmlSink) Comment(text string) {
+	h.out.Comment(text)
+	h.text.Comment(text)
+}
+
+// Pos switches the assembly collected to the group of the source line.
+func (h *htmlSink) Pos(pos scanner.Position) {
+	h.out.Pos(pos)
+
+	l := htmlLine{pos.Filename, pos.Line}
+	g := h.lines[l]
+	if g == nil {
+		g = &htmlGroup{htmlLine: l}
+		h.lines[l] = g
+		h.groups = append(h.groups, g)
+	}
+	h.text.W = &g.asm
+}
+
+// htmlRow is a row of the page, a source line
+// with the assembly generated for it.
+type htmlRow struct {
+	Pos    string
+	Source string
+	Asm    string
+}
+
+var htmlPage = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: monospace; }
+table { border-collapse: collapse; }
+td { vertical-align: top; padding: 0 1em; white-space: pre; }
+td.pos { color: #888; text-align: right; }
+tr.code td { background: #f4f4f4; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<table>
+{{range .Rows}}<tr{{if .Asm}} class="code"{{end}}><td class="pos">{{.Pos}}</td><td>{{.Source}}</td><td>{{.Asm}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// write writes the page for the source file input to name, code
+// that does not come from a line of input is listed before it.
+func (h *htmlSink) write(name, input string) error {
+	src, err := fs.ReadFile(fsys, input)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(src), "\n"), "\n")
+	source := sourceLines()
+
+	var rows []htmlRow
+	for _, g := range h.groups {
+		if g.file == input || g.asm.Len() == 0 {
+			continue
+		}
+		row := htmlRow{Asm: g.asm.String()}
+		if g.file != "" {
+			row.Pos = g.file + ":" + strconv.Itoa(g.line)
+			row.Source = strings.TrimSpace(source(g.file, g.line))
+		}
+		rows = append(rows, row)
+	}
+
+	for i, text := range lines {
+		row := htmlRow{Pos: strconv.Itoa(i + 1), Source: text}
+		if g := h.lines[htmlLine{input, i + 1}]; g != nil {
+			row.Asm = g.asm.String()
+		}
+		rows = append(rows, row)
+	}
+
+	var buf bytes.Buffer
+	err = htmlPage.Execute(&buf, struct {
+		Title string
+		Rows  []htmlRow
+	}{input, rows})
+	if err != nil {
+		return err
+	}
+
+	fd, err := fsys.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = fd.Write(buf.Bytes())
+	if xerr := fd.Close(); err == nil {
+		err = xerr
+	}
+	return err
+}
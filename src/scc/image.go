@@ -0,0 +1,171 @@
+package main
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// pageSize is the alignment of the image base, the segments
+// of the image start on a page.
+const pageSize = 0x1000
+
+// span is a half-open range of addresses [lo, hi).
+type span struct {
+	lo, hi uint64
+}
+
+func (s span) size() uint64 { return s.hi - s.lo }
+
+func (s span) String() string { return fmt.Sprintf("[%#x, %#x)", s.lo, s.hi) }
+
+// parseSize parses a size or an address, in any base strconv knows,
+// with an optional K, M or G suffix for the multiples of 1024.
+func parseSize(s string) (uint64, error) {
+	shift := uint(0)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		shift = 10
+	case strings.HasSuffix(s, "M"):
+		shift = 20
+	case strings.HasSuffix(s, "G"):
+		shift = 30
+	}
+	if shift != 0 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseUint(s, 0, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n<<shift>>shift != n {
+		return 0, fmt.Errorf("%s is too large", s)
+	}
+	return n << shift, nil
+}
+
+// imageArgs checks the image options and returns the arguments that
+// tell the linker to put the image at the address of -image-base, a
+// flat image is at the address it is loaded at unless it is given.
+func imageArgs() ([]string, error) {
+	if flags.MaxImageSize != "" {
+		if _, err := parseSize(flags.MaxImageSize); err != nil {
+			return nil, fmt.Errorf("invalid maximum image size: %v", err)
+		}
+	}
+	flat, isFlat := flatImage()
+	if flags.ImageBase == "" && !isFlat {
+		return nil, nil
+	}
+	base := flat.base
+	if flags.ImageBase != "" {
+		var err error
+		if base, err = parseSize(flags.ImageBase); err != nil {
+			return nil, fmt.Errorf("invalid image base: %v", err)
+		}
+	}
+	if isFlat {
+		// the sections follow each other in a flat image, -N
+		// keeps them from starting on pages of their own and
+		// makes one segment of them that is writable and runs
+		return []string{"-N", "--no-warn-rwx-segments", fmt.Sprintf("-Ttext=%#x", base)}, nil
+	}
+	if base%pageSize != 0 {
+		return nil, fmt.Errorf("image base %#x is not aligned to a page of %#x bytes", base, pageSize)
+	}
+	if flags.OS == "darwin" {
+		return []string{"-image_base", fmt.Sprintf("%#x", base)}, nil
+	}
+	return []string{fmt.Sprintf("-Ttext-segment=%#x", base)}, nil
+}
+
+// checkImage checks that the image linked to output is at the image
+// base and no larger than -max-image-size, and that a flat image ends
+// in its segment. The image is removed if it isn't so a build can't
+// use it.
+func checkImage(output string) error {
+	if _, isFlat := flatImage(); !isFlat && flags.ImageBase == "" && flags.MaxImageSize == "" {
+		return nil
+	}
+	err := checkImageSpan(output)
+	if err != nil {
+		os.Remove(output)
+	}
+	return err
+}
+
+func checkImageSpan(output string) error {
+	image, err := imageSpan(output)
+	if err != nil {
+		return err
+	}
+
+	if flags.ImageBase != "" {
+		base, _ := parseSize(flags.ImageBase)
+		if image.lo < base {
+			return fmt.Errorf("%s: image %v starts below the image base %#x", output, image, base)
+		}
+	}
+
+	if _, isFlat := flatImage(); isFlat && image.hi > segmentSize {
+		return fmt.Errorf("%s: image %v doesn't fit in the segment of %d bytes", output, image, segmentSize)
+	}
+
+	if flags.MaxImageSize != "" {
+		max, _ := parseSize(flags.MaxImageSize)
+		if image.size() > max {
+			return fmt.Errorf("%s: image %v of %d bytes is larger than the maximum of %d bytes", output, image, image.size(), max)
+		}
+	}
+	return nil
+}
+
+// imageSpan returns the addresses that the segments of the
+// executable file name take when it is loaded.
+func imageSpan(name string) (span, error) {
+	var segs []span
+	if flags.OS == "darwin" {
+		f, err := macho.Open(name)
+		if err != nil {
+			return span{}, err
+		}
+		defer f.Close()
+		for _, l := range f.Loads {
+			// __PAGEZERO only reserves the addresses below the image
+			if s, ok := l.(*macho.Segment); ok && s.Memsz > 0 && s.Name != "__PAGEZERO" {
+				segs = append(segs, span{s.Addr, s.Addr + s.Memsz})
+			}
+		}
+	} else {
+		f, err := elf.Open(name)
+		if err != nil {
+			return span{}, err
+		}
+		defer f.Close()
+		for _, p := range f.Progs {
+			if p.Type == elf.PT_LOAD && p.Memsz > 0 {
+				segs = append(segs, span{p.Vaddr, p.Vaddr + p.Memsz})
+			}
+		}
+	}
+
+	if len(segs) == 0 {
+		return span{}, fmt.Errorf("%s: no segments are loaded", name)
+	}
+	image := segs[0]
+	for _, s := range segs {
+		if s.hi < s.lo {
+			return span{}, fmt.Errorf("%s: segment %v wraps around the address space", name, s)
+		}
+		if s.lo < image.lo {
+			image.lo = s.lo
+		}
+		if s.hi > image.hi {
+			image.hi = s.hi
+		}
+	}
+	return image, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+
+	"subc/scan"
+)
+
+// includeGraph is the graph of files including each other,
+// collected from all the inputs for -dump-includes.
+type includeGraph struct {
+	files []string
+	index map[string]int
+	edges []includeEdge
+	seen  map[[2]int]bool
+}
+
+// includeEdge is an include of one file by another, located at
+// the first line the include was seen.
+type includeEdge struct {
+	from, to int
+	line     int
+}
+
+// includes collects the include graph for -dump-includes.
+var includes *includeGraph
+
+func newIncludeGraph() *includeGraph {
+	return &includeGraph{
+		index: make(map[string]int),
+		seen:  make(map[[2]int]bool),
+	}
+}
+
+func (g *includeGraph) file(name string) int {
+	name = filepath.Clean(name)
+	if i, ok := g.index[name]; ok {
+		return i
+	}
+	g.index[name] = len(g.files)
+	g.files = append(g.files, name)
+	return len(g.files) - 1
+}
+
+func (g *includeGraph) add(ev scan.IncludeEvent) {
+	from := g.file(ev.Pos.Filename)
+	to := g.file(ev.File)
+	if g.seen[[2]int{from, to}] {
+		return
+	}
+	g.seen[[2]int{from, to}] = true
+	g.edges = append(g.edges, includeEdge{from, to, ev.Pos.Line})
+}
+
+// scan runs the preprocessor over name and records the files it includes.
+func (g *includeGraph) scan(ctx context.Context, name string) error {
+	g.file(name)
+	scanner, err := newScanner(ctx, name, func(c *scan.Config) {
+		c.IncludeHook = g.add
+	})
+	if err != nil {
+		return err
+	}
+	for tok := range scanner.Tokens {
+		if tok.Type == scan.Error {
+			reportToken(tok)
+		}
+	}
+	return nil
+}
+
+// write writes the graph out in the given format, either dot or json.
+func (g *includeGraph) write(w io.Writer, format string) error {
+	if format == "json" {
+		return g.writeJSON(w)
+	}
+	return g.writeDOT(w)
+}
+
+func (g *includeGraph) writeDOT(w io.Writer) error {
+	fmt.Fprintln(w, "digraph includes {")
+	for _, name := range g.files {
+		fmt.Fprintf(w, "\t%s;\n", strconv.Quote(name))
+	}
+	for _, e := range g.edges {
+		fmt.Fprintf(w, "\t%s -> %s [label=%d];\n", strconv.Quote(g.files[e.from]), strconv.Quote(g.files[e.to]), e.line)
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+func (g *includeGraph) writeJSON(w io.Writer) error {
+	type edge struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+		Line int    `json:"line"`
+	}
+	out := struct {
+		Files    []string `json:"files"`
+		Includes []edge   `json:"includes"`
+	}{Files: g.files, Includes: []edge{}}
+	for _, e := range g.edges {
+		out.Includes = append(out.Includes, edge{g.files[e.from], g.files[e.to], e.line})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(out)
+}
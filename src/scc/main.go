@@ -2,36 +2,62 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"runtime/pprof"
 	"sort"
+	"strconv"
 	"strings"
 	"text/scanner"
 
+	"subc/asm"
 	"subc/ast"
+	"subc/callgraph"
 	"subc/compile"
 	"subc/compile/arch"
 	"subc/compile/arch/amd64"
 	"subc/compile/arch/arm6"
 	"subc/compile/arch/darwinamd64"
 	"subc/compile/arch/i386"
+	"subc/compile/arch/i8086"
+	"subc/compile/arch/mips"
 	"subc/parse"
 	"subc/scan"
 	"subc/types"
+	"subc/vfs"
 )
 
+// callGraph collects the functions of all the inputs for the stack report.
+var callGraph *callgraph.Graph
+
+// fsys is the file system used for reading sources and
+// writing objects, external tools still use the host one.
+var fsys = vfs.OS()
+
 func main() {
-	os.Exit(build())
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	var status int
+	switch {
+	case flags.Daemon != "":
+		status = serve(ctx, flags.Daemon)
+	case flags.Connect != "":
+		status = connect(ctx, flags.Connect)
+	default:
+		status = build(ctx)
+	}
+	stop()
+	os.Exit(status)
 }
 
-func build() int {
+func build(ctx context.Context) int {
 	var err error
 	var objFiles []string
 
@@ -60,23 +86,80 @@ func build() int {
 		}
 	}
 
+	if flags.StackReport {
+		callGraph = callgraph.New()
+		defer func() {
+			if err := callGraph.Report(os.Stdout); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}()
+	}
+
+	if flags.Summary {
+		if flags.PrintAsm || flags.OS == "darwin" {
+			fmt.Fprintln(os.Stderr, "-fsummary reads the ELF objects of the compile, which -S and darwin don't write")
+			return 1
+		}
+		summaries = newSummaryWriter(os.Stdout)
+	}
+
+	if flags.Lang != "c" {
+		fmt.Fprintf(os.Stderr, "unknown language %q, scc compiles c\n", flags.Lang)
+		return 1
+	}
+	if flags.Output == "-" && !flags.PrintAsm {
+		fmt.Fprintln(os.Stderr, "-o - writes the assembly of -S to stdout, the objects and the programs are written to files")
+		return 1
+	}
+
+	if flags.DiagnosticsSummary {
+		diagnostics = newDiagSummary()
+		defer diagnostics.write(os.Stderr)
+	}
+
+	switch flags.DumpIncludes {
+	case "":
+	case "dot", "json":
+		includes = newIncludeGraph()
+		defer func() {
+			if err := includes.write(os.Stdout, flags.DumpIncludes); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}()
+	default:
+		fmt.Fprintf(os.Stderr, "unknown include graph format %q\n", flags.DumpIncludes)
+		return 1
+	}
+
+	wholeProgram = nil
+	if flags.WholeProgram && !dumping() {
+		wholeProgram, err = loadProgram(ctx, flag.Args())
+		if err != nil {
+			report(err)
+			return 1
+		}
+	}
+
 	exitStatus := 0
 	for i := 0; i < flag.NArg(); i++ {
 		name := flag.Arg(i)
 		switch {
 		case dumping():
-			err = dump(name)
+			err = guard(name, func() error { return dump(ctx, name) })
 
 		default:
 			objFile := name
 			if flag.NArg() == 1 && flags.Output != "" && flags.CompileOnly {
 				objFile = flags.Output
 			} else {
-				os.MkdirAll(flags.TempDir, 0755)
+				fsys.MkdirAll(flags.TempDir)
 				ext := filepath.Ext(name)
-				if strings.ToLower(ext) == ".o" {
+				switch {
+				case name == stdinInput:
+					objFile = "stdin.o"
+				case strings.ToLower(ext) == ".o":
 					objFile = name + ".o"
-				} else {
+				default:
 					objFile = name[:len(name)-len(ext)] + ".o"
 				}
 				if flags.TempDir != "" {
@@ -86,17 +169,20 @@ func build() int {
 			name = filepath.Clean(name)
 			objFile = filepath.Clean(objFile)
 			if flags.RemoveOnFinish && !flags.CompileOnly {
-				defer os.Remove(objFile)
+				defer fsys.Remove(objFile)
 			}
 
-			err = makeObj(name, objFile)
+			err = guard(name, func() error { return makeObj(ctx, name, objFile) })
+			if err == nil && summaries != nil {
+				err = summaries.write(name, objFile)
+			}
 			if err == nil && !flags.PrintAsm {
 				objFiles = append(objFiles, objFile)
 			}
 		}
 
 		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
+			report(err)
 			exitStatus = 1
 		}
 	}
@@ -105,9 +191,9 @@ func build() int {
 		return exitStatus
 	}
 
-	err = linkObjs(flags.Output, objFiles...)
+	err = linkObjs(ctx, flags.Output, objFiles...)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
+		report(err)
 		exitStatus = 1
 	}
 
@@ -118,11 +204,22 @@ func getCmdArgs(env, def string) []string {
 	cmd := os.Getenv(env)
 	if cmd == "" {
 		cmd = def
+		if tool, ok := crossTools[flags.Arch][env]; ok {
+			cmd = tool
+		}
 	}
 	return strings.Fields(cmd)
 }
 
-func newScanner(name string) (*scan.Scanner, error) {
+// crossTools are the commands run by default for the targets that the
+// tools of the host make the objects of with other options, or that
+// take the tools of a cross toolchain.
+var crossTools = map[string]map[string]string{
+	"8086": {"AS": "as --32", "LD": "ld -m elf_i386"},
+	"mips": {"AS": "mips-linux-gnu-as -mips32", "LD": "mips-linux-gnu-ld", "AR": "mips-linux-gnu-ar"},
+}
+
+func newScanner(ctx context.Context, name string, setup func(*scan.Config)) (*scan.Scanner, error) {
 	var (
 		reader scan.Reader
 		err    error
@@ -134,19 +231,39 @@ func newScanner(name string) (*scan.Scanner, error) {
 		}
 		args = append(args, name)
 
-		cmd := exec.Command(args[0], args[1:]...)
+		echo(args)
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
 		cmd.Stderr = os.Stderr
+		filename := name
+		if name == stdinInput {
+			text, err := readStdin()
+			if err != nil {
+				return nil, err
+			}
+			cmd.Stdin = strings.NewReader(text)
+			filename = stdinName
+		}
 		buf, err := cmd.Output()
 		if err != nil {
 			return nil, err
 		}
 		reader = scan.StringReader(scanner.Position{
-			Filename: name,
+			Filename: filename,
 			Line:     1,
 			Column:   1,
 		}, string(buf), false)
+	} else if name == stdinInput {
+		text, err := readStdin()
+		if err != nil {
+			return nil, err
+		}
+		reader = scan.StringReader(scanner.Position{
+			Filename: stdinName,
+			Line:     1,
+			Column:   1,
+		}, text, false)
 	} else {
-		reader, err = scan.OpenFile(name)
+		reader, err = scan.FSLoader(fsys).Open(name)
 		if err != nil {
 			return nil, err
 		}
@@ -154,27 +271,74 @@ func newScanner(name string) (*scan.Scanner, error) {
 
 	scanConfig := scan.DefaultConfig
 	scanConfig.IncludePaths = flags.Includes
+	scanConfig.Loader = scan.FSLoader(fsys)
+	scanConfig.Trigraphs = flags.Trigraphs
+	scanConfig.ANSI = ansi()
+	// the includes of the target pick the limits of the int and
+	// what the runtime does on DOS by these
+	macros := scanConfig.Macros[:len(scanConfig.Macros):len(scanConfig.Macros)]
+	if flags.IntSize != 0 {
+		macros = append(macros, [2]string{fmt.Sprintf("__INT%d__", flags.IntSize), ""})
+	}
+	if flags.OS == "dos" {
+		macros = append(macros, [2]string{"__dos", ""})
+	}
+	macros = append(macros, typeMacros()...)
+	scanConfig.Macros = macros
+	if setup != nil {
+		setup(&scanConfig)
+	}
+	if name == stdinInput {
+		name = stdinName
+	}
 	scanner := scan.New(scanConfig, name, reader)
 	return scanner, nil
 }
 
+// typeMacros returns the macros of the types of size_t, ptrdiff_t and
+// wchar_t of the target, none for a target that isn't known, which the
+// compile reports.
+func typeMacros() [][2]string {
+	emitter, err := newArchEmitter(arch.NewTextSink(ioutil.Discard))
+	if err != nil {
+		return nil
+	}
+	return emitter.TypeMacros()
+}
+
 func parseAndTypecheck(scanner *scan.Scanner, emitter *arch.Emitter) (*ast.Prog, *types.Info, error) {
 	predecl := true
 	if flags.Compat {
 		predecl = false
 	}
-	parseConfig := parse.Config{MaxErrors: flags.MaxErrors, Predecl: predecl}
+	parseConfig := parse.Config{MaxErrors: flags.MaxErrors, Predecl: predecl, ANSI: ansi()}
+	phase = "parse"
 	prog, err := parse.Parse(parseConfig, scanner)
-	if err = checkFrontEndError(err); err != nil {
+	if err = checkErrors(err); err != nil {
 		return prog, nil, err
 	}
 
-	typeConfig := types.Config{Sizes: emitter.Sizes, MaxErrors: flags.MaxErrors}
+	implicit := types.ImplicitWarn
+	switch {
+	case flags.Strict:
+		implicit = types.ImplicitError
+	case flags.Compat:
+		implicit = types.ImplicitAllow
+	}
+	phase = "type check"
+	typeConfig := types.Config{Sizes: emitter.Sizes, StdTypes: emitter.StdTypes, MaxErrors: flags.MaxErrors, Implicit: implicit}
 	info, err := types.Check(typeConfig, prog)
-	return prog, info, checkFrontEndError(err)
+	return prog, info, checkErrors(err)
 }
 
-func checkFrontEndError(err error) error {
+// ansi reports whether the GNU extensions are errors.
+func ansi() bool {
+	return flags.Ansi || !flags.GNUExtensions
+}
+
+// checkErrors prints the warnings of the errors of a phase and returns
+// err only if some are errors, which -no-warnings makes of the warnings.
+func checkErrors(err error) error {
 	l, _ := err.(*scan.ErrorList)
 	if l == nil {
 		return err
@@ -186,75 +350,230 @@ func checkFrontEndError(err error) error {
 			l.Messages[i].Warning = false
 		}
 	}
+	if diagnostics != nil {
+		diagnostics.add(l)
+	}
 	if l.NumErrors > 0 {
 		return err
 	}
 
-	for _, m := range l.Messages {
-		fmt.Fprintln(os.Stderr, m)
-	}
+	report(l)
 	return nil
 }
 
-func newArchEmitter(w io.Writer) (*arch.Emitter, error) {
+// diag is where the errors and warnings of the compile are reported,
+// a job of the daemon reports them to its client.
+var diag scan.DiagnosticSink = scan.NewWriterSink(os.Stderr)
+
+// report reports the diagnostics of err to diag.
+func report(err error) {
+	scan.ReportError(diag, err)
+}
+
+// reportToken reports the error of an error token to diag.
+func reportToken(tok scan.Token) {
+	diag.Report(scan.Diagnostic{Severity: scan.ErrorSeverity, Pos: tok.Pos, Message: tok.Text})
+}
+
+func newArchEmitter(out arch.Sink) (*arch.Emitter, error) {
 	var emitter *arch.Emitter
 	switch flags.Arch {
 	case "amd64":
 		if flags.OS == "darwin" {
-			emitter = darwinamd64.NewEmitter(w)
+			emitter = darwinamd64.New(out)
 		} else {
-			emitter = amd64.NewEmitter(w)
+			emitter = amd64.New(out)
 		}
 
 	case "i386":
-		emitter = i386.NewEmitter(w)
+		emitter = i386.New(out)
 
 	case "arm6":
-		emitter = arm6.NewEmitter(w)
+		emitter = arm6.New(out)
+
+	case "8086":
+		if _, ok := flatImage(); !ok {
+			return nil, fmt.Errorf("the 8086 runs dos or boot programs, not %s ones", flags.OS)
+		}
+		if flags.Sanitize.Null {
+			return nil, fmt.Errorf("-fsanitize=null reports through the C library, which the 8086 runtime doesn't have")
+		}
+		emitter = i8086.New(out)
+
+	case "mips":
+		if flags.OS != "linux" {
+			return nil, fmt.Errorf("mips only runs linux programs, not %s ones", flags.OS)
+		}
+		emitter = mips.New(out)
 	}
 
-	if emitter != nil {
-		return emitter, nil
+	if emitter == nil {
+		return nil, fmt.Errorf("unknown architecture %v", flags.Arch)
 	}
 
-	return nil, fmt.Errorf("unknown architecture %v", flags.Arch)
-}
+	switch flags.LabelPrefix {
+	case "L":
+	case ".L":
+		if flags.OS == "darwin" {
+			return nil, fmt.Errorf("-label-prefix .L is for ELF objects, the labels local to the darwin assembler start with L")
+		}
+		emitter.LabelPrefix = flags.LabelPrefix
+	default:
+		return nil, fmt.Errorf("unknown label prefix %q, it is L or .L", flags.LabelPrefix)
+	}
 
-func makeObj(input, output string) error {
-	scanner, err := newScanner(input)
-	if err != nil {
-		return err
+	if flags.IntSize != 0 {
+		if flags.IntSize%8 != 0 {
+			return nil, fmt.Errorf("-int-size %d is not a whole number of bytes", flags.IntSize)
+		}
+		if err := emitter.SetInt(flags.IntSize / 8); err != nil {
+			return nil, err
+		}
+		// the object writer takes the loads and stores of words only
+		if emitter.Int() != emitter.Word() && directObj() {
+			return nil, fmt.Errorf("-int-size %d can't be used with -direct", flags.IntSize)
+		}
 	}
+	return emitter, nil
+}
 
+func makeObj(ctx context.Context, input, output string) error {
+	var builder *asm.Builder
+	var out arch.Sink
 	buf := new(bytes.Buffer)
-	emitter, err := newArchEmitter(buf)
+	if directObj() {
+		builder = asm.NewBuilder(asm.Config{Arch: flags.Arch, OS: flags.OS}, input)
+		out = builder
+	} else {
+		sink := arch.NewTextSink(buf)
+		if flags.Annotate {
+			sink.Source = sourceLines()
+		}
+		out = sink
+	}
+
+	var page *htmlSink
+	if flags.HTML != "" {
+		page = newHTMLSink(out)
+		out = page
+	}
+
+	emitter, err := newArchEmitter(out)
 	if err != nil {
 		return err
 	}
+	emitter.Verbose = arch.Verbosity(flags.AsmVerbose.Level)
 
-	prog, info, err := parseAndTypecheck(scanner, emitter)
-	if err != nil {
+	var fold *arch.FoldSink
+	var branches *arch.BranchSink
+	var sched *arch.ScheduleSink
+	if !flags.Compat {
+		next := out
+		if emitter.Scheduling != nil {
+			sched = arch.NewScheduleSink(out, emitter.Scheduling)
+			next = sched
+		}
+		branches = arch.NewBranchSink(next, emitter.Branches)
+		emitter.Out = branches
+		if emitter.Folding != nil {
+			fold = arch.NewFoldSink(branches, emitter.Folding)
+			emitter.Out = fold
+		}
+	}
+
+	var (
+		prog *ast.Prog
+		info *types.Info
+	)
+	if wholeProgram != nil {
+		u := wholeProgram.units[input]
+		prog, info = u.prog, u.info
+	} else {
+		scanner, err := newScanner(ctx, input, nil)
+		if err != nil {
+			return err
+		}
+		prog, info, err = parseAndTypecheck(scanner, emitter)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := globals.add(prog, info); err != nil {
 		return err
 	}
 
-	compileConfig := compile.Config{Emitter: emitter, MaxErrors: flags.MaxErrors}
-	err = compile.Compile(compileConfig, prog, info)
+	if callGraph != nil {
+		callGraph.Add(prog, info, emitter.Sizes)
+	}
+
+	compileConfig := compile.Config{Emitter: emitter, MaxErrors: flags.MaxErrors, Common: !flags.Compat, Pool: !flags.Compat, Hoist: !flags.Compat, Reduce: !flags.Compat, Layout: !flags.Compat, Extend: !flags.Compat, Bools: !flags.Compat, Truncate: !flags.Compat, Index: !flags.Compat, Align: !flags.Compat && flags.Opt >= 2}
+	if !flags.Compat {
+		compileConfig.Ident = ident()
+	}
+	// the 8086 runtime has no C library to call memcpy and memset from
+	compileConfig.Idioms = !flags.Compat && flags.Arch != "8086"
+	compileConfig.Blocks = compileConfig.Idioms && flags.Opt >= 2
+	compileConfig.Registers = !flags.Compat
+	if flags.DumpIR.On {
+		compileConfig.Dump, compileConfig.DumpPass = os.Stderr, flags.DumpIR.Pass
+	}
+	if flags.FrameReport {
+		compileConfig.Frames = os.Stdout
+	}
+	compileConfig.MaxFrame = flags.MaxFrame
+	compileConfig.NullChecks = flags.Sanitize.Null
+	compileConfig.Instrument = flags.Instrument
+	if wholeProgram != nil {
+		compileConfig.Omit = wholeProgram.omitted(input)
+	}
+	phase = "compile"
+	err = checkErrors(compile.Compile(ctx, compileConfig, prog, info))
 	if err != nil {
 		return err
 	}
+	if summaries != nil {
+		// the asm labels give the symbols once the program is compiled
+		summaries.declare(input, prog, info, emitter)
+	}
+	if fold != nil {
+		fold.Flush()
+	}
+	if branches != nil {
+		branches.Flush()
+	}
+	if sched != nil {
+		sched.Flush()
+	}
+
+	if page != nil {
+		err = page.write(flags.HTML, input)
+		if err != nil {
+			return err
+		}
+	}
 
 	if flags.PrintAsm {
+		if flag.NArg() == 1 && flags.Output != "" && flags.Output != "-" {
+			return writeAsm(buf, flags.Output)
+		}
 		fmt.Println(buf.String())
 		return nil
 	}
 
+	phase = "assemble"
+	if builder != nil {
+		return writeObj(builder, output)
+	}
+
 	args := getCmdArgs("AS", "as")
 	args = append(args, "-o", output)
+	echo(args)
 
 	cmdErr := new(bytes.Buffer)
 	cmdOut := new(bytes.Buffer)
 
-	cmd := exec.Command(args[0], args[1:]...)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
 	cmd.Stdin = buf
 	cmd.Stderr = cmdErr
 	cmd.Stdout = cmdOut
@@ -270,6 +589,68 @@ func makeObj(input, output string) error {
 	return nil
 }
 
+// sourceLines returns a function that looks up the lines of the
+// source files for annotating the assembly, reading them through fsys.
+func sourceLines() func(filename string, line int) string {
+	files := make(map[string][]string)
+	return func(filename string, line int) string {
+		lines, found := files[filename]
+		if !found {
+			buf, _ := readSource(filename)
+			lines = strings.Split(string(buf), "\n")
+			files[filename] = lines
+		}
+		if line < 1 || line > len(lines) {
+			return ""
+		}
+		return lines[line-1]
+	}
+}
+
+// elfOSes are the oses whose objects are ELF, which the builtin
+// assembler writes.
+var elfOSes = map[string]bool{
+	"linux":   true,
+	"freebsd": true,
+	"netbsd":  true,
+	"openbsd": true,
+}
+
+// directObj reports whether the backend should emit straight
+// into the builtin assembler rather than through assembly text.
+func directObj() bool {
+	return flags.Direct && !flags.PrintAsm && (flags.Arch == "amd64" || flags.Arch == "i386" || flags.Arch == "mips") && elfOSes[flags.OS]
+}
+
+func writeObj(builder *asm.Builder, output string) error {
+	fd, err := fsys.Create(output)
+	if err != nil {
+		return err
+	}
+
+	err = builder.Finish(fd)
+	if xerr := fd.Close(); err == nil {
+		err = xerr
+	}
+	if err != nil {
+		fsys.Remove(output)
+	}
+	return err
+}
+
+// writeAsm writes the assembly of -S to the file output.
+func writeAsm(buf *bytes.Buffer, output string) error {
+	fd, err := fsys.Create(output)
+	if err != nil {
+		return err
+	}
+	_, err = fd.Write(buf.Bytes())
+	if xerr := fd.Close(); err == nil {
+		err = xerr
+	}
+	return err
+}
+
 func printAsmOutput(w io.Writer, name string, buf *bytes.Buffer) {
 	lines := strings.Split(buf.String(), "\n")
 	for len(lines) > 0 {
@@ -314,59 +695,77 @@ func printAsmOutput(w io.Writer, name string, buf *bytes.Buffer) {
 	}
 }
 
-func linkObjs(output string, objFiles ...string) error {
+func linkObjs(ctx context.Context, output string, objFiles ...string) error {
 	if len(objFiles) == 0 {
 		return nil
 	}
 
-	runtimeDir := filepath.Join(flags.RuntimeDir, flags.Arch, flags.OS)
+	base, err := imageArgs()
+	if err != nil {
+		return err
+	}
+
+	crt0, lib, err := runtimeObjs(ctx)
+	if err != nil {
+		return err
+	}
+
+	// a flat image is copied out of the executable linked next to it
+	exe := output
+	flat, isFlat := flatImage()
+	if isFlat {
+		exe = output + ".elf"
+		if flags.RemoveOnFinish {
+			defer os.Remove(exe)
+		}
+	}
 
 	args := getCmdArgs("LD", "ld")
-	args = append(args, "-o", output)
-	args = append(args, filepath.Join(runtimeDir, "crt0.o"))
+	args = append(args, base...)
+	args = append(args, "-o", exe)
+	args = append(args, crt0)
 	args = append(args, objFiles...)
-	args = append(args, filepath.Join(runtimeDir, "libscc.a"))
+	args = append(args, lib)
+	echo(args)
 
-	cmd := exec.Command(args[0], args[1:]...)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
 	cmd.Stderr = os.Stderr
 	cmd.Stdout = os.Stdout
-	return cmd.Run()
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+	if err := checkImage(exe); err != nil {
+		return err
+	}
+	if isFlat {
+		return copyFlat(ctx, flat, exe, output)
+	}
+	return nil
 }
 
-func dump(name string) error {
-	scanner, err := newScanner(name)
+func dump(ctx context.Context, name string) error {
+	if includes != nil {
+		if err := includes.scan(ctx, name); err != nil {
+			return err
+		}
+	}
+
+	if flags.DumpMacros {
+		if err := dumpMacros(ctx, name); err != nil {
+			return err
+		}
+	}
+
+	scanner, err := newScanner(ctx, name, nil)
 	if err != nil {
 		return err
 	}
 	defer scanner.Close()
 
 	if flags.DumpCpp {
-		file := ""
-		line, col := 1, 1
-		for tok := range scanner.Tokens {
-			tok.Pos.Filename = strings.TrimRight(tok.Pos.Filename, " (macro)")
-			if file != tok.Pos.Filename {
-				fmt.Printf("\n# %d %s\n\n", tok.Pos.Line, tok.Pos.Filename)
-				file = tok.Pos.Filename
-				line = tok.Pos.Line
-				col = tok.Pos.Column
-			}
-			for ; line < tok.Pos.Line; line, col = line+1, 1 {
-				fmt.Printf("\n")
-			}
-			for ; col < tok.Pos.Column; col++ {
-				fmt.Printf(" ")
-			}
-			if tok.Type == scan.String {
-				fmt.Printf("%q", tok.Text)
-			} else {
-				fmt.Printf("%s", tok.Text)
-			}
-			col += len(tok.Text)
-		}
-		fmt.Printf("\n")
+		writeCpp(os.Stdout, scanner.Tokens)
 
-		scanner, err = newScanner(name)
+		scanner, err = newScanner(ctx, name, nil)
 		if err != nil {
 			return err
 		}
@@ -376,11 +775,15 @@ func dump(name string) error {
 	if flags.DumpTokens {
 		fmt.Println()
 		for tok := range scanner.Tokens {
-			fmt.Printf("%v: %v %q\n", tok.Pos, tok.Type, tok.Text)
+			fmt.Printf("%v: %v %q", tok.Pos, tok.Type, tok.Text)
+			if x := tok.Macro; x != nil {
+				fmt.Printf(" from %s", strings.Join(x.Macros, " -> "))
+			}
+			fmt.Println()
 		}
 		fmt.Println()
 
-		scanner, err = newScanner(name)
+		scanner, err = newScanner(ctx, name, nil)
 		if err != nil {
 			return err
 		}
@@ -391,7 +794,7 @@ func dump(name string) error {
 		return nil
 	}
 
-	emitter, err := newArchEmitter(ioutil.Discard)
+	emitter, err := newArchEmitter(arch.NewTextSink(ioutil.Discard))
 	if err != nil {
 		return err
 	}
@@ -444,3 +847,148 @@ func dump(name string) error {
 
 	return err
 }
+
+// writeCpp writes the preprocessed text of the tokens to w as C that
+// scc can compile again, the tokens of a file keep their lines and
+// line markers give the file and line where they come from.
+func writeCpp(w io.Writer, tokens <-chan scan.Token) {
+	file := ""
+	line, col := 1, 1
+	for tok := range tokens {
+		tok.Pos.Filename = strings.TrimSuffix(tok.Pos.Filename, " (macro)")
+		if file != tok.Pos.Filename || line > tok.Pos.Line {
+			fmt.Fprintf(w, "\n# %d %s\n", tok.Pos.Line, strconv.Quote(tok.Pos.Filename))
+			file = tok.Pos.Filename
+			line, col = tok.Pos.Line, 1
+		}
+		for ; line < tok.Pos.Line; line, col = line+1, 1 {
+			fmt.Fprintf(w, "\n")
+		}
+		if col > 1 && col > tok.Pos.Column {
+			fmt.Fprintf(w, " ")
+			col++
+		}
+		for ; col < tok.Pos.Column; col++ {
+			fmt.Fprintf(w, " ")
+		}
+		text := tok.Text
+		if tok.Type == scan.String || tok.Type == scan.Rune {
+			text = quoteC(text)
+		}
+		fmt.Fprintf(w, "%s", text)
+		col += len(text)
+	}
+	fmt.Fprintf(w, "\n")
+}
+
+// quoteC escapes the text of a string or character literal the scanner
+// has decoded, so it can be scanned again.
+func quoteC(text string) string {
+	i := strings.IndexAny(text, "\"'")
+	if i < 0 || len(text) < i+2 {
+		return text
+	}
+	q := text[i]
+	s := text[i+1 : len(text)-1]
+
+	var b strings.Builder
+	b.WriteString(text[:i+1])
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == q || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString("\\n")
+		case c == '\t':
+			b.WriteString("\\t")
+		case c < ' ' || c >= 0x7f:
+			fmt.Fprintf(&b, "\\%03o", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte(q)
+	return b.String()
+}
+
+// macroDef is one definition of a macro, from where it was
+// defined until it was undefined or the end of the input.
+type macroDef struct {
+	pos        string
+	text       string
+	undef      string
+	expansions []string
+}
+
+// dumpMacros prints every macro that was defined while preprocessing
+// name and where each definition was expanded.
+func dumpMacros(ctx context.Context, name string) error {
+	macros := make(map[string][]*macroDef)
+	hook := func(ev scan.MacroEvent) {
+		pos := "<builtin>"
+		if ev.Pos.IsValid() {
+			pos = fmt.Sprint(ev.Pos)
+		}
+
+		defs := macros[ev.Name]
+		var def *macroDef
+		if len(defs) > 0 && defs[len(defs)-1].undef == "" {
+			def = defs[len(defs)-1]
+		}
+
+		switch ev.Kind {
+		case scan.MacroDefine:
+			if def == nil || def.pos != pos {
+				macros[ev.Name] = append(defs, &macroDef{pos: pos, text: ev.Text})
+			}
+		case scan.MacroUndef:
+			if def != nil {
+				def.undef = pos
+			}
+		case scan.MacroExpand:
+			// __FILE__, __LINE__ and friends are computed by the
+			// scanner and never go through a definition.
+			if def == nil {
+				def = &macroDef{pos: "<builtin>"}
+				macros[ev.Name] = append(defs, def)
+			}
+			def.expansions = append(def.expansions, pos)
+		}
+	}
+
+	scanner, err := newScanner(ctx, name, func(c *scan.Config) {
+		c.MacroHook = hook
+	})
+	if err != nil {
+		return err
+	}
+	for tok := range scanner.Tokens {
+		if tok.Type == scan.Error {
+			reportToken(tok)
+		}
+	}
+
+	var names []string
+	for name := range macros {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Println()
+	fmt.Println("Definitions and expansions of each macro:")
+	for _, name := range names {
+		fmt.Printf("%s:\n", name)
+		for _, def := range macros[name] {
+			fmt.Printf(" defined at %s as %q\n", def.pos, def.text)
+			if len(def.expansions) > 0 {
+				fmt.Printf("  expanded at %s\n", strings.Join(def.expansions, ", "))
+			}
+			if def.undef != "" {
+				fmt.Printf(" undefined at %s\n", def.undef)
+			}
+		}
+	}
+	fmt.Println()
+	return nil
+}
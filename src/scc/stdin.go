@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// The input - is the source read from stdin, for the scripts that pipe
+// a program through scc and for the playground backend. It is read once
+// and kept, as the dumps and the reproducer of a crash scan it again,
+// and its diagnostics say it comes from <stdin>. With -S -o - the
+// assembly is written to stdout, as it is without -o.
+
+const (
+	stdinInput = "-"       // the input that is read from stdin
+	stdinName  = "<stdin>" // the file that the source of stdin is in
+)
+
+// stdinSource is the source read from stdin.
+var stdinSource struct {
+	once sync.Once
+	text string
+	err  error
+}
+
+// readStdin returns the source on stdin, reading it the first time.
+func readStdin() (string, error) {
+	stdinSource.once.Do(func() {
+		buf, err := io.ReadAll(os.Stdin)
+		stdinSource.text, stdinSource.err = string(buf), err
+	})
+	return stdinSource.text, stdinSource.err
+}
+
+// readsStdin reports whether one of the inputs is read from stdin.
+func readsStdin() bool {
+	for _, input := range flag.Args() {
+		if input == stdinInput {
+			return true
+		}
+	}
+	return false
+}
+
+// readSource reads the source in the file name, the one on stdin for
+// the input that is read from it.
+func readSource(name string) ([]byte, error) {
+	if name == stdinInput || name == stdinName {
+		text, err := readStdin()
+		return []byte(text), err
+	}
+	return fs.ReadFile(fsys, name)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+
+	"subc/ast"
+	"subc/compile/arch"
+	"subc/obj"
+	"subc/scan"
+	"subc/types"
+)
+
+// summaryWriter writes the summary of each translation unit for
+// -fsummary, as a line of JSON once its object is written. The symbols
+// and the sections are read from the object, so they are the ones the
+// linker sees, and the declarations of the unit tell which symbols are
+// the functions and the variables of the source.
+type summaryWriter struct {
+	enc   *json.Encoder
+	units map[string]map[string]summaryDecl // the declarations of the inputs compiled, by their symbols
+}
+
+// summaryDecl is a function or a variable defined by a translation unit.
+type summaryDecl struct {
+	name     string
+	function bool
+	size     int // the size of a variable, the functions get theirs from the object
+}
+
+// unitSummary is the summary of a translation unit.
+type unitSummary struct {
+	File      string           `json:"file"`
+	Object    string           `json:"object"`
+	Arch      string           `json:"arch"`
+	Hash      string           `json:"hash"` // the hash of the content of the object, the one of obj.Hash
+	Sections  []sectionSummary `json:"sections"`
+	Defined   []symbolSummary  `json:"defined"`
+	Undefined []string         `json:"undefined"`
+	Functions []funcSummary    `json:"functions"`
+}
+
+// sectionSummary is a section that the unit adds to the image, COMMON
+// for the tentative definitions that the linker puts in the bss.
+type sectionSummary struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// symbolSummary is a symbol that the object defines.
+type symbolSummary struct {
+	Symbol  string `json:"symbol"`
+	Name    string `json:"name,omitempty"`
+	Kind    string `json:"kind"`
+	Section string `json:"section"`
+	Size    int64  `json:"size"`
+	Global  bool   `json:"global"`
+}
+
+// funcSummary is a function of the unit and the bytes of its code, to
+// the next function or the end of its section.
+type funcSummary struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// summaries collects the summaries of the inputs for -fsummary.
+var summaries *summaryWriter
+
+func newSummaryWriter(w io.Writer) *summaryWriter {
+	return &summaryWriter{enc: json.NewEncoder(w), units: make(map[string]map[string]summaryDecl)}
+}
+
+// declare records the functions and the variables that the input defines,
+// by the symbols that the emitter gives them.
+func (s *summaryWriter) declare(input string, prog *ast.Prog, info *types.Info, emitter *arch.Emitter) {
+	decls := make(map[string]summaryDecl)
+	for _, d := range prog.Decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			if d.Body != nil {
+				decls[emitter.Gsym(d.Name.Name)] = summaryDecl{name: d.Name.Name, function: true}
+			}
+		case *ast.VarDecl:
+			if d.Storage != nil && d.Storage.Type == scan.Extern {
+				continue
+			}
+			if v, ok := info.Defs[d.Name].(*types.Var); ok {
+				decls[emitter.Gsym(d.Name.Name)] = summaryDecl{name: d.Name.Name, size: emitter.Sizeof(v.Type())}
+			}
+		}
+	}
+	s.units[input] = decls
+}
+
+// write writes the summary of the input compiled to object.
+func (s *summaryWriter) write(input, object string) error {
+	buf, err := fs.ReadFile(fsys, object)
+	if err != nil {
+		return err
+	}
+	f, err := elf.NewFile(bytes.NewReader(buf))
+	if err != nil {
+		return fmt.Errorf("%s: %v", object, err)
+	}
+	syms, err := f.Symbols()
+	if err != nil && err != elf.ErrNoSymbols {
+		return fmt.Errorf("%s: %v", object, err)
+	}
+
+	hash, err := obj.Hash(buf)
+	if err != nil {
+		return fmt.Errorf("%s: %v", object, err)
+	}
+
+	decls := s.units[input]
+	u := &unitSummary{File: input, Object: object, Arch: flags.Arch, Hash: hash, Undefined: []string{}}
+	for _, sect := range f.Sections {
+		if sect.Flags&elf.SHF_ALLOC != 0 {
+			u.Sections = append(u.Sections, sectionSummary{sect.Name, int64(sect.Size)})
+		}
+	}
+
+	// the code of a function runs to the next function of its section,
+	// the labels of the code in between are symbols of the object too
+	var funcs []elf.Symbol
+	var common int64
+	for _, sym := range syms {
+		d, declared := decls[sym.Name]
+		switch {
+		case sym.Section == elf.SHN_UNDEF:
+			if sym.Name != "" {
+				u.Undefined = append(u.Undefined, sym.Name)
+			}
+			continue
+		case !declared:
+			continue
+		case d.function:
+			funcs = append(funcs, sym)
+			continue
+		}
+
+		x := symbolSummary{Symbol: sym.Name, Name: d.name, Kind: "variable", Size: int64(d.size), Global: elf.ST_BIND(sym.Info) == elf.STB_GLOBAL}
+		switch {
+		case sym.Section == elf.SHN_COMMON:
+			x.Section = "COMMON"
+			common = align(common, int64(sym.Value)) + int64(sym.Size)
+		case int(sym.Section) < len(f.Sections):
+			x.Section = f.Sections[sym.Section].Name
+		}
+		u.Defined = append(u.Defined, x)
+	}
+	if common > 0 {
+		u.Sections = append(u.Sections, sectionSummary{"COMMON", common})
+	}
+
+	sort.SliceStable(funcs, func(i, j int) bool {
+		if funcs[i].Section != funcs[j].Section {
+			return funcs[i].Section < funcs[j].Section
+		}
+		return funcs[i].Value < funcs[j].Value
+	})
+	for i, sym := range funcs {
+		end := f.Sections[sym.Section].Size
+		if i+1 < len(funcs) && funcs[i+1].Section == sym.Section {
+			end = funcs[i+1].Value
+		}
+		d := decls[sym.Name]
+		size := int64(end - sym.Value)
+		u.Defined = append(u.Defined, symbolSummary{Symbol: sym.Name, Name: d.name, Kind: "function", Section: f.Sections[sym.Section].Name, Size: size, Global: elf.ST_BIND(sym.Info) == elf.STB_GLOBAL})
+		u.Functions = append(u.Functions, funcSummary{d.name, size})
+	}
+	return s.enc.Encode(u)
+}
+
+// align returns the smallest y >= x such that y % a == 0, a is at least 1.
+func align(x, a int64) int64 {
+	if a < 1 {
+		a = 1
+	}
+	y := x + a - 1
+	return y - y%a
+}
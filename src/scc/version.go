@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// version is the version of gosubc, set by the Makefile with
+// -ldflags "-X main.version=..." from git describe.
+var version = "devel"
+
+// target returns the target triple that the code is compiled for.
+func target() string {
+	machine := flags.Arch
+	switch flags.Arch {
+	case "amd64":
+		machine = "x86_64"
+	case "arm6":
+		machine = "armv6"
+	case "8086":
+		machine = "i8086"
+	}
+	return machine + "-" + flags.OS
+}
+
+// ident returns the text recorded in the .comment section of the
+// objects, it has the version, the target and the options given
+// that change the code. The options naming files are left out so
+// the objects of a build don't depend on where it was made.
+func ident() string {
+	text := []string{"gosubc", version, target()}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "o", "T", "I", "R", "c", "S", "v", "html", "root",
+			"cpuprofile", "memprofile", "stack-report", "errors",
+			"image-base", "max-image-size", "cache", "daemon", "connect", "fdump-ir",
+			"fsummary", "fdiagnostics-summary":
+			return
+		}
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			if f.Value.String() == "true" {
+				text = append(text, "-"+f.Name)
+			}
+			return
+		}
+		if m, ok := f.Value.(*MultiFlag); ok {
+			for _, v := range *m {
+				text = append(text, "-"+f.Name+" "+v)
+			}
+			return
+		}
+		text = append(text, "-"+f.Name+" "+f.Value.String())
+	})
+	return strings.Join(text, " ")
+}
+
+// printVersion prints the version and the target to w, with -v it
+// also prints where the compiler looks for the runtime and the
+// includes and the commands it runs.
+func printVersion(w io.Writer) {
+	fmt.Fprintf(w, "scc version %s %s\n", version, target())
+	if !flags.Verbose {
+		return
+	}
+	fmt.Fprintf(w, "root: %s\n", flags.RootDir)
+	fmt.Fprintf(w, "runtime: %s\n", flags.RuntimeDir)
+	if dir, err := cacheDir(); err == nil {
+		fmt.Fprintf(w, "cache: %s\n", dir)
+	}
+	for _, include := range flags.Includes {
+		fmt.Fprintf(w, "include: %s\n", include)
+	}
+	for _, env := range [][2]string{{"CPP", "cpp"}, {"AS", "as"}, {"LD", "ld"}, {"OBJCOPY", "objcopy"}} {
+		fmt.Fprintf(w, "%s: %s\n", env[0], strings.Join(getCmdArgs(env[0], env[1]), " "))
+	}
+}
+
+// echo prints a command that is run with -v.
+func echo(args []string) {
+	if flags.Verbose {
+		fmt.Fprintln(os.Stderr, strings.Join(args, " "))
+	}
+}
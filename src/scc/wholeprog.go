@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"subc/ast"
+	"subc/compile/arch"
+	"subc/types"
+)
+
+// With -fwhole-program all of the inputs are parsed and type checked
+// before any is compiled, and the globals that can't be reached from
+// the roots of the program are left out of the objects. The roots are
+// main and the hooks of -finstrument-functions when the program is
+// linked, or all of the public globals when the objects are kept, as
+// something else links them. A global is reached by naming it in a
+// function or an initializer that is reached, so a function stored in
+// a table that is reached is kept whether it is called or not. It is a
+// simpler complement to the --gc-sections of the linker, the code of
+// the globals left out is never generated.
+
+// wholeProgram is the program of -fwhole-program, nil without it.
+var wholeProgram *program
+
+// program is the inputs of a whole-program compile and the globals of
+// them that are reached.
+type program struct {
+	units map[string]*unit    // the inputs, by their names
+	refs  map[string][]string // the globals named by the definition of each global
+	live  map[string]bool     // the globals reached from the roots
+}
+
+// unit is a parsed and type checked input.
+type unit struct {
+	prog *ast.Prog
+	info *types.Info
+}
+
+// loadProgram parses and type checks the inputs, printing their errors,
+// and finds the globals that are reached. It fails if any input does.
+func loadProgram(ctx context.Context, inputs []string) (*program, error) {
+	emitter, err := newArchEmitter(arch.NewTextSink(ioutil.Discard))
+	if err != nil {
+		return nil, err
+	}
+
+	p := &program{
+		units: make(map[string]*unit),
+		refs:  make(map[string][]string),
+		live:  make(map[string]bool),
+	}
+	failed := false
+	for _, input := range inputs {
+		input = filepath.Clean(input)
+		err := guard(input, func() error {
+			scanner, err := newScanner(ctx, input, nil)
+			if err != nil {
+				return err
+			}
+			prog, info, err := parseAndTypecheck(scanner, emitter)
+			if err != nil {
+				return err
+			}
+			p.units[input] = &unit{prog, info}
+			p.add(input, prog, info)
+			return nil
+		})
+		if err != nil {
+			report(err)
+			failed = true
+		}
+	}
+	if failed {
+		return nil, fmt.Errorf("-fwhole-program: not all of the inputs could be compiled")
+	}
+
+	for _, root := range p.roots() {
+		p.mark(root)
+	}
+	return p, nil
+}
+
+// add adds the globals defined by input and the globals they name.
+func (p *program) add(input string, prog *ast.Prog, info *types.Info) {
+	for _, d := range prog.Decls {
+		obj := info.Defs[declIdent(d)]
+		if obj == nil {
+			continue
+		}
+		key := globalKey(input, obj)
+		if _, found := p.refs[key]; !found {
+			p.refs[key] = nil
+		}
+		ast.Inspect(d, func(n ast.Node) bool {
+			x, ok := n.(*ast.Ident)
+			if !ok {
+				return true
+			}
+			if obj := info.Uses[x]; obj != nil && global(obj) {
+				p.refs[key] = append(p.refs[key], globalKey(input, obj))
+			}
+			return true
+		})
+	}
+}
+
+// roots returns the globals that are reached from outside the program.
+func (p *program) roots() []string {
+	if flags.CompileOnly || flags.PrintAsm {
+		var roots []string
+		for input, u := range p.units {
+			for _, d := range u.prog.Decls {
+				if obj := u.info.Defs[declIdent(d)]; obj != nil && public(obj) {
+					roots = append(roots, globalKey(input, obj))
+				}
+			}
+		}
+		return roots
+	}
+
+	roots := []string{"main"}
+	if flags.Instrument {
+		roots = append(roots, "__cyg_profile_func_enter", "__cyg_profile_func_exit")
+	}
+	return roots
+}
+
+// mark marks key and the globals it reaches as live.
+func (p *program) mark(key string) {
+	if p.live[key] {
+		return
+	}
+	p.live[key] = true
+	for _, ref := range p.refs[key] {
+		p.mark(ref)
+	}
+}
+
+// omitted returns the globals of input that aren't reached, which its
+// object leaves out.
+func (p *program) omitted(input string) map[string]bool {
+	u := p.units[input]
+	omit := make(map[string]bool)
+	for _, d := range u.prog.Decls {
+		if obj := u.info.Defs[declIdent(d)]; obj != nil && !p.live[globalKey(input, obj)] {
+			omit[obj.Name()] = true
+		}
+	}
+	return omit
+}
+
+// declIdent returns the name declared by a variable or a function
+// declaration, nil for the other declarations.
+func declIdent(d ast.Decl) *ast.Ident {
+	switch d := d.(type) {
+	case *ast.VarDecl:
+		return d.Name
+	case *ast.FuncDecl:
+		return d.Name
+	}
+	return nil
+}
+
+// globalKey returns the name of obj in the whole program, the statics
+// of an input are told apart from the ones of the others by its name.
+func globalKey(input string, obj types.Object) string {
+	if public(obj) {
+		return obj.Name()
+	}
+	return input + ":" + obj.Name()
+}
+
+// global reports whether obj is a function or a variable outside of
+// the functions.
+func global(obj types.Object) bool {
+	switch obj := obj.(type) {
+	case *types.Var:
+		return obj.Storage() != types.Auto && obj.Storage() != types.LocalStatic
+	case *types.Func, *types.Fwrd:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,1043 @@
+package asm
+
+import (
+	"math/bits"
+	"strings"
+
+	"subc/obj"
+)
+
+const (
+	opA64ADD op = iota + 300
+	opA64ADDS
+	opA64ADR
+	opA64ADRP
+	opA64AND
+	opA64ANDS
+	opA64ASR
+	opA64B
+	opA64BCOND
+	opA64BIC
+	opA64BL
+	opA64BLR
+	opA64BR
+	opA64BRK
+	opA64CBNZ
+	opA64CBZ
+	opA64CSEL
+	opA64CSINC
+	opA64CSINV
+	opA64CSNEG
+	opA64EOR
+	opA64EXTR
+	opA64LDP
+	opA64LDR
+	opA64LDRB
+	opA64LDRH
+	opA64LDRSB
+	opA64LDRSH
+	opA64LDRSW
+	opA64LSL
+	opA64LSR
+	opA64MADD
+	opA64MOVK
+	opA64MOVN
+	opA64MOVZ
+	opA64MSUB
+	opA64ORN
+	opA64ORR
+	opA64RET
+	opA64ROR
+	opA64SBFM
+	opA64SDIV
+	opA64SMULH
+	opA64STP
+	opA64STR
+	opA64STRB
+	opA64STRH
+	opA64SUB
+	opA64SUBS
+	opA64SVC
+	opA64UBFM
+	opA64UDIV
+	opA64UMULH
+)
+
+// arm64 assembles the A64 instructions of the 64-bit ARM architecture
+// in the syntax of the GNU assembler, where the comments start with //
+// and the immediates with an optional #. The memory operands are [xn],
+// [xn, #off], [xn, #off]!, [xn], #off and [xn, xm, lsl #s], and the
+// address of a symbol is made by adrp and the :lo12: of the symbol in
+// the add or the load or the store after it.
+type arm64 struct {
+	*as
+}
+
+// arm64AddSub are the encodings of the additions and the subtractions,
+// with an immediate, a shifted register and an extended register, the
+// one that takes sp.
+var arm64AddSub = map[string]struct {
+	op            op
+	imm, reg, ext uint32
+	neg           string // the instruction that takes the negated immediate
+}{
+	"add":  {opA64ADD, 0x11000000, 0x0b000000, 0x0b200000, "sub"},
+	"adds": {opA64ADDS, 0x31000000, 0x2b000000, 0x2b200000, "subs"},
+	"sub":  {opA64SUB, 0x51000000, 0x4b000000, 0x4b200000, "add"},
+	"subs": {opA64SUBS, 0x71000000, 0x6b000000, 0x6b200000, "adds"},
+}
+
+// arm64Logic are the encodings of the logical instructions, with a
+// bitmask immediate, 0 if they have none, and a shifted register.
+var arm64Logic = map[string]struct {
+	op       op
+	imm, reg uint32
+}{
+	"and":  {opA64AND, 0x12000000, 0x0a000000},
+	"orr":  {opA64ORR, 0x32000000, 0x2a000000},
+	"eor":  {opA64EOR, 0x52000000, 0x4a000000},
+	"ands": {opA64ANDS, 0x72000000, 0x6a000000},
+	"bic":  {opA64BIC, 0, 0x0a200000},
+	"orn":  {opA64ORN, 0, 0x2a200000},
+}
+
+// arm64Shifts are the shifts of a register operand, by their numbers
+// in the encodings.
+var arm64Shifts = map[string]uint32{"lsl": 0, "lsr": 1, "asr": 2, "ror": 3}
+
+// arm64Conds are the condition codes, the low bit of a code inverts
+// the condition.
+var arm64Conds = map[string]uint32{
+	"eq": 0, "ne": 1, "cs": 2, "hs": 2, "cc": 3, "lo": 3, "mi": 4, "pl": 5,
+	"vs": 6, "vc": 7, "hi": 8, "ls": 9, "ge": 10, "lt": 11, "gt": 12, "le": 13,
+	"al": 14,
+}
+
+// arm64Mem are the loads and the stores of the bytes and the halfwords
+// and ldrsw, by the log2 of the size they access and their opc field.
+// The size of ldr and str is the one of their register.
+var arm64Mem = map[string]struct {
+	op        op
+	size, opc uint32
+}{
+	"strb":  {opA64STRB, 0, 0},
+	"ldrb":  {opA64LDRB, 0, 1},
+	"ldrsb": {opA64LDRSB, 0, 2},
+	"strh":  {opA64STRH, 1, 0},
+	"ldrh":  {opA64LDRH, 1, 1},
+	"ldrsh": {opA64LDRSH, 1, 2},
+	"ldrsw": {opA64LDRSW, 2, 2},
+}
+
+// arm64PageOffs are the relocations of the :lo12: of a symbol in a load
+// or a store, by the log2 of the size it accesses.
+var arm64PageOffs = []obj.RelocKind{obj.RelocPageOff8, obj.RelocPageOff16, obj.RelocPageOff32, obj.RelocPageOff64}
+
+// arm64Reg returns the number of the register name and whether it is
+// one of 64 bits, ok is false if name isn't a register. The number 31
+// is both sp and the zero register, the instruction says which.
+func arm64Reg(name string) (r byte, wide, ok bool) {
+	switch name {
+	case "sp", "xzr":
+		return 31, true, true
+	case "wsp", "wzr":
+		return 31, false, true
+	case "lr":
+		return 30, true, true
+	case "fp":
+		return 29, true, true
+	}
+	if len(name) < 2 || (name[0] != 'x' && name[0] != 'w') {
+		return 0, false, false
+	}
+	n := 0
+	for _, c := range name[1:] {
+		if c < '0' || c > '9' || (n == 0 && c == '0' && len(name) > 2) {
+			return 0, false, false
+		}
+		n = n*10 + int(c-'0')
+	}
+	if n > 30 {
+		return 0, false, false
+	}
+	return byte(n), name[0] == 'x', true
+}
+
+// args parses the comma separated operands of an instruction.
+func (as *arm64) args(line string) [4]addr {
+	var addr [4]addr
+	args := splitArgs(strings.TrimSpace(line))
+	if len(args) > len(addr) {
+		as.errorf("junk at end")
+	}
+	for i, arg := range args {
+		addr[i] = as.arg(strings.TrimSpace(arg))
+	}
+	return addr
+}
+
+// arg decodes an argument: a register, an immediate, a symbol or a
+// symbol plus a constant, the :lo12: of a symbol, the shift of the
+// register before it like lsl #12, or a memory operand.
+func (as *arm64) arg(s string) (a addr) {
+	s = strings.TrimSpace(strings.TrimPrefix(s, "#"))
+	if a, ok := as.literal(s); ok {
+		return a
+	}
+	if strings.HasPrefix(s, "[") {
+		return as.mem(s)
+	}
+	if r, _, ok := arm64Reg(strings.ToLower(s)); ok {
+		a.typ = aREG
+		a.reg = r
+		a.sval = strings.ToLower(s)
+		return a
+	}
+	if f := strings.Fields(s); len(f) == 2 {
+		if _, ok := arm64Shifts[strings.ToLower(f[0])]; ok {
+			a.typ = aSHIFT
+			a.sval = strings.ToLower(f[0])
+			a.ival = as.number(strings.TrimPrefix(f[1], "#"))
+			return a
+		}
+	}
+	if strings.HasPrefix(s, ":lo12:") {
+		a = as.arg(s[len(":lo12:"):])
+		if a.typ != aPTR {
+			as.errorf("invalid argument %q", s)
+		}
+		a.part = obj.RelocPageOff
+		return a
+	}
+	if sym, n, ok := symPlus(s); ok {
+		a.typ = aPTR
+		a.sval = sym
+		a.ival = n
+		return a
+	}
+
+	switch {
+	case isIdent(s):
+		a.typ = aPTR
+		a.sval = s
+	case isNumber(s):
+		a.typ = aINT
+		a.ival = as.number(s)
+	default:
+		as.errorf("invalid argument %q", s)
+	}
+	return
+}
+
+// mem decodes a memory operand, its base is a 64-bit register or sp and
+// the offset is an immediate, the :lo12: of a symbol or a 64-bit index
+// register shifted left by the log2 of the size of the access.
+func (as *arm64) mem(s string) (a addr) {
+	a.typ = aMEM
+	if strings.HasSuffix(s, "!") {
+		a.writeback = true
+		s = strings.TrimSpace(s[:len(s)-1])
+	}
+	if !strings.HasSuffix(s, "]") {
+		as.errorf("invalid memory operand %q", s)
+	}
+	parts := strings.Split(s[1:len(s)-1], ",")
+	if len(parts) > 3 {
+		as.errorf("invalid memory operand %q", s)
+	}
+
+	base := as.arg(strings.TrimSpace(parts[0]))
+	if base.typ != aREG || !as.wide(base) || base.sval == "xzr" {
+		as.errorf("the base of %q is not a 64-bit register", s)
+	}
+	a.reg = base.reg
+	a.sval = base.sval
+
+	if len(parts) > 1 {
+		switch off := as.arg(strings.TrimSpace(parts[1])); {
+		case off.typ == aINT:
+			a.ival = off.ival
+		case off.typ == aPTR && off.part == obj.RelocPageOff:
+			a.iname = off.sval
+			a.ival = off.ival
+			a.part = off.part
+		case off.typ == aREG && as.wide(off) && off.sval != "sp":
+			a.index = off.reg
+			a.scale = 1
+		default:
+			as.errorf("invalid offset in %q", s)
+		}
+	}
+	if len(parts) > 2 {
+		shift := as.arg(strings.TrimSpace(parts[2]))
+		if a.scale == 0 || shift.typ != aSHIFT || shift.sval != "lsl" || shift.ival < 0 || shift.ival > 3 {
+			as.errorf("invalid shift of the index in %q", s)
+		}
+		a.scale = 1 << uint(shift.ival)
+	}
+	if a.writeback && (a.scale != 0 || a.part != obj.RelocNone) {
+		as.errorf("%q can't write back its address", s)
+	}
+	return a
+}
+
+// inst assembles an instruction or a directive.
+func (as *arm64) inst(op_ string, addr [4]addr) bool {
+	unk := func() {
+		as.errorf("unknown argument")
+	}
+	n := 0
+	for n < len(addr) && addr[n].typ != aNONE {
+		n++
+	}
+	nargs := func(want ...int) {
+		for _, w := range want {
+			if n == w {
+				return
+			}
+		}
+		as.errorf("%s takes %d operand(s), got %d", op_, want[0], n)
+	}
+
+	x, y, z, w := addr[0], addr[1], addr[2], addr[3]
+	lop := strings.ToLower(op_)
+	if as.directive(lop, addr) {
+		return true
+	}
+
+	if strings.HasPrefix(lop, "b.") {
+		nargs(1)
+		as.branch(opA64BCOND, addr, x, 0x54000000|as.cond(lop[2:]))
+		as.sect.pc++
+		return true
+	}
+	if d, ok := arm64AddSub[lop]; ok {
+		nargs(3, 4)
+		as.addsub(lop, d.op, addr, x, y, z, w)
+		as.sect.pc++
+		return true
+	}
+	if d, ok := arm64Logic[lop]; ok {
+		nargs(3, 4)
+		as.logic(lop, d.op, addr, x, y, z, w)
+		as.sect.pc++
+		return true
+	}
+	if d, ok := arm64Mem[lop]; ok {
+		nargs(2, 3)
+		size, opc := d.size, d.opc
+		if opc == 2 && !as.wide(x) {
+			if lop == "ldrsw" {
+				as.errorf("ldrsw loads a 64-bit register")
+			}
+			// sign extended to 32 bits
+			opc = 3
+		}
+		if opc < 2 && as.wide(x) {
+			as.errorf("%s takes a 32-bit register", lop)
+		}
+		as.load(d.op, addr, size, opc, x, y, z)
+		as.sect.pc++
+		return true
+	}
+
+	switch lop {
+	case ".abort":
+		return false
+	case ".extern":
+	case ".xword", ".quad", ".dword":
+		as.words(opQUAD, addr, 8)
+	case ".word", ".long":
+		as.ranges(lop, addr[:], 4)
+		as.words(opLONG, addr, 4)
+	case ".hword", ".short":
+		as.ranges(lop, addr[:], 2)
+		as.words(opSHORT, addr, 2)
+	case ".byte":
+		as.ranges(lop, addr[:], 1)
+		as.words(opBYTE, addr, 1)
+	case ".align", ".p2align":
+		if x.ival < 0 || 1<<uint(x.ival) > maxAlign {
+			as.errorf("%s: alignment 2**%d out of range", lop, x.ival)
+		}
+		as.ranges(lop, addr[1:2], 1)
+		as.alignpc(1<<uint(x.ival), as.fill(y))
+	case ".balign":
+		if x.ival <= 0 || x.ival > maxAlign || x.ival&(x.ival-1) != 0 {
+			as.errorf("%s: alignment %d is not a power of 2 up to %d", lop, x.ival, maxAlign)
+		}
+		as.ranges(lop, addr[1:2], 1)
+		as.alignpc(int(x.ival), as.fill(y))
+	case "nop":
+		nargs(0)
+		as.emit(opNOP, addr, uint32(0xd503201f))
+	case "svc", "brk":
+		nargs(1)
+		o, code := opA64SVC, uint32(0xd4000001)
+		if lop == "brk" {
+			o, code = opA64BRK, 0xd4200000
+		}
+		as.emit(o, addr, code|as.imm(lop, x, 0, 0xffff)<<5)
+	case "ret":
+		nargs(0, 1)
+		rn := uint32(30)
+		if n == 1 {
+			rn = as.xreg(x)
+		}
+		as.emit(opA64RET, addr, 0xd65f0000|rn<<5)
+	case "br", "blr":
+		nargs(1)
+		o, code := opA64BR, uint32(0xd61f0000)
+		if lop == "blr" {
+			o, code = opA64BLR, 0xd63f0000
+		}
+		as.emit(o, addr, code|as.xreg(x)<<5)
+	case "b", "bl":
+		nargs(1)
+		if x.typ != aPTR || x.part != obj.RelocNone {
+			unk()
+		}
+		o, reltyp, code := opA64B, obj.RelocBranch, uint32(0x14000000)
+		if lop == "bl" {
+			o, reltyp, code = opA64BL, obj.RelocCall, 0x94000000
+		}
+		as.reloc(o, addr, reltyp, x.sval, x.ival, code)
+	case "cbz", "cbnz":
+		nargs(2)
+		o, code := opA64CBZ, uint32(0x34000000)
+		if lop == "cbnz" {
+			o, code = opA64CBNZ, 0x35000000
+		}
+		as.branch(o, addr, y, code|as.sf(x)|as.r(x, false))
+	case "adr":
+		nargs(2)
+		as.branch(opA64ADR, addr, y, 0x10000000|as.xreg(x))
+	case "adrp":
+		nargs(2)
+		if y.typ != aPTR || y.part != obj.RelocNone {
+			unk()
+		}
+		as.reloc(opA64ADRP, addr, obj.RelocPage, y.sval, y.ival, 0x90000000|as.xreg(x))
+	case "cmp", "cmn":
+		nargs(2, 3)
+		as.regs(x)
+		o := map[string]string{"cmp": "subs", "cmn": "adds"}[lop]
+		as.addsub(o, arm64AddSub[o].op, addr, as.zr(x), x, y, z)
+	case "neg", "negs":
+		nargs(2, 3)
+		as.regs(x)
+		o := map[string]string{"neg": "sub", "negs": "subs"}[lop]
+		if y.typ != aREG {
+			unk()
+		}
+		as.addsub(o, arm64AddSub[o].op, addr, x, as.zr(x), y, z)
+	case "tst":
+		nargs(2, 3)
+		as.regs(x)
+		as.logic("ands", opA64ANDS, addr, as.zr(x), x, y, z)
+	case "mvn":
+		nargs(2, 3)
+		as.regs(x)
+		as.logic("orn", opA64ORN, addr, x, as.zr(x), y, z)
+	case "mov":
+		nargs(2)
+		as.mov(addr, x, y)
+	case "movz", "movn", "movk":
+		nargs(2, 3)
+		o, code := map[string]op{"movz": opA64MOVZ, "movn": opA64MOVN, "movk": opA64MOVK}[lop],
+			map[string]uint32{"movz": 0x52800000, "movn": 0x12800000, "movk": 0x72800000}[lop]
+		hw := uint32(0)
+		if z.typ != aNONE {
+			if z.typ != aSHIFT || z.sval != "lsl" || z.ival%16 != 0 || z.ival < 0 || z.ival >= 32<<(as.sf(x)>>31) {
+				as.errorf("%s: invalid shift of the immediate", lop)
+			}
+			hw = uint32(z.ival / 16)
+		}
+		as.emit(o, addr, code|as.sf(x)|hw<<21|as.imm(lop, y, 0, 0xffff)<<5|as.r(x, false))
+	case "lsl", "lsr", "asr", "ror":
+		nargs(3)
+		as.shift(lop, addr, x, y, z)
+	case "mul", "madd", "msub":
+		ra := as.zr(x)
+		if lop == "mul" {
+			nargs(3)
+		} else {
+			nargs(4)
+			ra = w
+		}
+		o, code := opA64MADD, uint32(0x1b000000)
+		if lop == "msub" {
+			o, code = opA64MSUB, 0x1b008000
+		}
+		sf := as.sf(x, y, z, ra)
+		as.emit(o, addr, code|sf|as.r(z, false)<<16|as.r(ra, false)<<10|as.r(y, false)<<5|as.r(x, false))
+	case "smulh", "umulh":
+		nargs(3)
+		o, code := opA64SMULH, uint32(0x9b407c00)
+		if lop == "umulh" {
+			o, code = opA64UMULH, 0x9bc07c00
+		}
+		if as.sf(x, y, z) == 0 {
+			as.errorf("%s takes 64-bit registers", lop)
+		}
+		as.emit(o, addr, code|as.r(z, false)<<16|as.r(y, false)<<5|as.r(x, false))
+	case "sdiv", "udiv":
+		nargs(3)
+		o, code := opA64SDIV, uint32(0x1ac00c00)
+		if lop == "udiv" {
+			o, code = opA64UDIV, 0x1ac00800
+		}
+		as.emit(o, addr, code|as.sf(x, y, z)|as.r(z, false)<<16|as.r(y, false)<<5|as.r(x, false))
+	case "sxtb", "sxth", "sxtw", "uxtb", "uxth":
+		nargs(2)
+		as.regs(x, y)
+		if as.wide(y) || (lop == "sxtw" && !as.wide(x)) || (lop[0] == 'u' && as.wide(x)) {
+			as.errorf("%s: invalid registers", lop)
+		}
+		o, code := opA64SBFM, uint32(0x13000000)
+		if lop[0] == 'u' {
+			o, code = opA64UBFM, 0x53000000
+		}
+		imms := map[byte]uint32{'b': 7, 'h': 15, 'w': 31}[lop[3]]
+		as.emit(o, addr, as.bitfield(code, as.sf(x), 0, imms)|as.r(y, false)<<5|as.r(x, false))
+	case "csel", "csinc", "csinv", "csneg":
+		nargs(4)
+		o := map[string]op{"csel": opA64CSEL, "csinc": opA64CSINC, "csinv": opA64CSINV, "csneg": opA64CSNEG}[lop]
+		code := map[string]uint32{"csel": 0x1a800000, "csinc": 0x1a800400, "csinv": 0x5a800000, "csneg": 0x5a800400}[lop]
+		as.emit(o, addr, code|as.sf(x, y, z)|as.r(z, false)<<16|as.condArg(w)<<12|as.r(y, false)<<5|as.r(x, false))
+	case "cset", "csetm":
+		nargs(2)
+		o, code := opA64CSINC, uint32(0x1a800400)
+		if lop == "csetm" {
+			o, code = opA64CSINV, 0x5a800000
+		}
+		cond := as.condArg(y)
+		if cond == arm64Conds["al"] {
+			as.errorf("%s can't take al", lop)
+		}
+		as.emit(o, addr, code|as.sf(x)|31<<16|(cond^1)<<12|31<<5|as.r(x, false))
+	case "ldr", "str":
+		nargs(2, 3)
+		as.regs(x)
+		size, opc, o := uint32(2), uint32(0), opA64STR
+		if as.wide(x) {
+			size = 3
+		}
+		if lop == "ldr" {
+			opc, o = 1, opA64LDR
+		}
+		as.load(o, addr, size, opc, x, y, z)
+	case "ldp", "stp":
+		nargs(3, 4)
+		as.pair(lop, addr, x, y, z, w)
+	default:
+		as.errorf("unknown instruction %s", lop)
+	}
+
+	as.sect.pc++
+	return true
+}
+
+// regs checks that the operands are registers.
+func (as *arm64) regs(args ...addr) {
+	for _, a := range args {
+		if a.typ != aREG {
+			as.errorf("unknown argument")
+		}
+	}
+}
+
+// wide reports whether the register a has 64 bits.
+func (as *arm64) wide(a addr) bool {
+	_, wide, _ := arm64Reg(a.sval)
+	return wide
+}
+
+// sf returns the sf bit of the instruction that takes the registers,
+// set when they have 64 bits. They have to have the same size.
+func (as *arm64) sf(regs ...addr) uint32 {
+	as.regs(regs...)
+	for _, a := range regs[1:] {
+		if as.wide(a) != as.wide(regs[0]) {
+			as.errorf("the registers are not of the same size")
+		}
+	}
+	if as.wide(regs[0]) {
+		return 1 << 31
+	}
+	return 0
+}
+
+// r returns the number of the register a in a field that means sp by
+// 31 if sp is set, and the zero register otherwise.
+func (as *arm64) r(a addr, sp bool) uint32 {
+	as.regs(a)
+	isSP := a.sval == "sp" || a.sval == "wsp"
+	isZR := a.sval == "xzr" || a.sval == "wzr"
+	if (sp && isZR) || (!sp && isSP) {
+		as.errorf("%s can't be used here", a.sval)
+	}
+	return uint32(a.reg)
+}
+
+// xreg returns the number of the 64-bit register a.
+func (as *arm64) xreg(a addr) uint32 {
+	if as.sf(a) == 0 {
+		as.errorf("%s is not a 64-bit register", a.sval)
+	}
+	return as.r(a, false)
+}
+
+// zr returns the zero register of the size of the register a.
+func (as *arm64) zr(a addr) addr {
+	if as.wide(a) {
+		return addr{typ: aREG, reg: 31, sval: "xzr"}
+	}
+	return addr{typ: aREG, reg: 31, sval: "wzr"}
+}
+
+// cond returns the number of the condition code name.
+func (as *arm64) cond(name string) uint32 {
+	c, ok := arm64Conds[strings.ToLower(name)]
+	if !ok {
+		as.errorf("unknown condition %q", name)
+	}
+	return c
+}
+
+// condArg returns the number of the condition code of the operand a.
+func (as *arm64) condArg(a addr) uint32 {
+	if a.typ != aPTR || a.ival != 0 {
+		as.errorf("unknown argument")
+	}
+	return as.cond(a.sval)
+}
+
+// imm checks that a is an immediate in [min, max] and returns it.
+func (as *arm64) imm(lop string, a addr, min, max int64) uint32 {
+	if a.typ != aINT {
+		as.errorf("%s takes an immediate", lop)
+	}
+	if a.ival < min || a.ival > max {
+		as.errorf("%s: immediate %d out of range [%d, %d]", lop, a.ival, min, max)
+	}
+	return uint32(a.ival)
+}
+
+// shiftOf returns the type and the amount of the shift of a register
+// operand, none if shift isn't given.
+func (as *arm64) shiftOf(shift addr, sf uint32, ror bool) (typ, amount uint32) {
+	if shift.typ == aNONE {
+		return 0, 0
+	}
+	t, ok := arm64Shifts[shift.sval]
+	if shift.typ != aSHIFT || !ok || (t == 3 && !ror) {
+		as.errorf("invalid shift of the register")
+	}
+	width := int64(32) << (sf >> 31)
+	if shift.ival < 0 || shift.ival >= width {
+		as.errorf("shift amount %d out of range [0, %d]", shift.ival, width-1)
+	}
+	return t, uint32(shift.ival)
+}
+
+// addsub encodes an addition or a subtraction of rd, rn and m, which is
+// an immediate with an optional lsl #12, the :lo12: of a symbol for an
+// add, or a register shifted by shift. A negative immediate is taken
+// by the opposite instruction, the way the GNU assembler does.
+func (as *arm64) addsub(lop string, op op, addr [4]addr, rd, rn, m, shift addr) {
+	d := arm64AddSub[lop]
+	sp := !strings.HasSuffix(lop, "s")
+	switch m.typ {
+	case aINT:
+		sf := as.sf(rd, rn)
+		v, sh := m.ival, uint32(0)
+		switch {
+		case shift.typ != aNONE:
+			if shift.typ != aSHIFT || shift.sval != "lsl" || (shift.ival != 0 && shift.ival != 12) {
+				as.errorf("%s: the immediate is shifted by lsl #0 or lsl #12", lop)
+			}
+			sh = uint32(shift.ival / 12)
+		case v < 0 && v > -1<<24:
+			d = arm64AddSub[d.neg]
+			op, v = d.op, -v
+		}
+		if sh == 0 && v > 0xfff && v&0xfff == 0 {
+			sh, v = 1, v>>12
+		}
+		if v < 0 || v > 0xfff {
+			as.errorf("%s: immediate %d out of range", lop, m.ival)
+		}
+		as.emit(op, addr, d.imm|sf|sh<<22|uint32(v)<<10|as.r(rn, true)<<5|as.r(rd, sp))
+	case aPTR:
+		if m.part != obj.RelocPageOff || lop != "add" || shift.typ != aNONE {
+			as.errorf("unknown argument")
+		}
+		as.reloc(op, addr, obj.RelocPageOff, m.sval, m.ival, d.imm|as.sf(rd, rn)|as.r(rn, true)<<5|as.r(rd, sp))
+	case aREG:
+		sf := as.sf(rd, rn, m)
+		rdSP := sp && (rd.sval == "sp" || rd.sval == "wsp")
+		if rdSP || rn.sval == "sp" || rn.sval == "wsp" {
+			// the extended register form, lsl is uxtx or uxtw
+			typ, amount := as.shiftOf(shift, sf, false)
+			if typ != 0 || amount > 4 {
+				as.errorf("%s: a register added to sp is shifted by lsl up to #4", lop)
+			}
+			option := uint32(2) | sf>>31
+			as.emit(op, addr, d.ext|sf|as.r(m, false)<<16|option<<13|amount<<10|as.r(rn, true)<<5|as.r(rd, sp))
+			return
+		}
+		typ, amount := as.shiftOf(shift, sf, false)
+		as.emit(op, addr, d.reg|sf|typ<<22|as.r(m, false)<<16|amount<<10|as.r(rn, false)<<5|as.r(rd, false))
+	default:
+		as.errorf("unknown argument")
+	}
+}
+
+// logic encodes a logical instruction of rd, rn and m, which is a
+// bitmask immediate or a register shifted by shift.
+func (as *arm64) logic(lop string, op op, addr [4]addr, rd, rn, m, shift addr) {
+	d := arm64Logic[lop]
+	switch m.typ {
+	case aINT:
+		sf := as.sf(rd, rn)
+		if d.imm == 0 || shift.typ != aNONE {
+			as.errorf("%s: unknown argument", lop)
+		}
+		n, immr, imms, ok := arm64Bitmask(uint64(m.ival), sf != 0)
+		if !ok {
+			as.errorf("%s: immediate %#x can't be encoded as a bitmask", lop, m.ival)
+		}
+		as.emit(op, addr, d.imm|sf|n<<22|immr<<16|imms<<10|as.r(rn, false)<<5|as.r(rd, lop != "ands"))
+	case aREG:
+		sf := as.sf(rd, rn, m)
+		typ, amount := as.shiftOf(shift, sf, true)
+		as.emit(op, addr, d.reg|sf|typ<<22|as.r(m, false)<<16|amount<<10|as.r(rn, false)<<5|as.r(rd, false))
+	default:
+		as.errorf("unknown argument")
+	}
+}
+
+// arm64Bitmask returns the encoding of v as the bitmask immediate of a
+// logical instruction on 64 bits or on 32 if wide isn't set: a pattern
+// of 2, 4, 8, 16, 32 or 64 bits repeated over the register, which is a
+// run of ones rotated right by immr. It reports whether v is one.
+func arm64Bitmask(v uint64, wide bool) (n, immr, imms uint32, ok bool) {
+	if !wide {
+		v = v&0xffffffff | v<<32
+	}
+	if v == 0 || v == ^uint64(0) {
+		return 0, 0, 0, false
+	}
+	size := uint(64)
+	for size > 2 {
+		half := size / 2
+		mask := uint64(1)<<half - 1
+		if v&mask != v>>half&mask {
+			break
+		}
+		size = half
+	}
+	mask := ^uint64(0) >> (64 - size)
+	e := v & mask
+	ones := bits.OnesCount64(e)
+	run := uint64(1)<<uint(ones) - 1
+	for r := uint(0); r < size; r++ {
+		if (e>>r|e<<(size-r))&mask == run {
+			immr = uint32((size - r) % size)
+			imms = uint32(^(size-1)<<1|uint(ones-1)) & 0x3f
+			if size == 64 {
+				n = 1
+			}
+			return n, immr, imms, true
+		}
+	}
+	return 0, 0, 0, false
+}
+
+// mov encodes a move of a register or an immediate to the register x,
+// an immediate is moved by movz, movn or orr, the first of them that
+// can encode it.
+func (as *arm64) mov(addr [4]addr, x, y addr) {
+	switch y.typ {
+	case aREG:
+		sf := as.sf(x, y)
+		if x.sval == "sp" || x.sval == "wsp" || y.sval == "sp" || y.sval == "wsp" {
+			as.emit(opA64ADD, addr, 0x11000000|sf|as.r(y, true)<<5|as.r(x, true))
+			return
+		}
+		as.emit(opA64ORR, addr, 0x2a0003e0|sf|as.r(y, false)<<16|as.r(x, false))
+	case aINT:
+		sf := as.sf(x)
+		v, width := uint64(y.ival), uint(64)
+		if sf == 0 {
+			if y.ival < -1<<31 || y.ival > 1<<32-1 {
+				as.errorf("mov: immediate %d out of range", y.ival)
+			}
+			v, width = v&0xffffffff, 32
+		}
+		mask := ^uint64(0) >> (64 - width)
+		for hw := uint(0); hw < width; hw += 16 {
+			if v&^(0xffff<<hw) == 0 {
+				as.emit(opA64MOVZ, addr, 0x52800000|sf|uint32(hw/16)<<21|uint32(v>>hw&0xffff)<<5|as.r(x, false))
+				return
+			}
+			if nv := ^v & mask; nv&^(0xffff<<hw) == 0 {
+				as.emit(opA64MOVN, addr, 0x12800000|sf|uint32(hw/16)<<21|uint32(nv>>hw&0xffff)<<5|as.r(x, false))
+				return
+			}
+		}
+		if n, immr, imms, ok := arm64Bitmask(v, sf != 0); ok {
+			as.emit(opA64ORR, addr, 0x320003e0|sf|n<<22|immr<<16|imms<<10|as.r(x, true))
+			return
+		}
+		as.errorf("mov: immediate %#x can't be moved by one instruction, use movz and movk", y.ival)
+	default:
+		as.errorf("mov: unknown argument, the address of a symbol is made by adrp and add")
+	}
+}
+
+// bitfield encodes the bitfield move of code with immr and imms, the N
+// bit is set with the sf bit.
+func (as *arm64) bitfield(code, sf, immr, imms uint32) uint32 {
+	return code | sf | sf>>9 | immr<<16 | imms<<10
+}
+
+// shift encodes a shift of y into x by the register or the immediate z,
+// the shifts by an immediate are bitfield moves.
+func (as *arm64) shift(lop string, addr [4]addr, x, y, z addr) {
+	o := map[string]op{"lsl": opA64LSL, "lsr": opA64LSR, "asr": opA64ASR, "ror": opA64ROR}[lop]
+	switch z.typ {
+	case aREG:
+		sf := as.sf(x, y, z)
+		as.emit(o, addr, 0x1ac02000|sf|arm64Shifts[lop]<<10|as.r(z, false)<<16|as.r(y, false)<<5|as.r(x, false))
+	case aINT:
+		sf := as.sf(x, y)
+		width := uint32(32) << (sf >> 31)
+		s := as.imm(lop, z, 0, int64(width)-1)
+		rd, rn := as.r(x, false), as.r(y, false)
+		switch lop {
+		case "lsl":
+			as.emit(o, addr, as.bitfield(0x53000000, sf, (width-s)%width, width-1-s)|rn<<5|rd)
+		case "lsr":
+			as.emit(o, addr, as.bitfield(0x53000000, sf, s, width-1)|rn<<5|rd)
+		case "asr":
+			as.emit(o, addr, as.bitfield(0x13000000, sf, s, width-1)|rn<<5|rd)
+		case "ror":
+			// extr of the register with itself
+			as.emit(opA64EXTR, addr, 0x13800000|sf|sf>>9|rn<<16|s<<10|rn<<5|rd)
+		}
+	default:
+		as.errorf("unknown argument")
+	}
+}
+
+// load encodes a load or a store of the register t at the memory operand
+// m of the access of 1<<size bytes, post is the immediate added to the
+// base after the access. An offset that isn't a multiple of the size or
+// is negative is encoded unscaled, as ldur and stur.
+func (as *arm64) load(op op, addr [4]addr, size, opc uint32, t, m, post addr) {
+	if m.typ != aMEM {
+		as.errorf("unknown argument")
+	}
+	rt, rn := as.r(t, false), uint32(m.reg)
+	code := size<<30 | opc<<22 | rn<<5 | rt
+	switch {
+	case post.typ != aNONE:
+		if post.typ != aINT || m.ival != 0 || m.scale != 0 || m.part != obj.RelocNone || m.writeback {
+			as.errorf("invalid post-indexed address")
+		}
+		as.emit(op, addr, 0x38000400|code|(as.imm("the post-index", post, -256, 255)&0x1ff)<<12)
+	case m.writeback:
+		as.emit(op, addr, 0x38000c00|code|(as.imm("the pre-index", immediate(m.ival), -256, 255)&0x1ff)<<12)
+	case m.scale != 0:
+		s := uint32(bits.TrailingZeros64(uint64(m.scale)))
+		if s != 0 && s != size {
+			as.errorf("the index is shifted by lsl #0 or lsl #%d", size)
+		}
+		if s != 0 {
+			s = 1
+		}
+		as.emit(op, addr, 0x38206800|code|uint32(m.index)<<16|s<<12)
+	case m.part != obj.RelocNone:
+		as.reloc(op, addr, arm64PageOffs[size], m.iname, m.ival, 0x39000000|code)
+	case m.ival >= 0 && m.ival&(1<<size-1) == 0 && m.ival>>size <= 0xfff:
+		as.emit(op, addr, 0x39000000|code|uint32(m.ival>>size)<<10)
+	default:
+		as.emit(op, addr, 0x38000000|code|(as.imm("the offset", immediate(m.ival), -256, 255)&0x1ff)<<12)
+	}
+}
+
+// immediate returns the operand of the immediate v.
+func immediate(v int64) addr {
+	return addr{typ: aINT, ival: v}
+}
+
+// pair encodes ldp or stp of the registers x and y at the memory operand
+// m, post is the immediate added to the base after the access.
+func (as *arm64) pair(lop string, addr [4]addr, x, y, m, post addr) {
+	sf := as.sf(x, y)
+	if m.typ != aMEM || m.scale != 0 || m.part != obj.RelocNone {
+		as.errorf("unknown argument")
+	}
+	o, code := opA64STP, uint32(0x28000000)
+	if lop == "ldp" {
+		o, code = opA64LDP, 0x28400000
+	}
+	scale := uint(2)
+	if sf != 0 {
+		code |= 2 << 30
+		scale = 3
+	}
+	off := m.ival
+	switch {
+	case post.typ != aNONE:
+		if post.typ != aINT || m.ival != 0 || m.writeback {
+			as.errorf("invalid post-indexed address")
+		}
+		code |= 0x00800000
+		off = post.ival
+	case m.writeback:
+		code |= 0x01800000
+	default:
+		code |= 0x01000000
+	}
+	if off&(1<<scale-1) != 0 {
+		as.errorf("%s: offset %d is not a multiple of %d", lop, off, 1<<scale)
+	}
+	imm := as.imm(lop, immediate(off>>scale), -64, 63) & 0x7f
+	as.emit(o, addr, code|imm<<15|as.r(y, false)<<10|uint32(m.reg)<<5|as.r(x, false))
+}
+
+// fill returns the fill of the padding of an align directive with the
+// fill value y. Without a fill value the padding of the code is made of
+// nops, after the zeros that align it to an instruction.
+func (as *arm64) fill(y addr) func(n int) []byte {
+	if y.typ != aNONE || as.sect.flags&sfExec == 0 {
+		return fillValue(uint8(y.ival))
+	}
+	return func(n int) []byte {
+		buf := make([]byte, n%4, n)
+		for len(buf) < n {
+			buf = append(buf, as.code(uint32(0xd503201f))...)
+		}
+		return buf
+	}
+}
+
+// reloc emits the instruction code whose field is relocated by reltyp
+// with the address of the symbol name plus addend. The field is left
+// at zero, the relocation has the addend.
+func (as *arm64) reloc(op op, addr [4]addr, reltyp obj.RelocKind, name string, addend int64, code uint32) {
+	as.addrel(op, addr)
+	p := as.relocs[len(as.relocs)-1]
+	p.code = as.code(code)
+	p.isize = len(p.code)
+	p.reltyp = reltyp
+	p.relname = name
+	p.addend = addend
+	p.rel = p.off
+	as.sect.size += int64(p.isize)
+}
+
+// branch emits a conditional branch or an adr to the label l, the
+// offset is filled in when the label is known by finish.
+func (as *arm64) branch(op op, addr [4]addr, l addr, code uint32) {
+	if l.typ != aPTR || l.part != obj.RelocNone {
+		as.errorf("a branch takes a label")
+	}
+	as.reloc(op, addr, obj.RelocNone, l.sval, l.ival, code)
+}
+
+// words emits the integers and the addresses of symbols of a directive
+// in size bytes each, the addresses are relocated.
+func (as *arm64) words(op op, addr [4]addr, size int) {
+	for _, a := range addr {
+		switch a.typ {
+		case aNONE:
+			return
+		case aINT:
+			as.emit(op, addr, obj.Value(as.endian, size, a.ival))
+		case aPTR:
+			if size != 8 || a.part != obj.RelocNone {
+				as.errorf("an address takes 8 bytes")
+			}
+			as.addrel(op, addr)
+			p := as.relocs[len(as.relocs)-1]
+			p.code = make([]byte, 8)
+			p.isize = 8
+			p.reltyp = obj.RelocData
+			p.relname = a.sval
+			p.addend = a.ival
+			p.rel = p.off
+			as.sect.size += 8
+		default:
+			as.errorf("unknown argument")
+		}
+	}
+}
+
+// finish resolves the branches to the labels of their sections and
+// the symbols of the relocations left for the linker.
+func (as *arm64) finish() {
+	as.fixupRelocs(as.text)
+	as.fixupRelocs(as.data)
+	for _, s := range as.sects {
+		as.fixupRelocs(s)
+	}
+	as.fixupBSS()
+	for _, s := range as.sections() {
+		if s.size > as.max {
+			as.errorf("section %s of %d bytes is larger than the maximum of %d bytes", s.name, s.size, as.max)
+		}
+	}
+}
+
+// fixupRelocs puts the offsets of the branches of s in their code, the
+// conditional branches and adr have to go to a label of s and b and bl
+// go to the other symbols through the linker. A branch is relative to
+// itself and counts the instructions, adr counts the bytes.
+func (as *arm64) fixupRelocs(s *section) {
+	for i := 0; i < len(s.relocs); {
+		p := s.relocs[i]
+		l := as.fsym(aPTR, p.relname)
+		switch p.reltyp {
+		case obj.RelocNone:
+		case obj.RelocBranch, obj.RelocCall:
+			if l.typ != obj.SymLabel || l.sect != s || l.exported {
+				i++
+				continue
+			}
+		default:
+			i++
+			continue
+		}
+		if l.typ != obj.SymLabel || l.sect != s {
+			as.errorf("branch to %q, which is not a label of %s", p.relname, s.name)
+		}
+
+		off := l.off + p.addend - p.off
+		v := as.endian.Uint32(p.code)
+		switch p.op {
+		case opA64B, opA64BL:
+			as.inRange(p.relname, off, 1<<27)
+			v |= uint32(off>>2) & 0x3ffffff
+		case opA64ADR:
+			as.inRange(p.relname, off, 1<<20)
+			v |= uint32(off)&3<<29 | uint32(off>>2)&0x7ffff<<5
+		default:
+			as.inRange(p.relname, off, 1<<20)
+			v |= uint32(off>>2) & 0x7ffff << 5
+		}
+		as.endian.PutUint32(p.code, v)
+		s.relocs = append(s.relocs[:i], s.relocs[i+1:]...)
+	}
+}
+
+// inRange checks that the offset off to the label name is in the range
+// [-max, max) of the branch.
+func (as *arm64) inRange(name string, off, max int64) {
+	if off < -max || off >= max {
+		as.errorf("branch to %q out of range", name)
+	}
+}
@@ -4,15 +4,34 @@ package asm
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"unicode"
+
+	"subc/obj"
 )
 
+// Config is the configuration of the assembler.
+type Config struct {
+	Arch           string // the architecture to assemble for
+	OS             string // the os whose object file format is written
+	MaxSectionSize int64  // the largest size of a section in bytes, DefaultMaxSectionSize if 0
+}
+
+// DefaultMaxSectionSize is the largest size of a section unless the
+// configuration says otherwise, the code addresses the sections with
+// signed 32-bit displacements so they can't be any larger.
+const DefaultMaxSectionSize = 1<<31 - 1
+
 // Assemble assembles an operation.
-func Assemble(arch, os_, input string, output io.Writer, src []byte) (err error) {
+// The assembly stops early with the context error if ctx is canceled.
+func Assemble(ctx context.Context, conf Config, input string, output io.Writer, src []byte) (err error) {
 	defer func() {
 		if e := recover(); e != nil {
 			err = e.(error)
@@ -21,20 +40,251 @@ func Assemble(arch, os_, input string, output io.Writer, src []byte) (err error)
 			}
 		}
 	}()
-	prog := newprog(arch, os_)
-	switch arch {
+	a := &as{prog: newprog(conf), ctx: ctx, file: input}
+	arch, err := newAssembler(a)
+	if err != nil {
+		return err
+	}
+	a.assemble(arch, src)
+
+	return writeobj(output, a.prog)
+}
+
+// assembler is the part of the assembler that knows the instructions
+// of an architecture, the symbols, the sections and the directives
+// that every architecture has are kept by as.
+type assembler interface {
+	// args parses the comma separated operands of an instruction.
+	args(line string) [4]addr
+
+	// inst assembles an instruction or a directive with its parsed
+	// operands, it returns false when it ends the assembly.
+	inst(op string, addr [4]addr) bool
+
+	// finish resolves the relocations once all the instructions
+	// have been assembled.
+	finish()
+}
+
+// newAssembler returns the assembler of the architecture of a.
+func newAssembler(a *as) (assembler, error) {
+	a.sect = a.text
+	switch a.arch {
 	case "amd64":
-		x86as(prog, input, src)
+		return &x86{as: a, word: 8, rexw: byte(0x48)}, nil
+	case "i386":
+		return &x86{as: a, word: 4}, nil
+	case "mips":
+		// the instructions are words, which have to be aligned
+		a.text.align = 4
+		return &mips{as: a}, nil
+	case "arm64":
+		a.text.align = 4
+		return &arm64{as: a}, nil
+	case "riscv64":
+		a.text.align = 4
+		return &riscv64{as: a}, nil
+	}
+	return nil, fmt.Errorf("unsupported arch %q", a.arch)
+}
+
+// assemble assembles the lines of src with arch.
+func (as *as) assemble(arch assembler, src []byte) {
+	s := bufio.NewScanner(bytes.NewReader(src))
+	for as.lineno = 1; s.Scan(); as.lineno++ {
+		as.line = strings.TrimSpace(s.Text())
+		if !as.source(arch, as.line) {
+			break
+		}
+	}
+	as.line = ""
+	if as.defining != nil {
+		as.lineno = as.defining.lineno
+		as.errorf("missing .endm of macro %s", as.defining.name)
+	}
+
+	arch.finish()
+}
+
+// parse assembles one line of source, it returns false
+// when the line ends the assembly.
+func (as *as) parse(arch assembler, line string) bool {
+scan:
+	if line == "" || strings.HasPrefix(line, "#") {
+		return true
+	}
+	if i := as.comment(line); i >= 0 {
+		line = line[:i]
+	}
+
+	for {
+		// the names of the labels have no blanks or commas, unlike
+		// the operands with a colon like :lo12:sym
+		i := unquoted(line, ':')
+		if i > 0 && !strings.ContainsAny(line[:i], " \t,") {
+			as.addlabel(line[:i], as.sect.size, as.sect.pc)
+			line = strings.TrimSpace(line[i+1:])
+			goto scan
+		}
+		break
+	}
+
+	var op_ string
+	fmt.Sscan(line, &op_)
+
+	// the operands of the macros are text, which the lines of their
+	// bodies are parsed with
+	switch m := as.macros[op_]; {
+	case op_ == ".macro":
+		as.defmacro(line[len(op_):])
+		return true
+	case op_ == ".endm":
+		as.errorf(".endm without .macro")
+	case m != nil:
+		return as.expand(arch, m, line[len(op_):])
+	}
+
+	var addr [4]addr
+	if len(line) > len(op_) {
+		addr = arch.args(line[len(op_)+1:])
+	}
+
+	return arch.inst(op_, addr)
+}
+
+// directive handles the directives that are the same on every
+// architecture, it reports whether lop is one of them.
+func (as *as) directive(lop string, addr [4]addr) bool {
+	x, y, z := addr[0], addr[1], addr[2]
+	switch lop {
+	case ".section":
+		as.addsect(x.sval, y.sval, z.sval)
+	case ".text":
+		as.sect = as.text
+	case ".data":
+		as.sect = as.data
+	case ".ascii", ".asciz", ".string":
+		as.ascii(lop, addr)
+	case ".lcomm":
+		as.size(lop, y)
+		as.addbss(x.sval, y.ival, as.bssalign(lop, z), true)
+	case ".comm":
+		as.size(lop, y)
+		as.addbss(x.sval, y.ival, as.bssalign(lop, z), false)
+	case ".local":
+		as.addlocal(x.sval)
+	case ".globl":
+		as.addglobal(x.sval)
+	case ".ident":
+		if x.typ != aSTR {
+			as.errorf("%s takes a string", lop)
+		}
+		as.idents = append(as.idents, x.sval)
+	default:
+		return false
+	}
+	return true
+}
+
+// ascii emits the strings of a string directive, .ascii emits their
+// bytes and .asciz and .string end each one with a nul.
+func (as *as) ascii(dir string, addr [4]addr) {
+	for _, a := range addr {
+		switch a.typ {
+		case aNONE:
+			return
+		case aSTR:
+			if dir == ".ascii" {
+				as.sect.chars(a.sval)
+			} else {
+				as.sect.strz(a.sval)
+			}
+		default:
+			as.errorf("%s takes strings", dir)
+			return
+		}
+	}
+}
+
+// comment returns the index of the comment that ends line, -1 if it
+// has none. The comments start with #, but on arm64, where # starts an
+// immediate, they start with // and only a line that starts with # is a
+// comment.
+func (as *as) comment(line string) int {
+	if as.arch != "arm64" {
+		return unquoted(line, '#')
+	}
+	for i := 0; ; {
+		j := unquoted(line[i:], '/')
+		if j < 0 {
+			return -1
+		}
+		if i+j+1 < len(line) && line[i+j+1] == '/' {
+			return i + j
+		}
+		i += j + 1
+	}
+}
+
+// unquoted returns the index of the first c in line that isn't in a
+// string or a character constant, -1 if there is none.
+func unquoted(line string, c byte) int {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch ch := line[i]; {
+		case quote != 0:
+			if ch == '\\' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == c:
+			return i
+		case ch == '"' || ch == '\'':
+			quote = ch
+		}
+	}
+	return -1
+}
+
+// literal decodes the arguments written the same way on every
+// architecture: section names, strings, characters and the types
+// of .section. It reports whether s is one of them.
+func (as *as) literal(s string) (a addr, ok bool) {
+	switch {
+	case strings.HasPrefix(s, ".") && !strings.HasPrefix(s, localPrefix):
+		a.typ = aSECT
+		a.sval = s
+	case strings.HasPrefix(s, "\""):
+		str, err := obj.Unquote(s)
+		if err != nil {
+			as.errorf("invalid string %s: %v", s, err)
+		}
+		a.typ = aSTR
+		a.sval = str
+	case strings.HasPrefix(s, "'"):
+		a.typ = aINT
+		a.ival = as.char(s)
+	case strings.HasPrefix(s, "@"):
+		a.typ = aNOTE
+		a.sval = s[1:]
 	default:
-		return fmt.Errorf("unsupported arch %q", arch)
+		return a, false
 	}
+	return a, true
+}
 
+// writeobj writes the assembled program as an object
+// file in the format used by the program os.
+func writeobj(output io.Writer, prog *prog) error {
 	w := bufio.NewWriter(output)
-	switch os_ {
-	case "linux":
+	switch prog.os {
+	case "linux", "freebsd", "netbsd", "openbsd":
 		genelf(w, prog)
+	case "windows":
+		gencoff(w, prog)
 	default:
-		return fmt.Errorf("unsupported os %q", os_)
+		return fmt.Errorf("unsupported os %q", prog.os)
 	}
 
 	return w.Flush()
@@ -62,23 +312,7 @@ const (
 	aSTR
 	aNOTE
 	aSECT
-)
-
-// a variable type this will decide which section
-// it is placed into and the relocations applied to it
-const (
-	sNONE = iota
-	sLABEL
-	sBSS
-	sUND
-)
-
-// relocation types for all architectures.
-const (
-	lN = iota
-	lS
-	lPC
-	lV
+	aSHIFT
 )
 
 // section type
@@ -91,6 +325,44 @@ const (
 	stSTRTAB
 )
 
+// sectFlags are the flags of a section, the letters of the
+// flags argument of .section.
+type sectFlags uint
+
+const (
+	sfAlloc   sectFlags = 1 << iota // a: takes memory when the program runs
+	sfWrite                         // w: writable
+	sfExec                          // x: executable
+	sfMerge                         // M: the entries can be merged with the equal ones of other objects
+	sfStrings                       // S: the entries are nul-terminated strings
+)
+
+// sectFlagLetters are the letters of the section flags.
+var sectFlagLetters = map[rune]sectFlags{
+	'a': sfAlloc,
+	'w': sfWrite,
+	'x': sfExec,
+	'M': sfMerge,
+	'S': sfStrings,
+}
+
+// parseSectFlags parses the flags argument of .section,
+// the letters can be in any order but only once each.
+func parseSectFlags(s string) (sectFlags, error) {
+	var flags sectFlags
+	for _, r := range s {
+		f, found := sectFlagLetters[r]
+		if !found {
+			return 0, fmt.Errorf("unknown section flag %q in %q", r, s)
+		}
+		if flags&f != 0 {
+			return 0, fmt.Errorf("section flag %q repeated in %q", r, s)
+		}
+		flags |= f
+	}
+	return flags, nil
+}
+
 // op represents an symbolic opcode.
 type op int
 
@@ -98,10 +370,21 @@ type op int
 // shared by all architectures.
 type as struct {
 	*prog
+	ctx    context.Context
 	sect   *section
 	file   string
 	line   string
 	lineno int64
+
+	// the macros by name, the one whose body is being read and how
+	// many .macro in its body haven't been ended yet, the lines that
+	// expand the macros of the line being assembled and how many
+	// expansions there have been
+	macros     map[string]*macro
+	defining   *macro
+	nested     int
+	expansions []expansion
+	nexpanded  int
 }
 
 // relocation represents a relocation.
@@ -112,9 +395,13 @@ type relocation struct {
 	pc    int
 	isize int
 
-	reltyp  int
+	reltyp  obj.RelocKind
 	relname string
 	rel     int64
+
+	// the constant added to the address of the symbol, from an
+	// operand like sym+8
+	addend int64
 }
 
 // addr represents an argument for the instruction.
@@ -124,6 +411,25 @@ type addr struct {
 	ival  int64
 	sval  string
 	deref bool
+
+	// the index register of a memory operand scaled by scale,
+	// scale is 0 when there is no index
+	index byte
+	iname string
+	scale int64
+
+	// the half of the address of the symbol sval that a mips
+	// operand takes, RelocHi for %hi and RelocLo for %lo, or
+	// RelocPageOff for the :lo12: of an arm64 one
+	part obj.RelocKind
+
+	// an arm64 memory operand like [x0, #8]! that writes the
+	// address back to its base before the access
+	writeback bool
+
+	// an x86 number without a $, which is an absolute address
+	// to the instructions and not an immediate
+	bare bool
 }
 
 // inst represents an instruction.
@@ -136,7 +442,7 @@ type inst struct {
 // section represents one section.
 type section struct {
 	name       string
-	flags      string
+	flags      sectFlags
 	typ        int
 	inst       []*inst
 	syms       []*sym
@@ -145,9 +451,11 @@ type section struct {
 	blocks     []*sym
 	strings    []span
 	relocs     []*relocation
+	pads       []*pad
 	size       int64
 	blockalign int64
 	blocksize  int64
+	align      int64 // the largest alignment asked for by the align directives
 	pc         int
 }
 
@@ -158,6 +466,16 @@ type span struct {
 	pc   int
 }
 
+// pad is the padding of an align directive, it is sized again
+// when the code before it changes size.
+type pad struct {
+	*inst
+	off   int64
+	pc    int
+	align int64
+	fill  func(n int) []byte // makes the n bytes of the padding
+}
+
 // prog contains all the information generated by the assembler.
 // This is used to emit an object file.
 type prog struct {
@@ -168,40 +486,80 @@ type prog struct {
 	data   *section
 	bss    *section
 	sects  []*section
-	syms   map[string]*sym
-	osyms  []*sym
+	syms   map[string]*sym // the symbols by name, only for lookups
+	osyms  []*sym          // the symbols in the order they were defined
 	usyms  []*sym
 	relocs []*relocation
+	idents []string
+	max    int64 // the largest size of a section
+}
+
+// sections returns the sections of the prog in the order of their
+// headers in the object file, the predefined .text, .data and .bss
+// are first, followed by the other sections in the order they were
+// defined.
+func (p *prog) sections() []*section {
+	return append([]*section{p.text, p.data, p.bss}, p.sects...)
+}
+
+// symbols returns the symbols of the prog in the order of the symbol
+// table of the object file, the local symbols come before the exported
+// ones and each are sorted by name, so the objects assembled from the
+// same source are the same. The order of the symbols in the prog is
+// left as it is.
+func (p *prog) symbols() []*sym {
+	syms := append([]*sym(nil), p.osyms...)
+	sort.SliceStable(syms, func(i, j int) bool {
+		p, q := syms[i], syms[j]
+		if p.exported != q.exported {
+			return !p.exported
+		}
+		return p.name < q.name
+	})
+	return syms
 }
 
 // sym represents a symbol.
 type sym struct {
 	sect      *section
-	typ       int
+	typ       obj.SymKind
 	name      string
 	size      int64
 	off       int64
+	align     int64 // the alignment given to .comm or .lcomm, 0 if it has none
 	pc        int
 	allocated bool
 	exported  bool
+	local     bool // declared by .local, a .comm of it is allocated in the bss
+}
+
+// assemblerLocal reports whether p is a label local to the assembler,
+// which the relocations refer to by its section.
+func (p *sym) assemblerLocal() bool {
+	return strings.HasPrefix(p.name, localPrefix) && !p.exported && p.allocated && p.sect != nil
 }
 
 // newprog creates an empty prog
 // with the architecture information.
-func newprog(arch, os_ string) *prog {
+func newprog(conf Config) *prog {
+	max := conf.MaxSectionSize
+	if max <= 0 {
+		max = DefaultMaxSectionSize
+	}
 	return &prog{
-		arch:   arch,
-		os:     os_,
-		endian: binary.LittleEndian,
+		arch:   conf.Arch,
+		os:     conf.OS,
+		max:    max,
+		endian: obj.ByteOrder(conf.Arch),
 		syms:   make(map[string]*sym),
-		text:   newsection(".text", "ax", stPROGBITS),
-		data:   newsection(".data", "wa", stPROGBITS),
-		bss:    newsection(".bss", "wa", stNOBITS),
+		text:   newsection(".text", sfAlloc|sfExec, stPROGBITS),
+		data:   newsection(".data", sfAlloc|sfWrite, stPROGBITS),
+		bss:    newsection(".bss", sfAlloc|sfWrite, stNOBITS),
 	}
 }
 
 // newsection creates a new section.
-func newsection(name, flags string, typ int) *section {
+func newsection(name string, flags sectFlags, typ int) *section {
 	return &section{
 		name:  name,
 		flags: flags,
@@ -211,18 +569,21 @@ func newsection(name, flags string, typ int) *section {
 
 // errorf outputs an error string by the assembler.
 func (as *as) errorf(format string, args ...interface{}) {
-	var pos string
-	if as.file != "" {
-		pos = fmt.Sprintf("%s:%d", as.file, as.lineno)
-	} else {
-		pos = fmt.Sprint(as.lineno)
-	}
+	pos := as.pos(as.lineno)
 	text := fmt.Sprintf(format, args...)
 	if as.line != "" {
-		errf("%s: error: %q\n%*s%s", pos, as.line, len(pos)+9, "", text)
+		errf("%s: error: %q\n%*s%s%s", pos, as.line, len(pos)+9, "", text, as.expansionNotes())
 	} else {
-		errf("%s: error: %s", pos, text)
+		errf("%s: error: %s%s", pos, text, as.expansionNotes())
+	}
+}
+
+// pos returns the position of the line lineno in the diagnostics.
+func (as *as) pos(lineno int64) string {
+	if as.file != "" {
+		return fmt.Sprintf("%s:%d", as.file, lineno)
 	}
+	return fmt.Sprint(lineno)
 }
 
 // code emits a buffer of machine code.
@@ -234,6 +595,10 @@ func (as *as) code(v ...interface{}) []byte {
 		switch v := v[i].(type) {
 		case nil:
 			continue
+		case []interface{}:
+			code = append(code, as.code(v...)...)
+		case []byte:
+			code = append(code, v...)
 		case byte:
 			code = append(code, byte(v))
 		case int:
@@ -248,6 +613,17 @@ func (as *as) code(v ...interface{}) []byte {
 	return code
 }
 
+// values emits the integers of a data directive of size bytes each in the
+// order they are given, stopping at the first operand that isn't one.
+func (as *as) values(op op, addr [4]addr, size int) {
+	for _, a := range addr {
+		if a.typ != aINT {
+			return
+		}
+		as.emit(op, addr, obj.Value(as.endian, size, a.ival))
+	}
+}
+
 // emit emits code for an instruction.
 func (as *as) emit(op op, addr [4]addr, v ...interface{}) {
 	code := as.code(v...)
@@ -286,7 +662,7 @@ func (as *as) fsym(typ int, name string) *sym {
 	s := as.syms[name]
 	if s == nil {
 		as.usyms = append(as.usyms, &sym{
-			typ:      sUND,
+			typ:      obj.SymUndef,
 			name:     name,
 			exported: true,
 		})
@@ -297,6 +673,13 @@ func (as *as) fsym(typ int, name string) *sym {
 	return s
 }
 
+// addlocal marks a variable as local to the object,
+// it is only used to allocate a .comm in the bss.
+func (as *as) addlocal(name string) {
+	p := as.gsym(name, as.bss)
+	p.local = true
+}
+
 // addglobal marks a global variable as exported.
 // If the variable does not exist, it will create
 // one of no type.
@@ -305,12 +688,17 @@ func (as *as) addglobal(name string) {
 	p.exported = true
 }
 
-// addbss adds a bss variable.
-func (as *as) addbss(name string, size int64, allocated bool) {
+// addbss adds a bss variable, aligned to align if it isn't 0.
+func (as *as) addbss(name string, size, align int64, allocated bool) {
 	p := as.gsym(name, as.bss)
-	if p.typ == sNONE {
-		p.typ = sBSS
+	allocated = allocated || p.local
+	if size > as.max || (allocated && size > as.max-p.sect.blocksize) {
+		as.errorf("%s: a block of %d bytes does not fit in the maximum section size of %d bytes", name, size, as.max)
+	}
+	if p.typ == obj.SymNone {
+		p.typ = obj.SymBSS
 		p.size = size
+		p.align = align
 		p.allocated = allocated
 		if allocated {
 			p.sect.blocksize += size
@@ -324,14 +712,14 @@ func (as *as) addbss(name string, size int64, allocated bool) {
 // addlabel adds a label.
 func (as *as) addlabel(name string, off int64, pc int) {
 	p := as.gsym(name, as.sect)
-	if p.typ == sLABEL {
+	if p.typ == obj.SymLabel {
 		if p.off != off {
 			goto fail
 		}
 		return
 	}
-	if p.typ == sNONE {
-		p.typ = sLABEL
+	if p.typ == obj.SymNone {
+		p.typ = obj.SymLabel
 		p.off = off
 		p.pc = pc
 		as.sect.labels = append(as.sect.labels, p)
@@ -348,14 +736,21 @@ func (as *as) addrel(op op, addr [4]addr) {
 	s.inst = append(s.inst, &inst{op: op, addr: addr})
 	i := s.inst[len(s.inst)-1]
 
-	as.relocs = append(as.relocs, &relocation{section: s, inst: i, off: s.size, pc: s.pc})
+	p := &relocation{section: s, inst: i, off: s.size, pc: s.pc}
+	for _, a := range addr {
+		if a.typ == aPTR || a.typ == aVAR {
+			p.addend = a.ival
+			break
+		}
+	}
+	as.relocs = append(as.relocs, p)
 	s.relocs = append(s.relocs, as.relocs[len(as.relocs)-1])
 }
 
 // addsect adds a section.
 func (as *as) addsect(name, flags, typ string) {
 	switch name {
-	case ".text", ".data", ".rela.text", ".rela.data",
+	case ".text", ".data", ".rela.text", ".rela.data", ".rel.text", ".rel.data",
 		".bss", ".shstrtab", ".strtab", ".symtab":
 		as.errorf("can't define a pre-defined section %q", name)
 	case "":
@@ -374,38 +769,150 @@ func (as *as) addsect(name, flags, typ string) {
 		as.errorf("unknown section type %q", typ)
 	}
 
+	xflags, err := parseSectFlags(flags)
+	if err != nil {
+		as.errorf("%v", err)
+	}
+
+	// the object file only has the predefined sections, the code
+	// of the parts of the text like .text.unlikely goes in .text
+	if strings.HasPrefix(name, ".text.") {
+		if xflags != as.text.flags || xtyp != as.text.typ {
+			as.errorf("section %q must have the flags and the type of .text", name)
+		}
+		as.sect = as.text
+		return
+	}
+
 	for _, s := range as.sects {
 		if s.name == name {
+			if xflags != s.flags || xtyp != s.typ {
+				as.errorf("section %q redeclared with other flags or type", name)
+			}
 			as.sect = s
 			return
 		}
 	}
-	as.sects = append(as.sects, newsection(name, flags, xtyp))
+	as.sects = append(as.sects, newsection(name, xflags, xtyp))
 	as.sect = as.sects[len(as.sects)-1]
 }
 
-// alignpc aligns current pc to value.
-func (as *as) alignpc(align int, value uint8) {
-	size := (align - (as.sect.pc % align)) % align
-	buf := make([]byte, size)
-	for i := range buf {
-		buf[i] = value
+// alignpc aligns the current offset to align, the padding is made by fill.
+func (as *as) alignpc(align int, fill func(n int) []byte) {
+	s := as.sect
+	if int64(align) > s.align {
+		s.align = int64(align)
+	}
+	p := &pad{inst: &inst{op: opBYTES}, off: s.size, pc: s.pc, align: int64(align), fill: fill}
+	p.code = fill(int(p.size(p.off)))
+	s.size += int64(len(p.code))
+	s.inst = append(s.inst, p.inst)
+	s.pads = append(s.pads, p)
+}
+
+// size returns how many bytes of padding p takes at the offset off.
+func (p *pad) size(off int64) int64 {
+	return (p.align - off%p.align) % p.align
+}
+
+// fillValue returns a fill of the padding with value.
+func fillValue(value uint8) func(n int) []byte {
+	return func(n int) []byte {
+		buf := make([]byte, n)
+		for i := range buf {
+			buf[i] = value
+		}
+		return buf
+	}
+}
+
+// shifts are the changes of the sizes of the instructions of a
+// section, in the order of their pcs, so what follows them is moved
+// once for all of them instead of once for each.
+type shifts struct {
+	pcs    []int
+	deltas []int64 // the sum of the changes up to each pc
+}
+
+// add records that the instruction at pc changed size by delta bytes.
+func (d *shifts) add(pc int, delta int64) {
+	if n := len(d.deltas); n > 0 {
+		delta += d.deltas[n-1]
+	}
+	d.pcs = append(d.pcs, pc)
+	d.deltas = append(d.deltas, delta)
+}
+
+// at returns how far the changes move what is at pc, the sum of the
+// ones of the instructions before it.
+func (d *shifts) at(pc int) int64 {
+	i := sort.SearchInts(d.pcs, pc)
+	if i == 0 {
+		return 0
+	}
+	return d.deltas[i-1]
+}
+
+// shift moves the labels, the relocations, the strings and the
+// padding after the instructions that changed size in d.
+func (s *section) shift(d *shifts) {
+	if len(d.pcs) == 0 {
+		return
+	}
+	for _, q := range s.labels {
+		q.off += d.at(q.pc)
+	}
+	for _, q := range s.relocs {
+		q.off += d.at(q.pc)
+	}
+	for i := range s.strings {
+		q := &s.strings[i]
+		q.off += d.at(q.pc)
+	}
+	for _, q := range s.pads {
+		q.off += d.at(q.pc)
+	}
+	s.size += d.deltas[len(d.deltas)-1]
+}
+
+// repad sizes the padding again after the code before it changed
+// size, it reports whether any of the padding changed.
+func (s *section) repad() bool {
+	var d shifts
+	for _, p := range s.pads {
+		n := p.size(p.off + d.at(p.pc))
+		if delta := n - int64(len(p.code)); delta != 0 {
+			p.code = p.fill(int(n))
+			d.add(p.pc, delta)
+		}
 	}
-	as.sect.bytes(buf)
+	s.shift(&d)
+	return len(d.pcs) > 0
 }
 
 // strz appends a nul-terminated string to the instruction stream.
 func (s *section) strz(str string) {
+	s.str(opSTRZ, append([]byte(str), 0))
+}
+
+// chars appends the bytes of a string to the instruction stream,
+// without a nul.
+func (s *section) chars(str string) {
+	s.str(opBYTES, []byte(str))
+}
+
+// str appends the bytes of a string to the instruction stream.
+func (s *section) str(op op, code []byte) {
 	s.strings = append(s.strings, span{
 		off:  s.size,
-		size: int64(len(str) + 1),
+		size: int64(len(code)),
 		pc:   s.pc,
 	})
 
-	s.size += int64(len(str)) + 1
+	s.size += int64(len(code))
 	s.inst = append(s.inst, &inst{
-		op:   opSTRZ,
-		code: append([]byte(str), 0),
+		op:   op,
+		code: code,
 	})
 }
 
@@ -419,11 +926,175 @@ func (s *section) bytes(buf []byte) {
 	})
 }
 
-// isIdent returns if a string is an identifier.
+// fixupBSS fixes the BSS offsets after
+// everything has been relocated correctly.
+func (as *as) fixupBSS() {
+	as.bss.blockalign = 1
+	align := int64(1)
+	off := int64(0)
+	for _, p := range as.bss.blocks {
+		if p.exported {
+			continue
+		}
+		if p.size == 0 {
+			as.errorf("invalid fixup bbs size of 0")
+		}
+		if n := off % p.size; p.size < 8 && n > 0 {
+			off += p.size - n
+		}
+		if p.align > 0 {
+			off = (off + p.align - 1) / p.align * p.align
+			if p.align > align {
+				align = p.align
+			}
+		}
+
+		if p.size > as.max-off {
+			as.errorf("%s: the bss is larger than the maximum section size of %d bytes", p.name, as.max)
+		}
+		p.off = off
+		off += p.size
+		as.bss.blockalign = int64(align2(p.size))
+	}
+	if as.bss.blockalign > 8 {
+		as.bss.blockalign = 8
+	}
+	if align > as.bss.blockalign {
+		as.bss.blockalign = align
+	}
+	if off > as.bss.blocksize {
+		as.bss.blocksize = off
+	}
+}
+
+// maxAlign is the largest alignment accepted by the align directives.
+const maxAlign = 1 << 16
+
+// isNumber reports whether s starts like an integer constant.
+func isNumber(s string) bool {
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		s = s[1:]
+	}
+	return len(s) > 0 && '0' <= s[0] && s[0] <= '9'
+}
+
+// number parses an integer constant, it can be signed and written in
+// decimal, hex (0x), octal (0) or binary (0b). Values up to 64 bits
+// are accepted, both signed and unsigned.
+func (as *as) number(s string) int64 {
+	t := s
+	neg := false
+	switch {
+	case strings.HasPrefix(t, "-"):
+		neg = true
+		t = t[1:]
+	case strings.HasPrefix(t, "+"):
+		t = t[1:]
+	}
+
+	if !isNumber(t) || strings.ContainsAny(t, "_+-") {
+		as.errorf("invalid integer constant %q", s)
+	}
+
+	base := 10
+	switch lt := strings.ToLower(t); {
+	case strings.HasPrefix(lt, "0x"):
+		base, t = 16, t[2:]
+	case strings.HasPrefix(lt, "0b"):
+		base, t = 2, t[2:]
+	case len(t) > 1 && t[0] == '0':
+		base, t = 8, t[1:]
+	}
+
+	n, err := strconv.ParseUint(t, base, 64)
+	if err != nil {
+		if e, ok := err.(*strconv.NumError); ok && e.Err == strconv.ErrRange {
+			as.errorf("integer constant %q overflows 64 bits", s)
+		}
+		as.errorf("invalid integer constant %q", s)
+	}
+	if neg {
+		if n > 1<<63 {
+			as.errorf("integer constant %q overflows 64 bits", s)
+		}
+		return -int64(n)
+	}
+	return int64(n)
+}
+
+// char parses a character constant such as 'a' or '\n'.
+func (as *as) char(s string) int64 {
+	if len(s) < 3 || !strings.HasSuffix(s, "'") {
+		as.errorf("invalid character constant %s", s)
+	}
+	v, err := obj.Unquote(s)
+	if err != nil || len(v) != 1 {
+		as.errorf("invalid character constant %s", s)
+		return 0
+	}
+	return int64(v[0])
+}
+
+// bssalign returns the optional alignment argument of a .comm or
+// .lcomm directive, 0 if it isn't given.
+func (as *as) bssalign(dir string, a addr) int64 {
+	if a.typ == aNONE {
+		return 0
+	}
+	if a.typ != aINT || a.ival <= 0 || a.ival > maxAlign || a.ival&(a.ival-1) != 0 {
+		as.errorf("%s: alignment %d is not a power of 2 up to %d", dir, a.ival, maxAlign)
+	}
+	return a.ival
+}
+
+// size checks that the size argument of a directive is valid.
+func (as *as) size(dir string, a addr) {
+	if a.typ != aINT || a.ival < 0 {
+		as.errorf("%s: invalid size", dir)
+	}
+}
+
+// ranges checks that the integer arguments of a directive fit in the
+// given size in bytes, as either a signed or an unsigned value.
+func (as *as) ranges(dir string, addr []addr, size int) {
+	if size >= 8 {
+		return
+	}
+	bits := uint(8 * size)
+	min, max := -int64(1)<<(bits-1), int64(1)<<bits-1
+	for _, a := range addr {
+		if a.typ == aINT && (a.ival < min || a.ival > max) {
+			as.errorf("%s: value %d out of range [%d, %d]", dir, a.ival, min, max)
+		}
+	}
+}
+
+// symPlus splits an operand like sym+8 or sym-4 into the symbol and the
+// constant added to its address.
+func symPlus(s string) (sym string, n int64, ok bool) {
+	i := strings.LastIndexAny(s, "+-")
+	if i <= 0 || !isIdent(s[:i]) {
+		return "", 0, false
+	}
+	n, err := strconv.ParseInt(s[i:], 0, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return s[:i], n, true
+}
+
+// localPrefix starts the names of the labels that are local to the
+// assembler, the symbol table of the object leaves them out.
+const localPrefix = ".L"
+
+// isIdent returns if a string is an identifier, a label local to the
+// assembler is one whatever follows its prefix.
 func isIdent(s string) bool {
+	local := strings.HasPrefix(s, localPrefix)
+	s = strings.TrimPrefix(s, localPrefix)
 	for i, r := range s {
 		isAlpha := r == '_' || unicode.IsLetter(r)
-		if i == 0 && !isAlpha {
+		if i == 0 && !isAlpha && !local {
 			return false
 		}
 		if i > 0 && !isAlpha && !unicode.IsDigit(r) {
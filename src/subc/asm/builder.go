@@ -0,0 +1,112 @@
+package asm
+
+import (
+	"io"
+	"runtime"
+	"strings"
+	"text/scanner"
+)
+
+// Builder assembles instructions as they are handed to it,
+// without writing an assembly file and running an assembler
+// on it. It satisfies the compiler's output sink so a backend
+// can emit into the object file. The backends hand over the
+// operands as text, which are parsed like the ones of a line
+// of a source file.
+type Builder struct {
+	as     *as
+	arch   assembler
+	inline bool
+	done   bool
+	err    error
+}
+
+// NewBuilder creates a builder for the architecture and os of conf,
+// input is the file name used when reporting errors.
+func NewBuilder(conf Config, input string) *Builder {
+	b := &Builder{as: &as{prog: newprog(conf), file: input}}
+	b.arch, b.err = newAssembler(b.as)
+	return b
+}
+
+// Label defines a label at the current location,
+// an inline label shares its line with the next instruction.
+func (b *Builder) Label(name string, inline bool) {
+	b.do(name+":", func() {
+		b.as.addlabel(name, b.as.sect.size, b.as.sect.pc)
+	})
+	b.inline = inline
+}
+
+// Inst assembles one instruction with its comma separated operands,
+// parsed by the assembler of the architecture.
+func (b *Builder) Inst(op, operands string) {
+	line := op
+	if operands != "" {
+		line += "\t" + operands
+	}
+	b.do(line, func() {
+		var addr [4]addr
+		if operands != "" {
+			addr = b.arch.args(operands)
+		}
+		if !b.arch.inst(op, addr) {
+			b.done = true
+		}
+	})
+	b.inline = false
+}
+
+// Pos marks the source position of the instructions that follow,
+// the builder does not record it yet.
+func (b *Builder) Pos(pos scanner.Position) {}
+
+// Comment drops a comment, there is no text to put it in.
+func (b *Builder) Comment(text string) {}
+
+// do runs f for one line of output, line is what the
+// assembly source would contain and is used in errors.
+func (b *Builder) do(line string, f func()) {
+	if b.err != nil || b.done {
+		return
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			b.err = e.(error)
+			if _, ok := b.err.(runtime.Error); ok {
+				panic(b.err)
+			}
+		}
+	}()
+
+	if b.inline {
+		b.as.line += line
+	} else {
+		b.as.lineno++
+		b.as.line = strings.TrimSpace(line)
+	}
+	f()
+}
+
+// Err returns the first error encountered while assembling.
+func (b *Builder) Err() error {
+	return b.err
+}
+
+// Finish resolves the relocations and writes the object file.
+func (b *Builder) Finish(output io.Writer) (err error) {
+	if b.err != nil {
+		return b.err
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			err = e.(error)
+			if _, ok := err.(runtime.Error); ok {
+				panic(err)
+			}
+		}
+	}()
+	b.as.line = ""
+	b.arch.finish()
+	return writeobj(output, b.as.prog)
+}
@@ -0,0 +1,406 @@
+package asm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/bits"
+
+	"subc/obj"
+)
+
+// gencoff creates a COFF emitter.
+func gencoff(w io.Writer, prog *prog) {
+	target, found := coffTargets[prog.arch]
+	if !found {
+		errf("unsupported arch %q for windows", prog.arch)
+	}
+	c := gcoff{
+		prog:   prog,
+		target: target,
+		w:      &counter{w: w},
+	}
+	c.gen()
+}
+
+// coffTarget describes the objects of an architecture.
+type coffTarget struct {
+	machine uint16
+	relocs  map[obj.RelocKind]uint16
+	addr32  uint16 // the relocation of the data of 4 bytes, RelocData is the one of 8 bytes
+}
+
+// The machines and the relocations of the COFF objects.
+const (
+	imageFileMachineAMD64 = 0x8664
+
+	imageRelAMD64Addr64 = 0x1
+	imageRelAMD64Addr32 = 0x2
+	imageRelAMD64Rel32  = 0x4
+)
+
+var coffTargets = map[string]coffTarget{
+	"amd64": {
+		machine: imageFileMachineAMD64,
+		relocs: map[obj.RelocKind]uint16{
+			obj.RelocAbs:  imageRelAMD64Addr32,
+			obj.RelocPC:   imageRelAMD64Rel32,
+			obj.RelocData: imageRelAMD64Addr64,
+		},
+		addr32: imageRelAMD64Addr32,
+	},
+}
+
+// The characteristics of the COFF sections.
+const (
+	imageScnCntCode              = 0x20
+	imageScnCntInitializedData   = 0x40
+	imageScnCntUninitializedData = 0x80
+	imageScnLnkInfo              = 0x200
+	imageScnLnkRemove            = 0x800
+	imageScnMemExecute           = 0x20000000
+	imageScnMemRead              = 0x40000000
+	imageScnMemWrite             = 0x80000000
+)
+
+// The storage classes of the COFF symbols.
+const (
+	imageSymClassExternal = 2
+	imageSymClassStatic   = 3
+)
+
+// The sizes of the COFF structures in the file.
+const (
+	coffHdrSize   = 20
+	coffShdrSize  = 40
+	coffRelocSize = 10
+	coffSymSize   = 18
+)
+
+// coffFileHeader is the header of a COFF object.
+type coffFileHeader struct {
+	Machine              uint16
+	NumberOfSections     uint16
+	TimeDateStamp        uint32
+	PointerToSymbolTable uint32
+	NumberOfSymbols      uint32
+	SizeOfOptionalHeader uint16
+	Characteristics      uint16
+}
+
+// coffSectionHeader is the header of a section of a COFF object.
+type coffSectionHeader struct {
+	Name                 [8]byte
+	VirtualSize          uint32
+	VirtualAddress       uint32
+	SizeOfRawData        uint32
+	PointerToRawData     uint32
+	PointerToRelocations uint32
+	PointerToLineNumbers uint32
+	NumberOfRelocations  uint16
+	NumberOfLineNumbers  uint16
+	Characteristics      uint32
+}
+
+// coffReloc is a relocation, the addend is in the code it relocates.
+type coffReloc struct {
+	VirtualAddress   uint32
+	SymbolTableIndex uint32
+	Type             uint16
+}
+
+// coffSym is a symbol, a name of more than 8 bytes is in the string
+// table at the offset in the last 4 bytes of the name.
+type coffSym struct {
+	Name               [8]byte
+	Value              uint32
+	SectionNumber      int16
+	Type               uint16
+	StorageClass       uint8
+	NumberOfAuxSymbols uint8
+}
+
+// coffSectionAux is the auxiliary record that follows the symbol of a
+// section.
+type coffSectionAux struct {
+	Length              uint32
+	NumberOfRelocations uint16
+	NumberOfLineNumbers uint16
+	CheckSum            uint32
+	Number              uint16
+	Selection           uint8
+	_                   [3]byte
+}
+
+// csect is a section of a COFF object, with where its contents and
+// its relocations are in the file.
+type csect struct {
+	*section
+	name   string
+	flags  uint32
+	size   int64 // the size of the contents, in the file unless it is the bss
+	relocs []byte
+	off    int64
+	reloff int64
+}
+
+// gcoff is a COFF emitter.
+// It emits the object files of windows.
+type gcoff struct {
+	*prog
+	target  coffTarget
+	w       *counter
+	sects   []*csect
+	symbols []*sym         // the symbols in the order of the symbol table, without the labels local to the assembler
+	index   map[string]int // the indexes of the symbols in the symbol table, by name
+	strtab  *section
+	symoff  int64
+	nsyms   int
+}
+
+// gen generates a COFF object file.
+func (c *gcoff) gen() {
+	c.sects = []*csect{
+		{section: c.text, name: ".text", flags: imageScnCntCode | imageScnMemExecute | imageScnMemRead, size: c.text.size},
+		{section: c.data, name: ".data", flags: imageScnCntInitializedData | imageScnMemRead | imageScnMemWrite, size: c.data.size},
+		{section: c.bss, name: ".bss", flags: imageScnCntUninitializedData | imageScnMemRead | imageScnMemWrite, size: c.bss.blocksize},
+	}
+	c.sects[0].flags |= alignflags(addralign(c.text))
+	c.sects[1].flags |= alignflags(addralign(c.data))
+	c.sects[2].flags |= alignflags(uint64(c.bss.blockalign))
+	if len(c.idents) > 0 {
+		// the strings of the .ident directives are left out of the image
+		comment := c.gencomment()
+		c.sects = append(c.sects, &csect{section: comment, name: ".comment", flags: imageScnLnkInfo | imageScnLnkRemove | alignflags(1), size: comment.size})
+	}
+
+	// each section has a symbol and its auxiliary record
+	c.index = make(map[string]int)
+	c.nsyms = 2 * 3
+	for _, p := range c.prog.symbols() {
+		if p.assemblerLocal() {
+			continue
+		}
+		c.index[p.name] = c.nsyms
+		c.symbols = append(c.symbols, p)
+		c.nsyms++
+	}
+
+	c.strtab = newsection(".strtab", 0, stSTRTAB)
+	c.sects[0].relocs = c.genreloc(c.text)
+	c.sects[1].relocs = c.genreloc(c.data)
+	c.layout()
+
+	c.writehdr()
+	for _, s := range c.sects {
+		c.writeshdr(s)
+	}
+	for _, s := range c.sects {
+		if s.section == c.bss {
+			continue
+		}
+		for _, i := range s.inst {
+			c.w.Write(i.code)
+		}
+	}
+	for _, s := range c.sects {
+		c.w.Write(s.relocs)
+	}
+	c.writesyms()
+	c.write(uint32(4 + c.strtab.size))
+	for _, i := range c.strtab.inst {
+		c.w.Write(i.code)
+	}
+}
+
+// gencomment generates a .comment section with the strings of the
+// .ident directives, which say what made the object.
+func (c *gcoff) gencomment() *section {
+	s := newsection(".comment", sfMerge|sfStrings, stPROGBITS)
+	for _, ident := range c.idents {
+		s.strz(ident)
+	}
+	return s
+}
+
+// layout places the contents of the sections after the headers in the
+// order they are written, followed by their relocations, the symbol
+// table and the string table.
+func (c *gcoff) layout() {
+	off := int64(coffHdrSize + coffShdrSize*len(c.sects))
+	for _, s := range c.sects {
+		if s.section != c.bss {
+			s.off = off
+			off += s.size
+		}
+	}
+	for _, s := range c.sects {
+		s.reloff = off
+		off += int64(len(s.relocs))
+	}
+	c.symoff = off
+}
+
+// alignflags returns the characteristics of a section aligned to align
+// bytes.
+func alignflags(align uint64) uint32 {
+	if align > 8192 {
+		errf("alignment %d is larger than the 8192 bytes of a COFF section", align)
+	}
+	return uint32(bits.TrailingZeros64(align)+1) << 20
+}
+
+// secnum returns the number of section s, the numbers start at 1.
+func (c *gcoff) secnum(s *section) int16 {
+	for i, p := range c.sects[:3] {
+		if p.section == s {
+			return int16(i + 1)
+		}
+	}
+	errf("unknown section name %q", s.name)
+	return 0
+}
+
+// genreloc generates the relocations of s. COFF has no addends in the
+// relocations, they are put in the code of s, which hasn't been written
+// yet. The field of a PC-relative relocation is relative to its end,
+// so unlike the addend of ELF it doesn't take the size of the field.
+func (c *gcoff) genreloc(s *section) []byte {
+	if len(s.relocs) > 0xffff {
+		errf("%d relocations in %s are more than a COFF section can have", len(s.relocs), s.name)
+	}
+
+	b := new(bytes.Buffer)
+	for _, p := range s.relocs {
+		var sym int
+		var addend int64
+
+		y := c.prog.syms[p.relname]
+		if y == nil {
+			errf("internal error: invalid relname %q", p.relname)
+		}
+
+		switch {
+		case y.typ == obj.SymBSS && !y.allocated:
+			sym = c.index[y.name]
+		case y.typ == obj.SymBSS:
+			sym = 2 * int(c.secnum(c.bss)-1)
+			addend = y.off
+		case !y.exported && y.sect != nil:
+			sym = 2 * int(c.secnum(y.sect)-1)
+			addend = y.off
+		default:
+			sym = c.index[y.name]
+		}
+		addend += p.addend
+
+		typ, found := c.target.relocs[p.reltyp]
+		if !found {
+			errf("unknown relocation type %v", p.reltyp)
+		}
+
+		code := p.code[p.rel-p.off:]
+		switch {
+		case p.reltyp == obj.RelocData && len(code) == 8:
+			c.endian.PutUint64(code, c.endian.Uint64(code)+uint64(addend))
+		case p.reltyp == obj.RelocData && len(code) == 4:
+			typ = c.target.addr32
+			fallthrough
+		case p.reltyp != obj.RelocData:
+			c.endian.PutUint32(code, c.endian.Uint32(code)+uint32(addend))
+		default:
+			errf("no COFF relocation of data of %d bytes", len(code))
+		}
+
+		binary.Write(b, c.endian, coffReloc{
+			VirtualAddress:   uint32(p.rel),
+			SymbolTableIndex: uint32(sym),
+			Type:             typ,
+		})
+	}
+	return b.Bytes()
+}
+
+// writehdr writes the COFF file header. The time stamp is left at 0, so
+// the objects assembled from the same source are the same.
+func (c *gcoff) writehdr() {
+	c.write(coffFileHeader{
+		Machine:              c.target.machine,
+		NumberOfSections:     uint16(len(c.sects)),
+		PointerToSymbolTable: uint32(c.symoff),
+		NumberOfSymbols:      uint32(c.nsyms),
+	})
+}
+
+// writeshdr writes the header of section s.
+func (c *gcoff) writeshdr(s *csect) {
+	h := coffSectionHeader{
+		SizeOfRawData:       uint32(s.size),
+		PointerToRawData:    uint32(s.off),
+		NumberOfRelocations: uint16(len(s.relocs) / coffRelocSize),
+		Characteristics:     s.flags,
+	}
+	copy(h.Name[:], s.name)
+	if len(s.relocs) > 0 {
+		h.PointerToRelocations = uint32(s.reloff)
+	}
+	c.write(h)
+}
+
+// writesyms writes the symbol table, the symbols of the sections with
+// their auxiliary records are first.
+func (c *gcoff) writesyms() {
+	for i, s := range c.sects[:3] {
+		sym := coffSym{
+			SectionNumber:      int16(i + 1),
+			StorageClass:       imageSymClassStatic,
+			NumberOfAuxSymbols: 1,
+		}
+		copy(sym.Name[:], s.name)
+		c.write(sym)
+		c.write(coffSectionAux{
+			Length:              uint32(s.size),
+			NumberOfRelocations: uint16(len(s.relocs) / coffRelocSize),
+		})
+	}
+
+	for _, p := range c.symbols {
+		sym := coffSym{
+			Name:         c.symname(p.name),
+			Value:        uint32(p.off),
+			StorageClass: imageSymClassStatic,
+		}
+		if p.exported {
+			sym.StorageClass = imageSymClassExternal
+		}
+		switch {
+		case p.sect == nil:
+			sym.Value = 0
+			sym.StorageClass = imageSymClassExternal
+		case !p.allocated:
+			// a common symbol is undefined with its size as the value
+			sym.Value = uint32(p.size)
+			sym.StorageClass = imageSymClassExternal
+		default:
+			sym.SectionNumber = c.secnum(p.sect)
+		}
+		c.write(sym)
+	}
+}
+
+// symname returns the name field of a symbol, the name itself if it
+// fits and the offset of it in the string table otherwise.
+func (c *gcoff) symname(name string) [8]byte {
+	var b [8]byte
+	if len(name) <= len(b) {
+		copy(b[:], name)
+		return b
+	}
+	c.endian.PutUint32(b[4:], uint32(4+c.strtab.size))
+	c.strtab.strz(name)
+	return b
+}
+
+func (c *gcoff) write(v interface{}) {
+	binary.Write(c.w, c.endian, v)
+}
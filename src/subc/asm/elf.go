@@ -6,19 +6,141 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"sort"
 	"strconv"
+
+	"subc/obj"
 )
 
 // genelf creates an ELF emitter.
 func genelf(w io.Writer, prog *prog) {
 	c := gelf{
-		prog: prog,
-		w:    w,
+		prog:   prog,
+		target: elfTargets[prog.arch],
+		w:      &counter{w: w},
 	}
 	c.gen()
 }
 
+// counter is a writer that counts the bytes written to it.
+type counter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *counter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	w.n += int64(n)
+	return n, err
+}
+
+// elfTarget describes the objects of an architecture.
+type elfTarget struct {
+	class   elf.Class
+	machine elf.Machine
+	flags   uint32 // the e_flags of the header
+	rela    bool   // the relocations have addends, the ones of rel are in the code
+	relocs  map[obj.RelocKind]uint32
+}
+
+// The e_flags of the mips objects, the code is for MIPS32 and the o32
+// abi and can be linked with the code that calls through $t9.
+const (
+	efMipsCpic  = 0x4
+	efMipsO32   = 0x1000
+	efMipsArch2 = 0x50000000
+)
+
+// The e_flags of the riscv64 objects, the code takes the arguments of
+// the lp64d abi, whose objects can be linked with the C library.
+const efRiscvFloatABIDouble = 0x4
+
+var elfTargets = map[string]elfTarget{
+	"amd64": {
+		class:   elf.ELFCLASS64,
+		machine: elf.EM_X86_64,
+		rela:    true,
+		relocs: map[obj.RelocKind]uint32{
+			obj.RelocAbs:  uint32(elf.R_X86_64_32S),
+			obj.RelocPC:   uint32(elf.R_X86_64_PC32),
+			obj.RelocData: uint32(elf.R_X86_64_64),
+		},
+	},
+	"arm64": {
+		class:   elf.ELFCLASS64,
+		machine: elf.EM_AARCH64,
+		rela:    true,
+		relocs: map[obj.RelocKind]uint32{
+			obj.RelocData:      uint32(elf.R_AARCH64_ABS64),
+			obj.RelocCall:      uint32(elf.R_AARCH64_CALL26),
+			obj.RelocBranch:    uint32(elf.R_AARCH64_JUMP26),
+			obj.RelocPage:      uint32(elf.R_AARCH64_ADR_PREL_PG_HI21),
+			obj.RelocPageOff:   uint32(elf.R_AARCH64_ADD_ABS_LO12_NC),
+			obj.RelocPageOff8:  uint32(elf.R_AARCH64_LDST8_ABS_LO12_NC),
+			obj.RelocPageOff16: uint32(elf.R_AARCH64_LDST16_ABS_LO12_NC),
+			obj.RelocPageOff32: uint32(elf.R_AARCH64_LDST32_ABS_LO12_NC),
+			obj.RelocPageOff64: uint32(elf.R_AARCH64_LDST64_ABS_LO12_NC),
+		},
+	},
+	"riscv64": {
+		class:   elf.ELFCLASS64,
+		machine: elf.EM_RISCV,
+		flags:   efRiscvFloatABIDouble,
+		rela:    true,
+		relocs: map[obj.RelocKind]uint32{
+			obj.RelocData:    uint32(elf.R_RISCV_64),
+			obj.RelocHi:      uint32(elf.R_RISCV_HI20),
+			obj.RelocLo:      uint32(elf.R_RISCV_LO12_I),
+			obj.RelocLoStore: uint32(elf.R_RISCV_LO12_S),
+			obj.RelocCall:    uint32(elf.R_RISCV_CALL_PLT),
+			obj.RelocBranch:  uint32(elf.R_RISCV_JAL),
+		},
+	},
+	"i386": {
+		class:   elf.ELFCLASS32,
+		machine: elf.EM_386,
+		relocs: map[obj.RelocKind]uint32{
+			obj.RelocAbs:  uint32(elf.R_386_32),
+			obj.RelocPC:   uint32(elf.R_386_PC32),
+			obj.RelocData: uint32(elf.R_386_32),
+		},
+	},
+	"mips": {
+		class:   elf.ELFCLASS32,
+		machine: elf.EM_MIPS,
+		flags:   efMipsArch2 | efMipsO32 | efMipsCpic,
+		relocs: map[obj.RelocKind]uint32{
+			obj.RelocData: uint32(elf.R_MIPS_32),
+			obj.RelocHi:   uint32(elf.R_MIPS_HI16),
+			obj.RelocLo:   uint32(elf.R_MIPS_LO16),
+			obj.RelocJump: uint32(elf.R_MIPS_26),
+		},
+	},
+}
+
+// elfOSABIs are the OS ABIs in the header of the objects of each os.
+var elfOSABIs = map[string]elf.OSABI{
+	"linux":   elf.ELFOSABI_NONE,
+	"freebsd": elf.ELFOSABI_FREEBSD,
+	"netbsd":  elf.ELFOSABI_NETBSD,
+	"openbsd": elf.ELFOSABI_OPENBSD,
+}
+
+// elfNote is the note that the kernel of an os looks for in a program
+// to tell it is one of its own, with the version of the os it is for.
+type elfNote struct {
+	section string
+	name    string
+	desc    uint32
+}
+
+// elfNotes are the notes of the BSDs by os, linux doesn't need one. The
+// versions are the ones of the startup code of SubC.
+var elfNotes = map[string]elfNote{
+	"freebsd": {".note.ABI-tag", "FreeBSD", 802000},
+	"netbsd":  {".note.netbsd.ident", "NetBSD", 400000003},
+	"openbsd": {".note.openbsd.ident", "OpenBSD", 0},
+}
+
 // self is a ELF symbol.
 type self struct {
 	*sym
@@ -29,34 +151,43 @@ type self struct {
 // It will emit a shared object file.
 type gelf struct {
 	*prog
-	w io.Writer
+	target elfTarget
+	w      *counter
 
+	reltext  *section
+	reldata  *section
 	symtab   *section
 	strtab   *section
 	shstrtab *section
+	comment  *section
+	note     *section
+	shnames  map[string]int64
+	symbols  []*sym // the symbols in the order of the symbol table, without the labels local to the assembler
 	syms     map[string]*self
+	offs     map[string]int64 // the offsets of the sections in the file, by name
+	shoff    int64            // the offset of the section headers
 }
 
 // gen generates an ELF object file.
 func (c *gelf) gen() {
-	sort.SliceStable(c.osyms, func(i, j int) bool {
-		p, q := c.osyms[i], c.osyms[j]
-		if !p.exported && q.exported {
-			return true
-		}
-		if !q.exported && p.exported {
-			return false
-		}
-		return p.name < q.name
-	})
 	c.syms = make(map[string]*self)
-	for i, p := range c.osyms {
-		c.syms[p.name] = &self{p, i}
+	for _, p := range c.prog.symbols() {
+		if p.assemblerLocal() {
+			c.syms[p.name] = &self{p, -1}
+			continue
+		}
+		c.syms[p.name] = &self{p, len(c.symbols)}
+		c.symbols = append(c.symbols, p)
 	}
 
+	c.note = c.gennote()
 	c.strtab = c.genstrtab()
 	c.shstrtab = c.genshstrtab()
 	c.symtab = c.gensymtab()
+	c.comment = c.gencomment()
+	c.reltext = c.genreloc(c.text)
+	c.reldata = c.genreloc(c.data)
+	c.layout()
 
 	c.writehdr()
 	c.writesection(c.text)
@@ -64,17 +195,91 @@ func (c *gelf) gen() {
 	c.writesection(c.symtab)
 	c.writesection(c.strtab)
 	c.writesection(c.shstrtab)
-	c.writereloc(c.text)
-	c.writereloc(c.data)
+	c.writesection(c.reltext)
+	c.writesection(c.reldata)
+	c.writesection(c.comment)
+	c.writesection(c.note)
+	c.pad(c.shoff)
 	c.writeshdr()
 }
 
+// layout places the sections in the file after the header in the order
+// they are written, each at an offset aligned to its alignment, and the
+// section headers after them.
+func (c *gelf) layout() {
+	hdrsize, _, ralign := c.sizes()
+	c.offs = make(map[string]int64)
+	off := hdrsize
+	place := func(name string, size int64, align uint64) {
+		if a := int64(align); a > 1 {
+			off = (off + a - 1) / a * a
+		}
+		c.offs[name] = off
+		off += size
+	}
+	place(".text", c.text.size, addralign(c.text))
+	place(".data", c.data.size, addralign(c.data))
+	c.offs[".bss"] = off
+	place(".symtab", c.symtab.size, uint64(ralign))
+	place(".strtab", c.strtab.size, 1)
+	place(".shstrtab", c.shstrtab.size, 1)
+	if c.reltext.size > 0 {
+		place(c.reltext.name, c.reltext.size, uint64(ralign))
+	}
+	if c.reldata.size > 0 {
+		place(c.reldata.name, c.reldata.size, uint64(ralign))
+	}
+	if c.comment.size > 0 {
+		place(".comment", c.comment.size, 1)
+	}
+	if c.note.size > 0 {
+		place(c.note.name, c.note.size, 4)
+	}
+	place("", 0, 8)
+	c.shoff = off
+}
+
+// sizes returns the size of the header, and the size and the alignment
+// of the relocations and the symbols of the class of the architecture.
+func (c *gelf) sizes() (hdrsize int64, rsz, ralign int) {
+	if c.target.class == elf.ELFCLASS32 {
+		if c.target.rela {
+			return 0x34, 0xc, 4
+		}
+		return 0x34, 0x8, 4
+	}
+	return 0x40, 0x18, 8
+}
+
+// symsize returns the size of a symbol of the class of the architecture.
+func (c *gelf) symsize() int {
+	if c.target.class == elf.ELFCLASS32 {
+		return 0x10
+	}
+	return 0x18
+}
+
+// relname returns the name of the section of the relocations of s.
+func (c *gelf) relname(s *section) string {
+	if c.target.rela {
+		return ".rela" + s.name
+	}
+	return ".rel" + s.name
+}
+
+// pad writes zeros up to the offset off of the file.
+func (c *gelf) pad(off int64) {
+	if n := off - c.w.n; n > 0 {
+		c.w.Write(make([]byte, n))
+	}
+}
+
 // convsym returns a ELF symbol data structure
 // based on the architecture.
 func (c *gelf) convsym(s elf.Symbol) interface{} {
 	name, _ := strconv.ParseInt(s.Name, 0, 64)
-	switch c.arch {
-	case "amd64":
+	switch c.target.class {
+	case elf.ELFCLASS64:
 		return elf.Sym64{
 			Name:  uint32(name),
 			Info:  s.Info,
@@ -83,7 +288,7 @@ func (c *gelf) convsym(s elf.Symbol) interface{} {
 			Value: uint64(s.Value),
 			Size:  uint64(s.Size),
 		}
-	case "i386":
+	case elf.ELFCLASS32:
 		return elf.Sym32{
 			Name:  uint32(name),
 			Info:  s.Info,
@@ -101,15 +306,15 @@ func (c *gelf) convsym(s elf.Symbol) interface{} {
 func (c *gelf) gensymtab() *section {
 	b := new(bytes.Buffer)
 	binary.Write(b, c.endian, c.convsym(elf.Symbol{}))
-	for i := 1; i <= 3; i++ {
+	for i := range c.sections()[:3] {
 		binary.Write(b, c.endian, c.convsym(elf.Symbol{
 			Info:    uint8(elf.STB_LOCAL<<4) | uint8(elf.STT_SECTION),
-			Section: elf.SectionIndex(i),
+			Section: elf.SectionIndex(i + 1),
 		}))
 	}
 
 	name := c.strtab.strings
-	for i, p := range c.osyms {
+	for i, p := range c.symbols {
 		value := uint64(p.off)
 		info := uint8(elf.STB_LOCAL << 4)
 		if p.exported {
@@ -118,18 +323,9 @@ func (c *gelf) gensymtab() *section {
 
 		shndx := uint16(0)
 		if p.sect != nil {
-			switch p.sect.name {
-			case ".text":
-				shndx = 1
-			case ".data":
-				shndx = 2
-			case ".bss":
-				shndx = 3
+			shndx = c.shndx(p.sect)
+			if p.sect == c.bss {
 				info |= uint8(elf.STT_OBJECT)
-			default:
-				if shndx == 0 {
-					errf("unknown section name %q", p.sect.name)
-				}
 			}
 			if !p.allocated {
 				info |= uint8(elf.STT_OBJECT)
@@ -138,6 +334,9 @@ func (c *gelf) gensymtab() *section {
 				if value > 0x10 {
 					value = 0x10
 				}
+				if p.align > 0 {
+					value = uint64(p.align)
+				}
 			}
 		}
 
@@ -150,19 +349,31 @@ func (c *gelf) gensymtab() *section {
 		}))
 	}
 
-	s := newsection(".symtab", "", stSYMTAB)
+	s := newsection(".symtab", 0, stSYMTAB)
 	s.bytes(b.Bytes())
 	return s
 }
 
+// shndx returns the index of the header of section s, the object
+// file only has the predefined sections.
+func (c *gelf) shndx(s *section) uint16 {
+	for i, p := range c.sections()[:3] {
+		if p == s {
+			return uint16(i + 1)
+		}
+	}
+	errf("unknown section name %q", s.name)
+	return 0
+}
+
 // genstrtab generates a .strtab section
 // .strtab is a section that contains all
 // the strings used in the ELF object.
 // It does not do string merging on the strings.
 func (c *gelf) genstrtab() *section {
-	s := newsection(".strtab", "", stSTRTAB)
+	s := newsection(".strtab", 0, stSTRTAB)
 	s.strz("")
-	for _, p := range c.osyms {
+	for _, p := range c.symbols {
 		s.strz(p.name)
 	}
 	return s
@@ -172,56 +383,107 @@ func (c *gelf) genstrtab() *section {
 // .shstrtab section contains the strings
 // for the ELF header section names.
 func (c *gelf) genshstrtab() *section {
-	s := newsection(".shstrtab", "", stSTRTAB)
-	s.strz("")
-	s.strz(".text")
-	s.strz(".data")
-	s.strz(".bss")
-	s.strz(".symtab")
-	s.strz(".strtab")
-	s.strz(".shstrtab")
+	names := []string{"", ".text", ".data", ".bss", ".symtab", ".strtab", ".shstrtab"}
 	if len(c.text.relocs) > 0 {
-		s.strz(".rela.text")
+		names = append(names, c.relname(c.text))
 	}
 	if len(c.data.relocs) > 0 {
-		s.strz(".rela.data")
+		names = append(names, c.relname(c.data))
 	}
+	if len(c.idents) > 0 {
+		names = append(names, ".comment")
+	}
+	if c.note.size > 0 {
+		names = append(names, c.note.name)
+	}
+
+	s := newsection(".shstrtab", 0, stSTRTAB)
+	c.shnames = make(map[string]int64)
+	for _, name := range names {
+		c.shnames[name] = s.size
+		s.strz(name)
+	}
+	return s
+}
+
+// gencomment generates a .comment section
+// .comment section contains the strings of the .ident
+// directives, they say what made the object.
+func (c *gelf) gencomment() *section {
+	s := newsection(".comment", sfMerge|sfStrings, stPROGBITS)
+	if len(c.idents) > 0 {
+		s.strz("")
+	}
+	for _, ident := range c.idents {
+		s.strz(ident)
+	}
+	return s
+}
+
+// gennote generates the note of the os in the object that defines
+// _start, the startup code, so a program linked from the objects has it
+// once. It is empty for the other objects and the oses without a note.
+func (c *gelf) gennote() *section {
+	n, found := elfNotes[c.os]
+	p := c.prog.syms["_start"]
+	if !found || p == nil || p.typ != obj.SymLabel {
+		return newsection("", 0, stNOTE)
+	}
+
+	s := newsection(n.section, sfAlloc, stNOTE)
+	name := append([]byte(n.name), 0)
+	b := new(bytes.Buffer)
+	binary.Write(b, c.endian, [3]uint32{uint32(len(name)), 4, 1})
+	b.Write(name)
+	b.Write(make([]byte, -len(name)&3))
+	binary.Write(b, c.endian, n.desc)
+	s.bytes(b.Bytes())
 	return s
 }
 
 // writehdr writes the ELF header information.
 func (c *gelf) writehdr() {
-	shoff := c.text.size + c.data.size + c.symtab.size + c.strtab.size + c.shstrtab.size
 	shnum := 7
-	if len := len(c.text.relocs); len > 0 {
-		shoff += int64(len * 0x18)
+	if len(c.text.relocs) > 0 {
 		shnum++
 	}
-	if len := len(c.data.relocs); len > 0 {
-		shoff += int64(len * 0x18)
+	if len(c.data.relocs) > 0 {
+		shnum++
+	}
+	if c.comment.size > 0 {
+		shnum++
+	}
+	if c.note.size > 0 {
 		shnum++
 	}
 
-	switch c.arch {
-	case "amd64":
+	data := byte(elf.ELFDATA2LSB)
+	if c.endian == binary.BigEndian {
+		data = byte(elf.ELFDATA2MSB)
+	}
+	osabi := byte(elfOSABIs[c.os])
+	switch c.target.class {
+	case elf.ELFCLASS64:
 		c.write(elf.Header64{
-			Ident:     [elf.EI_NIDENT]byte{0x7f, 'E', 'L', 'F', 0x2, 0x1, 0x1},
+			Ident:     [elf.EI_NIDENT]byte{0x7f, 'E', 'L', 'F', 0x2, data, 0x1, osabi},
 			Type:      1,
-			Machine:   0x3e,
+			Machine:   uint16(c.target.machine),
 			Version:   1,
-			Shoff:     0x40 + uint64(shoff),
+			Flags:     c.target.flags,
+			Shoff:     uint64(c.shoff),
 			Ehsize:    0x40,
 			Shentsize: 0x40,
 			Shnum:     uint16(shnum),
 			Shstrndx:  6,
 		})
-	case "i386":
+	case elf.ELFCLASS32:
 		c.write(elf.Header32{
-			Ident:     [elf.EI_NIDENT]byte{0x7f, 'E', 'L', 'F', 0x1, 0x1, 0x1},
+			Ident:     [elf.EI_NIDENT]byte{0x7f, 'E', 'L', 'F', 0x1, data, 0x1, osabi},
 			Type:      1,
-			Machine:   0x3,
+			Machine:   uint16(c.target.machine),
 			Version:   1,
-			Shoff:     0x34 + uint32(shoff),
+			Flags:     c.target.flags,
+			Shoff:     uint32(c.shoff),
 			Ehsize:    0x34,
 			Shentsize: 0x28,
 			Shnum:     uint16(shnum),
@@ -232,20 +494,7 @@ func (c *gelf) writehdr() {
 
 // writeshdr writes the ELF section headers.
 func (c *gelf) writeshdr() {
-	var (
-		off         int64
-		rsz, ralign int
-	)
-	switch c.arch {
-	case "amd64":
-		off = 0x40
-		rsz = 0x18
-		ralign = 8
-	case "i386":
-		off = 0x32
-		rsz = 0xc
-		ralign = 4
-	}
+	_, rsz, ralign := c.sizes()
 
 	// null
 	c.writeshdra(elf.SectionHeader{})
@@ -254,37 +503,35 @@ func (c *gelf) writeshdr() {
 	c.writeshdra(elf.SectionHeader{
 		Name:      ".text",
 		Type:      elf.SHT_PROGBITS,
-		Flags:     elf.SHF_ALLOC | elf.SHF_EXECINSTR,
+		Flags:     shflags(c.text.flags),
 		Size:      uint64(c.text.size),
-		Offset:    uint64(off),
-		Addralign: 1,
+		Offset:    uint64(c.offs[".text"]),
+		Addralign: addralign(c.text),
 	})
-	off += c.text.size
 
 	// data
 	c.writeshdra(elf.SectionHeader{
 		Name:      ".data",
 		Type:      elf.SHT_PROGBITS,
-		Flags:     elf.SHF_ALLOC | elf.SHF_WRITE,
+		Flags:     shflags(c.data.flags),
 		Size:      uint64(c.data.size),
-		Offset:    uint64(off),
-		Addralign: 1,
+		Offset:    uint64(c.offs[".data"]),
+		Addralign: addralign(c.data),
 	})
-	off += c.data.size
 
 	// bss
 	c.writeshdra(elf.SectionHeader{
 		Name:      ".bss",
 		Type:      elf.SHT_NOBITS,
-		Flags:     elf.SHF_ALLOC | elf.SHF_WRITE,
-		Offset:    uint64(off),
+		Flags:     shflags(c.bss.flags),
+		Offset:    uint64(c.offs[".bss"]),
 		Size:      uint64(c.bss.blocksize),
 		Addralign: uint64(c.bss.blockalign),
 	})
 
-	// symtab
-	info := uint32(0)
-	for i, p := range c.osyms {
+	// symtab, the info is the index of the first global symbol
+	info := uint32(len(c.symbols) + 4)
+	for i, p := range c.symbols {
 		if p.exported {
 			info = 4 + uint32(i)
 			break
@@ -293,95 +540,119 @@ func (c *gelf) writeshdr() {
 	c.writeshdra(elf.SectionHeader{
 		Name:      ".symtab",
 		Type:      elf.SHT_SYMTAB,
-		Offset:    uint64(off),
-		Size:      uint64(0x18 * (len(c.osyms) + 4)),
-		Entsize:   0x18,
+		Offset:    uint64(c.offs[".symtab"]),
+		Size:      uint64(c.symsize() * (len(c.symbols) + 4)),
+		Entsize:   uint64(c.symsize()),
 		Link:      5,
 		Info:      info,
-		Addralign: 8,
+		Addralign: uint64(ralign),
 	})
-	off += c.symtab.size
 
 	// strtab
 	c.writeshdra(elf.SectionHeader{
 		Name:      ".strtab",
 		Type:      elf.SHT_STRTAB,
-		Offset:    uint64(off),
+		Offset:    uint64(c.offs[".strtab"]),
 		Size:      uint64(c.strtab.size),
 		Addralign: 1,
 	})
-	off += c.strtab.size
 
 	// shstrtab
 	c.writeshdra(elf.SectionHeader{
 		Name:      ".shstrtab",
 		Type:      elf.SHT_STRTAB,
-		Offset:    uint64(off),
+		Offset:    uint64(c.offs[".shstrtab"]),
 		Size:      uint64(c.shstrtab.size),
 		Addralign: 1,
 	})
-	off += c.shstrtab.size
 
-	// .rela.text
-	if len := len(c.text.relocs); len > 0 {
+	// .rela.text or .rel.text, and the ones of .data
+	rtyp := elf.SHT_RELA
+	if !c.target.rela {
+		rtyp = elf.SHT_REL
+	}
+	for i, r := range []*section{c.reltext, c.reldata} {
+		if r.size == 0 {
+			continue
+		}
 		c.writeshdra(elf.SectionHeader{
-			Name:      ".rela.text",
-			Type:      elf.SHT_RELA,
+			Name:      r.name,
+			Type:      rtyp,
 			Flags:     elf.SHF_INFO_LINK,
 			Link:      4,
-			Offset:    uint64(off),
-			Size:      uint64(len * rsz),
-			Info:      1,
+			Offset:    uint64(c.offs[r.name]),
+			Size:      uint64(r.size),
+			Info:      uint32(i + 1),
 			Addralign: uint64(ralign),
 			Entsize:   uint64(rsz),
 		})
 	}
 
-	// .rela.data
-	if len := len(c.data.relocs); len > 0 {
+	// .comment
+	if c.comment.size > 0 {
 		c.writeshdra(elf.SectionHeader{
-			Name:      ".rela.data",
-			Type:      elf.SHT_RELA,
-			Flags:     elf.SHF_INFO_LINK,
-			Link:      4,
-			Offset:    uint64(off),
-			Size:      uint64(len * rsz),
-			Info:      2,
-			Addralign: uint64(ralign),
-			Entsize:   uint64(rsz),
+			Name:      ".comment",
+			Type:      elf.SHT_PROGBITS,
+			Flags:     shflags(c.comment.flags),
+			Offset:    uint64(c.offs[".comment"]),
+			Size:      uint64(c.comment.size),
+			Addralign: 1,
+			Entsize:   1,
 		})
 	}
+
+	// the note of the os
+	if c.note.size > 0 {
+		c.writeshdra(elf.SectionHeader{
+			Name:      c.note.name,
+			Type:      elf.SHT_NOTE,
+			Flags:     shflags(c.note.flags),
+			Offset:    uint64(c.offs[c.note.name]),
+			Size:      uint64(c.note.size),
+			Addralign: 4,
+		})
+	}
+}
+
+// addralign returns the alignment of the section in the object, the
+// largest one its align directives asked for.
+func addralign(s *section) uint64 {
+	if s.align > 1 {
+		return uint64(s.align)
+	}
+	return 1
+}
+
+// shflags returns the ELF section header flags of the section flags.
+func shflags(f sectFlags) elf.SectionFlag {
+	var flags elf.SectionFlag
+	for _, x := range []struct {
+		f  sectFlags
+		sh elf.SectionFlag
+	}{
+		{sfAlloc, elf.SHF_ALLOC},
+		{sfWrite, elf.SHF_WRITE},
+		{sfExec, elf.SHF_EXECINSTR},
+		{sfMerge, elf.SHF_MERGE},
+		{sfStrings, elf.SHF_STRINGS},
+	} {
+		if f&x.f != 0 {
+			flags |= x.sh
+		}
+	}
+	return flags
 }
 
 // writeshdra writes the section header
 // for a specific architecture.
 func (c *gelf) writeshdra(h elf.SectionHeader) {
-	name := int64(0)
-	switch h.Name {
-	case ".text":
-		name = 1
-	case ".data":
-		name = 2
-	case ".bss":
-		name = 3
-	case ".symtab":
-		name = 4
-	case ".strtab":
-		name = 5
-	case ".shstrtab":
-		name = 6
-	case ".rela.text":
-		name = 7
-	case ".rela.data":
-		name = 8
-	}
-	if name >= int64(len(c.shstrtab.strings)) {
+	name, found := c.shnames[h.Name]
+	if !found {
 		errf("no name index for section header")
 	}
-	name = c.shstrtab.strings[name].off
 
-	switch c.arch {
-	case "amd64":
+	switch c.target.class {
+	case elf.ELFCLASS64:
 		c.write(elf.Section64{
 			Name:      uint32(name),
 			Type:      uint32(h.Type),
@@ -394,7 +665,7 @@ func (c *gelf) writeshdra(h elf.SectionHeader) {
 			Addralign: uint64(h.Addralign),
 			Entsize:   uint64(h.Entsize),
 		})
-	case "i386":
+	case elf.ELFCLASS32:
 		c.write(elf.Section32{
 			Name:      uint32(name),
 			Type:      uint32(h.Type),
@@ -415,31 +686,27 @@ func (c *gelf) write(v interface{}) {
 }
 
 func (c *gelf) writesection(s *section) {
+	if s.size > 0 {
+		c.pad(c.offs[s.name])
+	}
 	for _, i := range s.inst {
 		c.w.Write(i.code)
 	}
 }
 
-// writereloc writes the relocation information.
-func (c *gelf) writereloc(s *section) {
+// genreloc generates the relocations of s, in a .rela section when the
+// architecture has addends in them and in a .rel section otherwise. The
+// addends of a .rel section are put in the code of s, which hasn't been
+// written yet.
+func (c *gelf) genreloc(s *section) *section {
+	r := newsection(c.relname(s), 0, stNONE)
 	if len(s.relocs) == 0 {
-		return
-	}
-
-	rtyp := [][3]uint64{
-		{uint64(elf.R_X86_64_32S), uint64(elf.R_X86_64_PC32), uint64(elf.R_X86_64_64)},
-		{uint64(elf.R_386_32), uint64(elf.R_386_PC32), uint64(elf.R_386_32)},
-	}
-	var ri int
-	switch c.arch {
-	case "amd64":
-		ri = 0
-	case "i386":
-		ri = 1
+		return r
 	}
 
+	b := new(bytes.Buffer)
 	for _, p := range s.relocs {
-		var info uint64
+		var sym uint64
 		var addend int64
 
 		y := c.syms[p.relname]
@@ -448,61 +715,82 @@ func (c *gelf) writereloc(s *section) {
 		}
 
 		switch y.typ {
-		case sBSS:
+		case obj.SymBSS:
 			if !y.allocated {
-				info = uint64(y.index) + 4
+				sym = uint64(y.index) + 4
 			} else {
-				info = 3
+				sym = 3
 				addend = y.off
 			}
 
 		default:
-			info = uint64(y.index + 4)
+			sym = uint64(y.index + 4)
 			if !y.exported {
 				switch y.sect {
 				case c.text:
-					info = 1
+					sym = 1
 				case c.data:
-					info = 2
+					sym = 2
 				}
 				addend = y.off
 			}
 			switch p.op {
 			case opCALL, opJMP, opJNE, opJE, opJGE, opJLE, opJG, opJL,
-				opJAE, opJBE, opJA, opJB, opJZ, opJNZ:
+				opJAE, opJBE, opJA, opJB, opJZ, opJNZ, opJO, opJNO:
 				addend -= 4
 			}
 		}
-		info <<= 32
-
-		switch p.reltyp {
-		case lS:
-			info |= rtyp[ri][0]
-		case lPC:
-			info |= rtyp[ri][1]
-		case lV:
-			info |= rtyp[ri][2]
-		default:
-			errf("unknown relocation type %d", p.reltyp)
-		}
 
-		if info&0xffffffff == 0 {
-			errf("specified %d but got no relocation type", p.reltyp)
+		addend += p.addend
+
+		typ, found := c.target.relocs[p.reltyp]
+		if !found {
+			errf("unknown relocation type %v", p.reltyp)
 		}
 
-		switch c.arch {
-		case "amd64":
-			c.write(elf.Rela64{
-				Info:   uint64(info),
+		switch {
+		case c.target.class == elf.ELFCLASS64:
+			binary.Write(b, c.endian, elf.Rela64{
+				Info:   sym<<32 | uint64(typ),
 				Off:    uint64(p.rel),
 				Addend: int64(addend),
 			})
-		case "i386":
-			c.write(elf.Rela32{
-				Info:   uint32(info),
+		case c.target.rela:
+			binary.Write(b, c.endian, elf.Rela32{
+				Info:   uint32(sym<<8) | typ,
 				Off:    uint32(p.rel),
 				Addend: int32(addend),
 			})
+		default:
+			c.implicit(p, addend)
+			binary.Write(b, c.endian, elf.Rel32{
+				Info: uint32(sym<<8) | typ,
+				Off:  uint32(p.rel),
+			})
 		}
 	}
+	r.bytes(b.Bytes())
+	return r
+}
+
+// implicit puts the addend of the relocation p in the field of the
+// code that p relocates, for a .rel section that has no addends. The
+// high half of an address is rounded up when the sign of the low half
+// takes from it, as the linker pairs it with the low half.
+func (c *gelf) implicit(p *relocation, addend int64) {
+	code := p.code[p.rel-p.off:]
+	v := c.endian.Uint32(code)
+	switch p.reltyp {
+	case obj.RelocAbs, obj.RelocPC, obj.RelocData:
+		v += uint32(addend)
+	case obj.RelocHi:
+		v = v&^0xffff | uint32(addend+0x8000)>>16&0xffff
+	case obj.RelocLo:
+		v = v&^0xffff | uint32(addend)&0xffff
+	case obj.RelocJump:
+		v = v&^0x3ffffff | uint32(addend)>>2&0x3ffffff
+	default:
+		errf("unknown relocation type %v", p.reltyp)
+	}
+	c.endian.PutUint32(code, v)
 }
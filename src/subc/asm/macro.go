@@ -0,0 +1,256 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The macros of the assembler are defined with
+//
+//	.macro name param, param=default ...
+//	body
+//	.endm
+//
+// and a line with name as its instruction expands to the body, with
+// \param replaced by the argument at its place in the comma separated
+// arguments of the line, the default if it is left out. An argument in
+// double quotes is passed without them, so it can have commas and blanks
+// and the body puts it in a string with "\param". \() is replaced
+// by nothing, to end the name of a parameter that text follows, and \@
+// by the number of the expansion, to make the labels of a body unique.
+// A body can use the other macros and define new ones, which are defined
+// when it is expanded.
+
+// maxMacroDepth is how deep the expansions of the macros can nest, a
+// macro that expands itself with no end stops there.
+const maxMacroDepth = 100
+
+// macro is a macro defined by .macro.
+type macro struct {
+	name   string
+	params []macroParam
+	body   []macroLine
+	lineno int64 // the line of the .macro
+}
+
+// macroParam is a parameter of a macro, def is its default, and req
+// is false if it has one.
+type macroParam struct {
+	name string
+	def  string
+	req  bool
+}
+
+// macroLine is a line of the body of a macro with its line in the file.
+type macroLine struct {
+	text   string
+	lineno int64
+}
+
+// expansion is the line that expands a macro, for the diagnostics of
+// the lines of its body.
+type expansion struct {
+	macro  *macro
+	line   string
+	lineno int64
+}
+
+// source assembles a line of the file or of the body of a macro, it
+// keeps the lines of the macro being defined. It returns false when
+// the line ends the assembly.
+func (as *as) source(arch assembler, line string) bool {
+	if err := as.ctx.Err(); err != nil {
+		panic(err)
+	}
+	if as.defining == nil {
+		return as.parse(arch, line)
+	}
+
+	// the .macro and .endm of the macros defined by the body are kept
+	// in it, with the lines between them
+	switch as.macroDirective(line) {
+	case ".macro":
+		as.nested++
+	case ".endm":
+		if as.nested == 0 {
+			as.macros[as.defining.name] = as.defining
+			as.defining = nil
+			return true
+		}
+		as.nested--
+	}
+	as.defining.body = append(as.defining.body, macroLine{line, as.lineno})
+	return true
+}
+
+// macroDirective returns the instruction of line if it is .macro or
+// .endm, an empty string otherwise.
+func (as *as) macroDirective(line string) string {
+	if i := as.comment(line); i >= 0 {
+		line = line[:i]
+	}
+	f := strings.Fields(line)
+	if len(f) > 0 && (f[0] == ".macro" || f[0] == ".endm") {
+		return f[0]
+	}
+	return ""
+}
+
+// defmacro starts the definition of the macro of the .macro line with
+// the operands args, the lines up to its .endm are its body.
+func (as *as) defmacro(args string) {
+	f := strings.FieldsFunc(args, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+	if len(f) == 0 {
+		as.errorf(".macro takes the name of the macro")
+	}
+	m := &macro{name: f[0], lineno: as.lineno}
+	if !isIdent(m.name) {
+		as.errorf("invalid macro name %q", m.name)
+	}
+	if _, found := as.macros[m.name]; found {
+		as.errorf("macro %s is already defined", m.name)
+	}
+	for _, s := range f[1:] {
+		p := macroParam{name: s, req: true}
+		if i := strings.IndexByte(s, '='); i >= 0 {
+			p = macroParam{name: s[:i], def: s[i+1:]}
+		}
+		if p.name == "" || !isIdent(p.name) {
+			as.errorf("invalid parameter %q of macro %s", p.name, m.name)
+		}
+		for _, q := range m.params {
+			if q.name == p.name {
+				as.errorf("parameter %s of macro %s is declared twice", p.name, m.name)
+			}
+		}
+		m.params = append(m.params, p)
+	}
+	if as.macros == nil {
+		as.macros = make(map[string]*macro)
+	}
+	as.defining = m
+	as.nested = 0
+}
+
+// expand assembles the body of macro m with the arguments of the line
+// that uses it, it returns false when a line of the body ends the
+// assembly.
+func (as *as) expand(arch assembler, m *macro, args string) bool {
+	if len(as.expansions) >= maxMacroDepth {
+		as.errorf("macro %s is expanded more than %d deep", m.name, maxMacroDepth)
+	}
+
+	var vals []string
+	if args = strings.TrimSpace(args); args != "" {
+		vals = splitArgs(args)
+	}
+	if len(vals) > len(m.params) {
+		as.errorf("macro %s takes %d arguments, not %d", m.name, len(m.params), len(vals))
+	}
+	subst := make(map[string]string)
+	for i, p := range m.params {
+		v := p.def
+		if i < len(vals) {
+			v = strings.TrimSpace(vals[i])
+		}
+		if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+			v = v[1 : len(v)-1]
+		}
+		if v == "" && p.req {
+			as.errorf("missing argument %s of macro %s", p.name, m.name)
+		}
+		subst[p.name] = v
+	}
+	count := strconv.Itoa(as.nexpanded)
+	as.nexpanded++
+
+	as.expansions = append(as.expansions, expansion{m, as.line, as.lineno})
+	line, lineno := as.line, as.lineno
+	ok := true
+	for _, b := range m.body {
+		as.line = substitute(b.text, subst, count)
+		as.lineno = b.lineno
+		if ok = as.source(arch, as.line); !ok {
+			break
+		}
+	}
+	if as.defining != nil {
+		as.errorf("missing .endm of macro %s in the body of macro %s", as.defining.name, m.name)
+	}
+	as.line, as.lineno = line, lineno
+	as.expansions = as.expansions[:len(as.expansions)-1]
+	return ok
+}
+
+// substitute replaces the parameters in a line of the body of a macro
+// with the arguments of an expansion and \@ with its count.
+func substitute(line string, subst map[string]string, count string) string {
+	var b strings.Builder
+	for i := 0; i < len(line); i++ {
+		if line[i] != '\\' || i+1 == len(line) {
+			b.WriteByte(line[i])
+			continue
+		}
+		switch j := i + 1; {
+		case line[j] == '@':
+			b.WriteString(count)
+			i = j
+		case line[j] == '(' && j+1 < len(line) && line[j+1] == ')':
+			i = j + 1
+		default:
+			for j < len(line) && isIdentChar(line[j], j > i+1) {
+				j++
+			}
+			if v, found := subst[line[i+1:j]]; found {
+				b.WriteString(v)
+				i = j - 1
+			} else {
+				b.WriteByte('\\')
+			}
+		}
+	}
+	return b.String()
+}
+
+// isIdentChar reports whether c can be in the name of a parameter, the
+// digits only after the first character.
+func isIdentChar(c byte, inner bool) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || inner && '0' <= c && c <= '9'
+}
+
+// maxMacroNotes is how many notes of the expansions the diagnostics
+// print at most, the ones in the middle are counted instead.
+const maxMacroNotes = 6
+
+// expansionNotes returns the lines of the expansions of the macros that
+// the line being assembled comes from, the innermost one first. The
+// expansions repeated by the same line, like the ones of a macro that
+// expands itself, are one note with how many times it was expanded.
+func (as *as) expansionNotes() string {
+	var notes []string
+	for i := len(as.expansions) - 1; i >= 0; {
+		e := as.expansions[i]
+		n := 1
+		for i-n >= 0 && as.expansions[i-n] == e {
+			n++
+		}
+		i -= n
+		note := fmt.Sprintf("%s: note: in expansion of macro %s, defined at line %d: %q", as.pos(e.lineno), e.macro.name, e.macro.lineno, e.line)
+		if n > 1 {
+			note += fmt.Sprintf(", %d times", n)
+		}
+		notes = append(notes, note)
+	}
+	if len(notes) > maxMacroNotes {
+		n := len(notes) - maxMacroNotes + 1
+		notes = append(notes[:maxMacroNotes/2], append([]string{fmt.Sprintf("note: %d more expansions", n)}, notes[len(notes)-maxMacroNotes/2+1:]...)...)
+	}
+	var b strings.Builder
+	for _, note := range notes {
+		b.WriteString("\n" + note)
+	}
+	return b.String()
+}
@@ -0,0 +1,634 @@
+package asm
+
+import (
+	"math"
+	"strings"
+
+	"subc/obj"
+)
+
+const (
+	opADDIU op = iota + 200
+	opADDU
+	opAND
+	opANDI
+	opBEQ
+	opBGEZ
+	opBGTZ
+	opBLEZ
+	opBLTZ
+	opBNE
+	opDIV
+	opDIVU
+	opJ
+	opJAL
+	opJALR
+	opJR
+	opLB
+	opLBU
+	opLH
+	opLHU
+	opLUI
+	opLW
+	opMFHI
+	opMFLO
+	opMUL
+	opMULT
+	opMULTU
+	opNOR
+	opOR
+	opORI
+	opSB
+	opSH
+	opSLL
+	opSLLV
+	opSLT
+	opSLTI
+	opSLTIU
+	opSLTU
+	opSRA
+	opSRAV
+	opSRL
+	opSRLV
+	opSUBU
+	opSW
+	opTEQ
+	opXOR
+	opXORI
+)
+
+// mips assembles the MIPS32 instructions of the o32 abi, in big-endian
+// objects. The source is in the reorder mode of the GNU assembler unless
+// .set noreorder says otherwise, a nop is put in the delay slot of each
+// branch and jump then.
+type mips struct {
+	*as
+	noreorder bool
+}
+
+// mipsRegs are the registers by their numbers and their names in the abi.
+var mipsRegs = map[string]byte{
+	"zero": 0, "at": 1, "v0": 2, "v1": 3,
+	"a0": 4, "a1": 5, "a2": 6, "a3": 7,
+	"t0": 8, "t1": 9, "t2": 10, "t3": 11,
+	"t4": 12, "t5": 13, "t6": 14, "t7": 15,
+	"s0": 16, "s1": 17, "s2": 18, "s3": 19,
+	"s4": 20, "s5": 21, "s6": 22, "s7": 23,
+	"t8": 24, "t9": 25, "k0": 26, "k1": 27,
+	"gp": 28, "sp": 29, "fp": 30, "s8": 30, "ra": 31,
+}
+
+// mipsR are the function codes of the register instructions, and the
+// opcodes of the immediate ones that take the same operands.
+var mipsR = map[string]struct {
+	op    op
+	funct uint32
+	imm   string // the instruction taking an immediate instead of rt
+}{
+	"addu": {opADDU, 0x21, "addiu"},
+	"subu": {opSUBU, 0x23, ""},
+	"and":  {opAND, 0x24, "andi"},
+	"or":   {opOR, 0x25, "ori"},
+	"xor":  {opXOR, 0x26, "xori"},
+	"nor":  {opNOR, 0x27, ""},
+	"slt":  {opSLT, 0x2a, "slti"},
+	"sltu": {opSLTU, 0x2b, "sltiu"},
+}
+
+// mipsI are the opcodes of the instructions with a 16-bit immediate,
+// it is zero extended by the logical ones.
+var mipsI = map[string]struct {
+	op       op
+	opcode   uint32
+	unsigned bool
+}{
+	"addiu": {opADDIU, 0x09, false},
+	"slti":  {opSLTI, 0x0a, false},
+	"sltiu": {opSLTIU, 0x0b, false},
+	"andi":  {opANDI, 0x0c, true},
+	"ori":   {opORI, 0x0d, true},
+	"xori":  {opXORI, 0x0e, true},
+}
+
+// mipsMem are the opcodes of the loads and the stores.
+var mipsMem = map[string]struct {
+	op     op
+	opcode uint32
+}{
+	"lb":  {opLB, 0x20},
+	"lh":  {opLH, 0x21},
+	"lw":  {opLW, 0x23},
+	"lbu": {opLBU, 0x24},
+	"lhu": {opLHU, 0x25},
+	"sb":  {opSB, 0x28},
+	"sh":  {opSH, 0x29},
+	"sw":  {opSW, 0x2b},
+}
+
+// mipsShifts are the function codes of the shifts by a constant, the
+// ones of the shifts by a register are 4 more. Either shift takes a
+// constant or a register count.
+var mipsShifts = map[string]struct {
+	op, vop op
+	funct   uint32
+}{
+	"sll":  {opSLL, opSLLV, 0x00},
+	"srl":  {opSRL, opSRLV, 0x02},
+	"sra":  {opSRA, opSRAV, 0x03},
+	"sllv": {opSLL, opSLLV, 0x00},
+	"srlv": {opSRL, opSRLV, 0x02},
+	"srav": {opSRA, opSRAV, 0x03},
+}
+
+// mipsBranches are the branches that compare a register with zero, by
+// their opcode and the rt field that tells them apart.
+var mipsBranches = map[string]struct {
+	op         op
+	opcode, rt uint32
+}{
+	"bltz": {opBLTZ, 0x01, 0},
+	"bgez": {opBGEZ, 0x01, 1},
+	"blez": {opBLEZ, 0x06, 0},
+	"bgtz": {opBGTZ, 0x07, 0},
+}
+
+// args parses the comma separated operands of an instruction.
+func (as *mips) args(line string) [4]addr {
+	var addr [4]addr
+	args := splitArgs(strings.TrimSpace(line))
+	if len(args) > len(addr) {
+		as.errorf("junk at end")
+	}
+	for i, arg := range args {
+		addr[i] = as.arg(strings.TrimSpace(arg))
+	}
+	return addr
+}
+
+// arg decodes an argument: a register, an integer, a symbol or a symbol
+// plus a constant, the %hi or the %lo of a symbol, or a memory operand
+// off(base) where off is an integer or the %lo of a symbol. The %lo of a
+// symbol is added by addiu.
+func (as *mips) arg(s string) (a addr) {
+	if a, ok := as.literal(s); ok {
+		return a
+	}
+	if sym, n, ok := symPlus(s); ok {
+		a.typ = aPTR
+		a.sval = sym
+		a.ival = n
+		return a
+	}
+
+	switch {
+	case strings.HasPrefix(s, "$"):
+		a.typ = aREG
+		a.reg = as.reg(s)
+		a.sval = s[1:]
+	case isIdent(s):
+		a.typ = aPTR
+		a.sval = s
+	case strings.HasPrefix(s, "%hi(") && strings.HasSuffix(s, ")"):
+		a.typ = aPTR
+		a.sval = as.sym(s[len("%hi(") : len(s)-1])
+		a.part = obj.RelocHi
+	case strings.HasPrefix(s, "%lo(") && strings.Count(s, "(") == 1 && strings.HasSuffix(s, ")"):
+		a.typ = aPTR
+		a.sval = as.sym(s[len("%lo(") : len(s)-1])
+		a.part = obj.RelocLo
+	case strings.HasSuffix(s, ")"):
+		i := strings.LastIndex(s, "(")
+		if i < 0 {
+			as.errorf("invalid memory operand %q", s)
+		}
+		a.typ = aMEM
+		a.reg = as.reg(s[i+1 : len(s)-1])
+		off := strings.TrimSpace(s[:i])
+		switch {
+		case off == "":
+		case strings.HasPrefix(off, "%lo(") && strings.HasSuffix(off, ")"):
+			a.sval = as.sym(off[len("%lo(") : len(off)-1])
+			a.part = obj.RelocLo
+		default:
+			a.ival = as.number(off)
+		}
+	case isNumber(s):
+		a.typ = aINT
+		a.ival = as.number(s)
+	default:
+		as.errorf("invalid argument %q", s)
+	}
+	return
+}
+
+// reg returns the number of the register $n or $name.
+func (as *mips) reg(s string) byte {
+	if !strings.HasPrefix(s, "$") {
+		as.errorf("%q is not a register", s)
+	}
+	s = s[1:]
+	if r, ok := mipsRegs[s]; ok {
+		return r
+	}
+	if isNumber(s) && s[0] != '-' && s[0] != '+' {
+		if n := as.number(s); n < 32 {
+			return byte(n)
+		}
+	}
+	as.errorf("unknown register $%s", s)
+	panic("unreachable")
+}
+
+// sym returns the symbol of a %hi or a %lo, it can't have an offset.
+func (as *mips) sym(s string) string {
+	s = strings.TrimSpace(s)
+	if !isIdent(s) {
+		as.errorf("%q is not a symbol", s)
+	}
+	return s
+}
+
+// inst assembles an instruction or a directive.
+func (as *mips) inst(op_ string, addr [4]addr) bool {
+	unk := func() {
+		as.errorf("unknown argument")
+	}
+	n := 0
+	for n < len(addr) && addr[n].typ != aNONE {
+		n++
+	}
+	nargs := func(want ...int) {
+		for _, w := range want {
+			if n == w {
+				return
+			}
+		}
+		as.errorf("%s takes %d operand(s), got %d", op_, want[0], n)
+	}
+
+	x, y, z := addr[0], addr[1], addr[2]
+	lop := strings.ToLower(op_)
+	if as.directive(lop, addr) {
+		return true
+	}
+
+	if d, ok := mipsR[lop]; ok {
+		nargs(3)
+		if z.typ == aINT && d.imm != "" {
+			lop = d.imm
+		} else {
+			as.regs(x, y, z)
+			as.emit(d.op, addr, as.rtype(y.reg, z.reg, x.reg, 0, d.funct))
+			as.sect.pc++
+			return true
+		}
+	}
+	if d, ok := mipsI[lop]; ok {
+		nargs(3)
+		as.regs(x, y)
+		if z.typ == aPTR && z.part == obj.RelocLo && lop == "addiu" {
+			as.reloc(d.op, addr, obj.RelocLo, z.sval, as.itype(d.opcode, y.reg, x.reg, 0))
+			as.sect.pc++
+			return true
+		}
+		if z.typ != aINT {
+			unk()
+		}
+		as.emit(d.op, addr, as.itype(d.opcode, y.reg, x.reg, as.imm16(lop, z.ival, d.unsigned)))
+		as.sect.pc++
+		return true
+	}
+	if d, ok := mipsMem[lop]; ok {
+		nargs(2)
+		as.regs(x)
+		if y.typ != aMEM {
+			unk()
+		}
+		if y.part == obj.RelocLo {
+			as.reloc(d.op, addr, obj.RelocLo, y.sval, as.itype(d.opcode, y.reg, x.reg, 0))
+		} else {
+			as.emit(d.op, addr, as.itype(d.opcode, y.reg, x.reg, as.imm16(lop, y.ival, false)))
+		}
+		as.sect.pc++
+		return true
+	}
+	if d, ok := mipsShifts[lop]; ok {
+		nargs(3)
+		as.regs(x, y)
+		switch z.typ {
+		case aINT:
+			if z.ival < 0 || z.ival > 31 {
+				as.errorf("%s: shift count %d out of range [0, 31]", lop, z.ival)
+			}
+			as.emit(d.op, addr, as.rtype(0, y.reg, x.reg, uint32(z.ival), d.funct))
+		case aREG:
+			as.emit(d.vop, addr, as.rtype(z.reg, y.reg, x.reg, 0, d.funct|4))
+		default:
+			unk()
+		}
+		as.sect.pc++
+		return true
+	}
+	if d, ok := mipsBranches[lop]; ok {
+		nargs(2)
+		as.regs(x)
+		as.branch(d.op, addr, y, as.itype(d.opcode, x.reg, byte(d.rt), 0))
+		as.sect.pc++
+		return true
+	}
+
+	switch lop {
+	case ".abort":
+		return false
+	case ".extern":
+	case ".set":
+		switch x.sval {
+		case "reorder":
+			as.noreorder = false
+		case "noreorder":
+			as.noreorder = true
+		default:
+			as.errorf(".set %s is not supported", x.sval)
+		}
+	case ".word":
+		as.ranges(lop, addr[:], 4)
+		as.words(opLONG, addr, 4)
+	case ".half", ".short":
+		as.ranges(lop, addr[:], 2)
+		as.words(opSHORT, addr, 2)
+	case ".byte":
+		as.ranges(lop, addr[:], 1)
+		as.words(opBYTE, addr, 1)
+	case ".align", ".p2align":
+		if x.ival < 0 || 1<<uint(x.ival) > maxAlign {
+			as.errorf("%s: alignment 2**%d out of range", lop, x.ival)
+		}
+		as.ranges(lop, addr[1:2], 1)
+		as.alignpc(1<<uint(x.ival), fillValue(uint8(y.ival)))
+	case ".balign":
+		if x.ival <= 0 || x.ival > maxAlign || x.ival&(x.ival-1) != 0 {
+			as.errorf("%s: alignment %d is not a power of 2 up to %d", lop, x.ival, maxAlign)
+		}
+		as.ranges(lop, addr[1:2], 1)
+		as.alignpc(int(x.ival), fillValue(uint8(y.ival)))
+	case "nop":
+		nargs(0)
+		as.emit(opNOP, addr, uint32(0))
+	case "move":
+		nargs(2)
+		as.regs(x, y)
+		as.emit(opADDU, addr, as.rtype(y.reg, 0, x.reg, 0, 0x21))
+	case "negu":
+		nargs(2)
+		as.regs(x, y)
+		as.emit(opSUBU, addr, as.rtype(0, y.reg, x.reg, 0, 0x23))
+	case "not":
+		nargs(2)
+		as.regs(x, y)
+		as.emit(opNOR, addr, as.rtype(y.reg, 0, x.reg, 0, 0x27))
+	case "li":
+		nargs(2)
+		as.regs(x)
+		if y.typ != aINT {
+			unk()
+		}
+		if y.ival < math.MinInt32 || y.ival > math.MaxUint32 {
+			as.errorf("li: value %d out of range", y.ival)
+		}
+		v := uint32(y.ival)
+		switch {
+		case -0x8000 <= y.ival && y.ival < 0x8000:
+			as.emit(opADDIU, addr, as.itype(0x09, 0, x.reg, v&0xffff))
+		case v <= 0xffff:
+			as.emit(opORI, addr, as.itype(0x0d, 0, x.reg, v))
+		case v&0xffff == 0:
+			as.emit(opLUI, addr, as.itype(0x0f, 0, x.reg, v>>16))
+		default:
+			as.emit(opLUI, addr, as.itype(0x0f, 0, x.reg, v>>16))
+			as.emit(opORI, addr, as.itype(0x0d, x.reg, x.reg, v&0xffff))
+		}
+	case "lui":
+		nargs(2)
+		as.regs(x)
+		switch {
+		case y.typ == aPTR && y.part == obj.RelocHi:
+			as.reloc(opLUI, addr, obj.RelocHi, y.sval, as.itype(0x0f, 0, x.reg, 0))
+		case y.typ == aINT:
+			as.emit(opLUI, addr, as.itype(0x0f, 0, x.reg, as.imm16(lop, y.ival, true)))
+		default:
+			unk()
+		}
+	case "mult", "multu", "div", "divu":
+		funct := map[string]uint32{"mult": 0x18, "multu": 0x19, "div": 0x1a, "divu": 0x1b}[lop]
+		o := map[string]op{"mult": opMULT, "multu": opMULTU, "div": opDIV, "divu": opDIVU}[lop]
+		nargs(2, 3)
+		if n == 3 {
+			// div $zero, rs, rt is the instruction without the checks
+			// that the macro with a destination register adds
+			as.regs(x)
+			if x.reg != 0 || lop == "mult" || lop == "multu" {
+				as.errorf("%s with a destination register is not supported", lop)
+			}
+			x, y = y, z
+		}
+		as.regs(x, y)
+		as.emit(o, addr, as.rtype(x.reg, y.reg, 0, 0, funct))
+	case "mul":
+		nargs(3)
+		as.regs(x, y, z)
+		as.emit(opMUL, addr, 0x1c<<26|as.rtype(y.reg, z.reg, x.reg, 0, 0x02))
+	case "mfhi", "mflo":
+		nargs(1)
+		as.regs(x)
+		if lop == "mfhi" {
+			as.emit(opMFHI, addr, as.rtype(0, 0, x.reg, 0, 0x10))
+		} else {
+			as.emit(opMFLO, addr, as.rtype(0, 0, x.reg, 0, 0x12))
+		}
+	case "teq":
+		nargs(2, 3)
+		as.regs(x, y)
+		if z.ival < 0 || z.ival > 0x3ff {
+			as.errorf("teq: code %d out of range [0, 1023]", z.ival)
+		}
+		as.emit(opTEQ, addr, as.rtype(x.reg, y.reg, 0, 0, 0x34)|uint32(z.ival)<<6)
+	case "syscall":
+		nargs(0)
+		as.emit(opSYSCALL, addr, uint32(0x0c))
+	case "beq", "bne":
+		nargs(3)
+		as.regs(x, y)
+		o, opcode := opBEQ, uint32(0x04)
+		if lop == "bne" {
+			o, opcode = opBNE, 0x05
+		}
+		as.branch(o, addr, z, as.itype(opcode, x.reg, y.reg, 0))
+	case "beqz", "bnez":
+		nargs(2)
+		as.regs(x)
+		o, opcode := opBEQ, uint32(0x04)
+		if lop == "bnez" {
+			o, opcode = opBNE, 0x05
+		}
+		as.branch(o, addr, y, as.itype(opcode, x.reg, 0, 0))
+	case "b":
+		nargs(1)
+		as.branch(opBEQ, addr, x, as.itype(0x04, 0, 0, 0))
+	case "j", "jal":
+		nargs(1)
+		if x.typ != aPTR || x.part != obj.RelocNone {
+			unk()
+		}
+		o, opcode := opJ, uint32(0x02)
+		if lop == "jal" {
+			o, opcode = opJAL, 0x03
+		}
+		as.reloc(o, addr, obj.RelocJump, x.sval, opcode<<26)
+		as.delay()
+	case "jr":
+		nargs(1)
+		as.regs(x)
+		as.emit(opJR, addr, as.rtype(x.reg, 0, 0, 0, 0x08))
+		as.delay()
+	case "jalr":
+		nargs(1, 2)
+		rd := byte(31)
+		if n == 2 {
+			as.regs(x)
+			rd, x = x.reg, y
+		}
+		as.regs(x)
+		as.emit(opJALR, addr, as.rtype(x.reg, 0, rd, 0, 0x09))
+		as.delay()
+	default:
+		as.errorf("unknown instruction %s", lop)
+	}
+
+	as.sect.pc++
+	return true
+}
+
+// regs checks that the operands are registers.
+func (as *mips) regs(args ...addr) {
+	for _, a := range args {
+		if a.typ != aREG {
+			as.errorf("unknown argument")
+		}
+	}
+}
+
+// rtype encodes an instruction of the register format.
+func (as *mips) rtype(rs, rt, rd byte, sa, funct uint32) uint32 {
+	return uint32(rs)<<21 | uint32(rt)<<16 | uint32(rd)<<11 | sa<<6 | funct
+}
+
+// itype encodes an instruction of the immediate format, imm is the
+// low 16 bits.
+func (as *mips) itype(opcode uint32, rs, rt byte, imm uint32) uint32 {
+	return opcode<<26 | uint32(rs)<<21 | uint32(rt)<<16 | imm&0xffff
+}
+
+// imm16 checks that v fits in the 16-bit immediate of the instruction,
+// signed unless unsigned is set, and returns it.
+func (as *mips) imm16(lop string, v int64, unsigned bool) uint32 {
+	min, max := int64(-0x8000), int64(0x7fff)
+	if unsigned {
+		min, max = 0, 0xffff
+	}
+	if v < min || v > max {
+		as.errorf("%s: immediate %d out of range [%d, %d]", lop, v, min, max)
+	}
+	return uint32(v) & 0xffff
+}
+
+// reloc emits the instruction code whose field is relocated by the part
+// of the address of the symbol name given by reltyp. The field is left
+// at zero, it gets the addend when the object is written.
+func (as *mips) reloc(op op, addr [4]addr, reltyp obj.RelocKind, name string, code uint32) {
+	as.addrel(op, addr)
+	p := as.relocs[len(as.relocs)-1]
+	p.code = as.code(code)
+	p.isize = len(p.code)
+	p.reltyp = reltyp
+	p.relname = name
+	p.rel = p.off
+	as.sect.size += int64(p.isize)
+}
+
+// branch emits a branch to the label l, the offset is filled in when the
+// label is known by finish.
+func (as *mips) branch(op op, addr [4]addr, l addr, code uint32) {
+	if l.typ != aPTR || l.part != obj.RelocNone {
+		as.errorf("a branch takes a label")
+	}
+	as.reloc(op, addr, obj.RelocNone, l.sval, code)
+	as.delay()
+}
+
+// delay fills the delay slot of a branch or a jump with a nop, unless
+// the source does it in noreorder mode.
+func (as *mips) delay() {
+	if !as.noreorder {
+		as.emit(opNOP, [4]addr{}, uint32(0))
+	}
+}
+
+// words emits the integers and the addresses of symbols of a directive
+// in size bytes each, the addresses are relocated.
+func (as *mips) words(op op, addr [4]addr, size int) {
+	for _, a := range addr {
+		switch a.typ {
+		case aNONE:
+			return
+		case aINT:
+			as.emit(op, addr, obj.Value(as.endian, size, a.ival))
+		case aPTR:
+			if size != 4 || a.part != obj.RelocNone {
+				as.errorf("an address takes 4 bytes")
+			}
+			as.reloc(op, addr, obj.RelocData, a.sval, 0)
+		default:
+			as.errorf("unknown argument")
+		}
+	}
+}
+
+// finish resolves the branches to the labels of their sections and
+// the symbols of the relocations left for the linker.
+func (as *mips) finish() {
+	as.fixupRelocs(as.text)
+	as.fixupRelocs(as.data)
+	for _, s := range as.sects {
+		as.fixupRelocs(s)
+	}
+	as.fixupBSS()
+	for _, s := range as.sections() {
+		if s.size > as.max {
+			as.errorf("section %s of %d bytes is larger than the maximum of %d bytes", s.name, s.size, as.max)
+		}
+	}
+}
+
+// fixupRelocs puts the offsets of the branches of s in their code, a
+// branch is relative to the instruction in its delay slot and counts
+// the instructions, its label has to be in s. The other relocations
+// are kept for the object file.
+func (as *mips) fixupRelocs(s *section) {
+	for i := 0; i < len(s.relocs); {
+		p := s.relocs[i]
+		l := as.fsym(aPTR, p.relname)
+		if p.reltyp != obj.RelocNone {
+			i++
+			continue
+		}
+		if l.typ != obj.SymLabel || l.sect != s {
+			as.errorf("branch to %q, which is not a label of %s", p.relname, s.name)
+		}
+		off := (l.off - p.off - 4) >> 2
+		if off < -0x8000 || off > 0x7fff {
+			as.errorf("branch to %q out of range", p.relname)
+		}
+		as.endian.PutUint32(p.code, as.endian.Uint32(p.code)|uint32(off)&0xffff)
+		s.relocs = append(s.relocs[:i], s.relocs[i+1:]...)
+	}
+}
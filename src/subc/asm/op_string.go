@@ -6,21 +6,36 @@ import "fmt"
 
 const (
 	_op_name_0 = "opNOPopSTRZopQUADopLONGopSHORTopBYTEopBYTES"
-	_op_name_1 = "opADDQopANDQopCALLopCLDopCLIopCMPQopCQOopDECBopDECQopDIVQopHLTopIDIVQopIMULQopINCBopINCQopINTopJAopJAEopJBopJBEopJEopJGopJGEopJLopJLEopJMPopJNEopJNZopJZopLEAQopLODSLopLODSQopLOOPopLOOPEopLOOPNEopMOVBopMOVLopMOVQopNEGQopNOTQopORQopPOPQopPUSHQopRETopSARQopSBBQopSEIopSHLQopSHRQopSTIopSUBQopSYSCALLopXCHGQopXORQ"
+	_op_name_1 = "opADDQopANDQopCALLopCLDopCLIopCMPQopCQOopDECBopDECQopDIVQopHLTopIDIVQopIMULQopINCBopINCQopINTopJAopJAEopJBopJBEopJEopJGopJGEopJLopJLEopJMPopJNEopJNOopJNZopJOopJZopLEAQopLODSLopLODSQopLOOPopLOOPEopLOOPNEopMOVBopMOVLopMOVQopMOVSBopMOVSBQopMOVSWQopMOVZBQopMOVZWQopMULQopNEGQopNOTQopORQopPOPQopPUSHQopREPopRETopSARQopSBBQopSEIopSHLQopSHRQopSTIopSTOSBopSUBQopSYSCALLopXCHGQopXORQ"
+	_op_name_2 = "opADDIUopADDUopANDopANDIopBEQopBGEZopBGTZopBLEZopBLTZopBNEopDIVopDIVUopJopJALopJALRopJRopLBopLBUopLHopLHUopLUIopLWopMFHIopMFLOopMULopMULTopMULTUopNORopORopORIopSBopSHopSLLopSLLVopSLTopSLTIopSLTIUopSLTUopSRAopSRAVopSRLopSRLVopSUBUopSWopTEQopXORopXORI"
+	_op_name_3 = "opA64ADDopA64ADDSopA64ADRopA64ADRPopA64ANDopA64ANDSopA64ASRopA64BopA64BCONDopA64BICopA64BLopA64BLRopA64BRopA64BRKopA64CBNZopA64CBZopA64CSELopA64CSINCopA64CSINVopA64CSNEGopA64EORopA64EXTRopA64LDPopA64LDRopA64LDRBopA64LDRHopA64LDRSBopA64LDRSHopA64LDRSWopA64LSLopA64LSRopA64MADDopA64MOVKopA64MOVNopA64MOVZopA64MSUBopA64ORNopA64ORRopA64RETopA64RORopA64SBFMopA64SDIVopA64SMULHopA64STPopA64STRopA64STRBopA64STRHopA64SUBopA64SUBSopA64SVCopA64UBFMopA64UDIVopA64UMULH"
+	_op_name_4 = "opRVADDopRVADDIopRVADDIWopRVADDWopRVANDopRVANDIopRVAUIPCopRVBEQopRVBGEopRVBGEUopRVBLTopRVBLTUopRVBNEopRVCALLopRVDIVopRVDIVUopRVDIVUWopRVDIVWopRVEBREAKopRVECALLopRVFENCEopRVJALopRVJALRopRVLBopRVLBUopRVLDopRVLHopRVLHUopRVLUIopRVLWopRVLWUopRVMULopRVMULHopRVMULHSUopRVMULHUopRVMULWopRVORopRVORIopRVREMopRVREMUopRVREMUWopRVREMWopRVSBopRVSDopRVSHopRVSLLopRVSLLIopRVSLLIWopRVSLLWopRVSLTopRVSLTIopRVSLTIUopRVSLTUopRVSRAopRVSRAIopRVSRAIWopRVSRAWopRVSRLopRVSRLIopRVSRLIWopRVSRLWopRVSUBopRVSUBWopRVSWopRVXORopRVXORI"
 )
 
 var (
 	_op_index_0 = [...]uint8{0, 5, 11, 17, 23, 30, 36, 43}
-	_op_index_1 = [...]uint16{0, 6, 12, 18, 23, 28, 34, 39, 45, 51, 57, 62, 69, 76, 82, 88, 93, 97, 102, 106, 111, 115, 119, 124, 128, 133, 138, 143, 148, 152, 158, 165, 172, 178, 185, 193, 199, 205, 211, 217, 223, 228, 234, 241, 246, 252, 258, 263, 269, 275, 280, 286, 295, 302, 308}
+	_op_index_1 = [...]uint16{0, 6, 12, 18, 23, 28, 34, 39, 45, 51, 57, 62, 69, 76, 82, 88, 93, 97, 102, 106, 111, 115, 119, 124, 128, 133, 138, 143, 148, 153, 157, 161, 167, 174, 181, 187, 194, 202, 208, 214, 220, 227, 235, 243, 251, 259, 265, 271, 277, 282, 288, 295, 300, 305, 311, 317, 322, 328, 334, 339, 346, 352, 361, 368, 374}
+	_op_index_2 = [...]uint16{0, 7, 13, 18, 24, 29, 35, 41, 47, 53, 58, 63, 69, 72, 77, 83, 87, 91, 96, 100, 105, 110, 114, 120, 126, 131, 137, 144, 149, 153, 158, 162, 166, 171, 177, 182, 188, 195, 201, 206, 212, 217, 223, 229, 233, 238, 243, 249}
+	_op_index_3 = [...]uint16{0, 8, 17, 25, 34, 42, 51, 59, 65, 75, 83, 90, 98, 105, 113, 122, 130, 139, 149, 159, 169, 177, 186, 194, 202, 211, 220, 230, 240, 250, 258, 266, 275, 284, 293, 302, 311, 319, 327, 335, 343, 352, 361, 371, 379, 387, 396, 405, 413, 422, 430, 439, 448, 458}
+	_op_index_4 = [...]uint16{0, 7, 15, 24, 32, 39, 47, 56, 63, 70, 78, 85, 93, 100, 108, 115, 123, 132, 140, 150, 159, 168, 175, 183, 189, 196, 202, 208, 215, 222, 228, 235, 242, 250, 260, 269, 277, 283, 290, 297, 305, 314, 322, 328, 334, 340, 347, 355, 364, 372, 379, 387, 396, 404, 411, 419, 428, 436, 443, 451, 460, 468, 475, 483, 489, 496, 504}
 )
 
 func (i op) String() string {
 	switch {
 	case 0 <= i && i <= 6:
 		return _op_name_0[_op_index_0[i]:_op_index_0[i+1]]
-	case 100 <= i && i <= 153:
+	case 100 <= i && i <= 163:
 		i -= 100
 		return _op_name_1[_op_index_1[i]:_op_index_1[i+1]]
+	case 200 <= i && i <= 246:
+		i -= 200
+		return _op_name_2[_op_index_2[i]:_op_index_2[i+1]]
+	case 300 <= i && i <= 352:
+		i -= 300
+		return _op_name_3[_op_index_3[i]:_op_index_3[i+1]]
+	case 400 <= i && i <= 465:
+		i -= 400
+		return _op_name_4[_op_index_4[i]:_op_index_4[i+1]]
 	default:
 		return fmt.Sprintf("op(%d)", i)
 	}
@@ -0,0 +1,835 @@
+package asm
+
+import (
+	"strings"
+
+	"subc/obj"
+)
+
+const (
+	opRVADD op = iota + 400
+	opRVADDI
+	opRVADDIW
+	opRVADDW
+	opRVAND
+	opRVANDI
+	opRVAUIPC
+	opRVBEQ
+	opRVBGE
+	opRVBGEU
+	opRVBLT
+	opRVBLTU
+	opRVBNE
+	opRVCALL
+	opRVDIV
+	opRVDIVU
+	opRVDIVUW
+	opRVDIVW
+	opRVEBREAK
+	opRVECALL
+	opRVFENCE
+	opRVJAL
+	opRVJALR
+	opRVLB
+	opRVLBU
+	opRVLD
+	opRVLH
+	opRVLHU
+	opRVLUI
+	opRVLW
+	opRVLWU
+	opRVMUL
+	opRVMULH
+	opRVMULHSU
+	opRVMULHU
+	opRVMULW
+	opRVOR
+	opRVORI
+	opRVREM
+	opRVREMU
+	opRVREMUW
+	opRVREMW
+	opRVSB
+	opRVSD
+	opRVSH
+	opRVSLL
+	opRVSLLI
+	opRVSLLIW
+	opRVSLLW
+	opRVSLT
+	opRVSLTI
+	opRVSLTIU
+	opRVSLTU
+	opRVSRA
+	opRVSRAI
+	opRVSRAIW
+	opRVSRAW
+	opRVSRL
+	opRVSRLI
+	opRVSRLIW
+	opRVSRLW
+	opRVSUB
+	opRVSUBW
+	opRVSW
+	opRVXOR
+	opRVXORI
+)
+
+// riscv64 assembles the RV64I instructions and the ones of the M
+// extension, in the syntax of the GNU assembler with the registers
+// named x0-x31 or by the abi. The address of a symbol is made by lui
+// of its %hi and the addi, the load or the store of its %lo, or by call
+// and tail, which are relocated as a pair of auipc and jalr. A jump or
+// a branch to a label of its section is resolved by the assembler, jal
+// goes to the other symbols through the linker.
+type riscv64 struct {
+	*as
+}
+
+// riscvRegs are the registers by their names in the abi.
+var riscvRegs = map[string]byte{
+	"zero": 0, "ra": 1, "sp": 2, "gp": 3, "tp": 4,
+	"t0": 5, "t1": 6, "t2": 7, "s0": 8, "fp": 8, "s1": 9,
+	"a0": 10, "a1": 11, "a2": 12, "a3": 13, "a4": 14, "a5": 15, "a6": 16, "a7": 17,
+	"s2": 18, "s3": 19, "s4": 20, "s5": 21, "s6": 22, "s7": 23,
+	"s8": 24, "s9": 25, "s10": 26, "s11": 27,
+	"t3": 28, "t4": 29, "t5": 30, "t6": 31,
+}
+
+// riscvR are the instructions of the register format, by their funct7,
+// funct3 and opcode, and the instruction that takes an immediate for
+// the second source register.
+var riscvR = map[string]struct {
+	op                     op
+	funct7, funct3, opcode uint32
+	imm                    string
+}{
+	"add":    {opRVADD, 0x00, 0, 0x33, "addi"},
+	"sub":    {opRVSUB, 0x20, 0, 0x33, ""},
+	"sll":    {opRVSLL, 0x00, 1, 0x33, "slli"},
+	"slt":    {opRVSLT, 0x00, 2, 0x33, "slti"},
+	"sltu":   {opRVSLTU, 0x00, 3, 0x33, "sltiu"},
+	"xor":    {opRVXOR, 0x00, 4, 0x33, "xori"},
+	"srl":    {opRVSRL, 0x00, 5, 0x33, "srli"},
+	"sra":    {opRVSRA, 0x20, 5, 0x33, "srai"},
+	"or":     {opRVOR, 0x00, 6, 0x33, "ori"},
+	"and":    {opRVAND, 0x00, 7, 0x33, "andi"},
+	"addw":   {opRVADDW, 0x00, 0, 0x3b, "addiw"},
+	"subw":   {opRVSUBW, 0x20, 0, 0x3b, ""},
+	"sllw":   {opRVSLLW, 0x00, 1, 0x3b, "slliw"},
+	"srlw":   {opRVSRLW, 0x00, 5, 0x3b, "srliw"},
+	"sraw":   {opRVSRAW, 0x20, 5, 0x3b, "sraiw"},
+	"mul":    {opRVMUL, 0x01, 0, 0x33, ""},
+	"mulh":   {opRVMULH, 0x01, 1, 0x33, ""},
+	"mulhsu": {opRVMULHSU, 0x01, 2, 0x33, ""},
+	"mulhu":  {opRVMULHU, 0x01, 3, 0x33, ""},
+	"div":    {opRVDIV, 0x01, 4, 0x33, ""},
+	"divu":   {opRVDIVU, 0x01, 5, 0x33, ""},
+	"rem":    {opRVREM, 0x01, 6, 0x33, ""},
+	"remu":   {opRVREMU, 0x01, 7, 0x33, ""},
+	"mulw":   {opRVMULW, 0x01, 0, 0x3b, ""},
+	"divw":   {opRVDIVW, 0x01, 4, 0x3b, ""},
+	"divuw":  {opRVDIVUW, 0x01, 5, 0x3b, ""},
+	"remw":   {opRVREMW, 0x01, 6, 0x3b, ""},
+	"remuw":  {opRVREMUW, 0x01, 7, 0x3b, ""},
+}
+
+// riscvI are the instructions with a 12-bit signed immediate, by their
+// funct3 and opcode.
+var riscvI = map[string]struct {
+	op             op
+	funct3, opcode uint32
+}{
+	"addi":  {opRVADDI, 0, 0x13},
+	"slti":  {opRVSLTI, 2, 0x13},
+	"sltiu": {opRVSLTIU, 3, 0x13},
+	"xori":  {opRVXORI, 4, 0x13},
+	"ori":   {opRVORI, 6, 0x13},
+	"andi":  {opRVANDI, 7, 0x13},
+	"addiw": {opRVADDIW, 0, 0x1b},
+}
+
+// riscvShifts are the shifts by a constant, by the high bits of their
+// immediate, their funct3 and opcode, and the largest count.
+var riscvShifts = map[string]struct {
+	op                   op
+	high, funct3, opcode uint32
+	max                  int64
+}{
+	"slli":  {opRVSLLI, 0x000, 1, 0x13, 63},
+	"srli":  {opRVSRLI, 0x000, 5, 0x13, 63},
+	"srai":  {opRVSRAI, 0x400, 5, 0x13, 63},
+	"slliw": {opRVSLLIW, 0x000, 1, 0x1b, 31},
+	"srliw": {opRVSRLIW, 0x000, 5, 0x1b, 31},
+	"sraiw": {opRVSRAIW, 0x400, 5, 0x1b, 31},
+}
+
+// riscvLoads are the loads by their funct3.
+var riscvLoads = map[string]struct {
+	op     op
+	funct3 uint32
+}{
+	"lb":  {opRVLB, 0},
+	"lh":  {opRVLH, 1},
+	"lw":  {opRVLW, 2},
+	"ld":  {opRVLD, 3},
+	"lbu": {opRVLBU, 4},
+	"lhu": {opRVLHU, 5},
+	"lwu": {opRVLWU, 6},
+}
+
+// riscvStores are the stores by their funct3.
+var riscvStores = map[string]struct {
+	op     op
+	funct3 uint32
+}{
+	"sb": {opRVSB, 0},
+	"sh": {opRVSH, 1},
+	"sw": {opRVSW, 2},
+	"sd": {opRVSD, 3},
+}
+
+// riscvBranches are the branches by their funct3, and the branches
+// that compare the registers the other way around or with zero.
+var riscvBranches = map[string]struct {
+	op     op
+	funct3 uint32
+}{
+	"beq":  {opRVBEQ, 0},
+	"bne":  {opRVBNE, 1},
+	"blt":  {opRVBLT, 4},
+	"bge":  {opRVBGE, 5},
+	"bltu": {opRVBLTU, 6},
+	"bgeu": {opRVBGEU, 7},
+}
+
+// riscvSwapped are the branches that are taken by another with the
+// registers swapped.
+var riscvSwapped = map[string]string{"bgt": "blt", "ble": "bge", "bgtu": "bltu", "bleu": "bgeu"}
+
+// riscvZero are the branches that compare a register with zero, by the
+// branch they are and whether zero is its first register.
+var riscvZero = map[string]struct {
+	branch string
+	first  bool
+}{
+	"beqz": {"beq", false},
+	"bnez": {"bne", false},
+	"bltz": {"blt", false},
+	"bgez": {"bge", false},
+	"blez": {"bge", true},
+	"bgtz": {"blt", true},
+}
+
+// args parses the comma separated operands of an instruction.
+func (as *riscv64) args(line string) [4]addr {
+	var addr [4]addr
+	args := splitArgs(strings.TrimSpace(line))
+	if len(args) > len(addr) {
+		as.errorf("junk at end")
+	}
+	for i, arg := range args {
+		addr[i] = as.arg(strings.TrimSpace(arg))
+	}
+	return addr
+}
+
+// arg decodes an argument: a register, an integer, a symbol or a symbol
+// plus a constant, the %hi or the %lo of them, or a memory operand
+// off(base) where off is an integer or a %lo.
+func (as *riscv64) arg(s string) (a addr) {
+	if a, ok := as.literal(s); ok {
+		return a
+	}
+	if r, ok := as.reg(s); ok {
+		a.typ = aREG
+		a.reg = r
+		a.sval = s
+		return a
+	}
+	if sym, n, ok := symPlus(s); ok {
+		a.typ = aPTR
+		a.sval = sym
+		a.ival = n
+		return a
+	}
+
+	switch {
+	case isIdent(s):
+		a.typ = aPTR
+		a.sval = s
+	case strings.HasPrefix(s, "%hi(") && strings.HasSuffix(s, ")"):
+		a = as.sym(s[len("%hi(") : len(s)-1])
+		a.part = obj.RelocHi
+	case strings.HasPrefix(s, "%lo(") && strings.Count(s, "(") == 1 && strings.HasSuffix(s, ")"):
+		a = as.sym(s[len("%lo(") : len(s)-1])
+		a.part = obj.RelocLo
+	case strings.HasSuffix(s, ")"):
+		i := strings.LastIndex(s, "(")
+		if i < 0 {
+			as.errorf("invalid memory operand %q", s)
+		}
+		r, ok := as.reg(strings.TrimSpace(s[i+1 : len(s)-1]))
+		if !ok {
+			as.errorf("invalid memory operand %q", s)
+		}
+		off := strings.TrimSpace(s[:i])
+		switch {
+		case off == "":
+		case strings.HasPrefix(off, "%lo(") && strings.HasSuffix(off, ")"):
+			a = as.sym(off[len("%lo(") : len(off)-1])
+			a.iname, a.sval = a.sval, ""
+			a.part = obj.RelocLo
+		default:
+			a.ival = as.number(off)
+		}
+		a.typ = aMEM
+		a.reg = r
+	case isNumber(s):
+		a.typ = aINT
+		a.ival = as.number(s)
+	default:
+		as.errorf("invalid argument %q", s)
+	}
+	return
+}
+
+// reg returns the number of the register xn or of its name in the abi.
+func (as *riscv64) reg(s string) (byte, bool) {
+	s = strings.ToLower(s)
+	if r, ok := riscvRegs[s]; ok {
+		return r, true
+	}
+	if len(s) > 1 && s[0] == 'x' && isNumber(s[1:]) && s[1] != '+' && s[1] != '-' {
+		if n := as.number(s[1:]); n < 32 {
+			return byte(n), true
+		}
+	}
+	return 0, false
+}
+
+// sym returns the symbol of a %hi or a %lo, it can have an offset.
+func (as *riscv64) sym(s string) addr {
+	s = strings.TrimSpace(s)
+	if sym, n, ok := symPlus(s); ok {
+		return addr{typ: aPTR, sval: sym, ival: n}
+	}
+	if !isIdent(s) {
+		as.errorf("%q is not a symbol", s)
+	}
+	return addr{typ: aPTR, sval: s}
+}
+
+// inst assembles an instruction or a directive.
+func (as *riscv64) inst(op_ string, addr [4]addr) bool {
+	unk := func() {
+		as.errorf("unknown argument")
+	}
+	n := 0
+	for n < len(addr) && addr[n].typ != aNONE {
+		n++
+	}
+	nargs := func(want ...int) {
+		for _, w := range want {
+			if n == w {
+				return
+			}
+		}
+		as.errorf("%s takes %d operand(s), got %d", op_, want[0], n)
+	}
+
+	x, y, z := addr[0], addr[1], addr[2]
+	zero := riscvReg(0)
+	lop := strings.ToLower(op_)
+	if as.directive(lop, addr) {
+		return true
+	}
+
+	if d, ok := riscvR[lop]; ok {
+		nargs(3)
+		if z.typ != aREG && d.imm != "" {
+			lop = d.imm
+		} else {
+			as.regs(x, y, z)
+			as.emit(d.op, addr, as.rtype(d.funct7, z.reg, y.reg, d.funct3, x.reg, d.opcode))
+			as.sect.pc++
+			return true
+		}
+	}
+	if d, ok := riscvI[lop]; ok {
+		nargs(3)
+		as.regs(x, y)
+		switch {
+		case z.typ == aPTR && z.part == obj.RelocLo:
+			as.reloc(d.op, addr, obj.RelocLo, z.sval, z.ival, as.itype(0, y.reg, d.funct3, x.reg, d.opcode))
+		case z.typ == aINT:
+			as.emit(d.op, addr, as.itype(as.imm12(lop, z.ival), y.reg, d.funct3, x.reg, d.opcode))
+		default:
+			unk()
+		}
+		as.sect.pc++
+		return true
+	}
+	if d, ok := riscvShifts[lop]; ok {
+		nargs(3)
+		as.regs(x, y)
+		if z.typ != aINT {
+			unk()
+		}
+		if z.ival < 0 || z.ival > d.max {
+			as.errorf("%s: shift count %d out of range [0, %d]", lop, z.ival, d.max)
+		}
+		as.emit(d.op, addr, as.itype(d.high|uint32(z.ival), y.reg, d.funct3, x.reg, d.opcode))
+		as.sect.pc++
+		return true
+	}
+	if d, ok := riscvLoads[lop]; ok {
+		nargs(2)
+		as.regs(x)
+		as.mem(d.op, addr, y, obj.RelocLo, as.itype(0, y.reg, d.funct3, x.reg, 0x03))
+		as.sect.pc++
+		return true
+	}
+	if d, ok := riscvStores[lop]; ok {
+		nargs(2)
+		as.regs(x)
+		as.mem(d.op, addr, y, obj.RelocLoStore, as.stype(0, x.reg, y.reg, d.funct3, 0x23))
+		as.sect.pc++
+		return true
+	}
+	if b, ok := riscvSwapped[lop]; ok {
+		nargs(3)
+		lop, x, y = b, y, x
+	}
+	if b, ok := riscvZero[lop]; ok {
+		nargs(2)
+		lop, z = b.branch, y
+		if b.first {
+			x, y = zero, x
+		} else {
+			y = zero
+		}
+		n = 3
+	}
+	if d, ok := riscvBranches[lop]; ok {
+		nargs(3)
+		as.regs(x, y)
+		as.branch(d.op, addr, z, as.btype(0, y.reg, x.reg, d.funct3))
+		as.sect.pc++
+		return true
+	}
+
+	switch lop {
+	case ".abort":
+		return false
+	case ".extern":
+	case ".dword", ".quad":
+		as.words(opQUAD, addr, 8)
+	case ".word", ".long":
+		as.ranges(lop, addr[:], 4)
+		as.words(opLONG, addr, 4)
+	case ".half", ".short":
+		as.ranges(lop, addr[:], 2)
+		as.words(opSHORT, addr, 2)
+	case ".byte":
+		as.ranges(lop, addr[:], 1)
+		as.words(opBYTE, addr, 1)
+	case ".align", ".p2align":
+		if x.ival < 0 || 1<<uint(x.ival) > maxAlign {
+			as.errorf("%s: alignment 2**%d out of range", lop, x.ival)
+		}
+		as.ranges(lop, addr[1:2], 1)
+		as.alignpc(1<<uint(x.ival), as.fill(y))
+	case ".balign":
+		if x.ival <= 0 || x.ival > maxAlign || x.ival&(x.ival-1) != 0 {
+			as.errorf("%s: alignment %d is not a power of 2 up to %d", lop, x.ival, maxAlign)
+		}
+		as.ranges(lop, addr[1:2], 1)
+		as.alignpc(int(x.ival), as.fill(y))
+	case "nop":
+		nargs(0)
+		as.emit(opNOP, addr, uint32(0x13))
+	case "ecall":
+		nargs(0)
+		as.emit(opRVECALL, addr, uint32(0x73))
+	case "ebreak":
+		nargs(0)
+		as.emit(opRVEBREAK, addr, uint32(0x100073))
+	case "fence":
+		nargs(0)
+		as.emit(opRVFENCE, addr, uint32(0x0ff0000f))
+	case "mv":
+		nargs(2)
+		as.regs(x, y)
+		as.emit(opRVADDI, addr, as.itype(0, y.reg, 0, x.reg, 0x13))
+	case "not":
+		nargs(2)
+		as.regs(x, y)
+		as.emit(opRVXORI, addr, as.itype(0xfff, y.reg, 4, x.reg, 0x13))
+	case "neg", "negw":
+		nargs(2)
+		as.regs(x, y)
+		o, opcode := opRVSUB, uint32(0x33)
+		if lop == "negw" {
+			o, opcode = opRVSUBW, 0x3b
+		}
+		as.emit(o, addr, as.rtype(0x20, y.reg, 0, 0, x.reg, opcode))
+	case "sext.w":
+		nargs(2)
+		as.regs(x, y)
+		as.emit(opRVADDIW, addr, as.itype(0, y.reg, 0, x.reg, 0x1b))
+	case "seqz":
+		nargs(2)
+		as.regs(x, y)
+		as.emit(opRVSLTIU, addr, as.itype(1, y.reg, 3, x.reg, 0x13))
+	case "snez":
+		nargs(2)
+		as.regs(x, y)
+		as.emit(opRVSLTU, addr, as.rtype(0, y.reg, 0, 3, x.reg, 0x33))
+	case "sltz":
+		nargs(2)
+		as.regs(x, y)
+		as.emit(opRVSLT, addr, as.rtype(0, 0, y.reg, 2, x.reg, 0x33))
+	case "sgtz":
+		nargs(2)
+		as.regs(x, y)
+		as.emit(opRVSLT, addr, as.rtype(0, y.reg, 0, 2, x.reg, 0x33))
+	case "li":
+		nargs(2)
+		as.regs(x)
+		if y.typ != aINT {
+			unk()
+		}
+		as.li(addr, x.reg, y.ival)
+	case "lui", "auipc":
+		nargs(2)
+		as.regs(x)
+		o, opcode := opRVLUI, uint32(0x37)
+		if lop == "auipc" {
+			o, opcode = opRVAUIPC, 0x17
+		}
+		switch {
+		case y.typ == aPTR && y.part == obj.RelocHi && lop == "lui":
+			as.reloc(o, addr, obj.RelocHi, y.sval, y.ival, opcode|uint32(x.reg)<<7)
+		case y.typ == aINT:
+			if y.ival < 0 || y.ival > 0xfffff {
+				as.errorf("%s: immediate %d out of range [0, 1048575]", lop, y.ival)
+			}
+			as.emit(o, addr, uint32(y.ival)<<12|uint32(x.reg)<<7|opcode)
+		default:
+			unk()
+		}
+	case "j", "jal":
+		nargs(1, 2)
+		rd := byte(1)
+		if lop == "j" {
+			rd = 0
+		}
+		if n == 2 {
+			if lop == "j" {
+				as.errorf("j takes 1 operand(s), got 2")
+			}
+			as.regs(x)
+			rd, x = x.reg, y
+		}
+		if x.typ != aPTR || x.part != obj.RelocNone {
+			unk()
+		}
+		as.reloc(opRVJAL, addr, obj.RelocBranch, x.sval, x.ival, uint32(rd)<<7|0x6f)
+	case "jr", "jalr":
+		nargs(1, 2, 3)
+		rd := byte(1)
+		if lop == "jr" {
+			rd = 0
+		}
+		var off int64
+		switch {
+		case n == 3 && lop == "jalr":
+			// jalr rd, rs, off
+			as.regs(x, y)
+			if z.typ != aINT {
+				unk()
+			}
+			rd, x, off = x.reg, y, z.ival
+		case n == 2 && lop == "jalr":
+			as.regs(x)
+			rd, x = x.reg, y
+		case n != 1:
+			as.errorf("%s takes 1 operand(s), got %d", lop, n)
+		}
+		rs := x.reg
+		switch x.typ {
+		case aREG:
+		case aMEM:
+			if x.part != obj.RelocNone {
+				unk()
+			}
+			off = x.ival
+		default:
+			unk()
+		}
+		as.emit(opRVJALR, addr, as.itype(as.imm12(lop, off), rs, 0, rd, 0x67))
+	case "ret":
+		nargs(0)
+		as.emit(opRVJALR, addr, as.itype(0, 1, 0, 0, 0x67))
+	case "call", "tail":
+		nargs(1)
+		if x.typ != aPTR || x.part != obj.RelocNone {
+			unk()
+		}
+		// auipc ra, 0 and jalr ra, 0(ra), or t1 and x0 for tail
+		rd, link := uint32(1), uint32(1)
+		if lop == "tail" {
+			rd, link = 6, 0
+		}
+		as.addrel(opRVCALL, addr)
+		p := as.relocs[len(as.relocs)-1]
+		p.code = as.code(rd<<7|0x17, as.itype(0, byte(rd), 0, byte(link), 0x67))
+		p.isize = len(p.code)
+		p.reltyp = obj.RelocCall
+		p.relname = x.sval
+		p.addend = x.ival
+		p.rel = p.off
+		as.sect.size += int64(p.isize)
+	default:
+		as.errorf("unknown instruction %s", lop)
+	}
+
+	as.sect.pc++
+	return true
+}
+
+// riscvReg returns the operand of the register xn.
+func riscvReg(n byte) addr {
+	return addr{typ: aREG, reg: n}
+}
+
+// regs checks that the operands are registers.
+func (as *riscv64) regs(args ...addr) {
+	for _, a := range args {
+		if a.typ != aREG {
+			as.errorf("unknown argument")
+		}
+	}
+}
+
+// rtype encodes an instruction of the register format.
+func (as *riscv64) rtype(funct7 uint32, rs2, rs1 byte, funct3 uint32, rd byte, opcode uint32) uint32 {
+	return funct7<<25 | uint32(rs2)<<20 | uint32(rs1)<<15 | funct3<<12 | uint32(rd)<<7 | opcode
+}
+
+// itype encodes an instruction of the immediate format, imm is the low
+// 12 bits.
+func (as *riscv64) itype(imm uint32, rs1 byte, funct3 uint32, rd byte, opcode uint32) uint32 {
+	return imm&0xfff<<20 | uint32(rs1)<<15 | funct3<<12 | uint32(rd)<<7 | opcode
+}
+
+// stype encodes an instruction of the store format, whose immediate is
+// split around the source register.
+func (as *riscv64) stype(imm uint32, rs2, rs1 byte, funct3, opcode uint32) uint32 {
+	return imm>>5&0x7f<<25 | uint32(rs2)<<20 | uint32(rs1)<<15 | funct3<<12 | imm&0x1f<<7 | opcode
+}
+
+// btype encodes a branch with the offset off in bytes.
+func (as *riscv64) btype(off uint32, rs2, rs1 byte, funct3 uint32) uint32 {
+	return off>>12&1<<31 | off>>5&0x3f<<25 | uint32(rs2)<<20 | uint32(rs1)<<15 | funct3<<12 | off>>1&0xf<<8 | off>>11&1<<7 | 0x63
+}
+
+// jtype returns the offset off in bytes of jal in its place.
+func (as *riscv64) jtype(off uint32) uint32 {
+	return off>>20&1<<31 | off>>1&0x3ff<<21 | off>>11&1<<20 | off>>12&0xff<<12
+}
+
+// imm12 checks that v fits in the 12-bit signed immediate of the
+// instruction and returns it.
+func (as *riscv64) imm12(lop string, v int64) uint32 {
+	if v < -0x800 || v > 0x7ff {
+		as.errorf("%s: immediate %d out of range [-2048, 2047]", lop, v)
+	}
+	return uint32(v) & 0xfff
+}
+
+// li loads the constant v in the register rd by lui and addiw when it
+// fits in 32 bits, and by loading its high bits and shifting them left
+// and adding the low 12 bits to them otherwise.
+func (as *riscv64) li(addr [4]addr, rd byte, v int64) {
+	if v == int64(int32(v)) {
+		lo := v << 52 >> 52
+		hi := uint32(v-lo) >> 12 & 0xfffff
+		if hi == 0 {
+			as.emit(opRVADDI, addr, as.itype(uint32(lo), 0, 0, rd, 0x13))
+			return
+		}
+		as.emit(opRVLUI, addr, hi<<12|uint32(rd)<<7|0x37)
+		if lo != 0 {
+			as.emit(opRVADDIW, addr, as.itype(uint32(lo), rd, 0, rd, 0x1b))
+		}
+		return
+	}
+	lo := v << 52 >> 52
+	hi := (v - lo) >> 12
+	shift := uint32(12)
+	for hi&1 == 0 {
+		hi >>= 1
+		shift++
+	}
+	as.li(addr, rd, hi)
+	as.emit(opRVSLLI, addr, as.itype(shift, rd, 1, rd, 0x13))
+	if lo != 0 {
+		as.emit(opRVADDI, addr, as.itype(uint32(lo), rd, 0, rd, 0x13))
+	}
+}
+
+// mem emits the load or the store code at the memory operand m, whose
+// offset is an immediate or the %lo of a symbol relocated by reltyp.
+func (as *riscv64) mem(op op, addr [4]addr, m addr, reltyp obj.RelocKind, code uint32) {
+	if m.typ != aMEM {
+		as.errorf("unknown argument")
+	}
+	if m.part == obj.RelocLo {
+		as.reloc(op, addr, reltyp, m.iname, m.ival, code)
+		return
+	}
+	off := as.imm12("the offset", m.ival)
+	if reltyp == obj.RelocLoStore {
+		code |= as.stype(off, 0, 0, 0, 0)
+	} else {
+		code |= as.itype(off, 0, 0, 0, 0)
+	}
+	as.emit(op, addr, code)
+}
+
+// fill returns the fill of the padding of an align directive with the
+// fill value y. Without a fill value the padding of the code is made of
+// nops, after the zeros that align it to an instruction.
+func (as *riscv64) fill(y addr) func(n int) []byte {
+	if y.typ != aNONE || as.sect.flags&sfExec == 0 {
+		return fillValue(uint8(y.ival))
+	}
+	return func(n int) []byte {
+		buf := make([]byte, n%4, n)
+		for len(buf) < n {
+			buf = append(buf, as.code(uint32(0x13))...)
+		}
+		return buf
+	}
+}
+
+// reloc emits the instruction code whose field is relocated by reltyp
+// with the address of the symbol name plus addend. The field is left
+// at zero, the relocation has the addend.
+func (as *riscv64) reloc(op op, addr [4]addr, reltyp obj.RelocKind, name string, addend int64, code uint32) {
+	as.addrel(op, addr)
+	p := as.relocs[len(as.relocs)-1]
+	p.code = as.code(code)
+	p.isize = len(p.code)
+	p.reltyp = reltyp
+	p.relname = name
+	p.addend = addend
+	p.rel = p.off
+	as.sect.size += int64(p.isize)
+}
+
+// branch emits a branch to the label l, the offset is filled in when the
+// label is known by finish.
+func (as *riscv64) branch(op op, addr [4]addr, l addr, code uint32) {
+	if l.typ != aPTR || l.part != obj.RelocNone {
+		as.errorf("a branch takes a label")
+	}
+	as.reloc(op, addr, obj.RelocNone, l.sval, l.ival, code)
+}
+
+// words emits the integers and the addresses of symbols of a directive
+// in size bytes each, the addresses are relocated.
+func (as *riscv64) words(op op, addr [4]addr, size int) {
+	for _, a := range addr {
+		switch a.typ {
+		case aNONE:
+			return
+		case aINT:
+			as.emit(op, addr, obj.Value(as.endian, size, a.ival))
+		case aPTR:
+			if size != 8 || a.part != obj.RelocNone {
+				as.errorf("an address takes 8 bytes")
+			}
+			as.addrel(op, addr)
+			p := as.relocs[len(as.relocs)-1]
+			p.code = make([]byte, 8)
+			p.isize = 8
+			p.reltyp = obj.RelocData
+			p.relname = a.sval
+			p.addend = a.ival
+			p.rel = p.off
+			as.sect.size += 8
+		default:
+			as.errorf("unknown argument")
+		}
+	}
+}
+
+// finish resolves the branches to the labels of their sections and
+// the symbols of the relocations left for the linker.
+func (as *riscv64) finish() {
+	as.fixupRelocs(as.text)
+	as.fixupRelocs(as.data)
+	for _, s := range as.sects {
+		as.fixupRelocs(s)
+	}
+	as.fixupBSS()
+	for _, s := range as.sections() {
+		if s.size > as.max {
+			as.errorf("section %s of %d bytes is larger than the maximum of %d bytes", s.name, s.size, as.max)
+		}
+	}
+}
+
+// fixupRelocs puts the offsets of the branches, the jumps and the calls
+// to the labels of s that aren't exported in their code, relative to
+// the instruction in bytes. The branches have to go to a label of s, the
+// jumps and the calls go to the other symbols through the linker.
+func (as *riscv64) fixupRelocs(s *section) {
+	for i := 0; i < len(s.relocs); {
+		p := s.relocs[i]
+		l := as.fsym(aPTR, p.relname)
+		switch p.reltyp {
+		case obj.RelocNone:
+		case obj.RelocBranch, obj.RelocCall:
+			if l.typ != obj.SymLabel || l.sect != s || l.exported {
+				i++
+				continue
+			}
+		default:
+			i++
+			continue
+		}
+		if l.typ != obj.SymLabel || l.sect != s {
+			as.errorf("branch to %q, which is not a label of %s", p.relname, s.name)
+		}
+
+		off := l.off + p.addend - p.off
+		v := as.endian.Uint32(p.code)
+		switch p.op {
+		case opRVJAL:
+			as.inRange(p.relname, off, 1<<20)
+			v |= as.jtype(uint32(off))
+		case opRVCALL:
+			as.inRange(p.relname, off, 1<<31)
+			lo := off << 52 >> 52
+			v |= uint32(off-lo) & 0xfffff000
+			as.endian.PutUint32(p.code[4:], as.endian.Uint32(p.code[4:])|uint32(lo)<<20)
+		default:
+			as.inRange(p.relname, off, 1<<12)
+			v |= as.btype(uint32(off), 0, 0, 0) &^ 0x63
+		}
+		as.endian.PutUint32(p.code, v)
+		s.relocs = append(s.relocs[:i], s.relocs[i+1:]...)
+	}
+}
+
+// inRange checks that the offset off to the label name is in the range
+// [-max, max) of the branch.
+func (as *riscv64) inRange(name string, off, max int64) {
+	if off < -max || off >= max {
+		as.errorf("branch to %q out of range", name)
+	}
+}
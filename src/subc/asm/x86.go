@@ -1,13 +1,10 @@
 package asm
 
 import (
-	"bufio"
-	"bytes"
-	"fmt"
 	"math"
-	"strconv"
 	"strings"
-	"unicode/utf8"
+
+	"subc/obj"
 )
 
 const (
@@ -38,7 +35,9 @@ const (
 	opJLE
 	opJMP
 	opJNE
+	opJNO
 	opJNZ
+	opJO
 	opJZ
 	opLEAQ
 	opLODSL
@@ -49,11 +48,18 @@ const (
 	opMOVB
 	opMOVL
 	opMOVQ
+	opMOVSB
+	opMOVSBQ
+	opMOVSWQ
+	opMOVZBQ
+	opMOVZWQ
+	opMULQ
 	opNEGQ
 	opNOTQ
 	opORQ
 	opPOPQ
 	opPUSHQ
+	opREP
 	opRET
 	opSARQ
 	opSBBQ
@@ -61,6 +67,7 @@ const (
 	opSHLQ
 	opSHRQ
 	opSTI
+	opSTOSB
 	opSUBQ
 	opSYSCALL
 	opXCHGQ
@@ -113,96 +120,232 @@ const (
 	rBH = 7
 )
 
+// x86regs maps the register names to their
+// encoding and size in bytes.
 var x86regs = map[string]struct {
-	reg byte
+	reg  byte
+	size int
 }{
-	"rax": {rRAX},
-	"rcx": {rRCX},
-	"rdx": {rRDX},
-	"rbx": {rRBX},
-	"rsp": {rRSP},
-	"rbp": {rRBP},
-	"rsi": {rRSI},
-	"rdi": {rRDI},
-	"r8":  {rR8},
-	"r9":  {rR9},
-	"r10": {rR10},
-	"r11": {rR11},
-	"r12": {rR12},
-	"r13": {rR13},
-	"r14": {rR14},
-	"r15": {rR15},
-	"eax": {rEAX},
-	"ecx": {rECX},
-	"edx": {rEDX},
-	"esp": {rESP},
-	"ebp": {rEBP},
-	"esi": {rESI},
-	"edi": {rEDI},
-	"ax":  {rAX},
-	"cx":  {rCX},
-	"dx":  {rDX},
-	"sp":  {rSP},
-	"bp":  {rBP},
-	"si":  {rSI},
-	"di":  {rDI},
-	"al":  {rAL},
-	"cl":  {rCL},
-	"dl":  {rBL},
-	"bl":  {rAH},
-	"ah":  {rAH},
-	"ch":  {rCH},
-	"dh":  {rDH},
-	"bh":  {rBH},
+	"rax": {rRAX, 8},
+	"rcx": {rRCX, 8},
+	"rdx": {rRDX, 8},
+	"rbx": {rRBX, 8},
+	"rsp": {rRSP, 8},
+	"rbp": {rRBP, 8},
+	"rsi": {rRSI, 8},
+	"rdi": {rRDI, 8},
+	"r8":  {rR8, 8},
+	"r9":  {rR9, 8},
+	"r10": {rR10, 8},
+	"r11": {rR11, 8},
+	"r12": {rR12, 8},
+	"r13": {rR13, 8},
+	"r14": {rR14, 8},
+	"r15": {rR15, 8},
+	"eax": {rEAX, 4},
+	"ecx": {rECX, 4},
+	"edx": {rEDX, 4},
+	"ebx": {rEBX, 4},
+	"esp": {rESP, 4},
+	"ebp": {rEBP, 4},
+	"esi": {rESI, 4},
+	"edi": {rEDI, 4},
+	"ax":  {rAX, 2},
+	"cx":  {rCX, 2},
+	"dx":  {rDX, 2},
+	"bx":  {rBX, 2},
+	"sp":  {rSP, 2},
+	"bp":  {rBP, 2},
+	"si":  {rSI, 2},
+	"di":  {rDI, 2},
+	"al":  {rAL, 1},
+	"cl":  {rCL, 1},
+	"dl":  {rDL, 1},
+	"bl":  {rBL, 1},
+	"ah":  {rAH, 1},
+	"ch":  {rCH, 1},
+	"dh":  {rDH, 1},
+	"bh":  {rBH, 1},
 }
 
-type x86 struct {
-	as
+// x86ops describes the operands taken by each instruction, it is
+// used to validate the operands before they get encoded.
+var x86ops = map[string]struct {
+	size  int  // size of the register operands in bytes, 0 if none are taken
+	nargs int  // number of operands
+	count bool // the first operand is a shift count
+	from  int  // size of the first register operand when it differs from size
+}{
+	"addq":    {8, 2, false, 0},
+	"andq":    {8, 2, false, 0},
+	"call":    {8, 1, false, 0},
+	"cld":     {0, 0, false, 0},
+	"cli":     {0, 0, false, 0},
+	"cmpq":    {8, 2, false, 0},
+	"cqo":     {0, 0, false, 0},
+	"decq":    {8, 1, false, 0},
+	"divq":    {8, 1, false, 0},
+	"hlt":     {0, 0, false, 0},
+	"idivq":   {8, 1, false, 0},
+	"imulq":   {8, 2, false, 0},
+	"incq":    {8, 1, false, 0},
+	"int":     {0, 1, false, 0},
+	"ja":      {0, 1, false, 0},
+	"jae":     {0, 1, false, 0},
+	"jb":      {0, 1, false, 0},
+	"jbe":     {0, 1, false, 0},
+	"jc":      {0, 1, false, 0},
+	"je":      {0, 1, false, 0},
+	"jg":      {0, 1, false, 0},
+	"jge":     {0, 1, false, 0},
+	"jl":      {0, 1, false, 0},
+	"jle":     {0, 1, false, 0},
+	"jmp":     {8, 1, false, 0},
+	"jnc":     {0, 1, false, 0},
+	"jne":     {0, 1, false, 0},
+	"jno":     {0, 1, false, 0},
+	"jnz":     {0, 1, false, 0},
+	"jo":      {0, 1, false, 0},
+	"jz":      {0, 1, false, 0},
+	"leaq":    {8, 2, false, 0},
+	"lodsl":   {0, 0, false, 0},
+	"lodsq":   {0, 0, false, 0},
+	"loop":    {0, 1, false, 0},
+	"loope":   {0, 1, false, 0},
+	"loopne":  {0, 1, false, 0},
+	"loopnz":  {0, 1, false, 0},
+	"loopz":   {0, 1, false, 0},
+	"movb":    {1, 2, false, 0},
+	"movl":    {4, 2, false, 0},
+	"movq":    {8, 2, false, 0},
+	"movsb":   {0, 0, false, 0},
+	"movsbq":  {8, 2, false, 1},
+	"movswq":  {8, 2, false, 2},
+	"movzbq":  {8, 2, false, 1},
+	"movzwq":  {8, 2, false, 2},
+	"mulq":    {8, 1, false, 0},
+	"negq":    {8, 1, false, 0},
+	"nop":     {0, 0, false, 0},
+	"notq":    {8, 1, false, 0},
+	"orq":     {8, 2, false, 0},
+	"popq":    {8, 1, false, 0},
+	"pushq":   {8, 1, false, 0},
+	"rep":     {0, 0, false, 0},
+	"ret":     {0, 0, false, 0},
+	"sarq":    {8, 2, true, 0},
+	"sbbq":    {8, 2, false, 0},
+	"shlq":    {8, 2, true, 0},
+	"shrq":    {8, 2, true, 0},
+	"sti":     {0, 0, false, 0},
+	"stosb":   {0, 0, false, 0},
+	"subq":    {8, 2, false, 0},
+	"syscall": {0, 0, false, 0},
+	"xchgq":   {8, 2, false, 0},
+	"xorq":    {8, 2, false, 0},
 }
 
-func x86as(prog *prog, name string, src []byte) {
-	as := x86{
-		as: as{
-			prog: prog,
-			file: name,
-		},
-	}
-	as.assemble(src)
+// x86ext are the instructions that take the registers %r8 to %r15 as
+// their register operands, the ones that the backends keep the register
+// variables in and the startup code uses. Their encodings put the fourth
+// bit of the registers in the REX prefix, the other instructions are
+// only encoded for the first eight registers.
+var x86ext = map[string]bool{
+	"addq": true,
+	"andq": true,
+	"cmpq": true,
+	"decq": true,
+	"incq": true,
+	"leaq": true,
+	"movq": true,
+	"orq":  true,
+	"subq": true,
+	"xorq": true,
 }
 
-func (as *x86) bytes(op op, addr [4]addr, size int) {
-	var v [4]interface{}
-	for i, a := range addr {
-		switch size {
-		case 1:
-			v[i] = uint8(a.ival)
-		case 2:
-			v[i] = uint16(a.ival)
-		case 4:
-			v[i] = uint32(a.ival)
-		case 8:
-			v[i] = uint64(a.ival)
-		default:
-			panic("unreachable")
+// group1 are the /digits of the arithmetic instructions with an
+// immediate, 0x83 and 0x81, which the reg field of their ModRM byte
+// takes in place of a register.
+var group1 = map[op]byte{
+	opADDQ: 0,
+	opORQ:  1,
+	opANDQ: 4,
+	opSUBQ: 5,
+	opXORQ: 6,
+	opCMPQ: 7,
+}
+
+// i386ops are the instructions on words of i386 and the instructions
+// of amd64 they are encoded as, the same ones without the REX.W prefix.
+var i386ops = map[string]string{
+	"addl":   "addq",
+	"andl":   "andq",
+	"cdq":    "cqo",
+	"cltd":   "cqo",
+	"cmpl":   "cmpq",
+	"decl":   "decq",
+	"divl":   "divq",
+	"idivl":  "idivq",
+	"imull":  "imulq",
+	"incl":   "incq",
+	"leal":   "leaq",
+	"movl":   "movq",
+	"movsbl": "movsbq",
+	"movswl": "movswq",
+	"movzbl": "movzbq",
+	"movzwl": "movzwq",
+	"mull":   "mulq",
+	"negl":   "negq",
+	"notl":   "notq",
+	"orl":    "orq",
+	"popl":   "popq",
+	"pushl":  "pushq",
+	"sarl":   "sarq",
+	"sbbl":   "sbbq",
+	"shll":   "shlq",
+	"shrl":   "shrq",
+	"subl":   "subq",
+	"xchgl":  "xchgq",
+	"xorl":   "xorq",
+}
+
+// x86 assembles the instructions of amd64, and the ones of i386 which
+// are mostly the same without the REX.W prefix on words.
+type x86 struct {
+	*as
+	word int         // the size of a word in bytes
+	rexw interface{} // the REX.W prefix of the instructions on words, nil on i386
+}
+
+// amd64op returns the instruction of amd64 that lop is encoded as,
+// lop itself on amd64. It fails for the instructions on quads, which
+// i386 doesn't have.
+func (as *x86) amd64op(lop string) string {
+	if as.word == 8 {
+		return lop
+	}
+	if q, ok := i386ops[lop]; ok {
+		return q
+	}
+	for _, q := range i386ops {
+		if lop == q {
+			as.errorf("unknown instruction %s", lop)
 		}
 	}
+	switch lop {
+	case "lodsq", "syscall":
+		as.errorf("unknown instruction %s", lop)
+	}
+	return lop
+}
 
+func (as *x86) bytes(op op, addr [4]addr, size int) {
 	switch addr[0].typ | addr[1].typ<<8 | addr[2].typ<<16 | addr[3].typ<<24 {
-	case aINT | aINT<<8 | aINT<<16 | aINT<<24:
-		as.emit(op, addr, v[3])
-		fallthrough
-	case aINT | aINT<<8 | aINT<<16:
-		as.emit(op, addr, v[2])
-		fallthrough
-	case aINT | aINT<<8:
-		as.emit(op, addr, v[1])
-		fallthrough
-	case aINT:
-		as.emit(op, addr, v[0])
+	case aINT, aINT | aINT<<8, aINT | aINT<<8 | aINT<<16, aINT | aINT<<8 | aINT<<16 | aINT<<24:
+		as.values(op, addr, size)
 	case aPTR:
 		as.addrel(op, addr)
 	case aINT | aPTR<<8:
-		as.emit(op, addr, v[0])
+		as.values(op, addr, size)
 		as.addrel(op, addr)
 	default:
 		as.errorf("unknown argument")
@@ -224,530 +367,757 @@ func (as *x86) alias(op string, x, y addr) string {
 	}
 }
 
-func (as *x86) assemble(src []byte) {
-	unk := func() {
-		as.errorf("unknown argument")
+// args parses the comma separated arguments of an instruction.
+func (as *x86) args(line string) [4]addr {
+	var addr [4]addr
+	args := splitArgs(strings.TrimSpace(line))
+	if len(args) > len(addr) {
+		as.errorf("junk at end")
+	}
+	for i, arg := range args {
+		arg = strings.TrimSpace(arg)
+		addr[i] = as.arg(arg)
 	}
+	return addr
+}
 
-	b := bytes.NewReader(src)
-	s := bufio.NewScanner(b)
+// splitArgs splits the arguments of an instruction at the commas
+// that are not inside the parentheses of a memory operand or quoted.
+func splitArgs(line string) []string {
+	var (
+		args  []string
+		depth int
+		quote byte
+	)
+	start := 0
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(' || c == '[':
+			depth++
+		case c == ')' || c == ']':
+			depth--
+		case c == ',' && depth == 0:
+			args = append(args, line[start:i])
+			start = i + 1
+		}
+	}
+	return append(args, line[start:])
+}
 
-	as.sect = as.text
+// inst assembles the instruction op_ with its parsed arguments,
+// it returns false when the instruction ends the assembly.
+func (as *x86) inst(op_ string, addr [4]addr) bool {
+	unk := func() {
+		as.errorf("unknown argument")
+	}
 
-loop:
-	for as.lineno = 1; s.Scan(); as.lineno++ {
-		as.line = strings.TrimSpace(s.Text())
-		line := as.line
+	x, y := addr[0], addr[1]
+	lop := strings.ToLower(op_)
+	lop = as.alias(lop, x, y)
+	as.check(lop, addr)
+	if as.directive(lop, addr) {
+		return true
+	}
 
-	scan:
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
+	switch lop := as.amd64op(lop); lop {
+	case ".abort":
+		return false
+	case ".extern":
+	case ".quad":
+		as.ranges(lop, addr[:], 8)
+		as.bytes(opQUAD, addr, 8)
+	case ".long":
+		as.ranges(lop, addr[:], 4)
+		as.bytes(opLONG, addr, 4)
+	case ".short":
+		as.ranges(lop, addr[:], 2)
+		as.bytes(opSHORT, addr, 2)
+	case ".byte":
+		as.ranges(lop, addr[:], 1)
+		as.bytes(opBYTE, addr, 1)
+	case ".align", ".balign":
+		if x.ival <= 0 || x.ival > maxAlign || x.ival&(x.ival-1) != 0 {
+			as.errorf("%s: alignment %d is not a power of 2 up to %d", lop, x.ival, maxAlign)
 		}
-		if i := strings.Index(line, "#"); i >= 0 {
-			line = line[:i]
+		as.ranges(lop, addr[1:2], 1)
+		as.alignpc(int(x.ival), as.fill(y))
+	case ".p2align":
+		if x.ival < 0 || 1<<uint(x.ival) > maxAlign {
+			as.errorf("%s: alignment 2**%d out of range", lop, x.ival)
 		}
-
-		for {
-			i := strings.Index(line, ":")
-			if i > 0 {
-				as.addlabel(line[:i], as.sect.size, as.sect.pc)
-				line = line[i+1:]
-				goto scan
-			}
-			break
-		}
-
-		var op_ string
-		fmt.Sscan(line, &op_)
-
-		var addr [4]addr
-		if len(line) > len(op_) {
-			line = strings.TrimSpace(line[len(op_)+1:])
-			args := strings.Split(line, ",")
-			if len(args) > len(addr) {
-				as.errorf("junk at end")
-			}
-			for i, arg := range args {
-				arg = strings.TrimSpace(arg)
-				addr[i] = as.arg(arg)
+		as.ranges(lop, addr[1:2], 1)
+		as.alignpc(1<<uint(x.ival), as.fill(y))
+	case "addq":
+		switch x.typ | y.typ<<8 {
+		case aREG | aREG<<8:
+			as.emit(opADDQ, addr, as.rex(x.reg, y.reg), 0x01, modrm(x.reg, y.reg))
+		case aINT | aREG<<8:
+			switch n := x.ival; {
+			case -128 <= n && n <= 127:
+				as.emit(opADDQ, addr, as.rex(0, y.reg), 0x83, modrm(group1[opADDQ], y.reg), byte(n))
+			case y.reg == rRAX:
+				as.emit(opADDQ, addr, as.rexw, 0x05, uint32(n))
+			default:
+				as.emit(opADDQ, addr, as.rex(0, y.reg), 0x81, modrm(group1[opADDQ], y.reg), uint32(n))
 			}
+		case aINT | aMEM<<8:
+			as.emit(opADDQ, addr, as.rexw, 0x83, as.mem(group1[opADDQ], y), byte(x.ival))
+		case aINT | aPTR<<8:
+			as.addrel(opADDQ, addr)
+		case aMEM | aREG<<8:
+			as.emit(opADDQ, addr, as.rex(y.reg, 0), 0x3, as.mem(y.reg, x))
+		case aREG | aPTR<<8:
+			as.addrel(opADDQ, addr)
+		default:
+			unk()
 		}
-
-		x, y, z := addr[0], addr[1], addr[2]
-		lop := strings.ToLower(op_)
-		lop = as.alias(lop, x, y)
-
-		switch lop {
-		case ".abort":
-			break loop
-		case ".section":
-			as.addsect(x.sval, y.sval, z.sval)
-			continue
-		case ".text":
-			as.sect = as.text
-			continue
-		case ".data":
-			as.sect = as.data
-			continue
-		case ".string":
-			as.sect.strz(x.sval)
-			continue
-		case ".lcomm":
-			as.addbss(x.sval, y.ival, true)
-			continue
-		case ".comm":
-			as.addbss(x.sval, y.ival, false)
-			continue
-		case ".globl":
-			as.addglobal(x.sval)
-			continue
-		case ".extern":
-		case ".quad":
-			as.bytes(opQUAD, addr, 8)
-		case ".long":
-			as.bytes(opLONG, addr, 4)
-		case ".short":
-			as.bytes(opSHORT, addr, 2)
-		case ".byte":
-			as.bytes(opBYTE, addr, 1)
-		case ".align":
-			as.alignpc(int(x.ival), uint8(y.ival))
-		case ".p2align":
-			as.alignpc(1<<uint(x.ival), uint8(y.ival))
-		case "addq":
-			switch x.typ | y.typ<<8 {
-			case aREG | aREG<<8:
-				as.emit(opADDQ, addr, 0x48, 0x01, 0xc0+x.reg*8+y.reg)
-			case aINT | aREG<<8:
-				switch n := x.ival; {
-				case -128 <= n && n <= 127:
-					as.emit(opADDQ, addr, 0x48, 0x83, 0xc0+y.reg, byte(n))
-				case y.reg == rRAX:
-					as.emit(opADDQ, addr, 0x48, 0x05, uint32(n))
-				default:
-					as.emit(opADDQ, addr, 0x48, 0x81, 0xc0+y.reg, uint32(n))
-				}
-			case aINT | aMEM<<8:
-				as.emit(opADDQ, addr, 0x48, 0x83, as.poff(y.ival)+y.reg, as.xoff(y.ival), as.xoff(x.ival))
-			case aINT | aPTR<<8:
-				as.addrel(opADDQ, addr)
-			case aMEM | aREG<<8:
-				switch {
-				case x.reg != rRSP:
-					as.emit(opLEAQ, addr, 0x48, 0x3, as.poff(x.ival)+x.reg+8*y.reg, as.xoff(x.ival))
-				case x.reg == rRSP:
-					as.emit(opLEAQ, addr, 0x48, 0x3, as.poff(x.ival)+x.reg+8*y.reg, 0x24, as.xoff(x.ival))
-				default:
-					unk()
-				}
-			case aREG | aPTR<<8:
-				as.addrel(opADDQ, addr)
+	case "andq":
+		switch x.typ | y.typ<<8 {
+		case aREG | aREG<<8:
+			as.emit(opANDQ, addr, as.rex(x.reg, y.reg), 0x21, modrm(x.reg, y.reg))
+		case aREG | aMEM<<8:
+			as.emit(opANDQ, addr, as.rex(x.reg, 0), 0x21, as.mem(x.reg, y))
+		case aMEM | aREG<<8:
+			as.emit(opANDQ, addr, as.rex(y.reg, 0), 0x23, as.mem(y.reg, x))
+		case aINT | aREG<<8:
+			switch n := x.ival; {
+			case -128 <= n && n <= 127:
+				as.emit(opANDQ, addr, as.rex(0, y.reg), 0x83, modrm(group1[opANDQ], y.reg), byte(n))
+			case y.reg == rRAX:
+				as.emit(opANDQ, addr, as.rexw, 0x25, uint32(n))
 			default:
-				unk()
+				as.emit(opANDQ, addr, as.rex(0, y.reg), 0x81, modrm(group1[opANDQ], y.reg), uint32(n))
 			}
-		case "andq":
-			switch x.typ | y.typ<<8 {
-			case aREG | aREG<<8:
-				as.emit(opANDQ, addr, 0x48, 0x21, 0xc0+x.reg*8+y.reg)
-			case aREG | aMEM<<8:
-				switch {
-				case x.reg != rRSP:
-					as.emit(opANDQ, addr, 0x48, 0x21, as.poff(y.ival)+8*x.reg+y.reg, as.xoff(y.ival))
-				case x.reg == rRSP:
-					as.emit(opANDQ, addr, 0x48, 0x21, as.poff(y.ival)+8*x.reg+y.reg, 0x24, as.xoff(y.ival))
-				default:
-					unk()
-				}
+		default:
+			unk()
+		}
+	case "call":
+		switch x.typ {
+		case aREG:
+			switch {
+			case x.deref && x.ival == 0:
+				as.emit(opCALL, addr, 0xff, 0xd0+x.reg)
 			default:
 				unk()
 			}
-		case "call":
-			switch x.typ {
-			case aREG:
-				switch {
-				case x.deref && x.ival == 0:
-					as.emit(opCALL, addr, 0xff, 0xd0+x.reg)
-				default:
-					unk()
-				}
-			case aPTR:
-				as.addrel(opCALL, addr)
+		case aPTR:
+			as.addrel(opCALL, addr)
+		default:
+			unk()
+		}
+	case "cld":
+		as.emit(opCLD, addr, 0xfc)
+	case "cli":
+		as.emit(opCLI, addr, 0xfa)
+	case "cmpq":
+		switch x.typ | y.typ<<8 {
+		case aREG | aREG<<8:
+			as.emit(opCMPQ, addr, as.rex(x.reg, y.reg), 0x39, modrm(x.reg, y.reg))
+		case aINT | aREG<<8:
+			switch n := x.ival; {
+			case -128 <= n && n <= 127:
+				as.emit(opCMPQ, addr, as.rex(0, y.reg), 0x83, modrm(group1[opCMPQ], y.reg), byte(n))
+			case y.reg == rRAX:
+				as.emit(opCMPQ, addr, as.rexw, 0x3d, uint32(n))
 			default:
-				unk()
+				as.emit(opCMPQ, addr, as.rex(0, y.reg), 0x81, modrm(group1[opCMPQ], y.reg), uint32(n))
 			}
-		case "cld":
-			as.emit(opCLD, addr, 0xfc)
-		case "cli":
-			as.emit(opCLI, addr, 0xfa)
-		case "cmpq":
-			switch x.typ | y.typ<<8 {
-			case aREG | aREG<<8:
-				as.emit(opCMPQ, addr, 0x48, 0x39, 0xc0+x.reg*8+y.reg)
-			case aINT | aREG<<8:
-				switch n := x.ival; {
-				case -128 <= n && n <= 127:
-					as.emit(opCMPQ, addr, 0x48, 0x83, 0xf8+y.reg, byte(n))
-				case y.reg == rRAX:
-					as.emit(opCMPQ, addr, 0x48, 0x3d, uint32(n))
-				default:
-					as.emit(opCMPQ, addr, 0x48, 0x81, 0xf0+y.reg, uint32(n))
-				}
-			case aINT | aPTR<<8:
-				as.addrel(opCMPQ, addr)
-			case aINT | aMEM<<8:
-				switch {
-				case y.reg == rRSP:
-					as.emit(opCMPQ, addr, 0x83, 0x7c, 0x24, as.poff(y.ival)+y.reg, as.xoff(y.ival), as.xoff(x.ival))
-				}
-			case aREG | aPTR<<8:
-				as.addrel(opCMPQ, addr)
-			default:
-				unk()
+		case aINT | aPTR<<8:
+			as.addrel(opCMPQ, addr)
+		case aINT | aMEM<<8:
+			as.emit(opCMPQ, addr, as.rexw, 0x83, as.mem(group1[opCMPQ], y), byte(x.ival))
+		case aREG | aPTR<<8:
+			as.addrel(opCMPQ, addr)
+		default:
+			unk()
+		}
+	case "cqo":
+		as.emit(opCQO, addr, as.rexw, 0x99)
+	case "decq":
+		switch x.typ {
+		case aREG:
+			if as.word == 4 {
+				as.emit(opDECQ, addr, 0x48+x.reg)
+				break
 			}
-		case "cqo":
-			as.emit(opCQO, addr, 0x48, 0x99)
-		case "decq":
-			switch x.typ {
-			case aREG:
-				as.emit(opDECQ, addr, 0x48, 0xff, 0xc8+x.reg)
-			case aMEM:
-				as.emit(opDECQ, addr, 0x48, 0xff, as.poff(x.ival)+8+x.reg, as.xoff(x.ival))
-			case aPTR:
-				as.addrel(opDECQ, addr)
+			as.emit(opDECQ, addr, as.rex(0, x.reg), 0xff, modrm(1, x.reg))
+		case aMEM:
+			as.emit(opDECQ, addr, as.rexw, 0xff, as.mem(1, x))
+		case aPTR:
+			as.addrel(opDECQ, addr)
+		default:
+			unk()
+		}
+	case "divq":
+		switch x.typ | y.typ<<8 {
+		case aREG:
+			as.emit(opDIVQ, addr, as.rexw, 0xf7, 0xf0+x.reg)
+		default:
+			unk()
+		}
+	case "hlt":
+		as.emit(opHLT, addr, 0xf4)
+	case "idivq":
+		switch x.typ | y.typ<<8 {
+		case aREG:
+			as.emit(opIDIVQ, addr, as.rexw, 0xf7, 0xf8+x.reg)
+		default:
+			unk()
+		}
+	case "imulq":
+		switch x.typ | y.typ<<8 {
+		case aREG | aREG<<8:
+			as.emit(opIMULQ, addr, as.rexw, 0xf, 0xaf, 0xc0+x.reg+8*y.reg)
+		case aMEM | aREG<<8:
+			as.emit(opIMULQ, addr, as.rexw, 0xf, 0xaf, as.mem(y.reg, x))
+		case aINT | aREG<<8:
+			switch n := x.ival; {
+			case -128 <= n && n <= 127:
+				as.emit(opIMULQ, addr, as.rexw, 0x6b, 0xc0+y.reg*9, byte(n))
 			default:
-				unk()
+				as.emit(opIMULQ, addr, as.rexw, 0x69, 0xc0+y.reg*9, uint32(n))
 			}
-		case "divq":
-			switch x.typ | y.typ<<8 {
-			case aREG:
-				as.emit(opDIVQ, addr, 0x48, 0xf7, 0xf0+x.reg)
-			default:
-				unk()
-			}
-		case "hlt":
-			as.emit(opHLT, addr, 0xf4)
-		case "idivq":
-			switch x.typ | y.typ<<8 {
-			case aREG:
-				as.emit(opIDIVQ, addr, 0x48, 0xf7, 0xf8+x.reg)
-			default:
-				unk()
+		default:
+			unk()
+		}
+	case "incq":
+		switch x.typ | y.typ<<8 {
+		case aREG:
+			if as.word == 4 {
+				// i386 has the short form that amd64 took for REX
+				as.emit(opINCQ, addr, 0x40+x.reg)
+				break
 			}
-		case "imulq":
-			switch x.typ | y.typ<<8 {
-			case aREG | aREG<<8:
-				as.emit(opIMULQ, addr, 0x48, 0xf, 0xaf, 0xc0+x.reg+8*y.reg)
-			default:
-				unk()
+			as.emit(opINCQ, addr, as.rex(0, x.reg), 0xff, modrm(0, x.reg))
+		case aMEM:
+			as.emit(opINCQ, addr, as.rexw, 0xff, as.mem(0, x))
+		case aPTR:
+			as.addrel(opINCQ, addr)
+		default:
+			unk()
+		}
+	case "int":
+		switch x.typ | y.typ<<8 {
+		case aINT:
+			as.emit(opINT, addr, 0xcd, byte(x.ival))
+		default:
+			unk()
+		}
+	case "jmp", "jne", "je", "jge", "jle", "jg", "jl", "jae", "jbe", "ja", "jb", "jz", "jnz", "jc", "jnc", "jo", "jno":
+		branches := map[string]op{
+			"jmp": opJMP,
+			"jne": opJNE,
+			"je":  opJE,
+			"jge": opJGE,
+			"jle": opJLE,
+			"jg":  opJG,
+			"jl":  opJL,
+			"jae": opJAE,
+			"jbe": opJBE,
+			"ja":  opJA,
+			"jb":  opJB,
+			"jz":  opJZ,
+			"jnz": opJNZ,
+			"jnc": opJAE,
+			"jc":  opJB,
+			"jo":  opJO,
+			"jno": opJNO,
+		}
+		switch x.typ {
+		case aPTR:
+			as.addrel(branches[lop], addr)
+		case aREG:
+			if lop != "jmp" {
+				as.errorf("%s does not take a register operand", lop)
 			}
-		case "incq":
-			switch x.typ | y.typ<<8 {
-			case aREG:
-				as.emit(opINCQ, addr, 0x48, 0xff, 0xc0+x.reg)
-			case aMEM:
-				as.emit(opINCQ, addr, 0x48, 0xff, as.poff(x.ival)+x.reg, as.xoff(x.ival))
-			case aPTR:
-				as.addrel(opINCQ, addr)
+			as.emit(opJMP, addr, 0xff, 0xe0+x.reg)
+		default:
+			unk()
+		}
+	case "leaq":
+		switch x.typ | y.typ<<8 {
+		case aMEM | aREG<<8:
+			as.emit(opLEAQ, addr, as.rex(y.reg, 0), 0x8d, as.mem(y.reg, x))
+		default:
+			unk()
+		}
+	case "lodsl":
+		as.emit(opLODSL, addr, 0xad)
+	case "lodsq":
+		as.emit(opLODSQ, addr, as.rexw, 0xad)
+	case "movsb":
+		as.emit(opMOVSB, addr, 0xa4)
+	case "loop", "loope", "loopz", "loopne", "loopnz":
+		loops := map[string]op{
+			"loop":   opLOOP,
+			"loope":  opLOOPE,
+			"loopz":  opLOOPE,
+			"loopne": opLOOPNE,
+			"loopnz": opLOOPNE,
+		}
+		as.addrel(loops[lop], addr)
+	case "movb":
+		switch x.typ | y.typ<<8 {
+		case aREG | aMEM<<8:
+			as.emit(opMOVB, addr, 0x88, as.mem(x.reg, y))
+		case aREG | aPTR<<8:
+			as.addrel(opMOVB, addr)
+		case aPTR | aREG<<8:
+			as.addrel(opMOVB, addr)
+		case aMEM | aREG<<8:
+			as.emit(opMOVB, addr, 0x8a, as.mem(y.reg, x))
+		default:
+			unk()
+		}
+	case "movl":
+		switch x.typ | y.typ<<8 {
+		case aREG | aMEM<<8:
+			as.emit(opMOVL, addr, 0x89, as.mem(x.reg, y))
+		default:
+			unk()
+		}
+	case "movq":
+		switch x.typ | y.typ<<8 {
+		case aREG | aREG<<8:
+			as.emit(opMOVQ, addr, as.rex(x.reg, y.reg), 0x89, modrm(x.reg, y.reg))
+		case aVAR | aREG<<8:
+			as.addrel(opMOVQ, addr)
+		case aREG | aPTR<<8:
+			as.addrel(opMOVQ, addr)
+		case aINT | aREG<<8:
+			switch {
+			case as.word == 4:
+				as.emit(opMOVQ, addr, 0xb8+y.reg, uint32(x.ival))
+			case x.ival < math.MinInt32 || x.ival > math.MaxInt32:
+				as.emit(opMOVQ, addr, as.rex(0, y.reg), 0xb8+y.reg&7, uint64(x.ival))
 			default:
-				unk()
+				as.emit(opMOVQ, addr, as.rex(0, y.reg), 0xc7, modrm(0, y.reg), uint32(x.ival))
 			}
-		case "int":
-			switch x.typ | y.typ<<8 {
-			case aINT:
-				as.emit(opINT, addr, 0xcd, byte(x.ival))
+		case aINT | aMEM<<8:
+			as.emit(opMOVQ, addr, as.rexw, 0xc7, as.mem(0, y), uint32(x.ival))
+		case aPTR | aREG<<8:
+			as.addrel(opMOVQ, addr)
+		case aMEM | aREG<<8:
+			as.emit(opMOVQ, addr, as.rex(y.reg, 0), 0x8b, as.mem(y.reg, x))
+		case aREG | aMEM<<8:
+			as.emit(opMOVQ, addr, as.rex(x.reg, 0), 0x89, as.mem(x.reg, y))
+		default:
+			unk()
+		}
+	case "movsbq", "movswq", "movzbq", "movzwq":
+		exts := map[string]struct {
+			op   op
+			code byte
+		}{
+			"movsbq": {opMOVSBQ, 0xbe},
+			"movswq": {opMOVSWQ, 0xbf},
+			"movzbq": {opMOVZBQ, 0xb6},
+			"movzwq": {opMOVZWQ, 0xb7},
+		}
+		switch x.typ | y.typ<<8 {
+		case aREG | aREG<<8:
+			e := exts[lop]
+			as.emit(e.op, addr, as.rexw, 0x0f, e.code, 0xc0+y.reg*8+x.reg)
+		default:
+			unk()
+		}
+	case "mulq":
+		switch x.typ | y.typ<<8 {
+		case aREG:
+			as.emit(opMULQ, addr, as.rexw, 0xf7, 0xe0+x.reg)
+		default:
+			unk()
+		}
+	case "negq":
+		switch x.typ {
+		case aREG:
+			as.emit(opNEGQ, addr, as.rexw, 0xf7, 0xd8+x.reg)
+		default:
+			unk()
+		}
+	case "nop":
+		as.emit(opNOP, addr, 0x90)
+	case "notq":
+		switch x.typ {
+		case aREG:
+			as.emit(opNOTQ, addr, as.rexw, 0xf7, 0xd0+x.reg)
+		default:
+			unk()
+		}
+	case "orq":
+		switch x.typ | y.typ<<8 {
+		case aREG | aREG<<8:
+			as.emit(opORQ, addr, as.rex(x.reg, y.reg), 0x9, modrm(x.reg, y.reg))
+		case aMEM | aREG<<8:
+			as.emit(opORQ, addr, as.rex(y.reg, 0), 0xb, as.mem(y.reg, x))
+		case aINT | aREG<<8:
+			switch n := x.ival; {
+			case -128 <= n && n <= 127:
+				as.emit(opORQ, addr, as.rex(0, y.reg), 0x83, modrm(group1[opORQ], y.reg), byte(n))
+			case y.reg == rRAX:
+				as.emit(opORQ, addr, as.rexw, 0xd, uint32(n))
 			default:
-				unk()
+				as.emit(opORQ, addr, as.rex(0, y.reg), 0x81, modrm(group1[opORQ], y.reg), uint32(n))
 			}
-		case "jmp", "jne", "je", "jge", "jle", "jg", "jl", "jae", "jbe", "ja", "jb", "jz", "jnz", "jc", "jnc":
-			branches := map[string]op{
-				"jmp": opJMP,
-				"jne": opJNE,
-				"je":  opJE,
-				"jge": opJGE,
-				"jle": opJLE,
-				"jg":  opJG,
-				"jl":  opJL,
-				"jae": opJAE,
-				"jbe": opJBE,
-				"ja":  opJA,
-				"jb":  opJB,
-				"jz":  opJZ,
-				"jnz": opJNZ,
-				"jnc": opJAE,
-				"jc":  opJB,
-			}
-			switch x.typ {
-			case aPTR:
-				as.addrel(branches[lop], addr)
-			case aREG:
-				as.emit(opJMP, addr, 0xff, 0xe0+x.reg)
-			default:
+		default:
+			unk()
+		}
+	case "pushq":
+		if x.typ != aREG {
+			unk()
+		}
+		as.emit(opPUSHQ, addr, 0x50+x.reg)
+	case "popq":
+		if x.typ != aREG {
+			unk()
+		}
+		as.emit(opPOPQ, addr, 0x58+x.reg)
+	case "sarq":
+		switch x.typ | y.typ<<8 {
+		case aREG | aREG<<8:
+			if x.reg != rCL {
 				unk()
 			}
-		case "leaq":
-			switch x.typ | y.typ<<8 {
-			case aMEM | aREG<<8:
-				switch {
-				case x.reg != rRSP:
-					as.emit(opLEAQ, addr, 0x48, 0x8d, as.poff(x.ival)+x.reg+8*y.reg, as.xoff(x.ival))
-				case x.reg == rRSP:
-					as.emit(opLEAQ, addr, 0x48, 0x8d, as.poff(x.ival)+x.reg+8*y.reg, 0x24, as.xoff(x.ival))
-				default:
-					unk()
-				}
-			default:
+			as.emit(opSARQ, addr, as.rexw, 0xd3, 0xf8+y.reg)
+		default:
+			unk()
+		}
+	case "sbbq":
+		switch x.typ | y.typ<<8 {
+		case aREG | aREG<<8:
+			as.emit(opSBBQ, addr, as.rexw, 0x19, 0xc0+x.reg*8+y.reg)
+		default:
+			unk()
+		}
+	case "shlq":
+		switch x.typ | y.typ<<8 {
+		case aREG | aREG<<8:
+			if x.reg != rCL {
 				unk()
 			}
-		case "lodsl":
-			as.emit(opLODSL, addr, 0xad)
-		case "lodsq":
-			as.emit(opLODSQ, addr, 0x48, 0xad)
-		case "loop", "loope", "loopz", "loopne", "loopnz":
-			loops := map[string]op{
-				"loop":   opLOOP,
-				"loope":  opLOOPE,
-				"loopz":  opLOOPE,
-				"loopne": opLOOPNE,
-				"loopnz": opLOOPNE,
-			}
-			as.addrel(loops[lop], addr)
-		case "movb":
-			switch x.typ | y.typ<<8 {
-			case aREG | aMEM<<8:
-				as.emit(opMOVB, addr, 0x88, as.poff(y.ival)+8*x.reg+y.reg, as.xoff(y.ival))
-			case aPTR | aREG<<8:
-				as.addrel(opMOVB, addr)
-			case aMEM | aREG<<8:
-				switch {
-				case x.reg != rRSP:
-					as.emit(opMOVB, addr, 0x8a, as.poff(x.ival)+x.reg+8*y.reg, as.xoff(x.ival))
-				default:
-					unk()
-				}
-			default:
+			as.emit(opSHLQ, addr, as.rexw, 0xd3, 0xe0+y.reg)
+		case aINT | aREG<<8:
+			as.emit(opSHLQ, addr, as.rexw, 0xc1, 0xe0+y.reg, byte(x.ival))
+		default:
+			unk()
+		}
+	case "shrq":
+		switch x.typ | y.typ<<8 {
+		case aREG | aREG<<8:
+			if x.reg != rCL {
 				unk()
 			}
-		case "movl":
-			switch x.typ | y.typ<<8 {
-			case aREG | aMEM<<8:
-				switch {
-				case x.reg != rRSP:
-					as.emit(opMOVL, addr, 0x89, as.poff(y.ival)+8*x.reg+y.reg, as.xoff(y.ival))
-				case x.reg == rRSP:
-					as.emit(opMOVL, addr, 0x89, as.poff(y.ival)+8*x.reg+y.reg, 0x24, as.xoff(y.ival))
-				default:
-					unk()
-				}
+			as.emit(opSHRQ, addr, as.rexw, 0xd3, 0xe8+y.reg)
+		case aINT | aREG<<8:
+			as.emit(opSHRQ, addr, as.rexw, 0xc1, 0xe8+y.reg, byte(x.ival))
+		default:
+			unk()
+		}
+	case "sti":
+		as.emit(opSTI, addr, 0xfb)
+	case "stosb":
+		as.emit(opSTOSB, addr, 0xaa)
+	case "subq":
+		switch x.typ | y.typ<<8 {
+		case aREG | aREG<<8:
+			as.emit(opSUBQ, addr, as.rex(x.reg, y.reg), 0x29, modrm(x.reg, y.reg))
+		case aINT | aMEM<<8:
+			as.emit(opSUBQ, addr, as.rexw, 0x83, as.mem(group1[opSUBQ], y), byte(x.ival))
+		case aMEM | aREG<<8:
+			as.emit(opSUBQ, addr, as.rex(y.reg, 0), 0x2b, as.mem(y.reg, x))
+		case aINT | aREG<<8:
+			switch n := x.ival; {
+			case -128 <= n && n <= 127:
+				as.emit(opSUBQ, addr, as.rex(0, y.reg), 0x83, modrm(group1[opSUBQ], y.reg), byte(n))
+			case y.reg == rRAX:
+				as.emit(opSUBQ, addr, as.rexw, 0x2d, uint32(n))
 			default:
-				unk()
+				as.emit(opSUBQ, addr, as.rex(0, y.reg), 0x81, modrm(group1[opSUBQ], y.reg), uint32(n))
 			}
-		case "movq":
-			switch x.typ | y.typ<<8 {
-			case aREG | aREG<<8:
-				as.emit(opMOVQ, addr, 0x48, 0x89, 0xc0+x.reg*8+y.reg)
-			case aVAR | aREG<<8:
-				as.addrel(opMOVQ, addr)
-			case aREG | aPTR<<8:
-				as.addrel(opMOVQ, addr)
-			case aINT | aREG<<8:
-				switch {
-				case x.ival < math.MinInt32 || x.ival > math.MaxUint32:
-					as.emit(opMOVQ, addr, 0x48, 0xb8+y.reg, uint64(x.ival))
-				default:
-					as.emit(opMOVQ, addr, 0x48, 0xc7, 0xc0+y.reg, uint32(x.ival))
-				}
-			case aINT | aMEM<<8:
-				as.emit(opMOVQ, addr, 0x48, 0xc7, as.poff(y.ival)+y.reg, as.xoff(y.ival), uint32(x.ival))
-			case aPTR | aREG<<8:
-				as.addrel(opMOVQ, addr)
-			case aMEM | aREG<<8:
-				switch {
-				case x.reg != rRSP:
-					as.emit(opMOVQ, addr, 0x48, 0x8b, as.poff(x.ival)+x.reg+8*y.reg, as.xoff(x.ival))
-				case x.reg == rRSP:
-					as.emit(opMOVQ, addr, 0x48, 0x8b, as.poff(x.ival)+x.reg+8*y.reg, 0x24, as.xoff(x.ival))
-				default:
-					unk()
-				}
-			case aREG | aMEM<<8:
-				switch {
-				case x.reg != rRSP:
-					as.emit(opMOVQ, addr, 0x48, 0x89, as.poff(y.ival)+8*x.reg+y.reg, as.xoff(y.ival))
-				case x.reg == rRSP:
-					as.emit(opMOVQ, addr, 0x48, 0x89, as.poff(y.ival)+8*x.reg+y.reg, 0x24, as.xoff(y.ival))
-				default:
-					unk()
-				}
-			default:
-				unk()
+		default:
+			unk()
+		}
+	case "xchgq":
+		switch x.typ | y.typ<<8 {
+		case aREG | aREG<<8:
+			if x.reg == 0 || y.reg == 0 {
+				as.emit(opXCHGQ, addr, as.rexw, 0x90+x.reg+y.reg)
+			} else {
+				as.emit(opXCHGQ, addr, as.rexw, 0x87, 0xc0+x.reg*8+y.reg)
 			}
-		case "negq":
-			switch x.typ {
-			case aREG:
-				as.emit(opNEGQ, addr, 0x48, 0xf7, 0xd8+x.reg)
+		default:
+			unk()
+		}
+	case "xorq":
+		switch x.typ | y.typ<<8 {
+		case aREG | aREG<<8:
+			as.emit(opXORQ, addr, as.rex(x.reg, y.reg), 0x31, modrm(x.reg, y.reg))
+		case aMEM | aREG<<8:
+			as.emit(opXORQ, addr, as.rex(y.reg, 0), 0x33, as.mem(y.reg, x))
+		case aINT | aREG<<8:
+			switch n := x.ival; {
+			case -128 <= n && n <= 127:
+				as.emit(opXORQ, addr, as.rex(0, y.reg), 0x83, modrm(group1[opXORQ], y.reg), byte(n))
+			case y.reg == rRAX:
+				as.emit(opXORQ, addr, as.rexw, 0x35, uint32(n))
 			default:
-				unk()
+				as.emit(opXORQ, addr, as.rex(0, y.reg), 0x81, modrm(group1[opXORQ], y.reg), uint32(n))
 			}
-		case "nop":
-			as.emit(opNOP, addr, 0x90)
-		case "notq":
-			switch x.typ {
-			case aREG:
-				as.emit(opNOTQ, addr, 0x48, 0xf7, 0xd0+x.reg)
-			default:
-				unk()
+		default:
+			unk()
+		}
+	case "rep":
+		as.emit(opREP, addr, 0xf3)
+	case "ret":
+		as.emit(opRET, addr, 0xc3)
+	case "syscall":
+		as.emit(opSYSCALL, addr, 0xf, 0x5)
+	default:
+		as.errorf("unknown instruction %s", lop)
+	}
+
+	as.sect.pc++
+	return true
+}
+
+// fill returns the fill of the padding of an align directive with
+// the fill value y. Without a fill value the padding of the code is
+// made of nops, as it can be run to reach the code aligned.
+func (as *x86) fill(y addr) func(n int) []byte {
+	if y.typ == aNONE && as.sect.flags&sfExec != 0 {
+		return nops
+	}
+	return fillValue(uint8(y.ival))
+}
+
+// longNops are the nops of 1 to 9 bytes, the ones of more than a byte
+// are a single instruction decoded faster than many one byte nops.
+var longNops = [][]byte{
+	{0x90},
+	{0x66, 0x90},
+	{0x0f, 0x1f, 0x00},
+	{0x0f, 0x1f, 0x40, 0x00},
+	{0x0f, 0x1f, 0x44, 0x00, 0x00},
+	{0x66, 0x0f, 0x1f, 0x44, 0x00, 0x00},
+	{0x0f, 0x1f, 0x80, 0x00, 0x00, 0x00, 0x00},
+	{0x0f, 0x1f, 0x84, 0x00, 0x00, 0x00, 0x00, 0x00},
+	{0x66, 0x0f, 0x1f, 0x84, 0x00, 0x00, 0x00, 0x00, 0x00},
+}
+
+// nops returns n bytes of nops.
+func nops(n int) []byte {
+	buf := make([]byte, 0, n)
+	for n > 0 {
+		k := n
+		if k > len(longNops) {
+			k = len(longNops)
+		}
+		buf = append(buf, longNops[k-1]...)
+		n -= k
+	}
+	return buf
+}
+
+// finish resolves the relocations once all the
+// instructions have been assembled.
+func (as *x86) finish() {
+	as.fixupRelocs(as.text)
+	as.fixupRelocs(as.data)
+	as.fixupBSS()
+	for _, s := range as.sections() {
+		if s.size > as.max {
+			as.errorf("section %s of %d bytes is larger than the maximum of %d bytes", s.name, s.size, as.max)
+		}
+	}
+}
+
+// check validates the operands of an instruction against what it
+// accepts, so bad operands are reported instead of being encoded
+// into a corrupt instruction.
+func (as *x86) check(lop string, addr [4]addr) {
+	q := as.amd64op(lop)
+	d, ok := x86ops[q]
+	if !ok {
+		return
+	}
+
+	n := 0
+	for n < len(addr) && addr[n].typ != aNONE {
+		n++
+	}
+	if n != d.nargs {
+		as.errorf("%s takes %d operand(s), got %d", lop, d.nargs, n)
+	}
+
+	for i, a := range addr[:n] {
+		switch a.typ {
+		case aREG:
+			r := x86regs[a.sval]
+			size := d.size
+			if size == 8 {
+				size = as.word
 			}
-		case "orq":
-			switch x.typ | y.typ<<8 {
-			case aREG | aREG<<8:
-				as.emit(opORQ, addr, 0x48, 0x9, 0xc0+8*x.reg+y.reg)
-			default:
-				unk()
+			if d.from != 0 && i == 0 {
+				size = d.from
 			}
-		case "pushq":
-			as.emit(opPUSHQ, addr, 0x50+x.reg)
-		case "popq":
-			as.emit(opPOPQ, addr, 0x58+x.reg)
-		case "sarq":
-			switch x.typ | y.typ<<8 {
-			case aREG | aREG<<8:
-				if x.reg != rCL {
-					unk()
+			if d.count && i == 0 {
+				if a.reg != rCL {
+					as.errorf("%s: shift count must be %%cl or an immediate", lop)
 				}
-				as.emit(opSARQ, addr, 0x48, 0xd3, 0xf8+y.reg)
-			default:
-				unk()
+				size = 1
 			}
-		case "sbbq":
-			switch x.typ | y.typ<<8 {
-			case aREG | aREG<<8:
-				as.emit(opSBBQ, addr, 0x48, 0x19, 0xc0+x.reg*8+y.reg)
-			default:
-				unk()
+			if size == 0 {
+				as.errorf("%s does not take a register operand", lop)
 			}
-		case "shlq":
-			switch x.typ | y.typ<<8 {
-			case aREG | aREG<<8:
-				if x.reg != rCL {
-					unk()
-				}
-				as.emit(opSHLQ, addr, 0x48, 0xd3, 0xe0+y.reg)
-			case aINT | aREG<<8:
-				as.emit(opSHLQ, addr, 0x48, 0xc1, 0xe0+y.reg, byte(x.ival))
-			default:
-				unk()
+			if r.size != size {
+				as.errorf("%s: register %%%s is not %d-bit", lop, a.sval, 8*size)
 			}
-		case "shrq":
-			switch x.typ | y.typ<<8 {
-			case aREG | aREG<<8:
-				if x.reg != rCL {
-					unk()
-				}
-				as.emit(opSHRQ, addr, 0x48, 0xd3, 0xe8+y.reg)
-			case aINT | aREG<<8:
-				as.emit(opSHRQ, addr, 0x48, 0xc1, 0xe8+y.reg, byte(x.ival))
-			default:
-				unk()
+			if a.reg >= rR8 && !x86ext[q] {
+				as.errorf("%s: register %%%s is not supported", lop, a.sval)
 			}
-		case "sti":
-			as.emit(opSTI, addr, 0xfb)
-		case "subq":
-			switch x.typ | y.typ<<8 {
-			case aREG | aREG<<8:
-				as.emit(opSUBQ, addr, 0x48, 0x29, 0xc0+x.reg*8+y.reg)
-			case aINT | aMEM<<8:
-				as.emit(opSUBQ, addr, 0x48, 0x83, as.poff(y.ival)+0x28+y.reg, as.xoff(y.ival), as.xoff(x.ival))
-			case aINT | aREG<<8:
-				switch n := x.ival; {
-				case -128 <= n && n <= 127:
-					as.emit(opSUBQ, addr, 0x48, 0x83, 0xe8+y.reg, byte(n))
-				case y.reg == rRAX:
-					as.emit(opSUBQ, addr, 0x48, 0x2d, uint32(n))
-				default:
-					as.emit(opSUBQ, addr, 0x48, 0x81, 0xe0+y.reg, uint32(n))
-				}
-			default:
-				unk()
+
+		case aMEM:
+			r := x86regs[a.sval]
+			switch {
+			case d.size == 0:
+				as.errorf("%s does not take a memory operand", lop)
+			case a.sval != "" && r.size != as.word:
+				as.errorf("%s: memory operand base %%%s is not a %d-bit register", lop, a.sval, 8*as.word)
+			case a.reg >= rR8:
+				as.errorf("%s: memory operand base %%%s is not supported", lop, a.sval)
+			case a.scale != 0 && x86regs[a.iname].size != as.word:
+				as.errorf("%s: memory operand index %%%s is not a %d-bit register", lop, a.iname, 8*as.word)
+			case a.scale != 0 && a.index == rRSP:
+				as.errorf("%s: %%%s cannot be a memory operand index", lop, a.iname)
+			case a.scale != 0 && a.index >= rR8:
+				as.errorf("%s: memory operand index %%%s is not supported", lop, a.iname)
+			case a.scale != 0 && a.scale != 1 && a.scale != 2 && a.scale != 4 && a.scale != 8:
+				as.errorf("%s: memory operand scale %d is not 1, 2, 4 or 8", lop, a.scale)
+			case a.ival < math.MinInt32 || a.ival > math.MaxInt32:
+				as.errorf("%s: displacement %d out of range", lop, a.ival)
 			}
-		case "xchgq":
-			switch x.typ | y.typ<<8 {
-			case aREG | aREG<<8:
-				if x.reg == 0 || y.reg == 0 {
-					as.emit(opXCHGQ, addr, 0x48, 0x90+x.reg+y.reg)
-				} else {
-					as.emit(opXCHGQ, addr, 0x48, 0x87, 0xc0+x.reg*8+y.reg)
-				}
-			default:
-				unk()
+
+		case aINT:
+			if a.bare {
+				as.errorf("%s: absolute address %d is not supported, an immediate is written $%d", lop, a.ival, a.ival)
 			}
-		case "xorq":
-			switch x.typ | y.typ<<8 {
-			case aREG | aREG<<8:
-				switch {
-				case x.reg == rR10 && y.reg == rR10:
-					as.emit(opXORQ, addr, 0x4d, 0x31, 0xd2)
-				default:
-					as.emit(opXORQ, addr, 0x48, 0x31, 0xc0+x.reg*8+y.reg)
-				}
-			default:
-				unk()
+			min, max := int64(math.MinInt32), int64(math.MaxInt32)
+			switch {
+			case d.count:
+				min, max = 0, int64(8*as.word-1)
+			case q == "int":
+				min, max = 0, 255
+			case q == "movq" && addr[1].typ == aREG && as.word == 4:
+				min, max = math.MinInt32, math.MaxUint32
+			case q == "movq" && addr[1].typ == aREG:
+				min, max = math.MinInt64, math.MaxInt64
+			case q != "movq" && addr[1].typ == aMEM:
+				min, max = -128, 127
+			}
+			if a.ival < min || a.ival > max {
+				as.errorf("%s: immediate %d out of range [%d, %d]", lop, a.ival, min, max)
 			}
-		case "ret":
-			as.emit(opRET, addr, 0xc3)
-		case "syscall":
-			as.emit(opSYSCALL, addr, 0xf, 0x5)
-		default:
-			as.errorf("unknown instruction %s", lop)
 		}
+	}
+}
 
-		as.sect.pc++
+// rex returns the REX prefix of an instruction on words that has reg
+// in the reg field of its ModRM byte and rm in its r/m field, with the
+// fourth bit of the registers from %r8 up that the fields don't hold.
+// It is nil on i386, which has no REX prefix nor the registers.
+func (as *x86) rex(reg, rm byte) interface{} {
+	if as.rexw == nil {
+		return nil
 	}
-	as.line = ""
+	return as.rexw.(byte) | reg>>3<<2 | rm>>3
+}
 
-	as.fixupRelocs(as.text)
-	as.fixupRelocs(as.data)
-	as.fixupBSS()
+// modrm encodes the ModRM byte of an instruction on the registers reg
+// and rm, the fourth bit of which is in the prefix of rex.
+func modrm(reg, rm byte) byte {
+	return 0xc0 | (reg&7)<<3 | rm&7
 }
 
-// poff generates an x86 prefix based on
-// the offset.
-func (as *x86) poff(n int64) byte {
-	switch {
-	case n == 0:
-		return 0
+// mem encodes the memory operand m of an instruction that has reg
+// in the reg field of its ModRM byte. The ModRM byte is followed by
+// a SIB byte when the operand has an index or is based on %rsp, and
+// by the displacement.
+func (as *x86) mem(reg byte, m addr) []interface{} {
+	modrm := (reg & 7) << 3
+	if m.sval == "" {
+		// no base, just a 32-bit displacement and the scaled index
+		return []interface{}{modrm | 4, as.sib(m.scale, m.index, rRBP), uint32(int32(m.ival))}
+	}
+
+	var disp interface{}
+	switch n := m.ival; {
+	case n == 0 && m.reg != rRBP:
+		// (%rbp) without a displacement would be relative to %rip
 	case -128 <= n && n <= 127:
-		return 0x40
+		modrm |= 0x40
+		disp = byte(int8(n))
 	default:
-		return 0x80
+		modrm |= 0x80
+		disp = uint32(int32(n))
+	}
+
+	switch {
+	case m.scale != 0:
+		return []interface{}{modrm | 4, as.sib(m.scale, m.index, m.reg), disp}
+	case m.reg == rRSP:
+		return []interface{}{modrm | 4, as.sib(1, rRSP, rRSP), disp}
 	}
+	return []interface{}{modrm | m.reg, disp}
 }
 
-// xoff generates the right sized type based on the offset.
-func (as *x86) xoff(n int64) interface{} {
-	if n == 0 {
-		return nil
+// abs encodes the memory operand at an absolute address of an
+// instruction that has reg in the reg field of its ModRM byte, the
+// address is left to a relocation. The ModRM byte alone would make it
+// relative to %rip on amd64, so it takes a SIB byte without a base.
+func (as *x86) abs(reg byte) []interface{} {
+	if as.word == 4 {
+		return []interface{}{(reg&7)<<3 | 5, uint32(0)}
 	}
-	if -128 <= n && n <= 127 {
-		return byte(int8(n))
+	return []interface{}{(reg&7)<<3 | 4, as.sib(1, rRSP, rRBP), uint32(0)}
+}
+
+// sib encodes a SIB byte, an index of %rsp means there is none.
+func (as *x86) sib(scale int64, index, base byte) byte {
+	var ss byte
+	switch scale {
+	case 2:
+		ss = 1
+	case 4:
+		ss = 2
+	case 8:
+		ss = 3
 	}
-	return uint32(int32(n))
+	return ss<<6 | index<<3 | base
 }
 
 // relOp returns a instruction based on the offset.
 // X86 can generate variable sized instruction on branches
 // based on how big the offsets are.
-func (as *x86) relOp(p *relocation, o int64) (code []byte, reltyp int, relname string) {
+func (as *x86) relOp(p *relocation, o int64) (code []byte, reltyp obj.RelocKind, relname string) {
 	x := p.addr[0]
 	y := p.addr[1]
 	l := as.fsym(x.typ, x.sval)
 
-	reltyp = lS
+	reltyp = obj.RelocAbs
 	relname = x.sval
 	if (x.typ != aPTR && x.typ != aVAR) || relname == "" {
 		relname = y.sval
 	}
 	switch p.op {
-	case opJMP, opJNE, opJE, opJGE, opJLE, opJG, opJL, opJAE, opJBE, opJA, opJB, opJZ, opJNZ:
+	case opJMP, opJNE, opJE, opJGE, opJLE, opJG, opJL, opJAE, opJBE, opJA, opJB, opJZ, opJNZ, opJO, opJNO:
 		branches := map[op]struct {
 			s byte
 			l []byte
@@ -765,9 +1135,11 @@ func (as *x86) relOp(p *relocation, o int64) (code []byte, reltyp int, relname s
 			opJB:  {0x72, []byte{0xf, 0x82}},
 			opJZ:  {0x74, []byte{0xf, 0x84}},
 			opJNZ: {0x75, []byte{0xf, 0x85}},
+			opJO:  {0x70, []byte{0xf, 0x80}},
+			opJNO: {0x71, []byte{0xf, 0x81}},
 		}
 		switch {
-		case p.section == l.sect && l.typ == sLABEL:
+		case p.section == l.sect && l.typ == obj.SymLabel:
 			switch {
 			case -128 <= o && o <= 127:
 				code = []byte{branches[p.op].s, byte(int8(o))}
@@ -775,10 +1147,10 @@ func (as *x86) relOp(p *relocation, o int64) (code []byte, reltyp int, relname s
 				code = append(code, branches[p.op].l...)
 				code = append(code, byte(o), byte(o>>8), byte(o>>16), byte(o>>24))
 			}
-		case p.section != l.sect || l.typ == sBSS || l.typ == sUND:
+		case p.section != l.sect || l.typ == obj.SymBSS || l.typ == obj.SymUndef:
 			code = append(code, branches[p.op].l...)
 			code = append(code, 0, 0, 0, 0)
-			reltyp = lPC
+			reltyp = obj.RelocPC
 		default:
 			as.errorf("unknown jmp op %d", x.typ)
 		}
@@ -788,16 +1160,16 @@ func (as *x86) relOp(p *relocation, o int64) (code []byte, reltyp int, relname s
 			as.errorf("no identifier for call op")
 		}
 		switch l.typ {
-		case sLABEL:
+		case obj.SymLabel:
 			if !l.exported && p.section == l.sect {
-				reltyp = lN
+				reltyp = obj.RelocNone
 				code = []byte{0xe8, byte(o), byte(o >> 8), byte(o >> 16), byte(o >> 24)}
 				break
 			}
 			fallthrough
-		case sUND, sBSS:
+		case obj.SymUndef, obj.SymBSS:
 			code = []byte{0xe8, 0, 0, 0, 0}
-			reltyp = lPC
+			reltyp = obj.RelocPC
 		default:
 			as.errorf("unknown call op %d", x.typ)
 		}
@@ -816,20 +1188,36 @@ func (as *x86) relOp(p *relocation, o int64) (code []byte, reltyp int, relname s
 		code = []byte{loops[p.op].op, byte(o)}
 
 	case opADDQ:
-		code = []byte{0x48, 0x83, 0x4, 0x25, 0, 0, 0, 0}
-		code = append(code, as.code(as.xoff(x.ival))...)
-		reltyp = lS
+		switch n := x.ival; {
+		case x.typ == aREG:
+			code = as.code(as.rex(x.reg, 0), 0x01, as.abs(x.reg))
+		case -128 <= n && n <= 127:
+			code = as.code(as.rexw, 0x83, as.abs(0), byte(int8(n)))
+		default:
+			code = as.code(as.rexw, 0x81, as.abs(0), uint32(n))
+		}
+		reltyp = obj.RelocAbs
 
 	case opDECQ:
-		code = []byte{0x48, 0xff, 0xc, 0x25, 0, 0, 0, 0}
+		code = as.code(as.rexw, 0xff, as.abs(1))
 
 	case opINCQ:
-		code = []byte{0x48, 0xff, 0x4, 0x25, 0, 0, 0, 0}
+		code = as.code(as.rexw, 0xff, as.abs(0))
 
 	case opMOVB:
 		switch x.typ | y.typ<<8 {
+		case aREG | aPTR<<8:
+			if as.word == 4 && x.reg == rAL {
+				code = as.code(0xa2, uint32(0))
+				break
+			}
+			code = as.code(0x88, as.abs(x.reg))
 		case aPTR | aREG<<8:
-			code = []byte{0x8a, 0x4 + 8*x.reg, 0x25, 0, 0, 0, 0}
+			if as.word == 4 && y.reg == rAL {
+				code = as.code(0xa0, uint32(0))
+				break
+			}
+			code = as.code(0x8a, as.abs(y.reg))
 		default:
 			as.errorf("unknown movb op %d %d", x.typ, y.typ)
 		}
@@ -837,29 +1225,41 @@ func (as *x86) relOp(p *relocation, o int64) (code []byte, reltyp int, relname s
 	case opMOVQ:
 		switch x.typ | y.typ<<8 {
 		case aVAR | aREG<<8:
-			code = []byte{0x48, 0xc7, 0xc0 + y.reg, 0, 0, 0, 0}
+			if as.word == 4 {
+				code = as.code(0xb8+y.reg, uint32(0))
+				break
+			}
+			code = as.code(as.rex(0, y.reg), 0xc7, modrm(0, y.reg), uint32(0))
 		case aREG | aPTR<<8:
-			code = []byte{0x48, 0x89, 0x4 + 8*x.reg, 0x25, 0, 0, 0, 0}
+			if as.word == 4 && x.reg == rEAX {
+				code = as.code(0xa3, uint32(0))
+				break
+			}
+			code = as.code(as.rex(x.reg, 0), 0x89, as.abs(x.reg))
 		case aPTR | aREG<<8:
-			code = []byte{0x48, 0x8b, 0x4 + 8*x.reg + y.reg, 0x25, 0, 0, 0, 0}
+			if as.word == 4 && y.reg == rEAX {
+				code = as.code(0xa1, uint32(0))
+				break
+			}
+			code = as.code(as.rex(y.reg, 0), 0x8b, as.abs(y.reg))
 		default:
 			as.errorf("unknown movq op %d %d", x.typ, y.typ)
 		}
 
 	case opQUAD:
-		reltyp = lV
+		reltyp = obj.RelocData
 		code = []byte{0, 0, 0, 0, 0, 0, 0, 0}
 
 	case opLONG:
-		reltyp = lV
+		reltyp = obj.RelocData
 		code = []byte{0, 0, 0, 0}
 
 	case opSHORT:
-		reltyp = lV
+		reltyp = obj.RelocData
 		code = []byte{0, 0}
 
 	case opBYTE:
-		reltyp = lV
+		reltyp = obj.RelocData
 		code = []byte{0}
 
 	default:
@@ -869,29 +1269,6 @@ func (as *x86) relOp(p *relocation, o int64) (code []byte, reltyp int, relname s
 	return
 }
 
-// adjustRel adjusts all of the offsets for labels
-// and relocations. It is needed to fix the offset
-// for x86 variable sized instruction.
-func (as *x86) adjustRel(p *relocation, off int64) {
-	for _, q := range p.labels {
-		if q.pc > p.pc {
-			q.off += off
-		}
-	}
-	for _, q := range p.relocs {
-		if q.pc > p.pc {
-			q.off += off
-		}
-	}
-	for i := range p.strings {
-		q := p.strings[i]
-		if q.pc > p.pc {
-			q.off += off
-		}
-	}
-	p.size += off
-}
-
 // fixupRelocs tries to emit the right offsets and
 // correct relocations for all instructions that need it.
 func (as *x86) fixupRelocs(s *section) {
@@ -912,7 +1289,11 @@ func (as *x86) fixupRelocs(s *section) {
 			as.errorf("x86 relocation did not reach a fixed point")
 		}
 
+		// the changes of the sizes are collected and applied once
+		// the pass is done, the offsets in the pass have the changes
+		// of the instructions before them added
 		fixed := true
+		var d shifts
 		for _, p := range s.relocs {
 			x := p.addr[0]
 			y := p.addr[1]
@@ -923,13 +1304,23 @@ func (as *x86) fixupRelocs(s *section) {
 			if l == nil {
 				as.errorf("bad relocation data %d %d %q %d %q", x.typ, aPTR, x.sval, y.typ, y.sval)
 			}
-			p.code, p.reltyp, p.relname = as.relOp(p, l.off-p.off-int64(len(p.code)))
+			off := l.off
+			if l.sect == s && l.typ == obj.SymLabel {
+				off += d.at(l.pc)
+			}
+			p.code, p.reltyp, p.relname = as.relOp(p, off-(p.off+d.at(p.pc))-int64(len(p.code)))
 			if p.isize != len(p.code) {
-				as.adjustRel(p, int64(len(p.code)-p.isize))
+				d.add(p.pc, int64(len(p.code)-p.isize))
 				p.isize = len(p.code)
 				fixed = false
 			}
 		}
+		s.shift(&d)
+		// the padding of the alignments moves the code after it
+		// like the instructions do, so it is sized in the same loop
+		if s.repad() {
+			fixed = false
+		}
 		if fixed {
 			break
 		}
@@ -942,13 +1333,13 @@ func (as *x86) fixupRelocs(s *section) {
 
 		switch p.op {
 		case opCALL:
-			if p.reltyp == lN {
+			if p.reltyp == obj.RelocNone {
 				s.relocs = append(s.relocs[:i], s.relocs[i+1:]...)
 				continue
 			}
-		case opJMP, opJNE, opJE, opJGE, opJLE, opJG, opJL, opJAE, opJBE, opJA, opJB, opJZ, opJNZ:
+		case opJMP, opJNE, opJE, opJGE, opJLE, opJG, opJL, opJAE, opJBE, opJA, opJB, opJZ, opJNZ, opJO, opJNO:
 			switch l.typ {
-			case sLABEL:
+			case obj.SymLabel:
 				s.relocs = append(s.relocs[:i], s.relocs[i+1:]...)
 				continue
 			}
@@ -956,7 +1347,15 @@ func (as *x86) fixupRelocs(s *section) {
 
 		switch p.op {
 		case opADDQ:
-			p.rel = p.off + 4
+			// the address is followed by the immediate
+			p.rel = p.off + int64(len(p.code)) - 4
+			switch n := x.ival; {
+			case x.typ != aINT:
+			case -128 <= n && n <= 127:
+				p.rel--
+			default:
+				p.rel -= 4
+			}
 		case opQUAD, opLONG, opSHORT, opBYTE:
 			p.rel = p.off
 		default:
@@ -966,61 +1365,10 @@ func (as *x86) fixupRelocs(s *section) {
 	}
 }
 
-// fixupBSS fixes the BSS offsets after
-// everything has been relocated correctly.
-func (as *x86) fixupBSS() {
-	as.bss.blockalign = 1
-	off := int64(0)
-	for _, p := range as.bss.blocks {
-		if p.exported {
-			continue
-		}
-		if p.size == 0 {
-			as.errorf("invalid fixup bbs size of 0")
-		}
-		if n := off % p.size; p.size < 8 && n > 0 {
-			off += p.size - n
-		}
-
-		p.off = off
-		off += p.size
-		as.bss.blockalign = int64(align2(p.size))
-	}
-	if as.bss.blockalign > 8 {
-		as.bss.blockalign = 8
-	}
-}
-
 // arg decodes an argument.
 func (as *x86) arg(s string) (a addr) {
-	if strings.HasPrefix(s, ".") {
-		a.typ = aSECT
-		a.sval = s
-		return
-	}
-
-	if strings.HasPrefix(s, "\"") {
-		str, err := strconv.Unquote(s)
-		if err != nil {
-			as.errorf("invalid string arg")
-		}
-		a.typ = aSTR
-		a.sval = str
-		return
-	}
-
-	if strings.HasPrefix(s, "'") {
-		s = strings.Trim(s, "'")
-		r, _ := utf8.DecodeRuneInString(s)
-		a.typ = aINT
-		a.ival = int64(r)
-		return
-	}
-
-	if strings.HasPrefix(s, "@") {
-		a.typ = aNOTE
-		a.sval = s[1:]
-		return
+	if a, ok := as.literal(s); ok {
+		return a
 	}
 
 	ptr := true
@@ -1038,10 +1386,20 @@ func (as *x86) arg(s string) (a addr) {
 		return
 	}
 
-	ival, err := strconv.ParseInt(s, 0, 64)
-	if err == nil {
+	if sym, n, ok := symPlus(s); ok {
+		a.typ = aPTR
+		a.sval = sym
+		a.ival = n
+		if !ptr {
+			a.typ = aVAR
+		}
+		return
+	}
+
+	if isNumber(s) && !strings.Contains(s, "(") {
 		a.typ = aINT
-		a.ival = ival
+		a.ival = as.number(s)
+		a.bare = ptr
 		return
 	}
 
@@ -1053,10 +1411,7 @@ func (as *x86) arg(s string) (a addr) {
 	mem := false
 	if n := strings.Index(s, "("); n >= 0 {
 		if n > 0 {
-			a.ival, err = strconv.ParseInt(s[:n], 0, 64)
-			if err != nil {
-				as.errorf("%v", err)
-			}
+			a.ival = as.number(s[:n])
 		}
 		s = s[n:]
 		s = strings.TrimLeft(s, "(")
@@ -1064,6 +1419,29 @@ func (as *x86) arg(s string) (a addr) {
 		mem = true
 	}
 
+	// a memory operand with an index is (base, index, scale),
+	// the base and the scale can be left out
+	if mem && strings.Contains(s, ",") {
+		parts := strings.Split(s, ",")
+		if len(parts) > 3 {
+			as.errorf("unsupported arg %q", s)
+		}
+		index := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(parts[1]), "%"))
+		r, ok := x86regs[index]
+		if !ok {
+			as.errorf("unsupported index %q", parts[1])
+		}
+		a.index, a.iname, a.scale = r.reg, index, 1
+		if len(parts) == 3 {
+			a.scale = as.number(strings.TrimSpace(parts[2]))
+		}
+		s = strings.TrimSpace(parts[0])
+		if s == "" {
+			a.typ = aMEM
+			return
+		}
+	}
+
 	if strings.HasPrefix(s, "%") {
 		p := strings.ToLower(s[1:])
 		r, ok := x86regs[p]
@@ -37,9 +37,12 @@ type Stmt interface {
 // VarDecl is a node for a variable declaration.
 type VarDecl struct {
 	Storage *scan.Token
+	Const   *scan.Token // const qualifier, if any
 	Type    Expr
 	Name    *Ident
 	Value   Expr
+	Attrs   []*Attr
+	Asm     *StringLit // the symbol given by __asm__("name"), if any
 }
 
 // FieldDecl is a field declaration inside a record.
@@ -72,11 +75,21 @@ type FuncDecl struct {
 	Lparen  scan.Token
 	Params  []*FieldDecl
 	Rparen  scan.Token
+	Attrs   []*Attr
+	Asm     *StringLit // the symbol given by __asm__("name"), if any
 	Labels  []*LabeledStmt
 	Decls   []Decl
 	Body    *BlockStmt
 }
 
+// Attr is an attribute of a declaration, __attribute__((name)) or
+// __attribute__((name(args))).
+type Attr struct {
+	Name   *Ident
+	Args   []Expr
+	Rparen *scan.Token // the parenthesis that closes the arguments, if there are any
+}
+
 // RecordDecl is a struct or union declaration.
 type RecordDecl struct {
 	Storage *scan.Token
@@ -85,6 +98,7 @@ type RecordDecl struct {
 	Lbrace  scan.Token
 	Fields  []*FieldDecl
 	Rbrace  scan.Token
+	Pack    int64 // the alignment given by #pragma pack, 0 if none
 }
 
 // BadDecl is a bad declaration.
@@ -153,7 +167,8 @@ func (a Affix) String() string {
 	}
 }
 
-// UnaryExpr represents a unary expression.
+// UnaryExpr represents a unary expression. An Op of && takes the address
+// of the label X, a GNU extension.
 type UnaryExpr struct {
 	Op    scan.Token
 	Affix Affix
@@ -167,6 +182,15 @@ type ParenExpr struct {
 	Rparen scan.Token
 }
 
+// StmtExpr represents a statement expression, ({ decls; stmts; expr; }).
+// Its value is the value of the last statement, if it is an expression.
+type StmtExpr struct {
+	Lparen scan.Token
+	Decls  []Decl // the local declarations at the start of the block
+	Body   *BlockStmt
+	Rparen scan.Token
+}
+
 // CondExpr represents ternary expressions.
 type CondExpr struct {
 	Cond Expr
@@ -237,10 +261,12 @@ type ForStmt struct {
 	Body   Stmt
 }
 
-// GotoStmt represents a goto statement.
+// GotoStmt represents a goto statement. goto *X jumps to the address X,
+// a GNU extension, and has no Label.
 type GotoStmt struct {
 	Goto  scan.Token
 	Label *Ident
+	X     Expr
 }
 
 // WhileStmt represents a while statement.
@@ -279,10 +305,12 @@ type DoStmt struct {
 
 // CaseClause represents a case statement inside a switch statement.
 type CaseClause struct {
-	Case  scan.Token
-	Value Expr
-	Colon scan.Token
-	Body  []Stmt
+	Case     scan.Token
+	Value    Expr
+	Ellipsis *scan.Token // the ... of a case range
+	High     Expr        // the last value of a case range, nil if it isn't one
+	Colon    scan.Token
+	Body     []Stmt
 }
 
 // EmptyStmt represents a ; statement.
@@ -409,6 +437,13 @@ func (d *FuncDecl) Span() scan.Span {
 	return scan.Span{n.Start, m.End}
 }
 
+func (a *Attr) Span() scan.Span {
+	if a.Rparen != nil {
+		return span2(a.Name, a.Rparen)
+	}
+	return a.Name.Span()
+}
+
 func (d *ConstDecl) Span() scan.Span {
 	return span2(d.Name, d.X)
 }
@@ -417,7 +452,9 @@ func (d *VarDecl) Span() scan.Span {
 	var span [3]scan.Span
 	var n, m scanner.Position
 
-	if d.Storage != nil {
+	if d.Const != nil && (d.Storage == nil || d.Const.Pos.Offset < d.Storage.Pos.Offset) {
+		span[0] = d.Const.Span()
+	} else if d.Storage != nil {
 		span[0] = d.Storage.Span()
 	} else if d.Type != nil {
 		span[0] = d.Type.Span()
@@ -465,6 +502,7 @@ func (e *CallExpr) Span() scan.Span     { return span2(e.Fun, e.Rparen) }
 func (e *BinaryExpr) Span() scan.Span   { return span2(e.X, e.Y) }
 func (e *UnaryExpr) Span() scan.Span    { return span2(e.Op, e.X) }
 func (e *ParenExpr) Span() scan.Span    { return span2(e.Lparen, e.Rparen) }
+func (e *StmtExpr) Span() scan.Span     { return span2(e.Lparen, e.Rparen) }
 func (e *CondExpr) Span() scan.Span     { return span2(e.Cond, e.Y) }
 func (e *SizeofExpr) Span() scan.Span   { return span2(e.Sizeof, e.Rparen) }
 func (e *SelectorExpr) Span() scan.Span { return span2(e.X, e.Sel) }
@@ -502,7 +540,12 @@ func (s *SwitchStmt) Span() scan.Span { return span2(s.Switch, s.Body.Rbrace) }
 func (s *DoStmt) Span() scan.Span     { return span2(s.Do, s.Rparen) }
 func (s *WhileStmt) Span() scan.Span  { return span2(s.While, s.Body) }
 func (s *ForStmt) Span() scan.Span    { return span2(s.For, s.Body) }
-func (s *GotoStmt) Span() scan.Span   { return span2(s.Goto, s.Label) }
+func (s *GotoStmt) Span() scan.Span {
+	if s.X != nil {
+		return span2(s.Goto, s.X)
+	}
+	return span2(s.Goto, s.Label)
+}
 
 func (s *CaseClause) Span() scan.Span {
 	if len(s.Body) > 0 {
@@ -535,3 +578,10 @@ func (s *LabeledStmt) Span() scan.Span {
 func (s *EmptyStmt) Span() scan.Span { return s.Semi.Span() }
 func (s *ExprStmt) Span() scan.Span  { return s.X.Span() }
 func (s *BadStmt) Span() scan.Span   { return scan.Span{s.From, s.To} }
+
+func (p *Prog) Span() scan.Span {
+	if len(p.Decls) == 0 {
+		return scan.NoSpan
+	}
+	return span2(p.Decls[0], p.Decls[len(p.Decls)-1])
+}
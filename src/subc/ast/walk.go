@@ -0,0 +1,207 @@
+package ast
+
+import (
+	"fmt"
+
+	"subc/scan"
+)
+
+// Visitor has its Visit method called for every node encountered by Walk.
+// If the visitor w returned is not nil, Walk visits each of the
+// children of node with w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses an AST in depth-first order, it starts by calling
+// v.Visit(node) and then walks the children of node if the visitor
+// returned is not nil.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Prog:
+		for _, d := range n.Decls {
+			Walk(v, d)
+		}
+
+	case *VarDecl:
+		walk(v, n.Type)
+		walk(v, n.Name)
+		walk(v, n.Value)
+	case *FieldDecl:
+		walk(v, n.Type)
+		walk(v, n.Name)
+	case *ConstDecl:
+		walk(v, n.Name)
+		walk(v, n.X)
+	case *EnumDecl:
+		walk(v, n.Name)
+		for _, c := range n.List {
+			Walk(v, c)
+		}
+	case *FuncDecl:
+		walk(v, n.Result)
+		walk(v, n.Name)
+		for _, p := range n.Params {
+			Walk(v, p)
+		}
+		for _, d := range n.Decls {
+			Walk(v, d)
+		}
+		walk(v, n.Body)
+	case *RecordDecl:
+		walk(v, n.Name)
+		for _, f := range n.Fields {
+			Walk(v, f)
+		}
+
+	case *BasicType:
+		walk(v, n.X)
+	case *FuncType:
+		walk(v, n.Result)
+		for _, p := range n.Params {
+			walk(v, p)
+		}
+	case *ArrayType:
+		walk(v, n.Len)
+	case *RecordType:
+		walk(v, n.X)
+		walk(v, n.Name)
+
+	case *BinaryExpr:
+		walk(v, n.X)
+		walk(v, n.Y)
+	case *UnaryExpr:
+		walk(v, n.X)
+	case *ParenExpr:
+		walk(v, n.X)
+	case *StmtExpr:
+		for _, d := range n.Decls {
+			Walk(v, d)
+		}
+		walk(v, n.Body)
+	case *CondExpr:
+		walk(v, n.Cond)
+		walk(v, n.X)
+		walk(v, n.Y)
+	case *SizeofExpr:
+		walk(v, n.X)
+	case *StarExpr:
+		walk(v, n.X)
+	case *IndexExpr:
+		walk(v, n.X)
+		walk(v, n.Index)
+	case *SelectorExpr:
+		walk(v, n.X)
+		walk(v, n.Sel)
+	case *CallExpr:
+		walk(v, n.Fun)
+		for _, a := range n.Args {
+			walk(v, a)
+		}
+	case *CastExpr:
+		walk(v, n.Type)
+		walk(v, n.X)
+	case *CompositeLit:
+		for _, e := range n.Elts {
+			walk(v, e)
+		}
+	case *StringLit:
+		for _, l := range n.Lits {
+			Walk(v, l)
+		}
+	case StringLit:
+		for _, l := range n.Lits {
+			Walk(v, l)
+		}
+
+	case *BlockStmt:
+		for _, s := range n.Stmt {
+			walk(v, s)
+		}
+	case *ForStmt:
+		walk(v, n.Init)
+		walk(v, n.Cond)
+		walk(v, n.Post)
+		walk(v, n.Body)
+	case *GotoStmt:
+		if n.X != nil {
+			walk(v, n.X)
+		} else {
+			walk(v, n.Label)
+		}
+	case *WhileStmt:
+		walk(v, n.Cond)
+		walk(v, n.Body)
+	case *IfStmt:
+		walk(v, n.Cond)
+		walk(v, n.Body)
+		walk(v, n.Else)
+	case *DoStmt:
+		walk(v, n.Body)
+		walk(v, n.Cond)
+	case *CaseClause:
+		walk(v, n.Value)
+		walk(v, n.High)
+		for _, s := range n.Body {
+			walk(v, s)
+		}
+	case *LabeledStmt:
+		walk(v, n.Label)
+		walk(v, n.Stmt)
+	case *ReturnStmt:
+		walk(v, n.X)
+	case *SwitchStmt:
+		walk(v, n.Tag)
+		walk(v, n.Body)
+	case *ExprStmt:
+		walk(v, n.X)
+
+	case *BadDecl, *BadExpr, *BadStmt, *BranchStmt, *EmptyStmt, *Ident, *BasicLit, scan.Token:
+		// no children
+
+	default:
+		panic(fmt.Sprintf("ast.Walk: unexpected node type %T", n))
+	}
+
+	v.Visit(nil)
+}
+
+// walk walks node if it is set, optional children of
+// a node are interfaces that can hold a nil pointer.
+func walk(v Visitor, node Node) {
+	if node == nil || isNil(node) {
+		return
+	}
+	Walk(v, node)
+}
+
+// isNil reports whether node holds a nil pointer.
+func isNil(node Node) bool {
+	switch n := node.(type) {
+	case *Ident:
+		return n == nil
+	case *BlockStmt:
+		return n == nil
+	}
+	return false
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses an AST in depth-first order, it starts by calling
+// f(node) and walks the children of node if f returns true.
+// f is called with nil after the children of a node are visited.
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
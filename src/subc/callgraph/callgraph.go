@@ -0,0 +1,297 @@
+// Package callgraph builds the call graph of type checked programs and
+// estimates the static stack usage of the functions in it.
+//
+// The stack usage of a function is its frame, made of the return address,
+// the saved frame pointer and the local variables, plus the deepest stack
+// of temporaries and call arguments pushed while evaluating its expressions.
+// The estimate follows the stack machine model used by the code generator.
+package callgraph
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/scanner"
+	"text/tabwriter"
+
+	"subc/ast"
+	"subc/types"
+)
+
+// Func is a function in the call graph.
+type Func struct {
+	Name     string
+	Pos      scanner.Position // where the function is defined
+	Defined  bool             // if the body of the function was seen
+	Frame    int64            // stack used by the function itself
+	Calls    []*Func          // functions called directly, sorted by name
+	Indirect bool             // if it calls through function pointers
+
+	calls map[*Func]bool
+}
+
+// Graph is the call graph of one or more translation units.
+type Graph struct {
+	funcs map[string]*Func
+}
+
+// New creates an empty call graph.
+func New() *Graph {
+	return &Graph{funcs: make(map[string]*Func)}
+}
+
+// Funcs returns all the functions of the graph sorted by name.
+func (g *Graph) Funcs() []*Func {
+	var funcs []*Func
+	for _, f := range g.funcs {
+		funcs = append(funcs, f)
+	}
+	sort.Slice(funcs, func(i, j int) bool { return funcs[i].Name < funcs[j].Name })
+	return funcs
+}
+
+// Lookup returns the function with the given name, or nil if there is none.
+func (g *Graph) Lookup(name string) *Func {
+	return g.funcs[name]
+}
+
+func (g *Graph) fn(name string) *Func {
+	f := g.funcs[name]
+	if f == nil {
+		f = &Func{Name: name, calls: make(map[*Func]bool)}
+		g.funcs[name] = f
+	}
+	return f
+}
+
+// Add adds the functions of a translation unit to the graph,
+// functions are matched by name across translation units.
+func (g *Graph) Add(prog *ast.Prog, info *types.Info, sizes types.Sizes) {
+	b := &builder{
+		Graph: g,
+		info:  info,
+		sizes: sizes,
+		word:  sizes.Sizeof(types.Typ[types.Int]),
+	}
+	for _, d := range prog.Decls {
+		if d, ok := d.(*ast.FuncDecl); ok && d.Body != nil {
+			b.funcDecl(d)
+		}
+	}
+}
+
+// builder adds a translation unit to the graph.
+type builder struct {
+	*Graph
+	info  *types.Info
+	sizes types.Sizes
+	word  int64
+}
+
+func (b *builder) funcDecl(d *ast.FuncDecl) {
+	f := b.fn(d.Name.Name)
+	f.Pos = d.Name.Pos
+	f.Defined = true
+
+	// the locals of the statement expressions are in the frame too
+	decls := append([]ast.Decl(nil), d.Decls...)
+	ast.Inspect(d.Body, func(n ast.Node) bool {
+		if e, ok := n.(*ast.StmtExpr); ok {
+			decls = append(decls, e.Decls...)
+		}
+		return true
+	})
+	locals := int64(0)
+	for _, d := range decls {
+		d, ok := d.(*ast.VarDecl)
+		if !ok {
+			continue
+		}
+		v, ok := b.info.Defs[d.Name].(*types.Var)
+		if !ok || v.Storage() != types.Auto {
+			continue
+		}
+		size := b.sizes.Sizeof(v.Type())
+		locals += (size + b.word - 1) / b.word * b.word
+	}
+
+	f.Frame = 2*b.word + locals + b.stmtDepth(d.Body)
+
+	ast.Inspect(d.Body, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		if id, ok := call.Fun.(*ast.Ident); ok {
+			switch b.info.Uses[id].(type) {
+			case *types.Func, *types.Fwrd:
+				f.addCall(b.fn(id.Name))
+				return true
+			}
+		}
+		f.Indirect = true
+		return true
+	})
+}
+
+func (f *Func) addCall(callee *Func) {
+	if f.calls[callee] {
+		return
+	}
+	f.calls[callee] = true
+	f.Calls = append(f.Calls, callee)
+	sort.Slice(f.Calls, func(i, j int) bool { return f.Calls[i].Name < f.Calls[j].Name })
+}
+
+// stmtDepth estimates the stack pushed while evaluating the expressions
+// of the statements in body.
+func (b *builder) stmtDepth(body ast.Node) int64 {
+	temps := int64(0)
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case nil:
+			return false
+		case *ast.BlockStmt, *ast.ForStmt, *ast.GotoStmt, *ast.WhileStmt,
+			*ast.IfStmt, *ast.BranchStmt, *ast.DoStmt, *ast.CaseClause,
+			*ast.EmptyStmt, *ast.LabeledStmt, *ast.ReturnStmt, *ast.SwitchStmt,
+			*ast.ExprStmt, *ast.BadStmt:
+			return true
+		default:
+			temps = max(temps, b.depth(n))
+			return false
+		}
+	})
+	return temps
+}
+
+// Temps estimates the deepest stack of temporaries and call arguments
+// pushed while evaluating the expressions of body, on a target with
+// words of word bytes.
+func Temps(body ast.Node, word int64) int64 {
+	b := &builder{word: word}
+	return b.stmtDepth(body)
+}
+
+// depth estimates the stack pushed while evaluating an expression.
+func (b *builder) depth(n ast.Node) int64 {
+	switch n := n.(type) {
+	case *ast.BinaryExpr:
+		return max(b.depth(n.X), b.word+b.depth(n.Y))
+	case *ast.CallExpr:
+		var d, pushed int64
+		for i := len(n.Args) - 1; i >= 0; i-- {
+			d = max(d, pushed+b.depth(n.Args[i]))
+			pushed += b.word
+		}
+		return max(d, pushed+b.depth(n.Fun))
+	case *ast.CondExpr:
+		return max(b.depth(n.Cond), b.depth(n.X), b.depth(n.Y))
+	case *ast.IndexExpr:
+		return max(b.depth(n.X), b.word+b.depth(n.Index))
+	case *ast.UnaryExpr:
+		return b.depth(n.X)
+	case *ast.ParenExpr:
+		return b.depth(n.X)
+	case *ast.StmtExpr:
+		return b.stmtDepth(n.Body)
+	case *ast.StarExpr:
+		return b.depth(n.X)
+	case *ast.CastExpr:
+		return b.depth(n.X)
+	case *ast.SelectorExpr:
+		return b.depth(n.X)
+	}
+	return 0
+}
+
+// Path is a call chain and the stack it uses.
+type Path struct {
+	Funcs     []*Func
+	Stack     int64
+	Recursive bool // the chain ends in a call back into it
+	Unknown   bool // the chain reaches functions with no known stack usage
+}
+
+func (p Path) String() string {
+	var names []string
+	for _, f := range p.Funcs {
+		names = append(names, f.Name)
+	}
+	return strings.Join(names, " -> ")
+}
+
+// MaxStack returns the call chain from f using the most stack.
+// Recursion and calls to functions that were not defined or through
+// pointers make the stack unbounded, this is flagged in the result
+// with the stack for the chain up to that point.
+func (g *Graph) MaxStack(f *Func) Path {
+	return g.maxStack(f, make(map[*Func]bool))
+}
+
+func (g *Graph) maxStack(f *Func, active map[*Func]bool) Path {
+	p := Path{Funcs: []*Func{f}, Stack: f.Frame, Unknown: !f.Defined || f.Indirect}
+	active[f] = true
+	defer delete(active, f)
+
+	var deepest Path
+	for _, c := range f.Calls {
+		var q Path
+		if active[c] {
+			q = Path{Funcs: []*Func{c}, Recursive: true}
+		} else {
+			q = g.maxStack(c, active)
+		}
+		p.Recursive = p.Recursive || q.Recursive
+		p.Unknown = p.Unknown || q.Unknown
+		if deepest.Funcs == nil || q.Stack > deepest.Stack {
+			deepest = q
+		}
+	}
+	p.Funcs = append(p.Funcs, deepest.Funcs...)
+	p.Stack += deepest.Stack
+	return p
+}
+
+// Report writes the stack usage and call graph of every defined function.
+func (g *Graph) Report(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	fmt.Fprintln(tw, "function\tframe\tmax\tdeepest path")
+	for _, f := range g.Funcs() {
+		if !f.Defined {
+			continue
+		}
+		p := g.MaxStack(f)
+		stack := fmt.Sprint(p.Stack)
+		if p.Recursive || p.Unknown {
+			stack += "+"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", f.Name, f.Frame, stack, p)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w)
+	for _, f := range g.Funcs() {
+		if !f.Defined {
+			continue
+		}
+		var notes []string
+		for _, c := range f.Calls {
+			name := c.Name
+			if !c.Defined {
+				name += " (undefined)"
+			}
+			notes = append(notes, name)
+		}
+		if f.Indirect {
+			notes = append(notes, "(through pointers)")
+		}
+		_, err := fmt.Fprintf(w, "%s: %s\n", f.Name, strings.Join(notes, ", "))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+package arch
+
+// Registers describes how the code of a target uses its registers, the
+// part of its calling convention that the sizes of the types don't give.
+// The arguments are words pushed from the last to the first on all the
+// targets, and the first one is two words above the frame pointer, past
+// the saved frame pointer and the return address.
+type Registers struct {
+	Acc     string   // accumulator, it has the result and the function pointer called
+	Frame   string   // frame pointer, the arguments are above it and the locals below
+	Stack   string   // stack pointer
+	Scratch []string // other registers the code uses, which calls don't preserve
+	Vars    []string // registers of the register variables, which the callee saves
+}
@@ -0,0 +1,123 @@
+package amd64
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"subc/compile/arch"
+)
+
+// folding describes the instructions that take an operand from memory,
+// for folding the loads of the operands into them.
+var folding = &arch.Folding{Fold: fold}
+
+// commutative are the operators whose operands commute, which can
+// take their source operand from memory or as an immediate.
+var commutative = map[string]bool{
+	"addq":  true,
+	"andq":  true,
+	"orq":   true,
+	"xorq":  true,
+	"imulq": true,
+}
+
+// fold folds the sequence of a binary operator whose right operand
+// is loaded with one instruction, after the left one is computed:
+//
+//	pushq	%rax
+//	movq	N, %rax
+//	popq	%rcx
+//	addq	%rcx, %rax
+//
+// into an addq N, %rax. A subq has the operands swapped with an
+// xchgq %rax, %rcx before it, which goes too. The operand folded is
+// either in memory or an immediate, the code that follows must not
+// use the value left in %rcx.
+func fold(code []arch.Inst) ([]arch.Inst, int) {
+	if len(code) < 4 || code[0] != (arch.Inst{Op: "pushq", Operands: "%rax"}) || code[1].Op != "movq" || code[2] != (arch.Inst{Op: "popq", Operands: "%rcx"}) {
+		return nil, 0
+	}
+	n, ok := loadOf(code[1])
+	if !ok || !foldable(n) {
+		return nil, 0
+	}
+
+	op := code[3].Op
+	switch {
+	case commutative[op] && code[3].Operands == "%rcx, %rax":
+		if dead("rcx", code[4:]) {
+			return []arch.Inst{{Op: op, Operands: n + ", %rax"}}, 4
+		}
+	case code[3] == (arch.Inst{Op: "xchgq", Operands: "%rax, %rcx"}) && len(code) >= 5 && code[4] == (arch.Inst{Op: "subq", Operands: "%rcx, %rax"}):
+		if dead("rcx", code[5:]) {
+			return []arch.Inst{{Op: "subq", Operands: n + ", %rax"}}, 5
+		}
+	}
+	return nil, 0
+}
+
+// loadOf returns the source of a movq into %rax.
+func loadOf(x arch.Inst) (string, bool) {
+	args := splitOperands(x.Operands)
+	if len(args) != 2 || strings.TrimSpace(args[1]) != "%rax" {
+		return "", false
+	}
+	return strings.TrimSpace(args[0]), true
+}
+
+// foldable reports whether the operand s can be the source operand of
+// an operator: an immediate of 32 bits, or memory at an offset from
+// registers other than %rsp, which moves with the push.
+func foldable(s string) bool {
+	switch {
+	case strings.HasPrefix(s, "$"):
+		n, err := strconv.ParseInt(s[1:], 0, 64)
+		return err == nil && math.MinInt32 <= n && n <= math.MaxInt32
+	case strings.HasSuffix(s, ")"):
+		x, ok := schedOperandOf(s, 8)
+		return ok && !uses(s, "rsp") && len(x.regs) > 0
+	}
+	return false
+}
+
+// uses reports whether the operand s uses the register reg.
+func uses(s, reg string) bool {
+	x, ok := schedOperandOf(s, 8)
+	if !ok {
+		return true
+	}
+	if x.reg == reg {
+		return true
+	}
+	for _, r := range x.regs {
+		if r == reg {
+			return true
+		}
+	}
+	return false
+}
+
+// dead reports whether code writes the register reg before it reads
+// it. The calls and the returns leave it dead, as the callee doesn't
+// preserve it, and the code is assumed to read it after a branch or
+// the end of code.
+func dead(reg string, code []arch.Inst) bool {
+	for _, x := range code {
+		e, ok := effects(x.Op, x.Operands)
+		if !ok {
+			return x.Op == "call" || x.Op == "ret"
+		}
+		for _, r := range e.Reads {
+			if r == reg {
+				return false
+			}
+		}
+		for _, r := range e.Writes {
+			if r == reg {
+				return true
+			}
+		}
+	}
+	return false
+}
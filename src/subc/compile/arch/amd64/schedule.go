@@ -0,0 +1,294 @@
+package amd64
+
+import (
+	"strconv"
+	"strings"
+
+	"subc/compile/arch"
+)
+
+// scheduling describes the instructions of the code, for reordering them.
+var scheduling = &arch.Scheduling{Effects: effects}
+
+// Latencies of the instructions, the cycles before their result can be used.
+const (
+	loadLatency = 4
+	mulLatency  = 3
+	divLatency  = 20
+)
+
+// registers are the names of the registers, and the register that
+// they are part of.
+var registers = map[string]string{
+	"rax": "rax", "eax": "rax", "ax": "rax", "al": "rax",
+	"rcx": "rcx", "ecx": "rcx", "cx": "rcx", "cl": "rcx",
+	"rdx": "rdx", "edx": "rdx", "dx": "rdx", "dl": "rdx",
+	"rbx": "rbx", "ebx": "rbx", "bx": "rbx", "bl": "rbx",
+	"rsi": "rsi", "esi": "rsi", "si": "rsi", "sil": "rsi",
+	"rdi": "rdi", "edi": "rdi", "di": "rdi", "dil": "rdi",
+	"rbp": "rbp", "rsp": "rsp",
+	"r8": "r8", "r9": "r9", "r10": "r10", "r11": "r11",
+	"r12": "r12", "r13": "r13", "r14": "r14", "r15": "r15",
+}
+
+// schedOperand is an operand of an instruction, as it is scheduled.
+type schedOperand struct {
+	reg     string // the register, if it is one
+	partial bool   // the register is written in part, the rest of it is kept
+	mem     bool   // the operand is in memory
+	regs    []string
+	access  arch.Access
+}
+
+// effects returns what an instruction reads and writes. The instructions
+// that change %rsp or %rbp, other than the pushes and the pops, are not
+// moved: a variable stored before the stack makes room for it would be
+// below the stack, where a signal handler can overwrite it.
+func effects(op, operands string) (arch.Effects, bool) {
+	e := arch.Effects{Latency: 1}
+	args, ok := schedOperands(op, operands)
+	if !ok {
+		return e, false
+	}
+
+	read := func(x schedOperand) {
+		if x.reg != "" {
+			e.Reads = append(e.Reads, x.reg)
+		}
+		e.Reads = append(e.Reads, x.regs...)
+		if x.mem {
+			e.Mem = append(e.Mem, x.access)
+			e.Latency = loadLatency
+		}
+	}
+	write := func(x schedOperand) {
+		if x.reg != "" {
+			if x.partial {
+				e.Reads = append(e.Reads, x.reg)
+			}
+			e.Writes = append(e.Writes, x.reg)
+		}
+		e.Reads = append(e.Reads, x.regs...)
+		if x.mem {
+			a := x.access
+			a.Write = true
+			e.Mem = append(e.Mem, a)
+		}
+	}
+	nargs := func(n int) bool { return len(args) == n }
+
+	switch op {
+	case "movq", "movb", "movzbq", "movzwq", "movsbq", "movswq":
+		if !nargs(2) {
+			return e, false
+		}
+		read(args[0])
+		write(args[1])
+	case "leaq":
+		if !nargs(2) || !args[0].mem {
+			return e, false
+		}
+		e.Reads = append(e.Reads, args[0].regs...)
+		write(args[1])
+	case "addq", "subq", "andq", "orq", "xorq", "imulq", "shlq", "sarq", "shrq", "sbbq":
+		if !nargs(2) {
+			return e, false
+		}
+		read(args[0])
+		read(args[1])
+		write(args[1])
+		if op == "sbbq" {
+			e.Reads = append(e.Reads, "flags")
+		}
+		if op == "imulq" {
+			e.Latency = mulLatency
+		}
+		e.Writes = append(e.Writes, "flags")
+	case "cmpq":
+		if !nargs(2) {
+			return e, false
+		}
+		read(args[0])
+		read(args[1])
+		e.Writes = append(e.Writes, "flags")
+	case "incq", "decq", "negq", "notq":
+		if !nargs(1) {
+			return e, false
+		}
+		read(args[0])
+		write(args[0])
+		e.Writes = append(e.Writes, "flags")
+	case "xchgq":
+		if !nargs(2) {
+			return e, false
+		}
+		read(args[0])
+		read(args[1])
+		write(args[0])
+		write(args[1])
+	case "cqo":
+		if !nargs(0) {
+			return e, false
+		}
+		e.Reads = append(e.Reads, "rax")
+		e.Writes = append(e.Writes, "rdx")
+	case "mulq", "divq", "idivq":
+		if !nargs(1) {
+			return e, false
+		}
+		read(args[0])
+		e.Reads = append(e.Reads, "rax")
+		if op != "mulq" {
+			e.Reads = append(e.Reads, "rdx")
+		}
+		e.Writes = append(e.Writes, "rax", "rdx", "flags")
+		e.Latency = mulLatency
+		if op != "mulq" {
+			e.Latency = divLatency
+		}
+	case "pushq", "popq":
+		if !nargs(1) {
+			return e, false
+		}
+		if op == "pushq" {
+			read(args[0])
+		} else {
+			write(args[0])
+		}
+	default:
+		return e, false
+	}
+
+	for _, r := range e.Writes {
+		if r == "rsp" || r == "rbp" {
+			return e, false
+		}
+	}
+
+	switch op {
+	case "pushq":
+		e.Mem = append(e.Mem, arch.Access{Area: arch.StackArea, Size: 8, Write: true})
+		e.Reads = append(e.Reads, "rsp")
+		e.Writes = append(e.Writes, "rsp")
+	case "popq":
+		e.Mem = append(e.Mem, arch.Access{Area: arch.StackArea, Size: 8})
+		e.Reads = append(e.Reads, "rsp")
+		e.Writes = append(e.Writes, "rsp")
+		e.Latency = loadLatency
+	}
+	return e, true
+}
+
+// schedOperands parses the operands of an instruction, ok is false if
+// one of them isn't understood.
+func schedOperands(op, operands string) (args []schedOperand, ok bool) {
+	if operands == "" {
+		return nil, true
+	}
+	for i, s := range splitOperands(operands) {
+		x, ok := schedOperandOf(strings.TrimSpace(s), memSize(op, i))
+		if !ok {
+			return nil, false
+		}
+		args = append(args, x)
+	}
+	return args, true
+}
+
+// splitOperands splits operands at the commas that aren't in parentheses.
+func splitOperands(operands string) []string {
+	var args []string
+	depth, start := 0, 0
+	for i, c := range operands {
+		switch {
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			args = append(args, operands[start:i])
+			start = i + 1
+		}
+	}
+	return append(args, operands[start:])
+}
+
+// schedOperandOf parses operand s that accesses size bytes of memory.
+func schedOperandOf(s string, size int) (x schedOperand, ok bool) {
+	switch {
+	case s == "":
+		return x, false
+	case s[0] == '$':
+		return x, true
+	case s[0] == '%':
+		x.reg, ok = registers[s[1:]]
+		x.partial = x.reg != s[1:]
+		return x, ok
+	case s[0] == '*':
+		return x, false
+	}
+
+	x.mem = true
+	x.access = arch.Access{Area: arch.OtherArea, Size: size}
+	i := strings.IndexByte(s, '(')
+	if i < 0 {
+		// a global
+		return x, true
+	}
+	if !strings.HasSuffix(s, ")") {
+		return x, false
+	}
+	disp := s[:i]
+	parts := strings.Split(s[i+1:len(s)-1], ",")
+	if len(parts) > 3 {
+		return x, false
+	}
+	for k, p := range parts {
+		p = strings.TrimSpace(p)
+		if k == 2 || p == "" {
+			continue
+		}
+		if p[0] != '%' {
+			return x, false
+		}
+		r, found := registers[p[1:]]
+		if !found {
+			return x, false
+		}
+		x.regs = append(x.regs, r)
+	}
+
+	base := ""
+	if len(x.regs) > 0 && strings.TrimSpace(parts[0]) != "" {
+		base = x.regs[0]
+	}
+	off, err := strconv.Atoi(disp)
+	if disp == "" {
+		off, err = 0, nil
+	}
+	switch {
+	case base == "rbp" && len(x.regs) == 1 && err == nil:
+		x.access.Area = arch.FrameArea
+		x.access.Off = off
+	case base == "rsp":
+		x.access.Area = arch.StackArea
+	}
+	return x, true
+}
+
+// memSize returns how many bytes the operand i of op accesses in memory.
+func memSize(op string, i int) int {
+	switch {
+	case op == "movb":
+		return 1
+	case op == "movzbq" || op == "movsbq":
+		if i == 0 {
+			return 1
+		}
+	case op == "movzwq" || op == "movswq":
+		if i == 0 {
+			return 2
+		}
+	}
+	return 8
+}
@@ -1,5 +1,13 @@
 // Package arm6 contains a code emitter to
 // generate code for the ARMv6 architecture.
+//
+// The code uses the calling convention of SubC, not the AAPCS. The
+// arguments are pushed as 4 byte words from the last to the first, so
+// the callee finds the first one at [r11, #8], and the caller pops them
+// after the call. No arguments are passed in registers. A function
+// pointer is called through r0 and the result is returned in r0. The
+// callee only preserves r11 and sp, and the stack is only kept aligned
+// to 4 bytes.
 package arm6
 
 import (
@@ -7,6 +15,7 @@ import (
 	"io"
 
 	"subc/compile/arch"
+	"subc/obj"
 	"subc/types"
 )
 
@@ -14,50 +23,91 @@ type Emitter struct {
 	*arch.Emitter
 }
 
+// NewEmitter returns an emitter that writes assembly text to w.
 func NewEmitter(w io.Writer) *arch.Emitter {
+	return New(arch.NewTextSink(w))
+}
+
+// New returns an emitter that sends the code it generates to out.
+func New(out arch.Sink) *arch.Emitter {
 	c := &Emitter{}
-	c.Emitter = &arch.Emitter{W: w, B: c, Sizes: &types.StdSizes{4, 4}}
+	c.Emitter = &arch.Emitter{Out: out, B: c, ByteOrder: obj.ByteOrder("arm6"), Branches: branches, Sizes: &types.StdSizes{WordSize: 4, MaxAlign: 4}, StdTypes: types.StdTypes{Size: types.Int, Ptrdiff: types.Int, Wchar: types.Int}, Registers: regUsage}
 	return c.Emitter
 }
 
+// regUsage describes how the code uses the registers.
+var regUsage = &arch.Registers{Acc: "r0", Frame: "r11", Stack: "sp", Scratch: []string{"r1", "r2", "r3", "lr"}}
+
+// branches are the branch instructions, for improving them.
+var branches = &arch.Branches{
+	Jump: "b",
+	Inverse: map[string]string{
+		"beq": "bne", "bne": "beq",
+		"blt": "bge", "bge": "blt",
+		"bgt": "ble", "ble": "bgt",
+		"blo": "bhs", "bhs": "blo",
+		"bhi": "bls", "bls": "bhi",
+	},
+}
+
+// operand describes how an operand of some width is accessed.
+type operand struct {
+	suffix string // load and store instruction suffix
+	def    string // data directive
+}
+
+var operands = map[arch.Width]operand{
+	arch.U8:   {"b", ".byte"},
+	arch.U32:  {"", ".long"},
+	arch.S32:  {"", ".long"},
+	arch.Word: {"", ".long"},
+	arch.Ptr:  {"", ".long"},
+}
+
+func opnd(w arch.Width) operand {
+	o, ok := operands[w]
+	if !ok {
+		panic(fmt.Sprintf("unsupported operand width: %v", w))
+	}
+	return o
+}
+
 func (c *Emitter) Data()           { c.Gen(".data") }
 func (c *Emitter) Text()           { c.Gen(".text") }
 func (c *Emitter) Prelude()        { c.Gen(".syntax unified") }
 func (c *Emitter) Postlude()       {}
-func (c *Emitter) Public(s string) { c.Raw(".globl\t" + s + "\n") }
+func (c *Emitter) Public(s string) { c.Sgen("%s\t%s", ".globl", s) }
+
+// Ident records the quoted string s in the .comment section of the object.
+func (c *Emitter) Ident(s string) { c.Sgen("%s\t%s", ".ident", s) }
+
+// Section switches to the section of the text s, the linker
+// puts .text.hot and .text.unlikely apart from the rest of .text.
+func (c *Emitter) Section(s arch.Section) {
+	switch s {
+	case arch.HotText:
+		c.Gen(".section\t.text.hot,\"ax\",%progbits")
+	case arch.ColdText:
+		c.Gen(".section\t.text.unlikely,\"ax\",%progbits")
+	default:
+		c.Text()
+	}
+}
 
+// Lit2 loads the literal v into the register numbered aux.
 func (c *Emitter) Lit2(v, aux int) {
-	var l, skip int
-	if 0 <= v && v <= 127 {
-		if aux == 2 {
-			c.Ngen("%s\tr2, #%d", "mov", v)
-		} else if aux == 1 {
-			c.Ngen("%s\tr1, #%d", "mov", v)
-		} else {
-			c.Ngen("%s\tr0, #%d", "mov", v)
-		}
-	} else if -128 <= v && v < 0 {
-		v = -v - 1
-		if aux == 2 {
-			c.Ngen("%s\tr2, #%d", "mvn", v)
-		} else if aux == 1 {
-			c.Ngen("%s\tr1, #%d", "mvn", v)
-		} else {
-			c.Ngen("%s\tr0, #%d", "mvn", v)
-		}
-	} else {
-		l = c.Label()
-		if aux == 2 {
-			c.Lgen("%s\tr2, %c%d", "ldr", l)
-		} else if aux == 1 {
-			c.Lgen("%s\tr1, %c%d", "ldr", l)
-		} else {
-			c.Lgen("%s\tr0, %c%d", "ldr", l)
-		}
-		skip = c.Label()
-		c.Lgen("%s\t%c%d", "b", skip)
+	switch {
+	case 0 <= v && v <= 127:
+		c.Ngen("%s\tr%d, #%d", "mov", aux, v)
+	case -128 <= v && v < 0:
+		c.Ngen("%s\tr%d, #%d", "mvn", aux, -v-1)
+	default:
+		l := c.Label()
+		c.Ngen("ldr\tr%d, %s", aux, c.Labname(l))
+		skip := c.Label()
+		c.Lgen("%s\t%s", "b", skip)
 		c.Lab(l)
-		c.Defw(v)
+		c.Def(arch.Word, v)
 		c.Lab(skip)
 	}
 }
@@ -79,15 +129,15 @@ func (c *Emitter) LocalAddr(n int, aux bool) {
 	}
 }
 
-func (c *Emitter) StatAddr(n int, aux bool) {
+func (c *Emitter) StatAddr(n arch.Label, aux bool) {
 	l := c.Label()
 	if aux {
-		c.Lgen("%s\tr1, %c%d", "ldr", l)
+		c.Lgen("%s\tr1, %s", "ldr", l)
 	} else {
-		c.Lgen("%s\tr0, %c%d", "ldr", l)
+		c.Lgen("%s\tr0, %s", "ldr", l)
 	}
 	skip := c.Label()
-	c.Lgen("%s\t%c%d", "b", skip)
+	c.Lgen("%s\t%s", "b", skip)
 	c.Lab(l)
 	c.Defl(n)
 	c.Lab(skip)
@@ -96,12 +146,12 @@ func (c *Emitter) StatAddr(n int, aux bool) {
 func (c *Emitter) GlobalAddr(s string, aux bool) {
 	l := c.Label()
 	if aux {
-		c.Lgen("%s\tr1, %c%d", "ldr", l)
+		c.Lgen("%s\tr1, %s", "ldr", l)
 	} else {
-		c.Lgen("%s\tr0, %c%d", "ldr", l)
+		c.Lgen("%s\tr0, %s", "ldr", l)
 	}
 	skip := c.Label()
-	c.Lgen("%s\t%c%d", "b", skip)
+	c.Lgen("%s\t%s", "b", skip)
 	c.Lab(l)
 	c.Sgen("%s\t%s", ".long", s)
 	c.Lab(skip)
@@ -110,24 +160,22 @@ func (c *Emitter) GlobalAddr(s string, aux bool) {
 func (c *Emitter) Ind2b() { c.Gen("ldrb\tr1, [r1]") }
 func (c *Emitter) Ind2w() { c.Gen("ldr\tr1, [r1]") }
 
-func (c *Emitter) Storb() { c.Gen("strb\tr0, [r1]") }
-func (c *Emitter) Storw() { c.Gen("str\tr0, [r1]") }
-
 func (c *Emitter) Synth() {}
 
 func (c *Emitter) Load2() bool {
 	n := c.Q.Value
+	l := c.Q.Label
 	s := c.Gsym(c.Q.Name)
 
 	switch c.Q.Type {
 	case arch.AddrAuto:
 		c.LocalAddr(n, true)
 	case arch.AddrStatic:
-		c.StatAddr(n, true)
+		c.StatAddr(l, true)
 	case arch.AddrGlobal:
 		c.GlobalAddr(s, true)
 	case arch.AddrLabel:
-		c.StatAddr(n, true)
+		c.StatAddr(l, true)
 	case arch.Literal:
 		c.Lit2(n, 1)
 	case arch.AutoByte:
@@ -137,10 +185,10 @@ func (c *Emitter) Load2() bool {
 		c.LocalAddr(n, true)
 		c.Ind2w()
 	case arch.StaticByte:
-		c.StatAddr(n, true)
+		c.StatAddr(l, true)
 		c.Ind2b()
 	case arch.StaticWord:
-		c.StatAddr(n, true)
+		c.StatAddr(l, true)
 		c.Ind2w()
 	case arch.GlobalByte:
 		c.GlobalAddr(s, true)
@@ -162,42 +210,48 @@ func (c *Emitter) Lit(v int) { c.Lit2(v, 0) }
 func (c *Emitter) Clear()    {}
 func (c *Emitter) Clear2()   {}
 
-func (c *Emitter) Ldgb(s string) {
+func (c *Emitter) Ldg(w arch.Width, s string) {
 	c.GlobalAddr(s, false)
-	c.Indb()
+	c.Ind(w)
 }
 
-func (c *Emitter) Ldgw(s string) {
-	c.GlobalAddr(s, false)
-	c.Indw()
+func (c *Emitter) Ldl(w arch.Width, n int) {
+	c.LocalAddr(n, false)
+	c.Ind(w)
 }
 
-func (c *Emitter) Ldlb(n int) {
-	c.LocalAddr(n, false)
-	c.Indb()
+func (c *Emitter) Lds(w arch.Width, l arch.Label) {
+	c.StatAddr(l, false)
+	c.Ind(w)
 }
 
-func (c *Emitter) Ldlw(n int) {
-	c.LocalAddr(n, false)
-	c.Indw()
+func (c *Emitter) Ind(w arch.Width) { c.Ngen("ldr%s\tr0, [r0]", opnd(w).suffix) }
+
+// Index adds the index in r0 scaled by scale to the address in r1,
+// shifting the index in the add.
+func (c *Emitter) Index(scale int) {
+	c.Ngen("add\tr0, r1, r0, lsl #%d", shift(scale))
 }
 
-func (c *Emitter) Ldsb(n int) {
-	c.StatAddr(n, false)
-	c.Indb()
+// IndIndex loads from the address in r1 plus the index in r0 scaled by
+// scale, ldr and ldrb shift the index register themselves.
+func (c *Emitter) IndIndex(w arch.Width, scale int) {
+	c.Ngen("ldr%s\tr0, [r1, r0, lsl #%d]", opnd(w).suffix, shift(scale))
 }
 
-func (c *Emitter) Ldsw(n int) {
-	c.StatAddr(n, false)
-	c.Indw()
+// shift returns the shift that multiplies by a power of 2.
+func shift(scale int) int {
+	n := 0
+	for ; scale > 1; scale >>= 1 {
+		n++
+	}
+	return n
 }
 
-func (c *Emitter) Ldla(n int)    { c.LocalAddr(n, false) }
-func (c *Emitter) Ldsa(n int)    { c.StatAddr(n, false) }
-func (c *Emitter) Ldga(s string) { c.GlobalAddr(s, false) }
-func (c *Emitter) Indb()         { c.Gen("ldrb\tr0, [r0]") }
-func (c *Emitter) Indw()         { c.Gen("ldr\tr0, [r0]") }
-func (c *Emitter) Ldlab(id int)  { c.StatAddr(id, false) }
+func (c *Emitter) Ldla(n int)          { c.LocalAddr(n, false) }
+func (c *Emitter) Ldsa(n arch.Label)   { c.StatAddr(n, false) }
+func (c *Emitter) Ldga(s string)       { c.GlobalAddr(s, false) }
+func (c *Emitter) Ldlab(id arch.Label) { c.StatAddr(id, false) }
 
 func (c *Emitter) Push() { c.Gen("push\t{r0}") }
 
@@ -220,6 +274,10 @@ func (c *Emitter) Xor() { c.Load2(); c.Gen("eor\tr0, r0, r1") }
 func (c *Emitter) Add() { c.Gen("add\tr0, r0, r1") }
 func (c *Emitter) Mul() { c.Gen("mul\tr0, r0, r1") }
 func (c *Emitter) Sub() { c.Gen("sub\tr0, r0, r1") }
+
+// Div and Mod call the runtime, as there is no divide instruction.
+// sdiv divides the magnitudes and negates the quotient when the signs
+// differ, srem gives the remainder the sign of the dividend.
 func (c *Emitter) Div() { c.Gen("bl\tsdiv") }
 func (c *Emitter) Mod() { c.Gen("bl\tsrem") }
 func (c *Emitter) Shl() { c.Gen("lsl\tr0, r0, r1") }
@@ -249,7 +307,7 @@ func (c *Emitter) Ugt() { c.Cmp("movhi") }
 func (c *Emitter) Ule() { c.Cmp("movls") }
 func (c *Emitter) Uge() { c.Cmp("movhs") }
 
-func (c *Emitter) BrCond(i string, n int) {
+func (c *Emitter) BrCond(i string, n arch.Label) {
 	lab := c.Label()
 	if c.Q.Type == arch.Empty {
 		c.Pop2()
@@ -258,25 +316,42 @@ func (c *Emitter) BrCond(i string, n int) {
 		c.Load2()
 		c.Gen("cmp\tr0, r1")
 	}
-	c.Lgen("%s\t%c%d", i, lab)
-	c.Lgen("%s\t%c%d", "b", n)
+	c.Lgen("%s\t%s", i, lab)
+	c.Lgen("%s\t%s", "b", n)
 	c.Lab(lab)
 }
 
-func (c *Emitter) BrEq(n int)  { c.BrCond("beq", n) }
-func (c *Emitter) BrNe(n int)  { c.BrCond("bne", n) }
-func (c *Emitter) BrLt(n int)  { c.BrCond("blt", n) }
-func (c *Emitter) BrGt(n int)  { c.BrCond("bgt", n) }
-func (c *Emitter) BrLe(n int)  { c.BrCond("ble", n) }
-func (c *Emitter) BrGe(n int)  { c.BrCond("bge", n) }
-func (c *Emitter) BrUlt(n int) { c.BrCond("blo", n) }
-func (c *Emitter) BrUgt(n int) { c.BrCond("bhi", n) }
-func (c *Emitter) BrUle(n int) { c.BrCond("bls", n) }
-func (c *Emitter) BrUge(n int) { c.BrCond("bhs", n) }
+func (c *Emitter) BrEq(n arch.Label)  { c.BrCond("beq", n) }
+func (c *Emitter) BrNe(n arch.Label)  { c.BrCond("bne", n) }
+func (c *Emitter) BrLt(n arch.Label)  { c.BrCond("blt", n) }
+func (c *Emitter) BrGt(n arch.Label)  { c.BrCond("bgt", n) }
+func (c *Emitter) BrLe(n arch.Label)  { c.BrCond("ble", n) }
+func (c *Emitter) BrGe(n arch.Label)  { c.BrCond("bge", n) }
+func (c *Emitter) BrUlt(n arch.Label) { c.BrCond("blo", n) }
+func (c *Emitter) BrUgt(n arch.Label) { c.BrCond("bhi", n) }
+func (c *Emitter) BrUle(n arch.Label) { c.BrCond("bls", n) }
+func (c *Emitter) BrUge(n arch.Label) { c.BrCond("bhs", n) }
 
 func (c *Emitter) neg() { c.Gen("neg\tr0, r0") }
 func (c *Emitter) not() { c.Gen("mvn\tr0, r0") }
 
+// Ext extends the value of width w in the accumulator to a word.
+func (c *Emitter) Ext(w arch.Width) {
+	switch w {
+	case arch.U8:
+		c.Gen("uxtb\tr0, r0")
+	case arch.S8:
+		c.Gen("sxtb\tr0, r0")
+	case arch.U16:
+		c.Gen("uxth\tr0, r0")
+	case arch.S16:
+		c.Gen("sxth\tr0, r0")
+	case arch.U32, arch.S32, arch.Word, arch.Ptr:
+	default:
+		panic(fmt.Sprintf("unsupported operand width: %v", w))
+	}
+}
+
 func (c *Emitter) lognot() {
 	c.Gen("cmp\tr0, #0")
 	c.Gen("mov\tr0, #0")
@@ -310,267 +385,138 @@ func (c *Emitter) bool() {
 
 func (c *Emitter) Ldinc() { c.Gen("mov\tr2, r0") }
 
-func (c *Emitter) Inc1pi(v int) {
-	c.Lit2(v, 1)
-	c.Gen("ldr\tr3, [r0]")
-	c.Gen("add\tr3, r3, r1")
-	c.Gen("str\tr3, [r0]")
-}
-
-func (c *Emitter) Dec1pi(v int) {
-	c.Lit2(v, 1)
-	c.Gen("ldr\tr3, [r0]")
-	c.Gen("sub\tr3, r3, r1")
-	c.Gen("str\tr3, [r0]")
-}
-
-func (c *Emitter) Inc2pi(v int) {
-	c.Lit2(v, 1)
-	c.Gen("ldr\tr3, [r2]")
-	c.Gen("add\tr3, r3, r1")
-	c.Gen("str\tr3, [r2]")
-}
-
-func (c *Emitter) Dec2pi(v int) {
-	c.Lit2(v, 1)
-	c.Gen("ldr\tr3, [r2]")
-	c.Gen("sub\tr3, r3, r1")
-	c.Gen("str\tr3, [r2]")
-}
-
-func (c *Emitter) Incpl(v, a int) {
-	c.Lit2(v, 2)
-	c.LocalAddr(a, true)
-	c.Gen("ldr\tr3, [r1]")
-	c.Gen("add\tr3, r3, r2")
-	c.Gen("str\tr3, [r1]")
-}
-
-func (c *Emitter) Decpl(v, a int) {
-	c.Lit2(v, 2)
-	c.LocalAddr(a, true)
-	c.Gen("ldr\tr3, [r1]")
-	c.Gen("sub\tr3, r3, r2")
-	c.Gen("str\tr3, [r1]")
-}
-
-func (c *Emitter) Incps(v, a int) {
-	c.Lit2(v, 2)
-	c.StatAddr(a, true)
-	c.Gen("ldr\tr3, [r1]")
-	c.Gen("add\tr3, r3, r2")
-	c.Gen("str\tr3, [r1]")
-}
-
-func (c *Emitter) Decps(a, v int) {
-	c.Lit2(v, 2)
-	c.StatAddr(a, true)
-	c.Gen("ldr\tr3, [r1]")
-	c.Gen("sub\tr3, r3, r2")
-	c.Gen("str\tr3, [r1]")
-}
-
-func (c *Emitter) Incpg(s string, v int) {
-	c.Lit2(v, 2)
-	c.GlobalAddr(s, true)
-	c.Gen("ldr\tr3, [r1]")
-	c.Gen("add\tr3, r3, r2")
-	c.Gen("str\tr3, [r1]")
-}
-
-func (c *Emitter) Decpg(s string, v int) {
-	c.Lit2(v, 2)
-	c.GlobalAddr(s, true)
-	c.Gen("ldr\tr3, [r1]")
-	c.Gen("sub\tr3, r3, r2")
-	c.Gen("str\tr3, [r1]")
-}
-
-func (c *Emitter) Inc1iw() {
-	c.Gen("ldr\tr1, [r0]")
-	c.Gen("add\tr1, r1, #1")
-	c.Gen("str\tr1, [r0]")
-}
-
-func (c *Emitter) Dec1iw() {
-	c.Gen("ldr\tr1, [r0]")
-	c.Gen("sub\tr1, r1,#1")
-	c.Gen("str\tr1, [r0]")
-}
-
-func (c *Emitter) Inc2iw() {
-	c.Gen("ldr\tr1, [r2]")
-	c.Gen("add\tr1, r1, #1")
-	c.Gen("str\tr1, [r2]")
-}
-
-func (c *Emitter) Dec2iw() {
-	c.Gen("ldr\tr1, [r2]")
-	c.Gen("sub\tr1, r1, #1")
-	c.Gen("str\tr1, [r2]")
-}
-
-func (c *Emitter) Inclw(a int) {
-	c.LocalAddr(a, true)
-	c.Gen("ldr\tr2, [r1]")
-	c.Gen("add\tr2, r2, #1")
-	c.Gen("str\tr2, [r1]")
-}
-
-func (c *Emitter) Declw(a int) {
-	c.LocalAddr(a, true)
-	c.Gen("ldr\tr2, [r1]")
-	c.Gen("sub\tr2, r2, #1")
-	c.Gen("str\tr2, [r1]")
-}
-
-func (c *Emitter) Incsw(a int) {
-	c.StatAddr(a, true)
-	c.Gen("ldr\tr2, [r1]")
-	c.Gen("add\tr2, r2, #1")
-	c.Gen("str\tr2, [r1]")
-}
-
-func (c *Emitter) Decsw(a int) {
-	c.StatAddr(a, true)
-	c.Gen("ldr\tr2, [r1]")
-	c.Gen("sub\tr2, r2, #1")
-	c.Gen("str\tr2, [r1]")
-}
-
-func (c *Emitter) Incgw(s string) {
-	c.GlobalAddr(s, true)
-	c.Gen("ldr\tr2, [r1]")
-	c.Gen("add\tr2, r2, #1")
-	c.Gen("str\tr2, [r1]")
-}
-
-func (c *Emitter) Decgw(s string) {
-	c.GlobalAddr(s, true)
-	c.Gen("ldr\tr2, [r1]")
-	c.Gen("sub\tr2, r2, #1")
-	c.Gen("str\tr2, [r1]")
-}
-
-func (c *Emitter) Inc1ib() {
-	c.Gen("ldrb\tr1, [r0]")
-	c.Gen("add\tr1, r1, #1")
-	c.Gen("strb\tr1, [r0]")
-}
-
-func (c *Emitter) Dec1ib() {
-	c.Gen("ldrb\tr1, [r0]")
-	c.Gen("sub\tr1, r1, #1")
-	c.Gen("strb\tr1, [r0]")
-}
-
-func (c *Emitter) Inc2ib() {
-	c.Gen("ldrb\tr1, [r2]")
-	c.Gen("add\tr1, r1, #1")
-	c.Gen("strb\tr1, [r2]")
-}
-
-func (c *Emitter) Dec2ib() {
-	c.Gen("ldrb\tr1, [r2]")
-	c.Gen("sub\tr1, r1, #1")
-	c.Gen("strb\tr1, [r2]")
-}
-
-func (c *Emitter) Inclb(a int) {
-	c.LocalAddr(a, true)
-	c.Gen("ldrb\tr2, [r1]")
-	c.Gen("add\tr2, r2, #1")
-	c.Gen("strb\tr2, [r1]")
-}
-
-func (c *Emitter) Declb(a int) {
-	c.LocalAddr(a, true)
-	c.Gen("ldrb\tr2, [r1]")
-	c.Gen("sub\tr2, r2, #1")
-	c.Gen("strb\tr2, [r1]")
-}
-
-func (c *Emitter) Incsb(a int) {
-	c.StatAddr(a, true)
-	c.Gen("ldrb\tr2, [r1]")
-	c.Gen("add\tr2, r2, #1")
-	c.Gen("strb\tr2, [r1]")
-}
-
-func (c *Emitter) Decsb(a int) {
-	c.StatAddr(a, true)
-	c.Gen("ldrb\tr2, [r1]")
-	c.Gen("sub\tr2, r2, #1")
-	c.Gen("strb\tr2, [r1]")
-}
+// Step increments or decrements a variable in place, through the
+// address in the register ptr with the register tmp free to use.
+func (c *Emitter) Step(s arch.Step) {
+	op := "add"
+	if s.Dec {
+		op = "sub"
+	}
 
-func (c *Emitter) Incgb(s string) {
-	c.GlobalAddr(s, true)
-	c.Gen("ldrb\tr2, [r1]")
-	c.Gen("add\tr2, r2, #1")
-	c.Gen("strb\tr2, [r1]")
-}
+	ptr, tmp := "r1", 2
+	switch s.Kind {
+	case arch.StepInd:
+		ptr, tmp = "r0", 1
+		if !s.Pre {
+			ptr = "r2"
+		}
+	case arch.StepLocal:
+		c.LocalAddr(s.Addr, true)
+	case arch.StepStatic:
+		c.StatAddr(s.Label, true)
+	case arch.StepGlobal:
+		c.GlobalAddr(s.Name, true)
+	}
 
-func (c *Emitter) Decgb(s string) {
-	c.GlobalAddr(s, true)
-	c.Gen("ldrb\tr2, [r1]")
-	c.Gen("sub\tr2, r2, #1")
-	c.Gen("strb\tr2, [r1]")
+	if s.Stride == 0 {
+		x := opnd(s.W).suffix
+		c.Ngen("ldr%s\tr%d, [%s]", x, tmp, ptr)
+		c.Ngen("%s\tr%d, r%d, #1", op, tmp, tmp)
+		c.Ngen("str%s\tr%d, [%s]", x, tmp, ptr)
+		return
+	}
+	c.Lit2(s.Stride, tmp)
+	c.Ngen("ldr\tr3, [%s]", ptr)
+	c.Ngen("%s\tr3, r3, r%d", op, tmp)
+	c.Ngen("str\tr3, [%s]", ptr)
 }
 
-func (c *Emitter) Br(how string, n int) {
+func (c *Emitter) Br(how string, n arch.Label) {
 	lab := c.Label()
 	c.Gen("cmp\tr0, #0")
-	c.Lgen("%s\t%c%d", how, lab)
-	c.Lgen("%s\t%c%d", "b", n)
+	c.Lgen("%s\t%s", how, lab)
+	c.Lgen("%s\t%s", "b", n)
 	c.Lab(lab)
 }
 
-func (c *Emitter) BrTrue(n int)  { c.Br("beq", n) }
-func (c *Emitter) BrFalse(n int) { c.Br("bne", n) }
-func (c *Emitter) Jump(n int)    { c.Lgen("%s\t%c%d", "b", n) }
-func (c *Emitter) LdSwtch(n int) { c.StatAddr(n, true) }
-func (c *Emitter) CalSwtch()     { c.Gen("b\tswitch") }
-func (c *Emitter) Case(v, l int) { c.Lgen2(".long\t%d, %c%d", v, l) }
+func (c *Emitter) BrTrue(n arch.Label)      { c.Br("beq", n) }
+func (c *Emitter) BrFalse(n arch.Label)     { c.Br("bne", n) }
+func (c *Emitter) Jump(n arch.Label)        { c.Lgen("%s\t%s", "b", n) }
+func (c *Emitter) Jumpr()                   { c.Gen("bx\tr0") }
+func (c *Emitter) LdSwtch(n arch.Label)     { c.StatAddr(n, true) }
+func (c *Emitter) CalSwtch()                { c.Gen("b\tswitch") }
+func (c *Emitter) Case(v int, l arch.Label) { c.Lgen2(".long\t%d, %s", v, l) }
 
 func (c *Emitter) PopPtr() { c.Gen("pop\t{r2}") }
-func (c *Emitter) Storib() { c.Gen("strb\tr0, [r2]") }
-func (c *Emitter) Storiw() { c.Gen("str\tr0, [r2]") }
 
-func (c *Emitter) Storlb(n int) {
-	c.LocalAddr(n, true)
-	c.Storb()
-}
+func (c *Emitter) store(w arch.Width) { c.Ngen("str%s\tr0, [r1]", opnd(w).suffix) }
+func (c *Emitter) Stori(w arch.Width) { c.Ngen("str%s\tr0, [r2]", opnd(w).suffix) }
 
-func (c *Emitter) Storlw(n int) {
+func (c *Emitter) Storl(w arch.Width, n int) {
 	c.LocalAddr(n, true)
-	c.Storw()
+	c.store(w)
 }
 
-func (c *Emitter) Storsb(n int) {
-	c.StatAddr(n, true)
-	c.Storb()
+func (c *Emitter) Stors(w arch.Width, l arch.Label) {
+	c.StatAddr(l, true)
+	c.store(w)
 }
 
-func (c *Emitter) Storsw(n int) {
-	c.StatAddr(n, true)
-	c.Storw()
+func (c *Emitter) Storg(w arch.Width, s string) {
+	c.GlobalAddr(s, true)
+	c.store(w)
 }
 
-func (c *Emitter) Storgb(s string) {
-	c.GlobalAddr(s, true)
-	c.Storb()
+// unroll is the most loads and stores that Copy and Zero do without a loop.
+const unroll = 8
+
+// blockUnit returns the widest unit that the loads and stores of a block
+// of size bytes aligned to align can move, and its suffix.
+func blockUnit(size, align int) (int, string) {
+	switch {
+	case size%4 == 0 && align%4 == 0:
+		return 4, ""
+	case size%2 == 0 && align%2 == 0:
+		return 2, "h"
+	}
+	return 1, "b"
 }
 
-func (c *Emitter) Storgw(s string) {
-	c.GlobalAddr(s, true)
-	c.Storw()
+// block emits the move of a unit n times, with a loop counting down
+// in r3 when there are many.
+func (c *Emitter) block(n int, move func()) {
+	if n <= unroll {
+		for i := 0; i < n; i++ {
+			move()
+		}
+		return
+	}
+	loop := c.Label()
+	c.Lit2(n, 3)
+	c.Lab(loop)
+	move()
+	c.Gen("subs\tr3, r3, #1")
+	c.Lgen("%s\t%s", "bne", loop)
+}
+
+// Copy copies size bytes from the address in r0 to the address in r2
+// through r1, in the widest units that align allows as the arm can't
+// load what isn't aligned. The address of the copy is left in r0.
+func (c *Emitter) Copy(size, align int) {
+	w, x := blockUnit(size, align)
+	c.block(size/w, func() {
+		c.Ngen("ldr%s\tr1, [r0], #%d", x, w)
+		c.Ngen("str%s\tr1, [r2], #%d", x, w)
+	})
+	c.Lit2(size, 1)
+	c.Gen("sub\tr0, r2, r1")
+}
+
+// Zero clears size bytes at the address in r0 like Copy copies them,
+// and leaves the address in r0.
+func (c *Emitter) Zero(size, align int) {
+	w, x := blockUnit(size, align)
+	c.Gen("mov\tr1, #0")
+	c.Gen("mov\tr2, r0")
+	c.block(size/w, func() {
+		c.Ngen("str%s\tr1, [r2], #%d", x, w)
+	})
 }
 
 func (c *Emitter) Initlw(v, a int) {
 	c.Lit(v)
 	c.LocalAddr(a, true)
-	c.Storw()
+	c.store(arch.Word)
 }
 
 func (c *Emitter) Call(s string) { c.Sgen("%s\t%s", "bl", s) }
@@ -587,11 +533,30 @@ func (c *Emitter) Entry() {
 
 func (c *Emitter) Exit() { c.Gen("pop\t{r11,pc}") }
 
-func (c *Emitter) Defb(v int)           { c.Ngen("%s\t%d", ".byte", v) }
-func (c *Emitter) Defw(v int)           { c.Ngen("%s\t%d", ".long", v) }
-func (c *Emitter) Defp(v int)           { c.Ngen("%s\t%d", ".long", v) }
-func (c *Emitter) Defl(v int)           { c.Lgen("%s\t%c%d", ".long", v) }
-func (c *Emitter) Defc(c_ int)          { c.Ngen("%s\t'%c'", ".byte", c_) }
-func (c *Emitter) Gbss(s string, z int) { c.Ngen(".comm\t%s, %d", s, z) }
-func (c *Emitter) Lbss(s string, z int) { c.Ngen(".lcomm\t%s, %d", s, z) }
-func (c *Emitter) Align()               { c.Gen(".align 2") }
+func (c *Emitter) Def(w arch.Width, v int) { c.Ngen("%s\t%d", opnd(w).def, v) }
+func (c *Emitter) Defa(s string)           { c.Sgen("%s\t%s", ".long", s) }
+func (c *Emitter) Defl(l arch.Label)       { c.Lgen("%s\t%s", ".long", l) }
+func (c *Emitter) Defc(c_ int)             { c.Ngen("%s\t'%c'", ".byte", c_) }
+func (c *Emitter) Align()                  { c.Gen(".align 2") }
+
+// Gbss and Lbss give the alignment of an aligned variable as the third
+// argument of .comm, a local one is declared with .local since .lcomm
+// doesn't take an alignment.
+func (c *Emitter) Gbss(s string, z, align int) {
+	if align > 0 {
+		c.Ngen(".comm\t%s, %d, %d", s, z, align)
+		return
+	}
+	c.Ngen(".comm\t%s, %d", s, z)
+}
+
+func (c *Emitter) Lbss(s string, z, align int) {
+	if align > 0 {
+		c.Ngen(".local\t%s", s)
+		c.Ngen(".comm\t%s, %d, %d", s, z, align)
+		return
+	}
+	c.Ngen(".lcomm\t%s, %d", s, z)
+}
+
+func (c *Emitter) Balign(n int) { c.Ngen(".balign\t%d", n) }
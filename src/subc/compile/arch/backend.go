@@ -1,5 +1,7 @@
 package arch
 
+import "fmt"
+
 // Backend represents an interface a architecture
 // specific code generator must have for the compiler to use
 // for generating code.
@@ -7,86 +9,57 @@ type Backend interface {
 	Add()
 	Align()
 	And()
+	Balign(n int)
 	Bool()
-	BrEq(n int)
-	BrFalse(n int)
-	BrGe(n int)
-	BrGt(n int)
-	BrLe(n int)
-	BrLt(n int)
-	BrNe(n int)
-	BrTrue(n int)
-	BrUge(n int)
-	BrUgt(n int)
-	BrUle(n int)
-	BrUlt(n int)
+	BrEq(l Label)
+	BrFalse(l Label)
+	BrGe(l Label)
+	BrGt(l Label)
+	BrLe(l Label)
+	BrLt(l Label)
+	BrNe(l Label)
+	BrTrue(l Label)
+	BrUge(l Label)
+	BrUgt(l Label)
+	BrUle(l Label)
+	BrUlt(l Label)
 	Call(s string)
 	Calr()
 	CalSwtch()
-	Case(v, l int)
+	Case(v int, l Label)
 	Clear()
 	Clear2()
+	Copy(size, align int)
 	Data()
-	Dec1ib()
-	Dec1iw()
-	Dec1pi(v int)
-	Dec2ib()
-	Dec2iw()
-	Dec2pi(v int)
-	Decgb(s string)
-	Decgw(s string)
-	Declb(a int)
-	Declw(a int)
-	Decpg(s string, v int)
-	Decpl(a, v int)
-	Decps(a, v int)
-	Decsb(a int)
-	Decsw(a int)
-	Defb(v int)
+	Def(w Width, v int)
+	Defa(s string)
 	Defc(c int)
-	Defl(v int)
-	Defp(v int)
-	Defw(v int)
+	Defl(l Label)
 	Div()
 	Entry()
 	Eq()
 	Exit()
-	Gbss(s string, z int)
+	Ext(w Width)
+	Gbss(s string, z, align int)
 	Ge()
 	Gt()
-	Inc1ib()
-	Inc1iw()
-	Inc1pi(v int)
-	Inc2ib()
-	Inc2iw()
-	Inc2pi(v int)
-	Incgb(s string)
-	Incgw(s string)
-	Inclb(a int)
-	Inclw(a int)
-	Incpg(s string, v int)
-	Incpl(a, v int)
-	Incps(a, v int)
-	Incsb(a int)
-	Incsw(a int)
-	Indb()
-	Indw()
+	Ident(s string)
+	Ind(w Width)
+	IndIndex(w Width, scale int)
+	Index(scale int)
 	Initlw(v, a int)
-	Or()
-	Jump(n int)
-	Lbss(s string, z int)
+	Jump(l Label)
+	Jumpr()
+	Lbss(s string, z, align int)
+	Ldg(w Width, s string)
 	Ldga(s string)
-	Ldgb(s string)
-	Ldgw(s string)
 	Ldinc()
+	Ldl(w Width, n int)
 	Ldla(n int)
-	Ldlab(id int)
-	Ldlb(n int)
-	Ldlw(n int)
-	Ldsa(n int)
-	Ldsb(n int)
-	Ldsw(n int)
-	LdSwtch(n int)
+	Ldlab(l Label)
+	Lds(w Width, l Label)
+	Ldsa(l Label)
+	LdSwtch(l Label)
 	Le()
 	Lit(v int)
 	Load2() bool
@@ -96,7 +69,9 @@ type Backend interface {
 	Mul()
 	Ne()
 	Neg()
+	NewLabel() Label
 	Not()
+	Or()
 	Pop2()
 	PopPtr()
 	Postlude()
@@ -108,17 +83,15 @@ type Backend interface {
 	Scale2()
 	Scale2By(v int)
 	ScaleBy(v int)
+	Section(s Section)
 	Shl()
 	Shr()
 	Stack(n int)
-	Storgb(s string)
-	Storgw(s string)
-	Storib()
-	Storiw()
-	Storlb(n int)
-	Storlw(n int)
-	Storsb(n int)
-	Storsw(n int)
+	Step(s Step)
+	Storg(w Width, s string)
+	Stori(w Width)
+	Storl(w Width, n int)
+	Stors(w Width, l Label)
 	Sub()
 	Swap()
 	Text()
@@ -129,4 +102,126 @@ type Backend interface {
 	Unscale()
 	UnscaleBy(v int)
 	Xor()
+	Zero(size, align int)
+}
+
+// Label is an opaque handle to a code location or static data,
+// labels are allocated by the backend through NewLabel and
+// the zero Label is never a valid label.
+type Label int
+
+// Width is the size and signedness of an operand
+// that a backend operation acts on.
+type Width int
+
+// Operand widths, a backend is only required to
+// support U8 and the widths of a machine word.
+const (
+	U8 Width = iota
+	S8
+	U16
+	S16
+	U32
+	S32
+	U64
+	S64
+	Word // a signed machine word
+	Ptr  // a machine pointer
+)
+
+// Step is an increment or a decrement of a variable in place,
+// which is what the ++ and -- operators do.
+type Step struct {
+	Kind   StepKind // where the variable is
+	W      Width    // width of the variable
+	Stride int      // what a pointer is moved by, 0 for integers that move by one
+	Dec    bool     // it is a decrement
+	Pre    bool     // the value is loaded after the step instead of before it
+	Addr   int      // frame offset of a local
+	Label  Label    // label of a local static
+	Name   string   // symbol of a global
+	Reg    int      // register of a register variable
+}
+
+// StepKind is where the variable of a Step is.
+type StepKind int
+
+const (
+	// StepInd steps the variable that a pointer points to, the pointer
+	// is in the accumulator for a Pre step and saved by Ldinc otherwise.
+	StepInd StepKind = iota
+	StepLocal
+	StepStatic
+	StepGlobal
+	StepReg
+)
+
+// RegBackend is a Backend that keeps register variables in registers
+// that the calls preserve, numbered from 1 to the RegVars of the
+// emitter. The Step of one has the kind StepReg.
+type RegBackend interface {
+	Ldr(r int)      // load register r into the accumulator
+	Storr(r int)    // store the accumulator in register r
+	Saver(r, n int) // save register r at offset n of the frame
+	Restr(r, n int) // restore register r from offset n of the frame
+}
+
+// OvfBackend is a Backend that checks the arithmetic for overflow, for
+// __builtin_add_overflow and the others. Each one computes the first
+// operand in the accumulator op the second one with the width w, stores
+// the result through the pointer popped by PopPtr and leaves 1 in the
+// accumulator if the result overflowed, 0 if it didn't.
+type OvfBackend interface {
+	AddOvf(w Width)
+	SubOvf(w Width)
+	MulOvf(w Width)
+}
+
+// BlockBackend is a Backend that copies and fills blocks of memory inline,
+// for the loops that Config.Idioms replaces. The destination and the
+// source, or the byte to fill with, have been pushed and the count of the
+// bytes is in the accumulator, the accumulator is clobbered.
+type BlockBackend interface {
+	CopyBlock()
+	FillBlock()
+}
+
+// Section is a part of the text segment that the code of a function
+// can be put in, so the linker places the code that runs often apart
+// from the code that rarely does.
+type Section int
+
+const (
+	PlainText Section = iota // the code of an ordinary function
+	HotText                  // the code of a function that runs often
+	ColdText                 // the code of a function that rarely runs
+)
+
+// Signed reports whether the values of width w are signed.
+func (w Width) Signed() bool {
+	switch w {
+	case S8, S16, S32, S64, Word:
+		return true
+	}
+	return false
+}
+
+var widths = [...]string{
+	U8:   "u8",
+	S8:   "s8",
+	U16:  "u16",
+	S16:  "s16",
+	U32:  "u32",
+	S32:  "s32",
+	U64:  "u64",
+	S64:  "s64",
+	Word: "word",
+	Ptr:  "ptr",
+}
+
+func (w Width) String() string {
+	if 0 <= w && int(w) < len(widths) {
+		return widths[w]
+	}
+	return fmt.Sprintf("Width(%d)", int(w))
 }
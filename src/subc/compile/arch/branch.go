@@ -0,0 +1,229 @@
+package arch
+
+import (
+	"strings"
+	"text/scanner"
+)
+
+// Branches describes the branch instructions of a target,
+// so the branches can be improved without knowing the target.
+type Branches struct {
+	Jump    string            // unconditional jump
+	Inverse map[string]string // conditional branches, and the branch on the inverted condition
+}
+
+// X86Inverse are the conditional jumps of the x86 targets, from the
+// 8086 to amd64, and the jumps on the inverted conditions.
+var X86Inverse = map[string]string{
+	"je": "jne", "jne": "je",
+	"jz": "jnz", "jnz": "jz",
+	"jl": "jge", "jge": "jl",
+	"jg": "jle", "jle": "jg",
+	"jb": "jae", "jae": "jb",
+	"ja": "jbe", "jbe": "ja",
+}
+
+// BranchSink is a sink that improves the branches of the code before
+// passing it on to another sink. The backends branch on a condition by
+// jumping over an unconditional jump, and the compiler often jumps to
+// jumps or to the next instruction, which take space and time when
+// left as they are.
+//
+// The code is held until Flush, when it is passed on with:
+//
+//   - a conditional branch over a jump turned into the inverted
+//     conditional branch to where the jump goes
+//   - branches to a jump sent to where the jump goes
+//   - branches to the instruction that follows removed
+//   - code after a jump that can't be reached removed
+type BranchSink struct {
+	out  Sink
+	br   *Branches
+	code []sinkItem
+}
+
+// sinkItem is a label, an instruction, a position or a comment held by a BranchSink.
+type sinkItem struct {
+	kind     sinkKind
+	name     string // label name, instruction or comment
+	operands string
+	inline   bool
+	pos      scanner.Position
+	dead     bool
+}
+
+type sinkKind int
+
+const (
+	sinkLabel sinkKind = iota
+	sinkInst
+	sinkPos
+	sinkComment
+)
+
+// NewBranchSink returns a sink that improves the branches described
+// by br and passes the code on to out.
+func NewBranchSink(out Sink, br *Branches) *BranchSink {
+	return &BranchSink{out: out, br: br}
+}
+
+// Label holds a label.
+func (b *BranchSink) Label(name string, inline bool) {
+	b.code = append(b.code, sinkItem{kind: sinkLabel, name: name, inline: inline})
+}
+
+// Inst holds an instruction.
+func (b *BranchSink) Inst(op, operands string) {
+	b.code = append(b.code, sinkItem{kind: sinkInst, name: op, operands: operands})
+}
+
+// Pos holds a source position.
+func (b *BranchSink) Pos(pos scanner.Position) {
+	b.code = append(b.code, sinkItem{kind: sinkPos, pos: pos})
+}
+
+// Comment holds a comment.
+func (b *BranchSink) Comment(text string) {
+	b.code = append(b.code, sinkItem{kind: sinkComment, name: text})
+}
+
+// Flush improves the branches of the code held and passes it on.
+func (b *BranchSink) Flush() {
+	for b.improve() {
+	}
+
+	for _, x := range b.code {
+		switch {
+		case x.dead:
+		case x.kind == sinkLabel:
+			b.out.Label(x.name, x.inline)
+		case x.kind == sinkInst:
+			b.out.Inst(x.name, x.operands)
+		case x.kind == sinkPos:
+			b.out.Pos(x.pos)
+		case x.kind == sinkComment:
+			b.out.Comment(x.name)
+		}
+	}
+	b.code = b.code[:0]
+}
+
+// improve makes a pass over the code, it reports whether it changed
+// anything so another pass can be made for what that uncovered.
+func (b *BranchSink) improve() bool {
+	labels := make(map[string]int)
+	for i, x := range b.code {
+		if x.kind == sinkLabel && !x.dead {
+			labels[x.name] = i
+		}
+	}
+
+	changed := false
+	for i := range b.code {
+		x := &b.code[i]
+		if x.dead || x.kind != sinkInst {
+			continue
+		}
+		jump := x.name == b.br.Jump
+		inv, cond := b.br.Inverse[x.name]
+		if !jump && !cond {
+			continue
+		}
+
+		// a conditional branch over a jump
+		regs, label := target(x.operands)
+		if cond {
+			j := b.next(i)
+			if j >= 0 && b.code[j].kind == sinkInst && b.code[j].name == b.br.Jump && b.labelAt(b.next(j), label) {
+				x.name, x.operands = inv, regs+b.code[j].operands
+				b.code[j].dead = true
+				changed = true
+				continue
+			}
+		}
+
+		// a branch to a jump
+		if l, found := labels[label]; found {
+			j := b.nextInst(l)
+			if j >= 0 && b.code[j].name == b.br.Jump && b.code[j].operands != label {
+				if _, isLabel := labels[b.code[j].operands]; isLabel {
+					x.operands = regs + b.code[j].operands
+					changed = true
+				}
+			}
+		}
+
+		// a branch to what follows
+		if b.fallsInto(i, label) {
+			x.dead = true
+			changed = true
+			continue
+		}
+
+		// the code after a jump up to the next label is never run
+		if jump {
+			for j := i + 1; j < len(b.code); j++ {
+				y := &b.code[j]
+				if y.kind == sinkLabel || (y.kind == sinkInst && strings.HasPrefix(y.name, ".")) {
+					break
+				}
+				if y.kind == sinkInst && !y.dead {
+					y.dead = true
+					changed = true
+				}
+			}
+		}
+	}
+	return changed
+}
+
+// target splits the operands of a branch into the label it goes to,
+// which is the last one, and the operands before it with the comma
+// that follows them. The conditional branches of mips compare the
+// registers they are given.
+func target(operands string) (regs, label string) {
+	i := strings.LastIndex(operands, ",")
+	if i < 0 {
+		return "", operands
+	}
+	label = strings.TrimSpace(operands[i+1:])
+	return operands[:len(operands)-len(label)], label
+}
+
+// next returns the index of the next label or instruction after i,
+// or -1 if there is none.
+func (b *BranchSink) next(i int) int {
+	for i++; i < len(b.code); i++ {
+		if x := b.code[i]; !x.dead && (x.kind == sinkLabel || x.kind == sinkInst) {
+			return i
+		}
+	}
+	return -1
+}
+
+// nextInst returns the index of the first instruction after i,
+// or -1 if there is none.
+func (b *BranchSink) nextInst(i int) int {
+	for i++; i < len(b.code); i++ {
+		if x := b.code[i]; !x.dead && x.kind == sinkInst {
+			return i
+		}
+	}
+	return -1
+}
+
+// labelAt reports whether the item at i is the label name.
+func (b *BranchSink) labelAt(i int, name string) bool {
+	return i >= 0 && b.code[i].kind == sinkLabel && b.code[i].name == name
+}
+
+// fallsInto reports whether the code after i reaches the label
+// name without running any instruction.
+func (b *BranchSink) fallsInto(i int, name string) bool {
+	for j := b.next(i); j >= 0 && b.code[j].kind == sinkLabel; j = b.next(j) {
+		if b.code[j].name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -1,13 +1,16 @@
 // Package darwinamd64 contains code to generate a x86_64 architecture
 // that is running on darwin. The difference between this package and
 // amd64 is that darwin loads things relative to the rip register.
+// The calling convention is the same as the one of amd64.
 package darwinamd64
 
 import (
 	"fmt"
 	"io"
+	"math/bits"
 
 	"subc/compile/arch"
+	"subc/obj"
 	"subc/types"
 )
 
@@ -15,20 +18,66 @@ type Emitter struct {
 	*arch.Emitter
 }
 
+// NewEmitter returns an emitter that writes assembly text to w.
 func NewEmitter(w io.Writer) *arch.Emitter {
+	return New(arch.NewTextSink(w))
+}
+
+// New returns an emitter that sends the code it generates to out.
+func New(out arch.Sink) *arch.Emitter {
 	c := &Emitter{}
-	c.Emitter = &arch.Emitter{W: w, B: c, Sizes: &types.StdSizes{8, 8}}
+	c.Emitter = &arch.Emitter{Out: out, B: c, ByteOrder: obj.ByteOrder("amd64"), Branches: branches, Sizes: &types.StdSizes{WordSize: 8, MaxAlign: 8}, StdTypes: types.StdTypes{Size: types.Int, Ptrdiff: types.Int, Wchar: types.Int}, Registers: regUsage}
 	return c.Emitter
 }
 
+// regUsage describes how the code uses the registers.
+var regUsage = &arch.Registers{Acc: "%rax", Frame: "%rbp", Stack: "%rsp", Scratch: []string{"%rcx", "%rdx", "%rsi", "%rdi"}}
+
+// branches are the branch instructions, for improving them.
+var branches = &arch.Branches{
+	Jump:    "jmp",
+	Inverse: arch.X86Inverse,
+}
+
+// operand describes how an operand of some width is accessed.
+type operand struct {
+	suffix string // instruction suffix
+	acc    string // accumulator register
+	def    string // data directive
+}
+
+var operands = map[arch.Width]operand{
+	arch.U8:   {"b", "al", ".byte"},
+	arch.U64:  {"q", "rax", ".quad"},
+	arch.S64:  {"q", "rax", ".quad"},
+	arch.Word: {"q", "rax", ".quad"},
+	arch.Ptr:  {"q", "rax", ".quad"},
+}
+
+func opnd(w arch.Width) operand {
+	o, ok := operands[w]
+	if !ok {
+		panic(fmt.Sprintf("unsupported operand width: %v", w))
+	}
+	return o
+}
+
 func (c *Emitter) Data()           { c.Gen(".data") }
 func (c *Emitter) Text()           { c.Gen(".text") }
 func (c *Emitter) Prelude()        {}
 func (c *Emitter) Postlude()       {}
-func (c *Emitter) Public(s string) { c.Raw("\t.globl\t" + s + "\n") }
+func (c *Emitter) Public(s string) { c.Sgen("%s\t%s", ".globl", s) }
+
+// Ident records the quoted string s in the .comment section of the object.
+func (c *Emitter) Ident(s string) { c.Sgen("%s\t%s", ".ident", s) }
+
+// Section switches to the text, Mach-O orders the code of the hot
+// and cold functions with the order files of the linker instead.
+func (c *Emitter) Section(s arch.Section) { c.Text() }
 
 func (c *Emitter) Synth(op string) {
 	n := c.Q.Value
+	l := c.Q.Label
 	s := c.Gsym(c.Q.Name)
 	switch c.Q.Type {
 	case arch.AddrAuto:
@@ -36,13 +85,13 @@ func (c *Emitter) Synth(op string) {
 		c.Sgen("%s\t%s, %%rax", op, "%rcx")
 
 	case arch.AddrStatic:
-		c.Lgen("%s\t%c%d(%%rip),%%rax", op, n)
+		c.Lgen("%s\t%s(%%rip),%%rax", op, l)
 
 	case arch.AddrGlobal:
 		c.Sgen("%s\t%s(%%rip),%%rax", op, s)
 
 	case arch.AddrLabel:
-		c.Lgen("%s\t%c%d(%%rip),%%rax", op, n)
+		c.Lgen("%s\t%s(%%rip),%%rax", op, l)
 
 	case arch.Literal:
 		c.Ngen("%s\t$%d, %%rax", op, n)
@@ -51,7 +100,7 @@ func (c *Emitter) Synth(op string) {
 		c.Ngen("%s\t%d(%%rbp), %%rax", op, n)
 
 	case arch.StaticWord:
-		c.Lgen("%s\t%c%d(%%rip),%%rax", op, n)
+		c.Lgen("%s\t%s(%%rip),%%rax", op, l)
 
 	case arch.GlobalWord:
 		c.Sgen("%s\t%s(%%rip),%%rax", op, s)
@@ -78,19 +127,20 @@ func (c *Emitter) Load2() bool {
 	op := "movq"
 	opb := "movb"
 	n := c.Q.Value
+	l := c.Q.Label
 	s := c.Gsym(c.Q.Name)
 	switch c.Q.Type {
 	case arch.AddrAuto:
 		c.Ngen("%s\t%d(%%rbp), %%rcx", "leaq", n)
 
 	case arch.AddrStatic:
-		c.Lgen("%s\t%c%d(%%rip),%%rcx", op, n)
+		c.Lgen("%s\t%s(%%rip),%%rcx", op, l)
 
 	case arch.AddrGlobal:
 		c.Sgen("%s\t%s(%%rip),%%rcx", op, s)
 
 	case arch.AddrLabel:
-		c.Lgen("%s\t%c%d(%%rip),%%rcx", op, n)
+		c.Lgen("%s\t%s(%%rip),%%rcx", op, l)
 
 	case arch.Literal:
 		c.Ngen("%s\t$%d, %%rcx", op, n)
@@ -104,10 +154,10 @@ func (c *Emitter) Load2() bool {
 
 	case arch.StaticByte:
 		c.Clear2()
-		c.Lgen("%s\t%c%d(%%rip),%%cl", opb, n)
+		c.Lgen("%s\t%s(%%rip),%%cl", opb, l)
 
 	case arch.StaticWord:
-		c.Lgen("%s\t%c%d(%%rip),%%rcx", op, n)
+		c.Lgen("%s\t%s(%%rip),%%rcx", op, l)
 
 	case arch.GlobalByte:
 		c.Clear2()
@@ -128,27 +178,92 @@ func (c *Emitter) Load2() bool {
 	return q == arch.Empty
 }
 
-func (c *Emitter) Lit(v int)     { c.Ngen("%s\t$%d, %%rax", "movq", v) }
-func (c *Emitter) Clear()        { c.Gen("xorq\t%rax, %rax") }
-func (c *Emitter) Clear2()       { c.Gen("xorq\t%rcx, %rcx") }
-func (c *Emitter) Ldgb(s string) { c.Sgen("%s\t%s(%%rip),%%al", "movb", s) }
-func (c *Emitter) Ldgw(s string) { c.Sgen("%s\t%s(%%rip),%%rax", "movq", s) }
-func (c *Emitter) Ldlb(n int)    { c.Ngen("%s\t%d(%%rbp), %%al", "movb", n) }
-func (c *Emitter) Ldlw(n int)    { c.Ngen("%s\t%d(%%rbp), %%rax", "movq", n) }
-func (c *Emitter) Ldsb(n int)    { c.Lgen("%s\t%c%d(%%rip),%%al", "movb", n) }
-func (c *Emitter) Ldsw(n int)    { c.Lgen("%s\t%c%d(%%rip),%%rax", "movq", n) }
-func (c *Emitter) Ldla(n int)    { c.Ngen("%s\t%d(%%rbp), %%rax", "leaq", n) }
-func (c *Emitter) Ldsa(n int)    { c.Lgen("%s\t%c%d(%%rip),%%rax", "leaq", n) }
-func (c *Emitter) Ldga(s string) { c.Sgen("%s\t%s(%%rip),%%rax", "leaq", s) }
+func (c *Emitter) Lit(v int)         { c.Ngen("%s\t$%d, %%rax", "movq", v) }
+func (c *Emitter) Clear()            { c.Gen("xorq\t%rax, %rax") }
+func (c *Emitter) Clear2()           { c.Gen("xorq\t%rcx, %rcx") }
+func (c *Emitter) Ldla(n int)        { c.Ngen("%s\t%d(%%rbp), %%rax", "leaq", n) }
+func (c *Emitter) Ldsa(n arch.Label) { c.Lgen("%s\t%s(%%rip),%%rax", "leaq", n) }
+func (c *Emitter) Ldga(s string)     { c.Sgen("%s\t%s(%%rip),%%rax", "leaq", s) }
+
+func (c *Emitter) Ldg(w arch.Width, s string) {
+	o := opnd(w)
+	c.Ngen("mov%s\t%s(%%rip),%%%s", o.suffix, s, o.acc)
+}
+
+func (c *Emitter) Ldl(w arch.Width, n int) {
+	o := opnd(w)
+	c.Ngen("mov%s\t%d(%%rbp), %%%s", o.suffix, n, o.acc)
+}
+
+func (c *Emitter) Lds(w arch.Width, l arch.Label) {
+	o := opnd(w)
+	c.Ngen("mov%s\t%s(%%rip),%%%s", o.suffix, c.Labname(l), o.acc)
+}
+
+func (c *Emitter) Ind(w arch.Width) {
+	o := opnd(w)
+	if w == arch.U8 {
+		c.Gen("movq\t%rax, %rdx")
+		c.Clear()
+		c.Ngen("mov%s\t(%%rdx), %%%s", o.suffix, o.acc)
+		return
+	}
+	c.Ngen("mov%s\t(%%rax), %%%s", o.suffix, o.acc)
+}
+
+// Index adds the index in %rax scaled by scale to the address in %rcx
+// with one lea.
+func (c *Emitter) Index(scale int) {
+	c.Ngen("leaq\t(%%rcx,%%rax,%d), %%rax", scale)
+}
 
-func (c *Emitter) Indb() {
-	c.Gen("movq\t%rax, %rdx")
-	c.Clear()
-	c.Gen("movb\t(%rdx), %al")
+// IndIndex loads from the address in %rcx plus the index in %rax
+// scaled by scale, addressing the element directly.
+func (c *Emitter) IndIndex(w arch.Width, scale int) {
+	o := opnd(w)
+	if w == arch.U8 {
+		c.Gen("movq\t%rax, %rdx")
+		c.Clear()
+		c.Ngen("mov%s\t(%%rcx,%%rdx,%d), %%%s", o.suffix, scale, o.acc)
+		return
+	}
+	c.Ngen("mov%s\t(%%rcx,%%rax,%d), %%%s", o.suffix, scale, o.acc)
 }
 
-func (c *Emitter) Indw()        { c.Gen("movq\t(%rax), %rax") }
-func (c *Emitter) Ldlab(id int) { c.Lgen("%s\t%c%d(%%rip),%%rax", "leaq", id) }
+// Step increments or decrements a variable in place, with inc or dec
+// for an integer and by adding the stride to a pointer.
+func (c *Emitter) Step(s arch.Step) {
+	var dst string
+	switch s.Kind {
+	case arch.StepInd:
+		dst = "(%rax)"
+		if !s.Pre {
+			dst = "(%rdx)"
+		}
+	case arch.StepLocal:
+		dst = fmt.Sprintf("%d(%%rbp)", s.Addr)
+	case arch.StepStatic:
+		dst = fmt.Sprintf("%s(%%rip)", c.Labname(s.Label))
+	case arch.StepGlobal:
+		dst = fmt.Sprintf("%s(%%rip)", s.Name)
+	}
+
+	if s.Stride == 0 {
+		op := "inc"
+		if s.Dec {
+			op = "dec"
+		}
+		c.Ngen("%s%s\t%s", op, opnd(s.W).suffix, dst)
+		return
+	}
+	op := "addq"
+	if s.Dec {
+		op = "subq"
+	}
+	c.Ngen("%s\t$%d, %s", op, s.Stride, dst)
+}
+
+func (c *Emitter) Ldlab(id arch.Label) { c.Lgen("%s\t%s(%%rip),%%rax", "leaq", id) }
 
 func (c *Emitter) Push()         { c.Gen("pushq\t%rax") }
 func (c *Emitter) PushLit(n int) { c.Ngen("%s\t$%d", "pushq", n) }
@@ -162,6 +277,29 @@ func (c *Emitter) Add() { c.Gen("addq\t%rcx, %rax") }
 func (c *Emitter) Mul() { c.Gen("imulq\t%rcx, %rax") }
 func (c *Emitter) Sub() { c.Gen("subq\t%rcx, %rax") }
 
+// AddOvf, SubOvf and MulOvf do the arithmetic with the width of the int,
+// store the result through %rdx and branch on the overflow flag that the
+// instruction leaves, the moves after it keep the flags.
+func (c *Emitter) AddOvf(w arch.Width) { c.ovf("add", w) }
+func (c *Emitter) SubOvf(w arch.Width) { c.ovf("sub", w) }
+func (c *Emitter) MulOvf(w arch.Width) { c.ovf("imul", w) }
+
+// second is the second register of the width of the accumulator.
+var second = map[string]string{"rax": "rcx", "eax": "ecx", "ax": "cx"}
+
+func (c *Emitter) ovf(inst string, w arch.Width) {
+	o := opnd(w)
+	c.Ngen("%s%s\t%%%s, %%%s", inst, o.suffix, second[o.acc], o.acc)
+	c.Ngen("mov%s\t%%%s, (%%rdx)", o.suffix, o.acc)
+	c.Gen("movq\t$0, %rax")
+	lab := c.Label()
+	c.Lgen("%s\t%s", "jno", lab)
+	c.Gen("movq\t$1, %rax")
+	c.Lab(lab)
+}
+
+// Div divides with idiv, which truncates the quotient toward zero
+// and leaves the remainder with the sign of the dividend.
 func (c *Emitter) Div() {
 	c.Gen("cqo")
 	c.Gen("idivq\t%rcx")
@@ -184,7 +322,7 @@ func (c *Emitter) Cmp(inst string) {
 	} else {
 		c.Synth("cmpq")
 	}
-	c.Lgen("%s\t%c%d", inst, lab)
+	c.Lgen("%s\t%s", inst, lab)
 	c.Gen("incq\t%rdx")
 	c.Lab(lab)
 	c.Gen("movq\t%rdx, %rax")
@@ -201,7 +339,7 @@ func (c *Emitter) Ugt() { c.Cmp("jbe") }
 func (c *Emitter) Ule() { c.Cmp("ja") }
 func (c *Emitter) Uge() { c.Cmp("jb") }
 
-func (c *Emitter) BrCond(i string, n int) {
+func (c *Emitter) BrCond(i string, n arch.Label) {
 	lab := c.Label()
 	if c.Q.Type == arch.Empty {
 		c.Pop2()
@@ -209,25 +347,42 @@ func (c *Emitter) BrCond(i string, n int) {
 	} else {
 		c.Synth("cmpq")
 	}
-	c.Lgen("%s\t%c%d", i, lab)
-	c.Lgen("%s\t%c%d", "jmp", n)
+	c.Lgen("%s\t%s", i, lab)
+	c.Lgen("%s\t%s", "jmp", n)
 	c.Lab(lab)
 }
 
-func (c *Emitter) BrEq(n int)  { c.BrCond("je", n) }
-func (c *Emitter) BrNe(n int)  { c.BrCond("jne", n) }
-func (c *Emitter) BrLt(n int)  { c.BrCond("jl", n) }
-func (c *Emitter) BrGt(n int)  { c.BrCond("jg", n) }
-func (c *Emitter) BrLe(n int)  { c.BrCond("jle", n) }
-func (c *Emitter) BrGe(n int)  { c.BrCond("jge", n) }
-func (c *Emitter) BrUlt(n int) { c.BrCond("jb", n) }
-func (c *Emitter) BrUgt(n int) { c.BrCond("ja", n) }
-func (c *Emitter) BrUle(n int) { c.BrCond("jbe", n) }
-func (c *Emitter) BrUge(n int) { c.BrCond("jae", n) }
+func (c *Emitter) BrEq(n arch.Label)  { c.BrCond("je", n) }
+func (c *Emitter) BrNe(n arch.Label)  { c.BrCond("jne", n) }
+func (c *Emitter) BrLt(n arch.Label)  { c.BrCond("jl", n) }
+func (c *Emitter) BrGt(n arch.Label)  { c.BrCond("jg", n) }
+func (c *Emitter) BrLe(n arch.Label)  { c.BrCond("jle", n) }
+func (c *Emitter) BrGe(n arch.Label)  { c.BrCond("jge", n) }
+func (c *Emitter) BrUlt(n arch.Label) { c.BrCond("jb", n) }
+func (c *Emitter) BrUgt(n arch.Label) { c.BrCond("ja", n) }
+func (c *Emitter) BrUle(n arch.Label) { c.BrCond("jbe", n) }
+func (c *Emitter) BrUge(n arch.Label) { c.BrCond("jae", n) }
 
 func (c *Emitter) Neg() { c.Gen("negq\t%rax") }
 func (c *Emitter) Not() { c.Gen("notq\t%rax") }
 
+// Ext extends the value of width w in the accumulator to a word.
+func (c *Emitter) Ext(w arch.Width) {
+	switch w {
+	case arch.U8:
+		c.Gen("movzbq\t%al, %rax")
+	case arch.S8:
+		c.Gen("movsbq\t%al, %rax")
+	case arch.U16:
+		c.Gen("movzwq\t%ax, %rax")
+	case arch.S16:
+		c.Gen("movswq\t%ax, %rax")
+	case arch.U64, arch.S64, arch.Word, arch.Ptr:
+	default:
+		panic(fmt.Sprintf("unsupported operand width: %v", w))
+	}
+}
+
 func (c *Emitter) LogNot() {
 	c.Gen("negq\t%rax")
 	c.Gen("sbbq\t%rax, %rax")
@@ -263,62 +418,125 @@ func (c *Emitter) Bool() {
 	c.Gen("negq\t%rax")
 }
 
-func (c *Emitter) Ldinc()                { c.Gen("movq\t%rax, %rdx") }
-func (c *Emitter) Inc1pi(v int)          { c.Ngen("%s\t$%d, (%%rax)", "addq", v) }
-func (c *Emitter) Dec1pi(v int)          { c.Ngen("%s\t$%d, (%%rax)", "subq", v) }
-func (c *Emitter) Inc2pi(v int)          { c.Ngen("%s\t$%d, (%%rdx)", "addq", v) }
-func (c *Emitter) Dec2pi(v int)          { c.Ngen("%s\t$%d, (%%rdx)", "subq", v) }
-func (c *Emitter) Incpl(a int, v int)    { c.Ngen2("%s\t$%d, %d(%%rbp)", "addq", v, a) }
-func (c *Emitter) Decpl(a int, v int)    { c.Ngen2("%s\t$%d, %d(%%rbp)", "subq", v, a) }
-func (c *Emitter) Incps(a int, v int)    { c.Lgen2("addq\t$%d,%c%d(%%rip)", v, a) }
-func (c *Emitter) Decps(a int, v int)    { c.Lgen2("subq\t$%d,%c%d(%%rip)", v, a) }
-func (c *Emitter) Incpg(s string, v int) { c.Sgen2("%s\t$%d,%s(%%rip)", "addq", v, s) }
-func (c *Emitter) Decpg(s string, v int) { c.Sgen2("%s\t$%d,%s(%%rip)", "subq", v, s) }
-func (c *Emitter) Inc1iw()               { c.Ngen("%s\t(%%rax)", "incq") }
-func (c *Emitter) Dec1iw()               { c.Ngen("%s\t(%%rax)", "decq") }
-func (c *Emitter) Inc2iw()               { c.Ngen("%s\t(%%rdx)", "incq") }
-func (c *Emitter) Dec2iw()               { c.Ngen("%s\t(%%rdx)", "decq") }
-func (c *Emitter) Inclw(a int)           { c.Ngen("%s\t%d(%%rbp)", "incq", a) }
-func (c *Emitter) Declw(a int)           { c.Ngen("%s\t%d(%%rbp)", "decq", a) }
-func (c *Emitter) Incsw(a int)           { c.Lgen("%s\t%c%d(%%rip)", "incq", a) }
-func (c *Emitter) Decsw(a int)           { c.Lgen("%s\t%c%d(%%rip)", "decq", a) }
-func (c *Emitter) Incgw(s string)        { c.Sgen("%s\t%s(%%rip)", "incq", s) }
-func (c *Emitter) Decgw(s string)        { c.Sgen("%s\t%s(%%rip)", "decq", s) }
-func (c *Emitter) Inc1ib()               { c.Ngen("%s\t(%%rax)", "incb", 0) }
-func (c *Emitter) Dec1ib()               { c.Ngen("%s\t(%%rax)", "decb", 0) }
-func (c *Emitter) Inc2ib()               { c.Ngen("%s\t(%%rdx)", "incb", 0) }
-func (c *Emitter) Dec2ib()               { c.Ngen("%s\t(%%rdx)", "decb", 0) }
-func (c *Emitter) Inclb(a int)           { c.Ngen("%s\t%d(%%rbp)", "incb", a) }
-func (c *Emitter) Declb(a int)           { c.Ngen("%s\t%d(%%rbp)", "decb", a) }
-func (c *Emitter) Incsb(a int)           { c.Lgen("%s\t%c%d(%%rip)", "incb", a) }
-func (c *Emitter) Decsb(a int)           { c.Lgen("%s\t%c%d(%%rip)", "decb", a) }
-func (c *Emitter) Incgb(s string)        { c.Sgen("%s\t%s(%%rip)", "incb", s) }
-func (c *Emitter) Decgb(s string)        { c.Sgen("%s\t%s(%%rip)", "decb", s) }
-
-func (c *Emitter) Br(how string, n int) {
+func (c *Emitter) Ldinc() { c.Gen("movq\t%rax, %rdx") }
+
+func (c *Emitter) Br(how string, n arch.Label) {
 	lab := c.Label()
 	c.Gen("orq\t%rax, %rax")
-	c.Lgen("%s\t%c%d", how, lab)
-	c.Lgen("%s\t%c%d", "jmp", n)
+	c.Lgen("%s\t%s", how, lab)
+	c.Lgen("%s\t%s", "jmp", n)
 	c.Lab(lab)
 }
 
-func (c *Emitter) BrTrue(n int)  { c.Br("jz", n) }
-func (c *Emitter) BrFalse(n int) { c.Br("jnz", n) }
-func (c *Emitter) Jump(n int)    { c.Lgen("%s\t%c%d", "jmp", n) }
-func (c *Emitter) LdSwtch(n int) { c.Lgen("%s\t$%c%d(%%rip), %%rdx", "leaq", n) }
-func (c *Emitter) CalSwtch()     { c.Gen("jmp\tswitch") }
-func (c *Emitter) Case(v, l int) { c.Lgen2(".quad\t%d, %c%d", v, l) }
+func (c *Emitter) BrTrue(n arch.Label)      { c.Br("jz", n) }
+func (c *Emitter) BrFalse(n arch.Label)     { c.Br("jnz", n) }
+func (c *Emitter) Jump(n arch.Label)        { c.Lgen("%s\t%s", "jmp", n) }
+func (c *Emitter) Jumpr()                   { c.Gen("jmp\t*%rax") }
+func (c *Emitter) LdSwtch(n arch.Label)     { c.Lgen("%s\t$%s(%%rip), %%rdx", "leaq", n) }
+func (c *Emitter) CalSwtch()                { c.Gen("jmp\tswitch") }
+func (c *Emitter) Case(v int, l arch.Label) { c.Lgen2(".quad\t%d, %s", v, l) }
+
+func (c *Emitter) PopPtr() { c.Gen("popq\t%rdx") }
+
+func (c *Emitter) Stori(w arch.Width) {
+	o := opnd(w)
+	c.Ngen("mov%s\t%%%s, (%%rdx)", o.suffix, o.acc)
+}
+
+func (c *Emitter) Storl(w arch.Width, n int) {
+	o := opnd(w)
+	c.Ngen("mov%s\t%%%s, %d(%%rbp)", o.suffix, o.acc, n)
+}
 
-func (c *Emitter) PopPtr()         { c.Gen("popq\t%rdx") }
-func (c *Emitter) Storib()         { c.Ngen("%s\t%%al, (%%rdx)", "movb") }
-func (c *Emitter) Storiw()         { c.Ngen("%s\t%%rax, (%%rdx)", "movq") }
-func (c *Emitter) Storlb(n int)    { c.Ngen("%s\t%%al, %d(%%rbp)", "movb", n) }
-func (c *Emitter) Storlw(n int)    { c.Ngen("%s\t%%rax, %d(%%rbp)", "movq", n) }
-func (c *Emitter) Storsb(n int)    { c.Lgen("%s\t%%al, %c%d(%%rip)", "movb", n) }
-func (c *Emitter) Storsw(n int)    { c.Lgen("%s\t%%rax, %c%d(%%rip)", "movq", n) }
-func (c *Emitter) Storgb(s string) { c.Sgen("%s\t%%al, %s(%%rip)", "movb", s) }
-func (c *Emitter) Storgw(s string) { c.Sgen("%s\t%%rax, %s(%%rip)", "movq", s) }
+func (c *Emitter) Stors(w arch.Width, l arch.Label) {
+	o := opnd(w)
+	c.Ngen("mov%s\t%%%s, %s(%%rip)", o.suffix, o.acc, c.Labname(l))
+}
+
+func (c *Emitter) Storg(w arch.Width, s string) {
+	o := opnd(w)
+	c.Ngen("mov%s\t%%%s, %s(%%rip)", o.suffix, o.acc, s)
+}
+
+// unroll is the most words that Copy and Zero move without a loop.
+const unroll = 8
+
+// Copy copies size bytes from the address in %rax to the address in %rdx
+// a word at a time and the bytes left over one by one, with a loop when
+// there are many words. The x86 moves what isn't aligned too, so align
+// doesn't matter. The address of the copy is left in %rax.
+func (c *Emitter) Copy(size, align int) {
+	done := 0
+	if n := size / 8; n > unroll {
+		loop := c.Label()
+		c.Ngen("movq\t$%d, %%rcx", n)
+		c.Lab(loop)
+		c.Gen("movq\t(%rax), %rsi")
+		c.Gen("movq\t%rsi, (%rdx)")
+		c.Gen("addq\t$8, %rax")
+		c.Gen("addq\t$8, %rdx")
+		c.Gen("decq\t%rcx")
+		c.Lgen("%s\t%s", "jnz", loop)
+		done = n * 8
+	}
+	for i := done; i+8 <= size; i += 8 {
+		c.Ngen("movq\t%d(%%rax), %%rcx", i-done)
+		c.Ngen("movq\t%%rcx, %d(%%rdx)", i-done)
+	}
+	for i := size / 8 * 8; i < size; i++ {
+		c.Ngen("movb\t%d(%%rax), %%cl", i-done)
+		c.Ngen("movb\t%%cl, %d(%%rdx)", i-done)
+	}
+	if done != 0 {
+		c.Ngen("leaq\t%d(%%rdx), %%rax", -done)
+	} else {
+		c.Gen("movq\t%rdx, %rax")
+	}
+}
+
+// Zero clears size bytes at the address in %rax like Copy copies them,
+// and leaves the address in %rax.
+func (c *Emitter) Zero(size, align int) {
+	done := 0
+	c.Gen("xorq\t%rcx, %rcx")
+	if n := size / 8; n > unroll {
+		loop := c.Label()
+		c.Ngen("movq\t$%d, %%rdx", n)
+		c.Lab(loop)
+		c.Gen("movq\t%rcx, (%rax)")
+		c.Gen("addq\t$8, %rax")
+		c.Gen("decq\t%rdx")
+		c.Lgen("%s\t%s", "jnz", loop)
+		done = n * 8
+	}
+	for i := done; i+8 <= size; i += 8 {
+		c.Ngen("movq\t%%rcx, %d(%%rax)", i-done)
+	}
+	for i := size / 8 * 8; i < size; i++ {
+		c.Ngen("movb\t%%cl, %d(%%rax)", i-done)
+	}
+	if done != 0 {
+		c.Ngen("subq\t$%d, %%rax", done)
+	}
+}
+
+// CopyBlock and FillBlock go up from the destination in %rdi with the
+// string instructions, repeated for the count in %rcx.
+func (c *Emitter) CopyBlock() {
+	c.Gen("movq\t%rax, %rcx")
+	c.Gen("popq\t%rsi")
+	c.Gen("popq\t%rdi")
+	c.Gen("rep")
+	c.Gen("movsb")
+}
+
+func (c *Emitter) FillBlock() {
+	c.Gen("movq\t%rax, %rcx")
+	c.Gen("popq\t%rax")
+	c.Gen("popq\t%rdi")
+	c.Gen("rep")
+	c.Gen("stosb")
+}
 
 func (c *Emitter) Initlw(v int, a int) { c.Ngen2("%s\t$%d, %d(%%rbp)", "movq", v, a) }
 func (c *Emitter) Call(s string)       { c.Sgen("%s\t%s", "call", s) }
@@ -335,11 +553,28 @@ func (c *Emitter) Exit() {
 	c.Gen("ret")
 }
 
-func (c *Emitter) Defb(v int)           { c.Ngen("%s\t%d", ".byte", v) }
-func (c *Emitter) Defw(v int)           { c.Ngen("%s\t%d", ".quad", v) }
-func (c *Emitter) Defp(v int)           { c.Ngen("%s\t%d", ".quad", v) }
-func (c *Emitter) Defl(v int)           { c.Lgen("%s\t%c%d", ".quad", v) }
-func (c *Emitter) Defc(ch int)          { c.Ngen("%s\t'%c'", ".byte", ch) }
-func (c *Emitter) Gbss(s string, z int) { c.Ngen(".comm\t%s,%d", s, z) }
-func (c *Emitter) Lbss(s string, z int) { c.Ngen(".lcomm\t%s,%d", s, z) }
-func (c *Emitter) Align()               {}
+func (c *Emitter) Def(w arch.Width, v int) { c.Ngen("%s	%d", opnd(w).def, v) }
+func (c *Emitter) Defa(s string)           { c.Sgen("%s\t%s", ".quad", s) }
+func (c *Emitter) Defl(l arch.Label)       { c.Lgen("%s\t%s", ".quad", l) }
+func (c *Emitter) Defc(ch int)             { c.Ngen("%s\t'%c'", ".byte", ch) }
+func (c *Emitter) Align()                  {}
+
+// Gbss and Lbss give the alignment of an aligned variable as the third
+// argument of .comm and .lcomm, Mach-O takes it as a power of 2.
+func (c *Emitter) Gbss(s string, z, align int) {
+	if align > 0 {
+		c.Ngen(".comm\t%s,%d,%d", s, z, bits.TrailingZeros(uint(align)))
+		return
+	}
+	c.Ngen(".comm\t%s,%d", s, z)
+}
+
+func (c *Emitter) Lbss(s string, z, align int) {
+	if align > 0 {
+		c.Ngen(".lcomm\t%s,%d,%d", s, z, bits.TrailingZeros(uint(align)))
+		return
+	}
+	c.Ngen(".lcomm\t%s,%d", s, z)
+}
+
+func (c *Emitter) Balign(n int) { c.Ngen(".p2align\t%d", bits.TrailingZeros(uint(n))) }
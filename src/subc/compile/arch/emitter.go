@@ -1,10 +1,14 @@
 package arch
 
 import (
+	"encoding/binary"
 	"fmt"
-	"io"
+	"strconv"
+	"strings"
+	"text/scanner"
 
 	"subc/constant"
+	"subc/obj"
 	"subc/types"
 )
 
@@ -20,6 +24,8 @@ type LV struct {
 	Name        string         // name of the variable if any
 	Size        int            // size of the variable, for sizeof
 	Addr        int            // the location of the variable, such as the offset of where it is on the stack
+	Reg         int            // the register of a register variable, numbered from 1, or 0 if it is in memory
+	Label       Label          // the label of the variable if it is static, or the label of a string literal or branch target
 	Value       constant.Value // if the variable is a constant, the constant will be stored here
 }
 
@@ -37,21 +43,79 @@ type synth struct {
 	Bool  int    // if it a bool operation
 	Value int    // value of the constant
 	Name  string // name of the variable
+	Label Label  // label of the static variable or address
 }
 
 // Emitter is uses to emit code.
 type Emitter struct {
 	types.Sizes
-	W io.Writer
-	B Backend
+	Out Sink
+	B   Backend
+
+	// Branches describes the branch instructions of the target,
+	// for a BranchSink to improve the branches of the code.
+	Branches *Branches
+
+	// Folding describes the instructions of the target for a FoldSink
+	// to fold them, nil if they aren't folded.
+	Folding *Folding
+
+	// Scheduling describes the instructions of the target for a
+	// ScheduleSink to reorder them, nil if they aren't reordered.
+	Scheduling *Scheduling
+
+	// IntSizes are the sizes narrower than a word that the backend
+	// can make int with SetInt, nil if int is always a word.
+	IntSizes []int
+
+	// StdTypes are the types of size_t, ptrdiff_t and wchar_t on the
+	// target, that sizeof and the difference of pointers have too.
+	StdTypes types.StdTypes
+
+	// ByteOrder is the byte order of the data of the target, the one
+	// that obj.ByteOrder gives the assembler too.
+	ByteOrder binary.ByteOrder
+
+	// RegVars is how many register variables a function can have kept
+	// in the registers of a RegBackend, 0 if the backend keeps them in
+	// the frame like the other locals.
+	RegVars int
+
+	// Registers describes how the code uses the registers of the
+	// target, for documenting its calling convention.
+	Registers *Registers
+
+	// Symbols are the symbols that the asm labels give to globals and
+	// functions, by their names. Gsym gives them as they are.
+	Symbols map[string]string
+
+	// LabelPrefix starts the names of the labels that the compiler
+	// makes up for the branches, the strings and the local statics,
+	// SubC's L if it is empty. The ELF assemblers leave the labels
+	// starting with .L out of the symbol table of the object.
+	LabelPrefix string
+
+	// Verbose is how much the code is explained with comments,
+	// the backends leave it to the emitter.
+	Verbose Verbosity
 
 	Q       synth
-	Retlab  int
 	Acc     bool
 	textSeg bool
+	section Section
 	labelID int
 }
 
+// Verbosity is how much the emitter explains the code it emits.
+type Verbosity int
+
+// Verbosities of the code, each one has the comments of the ones before it.
+const (
+	Quiet     Verbosity = iota // just the code
+	Commented                  // blank lines between the definitions and comments saying what they are
+	Traced                     // the operations of the code synthesizer as they are flushed
+)
+
 // Addressing modes for the code synthesizer.
 const (
 	Empty = iota
@@ -66,6 +130,7 @@ const (
 	StaticWord
 	GlobalByte
 	GlobalWord
+	RegWord
 )
 
 // Comparison operations.
@@ -90,6 +155,53 @@ const (
 	Normalize
 )
 
+var (
+	queueNames = [...]string{
+		AddrAuto:   "address of auto",
+		AddrStatic: "address of static",
+		AddrGlobal: "address of global",
+		AddrLabel:  "address of label",
+		Literal:    "literal",
+		AutoByte:   "auto byte",
+		AutoWord:   "auto word",
+		StaticByte: "static byte",
+		StaticWord: "static word",
+		GlobalByte: "global byte",
+		GlobalWord: "global word",
+		RegWord:    "register word",
+	}
+	cmpNames = [...]string{
+		Equal:        "==",
+		NotEqual:     "!=",
+		Less:         "<",
+		Greater:      ">",
+		LessEqual:    "<=",
+		GreaterEqual: ">=",
+		Below:        "< unsigned",
+		Above:        "> unsigned",
+		BelowEqual:   "<= unsigned",
+		AboveEqual:   ">= unsigned",
+	}
+	boolNames = [...]string{
+		LogNot:    "logical not",
+		Normalize: "normalize",
+	}
+)
+
+// queued describes the value queued in the code synthesizer.
+func (c *Emitter) queued() string {
+	var what string
+	switch c.Q.Type {
+	case AddrStatic, AddrLabel, StaticByte, StaticWord:
+		what = c.Labname(c.Q.Label)
+	case AddrGlobal, GlobalByte, GlobalWord:
+		what = c.Gsym(c.Q.Name)
+	default:
+		what = fmt.Sprint(c.Q.Value)
+	}
+	return queueNames[c.Q.Type] + " " + what
+}
+
 // Clear resets the accumulator.
 func (c *Emitter) Clear(q bool) {
 	c.Acc = false
@@ -100,6 +212,12 @@ func (c *Emitter) Clear(q bool) {
 	}
 }
 
+// BigEndian reports whether the most significant byte of a word is the
+// first one, which moves the low byte of a word to its end.
+func (c *Emitter) BigEndian() bool {
+	return c.ByteOrder == binary.BigEndian
+}
+
 // Load enables the accumulator, meaning it tells
 // the later code generation it must spills if it wants
 // to mess with the accumulator.
@@ -107,79 +225,117 @@ func (c *Emitter) Load() {
 	c.Acc = true
 }
 
-// Label generates a new label ID for labels.
-func (c *Emitter) Label() int {
+// Label allocates a new label from the backend.
+func (c *Emitter) Label() Label {
+	return c.B.NewLabel()
+}
+
+// ResetLabels numbers the labels allocated next from the first one
+// again, so the labels of a translation unit don't depend on what the
+// emitter compiled before it.
+func (c *Emitter) ResetLabels() {
+	c.labelID = 0
+}
+
+// NewLabel allocates a new label with a unique ID, backends
+// that want a different label representation can override it.
+func (c *Emitter) NewLabel() Label {
 	c.labelID++
-	return c.labelID
+	return Label(c.labelID)
 }
 
 // Spill pushes the registers in use onto the stack.
 func (c *Emitter) Spill() {
 	if c.Acc {
 		c.Text()
+		c.trace("spill")
 		c.B.Push()
 	}
 }
 
-// Raw emits raw text.
-func (c *Emitter) Raw(s string) {
-	fmt.Fprint(c.W, s)
+// emit splits a line of assembly into an instruction
+// and its operands and sends it to the output sink.
+func (c *Emitter) emit(line string) {
+	op, operands := line, ""
+	if i := strings.IndexByte(line, '\t'); i >= 0 {
+		op, operands = line[:i], line[i+1:]
+	}
+	c.Out.Inst(op, operands)
 }
 
 // Gen emits a line of assembly with an instruction.
 func (c *Emitter) Gen(s string) {
-	fmt.Fprintf(c.W, "\t%s\n", s)
+	c.emit(s)
 }
 
 // Ngen emits a line of assembly with instruction and one or zero argument.
 func (c *Emitter) Ngen(s string, args ...interface{}) {
-	fmt.Fprint(c.W, "\t")
-	fmt.Fprintf(c.W, s, args...)
-	fmt.Fprint(c.W, "\n")
+	c.emit(fmt.Sprintf(s, args...))
 }
 
 // Ngen2 emits a line of assembly with instruction and two arguments.
 func (c *Emitter) Ngen2(s, inst string, n, a int) {
-	fmt.Fprint(c.W, "\t")
-	fmt.Fprintf(c.W, s, inst, n, a)
-	fmt.Fprintf(c.W, "\n")
+	c.emit(fmt.Sprintf(s, inst, n, a))
 }
 
 // Lgen emits an instruction that acts on a label.
-func (c *Emitter) Lgen(s, inst string, n int) {
-	fmt.Fprint(c.W, "\t")
-	fmt.Fprintf(c.W, s, inst, lprefix, n)
-	fmt.Fprint(c.W, "\n")
+func (c *Emitter) Lgen(s, inst string, l Label) {
+	c.emit(fmt.Sprintf(s, inst, c.Labname(l)))
 }
 
 // Lgen2 emits an instruction with one argument that acts on a label.
-func (c *Emitter) Lgen2(s string, v1, v2 int) {
-	fmt.Fprint(c.W, "\t")
-	fmt.Fprintf(c.W, s, v1, lprefix, v2)
-	fmt.Fprint(c.W, "\n")
+func (c *Emitter) Lgen2(s string, v int, l Label) {
+	c.emit(fmt.Sprintf(s, v, c.Labname(l)))
 }
 
 // Sgen emits a prefix and instruction with one argument.
 func (c *Emitter) Sgen(s, inst, s2 string) {
-	fmt.Fprint(c.W, "\t")
-	fmt.Fprintf(c.W, s, inst, s2)
-	fmt.Fprint(c.W, "\n")
+	c.emit(fmt.Sprintf(s, inst, s2))
 }
 
 // Sgen2 emits a prefix and instruction with two arguments.
 func (c *Emitter) Sgen2(s, inst string, v int, s2 string) {
-	fmt.Fprintf(c.W, "\t")
-	fmt.Fprintf(c.W, s, inst, v, s2)
-	fmt.Fprintf(c.W, "\n")
+	c.emit(fmt.Sprintf(s, inst, v, s2))
+}
+
+// Comment emits a comment on the code if the code is being commented.
+func (c *Emitter) Comment(format string, args ...interface{}) {
+	if c.Verbose >= Commented {
+		c.Out.Comment(fmt.Sprintf(format, args...))
+	}
+}
+
+// Blank emits a blank line before a definition if the code is being commented.
+func (c *Emitter) Blank() {
+	if c.Verbose >= Commented {
+		c.Out.Comment("")
+	}
+}
+
+// trace emits a comment on an operation of the code synthesizer if
+// the code is being traced.
+func (c *Emitter) trace(format string, args ...interface{}) {
+	if c.Verbose >= Traced {
+		c.Out.Comment(fmt.Sprintf(format, args...))
+	}
+}
+
+// Pos marks the source position of the code emitted next.
+func (c *Emitter) Pos(pos scanner.Position) {
+	c.Out.Pos(pos)
 }
 
 // Lab emits a label.
-func (c *Emitter) Lab(id int) {
-	fmt.Fprintf(c.W, "%c%d:\n", lprefix, id)
+func (c *Emitter) Lab(l Label) {
+	c.Out.Label(c.Labname(l), false)
 }
 
-// Gsym returns a label string with the code prefix for string s.
+// Gsym returns the symbol of the global s, the code prefix and s
+// unless an asm label gives it another one.
 func (c *Emitter) Gsym(s string) string {
+	if sym, found := c.Symbols[s]; found {
+		return sym
+	}
 	return string(prefix) + s
 }
 
@@ -195,6 +351,12 @@ func (c *Emitter) Postlude() {
 	c.B.Postlude()
 }
 
+// Ident emits the text that identifies the compiler that made the code,
+// the assembler records it in the .comment section of the object.
+func (c *Emitter) Ident(text string) {
+	c.B.Ident(obj.Quote(text))
+}
+
 // Data emits code to switch to the data segment.
 func (c *Emitter) Data() {
 	if c.textSeg {
@@ -203,18 +365,30 @@ func (c *Emitter) Data() {
 	c.textSeg = false
 }
 
-// Text emits code to switch to the text segment.
+// Text emits code to switch to the text segment, to the section
+// of the text that the functions are put in.
 func (c *Emitter) Text() {
 	if !c.textSeg {
-		c.B.Text()
+		if c.section == PlainText {
+			c.B.Text()
+		} else {
+			c.B.Section(c.section)
+		}
 	}
 	c.textSeg = true
 }
 
+// Section puts the functions that follow in section s of the text.
+func (c *Emitter) Section(s Section) {
+	if s != c.section {
+		c.section = s
+		c.textSeg = false
+	}
+}
+
 // Name emits a code label for name.
 func (c *Emitter) Name(name string) {
-	c.Raw(c.Gsym(name))
-	c.Raw(":")
+	c.Out.Label(c.Gsym(name), true)
 }
 
 // Public emits code for defining a global symbol,
@@ -224,12 +398,19 @@ func (c *Emitter) Public(name string) {
 }
 
 // Jump emits code to jump to a destination.
-func (c *Emitter) Jump(dest int) {
+func (c *Emitter) Jump(dest Label) {
 	c.Text()
 	c.Commit()
 	c.B.Jump(dest)
 }
 
+// Jumpr emits code to jump to the address in the accumulator.
+func (c *Emitter) Jumpr() {
+	c.Text()
+	c.Commit()
+	c.B.Jumpr()
+}
+
 // Commit flushes the code synthesizer to load
 // and spill the registers to memory.
 func (c *Emitter) Commit() {
@@ -246,43 +427,47 @@ func (c *Emitter) Commit() {
 		return
 	}
 
+	c.trace("load %s", c.queued())
 	c.Spill()
 	switch c.Q.Type {
 	case AddrAuto:
 		c.B.Ldla(c.Q.Value)
 
 	case AddrStatic:
-		c.B.Ldsa(c.Q.Value)
+		c.B.Ldsa(c.Q.Label)
 
 	case AddrGlobal:
 		c.B.Ldga(c.Gsym(c.Q.Name))
 
 	case AddrLabel:
-		c.B.Ldlab(c.Q.Value)
+		c.B.Ldlab(c.Q.Label)
 
 	case Literal:
 		c.B.Lit(c.Q.Value)
 
 	case AutoByte:
 		c.B.Clear()
-		c.B.Ldlb(c.Q.Value)
+		c.B.Ldl(U8, c.Q.Value)
 
 	case AutoWord:
-		c.B.Ldlw(c.Q.Value)
+		c.B.Ldl(Word, c.Q.Value)
 
 	case StaticByte:
 		c.B.Clear()
-		c.B.Ldsb(c.Q.Value)
+		c.B.Lds(U8, c.Q.Label)
 
 	case StaticWord:
-		c.B.Ldsw(c.Q.Value)
+		c.B.Lds(Word, c.Q.Label)
 
 	case GlobalByte:
 		c.B.Clear()
-		c.B.Ldgb(c.Gsym(c.Q.Name))
+		c.B.Ldg(U8, c.Gsym(c.Q.Name))
 
 	case GlobalWord:
-		c.B.Ldgw(c.Gsym(c.Q.Name))
+		c.B.Ldg(Word, c.Gsym(c.Q.Name))
+
+	case RegWord:
+		c.regs().Ldr(c.Q.Value)
 
 	default:
 		panic(fmt.Sprintf("unknown Q-Type: %v", c.Q.Type))
@@ -307,6 +492,14 @@ func (c *Emitter) Queue(typ, value int, name string) {
 	}
 }
 
+// QueueLabel queues a label address or a value addressed by a label
+// into the code synthesizer.
+func (c *Emitter) QueueLabel(typ int, l Label) {
+	c.Commit()
+	c.Q.Type = typ
+	c.Q.Label = l
+}
+
 // Addr emits code for queuing up addresses.
 func (c *Emitter) Addr(lv LV) {
 	c.Text()
@@ -315,16 +508,16 @@ func (c *Emitter) Addr(lv LV) {
 	case types.Auto:
 		c.Queue(AddrAuto, lv.Addr, "")
 	case types.LocalStatic:
-		c.Queue(AddrStatic, lv.Addr, "")
+		c.QueueLabel(AddrStatic, lv.Label)
 	default:
 		c.Queue(AddrGlobal, 0, lv.Name)
 	}
 }
 
-// Ldlab queues an address label with id.
-func (c *Emitter) Ldlab(id int) {
+// Ldlab queues the address of label l.
+func (c *Emitter) Ldlab(l Label) {
 	c.Text()
-	c.Queue(AddrLabel, id, "")
+	c.QueueLabel(AddrLabel, l)
 }
 
 // Lit queues a literal.
@@ -335,6 +528,7 @@ func (c *Emitter) Lit(v int) {
 
 // commitCmp flushes a comparison operator.
 func (c *Emitter) commitCmp() {
+	c.trace("compare %s", cmpNames[c.Q.Cmp])
 	switch c.Q.Cmp {
 	case Equal:
 		c.B.Eq()
@@ -362,6 +556,7 @@ func (c *Emitter) commitCmp() {
 
 // commitBool flushes a boolean operator.
 func (c *Emitter) commitBool() {
+	c.trace("%s", boolNames[c.Q.Bool])
 	switch c.Q.Bool {
 	case LogNot:
 		c.B.LogNot()
@@ -421,22 +616,134 @@ func (c *Emitter) AlignText() {
 	c.B.Align()
 }
 
+// AlignCode aligns the code that follows to align, the padding
+// that is run on the way is filled with instructions that do nothing.
+func (c *Emitter) AlignCode(align int) {
+	c.Text()
+	c.B.Balign(align)
+}
+
 // Defb emits code for byte declaration.
 func (c *Emitter) Defb(v int) {
 	c.Data()
-	c.B.Defb(v)
+	c.B.Def(U8, v)
 }
 
 // Defp emits code for pointer declaration.
 func (c *Emitter) Defp(v int) {
 	c.Data()
-	c.B.Defp(v)
+	c.B.Def(Ptr, v)
 }
 
-// Defw emits code for word declaration.
+// Defa emits code for a pointer declaration initialized to the address
+// of lv plus off bytes, lv is a global, a function or a label of the data.
+func (c *Emitter) Defa(lv LV, off int) {
+	s := c.Gsym(lv.Name)
+	if lv.Storage == types.LocalStatic {
+		s = c.Labname(lv.Label)
+	}
+	if off != 0 {
+		s += fmt.Sprintf("%+d", off)
+	}
+	c.Data()
+	c.B.Defa(s)
+}
+
+// Defw emits code for an int declaration.
 func (c *Emitter) Defw(v int) {
 	c.Data()
-	c.B.Defw(v)
+	c.B.Def(c.Width(types.Typ[types.Int]), v)
+}
+
+// SaveRegs emits code to save the registers of the register variables
+// lvs in their slots of the frame, for the caller.
+func (c *Emitter) SaveRegs(lvs []*LV) {
+	c.Text()
+	for _, lv := range lvs {
+		c.regs().Saver(lv.Reg, lv.Addr)
+	}
+}
+
+// RestoreRegs emits code to restore the registers saved by SaveRegs,
+// the accumulator is kept.
+func (c *Emitter) RestoreRegs(lvs []*LV) {
+	c.Text()
+	for _, lv := range lvs {
+		c.regs().Restr(lv.Reg, lv.Addr)
+	}
+}
+
+// regs returns the backend as the one keeping the register variables.
+func (c *Emitter) regs() RegBackend {
+	return c.B.(RegBackend)
+}
+
+// Overflows reports whether the backend checks the arithmetic for
+// overflow, which the overflow checking builtins need.
+func (c *Emitter) Overflows() bool {
+	_, ok := c.B.(OvfBackend)
+	return ok
+}
+
+// AddOvf emits code for __builtin_add_overflow, the pointer to the result
+// and the first operand have been pushed and the second operand is in the
+// accumulator. The sum is stored as an int through the pointer and the
+// accumulator is 1 if it overflowed the int, 0 otherwise.
+func (c *Emitter) AddOvf() { c.ovf(c.B.(OvfBackend).AddOvf) }
+
+// SubOvf emits code for __builtin_sub_overflow, like AddOvf.
+func (c *Emitter) SubOvf() { c.ovf(c.B.(OvfBackend).SubOvf) }
+
+// MulOvf emits code for __builtin_mul_overflow, like AddOvf.
+func (c *Emitter) MulOvf() { c.ovf(c.B.(OvfBackend).MulOvf) }
+
+func (c *Emitter) ovf(inst func(w Width)) {
+	c.Text()
+	c.Commit()
+	c.B.Pop2()
+	c.B.Swap()
+	c.B.PopPtr()
+	inst(c.Width(types.Typ[types.Int]))
+}
+
+// Blocks reports whether the backend copies and fills blocks of memory
+// inline, instead of calling memcpy and memset.
+func (c *Emitter) Blocks() bool {
+	_, ok := c.B.(BlockBackend)
+	return ok
+}
+
+// CopyBlock emits code to copy the bytes of a block like memcpy does, the
+// destination and the source have been pushed and the count is in the
+// accumulator.
+func (c *Emitter) CopyBlock() {
+	c.Text()
+	c.Commit()
+	c.B.(BlockBackend).CopyBlock()
+}
+
+// FillBlock emits code to fill a block with a byte like memset does, the
+// destination and the byte have been pushed and the count is in the
+// accumulator.
+func (c *Emitter) FillBlock() {
+	c.Text()
+	c.Commit()
+	c.B.(BlockBackend).FillBlock()
+}
+
+// NullCheck emits code to check the pointer in the accumulator against
+// null before it is dereferenced. A null pointer is reported by calling
+// __nullderef of the runtime with the message at label msg, which
+// doesn't return, so the pointer is still in the accumulator after it.
+func (c *Emitter) NullCheck(msg Label) {
+	c.Text()
+	c.Commit()
+	ok := c.Label()
+	c.B.BrTrue(ok)
+	c.B.Ldsa(msg)
+	c.B.Push()
+	c.B.Call(c.Gsym("__nullderef"))
+	c.Lab(ok)
 }
 
 // Entry emits code for entry.
@@ -446,8 +753,8 @@ func (c *Emitter) Entry() {
 }
 
 // Branch emits code for branching.
-func (c *Emitter) Branch(dest int, inv bool) {
-	f := [...][2]func(int){
+func (c *Emitter) Branch(dest Label, inv bool) {
+	f := [...][2]func(Label){
 		Equal:        {c.B.BrNe, c.B.BrEq},
 		NotEqual:     {c.B.BrEq, c.B.BrNe},
 		Less:         {c.B.BrGe, c.B.BrLt},
@@ -469,7 +776,7 @@ func (c *Emitter) Branch(dest int, inv bool) {
 }
 
 // LogBr emits code for logical branching.
-func (c *Emitter) LogBr(dest int, inv bool) {
+func (c *Emitter) LogBr(dest Label, inv bool) {
 	switch c.Q.Bool {
 	case Normalize:
 		inv = !inv
@@ -485,7 +792,7 @@ func (c *Emitter) LogBr(dest int, inv bool) {
 }
 
 // BrFalse emits code for branching.
-func (c *Emitter) BrFalse(dest int) {
+func (c *Emitter) BrFalse(dest Label) {
 	c.Text()
 	if c.Q.Cmp != Cnone {
 		c.Branch(dest, false)
@@ -500,7 +807,7 @@ func (c *Emitter) BrFalse(dest int) {
 }
 
 // BrTrue emits code for branching.
-func (c *Emitter) BrTrue(dest int) {
+func (c *Emitter) BrTrue(dest Label) {
 	c.Text()
 	if c.Q.Cmp != Cnone {
 		c.Branch(dest, true)
@@ -557,7 +864,10 @@ func (c *Emitter) Shr(swapped bool) {
 	c.B.Shr()
 }
 
-// Div emits code for the division operator.
+// Div emits code for the division operator. The quotient is truncated
+// toward zero on all targets, like C99 asks for. Dividing by zero or
+// the most negative int by -1 is undefined, the x86 targets trap on
+// both and the arm runtime raises SIGFPE on a zero divisor.
 func (c *Emitter) Div(swapped bool) {
 	c.Text()
 	if c.B.Load2() || !swapped {
@@ -566,7 +876,8 @@ func (c *Emitter) Div(swapped bool) {
 	c.B.Div()
 }
 
-// Mod emits code for the modulus operator.
+// Mod emits code for the modulus operator. The remainder has the sign
+// of the dividend, so (a/b)*b + a%b == a whenever a/b is defined.
 func (c *Emitter) Mod(swapped bool) {
 	c.Text()
 	if c.B.Load2() || !swapped {
@@ -575,19 +886,25 @@ func (c *Emitter) Mod(swapped bool) {
 	c.B.Mod()
 }
 
-// Call emits code to call a function with a name
+// Call emits code to call a function with a name, the arguments
+// have been pushed. The result is in the accumulator after the call.
 func (c *Emitter) Call(lv LV) {
-	c.Text()
-	c.Commit()
-	c.B.Call(c.Gsym(lv.Name))
-	c.Load()
+	c.call(func() { c.B.Call(c.Gsym(lv.Name)) })
 }
 
-// Calr emits code to call a function pointer.
+// Calr emits code to call the function pointer in the accumulator,
+// the arguments have been pushed. The result is in the accumulator
+// after the call.
 func (c *Emitter) Calr(lv LV) {
+	c.call(c.B.Calr)
+}
+
+// call emits the code of a call, both kinds of calls
+// are lowered the same way around the call instruction.
+func (c *Emitter) call(inst func()) {
 	c.Text()
 	c.Commit()
-	c.B.Calr()
+	inst()
 	c.Load()
 }
 
@@ -595,39 +912,45 @@ func (c *Emitter) Calr(lv LV) {
 func (c *Emitter) Store(lv LV) {
 	c.Text()
 
-	typ := lv.Type.Underlying()
+	w := c.Width(lv.Type.Underlying())
 	switch {
 	case !lv.Ident:
 		c.B.PopPtr()
-		if typ == types.Typ[types.Char] {
-			c.B.Storib()
-		} else {
-			c.B.Storiw()
-		}
+		c.B.Stori(w)
+
+	case lv.Reg > 0:
+		c.regs().Storr(lv.Reg)
 
 	case lv.Storage == types.Auto:
-		if typ == types.Typ[types.Char] {
-			c.B.Storlb(lv.Addr)
-		} else {
-			c.B.Storlw(lv.Addr)
-		}
+		c.B.Storl(w, lv.Addr)
 
 	case lv.Storage == types.LocalStatic:
-		if typ == types.Typ[types.Char] {
-			c.B.Storsb(lv.Addr)
-		} else {
-			c.B.Storsw(lv.Addr)
-		}
+		c.B.Stors(w, lv.Label)
 
 	default:
-		if typ == types.Typ[types.Char] {
-			c.B.Storgb(c.Gsym(lv.Name))
-		} else {
-			c.B.Storgw(c.Gsym(lv.Name))
-		}
+		c.B.Storg(w, c.Gsym(lv.Name))
 	}
 }
 
+// Copy emits code to copy size bytes aligned to align from the address
+// in the accumulator to the address pushed before it, like the Store of
+// a scalar through a pointer. The address of the copy is left in the
+// accumulator.
+func (c *Emitter) Copy(size, align int) {
+	c.Text()
+	c.Commit()
+	c.B.PopPtr()
+	c.B.Copy(size, align)
+}
+
+// Zero emits code to clear size bytes aligned to align at the address
+// in the accumulator, the address is left in the accumulator.
+func (c *Emitter) Zero(size, align int) {
+	c.Text()
+	c.Commit()
+	c.B.Zero(size, align)
+}
+
 // Bool emits code for a bool.
 func (c *Emitter) Bool() {
 	c.QueueBool(Normalize)
@@ -652,77 +975,24 @@ func (c *Emitter) Not() {
 	c.B.Not()
 }
 
-// Scale emits code to scale indices for pointer arithmetic and array accesses.
-func (c *Emitter) Scale() {
+// Ext emits code to extend the low bits of the value of width w
+// to a word, with zeros if w is unsigned and with its sign otherwise.
+func (c *Emitter) Ext(w Width) {
 	c.Text()
 	c.Commit()
-	c.B.Scale()
+	c.B.Ext(w)
 }
 
-// incPtr emits code to increment a pointer.
-func (c *Emitter) incPtr(lv LV, inc, pre bool) {
+// Scale emits code to scale indices for pointer arithmetic and array accesses.
+func (c *Emitter) Scale() {
 	c.Text()
 	c.Commit()
-	if !lv.Ident && !pre {
-		c.B.Ldinc()
-	}
-	if !pre {
-		c.Rval(lv)
-		c.Commit()
-	}
-
-	size := c.Sizeof(deref(lv.Type))
-
-	switch {
-	case !lv.Ident:
-		switch {
-		case pre && inc:
-			c.B.Inc1pi(size)
-		case pre && !inc:
-			c.B.Dec1pi(size)
-		case !pre && inc:
-			c.B.Inc2pi(size)
-		case !pre && !inc:
-			c.B.Dec2pi(size)
-		}
-
-	case lv.Storage == types.Auto:
-		if inc {
-			c.B.Incpl(lv.Addr, size)
-		} else {
-			c.B.Decpl(lv.Addr, size)
-		}
-
-	case lv.Storage == types.LocalStatic:
-		if inc {
-			c.B.Incps(lv.Addr, size)
-		} else {
-			c.B.Decps(lv.Addr, size)
-		}
-
-	default:
-		if inc {
-			c.B.Incpg(c.Gsym(lv.Name), size)
-		} else {
-			c.B.Decpg(c.Gsym(lv.Name), size)
-		}
-	}
-
-	if pre {
-		c.Rval(lv)
-	}
+	c.B.Scale()
 }
 
 // Inc emits code to increment a variable.
 func (c *Emitter) Inc(lv LV, inc, pre bool) {
 	c.Text()
-
-	if needScale(lv.Type) {
-		c.incPtr(lv, inc, pre)
-		return
-	}
-
-	isChar := lv.Type == types.Typ[types.Char]
 	c.Commit()
 	if !lv.Ident && !pre {
 		c.B.Ldinc()
@@ -732,74 +1002,23 @@ func (c *Emitter) Inc(lv LV, inc, pre bool) {
 		c.Commit()
 	}
 
+	s := Step{W: c.Width(lv.Type), Dec: !inc, Pre: pre}
+	if needScale(lv.Type) {
+		s.Stride = c.Sizeof(deref(lv.Type))
+	}
 	switch {
 	case !lv.Ident:
-		switch {
-		case pre && inc:
-			if isChar {
-				c.B.Inc1ib()
-			} else {
-				c.B.Inc1iw()
-			}
-
-		case pre && !inc:
-			if isChar {
-				c.B.Dec1ib()
-			} else {
-				c.B.Dec1iw()
-			}
-
-		case !pre && inc:
-			if isChar {
-				c.B.Inc2ib()
-			} else {
-				c.B.Inc2iw()
-			}
-
-		case !pre && !inc:
-			if isChar {
-				c.B.Dec2ib()
-			} else {
-				c.B.Dec2iw()
-			}
-		}
-
+		s.Kind = StepInd
+	case lv.Reg > 0:
+		s.Kind, s.Reg = StepReg, lv.Reg
 	case lv.Storage == types.Auto:
-		switch {
-		case inc && isChar:
-			c.B.Inclb(lv.Addr)
-		case inc && !isChar:
-			c.B.Inclw(lv.Addr)
-		case !inc && isChar:
-			c.B.Declb(lv.Addr)
-		case !inc && !isChar:
-			c.B.Declw(lv.Addr)
-		}
-
+		s.Kind, s.Addr = StepLocal, lv.Addr
 	case lv.Storage == types.LocalStatic:
-		switch {
-		case inc && isChar:
-			c.B.Incsb(lv.Addr)
-		case inc && !isChar:
-			c.B.Incsw(lv.Addr)
-		case !inc && isChar:
-			c.B.Decsb(lv.Addr)
-		case !inc && !isChar:
-			c.B.Decsw(lv.Addr)
-		}
-
+		s.Kind, s.Label = StepStatic, lv.Label
 	default:
-		switch {
-		case inc && isChar:
-			c.B.Incgb(c.Gsym(lv.Name))
-		case inc && !isChar:
-			c.B.Incgw(c.Gsym(lv.Name))
-		case !inc && isChar:
-			c.B.Decgb(c.Gsym(lv.Name))
-		case !inc && !isChar:
-			c.B.Decgw(c.Gsym(lv.Name))
-		}
+		s.Kind, s.Name = StepGlobal, c.Gsym(lv.Name)
 	}
+	c.B.Step(s)
 
 	if pre {
 		c.Rval(lv)
@@ -829,7 +1048,7 @@ func (c *Emitter) Add(p1, p2 types.Type, swapped bool) types.Type {
 	var rp types.Type = types.Typ[types.Int]
 	if isPointer(p1) {
 		if needScale(p1) {
-			if p := deref(p1); isRecord(p) {
+			if p := deref(p1); !c.wordSized(p) {
 				c.B.Scale2By(c.Sizeof(p))
 			} else {
 				c.B.Scale2()
@@ -838,7 +1057,7 @@ func (c *Emitter) Add(p1, p2 types.Type, swapped bool) types.Type {
 		rp = p1
 	} else if isPointer(p2) {
 		if needScale(p2) {
-			if p := deref(p2); isRecord(p) {
+			if p := deref(p2); !c.wordSized(p) {
 				c.B.ScaleBy(c.Sizeof(p))
 			} else {
 				c.B.Scale()
@@ -868,15 +1087,34 @@ func isRecord(typ types.Type) bool {
 	return ok
 }
 
+// wordSized reports whether the elements of type typ are a word,
+// which Scale scales the indices by, and not a record.
+func (c *Emitter) wordSized(typ types.Type) bool {
+	return !isRecord(typ) && c.Sizeof(typ) == c.Word()
+}
+
 // Ind emits code to do an indirection.
 func (c *Emitter) Ind(lv LV) {
 	c.Text()
 	c.Commit()
-	if lv.Type == types.Typ[types.Char] {
-		c.B.Indb()
-	} else {
-		c.B.Indw()
-	}
+	c.B.Ind(c.Width(lv.Type))
+}
+
+// Index emits code to add the index in the accumulator scaled by scale
+// to the address queued or pushed before it, with the scaled index
+// addressing of the target. The scale is 1, 2, 4 or 8.
+func (c *Emitter) Index(scale int) {
+	c.Text()
+	c.B.Load2()
+	c.B.Index(scale)
+}
+
+// IndIndex is like Index, but it loads the value at the address in lv
+// instead, which saves computing the address first.
+func (c *Emitter) IndIndex(lv LV, scale int) {
+	c.Text()
+	c.B.Load2()
+	c.B.IndIndex(c.Width(lv.Type), scale)
 }
 
 // ScaleBy emits code to scale the indices.
@@ -895,6 +1133,15 @@ func (c *Emitter) Rval(lv LV) {
 	case !lv.Ident:
 		c.Ind(lv)
 
+	case c.narrow(typ):
+		// the code synthesizer only queues bytes and words,
+		// a narrower int is loaded through its address
+		c.Addr(lv)
+		c.Ind(lv)
+
+	case lv.Reg > 0:
+		c.Queue(RegWord, lv.Reg, "")
+
 	case lv.Storage == types.Auto:
 		if typ == types.Typ[types.Char] {
 			c.Queue(AutoByte, lv.Addr, "")
@@ -904,9 +1151,9 @@ func (c *Emitter) Rval(lv LV) {
 
 	case lv.Storage == types.LocalStatic:
 		if typ == types.Typ[types.Char] {
-			c.Queue(StaticByte, lv.Addr, "")
+			c.QueueLabel(StaticByte, lv.Label)
 		} else {
-			c.Queue(StaticWord, lv.Addr, "")
+			c.QueueLabel(StaticWord, lv.Label)
 		}
 
 	default:
@@ -922,10 +1169,16 @@ func (c *Emitter) Rval(lv LV) {
 func (c *Emitter) Align(k, align int) {
 	c.Data()
 	for ; k%align != 0; k++ {
-		c.B.Defb(0)
+		c.B.Def(U8, 0)
 	}
 }
 
+// AlignData aligns the data to align, for the variable defined next.
+func (c *Emitter) AlignData(align int) {
+	c.Data()
+	c.B.Balign(align)
+}
+
 // Defs emits code for storing a string on a data segment.
 func (c *Emitter) Defs(s string) {
 	c.Data()
@@ -934,7 +1187,7 @@ func (c *Emitter) Defs(s string) {
 		case 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9':
 			c.B.Defc(int(s[i]))
 		default:
-			c.B.Defb(int(s[i]))
+			c.B.Def(U8, int(s[i]))
 		}
 	}
 }
@@ -949,7 +1202,7 @@ func (c *Emitter) Sub(p1, p2 types.Type, swapped bool) types.Type {
 	var rp types.Type = types.Typ[types.Int]
 	if isPointer(p1) && !isPointer(p2) {
 		if needScale(p1) {
-			if p := deref(p1); isRecord(p) {
+			if p := deref(p1); !c.wordSized(p) {
 				c.B.Scale2By(c.Sizeof(p))
 			} else {
 				c.B.Scale2()
@@ -962,7 +1215,7 @@ func (c *Emitter) Sub(p1, p2 types.Type, swapped bool) types.Type {
 
 	if needScale(p1) && needScale(p2) {
 		p := deref(p1)
-		if isRecord(p) {
+		if !c.wordSized(p) {
 			c.B.UnscaleBy(c.Sizeof(p))
 		} else {
 			c.B.Unscale()
@@ -973,33 +1226,95 @@ func (c *Emitter) Sub(p1, p2 types.Type, swapped bool) types.Type {
 }
 
 // Switch emits code for a switch statement.
-func (c *Emitter) Switch(vals, labs []int, dflt int) {
+func (c *Emitter) Switch(vals []int, labs []Label, dflt Label) {
 	ltbl := c.Label()
 	c.Text()
 	c.B.LdSwtch(ltbl)
 	c.B.CalSwtch()
 	c.Lab(ltbl)
-	c.B.Defw(len(vals))
+	c.B.Def(Word, len(vals))
 	for i := range vals {
 		c.B.Case(vals[i], labs[i])
 	}
 	c.B.Defl(dflt)
 }
 
-// BSS emits code to store things on the .bss
-func (c *Emitter) BSS(name string, length int, static bool) {
-	intSize := c.Int()
+// BSS emits code to store things on the .bss, aligned to align
+// if it isn't 0. The things are never aligned less than a word.
+func (c *Emitter) BSS(name string, length, align int, static bool) {
+	wordSize := c.Word()
+	if align > 0 && align < wordSize {
+		align = wordSize
+	}
 	c.Data()
 	if static {
-		c.B.Lbss(name, (length+intSize-1)/intSize*intSize)
+		c.B.Lbss(name, (length+wordSize-1)/wordSize*wordSize, align)
 	} else {
-		c.B.Gbss(name, (length+intSize-1)/intSize*intSize)
+		c.B.Gbss(name, (length+wordSize-1)/wordSize*wordSize, align)
 	}
 }
 
-// Labname returns a label name with an lprefix.
-func (c *Emitter) Labname(id int) string {
-	return fmt.Sprintf("%c%d", lprefix, id)
+// Labname returns the name of label l with the label prefix.
+func (c *Emitter) Labname(l Label) string {
+	if c.LabelPrefix != "" {
+		return fmt.Sprintf("%s%d", c.LabelPrefix, int(l))
+	}
+	return fmt.Sprintf("%c%d", lprefix, int(l))
+}
+
+// Width returns the operand width a backend uses for values of type T.
+func (c *Emitter) Width(T types.Type) Width {
+	switch {
+	case T == types.Typ[types.Char]:
+		return U8
+	case isPointer(T):
+		return Ptr
+	case T == types.Typ[types.Int] && c.Int() != c.Word():
+		return narrowInts[c.Int()]
+	}
+	return Word
+}
+
+var narrowInts = map[int]Width{2: S16, 4: S32}
+
+// narrow reports whether the values of type T are an int narrower
+// than a word.
+func (c *Emitter) narrow(T types.Type) bool {
+	switch c.Width(T) {
+	case S16, S32:
+		return true
+	}
+	return false
+}
+
+// SetInt makes int n bytes and long twice that, up to 8 bytes. n is the
+// size of a word or one of the IntSizes of the backend. The values
+// passed to the functions and returned by them are still words.
+func (c *Emitter) SetInt(n int) error {
+	s, ok := c.Sizes.(*types.StdSizes)
+	if !ok {
+		return fmt.Errorf("the sizes of the target can't be changed")
+	}
+	if n != c.Word() {
+		ok = false
+		for _, m := range c.IntSizes {
+			ok = ok || m == n
+		}
+		if !ok {
+			return fmt.Errorf("the target has no %d-bit int", 8*n)
+		}
+	}
+	long := 2 * n
+	if long > 8 {
+		long = 8
+	}
+	c.Sizes = &types.StdSizes{
+		WordSize: s.WordSize,
+		MaxAlign: s.MaxAlign,
+		IntSize:  int64(n),
+		LongSize: int64(long),
+	}
+	return nil
 }
 
 // Int returns the size of an integer.
@@ -1007,11 +1322,37 @@ func (c *Emitter) Int() int {
 	return c.Sizeof(types.Typ[types.Int])
 }
 
+// Word returns the size of a machine word, the size of the arguments
+// and of the stack slots of the locals.
+func (c *Emitter) Word() int {
+	return c.Pointer()
+}
+
 // Pointer returns the size of a pointer.
 func (c *Emitter) Pointer() int {
 	return c.Sizeof(types.NewPointer(types.Typ[types.Int], nil))
 }
 
+// TypeMacros returns the macros that <stddef.h> defines size_t, ptrdiff_t
+// and wchar_t with, __SIZE_TYPE__ and __SIZEOF_SIZE_T__ and the ones of
+// the others as GCC names them.
+func (c *Emitter) TypeMacros() [][2]string {
+	var macros [][2]string
+	for _, t := range []struct {
+		name string
+		typ  *types.Basic
+	}{
+		{"SIZE", c.StdTypes.SizeT()},
+		{"PTRDIFF", c.StdTypes.PtrdiffT()},
+		{"WCHAR", c.StdTypes.WcharT()},
+	} {
+		macros = append(macros,
+			[2]string{"__" + t.name + "_TYPE__", t.typ.String()},
+			[2]string{"__SIZEOF_" + t.name + "_T__", strconv.Itoa(c.Sizeof(t.typ))})
+	}
+	return macros
+}
+
 // Sizeof returns the sizze of a type.
 func (c *Emitter) Sizeof(T types.Type) int {
 	return int(c.Sizes.Sizeof(T))
@@ -0,0 +1,118 @@
+package arch
+
+import "text/scanner"
+
+// Inst is an instruction with its operands.
+type Inst struct {
+	Op, Operands string
+}
+
+// Folding describes how the instructions of a target are folded, so
+// a FoldSink can fold them without knowing the target.
+type Folding struct {
+	// Fold folds the instructions at the start of code, which runs up
+	// to the next label or for maxBlock instructions, into fewer
+	// instructions. It returns the instructions that replace the
+	// first n of code, n is 0 when they can't be folded.
+	Fold func(code []Inst) (folded []Inst, n int)
+}
+
+// FoldSink is a sink that folds the instructions of the code into
+// fewer ones before passing it on to another sink. The backends keep
+// the value being computed in one register, an operand of a binary
+// operator is pushed while the other one is computed and popped into
+// a second register to operate on. When the other operand is loaded
+// with one instruction, the operator can take the operand from where
+// it was loaded instead.
+//
+// The code is held until Flush, the positions and the comments stay
+// where they are, in front of the folded instructions.
+type FoldSink struct {
+	out  Sink
+	fold *Folding
+	code []sinkItem
+}
+
+// NewFoldSink returns a sink that folds the instructions described
+// by fold and passes the code on to out.
+func NewFoldSink(out Sink, fold *Folding) *FoldSink {
+	return &FoldSink{out: out, fold: fold}
+}
+
+// Label holds a label.
+func (f *FoldSink) Label(name string, inline bool) {
+	f.code = append(f.code, sinkItem{kind: sinkLabel, name: name, inline: inline})
+}
+
+// Inst holds an instruction.
+func (f *FoldSink) Inst(op, operands string) {
+	f.code = append(f.code, sinkItem{kind: sinkInst, name: op, operands: operands})
+}
+
+// Pos holds a source position.
+func (f *FoldSink) Pos(pos scanner.Position) {
+	f.code = append(f.code, sinkItem{kind: sinkPos, pos: pos})
+}
+
+// Comment holds a comment.
+func (f *FoldSink) Comment(text string) {
+	f.code = append(f.code, sinkItem{kind: sinkComment, name: text})
+}
+
+// Flush folds the instructions of the code held and passes it on.
+func (f *FoldSink) Flush() {
+	for f.improve() {
+	}
+
+	for _, x := range f.code {
+		switch {
+		case x.dead:
+		case x.kind == sinkLabel:
+			f.out.Label(x.name, x.inline)
+		case x.kind == sinkInst:
+			f.out.Inst(x.name, x.operands)
+		case x.kind == sinkPos:
+			f.out.Pos(x.pos)
+		case x.kind == sinkComment:
+			f.out.Comment(x.name)
+		}
+	}
+	f.code = f.code[:0]
+}
+
+// improve makes a pass over the code, it reports whether it folded
+// anything so another pass can fold what that uncovered.
+func (f *FoldSink) improve() bool {
+	changed := false
+	for i := range f.code {
+		x := f.code[i]
+		if x.dead || x.kind != sinkInst {
+			continue
+		}
+
+		// the instructions up to the next label
+		var code []Inst
+		var at []int
+		for j := i; j < len(f.code) && f.code[j].kind != sinkLabel && len(code) < maxBlock; j++ {
+			if y := f.code[j]; !y.dead && y.kind == sinkInst {
+				code = append(code, Inst{y.name, y.operands})
+				at = append(at, j)
+			}
+		}
+
+		folded, n := f.fold.Fold(code)
+		if n == 0 {
+			continue
+		}
+		for k, j := range at[:n] {
+			y := &f.code[j]
+			if k < len(folded) {
+				y.name, y.operands = folded[k].Op, folded[k].Operands
+			} else {
+				y.dead = true
+			}
+		}
+		changed = true
+	}
+	return changed
+}
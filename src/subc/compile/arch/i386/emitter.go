@@ -1,5 +1,11 @@
 // Package i386 contains code to
 // generate code for the x86 architecture.
+//
+// The arguments of a call are pushed as 4 byte words from the last to
+// the first, so the callee finds the first one at 8(%ebp), and the
+// caller pops them after the call. A function pointer is called through
+// %eax and the result is returned in %eax. The callee only preserves
+// %ebp and %esp.
 package i386
 
 import (
@@ -7,6 +13,7 @@ import (
 	"io"
 
 	"subc/compile/arch"
+	"subc/obj"
 	"subc/types"
 )
 
@@ -14,20 +21,86 @@ type Emitter struct {
 	*arch.Emitter
 }
 
+// NewEmitter returns an emitter that writes assembly text to w.
 func NewEmitter(w io.Writer) *arch.Emitter {
+	return New(arch.NewTextSink(w))
+}
+
+// New returns an emitter that sends the code it generates to out.
+func New(out arch.Sink) *arch.Emitter {
 	c := &Emitter{}
-	c.Emitter = &arch.Emitter{W: w, B: c, Sizes: &types.StdSizes{4, 4}}
+	c.Emitter = &arch.Emitter{Out: out, B: c, ByteOrder: obj.ByteOrder("i386"), Branches: branches, Sizes: &types.StdSizes{WordSize: 4, MaxAlign: 4}, StdTypes: types.StdTypes{Size: types.Int, Ptrdiff: types.Int, Wchar: types.Int}, IntSizes: []int{2}, Registers: regUsage}
 	return c.Emitter
 }
 
+// regUsage describes how the code uses the registers.
+var regUsage = &arch.Registers{Acc: "%eax", Frame: "%ebp", Stack: "%esp", Scratch: []string{"%ecx", "%edx", "%esi"}}
+
+// branches are the branch instructions, for improving them.
+var branches = &arch.Branches{
+	Jump:    "jmp",
+	Inverse: arch.X86Inverse,
+}
+
+// operand describes how an operand of some width is accessed.
+type operand struct {
+	suffix string // instruction suffix
+	acc    string // accumulator register
+	def    string // data directive
+	ext    string // instruction loading it sign extended to a word, if mov doesn't
+}
+
+var operands = map[arch.Width]operand{
+	arch.U8:   {"b", "al", ".byte", ""},
+	arch.S16:  {"w", "ax", ".short", "movswl"},
+	arch.U32:  {"l", "eax", ".long", ""},
+	arch.S32:  {"l", "eax", ".long", ""},
+	arch.Word: {"l", "eax", ".long", ""},
+	arch.Ptr:  {"l", "eax", ".long", ""},
+}
+
+func opnd(w arch.Width) operand {
+	o, ok := operands[w]
+	if !ok {
+		panic(fmt.Sprintf("unsupported operand width: %v", w))
+	}
+	return o
+}
+
+// load returns the instruction and the accumulator register that
+// an operand is loaded with.
+func (o operand) load() (string, string) {
+	if o.ext != "" {
+		return o.ext, "eax"
+	}
+	return "mov" + o.suffix, o.acc
+}
+
 func (c *Emitter) Data()           { c.Gen(".data") }
 func (c *Emitter) Text()           { c.Gen(".text") }
 func (c *Emitter) Prelude()        {}
 func (c *Emitter) Postlude()       {}
-func (c *Emitter) Public(s string) { c.Raw("\t.globl\t" + s + "\n") }
+func (c *Emitter) Public(s string) { c.Sgen("%s\t%s", ".globl", s) }
+
+// Ident records the quoted string s in the .comment section of the object.
+func (c *Emitter) Ident(s string) { c.Sgen("%s\t%s", ".ident", s) }
+
+// Section switches to the section of the text s, the linker
+// puts .text.hot and .text.unlikely apart from the rest of .text.
+func (c *Emitter) Section(s arch.Section) {
+	switch s {
+	case arch.HotText:
+		c.Gen(".section\t.text.hot,\"ax\",@progbits")
+	case arch.ColdText:
+		c.Gen(".section\t.text.unlikely,\"ax\",@progbits")
+	default:
+		c.Text()
+	}
+}
 
 func (c *Emitter) Synth(op string) {
 	n := c.Q.Value
+	l := c.Q.Label
 	s := c.Gsym(c.Q.Name)
 	switch c.Q.Type {
 	case arch.AddrAuto:
@@ -35,7 +108,7 @@ func (c *Emitter) Synth(op string) {
 		c.Sgen("%s\t%s, %%eax", op, "%ecx")
 
 	case arch.AddrStatic:
-		c.Lgen("%s\t$%c%d, %%eax", op, n)
+		c.Lgen("%s\t$%s, %%eax", op, l)
 
 	case arch.AddrGlobal:
 		c.Sgen("%s\t$%s, %%eax", op, s)
@@ -47,7 +120,7 @@ func (c *Emitter) Synth(op string) {
 		c.Ngen("%s\t%d(%%ebp), %%eax", op, n)
 
 	case arch.StaticWord:
-		c.Lgen("%s\t%c%d, %%eax", op, n)
+		c.Lgen("%s\t%s, %%eax", op, l)
 
 	case arch.GlobalWord:
 		c.Sgen("%s\t%s, %%eax", op, s)
@@ -74,19 +147,20 @@ func (c *Emitter) Load2() bool {
 	op := "movl"
 	opb := "movb"
 	n := c.Q.Value
+	l := c.Q.Label
 	s := c.Gsym(c.Q.Name)
 	switch c.Q.Type {
 	case arch.AddrAuto:
 		c.Ngen("%s\t%d(%%ebp), %%ecx", "leal", n)
 
 	case arch.AddrStatic:
-		c.Lgen("%s\t$%c%d, %%ecx", op, n)
+		c.Lgen("%s\t$%s, %%ecx", op, l)
 
 	case arch.AddrGlobal:
 		c.Sgen("%s\t$%s, %%ecx", op, s)
 
 	case arch.AddrLabel:
-		c.Lgen("%s\t$%c%d, %%ecx", op, n)
+		c.Lgen("%s\t$%s, %%ecx", op, l)
 
 	case arch.Literal:
 		c.Ngen("%s\t$%d, %%ecx", op, n)
@@ -100,10 +174,10 @@ func (c *Emitter) Load2() bool {
 
 	case arch.StaticByte:
 		c.Clear2()
-		c.Lgen("%s\t%c%d, %%cl", opb, n)
+		c.Lgen("%s\t%s, %%cl", opb, l)
 
 	case arch.StaticWord:
-		c.Lgen("%s\t%c%d, %%ecx", op, n)
+		c.Lgen("%s\t%s, %%ecx", op, l)
 
 	case arch.GlobalByte:
 		c.Clear2()
@@ -124,27 +198,94 @@ func (c *Emitter) Load2() bool {
 	return q == arch.Empty
 }
 
-func (c *Emitter) Lit(v int)     { c.Ngen("%s\t$%d, %%eax", "movl", v) }
-func (c *Emitter) Clear()        { c.Gen("xorl\t%eax, %eax") }
-func (c *Emitter) Clear2()       { c.Gen("xorl\t%ecx, %ecx") }
-func (c *Emitter) Ldgb(s string) { c.Sgen("%s\t%s, %%al", "movb", s) }
-func (c *Emitter) Ldgw(s string) { c.Sgen("%s\t%s, %%eax", "movl", s) }
-func (c *Emitter) Ldlb(n int)    { c.Ngen("%s\t%d(%%ebp), %%al", "movb", n) }
-func (c *Emitter) Ldlw(n int)    { c.Ngen("%s\t%d(%%ebp), %%eax", "movl", n) }
-func (c *Emitter) Ldsb(n int)    { c.Lgen("%s\t%c%d, %%al", "movb", n) }
-func (c *Emitter) Ldsw(n int)    { c.Lgen("%s\t%c%d, %%eax", "movl", n) }
-func (c *Emitter) Ldla(n int)    { c.Ngen("%s\t%d(%%ebp), %%eax", "leal", n) }
-func (c *Emitter) Ldsa(n int)    { c.Lgen("%s\t$%c%d, %%eax", "movl", n) }
-func (c *Emitter) Ldga(s string) { c.Sgen("%s\t$%s, %%eax", "movl", s) }
+func (c *Emitter) Lit(v int)         { c.Ngen("%s\t$%d, %%eax", "movl", v) }
+func (c *Emitter) Clear()            { c.Gen("xorl\t%eax, %eax") }
+func (c *Emitter) Clear2()           { c.Gen("xorl\t%ecx, %ecx") }
+func (c *Emitter) Ldla(n int)        { c.Ngen("%s\t%d(%%ebp), %%eax", "leal", n) }
+func (c *Emitter) Ldsa(n arch.Label) { c.Lgen("%s\t$%s, %%eax", "movl", n) }
+func (c *Emitter) Ldga(s string)     { c.Sgen("%s\t$%s, %%eax", "movl", s) }
 
-func (c *Emitter) Indb() {
-	c.Gen("movl\t%eax, %edx")
-	c.Clear()
-	c.Gen("movb\t(%edx), %al")
+func (c *Emitter) Ldg(w arch.Width, s string) {
+	inst, acc := opnd(w).load()
+	c.Ngen("%s\t%s, %%%s", inst, s, acc)
+}
+
+func (c *Emitter) Ldl(w arch.Width, n int) {
+	inst, acc := opnd(w).load()
+	c.Ngen("%s\t%d(%%ebp), %%%s", inst, n, acc)
+}
+
+func (c *Emitter) Lds(w arch.Width, l arch.Label) {
+	inst, acc := opnd(w).load()
+	c.Ngen("%s\t%s, %%%s", inst, c.Labname(l), acc)
+}
+
+func (c *Emitter) Ind(w arch.Width) {
+	o := opnd(w)
+	if w == arch.U8 {
+		c.Gen("movl\t%eax, %edx")
+		c.Clear()
+		c.Ngen("mov%s\t(%%edx), %%%s", o.suffix, o.acc)
+		return
+	}
+	inst, acc := o.load()
+	c.Ngen("%s\t(%%eax), %%%s", inst, acc)
+}
+
+// Index adds the index in %eax scaled by scale to the address in %ecx
+// with one lea.
+func (c *Emitter) Index(scale int) {
+	c.Ngen("leal\t(%%ecx,%%eax,%d), %%eax", scale)
+}
+
+// IndIndex loads from the address in %ecx plus the index in %eax
+// scaled by scale, addressing the element directly.
+func (c *Emitter) IndIndex(w arch.Width, scale int) {
+	o := opnd(w)
+	if w == arch.U8 {
+		c.Gen("movl\t%eax, %edx")
+		c.Clear()
+		c.Ngen("mov%s\t(%%ecx,%%edx,%d), %%%s", o.suffix, scale, o.acc)
+		return
+	}
+	inst, acc := o.load()
+	c.Ngen("%s\t(%%ecx,%%eax,%d), %%%s", inst, scale, acc)
+}
+
+// Step increments or decrements a variable in place, with inc or dec
+// for an integer and by adding the stride to a pointer.
+func (c *Emitter) Step(s arch.Step) {
+	var dst string
+	switch s.Kind {
+	case arch.StepInd:
+		dst = "(%eax)"
+		if !s.Pre {
+			dst = "(%edx)"
+		}
+	case arch.StepLocal:
+		dst = fmt.Sprintf("%d(%%ebp)", s.Addr)
+	case arch.StepStatic:
+		dst = c.Labname(s.Label)
+	case arch.StepGlobal:
+		dst = s.Name
+	}
+
+	if s.Stride == 0 {
+		op := "inc"
+		if s.Dec {
+			op = "dec"
+		}
+		c.Ngen("%s%s\t%s", op, opnd(s.W).suffix, dst)
+		return
+	}
+	op := "addl"
+	if s.Dec {
+		op = "subl"
+	}
+	c.Ngen("%s\t$%d, %s", op, s.Stride, dst)
 }
 
-func (c *Emitter) Indw()        { c.Gen("movl\t(%eax), %eax") }
-func (c *Emitter) Ldlab(id int) { c.Lgen("%s\t$%c%d, %%eax", "movl", id) }
+func (c *Emitter) Ldlab(id arch.Label) { c.Lgen("%s\t$%s, %%eax", "movl", id) }
 
 func (c *Emitter) Push()         { c.Gen("pushl\t%eax") }
 func (c *Emitter) PushLit(n int) { c.Ngen("%s\t$%d", "pushl", n) }
@@ -158,6 +299,29 @@ func (c *Emitter) Add() { c.Gen("addl\t%ecx, %eax") }
 func (c *Emitter) Mul() { c.Gen("imull\t%ecx, %eax") }
 func (c *Emitter) Sub() { c.Gen("subl\t%ecx, %eax") }
 
+// AddOvf, SubOvf and MulOvf do the arithmetic with the width of the int,
+// store the result through %edx and branch on the overflow flag that the
+// instruction leaves, the moves after it keep the flags.
+func (c *Emitter) AddOvf(w arch.Width) { c.ovf("add", w) }
+func (c *Emitter) SubOvf(w arch.Width) { c.ovf("sub", w) }
+func (c *Emitter) MulOvf(w arch.Width) { c.ovf("imul", w) }
+
+// second is the second register of the width of the accumulator.
+var second = map[string]string{"eax": "ecx", "ax": "cx"}
+
+func (c *Emitter) ovf(inst string, w arch.Width) {
+	o := opnd(w)
+	c.Ngen("%s%s\t%%%s, %%%s", inst, o.suffix, second[o.acc], o.acc)
+	c.Ngen("mov%s\t%%%s, (%%edx)", o.suffix, o.acc)
+	c.Gen("movl\t$0, %eax")
+	lab := c.Label()
+	c.Lgen("%s\t%s", "jno", lab)
+	c.Gen("movl\t$1, %eax")
+	c.Lab(lab)
+}
+
+// Div divides with idiv, which truncates the quotient toward zero
+// and leaves the remainder with the sign of the dividend.
 func (c *Emitter) Div() {
 	c.Gen("cdq")
 	c.Gen("idivl\t%ecx")
@@ -180,7 +344,7 @@ func (c *Emitter) Cmp(inst string) {
 	} else {
 		c.Synth("cmpl")
 	}
-	c.Lgen("%s\t%c%d", inst, lab)
+	c.Lgen("%s\t%s", inst, lab)
 	c.Gen("incl\t%edx")
 	c.Lab(lab)
 	c.Gen("movl\t%edx, %eax")
@@ -197,7 +361,7 @@ func (c *Emitter) Ugt() { c.Cmp("jbe") }
 func (c *Emitter) Ule() { c.Cmp("ja") }
 func (c *Emitter) Uge() { c.Cmp("jb") }
 
-func (c *Emitter) BrCond(i string, n int) {
+func (c *Emitter) BrCond(i string, n arch.Label) {
 	lab := c.Label()
 	if c.Q.Type == arch.Empty {
 		c.Pop2()
@@ -205,25 +369,42 @@ func (c *Emitter) BrCond(i string, n int) {
 	} else {
 		c.Synth("cmpl")
 	}
-	c.Lgen("%s\t%c%d", i, lab)
-	c.Lgen("%s\t%c%d", "jmp", n)
+	c.Lgen("%s\t%s", i, lab)
+	c.Lgen("%s\t%s", "jmp", n)
 	c.Lab(lab)
 }
 
-func (c *Emitter) BrEq(n int)  { c.BrCond("je", n) }
-func (c *Emitter) BrNe(n int)  { c.BrCond("jne", n) }
-func (c *Emitter) BrLt(n int)  { c.BrCond("jl", n) }
-func (c *Emitter) BrGt(n int)  { c.BrCond("jg", n) }
-func (c *Emitter) BrLe(n int)  { c.BrCond("jle", n) }
-func (c *Emitter) BrGe(n int)  { c.BrCond("jge", n) }
-func (c *Emitter) BrUlt(n int) { c.BrCond("jb", n) }
-func (c *Emitter) BrUgt(n int) { c.BrCond("ja", n) }
-func (c *Emitter) BrUle(n int) { c.BrCond("jbe", n) }
-func (c *Emitter) BrUge(n int) { c.BrCond("jae", n) }
+func (c *Emitter) BrEq(n arch.Label)  { c.BrCond("je", n) }
+func (c *Emitter) BrNe(n arch.Label)  { c.BrCond("jne", n) }
+func (c *Emitter) BrLt(n arch.Label)  { c.BrCond("jl", n) }
+func (c *Emitter) BrGt(n arch.Label)  { c.BrCond("jg", n) }
+func (c *Emitter) BrLe(n arch.Label)  { c.BrCond("jle", n) }
+func (c *Emitter) BrGe(n arch.Label)  { c.BrCond("jge", n) }
+func (c *Emitter) BrUlt(n arch.Label) { c.BrCond("jb", n) }
+func (c *Emitter) BrUgt(n arch.Label) { c.BrCond("ja", n) }
+func (c *Emitter) BrUle(n arch.Label) { c.BrCond("jbe", n) }
+func (c *Emitter) BrUge(n arch.Label) { c.BrCond("jae", n) }
 
 func (c *Emitter) Neg() { c.Gen("negl\t%eax") }
 func (c *Emitter) Not() { c.Gen("notl\t%eax") }
 
+// Ext extends the value of width w in the accumulator to a word.
+func (c *Emitter) Ext(w arch.Width) {
+	switch w {
+	case arch.U8:
+		c.Gen("movzbl\t%al, %eax")
+	case arch.S8:
+		c.Gen("movsbl\t%al, %eax")
+	case arch.U16:
+		c.Gen("movzwl\t%ax, %eax")
+	case arch.S16:
+		c.Gen("movswl\t%ax, %eax")
+	case arch.U32, arch.S32, arch.Word, arch.Ptr:
+	default:
+		panic(fmt.Sprintf("unsupported operand width: %v", w))
+	}
+}
+
 func (c *Emitter) LogNot() {
 	c.Gen("negl\t%eax")
 	c.Gen("sbbl\t%eax, %eax")
@@ -259,62 +440,110 @@ func (c *Emitter) Bool() {
 	c.Gen("negl\t%eax")
 }
 
-func (c *Emitter) Ldinc()                { c.Gen("movl\t%eax, %edx") }
-func (c *Emitter) Inc1pi(v int)          { c.Ngen("%s\t$%d, (%%eax)", "addl", v) }
-func (c *Emitter) Dec1pi(v int)          { c.Ngen("%s\t$%d, (%%eax)", "subl", v) }
-func (c *Emitter) Inc2pi(v int)          { c.Ngen("%s\t$%d, (%%edx)", "addl", v) }
-func (c *Emitter) Dec2pi(v int)          { c.Ngen("%s\t$%d, (%%edx)", "subl", v) }
-func (c *Emitter) Incpl(a int, v int)    { c.Ngen2("%s\t$%d, %d(%%ebp)", "addl", v, a) }
-func (c *Emitter) Decpl(a int, v int)    { c.Ngen2("%s\t$%d, %d(%%ebp)", "subl", v, a) }
-func (c *Emitter) Incps(a int, v int)    { c.Lgen2("addl\t$%d, %c%d", v, a) }
-func (c *Emitter) Decps(a int, v int)    { c.Lgen2("subl\t$%d, %c%d", v, a) }
-func (c *Emitter) Incpg(s string, v int) { c.Sgen2("%s\t$%d, %s", "addl", v, s) }
-func (c *Emitter) Decpg(s string, v int) { c.Sgen2("%s\t$%d, %s", "subl", v, s) }
-func (c *Emitter) Inc1iw()               { c.Ngen("%s\t(%%eax)", "incl") }
-func (c *Emitter) Dec1iw()               { c.Ngen("%s\t(%%eax)", "decl") }
-func (c *Emitter) Inc2iw()               { c.Ngen("%s\t(%%edx)", "incl") }
-func (c *Emitter) Dec2iw()               { c.Ngen("%s\t(%%edx)", "decl") }
-func (c *Emitter) Inclw(a int)           { c.Ngen("%s\t%d(%%ebp)", "incl", a) }
-func (c *Emitter) Declw(a int)           { c.Ngen("%s\t%d(%%ebp)", "decl", a) }
-func (c *Emitter) Incsw(a int)           { c.Lgen("%s\t%c%d", "incl", a) }
-func (c *Emitter) Decsw(a int)           { c.Lgen("%s\t%c%d", "decl", a) }
-func (c *Emitter) Incgw(s string)        { c.Sgen("%s\t%s", "incl", s) }
-func (c *Emitter) Decgw(s string)        { c.Sgen("%s\t%s", "decl", s) }
-func (c *Emitter) Inc1ib()               { c.Ngen("%s\t(%%eax)", "incb", 0) }
-func (c *Emitter) Dec1ib()               { c.Ngen("%s\t(%%eax)", "decb", 0) }
-func (c *Emitter) Inc2ib()               { c.Ngen("%s\t(%%edx)", "incb", 0) }
-func (c *Emitter) Dec2ib()               { c.Ngen("%s\t(%%edx)", "decb", 0) }
-func (c *Emitter) Inclb(a int)           { c.Ngen("%s\t%d(%%ebp)", "incb", a) }
-func (c *Emitter) Declb(a int)           { c.Ngen("%s\t%d(%%ebp)", "decb", a) }
-func (c *Emitter) Incsb(a int)           { c.Lgen("%s\t%c%d", "incb", a) }
-func (c *Emitter) Decsb(a int)           { c.Lgen("%s\t%c%d", "decb", a) }
-func (c *Emitter) Incgb(s string)        { c.Sgen("%s\t%s", "incb", s) }
-func (c *Emitter) Decgb(s string)        { c.Sgen("%s\t%s", "decb", s) }
-
-func (c *Emitter) Br(how string, n int) {
+func (c *Emitter) Ldinc() { c.Gen("movl\t%eax, %edx") }
+
+func (c *Emitter) Br(how string, n arch.Label) {
 	lab := c.Label()
 	c.Gen("orl\t%eax, %eax")
-	c.Lgen("%s\t%c%d", how, lab)
-	c.Lgen("%s\t%c%d", "jmp", n)
+	c.Lgen("%s\t%s", how, lab)
+	c.Lgen("%s\t%s", "jmp", n)
 	c.Lab(lab)
 }
 
-func (c *Emitter) BrTrue(n int)  { c.Br("jz", n) }
-func (c *Emitter) BrFalse(n int) { c.Br("jnz", n) }
-func (c *Emitter) Jump(n int)    { c.Lgen("%s\t%c%d", "jmp", n) }
-func (c *Emitter) LdSwtch(n int) { c.Lgen("%s\t$%c%d, %%edx", "movl", n) }
-func (c *Emitter) CalSwtch()     { c.Gen("jmp\tswitch") }
-func (c *Emitter) Case(v, l int) { c.Lgen2(".long\t%d, %c%d", v, l) }
+func (c *Emitter) BrTrue(n arch.Label)      { c.Br("jz", n) }
+func (c *Emitter) BrFalse(n arch.Label)     { c.Br("jnz", n) }
+func (c *Emitter) Jump(n arch.Label)        { c.Lgen("%s\t%s", "jmp", n) }
+func (c *Emitter) Jumpr()                   { c.Gen("jmp\t*%eax") }
+func (c *Emitter) LdSwtch(n arch.Label)     { c.Lgen("%s\t$%s, %%edx", "movl", n) }
+func (c *Emitter) CalSwtch()                { c.Gen("jmp\tswitch") }
+func (c *Emitter) Case(v int, l arch.Label) { c.Lgen2(".long\t%d, %s", v, l) }
+
+func (c *Emitter) PopPtr() { c.Gen("popl\t%edx") }
+
+func (c *Emitter) Stori(w arch.Width) {
+	o := opnd(w)
+	c.Ngen("mov%s\t%%%s, (%%edx)", o.suffix, o.acc)
+}
+
+func (c *Emitter) Storl(w arch.Width, n int) {
+	o := opnd(w)
+	c.Ngen("mov%s\t%%%s, %d(%%ebp)", o.suffix, o.acc, n)
+}
+
+func (c *Emitter) Stors(w arch.Width, l arch.Label) {
+	o := opnd(w)
+	c.Ngen("mov%s\t%%%s, %s", o.suffix, o.acc, c.Labname(l))
+}
+
+func (c *Emitter) Storg(w arch.Width, s string) {
+	o := opnd(w)
+	c.Ngen("mov%s\t%%%s, %s", o.suffix, o.acc, s)
+}
+
+// unroll is the most words that Copy and Zero move without a loop.
+const unroll = 8
+
+// Copy copies size bytes from the address in %eax to the address in %edx
+// a word at a time and the bytes left over one by one, with a loop when
+// there are many words. The x86 moves what isn't aligned too, so align
+// doesn't matter. The address of the copy is left in %eax.
+func (c *Emitter) Copy(size, align int) {
+	done := 0
+	if n := size / 4; n > unroll {
+		// %esi belongs to the caller, so it is saved around the loop
+		loop := c.Label()
+		c.Gen("pushl\t%esi")
+		c.Ngen("movl\t$%d, %%ecx", n)
+		c.Lab(loop)
+		c.Gen("movl\t(%eax), %esi")
+		c.Gen("movl\t%esi, (%edx)")
+		c.Gen("addl\t$4, %eax")
+		c.Gen("addl\t$4, %edx")
+		c.Gen("decl\t%ecx")
+		c.Lgen("%s\t%s", "jnz", loop)
+		c.Gen("popl\t%esi")
+		done = n * 4
+	}
+	for i := done; i+4 <= size; i += 4 {
+		c.Ngen("movl\t%d(%%eax), %%ecx", i-done)
+		c.Ngen("movl\t%%ecx, %d(%%edx)", i-done)
+	}
+	for i := size / 4 * 4; i < size; i++ {
+		c.Ngen("movb\t%d(%%eax), %%cl", i-done)
+		c.Ngen("movb\t%%cl, %d(%%edx)", i-done)
+	}
+	if done != 0 {
+		c.Ngen("leal\t%d(%%edx), %%eax", -done)
+	} else {
+		c.Gen("movl\t%edx, %eax")
+	}
+}
 
-func (c *Emitter) PopPtr()         { c.Gen("popl\t%edx") }
-func (c *Emitter) Storib()         { c.Ngen("%s\t%%al, (%%edx)", "movb") }
-func (c *Emitter) Storiw()         { c.Ngen("%s\t%%eax, (%%edx)", "movl") }
-func (c *Emitter) Storlb(n int)    { c.Ngen("%s\t%%al, %d(%%ebp)", "movb", n) }
-func (c *Emitter) Storlw(n int)    { c.Ngen("%s\t%%eax, %d(%%ebp)", "movl", n) }
-func (c *Emitter) Storsb(n int)    { c.Lgen("%s\t%%al, %c%d", "movb", n) }
-func (c *Emitter) Storsw(n int)    { c.Lgen("%s\t%%eax, %c%d", "movl", n) }
-func (c *Emitter) Storgb(s string) { c.Sgen("%s\t%%al, %s", "movb", s) }
-func (c *Emitter) Storgw(s string) { c.Sgen("%s\t%%eax, %s", "movl", s) }
+// Zero clears size bytes at the address in %eax like Copy copies them,
+// and leaves the address in %eax.
+func (c *Emitter) Zero(size, align int) {
+	done := 0
+	c.Gen("xorl\t%ecx, %ecx")
+	if n := size / 4; n > unroll {
+		loop := c.Label()
+		c.Ngen("movl\t$%d, %%edx", n)
+		c.Lab(loop)
+		c.Gen("movl\t%ecx, (%eax)")
+		c.Gen("addl\t$4, %eax")
+		c.Gen("decl\t%edx")
+		c.Lgen("%s\t%s", "jnz", loop)
+		done = n * 4
+	}
+	for i := done; i+4 <= size; i += 4 {
+		c.Ngen("movl\t%%ecx, %d(%%eax)", i-done)
+	}
+	for i := size / 4 * 4; i < size; i++ {
+		c.Ngen("movb\t%%cl, %d(%%eax)", i-done)
+	}
+	if done != 0 {
+		c.Ngen("subl\t$%d, %%eax", done)
+	}
+}
 
 func (c *Emitter) Initlw(v int, a int) { c.Ngen2("%s\t$%d, %d(%%ebp)", "movl", v, a) }
 func (c *Emitter) Call(s string)       { c.Sgen("%s\t%s", "call", s) }
@@ -331,11 +560,30 @@ func (c *Emitter) Exit() {
 	c.Gen("ret")
 }
 
-func (c *Emitter) Defb(v int)           { c.Ngen("%s\t%d", ".byte", v) }
-func (c *Emitter) Defw(v int)           { c.Ngen("%s\t%d", ".long", v) }
-func (c *Emitter) Defp(v int)           { c.Ngen("%s\t%d", ".long", v) }
-func (c *Emitter) Defl(v int)           { c.Lgen("%s\t%c%d", ".long", v) }
-func (c *Emitter) Defc(ch int)          { c.Ngen("%s\t'%c'", ".byte", ch) }
-func (c *Emitter) Gbss(s string, z int) { c.Ngen(".comm\t%s,%d", s, z) }
-func (c *Emitter) Lbss(s string, z int) { c.Ngen(".lcomm\t%s,%d", s, z) }
-func (c *Emitter) Align()               {}
+func (c *Emitter) Def(w arch.Width, v int) { c.Ngen("%s	%d", opnd(w).def, v) }
+func (c *Emitter) Defa(s string)           { c.Sgen("%s\t%s", ".long", s) }
+func (c *Emitter) Defl(l arch.Label)       { c.Lgen("%s\t%s", ".long", l) }
+func (c *Emitter) Defc(ch int)             { c.Ngen("%s\t'%c'", ".byte", ch) }
+func (c *Emitter) Align()                  {}
+
+// Gbss and Lbss give the alignment of an aligned variable as the third
+// argument of .comm, a local one is declared with .local since .lcomm
+// doesn't take an alignment.
+func (c *Emitter) Gbss(s string, z, align int) {
+	if align > 0 {
+		c.Ngen(".comm\t%s,%d,%d", s, z, align)
+		return
+	}
+	c.Ngen(".comm\t%s,%d", s, z)
+}
+
+func (c *Emitter) Lbss(s string, z, align int) {
+	if align > 0 {
+		c.Ngen(".local\t%s", s)
+		c.Ngen(".comm\t%s,%d,%d", s, z, align)
+		return
+	}
+	c.Ngen(".lcomm\t%s,%d", s, z)
+}
+
+func (c *Emitter) Balign(n int) { c.Ngen(".balign\t%d", n) }
@@ -0,0 +1,570 @@
+// Package i8086 contains code to
+// generate code for the 8086 in real mode.
+//
+// The code is for the tiny model, the code, the data and the stack
+// are in one segment of 64K and CS, DS, ES and SS all point at it, so a
+// pointer is a 2 byte offset into the segment. The arguments of a call
+// are pushed as 2 byte words from the last to the first, so the callee
+// finds the first one at 4(%bp), and the caller pops them after the
+// call. A function pointer is called through %ax and the result is
+// returned in %ax. The callee only preserves %bp and %sp.
+//
+// The 8086 can only address memory through %bx, %si, %di and %bp, so
+// the pointers are moved to %bx to load and store through them. The
+// assembly is for GNU as with .code16, and .arch i8086 makes it reject
+// the instructions that came with the later processors.
+package i8086
+
+import (
+	"fmt"
+	"io"
+
+	"subc/compile/arch"
+	"subc/obj"
+	"subc/types"
+)
+
+type Emitter struct {
+	*arch.Emitter
+}
+
+// NewEmitter returns an emitter that writes assembly text to w.
+func NewEmitter(w io.Writer) *arch.Emitter {
+	return New(arch.NewTextSink(w))
+}
+
+// New returns an emitter that sends the code it generates to out.
+func New(out arch.Sink) *arch.Emitter {
+	c := &Emitter{}
+	c.Emitter = &arch.Emitter{Out: out, B: c, ByteOrder: obj.ByteOrder("8086"), Branches: branches, Sizes: &types.StdSizes{WordSize: 2, MaxAlign: 2}, StdTypes: types.StdTypes{Size: types.Int, Ptrdiff: types.Int, Wchar: types.Int}, Registers: regUsage}
+	return c.Emitter
+}
+
+// regUsage describes how the code uses the registers.
+var regUsage = &arch.Registers{Acc: "%ax", Frame: "%bp", Stack: "%sp", Scratch: []string{"%bx", "%cx", "%dx", "%si", "%di"}}
+
+// branches are the branch instructions, for improving them. The
+// conditional jumps of the 8086 only reach 128 bytes, the assembler
+// turns the ones that go further into the inverse over a jmp.
+var branches = &arch.Branches{
+	Jump:    "jmp",
+	Inverse: arch.X86Inverse,
+}
+
+// operand describes how an operand of some width is accessed.
+type operand struct {
+	suffix string // instruction suffix
+	acc    string // accumulator register
+	def    string // data directive
+}
+
+var operands = map[arch.Width]operand{
+	arch.U8:   {"b", "al", ".byte"},
+	arch.Word: {"w", "ax", ".short"},
+	arch.Ptr:  {"w", "ax", ".short"},
+}
+
+func opnd(w arch.Width) operand {
+	o, ok := operands[w]
+	if !ok {
+		panic(fmt.Sprintf("unsupported operand width: %v", w))
+	}
+	return o
+}
+
+func (c *Emitter) Data() { c.Gen(".data") }
+func (c *Emitter) Text() { c.Gen(".text") }
+
+func (c *Emitter) Prelude() {
+	c.Gen(".code16")
+	c.Gen(".arch\ti8086")
+}
+
+func (c *Emitter) Postlude()       {}
+func (c *Emitter) Public(s string) { c.Sgen("%s\t%s", ".globl", s) }
+
+// Ident records the quoted string s in the .comment section of the object.
+func (c *Emitter) Ident(s string) { c.Sgen("%s\t%s", ".ident", s) }
+
+// Section keeps the code of all the functions in .text, a flat image
+// starts with the code of crt0 and the linker would put .text.hot and
+// .text.unlikely before it.
+func (c *Emitter) Section(s arch.Section) { c.Text() }
+
+func (c *Emitter) Synth(op string) {
+	n := c.Q.Value
+	l := c.Q.Label
+	s := c.Gsym(c.Q.Name)
+	switch c.Q.Type {
+	case arch.AddrAuto:
+		c.Ngen("%s\t%d(%%bp), %%cx", "leaw", n)
+		c.Sgen("%s\t%s, %%ax", op, "%cx")
+
+	case arch.AddrStatic:
+		c.Lgen("%s\t$%s, %%ax", op, l)
+
+	case arch.AddrGlobal:
+		c.Sgen("%s\t$%s, %%ax", op, s)
+
+	case arch.Literal:
+		c.Ngen("%s\t$%d, %%ax", op, n)
+
+	case arch.AutoWord:
+		c.Ngen("%s\t%d(%%bp), %%ax", op, n)
+
+	case arch.StaticWord:
+		c.Lgen("%s\t%s, %%ax", op, l)
+
+	case arch.GlobalWord:
+		c.Sgen("%s\t%s, %%ax", op, s)
+
+	case arch.AutoByte:
+		fallthrough
+	case arch.StaticByte:
+		fallthrough
+	case arch.GlobalByte:
+		c.Load2()
+		c.Ngen("%s\t%%cx, %%ax", op)
+
+	case arch.Empty:
+		c.Pop2()
+		c.Sgen("%s\t%s, %%ax", op, "%cx")
+
+	default:
+		panic(fmt.Sprint("bad type in synth: ", c.Q.Type))
+	}
+	c.Q.Type = arch.Empty
+}
+
+func (c *Emitter) Load2() bool {
+	op := "movw"
+	opb := "movb"
+	n := c.Q.Value
+	l := c.Q.Label
+	s := c.Gsym(c.Q.Name)
+	switch c.Q.Type {
+	case arch.AddrAuto:
+		c.Ngen("%s\t%d(%%bp), %%cx", "leaw", n)
+
+	case arch.AddrStatic:
+		c.Lgen("%s\t$%s, %%cx", op, l)
+
+	case arch.AddrGlobal:
+		c.Sgen("%s\t$%s, %%cx", op, s)
+
+	case arch.AddrLabel:
+		c.Lgen("%s\t$%s, %%cx", op, l)
+
+	case arch.Literal:
+		c.Ngen("%s\t$%d, %%cx", op, n)
+
+	case arch.AutoByte:
+		c.Clear2()
+		c.Ngen("%s\t%d(%%bp), %%cl", opb, n)
+
+	case arch.AutoWord:
+		c.Ngen("%s\t%d(%%bp), %%cx", op, n)
+
+	case arch.StaticByte:
+		c.Clear2()
+		c.Lgen("%s\t%s, %%cl", opb, l)
+
+	case arch.StaticWord:
+		c.Lgen("%s\t%s, %%cx", op, l)
+
+	case arch.GlobalByte:
+		c.Clear2()
+		c.Sgen("%s\t%s, %%cl", opb, s)
+
+	case arch.GlobalWord:
+		c.Sgen("%s\t%s, %%cx", op, s)
+
+	case arch.Empty:
+		c.Pop2()
+
+	default:
+		panic(fmt.Sprint("bad type in load:", c.Q.Type))
+	}
+
+	q := c.Q.Type
+	c.Q.Type = arch.Empty
+	return q == arch.Empty
+}
+
+func (c *Emitter) Lit(v int)         { c.Ngen("%s\t$%d, %%ax", "movw", v) }
+func (c *Emitter) Clear()            { c.Gen("xorw\t%ax, %ax") }
+func (c *Emitter) Clear2()           { c.Gen("xorw\t%cx, %cx") }
+func (c *Emitter) Ldla(n int)        { c.Ngen("%s\t%d(%%bp), %%ax", "leaw", n) }
+func (c *Emitter) Ldsa(n arch.Label) { c.Lgen("%s\t$%s, %%ax", "movw", n) }
+func (c *Emitter) Ldga(s string)     { c.Sgen("%s\t$%s, %%ax", "movw", s) }
+
+func (c *Emitter) Ldg(w arch.Width, s string) {
+	o := opnd(w)
+	c.Ngen("mov%s\t%s, %%%s", o.suffix, s, o.acc)
+}
+
+func (c *Emitter) Ldl(w arch.Width, n int) {
+	o := opnd(w)
+	c.Ngen("mov%s\t%d(%%bp), %%%s", o.suffix, n, o.acc)
+}
+
+func (c *Emitter) Lds(w arch.Width, l arch.Label) {
+	o := opnd(w)
+	c.Ngen("mov%s\t%s, %%%s", o.suffix, c.Labname(l), o.acc)
+}
+
+func (c *Emitter) Ind(w arch.Width) {
+	o := opnd(w)
+	c.Gen("movw\t%ax, %bx")
+	if w == arch.U8 {
+		c.Clear()
+	}
+	c.Ngen("mov%s\t(%%bx), %%%s", o.suffix, o.acc)
+}
+
+// Index adds the index in %ax scaled by scale to the address in %cx,
+// the 8086 has no scaled index addressing so the index is shifted
+// left by one bit at a time.
+func (c *Emitter) Index(scale int) {
+	for ; scale > 1; scale >>= 1 {
+		c.Gen("shlw\t$1, %ax")
+	}
+	c.Gen("addw\t%cx, %ax")
+}
+
+// IndIndex loads from the address in %cx plus the index in %ax
+// scaled by scale.
+func (c *Emitter) IndIndex(w arch.Width, scale int) {
+	c.Index(scale)
+	c.Ind(w)
+}
+
+// Step increments or decrements a variable in place, with inc or dec
+// for an integer and by adding the stride to a pointer. The pointer of
+// an indirect step is in %bx, where Ldinc or the load before a step
+// that is done after the load leave it.
+func (c *Emitter) Step(s arch.Step) {
+	var dst string
+	switch s.Kind {
+	case arch.StepInd:
+		if s.Pre {
+			c.Gen("movw\t%ax, %bx")
+		}
+		dst = "(%bx)"
+	case arch.StepLocal:
+		dst = fmt.Sprintf("%d(%%bp)", s.Addr)
+	case arch.StepStatic:
+		dst = c.Labname(s.Label)
+	case arch.StepGlobal:
+		dst = s.Name
+	}
+
+	if s.Stride == 0 {
+		op := "inc"
+		if s.Dec {
+			op = "dec"
+		}
+		c.Ngen("%s%s\t%s", op, opnd(s.W).suffix, dst)
+		return
+	}
+	op := "addw"
+	if s.Dec {
+		op = "subw"
+	}
+	c.Ngen("%s\t$%d, %s", op, s.Stride, dst)
+}
+
+func (c *Emitter) Ldlab(id arch.Label) { c.Lgen("%s\t$%s, %%ax", "movw", id) }
+
+func (c *Emitter) Push() { c.Gen("pushw\t%ax") }
+
+// PushLit pushes a literal through %ax, the 8086 can't push an immediate.
+func (c *Emitter) PushLit(n int) {
+	c.Lit(n)
+	c.Push()
+}
+
+func (c *Emitter) Pop2() { c.Gen("popw\t%cx") }
+func (c *Emitter) Swap() { c.Gen("xchgw\t%ax, %cx") }
+
+func (c *Emitter) And() { c.Synth("andw") }
+func (c *Emitter) Or()  { c.Synth("orw") }
+func (c *Emitter) Xor() { c.Synth("xorw") }
+func (c *Emitter) Add() { c.Gen("addw\t%cx, %ax") }
+func (c *Emitter) Mul() { c.Gen("imulw\t%cx") }
+func (c *Emitter) Sub() { c.Gen("subw\t%cx, %ax") }
+
+// AddOvf, SubOvf and MulOvf store the result through %bx and branch on
+// the overflow flag that the instruction leaves, the moves after it keep
+// the flags. The imul of the 8086 sets it when the product doesn't fit
+// in %ax.
+func (c *Emitter) AddOvf(w arch.Width) { c.ovf("addw\t%cx, %ax") }
+func (c *Emitter) SubOvf(w arch.Width) { c.ovf("subw\t%cx, %ax") }
+func (c *Emitter) MulOvf(w arch.Width) { c.ovf("imulw\t%cx") }
+
+func (c *Emitter) ovf(inst string) {
+	c.Gen(inst)
+	c.Gen("movw\t%ax, (%bx)")
+	c.Gen("movw\t$0, %ax")
+	lab := c.Label()
+	c.Lgen("%s\t%s", "jno", lab)
+	c.Gen("movw\t$1, %ax")
+	c.Lab(lab)
+}
+
+// Div divides with idiv, which truncates the quotient toward zero
+// and leaves the remainder with the sign of the dividend.
+func (c *Emitter) Div() {
+	c.Gen("cwd")
+	c.Gen("idivw\t%cx")
+}
+
+func (c *Emitter) Mod() {
+	c.Div()
+	c.Gen("movw\t%dx, %ax")
+}
+
+func (c *Emitter) Shl() { c.Gen("shlw\t%cl, %ax") }
+func (c *Emitter) Shr() { c.Gen("sarw\t%cl, %ax") }
+
+func (c *Emitter) Cmp(inst string) {
+	lab := c.Label()
+	c.Gen("xorw\t%dx, %dx")
+	if c.Q.Type == arch.Empty {
+		c.Pop2()
+		c.Gen("cmpw\t%ax, %cx")
+	} else {
+		c.Synth("cmpw")
+	}
+	c.Lgen("%s\t%s", inst, lab)
+	c.Gen("incw\t%dx")
+	c.Lab(lab)
+	c.Gen("movw\t%dx, %ax")
+}
+
+func (c *Emitter) Eq()  { c.Cmp("jne") }
+func (c *Emitter) Ne()  { c.Cmp("je") }
+func (c *Emitter) Lt()  { c.Cmp("jge") }
+func (c *Emitter) Gt()  { c.Cmp("jle") }
+func (c *Emitter) Le()  { c.Cmp("jg") }
+func (c *Emitter) Ge()  { c.Cmp("jl") }
+func (c *Emitter) Ult() { c.Cmp("jae") }
+func (c *Emitter) Ugt() { c.Cmp("jbe") }
+func (c *Emitter) Ule() { c.Cmp("ja") }
+func (c *Emitter) Uge() { c.Cmp("jb") }
+
+func (c *Emitter) BrCond(i string, n arch.Label) {
+	lab := c.Label()
+	if c.Q.Type == arch.Empty {
+		c.Pop2()
+		c.Gen("cmpw\t%ax, %cx")
+	} else {
+		c.Synth("cmpw")
+	}
+	c.Lgen("%s\t%s", i, lab)
+	c.Lgen("%s\t%s", "jmp", n)
+	c.Lab(lab)
+}
+
+func (c *Emitter) BrEq(n arch.Label)  { c.BrCond("je", n) }
+func (c *Emitter) BrNe(n arch.Label)  { c.BrCond("jne", n) }
+func (c *Emitter) BrLt(n arch.Label)  { c.BrCond("jl", n) }
+func (c *Emitter) BrGt(n arch.Label)  { c.BrCond("jg", n) }
+func (c *Emitter) BrLe(n arch.Label)  { c.BrCond("jle", n) }
+func (c *Emitter) BrGe(n arch.Label)  { c.BrCond("jge", n) }
+func (c *Emitter) BrUlt(n arch.Label) { c.BrCond("jb", n) }
+func (c *Emitter) BrUgt(n arch.Label) { c.BrCond("ja", n) }
+func (c *Emitter) BrUle(n arch.Label) { c.BrCond("jbe", n) }
+func (c *Emitter) BrUge(n arch.Label) { c.BrCond("jae", n) }
+
+func (c *Emitter) Neg() { c.Gen("negw\t%ax") }
+func (c *Emitter) Not() { c.Gen("notw\t%ax") }
+
+// Ext extends the value of width w in the accumulator to a word.
+func (c *Emitter) Ext(w arch.Width) {
+	switch w {
+	case arch.U8:
+		c.Gen("xorb\t%ah, %ah")
+	case arch.S8:
+		c.Gen("cbw")
+	case arch.U16, arch.S16, arch.Word, arch.Ptr:
+	default:
+		panic(fmt.Sprintf("unsupported operand width: %v", w))
+	}
+}
+
+func (c *Emitter) LogNot() {
+	c.Gen("negw\t%ax")
+	c.Gen("sbbw\t%ax, %ax")
+	c.Gen("incw\t%ax")
+}
+
+func (c *Emitter) Scale()   { c.Gen("shlw\t$1, %ax") }
+func (c *Emitter) Scale2()  { c.Gen("shlw\t$1, %cx") }
+func (c *Emitter) Unscale() { c.Gen("shrw\t$1, %ax") }
+
+func (c *Emitter) ScaleBy(v int) {
+	c.Ngen("%s\t$%d, %%cx", "movw", v)
+	c.Gen("mulw\t%cx")
+}
+
+func (c *Emitter) Scale2By(v int) {
+	c.Gen("pushw\t%ax")
+	c.Ngen("%s\t$%d, %%ax", "movw", v)
+	c.Gen("mulw\t%cx")
+	c.Gen("movw\t%ax, %cx")
+	c.Gen("popw\t%ax")
+}
+
+func (c *Emitter) UnscaleBy(v int) {
+	c.Ngen("%s\t$%d, %%cx", "movw", v)
+	c.Gen("xorw\t%dx, %dx")
+	c.Gen("divw\t%cx")
+}
+
+func (c *Emitter) Bool() {
+	c.Gen("negw\t%ax")
+	c.Gen("sbbw\t%ax, %ax")
+	c.Gen("negw\t%ax")
+}
+
+func (c *Emitter) Ldinc() { c.Gen("movw\t%ax, %bx") }
+
+func (c *Emitter) Br(how string, n arch.Label) {
+	lab := c.Label()
+	c.Gen("orw\t%ax, %ax")
+	c.Lgen("%s\t%s", how, lab)
+	c.Lgen("%s\t%s", "jmp", n)
+	c.Lab(lab)
+}
+
+func (c *Emitter) BrTrue(n arch.Label)      { c.Br("jz", n) }
+func (c *Emitter) BrFalse(n arch.Label)     { c.Br("jnz", n) }
+func (c *Emitter) Jump(n arch.Label)        { c.Lgen("%s\t%s", "jmp", n) }
+func (c *Emitter) Jumpr()                   { c.Gen("jmp\t*%ax") }
+func (c *Emitter) LdSwtch(n arch.Label)     { c.Lgen("%s\t$%s, %%si", "movw", n) }
+func (c *Emitter) CalSwtch()                { c.Gen("jmp\tswitch") }
+func (c *Emitter) Case(v int, l arch.Label) { c.Lgen2(".short\t%d, %s", v, l) }
+
+func (c *Emitter) PopPtr() { c.Gen("popw\t%bx") }
+
+func (c *Emitter) Stori(w arch.Width) {
+	o := opnd(w)
+	c.Ngen("mov%s\t%%%s, (%%bx)", o.suffix, o.acc)
+}
+
+func (c *Emitter) Storl(w arch.Width, n int) {
+	o := opnd(w)
+	c.Ngen("mov%s\t%%%s, %d(%%bp)", o.suffix, o.acc, n)
+}
+
+func (c *Emitter) Stors(w arch.Width, l arch.Label) {
+	o := opnd(w)
+	c.Ngen("mov%s\t%%%s, %s", o.suffix, o.acc, c.Labname(l))
+}
+
+func (c *Emitter) Storg(w arch.Width, s string) {
+	o := opnd(w)
+	c.Ngen("mov%s\t%%%s, %s", o.suffix, o.acc, s)
+}
+
+// unroll is the most words that Copy and Zero move without rep.
+const unroll = 8
+
+// Copy copies size bytes from the address in %ax to the address in %bx
+// a word at a time and the byte left over, with rep movsw when there
+// are many words, which takes ES to be DS like it is in the tiny model.
+// The 8086 moves what isn't aligned too, so align doesn't matter. The
+// address of the copy is left in %ax.
+func (c *Emitter) Copy(size, align int) {
+	c.Gen("movw\t%ax, %si")
+	if n := size / 2; n > unroll {
+		c.Gen("movw\t%bx, %di")
+		c.Ngen("movw\t$%d, %%cx", n)
+		c.Gen("cld")
+		c.Gen("rep movsw")
+		if size%2 != 0 {
+			c.Gen("movsb")
+		}
+		c.Gen("movw\t%bx, %ax")
+		return
+	}
+	for i := 0; i+2 <= size; i += 2 {
+		c.Ngen("movw\t%d(%%si), %%cx", i)
+		c.Ngen("movw\t%%cx, %d(%%bx)", i)
+	}
+	if size%2 != 0 {
+		c.Ngen("movb\t%d(%%si), %%cl", size-1)
+		c.Ngen("movb\t%%cl, %d(%%bx)", size-1)
+	}
+	c.Gen("movw\t%bx, %ax")
+}
+
+// Zero clears size bytes at the address in %ax like Copy copies them,
+// and leaves the address in %ax.
+func (c *Emitter) Zero(size, align int) {
+	if n := size / 2; n > unroll {
+		c.Gen("movw\t%ax, %di")
+		c.Gen("movw\t%ax, %dx")
+		c.Clear()
+		c.Ngen("movw\t$%d, %%cx", n)
+		c.Gen("cld")
+		c.Gen("rep stosw")
+		if size%2 != 0 {
+			c.Gen("stosb")
+		}
+		c.Gen("movw\t%dx, %ax")
+		return
+	}
+	c.Gen("movw\t%ax, %bx")
+	c.Clear2()
+	for i := 0; i+2 <= size; i += 2 {
+		c.Ngen("movw\t%%cx, %d(%%bx)", i)
+	}
+	if size%2 != 0 {
+		c.Ngen("movb\t%%cl, %d(%%bx)", size-1)
+	}
+}
+
+func (c *Emitter) Initlw(v int, a int) { c.Ngen2("%s\t$%d, %d(%%bp)", "movw", v, a) }
+func (c *Emitter) Call(s string)       { c.Sgen("%s\t%s", "call", s) }
+func (c *Emitter) Calr()               { c.Gen("call\t*%ax") }
+func (c *Emitter) Stack(n int)         { c.Ngen("%s\t$%d, %%sp", "addw", n) }
+
+func (c *Emitter) Entry() {
+	c.Gen("pushw\t%bp")
+	c.Gen("movw\t%sp, %bp")
+}
+
+func (c *Emitter) Exit() {
+	c.Gen("popw\t%bp")
+	c.Gen("ret")
+}
+
+func (c *Emitter) Def(w arch.Width, v int) { c.Ngen("%s	%d", opnd(w).def, v) }
+func (c *Emitter) Defa(s string)           { c.Sgen("%s\t%s", ".short", s) }
+func (c *Emitter) Defl(l arch.Label)       { c.Lgen("%s\t%s", ".short", l) }
+func (c *Emitter) Defc(ch int)             { c.Ngen("%s\t'%c'", ".byte", ch) }
+func (c *Emitter) Align()                  {}
+
+// Gbss and Lbss give the alignment of an aligned variable as the third
+// argument of .comm, a local one is declared with .local since .lcomm
+// doesn't take an alignment.
+func (c *Emitter) Gbss(s string, z, align int) {
+	if align > 0 {
+		c.Ngen(".comm\t%s,%d,%d", s, z, align)
+		return
+	}
+	c.Ngen(".comm\t%s,%d", s, z)
+}
+
+func (c *Emitter) Lbss(s string, z, align int) {
+	if align > 0 {
+		c.Ngen(".local\t%s", s)
+		c.Ngen(".comm\t%s,%d,%d", s, z, align)
+		return
+	}
+	c.Ngen(".lcomm\t%s,%d", s, z)
+}
+
+func (c *Emitter) Balign(n int) { c.Ngen(".balign\t%d", n) }
@@ -0,0 +1,597 @@
+// Package mips contains a code emitter to
+// generate code for the MIPS32 architecture.
+//
+// The code uses the calling convention of SubC, not the o32 one. The
+// arguments are pushed as 4 byte words from the last to the first, so
+// the callee finds the first one at 8($fp), and the caller pops them
+// after the call. No arguments are passed in registers. A function
+// pointer is called through $v0 and the result is returned in $v0. The
+// callee only preserves $fp and $sp, and the stack is only kept aligned
+// to 4 bytes.
+//
+// The accumulator is $v0 and the second operand is loaded to $v1, the
+// pointers that are stored through are kept in $t1. The addresses of
+// the globals and the statics are made with %hi and %lo from lui, as
+// the code isn't position independent. The assembly is for the reorder
+// mode of the assembler, which puts the nops in the delay slots of the
+// branches.
+package mips
+
+import (
+	"fmt"
+	"io"
+
+	"subc/compile/arch"
+	"subc/obj"
+	"subc/types"
+)
+
+type Emitter struct {
+	*arch.Emitter
+}
+
+// NewEmitter returns an emitter that writes assembly text to w.
+func NewEmitter(w io.Writer) *arch.Emitter {
+	return New(arch.NewTextSink(w))
+}
+
+// New returns an emitter that sends the code it generates to out.
+func New(out arch.Sink) *arch.Emitter {
+	c := &Emitter{}
+	c.Emitter = &arch.Emitter{Out: out, B: c, ByteOrder: obj.ByteOrder("mips"), Branches: branches, Sizes: &types.StdSizes{WordSize: 4, MaxAlign: 4}, StdTypes: types.StdTypes{Size: types.Int, Ptrdiff: types.Int, Wchar: types.Int}, Registers: regUsage}
+	return c.Emitter
+}
+
+// regUsage describes how the code uses the registers.
+var regUsage = &arch.Registers{Acc: "$v0", Frame: "$fp", Stack: "$sp", Scratch: []string{"$v1", "$t0", "$t1", "$t2", "$t3", "$ra"}}
+
+// branches are the branch instructions, for improving them. The
+// conditions are compared to a register by slt and sltu, so beq and
+// bne are the only conditional branches.
+var branches = &arch.Branches{
+	Jump: "b",
+	Inverse: map[string]string{
+		"beq": "bne", "bne": "beq",
+	},
+}
+
+// operand describes how an operand of some width is accessed.
+type operand struct {
+	load  string // load instruction
+	store string // store instruction
+	def   string // data directive
+}
+
+var operands = map[arch.Width]operand{
+	arch.U8:   {"lbu", "sb", ".byte"},
+	arch.U32:  {"lw", "sw", ".word"},
+	arch.S32:  {"lw", "sw", ".word"},
+	arch.Word: {"lw", "sw", ".word"},
+	arch.Ptr:  {"lw", "sw", ".word"},
+}
+
+func opnd(w arch.Width) operand {
+	o, ok := operands[w]
+	if !ok {
+		panic(fmt.Sprintf("unsupported operand width: %v", w))
+	}
+	return o
+}
+
+func (c *Emitter) Data()           { c.Gen(".data") }
+func (c *Emitter) Text()           { c.Gen(".text") }
+func (c *Emitter) Prelude()        {}
+func (c *Emitter) Postlude()       {}
+func (c *Emitter) Public(s string) { c.Sgen("%s\t%s", ".globl", s) }
+
+// Ident records the quoted string s in the .comment section of the object.
+func (c *Emitter) Ident(s string) { c.Sgen("%s\t%s", ".ident", s) }
+
+// Section switches to the section of the text s, the linker
+// puts .text.hot and .text.unlikely apart from the rest of .text.
+func (c *Emitter) Section(s arch.Section) {
+	switch s {
+	case arch.HotText:
+		c.Gen(".section\t.text.hot,\"ax\",@progbits")
+	case arch.ColdText:
+		c.Gen(".section\t.text.unlikely,\"ax\",@progbits")
+	default:
+		c.Text()
+	}
+}
+
+// imm16 reports whether v fits in the signed 16 bits of an immediate
+// or a displacement.
+func imm16(v int) bool { return -0x8000 <= v && v <= 0x7fff }
+
+// frame returns the operand of the local at offset n of the frame,
+// the address is made in $t2 when n doesn't fit in a displacement.
+func (c *Emitter) frame(n int) string {
+	if imm16(n) {
+		return fmt.Sprintf("%d($fp)", n)
+	}
+	c.Ngen("li\t$t2, %d", n)
+	c.Gen("addu\t$t2, $t2, $fp")
+	return "0($t2)"
+}
+
+// addImm adds v to the register r, through $t0 when v doesn't fit in an
+// immediate.
+func (c *Emitter) addImm(r string, v int) {
+	if imm16(v) {
+		c.Ngen("addiu\t%s, %s, %d", r, r, v)
+		return
+	}
+	c.Ngen("li\t$t0, %d", v)
+	c.Ngen("addu\t%s, %s, $t0", r, r)
+}
+
+// address loads the address of the symbol s to the register r.
+func (c *Emitter) address(r, s string) {
+	c.Ngen("lui\t%s, %%hi(%s)", r, s)
+	c.Ngen("addiu\t%s, %s, %%lo(%s)", r, r, s)
+}
+
+// load loads the value of width w at the symbol s to the register r.
+func (c *Emitter) load(w arch.Width, r, s string) {
+	c.Ngen("lui\t%s, %%hi(%s)", r, s)
+	c.Ngen("%s\t%s, %%lo(%s)(%s)", opnd(w).load, r, s, r)
+}
+
+// store stores the accumulator to the value of width w at the
+// symbol s, through the address in $t2.
+func (c *Emitter) store(w arch.Width, s string) {
+	c.Ngen("lui\t$t2, %%hi(%s)", s)
+	c.Ngen("%s\t$v0, %%lo(%s)($t2)", opnd(w).store, s)
+}
+
+func (c *Emitter) Load2() bool {
+	n := c.Q.Value
+	l := c.Labname(c.Q.Label)
+	s := c.Gsym(c.Q.Name)
+
+	switch c.Q.Type {
+	case arch.AddrAuto:
+		c.Ngen("addiu\t$v1, $fp, %d", n)
+	case arch.AddrStatic, arch.AddrLabel:
+		c.address("$v1", l)
+	case arch.AddrGlobal:
+		c.address("$v1", s)
+	case arch.Literal:
+		c.Ngen("li\t$v1, %d", n)
+	case arch.AutoByte:
+		c.Ngen("lbu\t$v1, %s", c.frame(n))
+	case arch.AutoWord:
+		c.Ngen("lw\t$v1, %s", c.frame(n))
+	case arch.StaticByte:
+		c.load(arch.U8, "$v1", l)
+	case arch.StaticWord:
+		c.load(arch.Word, "$v1", l)
+	case arch.GlobalByte:
+		c.load(arch.U8, "$v1", s)
+	case arch.GlobalWord:
+		c.load(arch.Word, "$v1", s)
+	case arch.Empty:
+		c.Pop2()
+	default:
+		panic(fmt.Sprintf("bad type in load2(): %v", c.Q.Type))
+	}
+	q := c.Q.Type
+	c.Q.Type = arch.Empty
+	return q == arch.Empty
+}
+
+func (c *Emitter) Lit(v int) { c.Ngen("li\t$v0, %d", v) }
+
+// Clear and Clear2 have nothing to do, lbu clears the rest of the word.
+func (c *Emitter) Clear()  {}
+func (c *Emitter) Clear2() {}
+
+func (c *Emitter) Ldg(w arch.Width, s string)     { c.load(w, "$v0", s) }
+func (c *Emitter) Ldl(w arch.Width, n int)        { c.Ngen("%s\t$v0, %s", opnd(w).load, c.frame(n)) }
+func (c *Emitter) Lds(w arch.Width, l arch.Label) { c.load(w, "$v0", c.Labname(l)) }
+func (c *Emitter) Ind(w arch.Width)               { c.Ngen("%s\t$v0, 0($v0)", opnd(w).load) }
+
+// Index adds the index in $v0 scaled by scale to the address in $v1.
+func (c *Emitter) Index(scale int) {
+	if n := shift(scale); n > 0 {
+		c.Ngen("sll\t$v0, $v0, %d", n)
+	}
+	c.Gen("addu\t$v0, $v1, $v0")
+}
+
+// IndIndex loads from the address in $v1 plus the index in $v0 scaled
+// by scale, mips has no indexed addressing so the address is made first.
+func (c *Emitter) IndIndex(w arch.Width, scale int) {
+	c.Index(scale)
+	c.Ind(w)
+}
+
+// shift returns the shift that multiplies by a power of 2.
+func shift(scale int) int {
+	n := 0
+	for ; scale > 1; scale >>= 1 {
+		n++
+	}
+	return n
+}
+
+func (c *Emitter) Ldla(n int) {
+	if imm16(n) {
+		c.Ngen("addiu\t$v0, $fp, %d", n)
+		return
+	}
+	c.Ngen("li\t$v0, %d", n)
+	c.Gen("addu\t$v0, $v0, $fp")
+}
+
+func (c *Emitter) Ldsa(n arch.Label)   { c.address("$v0", c.Labname(n)) }
+func (c *Emitter) Ldga(s string)       { c.address("$v0", s) }
+func (c *Emitter) Ldlab(id arch.Label) { c.address("$v0", c.Labname(id)) }
+
+func (c *Emitter) Push() {
+	c.Gen("addiu\t$sp, $sp, -4")
+	c.Gen("sw\t$v0, 0($sp)")
+}
+
+func (c *Emitter) PushLit(n int) {
+	c.Lit(n)
+	c.Push()
+}
+
+func (c *Emitter) Pop2() {
+	c.Gen("lw\t$v1, 0($sp)")
+	c.Gen("addiu\t$sp, $sp, 4")
+}
+
+func (c *Emitter) Swap() {
+	c.Gen("move\t$t0, $v0")
+	c.Gen("move\t$v0, $v1")
+	c.Gen("move\t$v1, $t0")
+}
+
+// logic applies the bitwise operation op to the accumulator and the
+// next operand, with the immediate form opi when it is a literal
+// that fits the unsigned 16 bits of its immediate.
+func (c *Emitter) logic(op, opi string) {
+	if v := c.Q.Value; c.Q.Type == arch.Literal && 0 <= v && v <= 0xffff {
+		c.Ngen("%s\t$v0, $v0, %d", opi, v)
+		c.Q.Type = arch.Empty
+		return
+	}
+	c.Load2()
+	c.Ngen("%s\t$v0, $v0, $v1", op)
+}
+
+func (c *Emitter) And() { c.logic("and", "andi") }
+func (c *Emitter) Or()  { c.logic("or", "ori") }
+func (c *Emitter) Xor() { c.logic("xor", "xori") }
+func (c *Emitter) Add() { c.Gen("addu\t$v0, $v0, $v1") }
+func (c *Emitter) Mul() { c.Gen("mul\t$v0, $v0, $v1") }
+func (c *Emitter) Sub() { c.Gen("subu\t$v0, $v0, $v1") }
+
+// Div and Mod divide with div, which truncates the quotient toward
+// zero and gives the remainder the sign of the dividend. mips doesn't
+// trap on a zero divisor, teq traps with the code of a division by
+// zero that linux raises SIGFPE for.
+func (c *Emitter) Div() {
+	c.Gen("div\t$zero, $v0, $v1")
+	c.Gen("teq\t$v1, $zero, 7")
+	c.Gen("mflo\t$v0")
+}
+
+func (c *Emitter) Mod() {
+	c.Gen("div\t$zero, $v0, $v1")
+	c.Gen("teq\t$v1, $zero, 7")
+	c.Gen("mfhi\t$v0")
+}
+
+func (c *Emitter) Shl() { c.Gen("sllv\t$v0, $v0, $v1") }
+func (c *Emitter) Shr() { c.Gen("srav\t$v0, $v0, $v1") }
+
+// compared returns the registers of the left and the right operand
+// of a comparison, the left one is popped when nothing is queued.
+func (c *Emitter) compared() (a, b string) {
+	if c.Q.Type == arch.Empty {
+		c.Pop2()
+		return "$v1", "$v0"
+	}
+	c.Load2()
+	return "$v0", "$v1"
+}
+
+// Cmp sets the accumulator to whether the operands are equal, or
+// unequal when ne is set.
+func (c *Emitter) Cmp(ne bool) {
+	a, b := c.compared()
+	c.Ngen("xor\t$v0, %s, %s", a, b)
+	if ne {
+		c.Gen("sltu\t$v0, $zero, $v0")
+	} else {
+		c.Gen("sltiu\t$v0, $v0, 1")
+	}
+}
+
+// Less sets the accumulator to the comparison of the operands by slt,
+// which is a < b, or b < a when swap is set, and inverted when not is.
+func (c *Emitter) Less(slt string, swap, not bool) {
+	a, b := c.compared()
+	if swap {
+		a, b = b, a
+	}
+	c.Ngen("%s\t$v0, %s, %s", slt, a, b)
+	if not {
+		c.Gen("xori\t$v0, $v0, 1")
+	}
+}
+
+func (c *Emitter) Eq()  { c.Cmp(false) }
+func (c *Emitter) Ne()  { c.Cmp(true) }
+func (c *Emitter) Lt()  { c.Less("slt", false, false) }
+func (c *Emitter) Gt()  { c.Less("slt", true, false) }
+func (c *Emitter) Le()  { c.Less("slt", true, true) }
+func (c *Emitter) Ge()  { c.Less("slt", false, true) }
+func (c *Emitter) Ult() { c.Less("sltu", false, false) }
+func (c *Emitter) Ugt() { c.Less("sltu", true, false) }
+func (c *Emitter) Ule() { c.Less("sltu", true, true) }
+func (c *Emitter) Uge() { c.Less("sltu", false, true) }
+
+// BrCond jumps to n unless the operands compare like slt says, see
+// Less. The equality is branched on by br itself, beq or bne, with an
+// empty slt.
+func (c *Emitter) BrCond(slt string, swap bool, br string, n arch.Label) {
+	lab := c.Label()
+	a, b := c.compared()
+	if swap {
+		a, b = b, a
+	}
+	if slt == "" {
+		c.Ngen("%s\t%s, %s, %s", br, a, b, c.Labname(lab))
+	} else {
+		c.Ngen("%s\t$t0, %s, %s", slt, a, b)
+		c.Ngen("%s\t$t0, $zero, %s", br, c.Labname(lab))
+	}
+	c.Lgen("%s\t%s", "b", n)
+	c.Lab(lab)
+}
+
+func (c *Emitter) BrEq(n arch.Label)  { c.BrCond("", false, "beq", n) }
+func (c *Emitter) BrNe(n arch.Label)  { c.BrCond("", false, "bne", n) }
+func (c *Emitter) BrLt(n arch.Label)  { c.BrCond("slt", false, "bne", n) }
+func (c *Emitter) BrGt(n arch.Label)  { c.BrCond("slt", true, "bne", n) }
+func (c *Emitter) BrLe(n arch.Label)  { c.BrCond("slt", true, "beq", n) }
+func (c *Emitter) BrGe(n arch.Label)  { c.BrCond("slt", false, "beq", n) }
+func (c *Emitter) BrUlt(n arch.Label) { c.BrCond("sltu", false, "bne", n) }
+func (c *Emitter) BrUgt(n arch.Label) { c.BrCond("sltu", true, "bne", n) }
+func (c *Emitter) BrUle(n arch.Label) { c.BrCond("sltu", true, "beq", n) }
+func (c *Emitter) BrUge(n arch.Label) { c.BrCond("sltu", false, "beq", n) }
+
+func (c *Emitter) Neg() { c.Gen("subu\t$v0, $zero, $v0") }
+func (c *Emitter) Not() { c.Gen("nor\t$v0, $v0, $zero") }
+
+// Ext extends the value of width w in the accumulator to a word, the
+// signed ones are shifted up and back as MIPS32 has no seb and seh.
+func (c *Emitter) Ext(w arch.Width) {
+	switch w {
+	case arch.U8:
+		c.Gen("andi\t$v0, $v0, 0xff")
+	case arch.S8:
+		c.Gen("sll\t$v0, $v0, 24")
+		c.Gen("sra\t$v0, $v0, 24")
+	case arch.U16:
+		c.Gen("andi\t$v0, $v0, 0xffff")
+	case arch.S16:
+		c.Gen("sll\t$v0, $v0, 16")
+		c.Gen("sra\t$v0, $v0, 16")
+	case arch.U32, arch.S32, arch.Word, arch.Ptr:
+	default:
+		panic(fmt.Sprintf("unsupported operand width: %v", w))
+	}
+}
+
+func (c *Emitter) LogNot() { c.Gen("sltiu\t$v0, $v0, 1") }
+
+func (c *Emitter) Scale()   { c.Gen("sll\t$v0, $v0, 2") }
+func (c *Emitter) Scale2()  { c.Gen("sll\t$v1, $v1, 2") }
+func (c *Emitter) Unscale() { c.Gen("sra\t$v0, $v0, 2") }
+
+func (c *Emitter) ScaleBy(v int) {
+	c.Ngen("li\t$t0, %d", v)
+	c.Gen("mul\t$v0, $v0, $t0")
+}
+
+func (c *Emitter) Scale2By(v int) {
+	c.Ngen("li\t$t0, %d", v)
+	c.Gen("mul\t$v1, $v1, $t0")
+}
+
+func (c *Emitter) UnscaleBy(v int) {
+	c.Ngen("li\t$t0, %d", v)
+	c.Gen("div\t$zero, $v0, $t0")
+	c.Gen("mflo\t$v0")
+}
+
+func (c *Emitter) Bool() { c.Gen("sltu\t$v0, $zero, $v0") }
+
+func (c *Emitter) Ldinc() { c.Gen("move\t$t1, $v0") }
+
+// Step increments or decrements a variable in place through $t0,
+// leaving the accumulator as it is. The pointer of an indirect step
+// is in the accumulator for a Pre step and in $t1 otherwise.
+func (c *Emitter) Step(s arch.Step) {
+	var dst string
+	switch s.Kind {
+	case arch.StepInd:
+		dst = "0($t1)"
+		if s.Pre {
+			dst = "0($v0)"
+		}
+	case arch.StepLocal:
+		dst = c.frame(s.Addr)
+	case arch.StepStatic:
+		c.address("$t2", c.Labname(s.Label))
+		dst = "0($t2)"
+	case arch.StepGlobal:
+		c.address("$t2", s.Name)
+		dst = "0($t2)"
+	}
+
+	o := opnd(s.W)
+	n := 1
+	if s.Stride != 0 {
+		n = s.Stride
+	}
+	if s.Dec {
+		n = -n
+	}
+	c.Ngen("%s\t$t0, %s", o.load, dst)
+	if imm16(n) {
+		c.Ngen("addiu\t$t0, $t0, %d", n)
+	} else {
+		c.Ngen("li\t$t3, %d", n)
+		c.Gen("addu\t$t0, $t0, $t3")
+	}
+	c.Ngen("%s\t$t0, %s", o.store, dst)
+}
+
+func (c *Emitter) Br(how string, n arch.Label) {
+	lab := c.Label()
+	c.Ngen("%s\t$v0, $zero, %s", how, c.Labname(lab))
+	c.Lgen("%s\t%s", "b", n)
+	c.Lab(lab)
+}
+
+func (c *Emitter) BrTrue(n arch.Label)      { c.Br("beq", n) }
+func (c *Emitter) BrFalse(n arch.Label)     { c.Br("bne", n) }
+func (c *Emitter) Jump(n arch.Label)        { c.Lgen("%s\t%s", "b", n) }
+func (c *Emitter) Jumpr()                   { c.Gen("jr\t$v0") }
+func (c *Emitter) LdSwtch(n arch.Label)     { c.address("$v1", c.Labname(n)) }
+func (c *Emitter) CalSwtch()                { c.Gen("j\tswitch") }
+func (c *Emitter) Case(v int, l arch.Label) { c.Lgen2(".word\t%d, %s", v, l) }
+
+func (c *Emitter) PopPtr() {
+	c.Gen("lw\t$t1, 0($sp)")
+	c.Gen("addiu\t$sp, $sp, 4")
+}
+
+func (c *Emitter) Stori(w arch.Width)               { c.Ngen("%s\t$v0, 0($t1)", opnd(w).store) }
+func (c *Emitter) Storl(w arch.Width, n int)        { c.Ngen("%s\t$v0, %s", opnd(w).store, c.frame(n)) }
+func (c *Emitter) Stors(w arch.Width, l arch.Label) { c.store(w, c.Labname(l)) }
+func (c *Emitter) Storg(w arch.Width, s string)     { c.store(w, s) }
+
+// unroll is the most loads and stores that Copy and Zero do without a loop.
+const unroll = 8
+
+// blockUnit returns the widest unit that the loads and stores of a block
+// of size bytes aligned to align can move, and its load and store.
+func blockUnit(size, align int) (int, string, string) {
+	switch {
+	case size%4 == 0 && align%4 == 0:
+		return 4, "lw", "sw"
+	case size%2 == 0 && align%2 == 0:
+		return 2, "lhu", "sh"
+	}
+	return 1, "lbu", "sb"
+}
+
+// block emits the move of n units of w bytes, at the offsets of the
+// unit when there are few and otherwise in a loop that counts down in
+// $t3 and moves along with step.
+func (c *Emitter) block(n, w int, move func(off int), step func()) {
+	if n <= unroll {
+		for i := 0; i < n; i++ {
+			move(i * w)
+		}
+		return
+	}
+	loop := c.Label()
+	c.Ngen("li\t$t3, %d", n)
+	c.Lab(loop)
+	move(0)
+	step()
+	c.Gen("addiu\t$t3, $t3, -1")
+	c.Ngen("bne\t$t3, $zero, %s", c.Labname(loop))
+}
+
+// Copy copies size bytes from the address in $v0 to the address in $t1
+// through $t0, in the widest units that align allows as mips can't load
+// what isn't aligned. The address of the copy is left in $v0.
+func (c *Emitter) Copy(size, align int) {
+	w, ld, st := blockUnit(size, align)
+	c.Gen("move\t$t2, $t1")
+	c.block(size/w, w, func(off int) {
+		c.Ngen("%s\t$t0, %d($v0)", ld, off)
+		c.Ngen("%s\t$t0, %d($t2)", st, off)
+	}, func() {
+		c.Ngen("addiu\t$v0, $v0, %d", w)
+		c.Ngen("addiu\t$t2, $t2, %d", w)
+	})
+	c.Gen("move\t$v0, $t1")
+}
+
+// Zero clears size bytes at the address in $v0 like Copy copies them,
+// and leaves the address in $v0.
+func (c *Emitter) Zero(size, align int) {
+	w, _, st := blockUnit(size, align)
+	c.Gen("move\t$t2, $v0")
+	c.block(size/w, w, func(off int) {
+		c.Ngen("%s\t$zero, %d($t2)", st, off)
+	}, func() {
+		c.Ngen("addiu\t$t2, $t2, %d", w)
+	})
+}
+
+func (c *Emitter) Initlw(v, a int) {
+	if v == 0 {
+		c.Ngen("sw\t$zero, %s", c.frame(a))
+		return
+	}
+	c.Ngen("li\t$t0, %d", v)
+	c.Ngen("sw\t$t0, %s", c.frame(a))
+}
+
+func (c *Emitter) Call(s string) { c.Sgen("%s\t%s", "jal", s) }
+func (c *Emitter) Calr()         { c.Gen("jalr\t$v0") }
+func (c *Emitter) Stack(n int)   { c.addImm("$sp", n) }
+
+func (c *Emitter) Entry() {
+	c.Gen("addiu\t$sp, $sp, -8")
+	c.Gen("sw\t$ra, 4($sp)")
+	c.Gen("sw\t$fp, 0($sp)")
+	c.Gen("move\t$fp, $sp")
+}
+
+func (c *Emitter) Exit() {
+	c.Gen("lw\t$ra, 4($sp)")
+	c.Gen("lw\t$fp, 0($sp)")
+	c.Gen("addiu\t$sp, $sp, 8")
+	c.Gen("jr\t$ra")
+}
+
+func (c *Emitter) Def(w arch.Width, v int) { c.Ngen("%s\t%d", opnd(w).def, v) }
+func (c *Emitter) Defa(s string)           { c.Sgen("%s\t%s", ".word", s) }
+func (c *Emitter) Defl(l arch.Label)       { c.Lgen("%s\t%s", ".word", l) }
+func (c *Emitter) Defc(c_ int)             { c.Ngen("%s\t'%c'", ".byte", c_) }
+func (c *Emitter) Align()                  { c.Gen(".align\t2") }
+
+// Gbss and Lbss give the alignment of an aligned variable as the third
+// argument of .comm, a local one is declared with .local since .lcomm
+// doesn't take an alignment.
+func (c *Emitter) Gbss(s string, z, align int) {
+	if align > 0 {
+		c.Ngen(".comm\t%s, %d, %d", s, z, align)
+		return
+	}
+	c.Ngen(".comm\t%s, %d", s, z)
+}
+
+func (c *Emitter) Lbss(s string, z, align int) {
+	if align > 0 {
+		c.Ngen(".local\t%s", s)
+		c.Ngen(".comm\t%s, %d, %d", s, z, align)
+		return
+	}
+	c.Ngen(".lcomm\t%s, %d", s, z)
+}
+
+func (c *Emitter) Balign(n int) { c.Ngen(".balign\t%d", n) }
@@ -0,0 +1,202 @@
+package arch
+
+import (
+	"fmt"
+	"strings"
+	"text/scanner"
+
+	"subc/types"
+)
+
+// Recorder is a backend that records the calls made to it instead of
+// generating code, so the code generation decisions of the compiler
+// can be checked without reading the assembly of a target. The labels
+// that the emitter defines are recorded in order with the calls.
+type Recorder struct {
+	Emitter *Emitter // the emitter that calls the recorder, for compiling with
+	Calls   []Call   // the calls made so far, in order
+}
+
+// Call is a call to a method of a backend.
+type Call struct {
+	Op   string        // name of the method, or "label" for a label defined
+	Args []interface{} // the arguments of the call
+}
+
+// NewRecorder returns a recorder with an emitter for a target of the
+// sizes given.
+func NewRecorder(sizes types.Sizes) *Recorder {
+	r := &Recorder{}
+	r.Emitter = &Emitter{Out: r, B: r, Sizes: sizes}
+	return r
+}
+
+// Reset forgets the calls recorded.
+func (r *Recorder) Reset() {
+	r.Calls = nil
+}
+
+// String returns the calls recorded, one on each line.
+func (r *Recorder) String() string {
+	var b strings.Builder
+	for _, x := range r.Calls {
+		b.WriteString(x.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// String returns the call as Op(arg, ...), with the labels given like
+// the emitter names them.
+func (x Call) String() string {
+	args := make([]string, len(x.Args))
+	for i, arg := range x.Args {
+		switch arg := arg.(type) {
+		case Label:
+			args[i] = fmt.Sprintf("%c%d", lprefix, int(arg))
+		case Step:
+			args[i] = fmt.Sprintf("%+v", arg)
+		default:
+			args[i] = fmt.Sprint(arg)
+		}
+	}
+	return x.Op + "(" + strings.Join(args, ", ") + ")"
+}
+
+func (r *Recorder) record(op string, args ...interface{}) {
+	r.Calls = append(r.Calls, Call{op, args})
+}
+
+// Load2 records the operand queued in the code synthesizer, which it
+// takes as the second operand like the backends do, and reports whether
+// there was none, so the first operand is on the stack.
+func (r *Recorder) Load2() bool {
+	if r.Emitter.Q.Type == Empty {
+		r.record("Load2")
+		return true
+	}
+	r.record("Load2", r.Emitter.queued())
+	r.Emitter.Q.Type = Empty
+	return false
+}
+
+// NewLabel allocates the labels like the emitter does.
+func (r *Recorder) NewLabel() Label {
+	return r.Emitter.NewLabel()
+}
+
+// Label records the label that the emitter defines.
+func (r *Recorder) Label(name string, inline bool) {
+	r.record("label", name)
+}
+
+// Inst records an instruction, only the backends emit them through
+// the emitter so there are none unless a backend is wrapped.
+func (r *Recorder) Inst(op, operands string) {
+	r.record("inst", op, operands)
+}
+
+// Pos and Comment are dropped, the calls don't change with them.
+func (r *Recorder) Pos(pos scanner.Position) {}
+func (r *Recorder) Comment(text string)      {}
+
+func (r *Recorder) Add()                        { r.record("Add") }
+func (r *Recorder) AddOvf(w Width)              { r.record("AddOvf", w) }
+func (r *Recorder) Align()                      { r.record("Align") }
+func (r *Recorder) And()                        { r.record("And") }
+func (r *Recorder) Balign(n int)                { r.record("Balign", n) }
+func (r *Recorder) Bool()                       { r.record("Bool") }
+func (r *Recorder) BrEq(l Label)                { r.record("BrEq", l) }
+func (r *Recorder) BrFalse(l Label)             { r.record("BrFalse", l) }
+func (r *Recorder) BrGe(l Label)                { r.record("BrGe", l) }
+func (r *Recorder) BrGt(l Label)                { r.record("BrGt", l) }
+func (r *Recorder) BrLe(l Label)                { r.record("BrLe", l) }
+func (r *Recorder) BrLt(l Label)                { r.record("BrLt", l) }
+func (r *Recorder) BrNe(l Label)                { r.record("BrNe", l) }
+func (r *Recorder) BrTrue(l Label)              { r.record("BrTrue", l) }
+func (r *Recorder) BrUge(l Label)               { r.record("BrUge", l) }
+func (r *Recorder) BrUgt(l Label)               { r.record("BrUgt", l) }
+func (r *Recorder) BrUle(l Label)               { r.record("BrUle", l) }
+func (r *Recorder) BrUlt(l Label)               { r.record("BrUlt", l) }
+func (r *Recorder) Call(s string)               { r.record("Call", s) }
+func (r *Recorder) Calr()                       { r.record("Calr") }
+func (r *Recorder) CalSwtch()                   { r.record("CalSwtch") }
+func (r *Recorder) Case(v int, l Label)         { r.record("Case", v, l) }
+func (r *Recorder) Clear()                      { r.record("Clear") }
+func (r *Recorder) Clear2()                     { r.record("Clear2") }
+func (r *Recorder) CopyBlock()                  { r.record("CopyBlock") }
+func (r *Recorder) Copy(size, align int)        { r.record("Copy", size, align) }
+func (r *Recorder) Data()                       { r.record("Data") }
+func (r *Recorder) Def(w Width, v int)          { r.record("Def", w, v) }
+func (r *Recorder) Defc(c int)                  { r.record("Defc", c) }
+func (r *Recorder) Defa(s string)               { r.record("Defa", s) }
+func (r *Recorder) Defl(l Label)                { r.record("Defl", l) }
+func (r *Recorder) Div()                        { r.record("Div") }
+func (r *Recorder) Entry()                      { r.record("Entry") }
+func (r *Recorder) Eq()                         { r.record("Eq") }
+func (r *Recorder) Exit()                       { r.record("Exit") }
+func (r *Recorder) Ext(w Width)                 { r.record("Ext", w) }
+func (r *Recorder) FillBlock()                  { r.record("FillBlock") }
+func (r *Recorder) Gbss(s string, z, align int) { r.record("Gbss", s, z, align) }
+func (r *Recorder) Ge()                         { r.record("Ge") }
+func (r *Recorder) Gt()                         { r.record("Gt") }
+func (r *Recorder) Ident(s string)              { r.record("Ident", s) }
+func (r *Recorder) Ind(w Width)                 { r.record("Ind", w) }
+func (r *Recorder) IndIndex(w Width, scale int) { r.record("IndIndex", w, scale) }
+func (r *Recorder) Index(scale int)             { r.record("Index", scale) }
+func (r *Recorder) Initlw(v, a int)             { r.record("Initlw", v, a) }
+func (r *Recorder) Jump(l Label)                { r.record("Jump", l) }
+func (r *Recorder) Jumpr()                      { r.record("Jumpr") }
+func (r *Recorder) Lbss(s string, z, align int) { r.record("Lbss", s, z, align) }
+func (r *Recorder) Ldg(w Width, s string)       { r.record("Ldg", w, s) }
+func (r *Recorder) Ldga(s string)               { r.record("Ldga", s) }
+func (r *Recorder) Ldinc()                      { r.record("Ldinc") }
+func (r *Recorder) Ldl(w Width, n int)          { r.record("Ldl", w, n) }
+func (r *Recorder) Ldla(n int)                  { r.record("Ldla", n) }
+func (r *Recorder) Ldlab(l Label)               { r.record("Ldlab", l) }
+func (r *Recorder) Lds(w Width, l Label)        { r.record("Lds", w, l) }
+func (r *Recorder) Ldsa(l Label)                { r.record("Ldsa", l) }
+func (r *Recorder) LdSwtch(l Label)             { r.record("LdSwtch", l) }
+func (r *Recorder) Le()                         { r.record("Le") }
+func (r *Recorder) Lit(v int)                   { r.record("Lit", v) }
+func (r *Recorder) LogNot()                     { r.record("LogNot") }
+func (r *Recorder) Lt()                         { r.record("Lt") }
+func (r *Recorder) Mod()                        { r.record("Mod") }
+func (r *Recorder) Mul()                        { r.record("Mul") }
+func (r *Recorder) MulOvf(w Width)              { r.record("MulOvf", w) }
+func (r *Recorder) Ne()                         { r.record("Ne") }
+func (r *Recorder) Neg()                        { r.record("Neg") }
+func (r *Recorder) Not()                        { r.record("Not") }
+func (r *Recorder) Or()                         { r.record("Or") }
+func (r *Recorder) Pop2()                       { r.record("Pop2") }
+func (r *Recorder) PopPtr()                     { r.record("PopPtr") }
+func (r *Recorder) Postlude()                   { r.record("Postlude") }
+func (r *Recorder) Prelude()                    { r.record("Prelude") }
+func (r *Recorder) Public(s string)             { r.record("Public", s) }
+func (r *Recorder) Push()                       { r.record("Push") }
+func (r *Recorder) PushLit(n int)               { r.record("PushLit", n) }
+func (r *Recorder) Scale()                      { r.record("Scale") }
+func (r *Recorder) Scale2()                     { r.record("Scale2") }
+func (r *Recorder) Scale2By(v int)              { r.record("Scale2By", v) }
+func (r *Recorder) ScaleBy(v int)               { r.record("ScaleBy", v) }
+func (r *Recorder) Section(s Section)           { r.record("Section", s) }
+func (r *Recorder) Shl()                        { r.record("Shl") }
+func (r *Recorder) Shr()                        { r.record("Shr") }
+func (r *Recorder) Stack(n int)                 { r.record("Stack", n) }
+func (r *Recorder) Step(s Step)                 { r.record("Step", s) }
+func (r *Recorder) Storg(w Width, s string)     { r.record("Storg", w, s) }
+func (r *Recorder) Stori(w Width)               { r.record("Stori", w) }
+func (r *Recorder) Storl(w Width, n int)        { r.record("Storl", w, n) }
+func (r *Recorder) Stors(w Width, l Label)      { r.record("Stors", w, l) }
+func (r *Recorder) Sub()                        { r.record("Sub") }
+func (r *Recorder) SubOvf(w Width)              { r.record("SubOvf", w) }
+func (r *Recorder) Swap()                       { r.record("Swap") }
+func (r *Recorder) Text()                       { r.record("Text") }
+func (r *Recorder) Uge()                        { r.record("Uge") }
+func (r *Recorder) Ugt()                        { r.record("Ugt") }
+func (r *Recorder) Ule()                        { r.record("Ule") }
+func (r *Recorder) Ult()                        { r.record("Ult") }
+func (r *Recorder) Unscale()                    { r.record("Unscale") }
+func (r *Recorder) UnscaleBy(v int)             { r.record("UnscaleBy", v) }
+func (r *Recorder) Xor()                        { r.record("Xor") }
+func (r *Recorder) Zero(size, align int)        { r.record("Zero", size, align) }
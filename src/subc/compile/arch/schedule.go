@@ -0,0 +1,316 @@
+package arch
+
+import "text/scanner"
+
+// Scheduling describes the instructions of a target, so a ScheduleSink
+// can reorder them without knowing the target.
+type Scheduling struct {
+	// Effects returns what an instruction reads and writes, ok is false
+	// for the instructions that can't be moved, like the branches, the
+	// calls and the directives, which end a block.
+	Effects func(op, operands string) (e Effects, ok bool)
+}
+
+// Effects are the registers and the memory that an instruction reads
+// and writes, and how many cycles it takes before its result can be used.
+type Effects struct {
+	Reads   []string // registers, and the flags as "flags"
+	Writes  []string
+	Mem     []Access
+	Latency int
+}
+
+// Access is an access of an instruction to memory.
+type Access struct {
+	Area  Area
+	Off   int // the first byte accessed, for a FrameArea
+	Size  int
+	Write bool
+}
+
+// Area is the memory that an access can touch, the accesses to two
+// different areas never touch the same memory.
+type Area int
+
+const (
+	OtherArea Area = iota // anywhere, through a pointer or a global
+	FrameArea             // a variable or an argument at an offset from the frame pointer
+	StackArea             // the temporaries pushed below the variables of the frame
+)
+
+// maxBlock is the most instructions that are scheduled together, the
+// time taken is quadratic in the size of the block.
+const maxBlock = 256
+
+// ScheduleSink is a sink that reorders the instructions of the code
+// before passing it on to another sink. The backends keep the value
+// being computed in one register and the code they generate uses a
+// value right after loading it, so the target waits for the load. In
+// a block of instructions that are run in order, an instruction that
+// doesn't depend on the load can often be moved between the load and
+// its use, and the independent computations of the addresses of an
+// expression interleaved.
+//
+// Each block is scheduled by picking the instructions in turn, the one
+// picked next is one that can start soonest, and of those the one with
+// the longest path of dependent instructions after it. An instruction
+// is never moved before one it depends on, so the code computes the
+// same values in the same places. Positions and comments stay with the
+// instruction that follows them.
+type ScheduleSink struct {
+	out   Sink
+	sched *Scheduling
+	block []schedInst
+	pos   scanner.Position // position of the code that follows
+	last  scanner.Position // last position passed on
+	notes []string         // comments on the code that follows
+}
+
+// schedInst is an instruction of a block held by a ScheduleSink.
+type schedInst struct {
+	op, operands string
+	pos          scanner.Position
+	notes        []string
+	eff          Effects
+	succs        []schedDep
+	npreds       int
+	height       int // the cycles from the start of the instruction to the end of the block
+	ready        int // the cycle that the operands of the instruction are ready
+	done         bool
+}
+
+// schedDep is an instruction that depends on another one,
+// and the cycles it has to wait for it.
+type schedDep struct {
+	to      int
+	latency int
+}
+
+// NewScheduleSink returns a sink that reorders the instructions
+// described by sched and passes the code on to out.
+func NewScheduleSink(out Sink, sched *Scheduling) *ScheduleSink {
+	return &ScheduleSink{out: out, sched: sched}
+}
+
+// Label ends the block and passes the label on.
+func (s *ScheduleSink) Label(name string, inline bool) {
+	s.flushBlock()
+	s.flushNotes()
+	s.out.Label(name, inline)
+}
+
+// Inst holds an instruction in the block, an instruction that
+// can't be moved ends the block and is passed on.
+func (s *ScheduleSink) Inst(op, operands string) {
+	eff, ok := s.sched.Effects(op, operands)
+	if !ok {
+		s.flushBlock()
+		s.flushNotes()
+		s.out.Inst(op, operands)
+		return
+	}
+	s.block = append(s.block, schedInst{op: op, operands: operands, pos: s.pos, notes: s.notes, eff: eff})
+	s.notes = nil
+	if len(s.block) == maxBlock {
+		s.flushBlock()
+	}
+}
+
+// Pos records the position of the code that follows.
+func (s *ScheduleSink) Pos(pos scanner.Position) {
+	s.pos = pos
+}
+
+// Comment holds a comment for the instruction that follows.
+func (s *ScheduleSink) Comment(text string) {
+	s.notes = append(s.notes, text)
+}
+
+// Flush schedules the block held and passes it on.
+func (s *ScheduleSink) Flush() {
+	s.flushBlock()
+	s.flushNotes()
+}
+
+// flushNotes passes on the position and the comments held.
+func (s *ScheduleSink) flushNotes() {
+	s.passPos(s.pos)
+	for _, text := range s.notes {
+		s.out.Comment(text)
+	}
+	s.notes = nil
+}
+
+// passPos passes pos on if it isn't the one passed last.
+func (s *ScheduleSink) passPos(pos scanner.Position) {
+	if pos != s.last {
+		s.out.Pos(pos)
+		s.last = pos
+	}
+}
+
+// flushBlock schedules the block and passes it on.
+func (s *ScheduleSink) flushBlock() {
+	s.depends()
+	for range s.block {
+		x := &s.block[s.pick()]
+		x.done = true
+		s.passPos(x.pos)
+		for _, text := range x.notes {
+			s.out.Comment(text)
+		}
+		s.out.Inst(x.op, x.operands)
+	}
+	s.block = s.block[:0]
+}
+
+// depends finds the instructions of the block that depend on each other
+// and the height of each instruction. An instruction that reads what
+// another one writes waits for the result of it, one that writes what
+// another one reads or writes only has to come after it.
+func (s *ScheduleSink) depends() {
+	type resource struct {
+		writer  int
+		readers []int
+	}
+	regs := make(map[string]*resource)
+	reg := func(name string) *resource {
+		r := regs[name]
+		if r == nil {
+			r = &resource{writer: -1}
+			regs[name] = r
+		}
+		return r
+	}
+
+	for j := range s.block {
+		y := &s.block[j]
+		for _, name := range y.eff.Reads {
+			if r := reg(name); r.writer >= 0 {
+				s.depend(r.writer, j, s.block[r.writer].eff.Latency)
+			}
+		}
+		for _, name := range y.eff.Writes {
+			r := reg(name)
+			if r.writer >= 0 {
+				s.depend(r.writer, j, 0)
+			}
+			for _, i := range r.readers {
+				s.depend(i, j, 0)
+			}
+		}
+		for _, name := range y.eff.Reads {
+			r := reg(name)
+			r.readers = append(r.readers, j)
+		}
+		for _, name := range y.eff.Writes {
+			r := reg(name)
+			r.writer, r.readers = j, nil
+		}
+
+		for i := 0; i < j; i++ {
+			x := &s.block[i]
+			for _, a := range x.eff.Mem {
+				for _, b := range y.eff.Mem {
+					switch {
+					case !overlap(a, b):
+					case a.Write:
+						s.depend(i, j, x.eff.Latency)
+					case b.Write:
+						s.depend(i, j, 0)
+					}
+				}
+			}
+		}
+	}
+
+	for i := len(s.block) - 1; i >= 0; i-- {
+		x := &s.block[i]
+		x.height = x.eff.Latency
+		for _, d := range x.succs {
+			if h := d.latency + s.block[d.to].height; h > x.height {
+				x.height = h
+			}
+		}
+	}
+}
+
+// depend records that instruction j of the block waits latency cycles
+// for instruction i.
+func (s *ScheduleSink) depend(i, j, latency int) {
+	x := &s.block[i]
+	for k, d := range x.succs {
+		if d.to == j {
+			if latency > d.latency {
+				x.succs[k].latency = latency
+			}
+			return
+		}
+	}
+	x.succs = append(x.succs, schedDep{j, latency})
+	s.block[j].npreds++
+}
+
+// pick picks the instruction of the block that is run next and makes
+// the ones that depend on it wait for it, an instruction is picked in
+// each cycle.
+func (s *ScheduleSink) pick() int {
+	cycle := 0
+	for _, x := range s.block {
+		if x.done && x.ready >= cycle {
+			cycle = x.ready
+		}
+	}
+
+	best := -1
+	for i := range s.block {
+		x := &s.block[i]
+		if x.done || x.npreds > 0 {
+			continue
+		}
+		if best < 0 {
+			best = i
+			continue
+		}
+		y := &s.block[best]
+		xwait, ywait := x.ready > cycle, y.ready > cycle
+		switch {
+		case xwait != ywait:
+			if !xwait {
+				best = i
+			}
+		case xwait && x.ready != y.ready:
+			if x.ready < y.ready {
+				best = i
+			}
+		case x.height > y.height:
+			best = i
+		}
+	}
+
+	x := &s.block[best]
+	start := cycle
+	if x.ready > start {
+		start = x.ready
+	}
+	x.ready = start + 1
+	for _, d := range x.succs {
+		y := &s.block[d.to]
+		y.npreds--
+		if t := start + d.latency; t > y.ready {
+			y.ready = t
+		}
+	}
+	return best
+}
+
+// overlap reports whether two accesses can touch the same memory.
+func overlap(a, b Access) bool {
+	switch {
+	case a.Area != b.Area:
+		return a.Area == OtherArea && b.Area != StackArea || b.Area == OtherArea && a.Area != StackArea
+	case a.Area == FrameArea:
+		return a.Off < b.Off+b.Size && b.Off < a.Off+a.Size
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+package arch
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/scanner"
+)
+
+// Sink is where a backend sends the code it generates, it receives
+// the code as a sequence of labels and instructions rather than text
+// so the code can be written out as assembly or assembled directly.
+type Sink interface {
+	// Label defines a label at the current location, an inline label
+	// shares its line with the instruction that follows it.
+	Label(name string, inline bool)
+
+	// Inst emits an instruction or a directive with its operands,
+	// operands is empty if there are none.
+	Inst(op, operands string)
+
+	// Pos marks the source position of the code that follows.
+	Pos(pos scanner.Position)
+
+	// Comment emits a comment on the code, an empty comment is a
+	// blank line separating the code. Sinks that don't produce text
+	// drop them.
+	Comment(text string)
+}
+
+// TextSink is a sink that writes assembly text to a writer.
+type TextSink struct {
+	W io.Writer
+
+	// Source returns the text of a line in a source file, if it is set
+	// the code is annotated with comments holding the source lines.
+	Source func(filename string, line int) string
+
+	pos    scanner.Position // position of the last annotation
+	next   scanner.Position // position of the code that follows
+	inline bool
+}
+
+// NewTextSink returns a sink that writes assembly text to w.
+func NewTextSink(w io.Writer) *TextSink {
+	return &TextSink{W: w}
+}
+
+// Label writes a label.
+func (t *TextSink) Label(name string, inline bool) {
+	t.annotate()
+	t.inline = inline
+	if inline {
+		fmt.Fprintf(t.W, "%s:", name)
+	} else {
+		fmt.Fprintf(t.W, "%s:\n", name)
+	}
+}
+
+// Inst writes an instruction.
+func (t *TextSink) Inst(op, operands string) {
+	t.annotate()
+	t.inline = false
+	if operands == "" {
+		fmt.Fprintf(t.W, "\t%s\n", op)
+	} else {
+		fmt.Fprintf(t.W, "\t%s\t%s\n", op, operands)
+	}
+}
+
+// Pos records the source position of the code that follows.
+func (t *TextSink) Pos(pos scanner.Position) {
+	t.next = pos
+}
+
+// Comment writes a comment on a line of its own, or a blank line.
+func (t *TextSink) Comment(text string) {
+	if t.inline {
+		fmt.Fprintln(t.W)
+		t.inline = false
+	}
+	if text == "" {
+		fmt.Fprintln(t.W)
+	} else {
+		fmt.Fprintf(t.W, "# %s\n", text)
+	}
+}
+
+// annotate writes a comment with the source line of the
+// code about to be written, if it is on a new line.
+func (t *TextSink) annotate() {
+	pos := t.next
+	if t.Source == nil || t.inline || !pos.IsValid() {
+		return
+	}
+	if pos.Filename == t.pos.Filename && pos.Line == t.pos.Line {
+		return
+	}
+	t.pos = pos
+	fmt.Fprintf(t.W, "# %s:%d: %s\n", pos.Filename, pos.Line, strings.TrimSpace(t.Source(pos.Filename, pos.Line)))
+}
@@ -5,6 +5,9 @@
 package compile
 
 import (
+	"context"
+	"io"
+
 	"subc/ast"
 	"subc/compile/arch"
 	"subc/scan"
@@ -13,18 +16,44 @@ import (
 
 // Config provide options for how the compiler will act when compiling.
 type Config struct {
-	Emitter   *arch.Emitter // the code emitter for the compiler, needed for generating code for an architecture
-	MaxErrors int           // max number of errors before bailing out
+	Emitter    *arch.Emitter   // the code emitter for the compiler, needed for generating code for an architecture
+	MaxErrors  int             // max number of errors before bailing out
+	Common     bool            // emit tentative definitions of scalars as common symbols instead of zeroed data
+	Pool       bool            // emit identical string literals only once
+	Hoist      bool            // hoist loads of globals that loops don't change out of them
+	Reduce     bool            // strength reduce the array indexing by loop counters
+	Idioms     bool            // replace the loops that copy or fill arrays by calls to memcpy and memset
+	Blocks     bool            // copy and fill the arrays of those loops in line, if the emitter can
+	Layout     bool            // lay out the body of for loops before their post statement
+	Extend     bool            // truncate the values converted to char like other compilers do
+	Bools      bool            // simplify the logical nots and normalizations of comparisons
+	Truncate   bool            // divide by the powers of two toward zero, which a right shift doesn't do for negative dividends
+	Index      bool            // address array elements with the scaled index addressing of the target
+	Align      bool            // align the function entries and the headers of the innermost loops, except in cold functions
+	Frames     io.Writer       // where the layout of the frame of each function is written, if not nil
+	MaxFrame   int             // warn about the functions whose frames take more bytes than this, if not 0
+	Registers  bool            // keep the locals declared register in registers, if the emitter has them
+	NullChecks bool            // check the pointers against null before they are dereferenced, reporting where
+	Instrument bool            // call the hooks of -finstrument-functions when the functions are entered and return
+	Ident      string          // the version, target and options of the compiler recorded in the object, if not empty
+	Omit       map[string]bool // the globals that aren't emitted, as the program never reaches them
+	Dump       io.Writer       // where the trees are dumped before and after the passes that optimize them, if not nil
+	DumpPass   string          // the pass the trees are dumped around, all of them if empty
 }
 
 // Compile compiles a AST tree down to native machine code.
 // It assumes a correct AST and valid typed check structure for the AST.
-func Compile(conf Config, prog *ast.Prog, info *types.Info) error {
+// The compilation stops early with the context error if ctx is canceled.
+// The errors are a *scan.ErrorList, scan.DiagnosticsOf returns them as
+// diagnostics.
+func Compile(ctx context.Context, conf Config, prog *ast.Prog, info *types.Info) error {
 	c := &compiler{
+		ctx:  ctx,
 		Info: info,
 		conf: conf,
 		cg:   conf.Emitter,
 		sym:  make(map[types.Object]*arch.LV),
+		strs: make(map[string]arch.Label),
 	}
 	return c.Compile(prog)
 }
@@ -32,15 +61,61 @@ func Compile(conf Config, prog *ast.Prog, info *types.Info) error {
 // compiler holds the structure for the compiler during execution.
 type compiler struct {
 	*types.Info
+	ctx    context.Context
 	cg     *arch.Emitter
 	conf   Config
 	errors scan.ErrorList
+	err    error // set when the compilation was canceled
+
+	sym      map[types.Object]*arch.LV
+	defs     map[string]*ast.VarDecl // the declaration that defines each global variable
+	strs     map[string]arch.Label   // labels of the string literals emitted so far
+	addr     map[types.Object]bool   // the variables that have their address taken
+	volatile map[string]bool         // the globals declared volatile
+	sections map[string]arch.Section // the section of the text of the hot and cold functions
+	aligns   map[string]int          // the alignment of the globals declared aligned
+	idioms   bool                    // the loops that copy or fill arrays are replaced, the program doesn't define memcpy or memset
+	untraced map[string]bool         // the functions declared no_instrument_function
+	fn       *function
+}
 
-	sym map[types.Object]*arch.LV
+// function holds the code generation state of the function being
+// compiled. A new one is made for every function so nothing carries
+// over from one function to the next.
+type function struct {
+	name          string                    // name of the function
+	trees         int                       // trees optimized so far, numbered in the dumps
+	sym           map[types.Object]*arch.LV // parameters and local variables
+	lsize         int                       // stack space used by the local variables
+	regs          []*arch.LV                // locals kept in registers, their slots save the registers
+	frame         []frameSlot               // slots of the parameters, the locals and the compiler, for the report of the frame
+	retlab        arch.Label                // label that return statements jump to
+	result        types.Type                // type of the result
+	cold          bool                      // the function is declared cold
+	labels        map[string]arch.Label     // labels for goto statements
+	breakStack    []arch.Label
+	continueStack []arch.Label
+	hoists        map[ast.Stmt][]*types.Var       // globals hoisted out of each loop
+	slots         map[string]int                  // stack slots of the hoisted globals
+	hoisted       map[string]int                  // hoisted globals read from their slots now
+	inductions    map[*ast.ForStmt]*inductionLoop // loops with array walks
+	idioms        map[*ast.ForStmt]*idiom         // loops that copy or fill arrays
+	hookSlot      int                             // slot of the result while the exit hook runs, 0 if there are no hooks
+	walks         map[*ast.IndexExpr]*walk        // indexing reading through a walk now
+}
 
-	labels        map[string]int
-	breakStack    []int
-	continueStack []int
+// newFunction creates the code generation state for a function.
+func newFunction() *function {
+	return &function{
+		sym:        make(map[types.Object]*arch.LV),
+		labels:     make(map[string]arch.Label),
+		hoists:     make(map[ast.Stmt][]*types.Var),
+		slots:      make(map[string]int),
+		hoisted:    make(map[string]int),
+		inductions: make(map[*ast.ForStmt]*inductionLoop),
+		idioms:     make(map[*ast.ForStmt]*idiom),
+		walks:      make(map[*ast.IndexExpr]*walk),
+	}
 }
 
 // Compile compiles an AST tree.
@@ -51,7 +126,10 @@ func (c *compiler) Compile(prog *ast.Prog) (err error) {
 				panic(e)
 			}
 		}
-		err = c.errors.Err()
+		err = c.err
+		if err == nil {
+			err = c.errors.Err()
+		}
 	}()
 
 	c.top(prog)
@@ -61,14 +139,41 @@ func (c *compiler) Compile(prog *ast.Prog) (err error) {
 // top represents the top level declaration of the code.
 // It will walk through the declarations and generate code for them.
 func (c *compiler) top(prog *ast.Prog) {
+	c.defs = c.definitions(prog)
+	c.addr = c.addressTaken(prog)
+	c.volatile = volatileGlobals(prog)
+	c.sections = funcSections(prog)
+	c.aligns = c.varAligns(prog)
+	c.idioms = c.conf.Idioms && !definesMem(prog)
+	c.untraced = noInstrument(prog)
+	c.cg.Symbols = asmLabels(prog)
+	// the labels are numbered in each translation unit, its code is
+	// the same whichever units are compiled with it and in what order
+	c.cg.ResetLabels()
 	c.cg.Prelude()
 	for _, d := range prog.Decls {
+		c.checkCanceled()
 		switch d := d.(type) {
 		case *ast.VarDecl:
-			c.varDecl(d)
+			if !c.conf.Omit[d.Name.Name] {
+				c.varDecl(d)
+			}
 		case *ast.FuncDecl:
-			c.funcDecl(d)
+			if !c.conf.Omit[d.Name.Name] {
+				c.funcDecl(d)
+			}
 		}
 	}
+	if c.conf.Ident != "" {
+		c.cg.Ident(c.conf.Ident)
+	}
 	c.cg.Postlude()
 }
+
+// checkCanceled bails out of the compilation if the context is done.
+func (c *compiler) checkCanceled() {
+	if err := c.ctx.Err(); err != nil {
+		c.err = err
+		panic(bailout{})
+	}
+}
@@ -6,13 +6,14 @@ import (
 
 	"subc/ast"
 	"subc/compile/arch"
+	"subc/constant"
 	"subc/scan"
 	"subc/types"
 )
 
 // varDecl emits code for global variable declarations.
 func (c *compiler) varDecl(d *ast.VarDecl) {
-	intSize := c.cg.Int()
+	c.cg.Pos(d.Span().Start)
 	v, found := c.variable(d.Name, c.Defs)
 	if !found {
 		return
@@ -27,52 +28,223 @@ func (c *compiler) varDecl(d *ast.VarDecl) {
 		Storage: storage,
 	}
 	c.sym[v] = lv
+	if c.defs[name] != d {
+		return
+	}
+	if storage == types.Public || storage == types.GlobalStatic {
+		c.cg.Blank()
+		c.cg.Comment("%s: %s %s", name, storage, typ)
+	}
 
 	_, isArray := typ.(*types.Array)
 	if isArray && d.Value != nil {
+		addrs := c.arrayAddrs(d.Value)
 		c.cg.Data()
+		if align := c.aligns[name]; align > 0 {
+			c.cg.AlignData(align)
+		}
 		c.cg.Name(name)
+		c.arrayData(typ.(*types.Array), d.Value, addrs)
 
-		switch x := d.Value.(type) {
-		case *ast.StringLit:
-			text := ""
-			for _, lit := range x.Lits {
-				text += lit.Text[1 : len(lit.Text)-1]
+		if storage == types.Public {
+			c.cg.Public(name)
+		}
+	} else {
+		c.defineGlobal(v, d.Value)
+	}
+}
+
+// arrayAddrs evaluates the address constants that initialize the
+// elements of a static array, by their index. It is called before the
+// data of the array is emitted, as it emits the strings.
+func (c *compiler) arrayAddrs(value ast.Expr) map[int]address {
+	addrs := make(map[int]address)
+	if x, ok := value.(*ast.CompositeLit); ok {
+		for i, e := range x.Elts {
+			if a, ok := c.addrConst(e); ok {
+				addrs[i] = a
 			}
-			c.cg.Defs(text)
-			c.cg.Defb(0)
-			c.cg.Align(len(text)+1, intSize)
+		}
+	}
+	return addrs
+}
 
-		case *ast.CompositeLit:
-			prim := primType(typ)
-			for _, elts := range x.Elts {
-				tv, found := c.typAndValue(elts)
+// arrayData emits the data of a static array initialized by value, with
+// the address constants addrs.
+func (c *compiler) arrayData(array *types.Array, value ast.Expr, addrs map[int]address) {
+	intSize := c.cg.Int()
+	length := int(array.Len())
+	switch x := value.(type) {
+	case *ast.StringLit:
+		n := c.defsChunked(x)
+		for i := n; i < length; i++ {
+			c.cg.Defb(0)
+		}
+		c.cg.Align(length, intSize)
+
+	case *ast.CompositeLit:
+		prim := primType(array)
+		for i := 0; i < length; i++ {
+			if a, ok := addrs[i]; ok {
+				c.cg.Defa(a.lv, a.off)
+				continue
+			}
+			n := 0
+			if i < len(x.Elts) {
+				tv, found := c.typAndValue(x.Elts[i])
 				if !found {
 					continue
 				}
+				n, _ = strconv.Atoi(tv.Value.String())
+			}
 
-				n, _ := strconv.Atoi(tv.Value.String())
-				switch prim {
-				case types.Typ[types.Char]:
-					c.cg.Defb(n)
-				default:
-					c.cg.Defw(n)
-				}
+			switch prim {
+			case types.Typ[types.Char]:
+				c.cg.Defb(n)
+			default:
+				c.cg.Defw(n)
 			}
-			if prim == types.Typ[types.Char] {
-				c.cg.Align(len(x.Elts), intSize)
+		}
+		if prim == types.Typ[types.Char] {
+			c.cg.Align(length, intSize)
+		}
+
+	default:
+		panic(fmt.Sprintf("unknown value for code generation: %T", x))
+	}
+}
+
+// dataChunk is the most bytes of a string initializer emitted at once.
+const dataChunk = 4096
+
+// defsChunked emits the pieces of a string initializer a chunk at a
+// time rather than joining them first, so a blob of megabytes embedded
+// as a char array isn't copied whole. It returns the length of the
+// string.
+func (c *compiler) defsChunked(x *ast.StringLit) int {
+	n := 0
+	for _, lit := range x.Lits {
+		text := lit.Text[1 : len(lit.Text)-1]
+		for len(text) > dataChunk {
+			c.cg.Defs(text[:dataChunk])
+			text = text[dataChunk:]
+			n += dataChunk
+		}
+		c.cg.Defs(text)
+		n += len(text)
+	}
+	return n
+}
+
+// definitions picks the declaration that defines each global variable,
+// the one with an initializer or else the first tentative definition
+// that gives the size of an array, so a variable declared more than
+// once is only emitted once.
+func (c *compiler) definitions(prog *ast.Prog) map[string]*ast.VarDecl {
+	defs := make(map[string]*ast.VarDecl)
+	for _, d := range prog.Decls {
+		d, ok := d.(*ast.VarDecl)
+		if !ok || (d.Storage != nil && d.Storage.Type == scan.Extern) {
+			continue
+		}
+		x := defs[d.Name.Name]
+		switch {
+		case x == nil,
+			x.Value == nil && d.Value != nil,
+			x.Value == nil && c.incomplete(x) && !c.incomplete(d):
+			defs[d.Name.Name] = d
+		}
+	}
+	return defs
+}
+
+// funcSections finds the section of the text that each function declared
+// hot or cold goes in, the attribute can be given by any declaration.
+func funcSections(prog *ast.Prog) map[string]arch.Section {
+	sections := make(map[string]arch.Section)
+	for _, d := range prog.Decls {
+		d, ok := d.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		for _, a := range d.Attrs {
+			switch a.Name.Name {
+			case "hot":
+				sections[d.Name.Name] = arch.HotText
+			case "cold":
+				sections[d.Name.Name] = arch.ColdText
 			}
+		}
+	}
+	return sections
+}
 
-		default:
-			panic(fmt.Sprintf("unknown value for code generation: %T", x))
+// asmLabels finds the symbol that the asm labels give to each global and
+// function, which any of its declarations can give.
+func asmLabels(prog *ast.Prog) map[string]string {
+	syms := make(map[string]string)
+	for _, d := range prog.Decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			if d.Asm != nil {
+				syms[d.Name.Name] = types.AsmName(d.Asm)
+			}
+		case *ast.VarDecl:
+			if d.Asm != nil {
+				syms[d.Name.Name] = types.AsmName(d.Asm)
+			}
 		}
+	}
+	return syms
+}
 
-		if storage == types.Public {
-			c.cg.Public(name)
+// varAligns finds the alignment of each global variable declared
+// aligned, the largest one that any of its declarations gives.
+func (c *compiler) varAligns(prog *ast.Prog) map[string]int {
+	aligns := make(map[string]int)
+	for _, d := range prog.Decls {
+		d, ok := d.(*ast.VarDecl)
+		if !ok {
+			continue
+		}
+		if align := c.declAlign(d); align > aligns[d.Name.Name] {
+			aligns[d.Name.Name] = align
+		}
+	}
+	return aligns
+}
+
+// declAlign returns the alignment that the aligned attributes of d
+// give, 0 if it has none.
+func (c *compiler) declAlign(d *ast.VarDecl) int {
+	align := 0
+	for _, a := range d.Attrs {
+		if a.Name.Name != "aligned" {
+			continue
+		}
+		n := types.DefaultVarAlign
+		if len(a.Args) == 1 {
+			tv, found := c.typAndValue(a.Args[0])
+			if !found || tv.Value == nil {
+				continue
+			}
+			n, _ = strconv.Atoi(tv.Value.String())
+		}
+		if n > align {
+			align = n
 		}
-	} else {
-		c.defineGlobal(v)
 	}
+	return align
+}
+
+// incomplete reports whether d declares an array of unknown size.
+func (c *compiler) incomplete(d *ast.VarDecl) bool {
+	v, found := c.Defs[d.Name]
+	if !found {
+		return false
+	}
+	a, ok := v.Type().(*types.Array)
+	return ok && a.Len() < 0
 }
 
 // funcDecl emits code for function declarations
@@ -90,18 +262,23 @@ func (c *compiler) funcDecl(d *ast.FuncDecl) {
 	}
 
 	name := d.Name.Name
-
-	intSize := c.cg.Int()
-	addr := 2 * intSize
+	fn := newFunction()
+	fn.name = name
+	fn.result = ret.Type
+	c.fn = fn
+	defer func() { c.fn = nil }()
+
+	wordSize := c.cg.Word()
+	addr := 2 * wordSize
 	for _, p := range d.Params {
 		if p.Name == nil {
-			addr += intSize
+			addr += wordSize
 			continue
 		}
 
 		v, found := c.variable(p.Name, c.Defs)
 		if !found {
-			addr += intSize
+			addr += wordSize
 			continue
 		}
 
@@ -110,13 +287,21 @@ func (c *compiler) funcDecl(d *ast.FuncDecl) {
 			Type:    v.Type(),
 			Value:   v.Value(),
 			Storage: types.Auto,
-			Addr:    addr,
+			Addr:    c.wordAddr(addr, v.Type()),
 		}
-		c.sym[v] = lv
-		addr += intSize
+		fn.sym[v] = lv
+		fn.slot(addr, wordSize, p.Name.Name, "parameter")
+		addr += wordSize
 	}
 
-	lsize, localInits := c.localDecls(d.Decls)
+	c.cg.Pos(d.Span().Start)
+	c.cg.Blank()
+	c.cg.Comment("function %s", name)
+	lsize, localInits := c.localDecls(d.Decls, 0)
+	lsize, _ = c.localDecls(stmtExprDecls(d.Body), lsize)
+	c.planIdioms(d)
+	fn.lsize = c.planInstrument(d, c.planInduction(d, c.planHoists(d, lsize)))
+	c.cg.Section(c.sections[name])
 	c.cg.Text()
 
 	if d.Storage == nil || d.Storage.Type == scan.Extern {
@@ -124,30 +309,43 @@ func (c *compiler) funcDecl(d *ast.FuncDecl) {
 	}
 
 	c.cg.AlignText()
+	fn.cold = c.sections[name] == arch.ColdText
+	if c.conf.Align && !fn.cold {
+		c.cg.AlignCode(codeAlign)
+	}
 	c.cg.Name(name)
 	c.cg.Entry()
-	c.cg.Stack(lsize)
+	c.cg.Comment("%d bytes of locals", -fn.lsize)
+	c.cg.Stack(fn.lsize)
+	c.cg.SaveRegs(fn.regs)
 	c.cg.LocInit(localInits)
-	c.cg.Retlab = c.cg.Label()
+	c.regInits(d.Decls)
+	c.arrayInits(d.Decls)
+	fn.retlab = c.cg.Label()
 
-	c.labels = make(map[string]int)
 	for _, l := range d.Labels {
-		c.labels[l.Label.Name] = c.cg.Label()
+		c.label(l.Label.Name)
 	}
+	c.enterFunc()
 
 	for _, s := range d.Body.Stmt {
 		c.stmt(s)
 	}
 
-	c.cg.Lab(c.cg.Retlab)
-	c.cg.Stack(-lsize)
+	c.cg.Pos(d.Body.Span().End)
+	c.cg.Comment("return from %s", name)
+	c.cg.Lab(fn.retlab)
+	c.exitFunc()
+	c.cg.RestoreRegs(fn.regs)
+	c.cg.Stack(-fn.lsize)
 	c.cg.Exit()
+	c.frame(d)
 }
 
-// localDecls emits code for local variable declarations.
-func (c *compiler) localDecls(d []ast.Decl) (stackSize int, localInits [][2]int) {
-	intSize := c.cg.Int()
-	addr := 0
+// localDecls emits code for local variable declarations, the locals are
+// placed below addr.
+func (c *compiler) localDecls(d []ast.Decl, addr int) (stackSize int, localInits [][2]int) {
+	wordSize := c.cg.Word()
 	for _, d := range d {
 		pos := d.Span().Start
 		switch d := d.(type) {
@@ -164,12 +362,12 @@ func (c *compiler) localDecls(d []ast.Decl) (stackSize int, localInits [][2]int)
 			val := v.Value()
 			storage := v.Storage()
 			size := int(c.cg.Sizeof(typ))
-			size = (size + intSize - 1) / intSize * intSize
+			size = (size + wordSize - 1) / wordSize * wordSize
 			lv := &arch.LV{}
 
 			switch storage {
 			case types.LocalStatic:
-				c.defineLocal(v, lv)
+				c.defineLocal(v, lv, d.Value, c.declAlign(d))
 
 			case types.Extern:
 				// nothing
@@ -177,14 +375,21 @@ func (c *compiler) localDecls(d []ast.Decl) (stackSize int, localInits [][2]int)
 			case types.Auto:
 				addr -= int(size)
 				lv.Size = int(size)
-				lv.Addr = addr
+				lv.Addr = c.wordAddr(addr, typ)
+				what := "local"
+				if c.inRegister(v) {
+					lv.Reg = len(c.fn.regs) + 1
+					c.fn.regs = append(c.fn.regs, lv)
+					what = "register local"
+				}
+				c.fn.slot(addr, size, v.Name(), what)
 
 			default:
 				c.errorf(pos, "unknown storage: %v", storage)
 			}
-			c.sym[v] = lv
+			c.fn.sym[v] = lv
 
-			if val != nil && storage == types.Auto {
+			if val != nil && storage == types.Auto && lv.Reg == 0 {
 				n, _ := strconv.Atoi(val.String())
 				localInits = append(localInits, [2]int{addr, n})
 			}
@@ -197,6 +402,143 @@ func (c *compiler) localDecls(d []ast.Decl) (stackSize int, localInits [][2]int)
 	return addr, localInits
 }
 
+// inRegister reports whether local v is kept in a register: it is
+// declared register, the emitter has a register left for it and it is
+// an int or a pointer, which fill the register.
+func (c *compiler) inRegister(v *types.Var) bool {
+	if !c.conf.Registers || !v.Register() || len(c.fn.regs) >= c.cg.RegVars {
+		return false
+	}
+	switch typ := v.Type().Underlying().(type) {
+	case *types.Pointer:
+		return true
+	case *types.Basic:
+		return typ == types.Typ[types.Int] && c.cg.Sizeof(typ) == c.cg.Word()
+	}
+	return false
+}
+
+// regInits emits code to initialize the register variables declared by
+// d, which have no slot of their own to be initialized in.
+func (c *compiler) regInits(d []ast.Decl) {
+	for _, d := range d {
+		d, ok := d.(*ast.VarDecl)
+		if !ok {
+			continue
+		}
+		v, ok := c.Defs[d.Name].(*types.Var)
+		if !ok || v.Value() == nil || c.fn.sym[v] == nil || c.fn.sym[v].Reg == 0 {
+			continue
+		}
+		n, _ := strconv.Atoi(v.Value().String())
+		c.cg.Lit(n)
+		c.cg.Commit()
+		c.cg.Store(arch.LV{Ident: true, Type: v.Type(), Storage: types.Auto, Reg: c.fn.sym[v].Reg})
+		c.cg.Clear(true)
+	}
+}
+
+// wordAddr returns the address of a local of type T in the word at addr.
+// An argument is pushed as a word and an initializer is stored as one,
+// so a char is the low byte of its word, which is the last one on a
+// big-endian target.
+func (c *compiler) wordAddr(addr int, T types.Type) int {
+	if c.cg.BigEndian() && T == types.Typ[types.Char] {
+		return addr + c.cg.Word() - 1
+	}
+	return addr
+}
+
+// stmtExprDecls returns the declarations of the statement expressions
+// in body. Their locals have places in the frame of the function with
+// the others, but they are initialized when the statements run.
+func stmtExprDecls(body ast.Node) []ast.Decl {
+	var decls []ast.Decl
+	ast.Inspect(body, func(n ast.Node) bool {
+		if e, ok := n.(*ast.StmtExpr); ok {
+			decls = append(decls, e.Decls...)
+		}
+		return true
+	})
+	return decls
+}
+
+// arrayInits emits code to store the initializers of the local arrays,
+// the elements an initializer doesn't give are cleared.
+func (c *compiler) arrayInits(decls []ast.Decl) {
+	for _, d := range decls {
+		d, ok := d.(*ast.VarDecl)
+		if !ok || d.Value == nil {
+			continue
+		}
+		v, ok := c.Defs[d.Name].(*types.Var)
+		if !ok || v.Storage() != types.Auto {
+			continue
+		}
+		array, ok := v.Type().(*types.Array)
+		if !ok {
+			continue
+		}
+
+		addr := c.fn.sym[v].Addr
+		elem := array.Elem()
+		size := c.cg.Sizeof(elem)
+		length := int(array.Len())
+		switch x := d.Value.(type) {
+		case *ast.StringLit:
+			text := ""
+			for _, lit := range x.Lits {
+				text += lit.Text[1 : len(lit.Text)-1]
+			}
+			n := len(text) + 1
+			if n > length {
+				n = length
+			}
+			c.cg.Addr(arch.LV{Storage: types.Auto, Addr: addr})
+			c.cg.Commit()
+			c.cg.Ldlab(c.stringLit(text))
+			c.cg.Commit()
+			c.cg.Copy(n, 1)
+			c.cg.Clear(true)
+			if n < length {
+				c.cg.Addr(arch.LV{Storage: types.Auto, Addr: addr + n})
+				c.cg.Commit()
+				c.cg.Zero(length-n, 1)
+				c.cg.Clear(true)
+			}
+
+		case *ast.CompositeLit:
+			zeroed := len(x.Elts) < length
+			if zeroed {
+				c.cg.Addr(arch.LV{Storage: types.Auto, Addr: addr})
+				c.cg.Commit()
+				c.cg.Zero(c.cg.Sizeof(array), int(c.cg.Alignof(array)))
+				c.cg.Clear(true)
+			}
+			for i, e := range x.Elts {
+				tv, found := c.typAndValue(e)
+				if !found {
+					continue
+				}
+				// the addresses of labels are the only elements
+				// that aren't numbers
+				if u, ok := e.(*ast.UnaryExpr); ok && u.Op.Type == scan.Land {
+					c.cg.Ldlab(c.label(u.X.(*ast.Ident).Name))
+				} else {
+					n, _ := strconv.Atoi(tv.Value.String())
+					if n == 0 && zeroed {
+						continue
+					}
+					c.cg.Lit(n)
+				}
+				c.cg.Commit()
+				c.cg.Store(arch.LV{Ident: true, Type: elem, Storage: types.Auto, Addr: addr + i*size})
+				c.cg.Clear(true)
+			}
+		}
+	}
+}
+
 // variable looks up a variable given its identifier.
 func (c *compiler) variable(d *ast.Ident, m map[*ast.Ident]types.Object) (*types.Var, bool) {
 	pos := d.Span().Start
@@ -221,12 +563,14 @@ func (c *compiler) variable(d *ast.Ident, m map[*ast.Ident]types.Object) (*types
 	return v, true
 }
 
-// defineGlobal defines a variable at the file scope level.
-func (c *compiler) defineGlobal(v *types.Var) {
+// defineGlobal defines a variable at the file scope level, initialized
+// by value if it isn't nil.
+func (c *compiler) defineGlobal(v *types.Var, value ast.Expr) {
 	storage := v.Storage()
 	if storage != types.Public && storage != types.GlobalStatic {
 		return
 	}
+	addr, isAddr := c.addrConst(value)
 
 	c.cg.Data()
 
@@ -235,6 +579,7 @@ func (c *compiler) defineGlobal(v *types.Var) {
 
 	name := v.Name()
 	gname := c.cg.Gsym(name)
+	align := c.aligns[name]
 	isStatic := storage == types.GlobalStatic
 	if storage == types.Public {
 		c.cg.Public(name)
@@ -246,6 +591,13 @@ func (c *compiler) defineGlobal(v *types.Var) {
 	isRecord := isRecord(typ, true)
 
 	if !isArray && !isRecord {
+		if c.conf.Common && v.Value() == nil && !isAddr {
+			c.cg.BSS(gname, c.cg.Sizeof(typ), align, isStatic)
+			return
+		}
+		if align > 0 {
+			c.cg.AlignData(align)
+		}
 		c.cg.Name(name)
 	}
 
@@ -261,34 +613,134 @@ func (c *compiler) defineGlobal(v *types.Var) {
 
 	switch {
 	case isRecord:
-		c.cg.BSS(gname, c.cg.Sizeof(typ), isStatic)
+		c.cg.BSS(gname, c.cg.Sizeof(typ), align, isStatic)
 	case prim == types.Typ[types.Char]:
 		if isArray {
-			c.cg.BSS(gname, size, isStatic)
+			c.cg.BSS(gname, size, align, isStatic)
 		} else {
 			c.cg.Defb(val)
 			c.cg.Align(1, intSize)
 		}
 	case prim == types.Typ[types.Int]:
 		if isArray {
-			c.cg.BSS(gname, size*intSize, isStatic)
+			c.cg.BSS(gname, size*intSize, align, isStatic)
 		} else {
 			c.cg.Defw(val)
 		}
 	default:
-		if isArray {
-			c.cg.BSS(gname, size*ptrSize, isStatic)
-		} else {
+		switch {
+		case isArray:
+			c.cg.BSS(gname, size*ptrSize, align, isStatic)
+		case isAddr:
+			c.cg.Defa(addr.lv, addr.off)
+		default:
 			c.cg.Defp(val)
 		}
 	}
 }
 
-// defineLocal defines a variable at a function scope.
-func (c *compiler) defineLocal(v *types.Var, lv *arch.LV) {
+// address is an address constant, the address of a global, a function,
+// a local static, a label or a string plus off bytes.
+type address struct {
+	lv  arch.LV
+	off int
+}
+
+// addrConst evaluates the initializer e of a static pointer if it is an
+// address constant, the type checker allows no other initializers that
+// aren't numbers. The strings it takes the address of are emitted.
+func (c *compiler) addrConst(e ast.Expr) (a address, ok bool) {
+	if e == nil {
+		return a, false
+	}
+	tv := c.Types[e]
+	if tv.Value != nil {
+		if tv.Value.Type() != constant.String {
+			return a, false
+		}
+		str, _ := strconv.Unquote(tv.Value.String())
+		lv := arch.LV{Storage: types.LocalStatic, Label: c.stringLit(str)}
+		return address{lv: lv}, true
+	}
+
+	switch e := e.(type) {
+	case *ast.ParenExpr:
+		return c.addrConst(e.X)
+	case *ast.CastExpr:
+		return c.addrConst(e.X)
+	case *ast.UnaryExpr:
+		switch e.Op.Type {
+		case scan.Land:
+			lv := arch.LV{Storage: types.LocalStatic, Label: c.label(e.X.(*ast.Ident).Name)}
+			return address{lv: lv}, true
+		case scan.And:
+			return c.staticAddr(e.X)
+		}
+	case *ast.BinaryExpr:
+		x, y := e.X, e.Y
+		if tv := c.Types[x]; e.Op.Type == scan.Plus && tv.Value != nil && tv.Value.Type() == constant.Int {
+			x, y = y, x
+		}
+		if a, ok = c.addrConst(x); !ok {
+			return a, false
+		}
+		n, _ := strconv.Atoi(c.Types[y].Value.String())
+		if e.Op.Type == scan.Minus {
+			n = -n
+		}
+		if p, isPtr := c.Types[x].Type.Underlying().(*types.Pointer); isPtr {
+			n *= max(c.cg.Sizeof(p.Elem()), 1)
+		}
+		a.off += n
+		return a, true
+	case *ast.Ident, *ast.IndexExpr, *ast.SelectorExpr:
+		return c.staticAddr(e)
+	}
+	return a, false
+}
+
+// staticAddr returns the address of the static object e, a global, a
+// function or a local static, or an element or a field of one.
+func (c *compiler) staticAddr(e ast.Expr) (a address, ok bool) {
+	switch e := e.(type) {
+	case *ast.ParenExpr:
+		return c.staticAddr(e.X)
+	case *ast.Ident:
+		a.lv = arch.LV{Name: e.Name, Storage: types.Public}
+		if v, isVar := c.Uses[e].(*types.Var); isVar && v.Storage() == types.LocalStatic {
+			s, found := c.symbol(v)
+			if !found {
+				return a, false
+			}
+			a.lv = arch.LV{Storage: types.LocalStatic, Label: s.Label}
+		}
+		return a, true
+	case *ast.IndexExpr:
+		if a, ok = c.staticAddr(e.X); ok {
+			n, _ := strconv.Atoi(c.Types[e.Index].Value.String())
+			a.off += n * c.cg.Sizeof(c.Types[e].Type)
+		}
+		return a, ok
+	case *ast.SelectorExpr:
+		if a, ok = c.staticAddr(e.X); ok {
+			if sel := c.Selections[e]; !sel.IsUnion() {
+				a.off += int(sel.Offset())
+			}
+		}
+		return a, ok
+	}
+	return a, false
+}
+
+// defineLocal defines a static variable at a function scope, initialized
+// by value if it isn't nil and aligned to align if it isn't 0.
+func (c *compiler) defineLocal(v *types.Var, lv *arch.LV, value ast.Expr, align int) {
+	addr, isAddr := c.addrConst(value)
+	addrs := c.arrayAddrs(value)
 	val := c.cg.Label()
-	lv.Addr = val
+	lv.Label = val
 	c.cg.Data()
+	c.cg.Comment("%s: %s %s", v.Name(), v.Storage(), v.Type())
 
 	intSize := c.cg.Int()
 	ptrSize := c.cg.Pointer()
@@ -308,17 +760,29 @@ func (c *compiler) defineLocal(v *types.Var, lv *arch.LV) {
 		init, _ = strconv.Atoi(x.String())
 	}
 
+	if isArray && value != nil {
+		if align > 0 {
+			c.cg.AlignData(align)
+		}
+		c.cg.Lab(val)
+		c.arrayData(array, value, addrs)
+		return
+	}
+
 	if !isArray && !isRecord {
+		if align > 0 {
+			c.cg.AlignData(align)
+		}
 		c.cg.Lab(val)
 	}
 
 	switch {
 	case isRecord:
-		c.cg.BSS(c.cg.Labname(val), c.cg.Sizeof(typ), true)
+		c.cg.BSS(c.cg.Labname(val), c.cg.Sizeof(typ), align, true)
 
 	case prim == types.Typ[types.Char]:
 		if isArray {
-			c.cg.BSS(c.cg.Labname(val), size, true)
+			c.cg.BSS(c.cg.Labname(val), size, align, true)
 		} else {
 			c.cg.Defb(init)
 			c.cg.Align(1, intSize)
@@ -326,15 +790,18 @@ func (c *compiler) defineLocal(v *types.Var, lv *arch.LV) {
 
 	case prim == types.Typ[types.Int]:
 		if isArray {
-			c.cg.BSS(c.cg.Labname(val), size*intSize, true)
+			c.cg.BSS(c.cg.Labname(val), size*intSize, align, true)
 		} else {
 			c.cg.Defw(init)
 		}
 
 	default:
-		if isArray {
-			c.cg.BSS(c.cg.Labname(val), size*ptrSize, true)
-		} else {
+		switch {
+		case isArray:
+			c.cg.BSS(c.cg.Labname(val), size*ptrSize, align, true)
+		case isAddr:
+			c.cg.Defa(addr.lv, addr.off)
+		default:
 			c.cg.Defp(init)
 		}
 	}
@@ -18,4 +18,8 @@ func (c *compiler) errorf(pos scanner.Position, format string, args ...interface
 	}
 }
 
+func (c *compiler) warnf(pos scanner.Position, format string, args ...interface{}) {
+	c.errors.Add(scan.ErrorMessage{pos, fmt.Sprintf(format, args...), true})
+}
+
 type bailout struct{}
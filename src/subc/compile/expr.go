@@ -1,6 +1,7 @@
 package compile
 
 import (
+	"fmt"
 	"strconv"
 
 	"subc/ast"
@@ -18,6 +19,26 @@ func (c *compiler) expr(e ast.Expr) {
 	c.emit(n)
 }
 
+// exprTo generates code for an expression whose value is converted to T.
+func (c *compiler) exprTo(e ast.Expr, T types.Type) {
+	var lv arch.LV
+	n := c.exprInternal(e, &lv)
+	n = c.rvalue(n, &lv)
+	c.emit(c.narrow(n, T))
+}
+
+// effect generates code for an expression whose value isn't used,
+// like an expression statement, so its value is not truncated.
+func (c *compiler) effect(e ast.Expr) {
+	var lv arch.LV
+	n := c.exprInternal(e, &lv)
+	n = c.rvalue(n, &lv)
+	if n != nil && n.op == opExt {
+		n = n.left
+	}
+	c.emit(n)
+}
+
 // exprInternal is the main function for generating code by figuring
 // out what kind of expression it is.
 func (c *compiler) exprInternal(e ast.Expr, lv *arch.LV) *node {
@@ -30,22 +51,19 @@ func (c *compiler) exprInternal(e ast.Expr, lv *arch.LV) *node {
 	}
 
 	// for constants we can just get the value right away
-	if tv.Value != nil {
+	if tv.Value != nil && !c.foldsCharCast(e) {
 		lv.Type = tv.Type
 		lv.Value = tv.Value
+		if _, isCast := e.(*ast.CastExpr); isCast && c.conf.Extend && tv.Type == types.Typ[types.Char] {
+			lv.Value = charValue(tv.Value)
+		}
 		switch tv.Type {
 		case types.Typ[types.UntypedString]:
 			str, err := strconv.Unquote(tv.Value.String())
 			if err != nil {
 				c.errorf(pos, "invalid constant %v: %v", tv.Value, err)
 			}
-			c.cg.Data()
-			lab := c.cg.Label()
-			c.cg.Lab(lab)
-			c.cg.Defs(str)
-			c.cg.Defb(0)
-			c.cg.Align(len(str)+1, c.cg.Int())
-			lv.Addr = lab
+			lv.Label = c.stringLit(str)
 			return newNode(opLdlab, lv, nil, nil, nil)
 
 		default:
@@ -58,6 +76,11 @@ func (c *compiler) exprInternal(e ast.Expr, lv *arch.LV) *node {
 		return c.binaryExpr(e, lv)
 
 	case *ast.UnaryExpr:
+		if e.Op.Type == scan.Land {
+			lv.Type = tv.Type
+			lv.Label = c.label(e.X.(*ast.Ident).Name)
+			return newNode(opLdlab, lv, nil, nil, nil)
+		}
 		return c.unaryExpr(e, lv)
 
 	case *ast.SizeofExpr:
@@ -75,6 +98,15 @@ func (c *compiler) exprInternal(e ast.Expr, lv *arch.LV) *node {
 	case *ast.ParenExpr:
 		return c.exprInternal(e.X, lv)
 
+	case *ast.StmtExpr:
+		// the statements are generated with the tree, so they run
+		// where the value is used
+		lv.Type = tv.Type
+		lv.Addressable = false
+		n := newNode(opStmts, lv, nil, nil, nil)
+		n.stmts = e
+		return n
+
 	case *ast.IndexExpr:
 		return c.indexExpr(e, lv)
 
@@ -97,6 +129,33 @@ func (c *compiler) exprInternal(e ast.Expr, lv *arch.LV) *node {
 	return nil
 }
 
+// commentLen is the most bytes of a string quoted in the comment on its
+// data, a longer one is cut short.
+const commentLen = 64
+
+// stringLit emits the data for a string literal and returns its label.
+// When pooling, a string that was already emitted in the translation
+// unit reuses the label of the first one.
+func (c *compiler) stringLit(str string) arch.Label {
+	if lab, found := c.strs[str]; found && c.conf.Pool {
+		return lab
+	}
+
+	c.cg.Data()
+	if len(str) > commentLen {
+		c.cg.Comment("string %q... (%d bytes)", str[:commentLen], len(str))
+	} else {
+		c.cg.Comment("string %q", str)
+	}
+	lab := c.cg.Label()
+	c.cg.Lab(lab)
+	c.cg.Defs(str)
+	c.cg.Defb(0)
+	c.cg.Align(len(str)+1, c.cg.Int())
+	c.strs[str] = lab
+	return lab
+}
+
 // ident generates code for an identifier by loading it into the accumulator.
 func (c *compiler) ident(e *ast.Ident, lv *arch.LV, tv types.TypeAndValue) *node {
 	lv.Ident = true
@@ -135,14 +194,23 @@ func (c *compiler) ident(e *ast.Ident, lv *arch.LV, tv types.TypeAndValue) *node
 	array, isArray := v.Type().(*types.Array)
 
 	lv.Addr = s.Addr
+	lv.Reg = s.Reg
+	lv.Label = s.Label
 	lv.Value = s.Value
 	lv.Storage = v.Storage()
+	if addr, found := c.hoistedSlot(v); found {
+		lv.Addr, lv.Storage = addr, types.Auto
+	}
 	switch {
 	// constants
 	case tv.Value != nil:
 		lv.Value = tv.Value
 		return newNode(opLit, lv, nil, nil, nil)
 
+	case c.propagate(v):
+		lv.Value = v.Value()
+		return newNode(opLit, lv, nil, nil, nil)
+
 	case isArray:
 		lv.Type = types.NewPointer(array.Elem(), nil)
 		return newNode(opAddr, lv, nil, nil, nil)
@@ -183,11 +251,28 @@ func (c *compiler) indirection(e ast.Expr, n *node, lv *arch.LV) *node {
 
 	lv.Ident = false
 	lv.Type = ptr.Elem().Underlying()
-	return n
+	return c.nullCheck(e, n)
+}
+
+// nullCheck checks the pointer that n computes against null before e
+// dereferences it, with Config.NullChecks. The addresses of the objects
+// are never null and the constant ones are the program's own, like the
+// ones of the offsetof idiom, so they are left alone.
+func (c *compiler) nullCheck(e ast.Expr, n *node) *node {
+	if !c.conf.NullChecks || n == nil || n.op == opAddr || n.op == opLit {
+		return n
+	}
+	msg := fmt.Sprintf("%s: null pointer dereference in %s", e.Span().Start, types.ExprString(e))
+	lv := arch.LV{Label: c.stringLit(msg)}
+	return newNode(opNullCheck, &lv, nil, n, nil)
 }
 
 // indexExpr generates code for array accesses (a[x], etc).
 func (c *compiler) indexExpr(e *ast.IndexExpr, lv *arch.LV) *node {
+	if n := c.walkExpr(e, lv); n != nil {
+		return n
+	}
+
 	var lv2 arch.LV
 	n := c.exprInternal(e.X, lv)
 	lv.Type = lv.Type.Underlying()
@@ -196,15 +281,17 @@ func (c *compiler) indexExpr(e *ast.IndexExpr, lv *arch.LV) *node {
 	m := c.exprInternal(e.Index, &lv2)
 	m = c.rvalue(m, &lv2)
 
-	record, isRecord := lv.Type.(*types.Record)
-	if !isRecord && lv.Type != types.Typ[types.Char] {
-		// if it is not a record, we just need to scale
+	_, isRecord := lv.Type.(*types.Record)
+	size := c.cg.Sizeof(lv.Type)
+	if !isRecord && size == c.cg.Word() {
+		// if it is a word, we just need to scale
 		// it by the sizeof of the type
 		m = newNode(opScale, nil, nil, m, nil)
-	} else if isRecord {
-		// if it is a struct, we use sizeof to figure
-		// out the size to multiply by to get to the index
-		lv2.Size = c.cg.Sizeof(record)
+	} else if isRecord || lv.Type != types.Typ[types.Char] {
+		// if it is a struct or an int narrower than a word,
+		// we use sizeof to figure out the size to multiply
+		// by to get to the index
+		lv2.Size = size
 		m = newNode(opScaleBy, &lv2, nil, m, nil)
 	}
 
@@ -224,7 +311,7 @@ func (c *compiler) selectorExpr(e *ast.SelectorExpr, lv *arch.LV) *node {
 
 	n := c.exprInternal(e.X, lv)
 	if sel.Indirect() {
-		n = c.rvalue(n, lv)
+		n = c.nullCheck(e, c.rvalue(n, lv))
 		lv.Ident = false
 	}
 
@@ -251,6 +338,11 @@ func (c *compiler) binaryExpr(e *ast.BinaryExpr, lv *arch.LV) *node {
 	if op == scan.Land || op == scan.Lor {
 		return c.logical(op, e, lv)
 	}
+	if tv, found := c.typAndValue(e.X); found && op == scan.Assign {
+		if _, ok := tv.Type.Underlying().(*types.Record); ok {
+			return c.recordAssign(e, lv)
+		}
+	}
 
 	var lv2 arch.LV
 	n := c.exprInternal(e.X, lv)
@@ -275,11 +367,13 @@ func (c *compiler) binaryExpr(e *ast.BinaryExpr, lv *arch.LV) *node {
 		src := c.rvalue(n, &lvs)
 		m = newNode(aop, lv, &lv2, src, m)
 		n = newNode(opAssign, lv, &lv2, n, m)
+		n = c.narrow(n, lv.Type)
 		lv.Addressable = false
 
 	// assignment operator (=)
 	case op == scan.Assign:
 		n = newNode(opAssign, lv, &lv2, n, m)
+		n = c.narrow(n, lv.Type)
 		lv.Addressable = false
 
 	// comma operator (,)
@@ -294,6 +388,18 @@ func (c *compiler) binaryExpr(e *ast.BinaryExpr, lv *arch.LV) *node {
 	return n
 }
 
+// recordAssign generates code for assigning a struct or a union, which
+// copies it. The value of the assignment is the address of the copy,
+// like the value of a struct or union variable is its address.
+func (c *compiler) recordAssign(e *ast.BinaryExpr, lv *arch.LV) *node {
+	var lv2 arch.LV
+	n := c.exprInternal(e.X, lv)
+	m := c.exprInternal(e.Y, &lv2)
+	lv.Ident = false
+	lv.Addressable = false
+	return newNode(opCopy, lv, &lv2, n, m)
+}
+
 // logical generates code for || and && operators.
 func (c *compiler) logical(op scan.Type, e *ast.BinaryExpr, lv *arch.LV) *node {
 	l := []*ast.BinaryExpr{e}
@@ -310,8 +416,8 @@ func (c *compiler) logical(op scan.Type, e *ast.BinaryExpr, lv *arch.LV) *node {
 	n := c.exprInternal(l[len(l)-1].X, lv)
 	for i := len(l) - 1; i >= 0; i-- {
 		var lv2 arch.LV
-		if lx.Addr == 0 {
-			lx.Addr = c.cg.Label()
+		if lx.Label == 0 {
+			lx.Label = c.cg.Label()
 		}
 
 		n = c.rvalue(n, lv)
@@ -344,7 +450,7 @@ func (c *compiler) condExpr(e *ast.CondExpr, lv *arch.LV) *node {
 	}
 
 	var lx arch.LV
-	var l2 int
+	var l2 arch.Label
 	var typ types.Type
 	n := c.exprInternal(l[len(l)-1].Cond, lv)
 	for i := len(l) - 1; i >= 0; i-- {
@@ -358,7 +464,7 @@ func (c *compiler) condExpr(e *ast.CondExpr, lv *arch.LV) *node {
 
 		n2 := c.exprInternal(l[i].X, &lv2)
 		n2 = c.rvalue(n2, &lv2)
-		lx.Addr = l1
+		lx.Label = l1
 		n = newNode(opBrFalse, &lx, nil, n, n2)
 		if typ == nil {
 			typ = lv2.Type
@@ -369,7 +475,7 @@ func (c *compiler) condExpr(e *ast.CondExpr, lv *arch.LV) *node {
 		n = newNode(opGlue, nil, nil, n, n2)
 	}
 
-	lx.Addr = l2
+	lx.Label = l2
 	n = newNode(opIfElse, &lx, nil, n, nil)
 	lv.Type = typ
 	lv.Addressable = false
@@ -511,6 +617,9 @@ func (c *compiler) starExpr(e *ast.StarExpr, lv *arch.LV) *node {
 
 // call expression generates code for calling functions (f(x), fact(1), etc).
 func (c *compiler) callExpr(e *ast.CallExpr, lv *arch.LV) *node {
+	if op, ok := types.Builtin(e.Fun); ok {
+		return c.builtin(e, op, lv)
+	}
 	c.exprInternal(e.Fun, lv)
 	n := c.fnArgs(e.Args)
 	if sig, ok := lv.Type.(*types.Signature); ok {
@@ -528,6 +637,31 @@ func (c *compiler) callExpr(e *ast.CallExpr, lv *arch.LV) *node {
 	return n
 }
 
+// builtins are the nodes of the overflow checking builtins, by the
+// operator of their arithmetic.
+var builtins = map[scan.Type]opcode{
+	scan.Plus:  opAddOvf,
+	scan.Minus: opSubOvf,
+	scan.Mul:   opMulOvf,
+}
+
+// builtin generates code for a call of an overflow checking builtin, which
+// the target does in place with the pointer to the result on the left and
+// the operands glued on the right.
+func (c *compiler) builtin(e *ast.CallExpr, op scan.Type, lv *arch.LV) *node {
+	*lv = arch.LV{Type: types.Typ[types.Int]}
+	if !c.cg.Overflows() {
+		c.errorf(e.Span().Start, "%s is not supported on this target", types.ExprString(e.Fun))
+		lv.Value = constant.MakeInt64(0)
+		return newNode(opLit, lv, nil, nil, nil)
+	}
+	var x, y, p arch.LV
+	a := c.rvalue(c.exprInternal(e.Args[0], &x), &x)
+	b := c.rvalue(c.exprInternal(e.Args[1], &y), &y)
+	ptr := c.rvalue(c.exprInternal(e.Args[2], &p), &p)
+	return newNode(builtins[op], lv, nil, ptr, newNode(opGlue, nil, nil, a, b))
+}
+
 // fnArgs generates code for passing function arguments.
 func (c *compiler) fnArgs(args []ast.Expr) *node {
 	var n *node
@@ -542,10 +676,89 @@ func (c *compiler) fnArgs(args []ast.Expr) *node {
 // castExpr generates code casting ((void**) f, (int) a, etc).
 func (c *compiler) castExpr(e *ast.CastExpr, lv *arch.LV, tv types.TypeAndValue) *node {
 	n := c.exprInternal(e.X, lv)
+	if lv.Addressable && c.cg.BigEndian() && tv.Type == types.Typ[types.Char] && c.cg.Width(lv.Type) != c.cg.Width(tv.Type) {
+		// the low byte of a wider object is its last one on a
+		// big-endian target, so the object is loaded and truncated
+		n = c.rvalue(n, lv)
+		ext := arch.LV{Type: tv.Type, Size: int(c.cg.Width(tv.Type))}
+		n = newNode(opExt, &ext, nil, n, nil)
+	}
+	if !lv.Addressable {
+		// an object cast is loaded with the width of the cast instead
+		n = c.narrow(n, tv.Type)
+	}
 	lv.Type = tv.Type
 	return n
 }
 
+// narrow converts the value of n to type T. The value is truncated
+// when it is stored in a char, but a value that is used right away
+// is as wide as it was computed, so the truncation is made explicit
+// with an extension unless the value is known to fit. The width
+// extended from is kept in the size of the node.
+func (c *compiler) narrow(n *node, T types.Type) *node {
+	if !c.conf.Extend || n == nil || T != types.Typ[types.Char] || fitsChar(n) {
+		return n
+	}
+	if n.op == opLit {
+		lv := n.lv[0]
+		lv.Value = charValue(lv.Value)
+		lv.Type = T
+		return newNode(opLit, &lv, nil, nil, nil)
+	}
+	lv := arch.LV{Type: T, Size: int(c.cg.Width(T))}
+	return newNode(opExt, &lv, nil, n, nil)
+}
+
+// fitsChar reports whether the value of n is known to fit in a char,
+// the type checker gives arithmetic on chars the type char so the type
+// of n doesn't tell.
+func fitsChar(n *node) bool {
+	switch n.op {
+	case opRval:
+		// loaded with the width of its type
+		return n.lv[0].Type == types.Typ[types.Char]
+	case opExt:
+		return true
+	case opLit:
+		x, err := strconv.Atoi(n.lv[0].Value.String())
+		return err != nil || (0 <= x && x <= 255)
+	case opAssign:
+		return fitsChar(n.right)
+	}
+	return false
+}
+
+// foldsCharCast reports whether e is a constant that the type checker
+// folded through a cast to char inside it, which it doesn't truncate.
+// It is computed from its operands instead, so the cast is truncated.
+func (c *compiler) foldsCharCast(e ast.Expr) bool {
+	if !c.conf.Extend {
+		return false
+	}
+	found := false
+	ast.Inspect(e, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.SizeofExpr:
+			return false
+		case *ast.CastExpr:
+			if n != e && c.Types[n].Type == types.Typ[types.Char] {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// charValue returns the integer constant v truncated to a char.
+func charValue(v constant.Value) constant.Value {
+	if x, err := strconv.Atoi(v.String()); err == nil && (x < 0 || x > 255) {
+		return constant.MakeInt64(int64(x & 0xff))
+	}
+	return v
+}
+
 // typAndValue returns a type and value from the type checker for an expression.
 func (c *compiler) typAndValue(e ast.Expr) (types.TypeAndValue, bool) {
 	tv, found := c.Types[e]
@@ -558,6 +771,11 @@ func (c *compiler) typAndValue(e ast.Expr) (types.TypeAndValue, bool) {
 // symbol returns a symbol from an object. A symbol is an object
 // but with more compile time info such as the stack addresses of it.
 func (c *compiler) symbol(obj types.Object) (*arch.LV, bool) {
+	if c.fn != nil {
+		if s, found := c.fn.sym[obj]; found {
+			return s, true
+		}
+	}
 	s, found := c.sym[obj]
 	if !found {
 		c.errorf(obj.Pos(), "no object information found")
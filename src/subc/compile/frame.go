@@ -0,0 +1,60 @@
+package compile
+
+import (
+	"fmt"
+	"sort"
+
+	"subc/ast"
+	"subc/callgraph"
+)
+
+// frameSlot is a slot in the frame of a function, as the frame report
+// shows it. The offsets are from the frame pointer.
+type frameSlot struct {
+	addr int
+	size int
+	name string
+	what string
+}
+
+// slot records a slot of the frame, for the report.
+func (fn *function) slot(addr, size int, name, what string) {
+	fn.frame = append(fn.frame, frameSlot{addr, size, name, what})
+}
+
+// frame writes the layout of the frame of function d to the Frames of
+// the config and warns when the frame takes more than its MaxFrame.
+// Below the locals and the slots of the compiler is the spill area of
+// the temporaries and the call arguments, which is only estimated.
+func (c *compiler) frame(d *ast.FuncDecl) {
+	if c.conf.Frames == nil && c.conf.MaxFrame == 0 {
+		return
+	}
+
+	word := c.cg.Word()
+	spills := int(callgraph.Temps(d.Body, int64(word)))
+	size := 2*word - c.fn.lsize + spills
+	if c.conf.MaxFrame > 0 && size > c.conf.MaxFrame {
+		c.warnf(d.Name.Pos, "frame of %s takes %d bytes, more than %d", c.fn.name, size, c.conf.MaxFrame)
+	}
+	if c.conf.Frames == nil {
+		return
+	}
+
+	slots := append(c.fn.frame,
+		frameSlot{word, word, "", "return address"},
+		frameSlot{0, word, "", "saved frame pointer"})
+	sort.SliceStable(slots, func(i, j int) bool { return slots[i].addr > slots[j].addr })
+	if spills > 0 {
+		slots = append(slots, frameSlot{c.fn.lsize - spills, spills, "", "spill area, at most"})
+	}
+
+	width := 0
+	for _, s := range slots {
+		width = max(width, len(s.name))
+	}
+	fmt.Fprintf(c.conf.Frames, "%s: frame of %d bytes\n", c.fn.name, size)
+	for _, s := range slots {
+		fmt.Fprintf(c.conf.Frames, "\t%+6d %6d  %-*s  %s\n", s.addr, s.size, width, s.name, s.what)
+	}
+}
@@ -0,0 +1,238 @@
+package compile
+
+import (
+	"subc/ast"
+	"subc/compile/arch"
+	"subc/constant"
+	"subc/scan"
+	"subc/types"
+)
+
+// The loops that only copy or fill an array, like
+//
+//	for (i = 0; i < n; i++)
+//		a[i] = b[i];
+//
+//	for (i = 0; i < n; i++)
+//		a[i] = 0;
+//
+// are replaced by a call to memcpy or memset of the runtime for the
+// elements from i to n, and i is set to n after it. The counter has to be
+// a local int that only the post statement steps by 1, and the bound a
+// constant or a local int that the loop can't change. The runtime copies
+// forward a byte at a time, so a copy between arrays that overlap gives
+// what the loop gives. A fill is a memset of its byte for char arrays and
+// of zero for the others. With Config.Blocks the targets that have string
+// instructions copy and fill in line instead of calling the runtime.
+
+// idiom is a loop that copies or fills the elements of an array.
+type idiom struct {
+	counter *ast.Ident     // the counter in the condition of the loop
+	bound   ast.Expr       // the bound the counter is compared with
+	dst     *ast.IndexExpr // the element stored to
+	src     ast.Expr       // the element copied, or the value filled with
+	copy    bool           // whether it copies src instead of filling with it
+	size    int            // size of an element of the array
+}
+
+// planIdioms finds the loops of a function that copy or fill arrays, the
+// other optimizations of loops leave them alone.
+func (c *compiler) planIdioms(d *ast.FuncDecl) {
+	if !c.idioms {
+		return
+	}
+
+	ast.Inspect(d.Body, func(n ast.Node) bool {
+		if s, ok := n.(*ast.ForStmt); ok {
+			if x := c.idiomLoop(s); x != nil {
+				c.fn.idioms[s] = x
+			}
+		}
+		return true
+	})
+}
+
+// idiomLoop returns the idiom of a for loop, or nil if it is not
+// a copy or a fill.
+func (c *compiler) idiomLoop(s *ast.ForStmt) *idiom {
+	counter, step := c.counter(s.Post)
+	if counter == nil || step != 1 {
+		return nil
+	}
+	written, calls, _ := c.loopWrites(s.Cond, s.Body)
+	if written[counter.Name()] {
+		return nil
+	}
+
+	cond, ok := s.Cond.(*ast.BinaryExpr)
+	if !ok || cond.Op.Type != scan.Lt {
+		return nil
+	}
+	id := identOf(cond.X)
+	if id == nil || c.Uses[id] != counter || !c.invariantBound(cond.Y, counter, written) {
+		return nil
+	}
+
+	body := s.Body
+	if b, ok := body.(*ast.BlockStmt); ok && len(b.Stmt) == 1 {
+		body = b.Stmt[0]
+	}
+	x, ok := body.(*ast.ExprStmt)
+	if !ok {
+		return nil
+	}
+	assign, ok := x.X.(*ast.BinaryExpr)
+	if !ok || assign.Op.Type != scan.Assign {
+		return nil
+	}
+	dst, ok := assign.X.(*ast.IndexExpr)
+	if !ok {
+		return nil
+	}
+	elem, size, ok := c.idiomArray(dst, counter, written, calls)
+	if !ok {
+		return nil
+	}
+
+	loop := &idiom{counter: id, bound: cond.Y, dst: dst, src: assign.Y, size: size}
+	if src, ok := assign.Y.(*ast.IndexExpr); ok {
+		t, _, ok := c.idiomArray(src, counter, written, calls)
+		if !ok || !types.Identical(t, elem) {
+			return nil
+		}
+		loop.copy = true
+		return loop
+	}
+
+	tv, found := c.Types[assign.Y]
+	if !found || tv.Value == nil || tv.Value.Type() != constant.Int {
+		return nil
+	}
+	if size != 1 && !constant.Compare(tv.Value, scan.Eq, constant.MakeInt64(0)) {
+		return nil
+	}
+	return loop
+}
+
+// invariantBound reports whether the bound of a loop is a constant or a
+// local int other than the counter that the loop can't change.
+func (c *compiler) invariantBound(bound ast.Expr, counter *types.Var, written map[string]bool) bool {
+	if tv, found := c.Types[bound]; found && tv.Value != nil {
+		return true
+	}
+	id := identOf(bound)
+	if id == nil {
+		return false
+	}
+	v, ok := c.Uses[id].(*types.Var)
+	return ok && v != counter && v.Storage() == types.Auto && v.Type() == types.Typ[types.Int] &&
+		!written[v.Name()] && !c.addr[v]
+}
+
+// idiomArray returns the element type and its size of an array that e
+// indexes by the counter of a loop, if the loop can't change where the
+// array is.
+func (c *compiler) idiomArray(e *ast.IndexExpr, counter *types.Var, written map[string]bool, calls bool) (types.Type, int, bool) {
+	if id := identOf(e.Index); id == nil || c.Uses[id] != counter {
+		return nil, 0, false
+	}
+	id := identOf(e.X)
+	if id == nil {
+		return nil, 0, false
+	}
+	v, ok := c.Uses[id].(*types.Var)
+	if !ok || (isGlobal(v) && c.volatile[v.Name()]) {
+		return nil, 0, false
+	}
+	// the only store of the loop is the one of the elements that the
+	// idiom replaces, which leaves the pointers it is given alone
+	size, ok := c.walkSize(v, written, calls, false)
+	if !ok {
+		return nil, 0, false
+	}
+	tv, found := c.typAndValue(e)
+	if !found {
+		return nil, 0, false
+	}
+	return tv.Type, size, true
+}
+
+// definesMem reports whether a program declares memcpy or memset as
+// anything but the external functions of the runtime.
+func definesMem(prog *ast.Prog) bool {
+	for _, d := range prog.Decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			switch d.Name.Name {
+			case "memcpy", "memset":
+				if d.Body != nil || (d.Storage != nil && d.Storage.Type == scan.Static) {
+					return true
+				}
+			}
+		case *ast.VarDecl:
+			switch d.Name.Name {
+			case "memcpy", "memset":
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// idiomStmt generates code for a loop that copies or fills an array,
+// it returns false if s is not one.
+func (c *compiler) idiomStmt(s *ast.ForStmt) bool {
+	x := c.fn.idioms[s]
+	if x == nil {
+		return false
+	}
+
+	if s.Init != nil {
+		c.effect(s.Init)
+		c.cg.Clear(true)
+	}
+	done := c.cg.Label()
+	c.expr(s.Cond)
+	c.cg.BrFalse(done)
+	c.cg.Clear(true)
+
+	var dlv, slv, clv, blv arch.LV
+	dst := c.exprInternal(x.dst, &dlv)
+	src := c.exprInternal(x.src, &slv)
+	if !x.copy {
+		src = c.rvalue(src, &slv)
+	}
+	i := c.rvalue(c.exprInternal(x.counter, &clv), &clv)
+	bound := c.rvalue(c.exprInternal(x.bound, &blv), &blv)
+	sub := arch.LV{Type: types.Typ[types.Int], Btype: types.Typ[types.Int]}
+	count := newNode(opSub, &sub, &clv, bound, i)
+	if x.size != 1 {
+		size := arch.LV{Type: types.Typ[types.Int], Value: constant.MakeInt64(int64(x.size))}
+		count = newNode(opMul, &sub, &size, count, newNode(opLit, &size, nil, nil, nil))
+	}
+
+	if c.conf.Blocks && c.cg.Blocks() {
+		op := opFillBlock
+		if x.copy {
+			op = opCopyBlock
+		}
+		c.emit(newNode(op, nil, nil, dst, newNode(opGlue, nil, nil, src, count)))
+	} else {
+		fn := arch.LV{Name: "memset", Size: 3, Type: voidPtr, Storage: types.Extern}
+		if x.copy {
+			fn.Name = "memcpy"
+		}
+		args := newNode(opGlue, nil, nil, newNode(opGlue, nil, nil, newNode(opGlue, nil, nil, nil, dst), src), count)
+		c.emit(newNode(opCall, &fn, nil, args, nil))
+	}
+	c.cg.Clear(true)
+
+	// the counter ends at the bound, as the loop leaves it
+	var lv arch.LV
+	n := c.exprInternal(x.counter, &lv)
+	m := c.rvalue(c.exprInternal(x.bound, &blv), &blv)
+	c.emit(newNode(opAssign, &lv, &blv, n, m))
+	c.cg.Clear(true)
+	c.cg.Lab(done)
+	return true
+}
@@ -0,0 +1,289 @@
+package compile
+
+import (
+	"strconv"
+
+	"subc/ast"
+	"subc/compile/arch"
+	"subc/constant"
+	"subc/scan"
+	"subc/types"
+)
+
+// The array walks of counted loops are strength reduced, so a[i] in
+//
+//	for (i = 0; i < n; i++)
+//		s += a[i];
+//
+// reads through a pointer kept in a stack slot that the loop moves
+// along with i, instead of scaling i and adding it to a every time.
+// The loop counter has to be a local int that only the post statement
+// of the loop changes by a constant, and the base of the indexing an
+// array or a pointer that the loop can't change.
+
+// walk is a pointer into an array that a loop moves along with its
+// counter, a[i] in the loop reads through it.
+type walk struct {
+	index *ast.IndexExpr // the first a[i] in the loop, to compute the start of the walk
+	addr  int            // stack slot of the pointer
+	size  int            // size of an element of the array
+}
+
+// inductionLoop is a loop whose array walks are strength reduced.
+type inductionLoop struct {
+	step  int                      // constant that the counter changes by
+	walks []*walk                  // the arrays walked, one for each base
+	exprs map[*ast.IndexExpr]*walk // the indexing that reads through a walk
+}
+
+// planInduction finds the array walks of the counted loops of a function
+// and gives their pointers a stack slot below the locals at addr, returning
+// the new size of the locals. Functions with labels are left alone, as
+// a goto into the loop would skip starting the walks. With null checks
+// the walks are left out too, they would check the pointers before the
+// loop even when it doesn't run.
+func (c *compiler) planInduction(d *ast.FuncDecl, addr int) int {
+	if !c.conf.Reduce || c.conf.NullChecks || len(d.Labels) > 0 {
+		return addr
+	}
+
+	ast.Inspect(d.Body, func(n ast.Node) bool {
+		s, ok := n.(*ast.ForStmt)
+		if !ok || c.fn.idioms[s] != nil {
+			return true
+		}
+		loop := c.inductionLoop(s)
+		if loop == nil {
+			return true
+		}
+		for _, w := range loop.walks {
+			addr -= c.cg.Word()
+			w.addr = addr
+			c.fn.slot(addr, c.cg.Word(), types.ExprString(w.index), "array walk")
+		}
+		c.fn.inductions[s] = loop
+		return true
+	})
+	return addr
+}
+
+// inductionLoop finds the array walks of a for loop, or returns nil
+// if it has none.
+func (c *compiler) inductionLoop(s *ast.ForStmt) *inductionLoop {
+	counter, step := c.counter(s.Post)
+	if counter == nil {
+		return nil
+	}
+	written, calls, indirect := c.loopWrites(s.Cond, s.Body)
+	if written[counter.Name()] {
+		return nil
+	}
+
+	loop := &inductionLoop{step: step, exprs: make(map[*ast.IndexExpr]*walk)}
+	bases := make(map[string]*walk)
+	for _, p := range []ast.Node{s.Cond, s.Body} {
+		if p == nil {
+			continue
+		}
+		ast.Inspect(p, func(n ast.Node) bool {
+			e, ok := n.(*ast.IndexExpr)
+			if !ok {
+				return true
+			}
+			if id := identOf(e.Index); id == nil || c.Uses[id] != counter {
+				return true
+			}
+			id := identOf(e.X)
+			if id == nil {
+				return true
+			}
+			v, ok := c.Uses[id].(*types.Var)
+			if !ok {
+				return true
+			}
+
+			w := bases[v.Name()]
+			if w == nil {
+				size, ok := c.walkSize(v, written, calls, indirect)
+				if !ok {
+					return true
+				}
+				w = &walk{index: e, size: size}
+				bases[v.Name()] = w
+				loop.walks = append(loop.walks, w)
+			}
+			loop.exprs[e] = w
+			return true
+		})
+	}
+	if len(loop.walks) == 0 {
+		return nil
+	}
+	return loop
+}
+
+// counter returns the loop counter that the post statement
+// of a loop changes and the constant it changes it by.
+func (c *compiler) counter(post ast.Expr) (*types.Var, int) {
+	var (
+		id   *ast.Ident
+		step int
+	)
+	switch e := post.(type) {
+	case *ast.UnaryExpr:
+		id, step = identOf(e.X), 1
+		switch e.Op.Type {
+		case scan.Inc:
+		case scan.Dec:
+			step = -1
+		default:
+			return nil, 0
+		}
+
+	case *ast.BinaryExpr:
+		tv, found := c.Types[e.Y]
+		if !found || tv.Value == nil {
+			return nil, 0
+		}
+		n, err := strconv.Atoi(tv.Value.String())
+		if err != nil {
+			return nil, 0
+		}
+		id = identOf(e.X)
+		switch e.Op.Type {
+		case scan.PlusEq:
+			step = n
+		case scan.MinusEq:
+			step = -n
+		default:
+			return nil, 0
+		}
+
+	default:
+		return nil, 0
+	}
+
+	if id == nil {
+		return nil, 0
+	}
+	v, ok := c.Uses[id].(*types.Var)
+	if !ok || v.Storage() != types.Auto || v.Type() != types.Typ[types.Int] || c.addr[v] {
+		return nil, 0
+	}
+	return v, step
+}
+
+// walkSize returns the size of the elements of an array walked
+// through base v, if the loop can't change where v points to. A
+// global pointer that isn't static can have its address taken in
+// another file, so a loop that stores through a pointer, indirect,
+// can change it.
+func (c *compiler) walkSize(v *types.Var, written map[string]bool, calls, indirect bool) (int, bool) {
+	var elem types.Type
+	switch t := v.Type().(type) {
+	case *types.Array:
+		elem = t.Elem()
+	case *types.Pointer:
+		if written[v.Name()] || c.addr[v] {
+			return 0, false
+		}
+		if isGlobal(v) && (calls || c.volatile[v.Name()] || indirect && v.Storage() != types.GlobalStatic) {
+			return 0, false
+		}
+		elem = t.Elem()
+	default:
+		return 0, false
+	}
+
+	switch t := elem.Underlying().(type) {
+	case *types.Record:
+		return int(c.cg.Sizeof(t)), true
+	case *types.Array:
+		return 0, false
+	}
+	switch elem {
+	case types.Typ[types.Void]:
+		return 0, false
+	case types.Typ[types.Char]:
+		return 1, true
+	}
+	return int(c.cg.Sizeof(elem)), true
+}
+
+// startWalks points the walks of loop s at the element its counter
+// indexes once the loop is initialized, and makes its indexing read
+// through them.
+func (c *compiler) startWalks(s *ast.ForStmt) {
+	loop := c.fn.inductions[s]
+	if loop == nil {
+		return
+	}
+
+	for _, w := range loop.walks {
+		var lv arch.LV
+		n := c.exprInternal(w.index, &lv)
+		c.emit(n)
+		c.cg.Commit()
+		c.cg.Store(c.walkLV(w))
+		c.cg.Clear(true)
+	}
+	for e, w := range loop.exprs {
+		c.fn.walks[e] = w
+	}
+}
+
+// stepWalks moves the walks of loop s along with its counter.
+func (c *compiler) stepWalks(s *ast.ForStmt) {
+	loop := c.fn.inductions[s]
+	if loop == nil {
+		return
+	}
+
+	for _, w := range loop.walks {
+		lv := c.walkLV(w)
+		var step arch.LV
+		step.Value = constant.MakeInt64(int64(loop.step * w.size))
+		n := newNode(opIdent, &lv, nil, nil, nil)
+		m := newNode(opAdd, &lv, nil, newNode(opRval, &lv, nil, n, nil), newNode(opLit, &step, nil, nil, nil))
+		c.emit(newNode(opAssign, &lv, nil, n, m))
+		c.cg.Clear(true)
+	}
+}
+
+// endWalks makes the indexing of loop s be computed again.
+func (c *compiler) endWalks(s *ast.ForStmt) {
+	if loop := c.fn.inductions[s]; loop != nil {
+		for e := range loop.exprs {
+			delete(c.fn.walks, e)
+		}
+	}
+}
+
+// walkLV returns the lvalue of the pointer of a walk, it is a void
+// pointer as its steps are already scaled.
+func (c *compiler) walkLV(w *walk) arch.LV {
+	return arch.LV{Ident: true, Type: voidPtr, Storage: types.Auto, Addr: w.addr}
+}
+
+var voidPtr = types.NewPointer(types.Typ[types.Void], nil)
+
+// walkExpr generates code for the address of a[i] when it reads through
+// a walk, it returns nil if it doesn't.
+func (c *compiler) walkExpr(e *ast.IndexExpr, lv *arch.LV) *node {
+	if c.fn == nil {
+		return nil
+	}
+	w := c.fn.walks[e]
+	if w == nil {
+		return nil
+	}
+	tv, found := c.typAndValue(e)
+	if !found {
+		return nil
+	}
+
+	p := c.walkLV(w)
+	n := newNode(opRval, &p, nil, newNode(opIdent, &p, nil, nil, nil), nil)
+	*lv = arch.LV{Type: tv.Type.Underlying(), Addressable: true}
+	return n
+}
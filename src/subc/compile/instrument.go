@@ -0,0 +1,93 @@
+package compile
+
+import (
+	"subc/ast"
+	"subc/compile/arch"
+	"subc/types"
+)
+
+// With Config.Instrument the functions call the hooks
+//
+//	void __cyg_profile_func_enter(void *fn, void *site);
+//	void __cyg_profile_func_exit(void *fn, void *site);
+//
+// once they are entered and before they return, with their address and
+// the address they return to, like the -finstrument-functions of other
+// compilers. The hooks are the program's own, the functions declared
+// no_instrument_function and the hooks themselves don't call them. The
+// return address is the word above the saved frame pointer on all the
+// targets, and the result is kept in a slot of the frame while the exit
+// hook runs.
+
+const (
+	enterHook = "__cyg_profile_func_enter"
+	exitHook  = "__cyg_profile_func_exit"
+)
+
+// noInstrument finds the functions that any of their declarations
+// declares no_instrument_function.
+func noInstrument(prog *ast.Prog) map[string]bool {
+	names := make(map[string]bool)
+	for _, d := range prog.Decls {
+		d, ok := d.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		for _, a := range d.Attrs {
+			if a.Name.Name == "no_instrument_function" {
+				names[d.Name.Name] = true
+			}
+		}
+	}
+	return names
+}
+
+// planInstrument gives the result of function d a slot below the locals
+// at addr if d calls the hooks, returning the new size of the locals.
+func (c *compiler) planInstrument(d *ast.FuncDecl, addr int) int {
+	switch name := d.Name.Name; {
+	case !c.conf.Instrument, c.untraced[name], name == enterHook, name == exitHook:
+		return addr
+	}
+	addr -= c.cg.Word()
+	c.fn.hookSlot = addr
+	c.fn.slot(addr, c.cg.Word(), "result", "kept for the exit hook")
+	return addr
+}
+
+// enterFunc calls the entry hook of the function if it has one.
+func (c *compiler) enterFunc() {
+	if c.fn.hookSlot == 0 {
+		return
+	}
+	c.cg.Clear(true)
+	c.callHook(enterHook)
+}
+
+// exitFunc calls the exit hook of the function if it has one, the
+// result is in the accumulator before and after it.
+func (c *compiler) exitFunc() {
+	if c.fn.hookSlot == 0 {
+		return
+	}
+	result := arch.LV{Ident: true, Type: voidPtr, Storage: types.Auto, Addr: c.fn.hookSlot}
+	c.cg.Commit()
+	c.cg.Store(result)
+	c.cg.Clear(true)
+	c.callHook(exitHook)
+	c.cg.Rval(result)
+	c.cg.Commit()
+	c.cg.Clear(true)
+}
+
+// callHook calls hook with the address of the function and its return
+// address.
+func (c *compiler) callHook(hook string) {
+	fn := arch.LV{Ident: true, Name: c.fn.name, Type: voidPtr, Storage: types.Extern}
+	site := arch.LV{Ident: true, Type: voidPtr, Storage: types.Auto, Addr: c.cg.Word()}
+	args := newNode(opGlue, nil, nil, newNode(opGlue, nil, nil, nil, newNode(opAddr, &fn, nil, nil, nil)),
+		newNode(opRval, &site, nil, newNode(opIdent, &site, nil, nil, nil), nil))
+	call := arch.LV{Name: hook, Size: 2, Type: types.Typ[types.Void], Storage: types.Extern}
+	c.emit(newNode(opCall, &call, nil, args, nil))
+	c.cg.Clear(true)
+}
@@ -0,0 +1,227 @@
+package compile
+
+import (
+	"subc/ast"
+	"subc/compile/arch"
+	"subc/scan"
+	"subc/types"
+)
+
+// The loop invariant code motion is conservative, it only hoists the
+// loads of global integers and pointers out of loops that can't change
+// them. A loop can't change a global when it never assigns it, makes
+// no calls, and no store through a pointer can reach it. That is when
+// the loop stores through no pointer, or the global is static and
+// never has its address taken, as the other files can take the address
+// of the globals they see and pass it around. The hoisted global is
+// loaded into a stack slot before the loop is entered and the loop
+// reads the slot instead, which saves a load of the global address for
+// each use on targets that need one, like the array walks of naive
+// index code.
+
+// planHoists finds the globals to hoist out of each loop of a function
+// and gives them a stack slot below the locals at addr, returning the
+// new size of the locals. Functions with labels are left alone, as a
+// goto into the loop would skip loading the slots.
+func (c *compiler) planHoists(d *ast.FuncDecl, addr int) int {
+	if !c.conf.Hoist || len(d.Labels) > 0 {
+		return addr
+	}
+
+	ast.Inspect(d.Body, func(n ast.Node) bool {
+		var vars []*types.Var
+		switch n := n.(type) {
+		case *ast.ForStmt:
+			if c.fn.idioms[n] != nil {
+				return true
+			}
+			vars = c.loopInvariants(n.Cond, n.Post, n.Body)
+		case *ast.WhileStmt:
+			vars = c.loopInvariants(n.Cond, n.Body)
+		case *ast.DoStmt:
+			vars = c.loopInvariants(n.Body, n.Cond)
+		default:
+			return true
+		}
+
+		for _, v := range vars {
+			if _, found := c.fn.slots[v.Name()]; !found {
+				addr -= c.cg.Word()
+				c.fn.slots[v.Name()] = addr
+				c.fn.slot(addr, c.cg.Word(), v.Name(), "hoisted global")
+			}
+		}
+		if len(vars) > 0 {
+			c.fn.hoists[n.(ast.Stmt)] = vars
+		}
+		return true
+	})
+	return addr
+}
+
+// loopInvariants returns the globals that the parts of a loop read
+// and can't change.
+func (c *compiler) loopInvariants(parts ...ast.Node) []*types.Var {
+	written, calls, indirect := c.loopWrites(parts...)
+	if calls {
+		return nil
+	}
+
+	var vars []*types.Var
+	seen := make(map[string]bool)
+	for _, p := range parts {
+		if p == nil {
+			continue
+		}
+		ast.Inspect(p, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok {
+				v := c.global(id)
+				if v != nil && !seen[v.Name()] && !written[v.Name()] && c.hoistable(v, indirect) {
+					seen[v.Name()] = true
+					vars = append(vars, v)
+				}
+			}
+			return true
+		})
+	}
+	return vars
+}
+
+// loopWrites returns the names of the variables that the parts of a loop
+// assign, whether the loop makes any calls and whether it stores through
+// a pointer. The names are unique in a function since all the locals are
+// declared at the top of it.
+func (c *compiler) loopWrites(parts ...ast.Node) (written map[string]bool, calls, indirect bool) {
+	written = make(map[string]bool)
+	for _, p := range parts {
+		if p == nil {
+			continue
+		}
+		ast.Inspect(p, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.CallExpr:
+				calls = true
+			case *ast.BinaryExpr:
+				if n.Op.Type == scan.Assign || arithOp(n.Op.Type) != 0 {
+					if id := identOf(n.X); id != nil {
+						written[id.Name] = true
+					} else {
+						indirect = true
+					}
+				}
+			case *ast.UnaryExpr:
+				if n.Op.Type == scan.Inc || n.Op.Type == scan.Dec {
+					if id := identOf(n.X); id != nil {
+						written[id.Name] = true
+					} else {
+						indirect = true
+					}
+				}
+			}
+			return true
+		})
+	}
+	return
+}
+
+// identOf returns the identifier e is, ignoring parentheses.
+func identOf(e ast.Expr) *ast.Ident {
+	for {
+		p, ok := e.(*ast.ParenExpr)
+		if !ok {
+			break
+		}
+		e = p.X
+	}
+	id, _ := e.(*ast.Ident)
+	return id
+}
+
+// global returns the global variable named by e, if e is a name.
+func (c *compiler) global(e ast.Expr) *types.Var {
+	id := identOf(e)
+	if id == nil {
+		return nil
+	}
+	v, ok := c.Uses[id].(*types.Var)
+	if !ok || !isGlobal(v) {
+		return nil
+	}
+	return v
+}
+
+// isGlobal reports whether v is a variable at the file scope.
+func isGlobal(v *types.Var) bool {
+	switch v.Storage() {
+	case types.Public, types.GlobalStatic, types.Extern:
+		return true
+	}
+	return false
+}
+
+// hoistable reports whether the loads of a global can be hoisted
+// when a loop doesn't assign it, indirect is whether the loop stores
+// through a pointer. The address of a global that isn't static can be
+// taken in another file, so a store through a pointer can change it.
+func (c *compiler) hoistable(v *types.Var, indirect bool) bool {
+	if c.addr[v] || c.volatile[v.Name()] || c.propagate(v) {
+		return false
+	}
+	if indirect && v.Storage() != types.GlobalStatic {
+		return false
+	}
+	_, isPointer := v.Type().(*types.Pointer)
+	return isPointer || v.Type() == types.Typ[types.Int]
+}
+
+// hoist loads the globals hoisted out of loop s into their slots,
+// it returns the globals loaded so endHoist can drop them after the
+// loop. The globals that an enclosing loop hoisted are in their
+// slots already.
+func (c *compiler) hoist(s ast.Stmt) []string {
+	var names []string
+	for _, v := range c.fn.hoists[s] {
+		name := v.Name()
+		if _, found := c.fn.hoisted[name]; found {
+			continue
+		}
+
+		addr := c.fn.slots[name]
+		c.cg.Rval(arch.LV{Ident: true, Type: v.Type(), Name: name, Storage: v.Storage()})
+		c.cg.Commit()
+		c.cg.Store(arch.LV{Ident: true, Type: v.Type(), Storage: types.Auto, Addr: addr})
+		c.cg.Clear(true)
+		c.fn.hoisted[name] = addr
+		names = append(names, name)
+	}
+	return names
+}
+
+// endHoist makes the globals loaded by hoist be read from memory again.
+func (c *compiler) endHoist(names []string) {
+	for _, name := range names {
+		delete(c.fn.hoisted, name)
+	}
+}
+
+// hoistedSlot returns the stack slot v is read from in the loop being
+// compiled, if it was hoisted out of it.
+func (c *compiler) hoistedSlot(v *types.Var) (int, bool) {
+	if c.fn == nil || !isGlobal(v) {
+		return 0, false
+	}
+	addr, found := c.fn.hoisted[v.Name()]
+	return addr, found
+}
+
+// volatileGlobals returns the names of the globals declared volatile,
+// their loads are never hoisted.
+func volatileGlobals(prog *ast.Prog) map[string]bool {
+	volatile := make(map[string]bool)
+	for _, d := range prog.Decls {
+		if d, ok := d.(*ast.VarDecl); ok && d.Storage != nil && d.Storage.Type == scan.Volatile {
+			volatile[d.Name.Name] = true
+		}
+	}
+	return volatile
+}
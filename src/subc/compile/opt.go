@@ -1,20 +1,167 @@
 package compile
 
 import (
+	"fmt"
 	"strconv"
 
+	"subc/ast"
 	"subc/compile/arch"
 	"subc/constant"
+	"subc/scan"
+	"subc/types"
 )
 
 // optimize tries to optimize the code generated tree by
 // doing constant folding and strength reductions.
 func (c *compiler) optimize(n *node) *node {
-	n = c.foldReduce(n)
-	n = c.reorderOps(n)
+	if c.fn != nil {
+		c.fn.trees++
+	}
+	for _, pass := range treePasses {
+		if pass.on != nil && !pass.on(c.conf) {
+			continue
+		}
+		dump := c.dumping(pass.name)
+		if dump {
+			c.dumpTree(n, "before", pass.name)
+		}
+		n = pass.run(c, n)
+		if dump {
+			c.dumpTree(n, "after", pass.name)
+		}
+	}
 	return n
 }
 
+// treePasses are the passes that optimize runs on the trees, in order.
+var treePasses = []struct {
+	name string
+	run  func(c *compiler, n *node) *node
+	on   func(conf Config) bool // whether the pass runs, always if nil
+}{
+	{"fold", (*compiler).foldReduce, nil},
+	{"reorder", (*compiler).reorderOps, nil},
+	{"bools", func(c *compiler, n *node) *node {
+		c.bools(n)
+		return n
+	}, func(conf Config) bool { return conf.Bools }},
+}
+
+// Passes returns the names of the passes run on the trees, in order,
+// the ones that Config.DumpPass can name.
+func Passes() []string {
+	names := make([]string, len(treePasses))
+	for i, pass := range treePasses {
+		names[i] = pass.name
+	}
+	return names
+}
+
+// dumping reports whether the trees are dumped around the pass.
+func (c *compiler) dumping(pass string) bool {
+	return c.conf.Dump != nil && (c.conf.DumpPass == "" || c.conf.DumpPass == pass)
+}
+
+// dumpTree dumps the tree n with a header that names the function,
+// the number of the tree in it and the pass.
+func (c *compiler) dumpTree(n *node, when, pass string) {
+	name, num := "", 0
+	if c.fn != nil {
+		name, num = c.fn.name, c.fn.trees
+	}
+	fmt.Fprintf(c.conf.Dump, "; %s #%d %s %s\n", name, num, when, pass)
+	p := &treePrinter{w: c.conf.Dump, indent: -1}
+	p.Dump(n)
+}
+
+// inverse are the comparisons that are true when the others are false.
+var inverse = map[opcode]opcode{
+	opEq:  opNeq,
+	opNeq: opEq,
+	opLt:  opGeq,
+	opGeq: opLt,
+	opGt:  opLeq,
+	opLeq: opGt,
+}
+
+// isBool returns if the value of a node is always 0 or 1,
+// which is what the comparisons and the logical operators give.
+func isBool(n *node) bool {
+	switch n.op {
+	case opEq, opNeq, opLt, opGt, opLeq, opGeq, opLogNot, opBool:
+		return true
+	}
+	return false
+}
+
+// bools simplifies the logical nots and the normalizations of values
+// in place, knowing which values are 0 or 1 already. A logical not of a
+// comparison becomes the inverted comparison, which can be branched on
+// without computing the 0 or 1 first, and a normalization of a value
+// that is 0 or 1 goes away. The nodes that are left are still compiled
+// by the Bool and LogNot of the backend.
+func (c *compiler) bools(n *node) {
+	if n == nil {
+		return
+	}
+	c.bools(n.left)
+	c.bools(n.right)
+
+	switch n.op {
+	case opLogNot:
+		x := n.left
+		switch {
+		case inverse[x.op] != 0: // !(a<b) -> a>=b
+			*n = *x
+			n.op = inverse[x.op]
+		case x.op == opLogNot: // !!a -> bool a
+			n.op, n.left = opBool, x.left
+		case x.op == opBool: // !bool a -> !a
+			n.left = x.left
+		}
+	}
+	if n.op == opBool && isBool(n.left) { // bool (a<b) -> a<b
+		*n = *n.left
+	}
+}
+
+// addressTaken finds the variables that have their address taken
+// anywhere in the program.
+func (c *compiler) addressTaken(prog *ast.Prog) map[types.Object]bool {
+	addr := make(map[types.Object]bool)
+	ast.Inspect(prog, func(n ast.Node) bool {
+		e, ok := n.(*ast.UnaryExpr)
+		if !ok || e.Op.Type != scan.And {
+			return true
+		}
+		x := e.X
+		for {
+			p, ok := x.(*ast.ParenExpr)
+			if !ok {
+				break
+			}
+			x = p.X
+		}
+		if id, ok := x.(*ast.Ident); ok {
+			addr[c.Uses[id]] = true
+		}
+		return true
+	})
+	return addr
+}
+
+// propagate returns if the uses of a variable can be replaced by its
+// value, which is the case for static const integers that never have
+// their address taken, so they are folded like a literal.
+func (c *compiler) propagate(v *types.Var) bool {
+	switch v.Type() {
+	case types.Typ[types.Int], types.Typ[types.Char]:
+		return v.ReadOnly() && v.Storage() == types.GlobalStatic &&
+			v.Value() != nil && !c.addr[v]
+	}
+	return false
+}
+
 // fold1 folds constant unary expressions.
 func (c *compiler) fold1(n *node) *node {
 	var lv arch.LV
@@ -22,10 +169,74 @@ func (c *compiler) fold1(n *node) *node {
 	switch n.op {
 	case opScale:
 		v, _ := strconv.Atoi(n.left.lv[0].Value.String())
-		lv.Value = constant.MakeInt64(int64(v * c.cg.Int()))
+		lv.Value = constant.MakeInt64(int64(v * c.cg.Word()))
+	default:
+		return n
+	}
+	return newNode(opLit, &lv, nil, nil, nil)
+}
+
+// fold2 folds integer binary expressions of two literals. The type
+// checker folds constant expressions already, this catches the ones
+// made of values it doesn't know as constants, like propagated ones.
+func (c *compiler) fold2(n *node) *node {
+	var lv arch.LV
+
+	vl, _ := strconv.ParseInt(n.left.lv[0].Value.String(), 10, 64)
+	vr, _ := strconv.ParseInt(n.right.lv[0].Value.String(), 10, 64)
+	bits := int64(c.cg.Int() * 8)
+
+	var v int64
+	switch n.op {
+	case opPlus, opSub:
+		// these could be pointer arithmetic that needs scaling
+		for _, lv := range n.lv {
+			if lv.Type == nil || isPointer(lv.Type) || isArray(lv.Type) {
+				return n
+			}
+		}
+		if n.op == opPlus {
+			v = vl + vr
+		} else {
+			v = vl - vr
+		}
+	case opAdd:
+		v = vl + vr
+	case opMul:
+		v = vl * vr
+	case opDiv, opMod:
+		// leave what is undefined to fail at run time like it does
+		// when the operands aren't known
+		if vr == 0 || (vr == -1 && vl == -1<<uint(bits-1)) {
+			return n
+		}
+		if n.op == opDiv {
+			v = vl / vr
+		} else {
+			v = vl % vr
+		}
+	case opLsh, opRsh:
+		if vr < 0 || vr >= bits {
+			return n
+		}
+		if n.op == opLsh {
+			v = vl << uint(vr)
+		} else {
+			v = vl >> uint(vr)
+		}
+	case opBinAnd:
+		v = vl & vr
+	case opBinOr:
+		v = vl | vr
+	case opBinXor:
+		v = vl ^ vr
 	default:
 		return n
 	}
+
+	// wrap around like the target's int would
+	v = v << uint(64-bits) >> uint(64-bits)
+	lv.Value = constant.MakeInt64(v)
 	return newNode(opLit, &lv, nil, nil, nil)
 }
 
@@ -41,6 +252,10 @@ func (c *compiler) foldReduce(n *node) *node {
 		n = c.fold1(n)
 	}
 
+	if n.left != nil && n.right != nil && n.left.op == opLit && n.right.op == opLit {
+		n = c.fold2(n)
+	}
+
 	if n.left != nil && n.right != nil && (n.left.op == opLit || n.right.op == opLit) {
 		n = c.reduce(n)
 	}
@@ -80,7 +295,7 @@ func (c *compiler) reduce(n *node) *node {
 		lv.Value = constant.MakeInt64(0)
 		return newNode(opLit, &lv, nil, nil, nil)
 
-	case op == opMul || op == opDiv: // reduce x*(2^n) or x/(2^n) to x<<n or x>>n
+	case op == opMul || op == opDiv && !c.conf.Truncate: // reduce x*(2^n) or x/(2^n) to x<<n or x>>n
 		lim := c.cg.Int()*8 - 1
 		for i, k := 0, 1; i < lim; i, k = i+1, k<<1 {
 			lv.Value = constant.MakeInt64(int64(i))
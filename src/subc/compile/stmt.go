@@ -4,12 +4,15 @@ import (
 	"strconv"
 
 	"subc/ast"
+	"subc/compile/arch"
 	"subc/scan"
+	"subc/types"
 )
 
 // stmt generates code for statements.
 func (c *compiler) stmt(s ast.Stmt) {
 	pos := s.Span().Start
+	c.cg.Pos(pos)
 	switch s := s.(type) {
 	case *ast.EmptyStmt, *ast.BadStmt:
 		// skip
@@ -30,21 +33,21 @@ func (c *compiler) stmt(s ast.Stmt) {
 	case *ast.SwitchStmt:
 		c.switchStmt(s)
 	case *ast.GotoStmt:
-		c.cg.Jump(c.labels[s.Label.Name])
+		c.gotoStmt(s)
 	case *ast.LabeledStmt:
-		c.cg.Lab(c.labels[s.Label.Name])
+		c.cg.Lab(c.label(s.Label.Name))
 		c.stmt(s.Stmt)
 	case *ast.BranchStmt:
 		switch s.Type {
 		case scan.Break:
-			c.cg.Jump(c.breakStack[len(c.breakStack)-1])
+			c.cg.Jump(c.fn.breakStack[len(c.fn.breakStack)-1])
 		case scan.Continue:
-			c.cg.Jump(c.continueStack[len(c.continueStack)-1])
+			c.cg.Jump(c.fn.continueStack[len(c.fn.continueStack)-1])
 		default:
 			c.invalidAST(pos, "bad statement: %T", s)
 		}
 	case *ast.ExprStmt:
-		c.expr(s.X)
+		c.effect(s.X)
 		c.cg.Commit()
 	default:
 		c.invalidAST(pos, "bad statement: %T", s)
@@ -71,18 +74,24 @@ func (c *compiler) ifStmt(s *ast.IfStmt) {
 
 // forStmt generates code for a for statement.
 func (c *compiler) forStmt(s *ast.ForStmt) {
+	if c.idiomStmt(s) {
+		return
+	}
 	ls := c.cg.Label()
 	lbody := c.cg.Label()
 	lb := c.cg.Label()
 	lc := c.cg.Label()
-	c.breakStack = append(c.breakStack, lb)
-	c.continueStack = append(c.continueStack, lc)
+	c.fn.breakStack = append(c.fn.breakStack, lb)
+	c.fn.continueStack = append(c.fn.continueStack, lc)
 
 	if s.Init != nil {
-		c.expr(s.Init)
+		c.effect(s.Init)
 		c.cg.Clear(true)
 	}
+	hoisted := c.hoist(s)
+	c.startWalks(s)
 
+	c.alignLoop(s.Body)
 	c.cg.Lab(ls)
 
 	if s.Cond != nil {
@@ -90,32 +99,75 @@ func (c *compiler) forStmt(s *ast.ForStmt) {
 		c.cg.BrFalse(lb)
 		c.cg.Clear(true)
 	}
-	c.cg.Jump(lbody)
-	c.cg.Lab(lc)
-
-	if s.Post != nil {
-		c.expr(s.Post)
+	if !c.conf.Layout {
+		c.cg.Jump(lbody)
+		c.cg.Lab(lc)
+		c.forPost(s)
+		c.cg.Jump(ls)
+		c.cg.Lab(lbody)
 	}
 
-	c.cg.Clear(true)
-	c.cg.Jump(ls)
-	c.cg.Lab(lbody)
-
 	c.stmt(s.Body)
 
-	c.cg.Jump(lc)
+	if c.conf.Layout {
+		// the body falls into the post statement
+		c.cg.Lab(lc)
+		c.forPost(s)
+		c.cg.Jump(ls)
+	} else {
+		c.cg.Jump(lc)
+	}
 	c.cg.Lab(lb)
+	c.endWalks(s)
+	c.endHoist(hoisted)
+
+	c.fn.breakStack = c.fn.breakStack[:len(c.fn.breakStack)-1]
+	c.fn.continueStack = c.fn.continueStack[:len(c.fn.continueStack)-1]
+}
 
-	c.breakStack = c.breakStack[:len(c.breakStack)-1]
-	c.continueStack = c.continueStack[:len(c.continueStack)-1]
+// codeAlign is the alignment of the function entries and
+// the loop headers with Config.Align.
+const codeAlign = 16
+
+// alignLoop aligns the header of a loop with body if it is an
+// innermost loop, the one that is run the most.
+func (c *compiler) alignLoop(body ast.Stmt) {
+	if c.conf.Align && !c.fn.cold && !hasLoop(body) {
+		c.cg.AlignCode(codeAlign)
+	}
+}
+
+// hasLoop reports whether there is a loop in s.
+func hasLoop(s ast.Stmt) bool {
+	found := false
+	ast.Inspect(s, func(n ast.Node) bool {
+		switch n.(type) {
+		case *ast.ForStmt, *ast.DoStmt, *ast.WhileStmt:
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// forPost generates code for the post statement of a for loop.
+func (c *compiler) forPost(s *ast.ForStmt) {
+	if s.Post != nil {
+		c.effect(s.Post)
+		c.cg.Clear(true)
+		c.stepWalks(s)
+	}
+	c.cg.Clear(true)
 }
 
 func (c *compiler) doStmt(s *ast.DoStmt) {
 	ls := c.cg.Label()
 	lb := c.cg.Label()
 	lc := c.cg.Label()
-	c.breakStack = append(c.breakStack, lb)
-	c.continueStack = append(c.continueStack, lc)
+	c.fn.breakStack = append(c.fn.breakStack, lb)
+	c.fn.continueStack = append(c.fn.continueStack, lc)
+	hoisted := c.hoist(s)
+	c.alignLoop(s.Body)
 	c.cg.Lab(ls)
 
 	c.stmt(s.Body)
@@ -127,16 +179,19 @@ func (c *compiler) doStmt(s *ast.DoStmt) {
 	c.cg.BrTrue(ls)
 	c.cg.Clear(true)
 	c.cg.Lab(lb)
-	c.breakStack = c.breakStack[:len(c.breakStack)-1]
-	c.continueStack = c.continueStack[:len(c.continueStack)-1]
+	c.endHoist(hoisted)
+	c.fn.breakStack = c.fn.breakStack[:len(c.fn.breakStack)-1]
+	c.fn.continueStack = c.fn.continueStack[:len(c.fn.continueStack)-1]
 }
 
 func (c *compiler) whileStmt(s *ast.WhileStmt) {
 	lb := c.cg.Label()
 	lc := c.cg.Label()
-	c.breakStack = append(c.breakStack, lb)
-	c.continueStack = append(c.continueStack, lc)
+	c.fn.breakStack = append(c.fn.breakStack, lb)
+	c.fn.continueStack = append(c.fn.continueStack, lc)
+	hoisted := c.hoist(s)
 
+	c.alignLoop(s.Body)
 	c.cg.Lab(lc)
 	c.expr(s.Cond)
 	c.cg.BrFalse(lb)
@@ -146,15 +201,83 @@ func (c *compiler) whileStmt(s *ast.WhileStmt) {
 
 	c.cg.Jump(lc)
 	c.cg.Lab(lb)
-	c.breakStack = c.breakStack[:len(c.breakStack)-1]
-	c.continueStack = c.continueStack[:len(c.continueStack)-1]
+	c.endHoist(hoisted)
+	c.fn.breakStack = c.fn.breakStack[:len(c.fn.breakStack)-1]
+	c.fn.continueStack = c.fn.continueStack[:len(c.fn.continueStack)-1]
 }
 
-func (c *compiler) returnStmt(s *ast.ReturnStmt) {
+// gotoStmt generates code for a goto statement, goto *X jumps to the
+// address that X computes.
+func (c *compiler) gotoStmt(s *ast.GotoStmt) {
 	if s.X != nil {
 		c.expr(s.X)
+		c.cg.Jumpr()
+		return
 	}
-	c.cg.Jump(c.cg.Retlab)
+	c.cg.Jump(c.label(s.Label.Name))
+}
+
+// label returns the label of the statements labeled name in the
+// function, it is made when it is first used.
+func (c *compiler) label(name string) arch.Label {
+	l, found := c.fn.labels[name]
+	if !found {
+		l = c.cg.Label()
+		c.fn.labels[name] = l
+	}
+	return l
+}
+
+// returnStmt generates code for a return statement, which leaves the
+// result in the accumulator and jumps to the epilogue. A function has one
+// epilogue after its body that all its returns share, the branch sink
+// removes the jump of a return that falls into it.
+func (c *compiler) returnStmt(s *ast.ReturnStmt) {
+	if s.X != nil {
+		c.exprTo(s.X, c.fn.result)
+	}
+	c.cg.Jump(c.fn.retlab)
+}
+
+// stmtExpr generates code for the statements of a statement expression,
+// the value of the last one is left in the accumulator.
+func (c *compiler) stmtExpr(e *ast.StmtExpr) {
+	for _, d := range e.Decls {
+		d, ok := d.(*ast.VarDecl)
+		if !ok {
+			continue
+		}
+		v, ok := c.Defs[d.Name].(*types.Var)
+		if !ok || v.Storage() != types.Auto || v.Value() == nil {
+			continue
+		}
+		n, _ := strconv.Atoi(v.Value().String())
+		c.cg.Lit(n)
+		c.cg.Commit()
+		c.cg.Store(arch.LV{Ident: true, Type: v.Type(), Storage: types.Auto, Addr: c.fn.sym[v].Addr, Reg: c.fn.sym[v].Reg})
+		c.cg.Clear(true)
+	}
+	c.arrayInits(e.Decls)
+
+	list := e.Body.Stmt
+	for i, s := range list {
+		if x, ok := s.(*ast.ExprStmt); ok && i == len(list)-1 {
+			c.expr(x.X)
+			c.cg.Commit()
+			break
+		}
+		c.stmt(s)
+	}
+}
+
+// caseValue returns the value of a case label.
+func (c *compiler) caseValue(e ast.Expr) int {
+	tv, found := c.typAndValue(e)
+	if !found {
+		return 0
+	}
+	n, _ := strconv.Atoi(tv.Value.String())
+	return n
 }
 
 func (c *compiler) switchStmt(s *ast.SwitchStmt) {
@@ -164,12 +287,12 @@ func (c *compiler) switchStmt(s *ast.SwitchStmt) {
 
 	lb := c.cg.Label()
 	ls := c.cg.Label()
-	c.breakStack = append(c.breakStack, lb)
+	c.fn.breakStack = append(c.fn.breakStack, lb)
 	c.cg.Jump(ls)
 
-	var ldflt int
-	var cval, clab []int
-	var nc bool
+	var ldflt arch.Label
+	var cval []int
+	var clab []arch.Label
 	for _, x := range s.Body.Stmt {
 		switch x := x.(type) {
 		case *ast.CaseClause:
@@ -182,28 +305,29 @@ func (c *compiler) switchStmt(s *ast.SwitchStmt) {
 				continue
 			}
 
-			tv, found := c.typAndValue(x.Value)
-			if found {
-				n, _ := strconv.Atoi(tv.Value.String())
-				cval = append(cval, n)
-			} else {
-				cval = append(cval, 0)
+			// a case range takes an entry for each of its values
+			lo := c.caseValue(x.Value)
+			hi := lo
+			if x.High != nil {
+				hi = c.caseValue(x.High)
 			}
 			label := c.cg.Label()
-			clab = append(clab, label)
+			for n := lo; n <= hi; n++ {
+				cval = append(cval, n)
+				clab = append(clab, label)
+			}
 			c.cg.Lab(label)
 
 			for _, y := range x.Body {
 				c.stmt(y)
 			}
-			nc = true
 
 		default:
 			c.errorf(s.Span().Start, "bad statement in switch: %T", x)
 		}
 	}
 
-	if !nc && ldflt != 0 {
+	if len(cval) == 0 && ldflt != 0 {
 		cval = append(cval, 0)
 		clab = append(clab, ldflt)
 	}
@@ -217,5 +341,5 @@ func (c *compiler) switchStmt(s *ast.SwitchStmt) {
 	c.cg.Switch(cval, clab, ldflt)
 	c.cg.Text()
 	c.cg.Lab(lb)
-	c.breakStack = c.breakStack[:len(c.breakStack)-1]
+	c.fn.breakStack = c.fn.breakStack[:len(c.fn.breakStack)-1]
 }
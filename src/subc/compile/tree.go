@@ -6,6 +6,7 @@ import (
 	"os"
 	"strconv"
 
+	"subc/ast"
 	"subc/compile/arch"
 	"subc/types"
 )
@@ -16,11 +17,13 @@ type node struct {
 	op          opcode
 	left, right *node
 	lv          [2]arch.LV
+	stmts       *ast.StmtExpr // the statement expression of an opStmts
 }
 
 const (
 	opGlue opcode = iota + 1
 	opAdd
+	opAddOvf
 	opAddr
 	opAssign
 	opBinAnd
@@ -32,9 +35,13 @@ const (
 	opCall
 	opCalr
 	opComma
+	opCopy
+	opCopyBlock
 	opDec
 	opDiv
 	opEq
+	opExt
+	opFillBlock
 	opGt
 	opGeq
 	opIdent
@@ -48,8 +55,10 @@ const (
 	opLeq
 	opMod
 	opMul
+	opMulOvf
 	opNeg
 	opNot
+	opNullCheck
 	opNeq
 	opPlus
 	opPreDec
@@ -60,53 +69,64 @@ const (
 	opRval
 	opScale
 	opScaleBy
+	opStmts
 	opSub
+	opSubOvf
 )
 
 func (op opcode) String() string {
 	tab := [...]string{
-		opGlue:    "glue",
-		opAdd:     "add",
-		opAddr:    "addr",
-		opAssign:  "assign",
-		opBinAnd:  "binand",
-		opBinOr:   "binor",
-		opBinXor:  "binxor",
-		opBool:    "bool",
-		opBrFalse: "brfalse",
-		opBrTrue:  "brtrue",
-		opCall:    "call",
-		opCalr:    "calr",
-		opComma:   "comma",
-		opDec:     "dec",
-		opDiv:     "div",
-		opEq:      "eq",
-		opGt:      "gt",
-		opGeq:     "geq",
-		opIdent:   "ident",
-		opIfElse:  "ifelse",
-		opLab:     "lab",
-		opLdlab:   "ldlab",
-		opLt:      "lt",
-		opLit:     "lit",
-		opLogNot:  "lognot",
-		opLsh:     "lsh",
-		opLeq:     "leq",
-		opMod:     "mod",
-		opMul:     "mul",
-		opNeg:     "neg",
-		opNot:     "not",
-		opNeq:     "neq",
-		opPlus:    "plus",
-		opPreDec:  "predec",
-		opPreInc:  "preinc",
-		opPostDec: "postdec",
-		opPostInc: "postinc",
-		opRsh:     "rsh",
-		opRval:    "rval",
-		opScale:   "scale",
-		opScaleBy: "scaleby",
-		opSub:     "sub",
+		opGlue:      "glue",
+		opAdd:       "add",
+		opAddOvf:    "addovf",
+		opAddr:      "addr",
+		opAssign:    "assign",
+		opBinAnd:    "binand",
+		opBinOr:     "binor",
+		opBinXor:    "binxor",
+		opBool:      "bool",
+		opBrFalse:   "brfalse",
+		opBrTrue:    "brtrue",
+		opCall:      "call",
+		opCalr:      "calr",
+		opComma:     "comma",
+		opCopy:      "copy",
+		opCopyBlock: "copyblock",
+		opDec:       "dec",
+		opDiv:       "div",
+		opEq:        "eq",
+		opExt:       "ext",
+		opFillBlock: "fillblock",
+		opGt:        "gt",
+		opGeq:       "geq",
+		opIdent:     "ident",
+		opIfElse:    "ifelse",
+		opLab:       "lab",
+		opLdlab:     "ldlab",
+		opLt:        "lt",
+		opLit:       "lit",
+		opLogNot:    "lognot",
+		opLsh:       "lsh",
+		opLeq:       "leq",
+		opMod:       "mod",
+		opMul:       "mul",
+		opMulOvf:    "mulovf",
+		opNeg:       "neg",
+		opNot:       "not",
+		opNullCheck: "nullcheck",
+		opNeq:       "neq",
+		opPlus:      "plus",
+		opPreDec:    "predec",
+		opPreInc:    "preinc",
+		opPostDec:   "postdec",
+		opPostInc:   "postinc",
+		opRsh:       "rsh",
+		opRval:      "rval",
+		opScale:     "scale",
+		opScaleBy:   "scaleby",
+		opStmts:     "stmts",
+		opSub:       "sub",
+		opSubOvf:    "subovf",
 	}
 	if op == 0 || int(op) >= len(tab) {
 		return "unknown"
@@ -137,12 +157,81 @@ func (c *compiler) emitArgs(n *node) {
 	c.emitArgs(n.left)
 }
 
+// call generates code for a call by name or through a function pointer,
+// they only differ in how the function is reached. The arguments are
+// pushed from the last to the first, after the value in the accumulator
+// if there is one, and popped by the caller when the call returns with
+// the result in the accumulator.
+func (c *compiler) call(n *node) {
+	lv := n.lv[0]
+	c.emitArgs(n.left)
+	c.cg.Commit()
+	c.cg.Spill()
+	if n.op == opCalr {
+		// the arguments are on the stack, so the accumulator
+		// is free to hold the function pointer
+		c.cg.Clear(false)
+		// lv has the type of the result, the function pointer
+		// is loaded as a pointer and not as an int or a char
+		fn := lv
+		fn.Type = voidPtr
+		c.cg.Rval(fn)
+		c.cg.Calr(lv)
+	} else {
+		c.cg.Call(lv)
+	}
+	c.cg.Stack(lv.Size * c.cg.Word())
+}
+
+// scaledIndex returns the base and the index of an array access whose
+// address the target computes with its scaled index addressing, and
+// the scale of the index. It returns a nil base for other nodes. The
+// scaled index is on either side, as reorderOps moves a pointer variable
+// to the right.
+func (c *compiler) scaledIndex(n *node) (base, index *node, scale int) {
+	if !c.conf.Index || n == nil || n.op != opAdd || n.left == nil || n.right == nil {
+		return nil, nil, 0
+	}
+	base, m := n.left, n.right
+	if m.op != opScale && m.op != opScaleBy {
+		base, m = m, base
+	}
+	switch m.op {
+	case opScale:
+		scale = c.cg.Word()
+	case opScaleBy:
+		scale = m.lv[0].Size
+	}
+	switch scale {
+	case 1, 2, 4, 8:
+		return base, m.left, scale
+	}
+	return nil, nil, 0
+}
+
+// indexed generates code for the base and the index of an array access
+// with the index in the accumulator and the base queued or pushed before
+// it. A base that is only queued is generated after the index, so it is
+// loaded straight into the second register.
+func (c *compiler) indexed(base, index *node) {
+	switch {
+	case base.op == opAddr, base.op == opLdlab,
+		base.op == opRval && base.left != nil && base.left.op == opIdent:
+		c.tree(index)
+		c.cg.Commit()
+		c.tree(base)
+	default:
+		c.tree(base)
+		c.tree(index)
+		c.cg.Commit()
+	}
+}
+
 func (c *compiler) tree(n *node) {
 	if n == nil {
 		return
 	}
 
-	intSize := c.cg.Int()
 	lv := n.lv[0]
 	switch n.op {
 	case opIdent, opGlue:
@@ -168,6 +257,10 @@ func (c *compiler) tree(n *node) {
 			c.cg.Inc(lv, false, false)
 		}
 
+	case opExt:
+		c.tree(n.left)
+		c.cg.Ext(arch.Width(lv.Size))
+
 	case opLogNot, opNeg, opNot, opScale:
 		c.tree(n.left)
 		switch n.op {
@@ -207,6 +300,11 @@ func (c *compiler) tree(n *node) {
 		}
 
 	case opMod, opLsh, opRsh, opDiv, opBinAnd, opBinOr, opBinXor, opMul, opSub, opPlus, opAdd:
+		if base, index, scale := c.scaledIndex(n); base != nil {
+			c.indexed(base, index)
+			c.cg.Index(scale)
+			break
+		}
 		c.tree(n.left)
 		c.tree(n.right)
 		c.cg.Commit()
@@ -235,31 +333,56 @@ func (c *compiler) tree(n *node) {
 			c.cg.Sub(n.lv[0].Type, n.lv[1].Type, true)
 		}
 
-	case opCall:
-		c.emitArgs(n.left)
+	case opCall, opCalr:
+		c.call(n)
+
+	case opAddOvf, opSubOvf, opMulOvf:
+		// the pointer to the result goes first, under the operands
+		c.tree(n.left)
 		c.cg.Commit()
-		c.cg.Spill()
-		c.cg.Call(lv)
-		c.cg.Stack(lv.Size * intSize)
+		c.tree(n.right.left)
+		c.tree(n.right.right)
+		c.cg.Commit()
+		switch n.op {
+		case opAddOvf:
+			c.cg.AddOvf()
+		case opSubOvf:
+			c.cg.SubOvf()
+		case opMulOvf:
+			c.cg.MulOvf()
+		}
 
-	case opCalr:
-		c.emitArgs(n.left)
+	case opNullCheck:
+		c.tree(n.left)
+		c.cg.NullCheck(lv.Label)
+
+	case opCopyBlock, opFillBlock:
+		// the destination goes first, under the source and the count
+		c.tree(n.left)
 		c.cg.Commit()
-		c.cg.Spill()
-		c.cg.Clear(false)
-		c.cg.Rval(lv)
-		c.cg.Calr(lv)
-		c.cg.Stack(lv.Size * intSize)
+		c.tree(n.right.left)
+		c.tree(n.right.right)
+		c.cg.Commit()
+		if n.op == opCopyBlock {
+			c.cg.CopyBlock()
+		} else {
+			c.cg.FillBlock()
+		}
 
 	case opLab:
 		c.tree(n.left)
 		c.cg.Commit()
-		c.cg.Lab(lv.Addr)
+		c.cg.Lab(lv.Label)
 
 	case opLdlab:
-		c.cg.Ldlab(lv.Addr)
+		c.cg.Ldlab(lv.Label)
 
 	case opRval:
+		if base, index, scale := c.scaledIndex(n.left); base != nil && !lv.Ident {
+			c.indexed(base, index)
+			c.cg.IndIndex(lv, scale)
+			break
+		}
 		c.tree(n.left)
 		c.cg.Rval(lv)
 
@@ -276,6 +399,13 @@ func (c *compiler) tree(n *node) {
 		}
 		c.cg.Store(lv)
 
+	case opCopy:
+		c.tree(n.left)
+		c.cg.Commit()
+		c.tree(n.right)
+		c.cg.Commit()
+		c.cg.Copy(c.cg.Sizeof(lv.Type), int(c.cg.Alignof(lv.Type)))
+
 	case opScaleBy:
 		c.tree(n.left)
 		c.cg.ScaleBy(lv.Size)
@@ -283,15 +413,15 @@ func (c *compiler) tree(n *node) {
 	case opIfElse:
 		c.emitCond(n.left, lv)
 		c.cg.Commit()
-		c.cg.Lab(lv.Addr)
+		c.cg.Lab(lv.Label)
 
 	case opBrFalse, opBrTrue:
 		c.tree(n.left)
 		c.cg.Commit()
 		if n.op == opBrTrue {
-			c.cg.BrTrue(lv.Addr)
+			c.cg.BrTrue(lv.Label)
 		} else {
-			c.cg.BrFalse(lv.Addr)
+			c.cg.BrFalse(lv.Label)
 		}
 		c.cg.Clear(false)
 		c.tree(n.right)
@@ -306,6 +436,13 @@ func (c *compiler) tree(n *node) {
 		c.tree(n.left)
 		c.cg.Bool()
 
+	case opStmts:
+		// the statements use the accumulator like a call does
+		c.cg.Commit()
+		c.cg.Spill()
+		c.cg.Clear(true)
+		c.stmtExpr(n.stmts)
+
 	default:
 		panic(fmt.Sprintf("internal: unhandle op %v", n.op))
 	}
@@ -316,12 +453,12 @@ func (c *compiler) emitCond(n *node, lv arch.LV) {
 		c.emitCond(n.left.left, lv)
 	}
 	c.tree(n.left.left)
-	c.cg.BrFalse(n.left.lv[0].Addr)
+	c.cg.BrFalse(n.left.lv[0].Label)
 	c.cg.Clear(false)
 	c.tree(n.left.right)
-	c.cg.Jump(lv.Addr)
+	c.cg.Jump(lv.Label)
 	c.cg.Commit()
-	c.cg.Lab(n.left.lv[0].Addr)
+	c.cg.Lab(n.left.lv[0].Label)
 	c.cg.Clear(false)
 	c.tree(n.right)
 }
@@ -332,7 +469,7 @@ func (c *compiler) printTree(n *node) {
 }
 
 func (c *compiler) emit(n *node) {
-	c.optimize(n)
+	n = c.optimize(n)
 	//c.printTree(n)
 	c.tree(n)
 }
@@ -349,7 +486,7 @@ func (p *treePrinter) Dump(n *node) {
 
 	p.indent++
 	for i := 0; i < p.indent; i++ {
-		fmt.Printf("  ")
+		fmt.Fprintf(p.w, "  ")
 	}
 
 	switch n.op {
@@ -364,11 +501,19 @@ func (p *treePrinter) Dump(n *node) {
 		fmt.Fprintf(p.w, "calr %s %v\n", n.lv[0].Name, n.lv[0].Type)
 		p.Dump(n.left)
 
+	case opExt:
+		w := arch.Width(n.lv[0].Size)
+		if w.Signed() {
+			p.dumpUnaryOp(n, "sext %v\n", n.lv[0].Type)
+		} else {
+			p.dumpUnaryOp(n, "zext %v\n", n.lv[0].Type)
+		}
+
 	case opLab:
-		fmt.Fprintf(p.w, "label L%d\n", n.lv[0].Addr)
+		fmt.Fprintf(p.w, "label L%d\n", n.lv[0].Label)
 
 	case opLdlab:
-		fmt.Fprintf(p.w, "ldlab L%d\n", n.lv[0].Addr)
+		fmt.Fprintf(p.w, "ldlab L%d\n", n.lv[0].Label)
 
 	case opGlue:
 		fmt.Fprintf(p.w, "glue\n")
@@ -387,6 +532,9 @@ func (p *treePrinter) Dump(n *node) {
 	case opAssign:
 		p.dumpBinExpr(n, "=")
 
+	case opCopy:
+		p.dumpBinExpr(n, "copy")
+
 	case opEq:
 		p.dumpBinExpr(n, "==")
 
@@ -469,7 +617,7 @@ func (p *treePrinter) Dump(n *node) {
 		p.dumpUnaryExpr(n, "scaleby ")
 
 	case opIfElse:
-		p.dumpUnaryExpr(n, "ifelse ")
+		p.dumpBinOp(n, "ifelse\n")
 
 	case opBrFalse:
 		p.dumpBinExpr(n, "brfalse")
@@ -480,6 +628,15 @@ func (p *treePrinter) Dump(n *node) {
 	case opComma:
 		p.dumpBinExpr(n, ",")
 
+	case opStmts:
+		fmt.Fprintf(p.w, "stmts %v\n", n.lv[0].Type)
+
+	case opAddOvf, opSubOvf, opMulOvf, opCopyBlock, opFillBlock:
+		p.dumpBinOp(n, "%v\n", n.op)
+
+	case opNullCheck:
+		p.dumpUnaryOp(n, "nullcheck\n")
+
 	default:
 		panic(fmt.Sprintf("unknown tree printer op: %v", n.op))
 	}
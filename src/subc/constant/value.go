@@ -2,8 +2,10 @@ package constant
 
 import (
 	"fmt"
+	"math"
 	"math/big"
 	"strconv"
+	"strings"
 
 	"subc/scan"
 )
@@ -102,6 +104,9 @@ func BinaryOp(x Value, op scan.Type, y Value) (Value, error) {
 			if b == 0 {
 				return int64Val(0), fmt.Errorf("division by zero")
 			}
+			if a == math.MinInt64 && b == -1 {
+				return normInt(new(big.Int).Neg(big.NewInt(a))), nil
+			}
 			c = a / b
 		case scan.Mod:
 			if b == 0 {
@@ -315,6 +320,7 @@ func MakeFromLiteral(lit string, tok scan.Type, prec uint) Value {
 
 	switch tok {
 	case scan.Number:
+		lit = strings.TrimRight(lit, "uUlL")
 		if x, err := strconv.ParseInt(lit, 0, 64); err == nil {
 			return int64Val(x)
 		}
@@ -330,9 +336,25 @@ func MakeFromLiteral(lit string, tok scan.Type, prec uint) Value {
 			return int64Val('\'')
 		}
 
+		// wide character constants are L'x' and have the value of the code point
+		if lit != "" && lit[0] == 'L' {
+			lit = lit[1:]
+		}
 		if n := len(lit); n >= 2 {
-			if code, _, _, err := strconv.UnquoteChar(lit[1:n-1], '\''); err == nil {
-				return int64Val(code)
+			// multi-character constants pack each character in as a byte
+			x := int64(0)
+			for s, i := lit[1:n-1], 0; ; i++ {
+				code, _, tail, err := strconv.UnquoteChar(s, '\'')
+				if err != nil {
+					break
+				}
+				if i == 0 && tail == "" {
+					return int64Val(code)
+				}
+				x = x<<8 | int64(code&0xff)
+				if s = tail; s == "" {
+					return int64Val(x)
+				}
 			}
 		}
 
@@ -401,3 +423,23 @@ func MakeInt64(x int64) Value { return int64Val(x) }
 
 // MakeUint64 creates a constant value out of a uint64.
 func MakeUint64(x uint64) Value { return normInt(new(big.Int).SetUint64(x)) }
+
+// Wrap truncates an integer constant to a signed integer of the given
+// bits, the way it wraps around when computed at run time.
+func Wrap(x Value, bits uint) Value {
+	switch x := x.(type) {
+	case int64Val:
+		if bits >= 64 {
+			return x
+		}
+		return x << (64 - bits) >> (64 - bits)
+	case intVal:
+		m := new(big.Int).Lsh(big.NewInt(1), bits)
+		z := new(big.Int).Mod(x.val, m)
+		if z.Bit(int(bits)-1) == 1 {
+			z.Sub(z, m)
+		}
+		return normInt(z)
+	}
+	return x
+}
@@ -0,0 +1,149 @@
+package obj
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"sort"
+)
+
+// Hash returns the hex SHA-256 of what the ELF object in data gives the
+// linker, for the build systems that cache the objects by their content.
+// The metadata that the linker doesn't put in the image is left out, so
+// the hash doesn't change with it: the .comment of the compiler and its
+// version, the file symbols that name the source, the layout of the file
+// and the order of the symbol table. The contents of the sections are
+// hashed with their relocations, of the symbols by name.
+func Hash(data []byte) (string, error) {
+	f, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	syms, err := f.Symbols()
+	if err != nil && err != elf.ErrNoSymbols {
+		return "", err
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%v %v %v %v %v %#x\n", f.Class, f.Data, f.OSABI, f.Type, f.Machine, f.Entry)
+	if err := hashSections(h, f, syms); err != nil {
+		return "", err
+	}
+	hashSymbols(h, f, syms)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashSections hashes the sections of f that the linker places and the
+// relocations that apply to them.
+func hashSections(h hash.Hash, f *elf.File, syms []elf.Symbol) error {
+	rels := make(map[uint32][]*elf.Section)
+	for _, s := range f.Sections {
+		if s.Type == elf.SHT_REL || s.Type == elf.SHT_RELA {
+			rels[s.Info] = append(rels[s.Info], s)
+		}
+	}
+	for i, s := range f.Sections {
+		switch {
+		case s.Type == elf.SHT_NULL, s.Type == elf.SHT_SYMTAB, s.Type == elf.SHT_STRTAB,
+			s.Type == elf.SHT_REL, s.Type == elf.SHT_RELA, s.Name == ".comment":
+			continue
+		}
+		fmt.Fprintf(h, "section %q %v %v %d %d\n", s.Name, s.Type, s.Flags, s.Addralign, s.Size)
+		if s.Type != elf.SHT_NOBITS {
+			data, err := s.Data()
+			if err != nil {
+				return fmt.Errorf("section %s: %v", s.Name, err)
+			}
+			h.Write(data)
+		}
+		for _, r := range rels[uint32(i)] {
+			if err := hashRelocs(h, f, r, syms); err != nil {
+				return fmt.Errorf("section %s: %v", r.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// hashRelocs hashes the relocations of the section r by their offsets,
+// types, symbols and addends.
+func hashRelocs(h hash.Hash, f *elf.File, r *elf.Section, syms []elf.Symbol) error {
+	data, err := r.Data()
+	if err != nil {
+		return err
+	}
+	rd := bytes.NewReader(data)
+	for rd.Len() > 0 {
+		var off, typ uint64
+		var sym uint32
+		var addend int64
+		if f.Class == elf.ELFCLASS64 {
+			var rela elf.Rela64
+			if r.Type == elf.SHT_REL {
+				var rel elf.Rel64
+				err = binary.Read(rd, f.ByteOrder, &rel)
+				rela = elf.Rela64{Off: rel.Off, Info: rel.Info}
+			} else {
+				err = binary.Read(rd, f.ByteOrder, &rela)
+			}
+			off, typ, sym, addend = rela.Off, uint64(elf.R_TYPE64(rela.Info)), elf.R_SYM64(rela.Info), rela.Addend
+		} else {
+			var rela elf.Rela32
+			if r.Type == elf.SHT_REL {
+				var rel elf.Rel32
+				err = binary.Read(rd, f.ByteOrder, &rel)
+				rela = elf.Rela32{Off: rel.Off, Info: rel.Info}
+			} else {
+				err = binary.Read(rd, f.ByteOrder, &rela)
+			}
+			off, typ, sym, addend = uint64(rela.Off), uint64(elf.R_TYPE32(rela.Info)), elf.R_SYM32(rela.Info), int64(rela.Addend)
+		}
+		if err != nil {
+			return err
+		}
+		if sym > uint32(len(syms)) {
+			return fmt.Errorf("relocation at %#x of symbol %d, there are %d", off, sym, len(syms))
+		}
+		name := ""
+		if sym > 0 {
+			name = symbolName(f, syms[sym-1])
+		}
+		fmt.Fprintf(h, "reloc %#x %d %q %d\n", off, typ, name, addend)
+	}
+	return nil
+}
+
+// hashSymbols hashes the symbols of f but the file and section symbols,
+// sorted so the order of the symbol table doesn't change the hash.
+func hashSymbols(h hash.Hash, f *elf.File, syms []elf.Symbol) {
+	var lines []string
+	for _, sym := range syms {
+		switch elf.ST_TYPE(sym.Info) {
+		case elf.STT_FILE, elf.STT_SECTION:
+			continue
+		}
+		section := fmt.Sprint(sym.Section)
+		if int(sym.Section) < len(f.Sections) && sym.Section != elf.SHN_UNDEF {
+			section = f.Sections[sym.Section].Name
+		}
+		lines = append(lines, fmt.Sprintf("symbol %q %v %v %v %q %#x %d\n", sym.Name,
+			elf.ST_TYPE(sym.Info), elf.ST_BIND(sym.Info), elf.ST_VISIBILITY(sym.Other), section, sym.Value, sym.Size))
+	}
+	sort.Strings(lines)
+	for _, l := range lines {
+		h.Write([]byte(l))
+	}
+}
+
+// symbolName returns the name of sym in the relocations, a section
+// symbol by the name of its section.
+func symbolName(f *elf.File, sym elf.Symbol) string {
+	if elf.ST_TYPE(sym.Info) == elf.STT_SECTION && int(sym.Section) < len(f.Sections) {
+		return "section " + f.Sections[sym.Section].Name
+	}
+	return sym.Name
+}
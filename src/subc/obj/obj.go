@@ -0,0 +1,75 @@
+//go:generate stringer -type SymKind,RelocKind
+
+// Package obj defines the kinds of the symbols and the relocations of
+// the object files and the byte order of their data, shared by the
+// assembler, the backends of the compiler and the tools that read the
+// objects it writes, and hashes the objects by their content.
+package obj
+
+import "encoding/binary"
+
+// SymKind is the kind of a symbol, it decides the section the symbol
+// is placed in and the relocations applied to its uses.
+type SymKind int
+
+const (
+	SymNone  SymKind = iota // not defined yet
+	SymLabel                // a label in the text or the data
+	SymBSS                  // a block of zeros in the bss
+	SymUndef                // defined by another object
+)
+
+// RelocKind is the kind of a relocation, what the linker puts at the
+// place that uses a symbol.
+type RelocKind int
+
+const (
+	RelocNone      RelocKind = iota // resolved by the assembler, there is nothing to relocate
+	RelocAbs                        // the address of the symbol in a sign extended 32-bit field of an instruction
+	RelocPC                         // the address of the symbol relative to the end of the instruction
+	RelocData                       // the address of the symbol in a data directive like .quad
+	RelocHi                         // the high 16 bits of the address of the symbol, 20 on riscv64, rounded for the sign of the low bits
+	RelocLo                         // the low 16 bits of the address of the symbol in the immediate of an instruction, 12 on riscv64
+	RelocJump                       // the address of the symbol in the 26-bit word index of a jump in the same 256M region
+	RelocCall                       // the address of the symbol relative to the instruction in the offset of a call, of auipc and jalr on riscv64
+	RelocBranch                     // the address of the symbol relative to the instruction in the offset of a branch
+	RelocPage                       // the 4K page of the address of the symbol relative to the page of the instruction
+	RelocPageOff                    // the offset of the address of the symbol in its 4K page, in the immediate of an add
+	RelocPageOff8                   // the offset in its 4K page of the address of a byte that is loaded or stored
+	RelocPageOff16                  // the offset in its 4K page of the address of a halfword, in halfwords
+	RelocPageOff32                  // the offset in its 4K page of the address of a word, in words
+	RelocPageOff64                  // the offset in its 4K page of the address of a doubleword, in doublewords
+	RelocLoStore                    // the low 12 bits of the address of the symbol in the split immediate of a riscv64 store
+)
+
+// ByteOrder returns the byte order of the data of the architecture arch,
+// which the compiler lays out its constants for and the assembler writes
+// them in. The words of mips are big-endian and the ones of the other
+// targets little-endian.
+func ByteOrder(arch string) binary.ByteOrder {
+	switch arch {
+	case "mips":
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// Value returns the bytes of the integer v in a data directive of size
+// bytes, in the byte order of the target. The bits above the size are
+// dropped, a directive checks that the value fits before.
+func Value(order binary.ByteOrder, size int, v int64) []byte {
+	b := make([]byte, 8)
+	switch size {
+	case 1:
+		b[0] = byte(v)
+	case 2:
+		order.PutUint16(b, uint16(v))
+	case 4:
+		order.PutUint32(b, uint32(v))
+	case 8:
+		order.PutUint64(b, uint64(v))
+	default:
+		panic("unsupported size of a value")
+	}
+	return b[:size]
+}
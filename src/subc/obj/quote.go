@@ -0,0 +1,106 @@
+package obj
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The strings of the assembly are written in the escapes of the GNU
+// assembler: \b, \f, \n, \r and \t, \\ and the quotes, up to 3 octal
+// digits and \x with the hex digits that follow it, of which the low
+// byte is kept. Quote only writes the octal ones and the backslashed
+// quote and backslash, so every string that the compiler writes is read
+// back by Unquote as the same bytes, the nul and high bytes included.
+
+// Quote returns text quoted as a string of the assembler, the bytes
+// that can't be in it as they are are escaped in octal.
+func Quote(text string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(text); i++ {
+		switch ch := text[i]; {
+		case ch == '"' || ch == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(ch)
+		case ch < ' ' || ch >= 0x7f:
+			fmt.Fprintf(&b, "\\%03o", ch)
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// Unquote returns the bytes of the string or the character constant s,
+// quoted by " or '.
+func Unquote(s string) (string, error) {
+	if len(s) < 2 || s[0] != '"' && s[0] != '\'' || s[len(s)-1] != s[0] {
+		return "", errors.New("missing quote")
+	}
+	q, s := s[0], s[1:len(s)-1]
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch == q:
+			return "", fmt.Errorf("unescaped %c", q)
+		case ch != '\\':
+			b.WriteByte(ch)
+			continue
+		case i+1 == len(s):
+			return "", errors.New("backslash at the end")
+		}
+		i++
+		switch ch = s[i]; ch {
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case '\\', '"', '\'':
+			b.WriteByte(ch)
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			v := 0
+			for n := 0; n < 3 && i < len(s) && '0' <= s[i] && s[i] <= '7'; n++ {
+				v = v<<3 | int(s[i]-'0')
+				i++
+			}
+			b.WriteByte(byte(v))
+			i--
+		case 'x', 'X':
+			v, n := 0, 0
+			for i++; i < len(s) && hexDigit(s[i]) >= 0; i++ {
+				v = v<<4&0xff | hexDigit(s[i])
+				n++
+			}
+			if n == 0 {
+				return "", errors.New("\\x without hex digits")
+			}
+			b.WriteByte(byte(v))
+			i--
+		default:
+			return "", fmt.Errorf("unknown escape \\%c", ch)
+		}
+	}
+	return b.String(), nil
+}
+
+// hexDigit returns the value of the hex digit ch, -1 if it isn't one.
+func hexDigit(ch byte) int {
+	switch {
+	case '0' <= ch && ch <= '9':
+		return int(ch - '0')
+	case 'a' <= ch && ch <= 'f':
+		return int(ch-'a') + 10
+	case 'A' <= ch && ch <= 'F':
+		return int(ch-'A') + 10
+	}
+	return -1
+}
@@ -3,6 +3,7 @@ package parse
 import (
 	"bytes"
 	"fmt"
+	"strings"
 
 	"subc/ast"
 	"subc/scan"
@@ -15,20 +16,29 @@ import (
  *	| primtype decl
  *	| storclass decl
  *	| storclass primtype decl
+ *	| CONST top
+ *	| attributes top
  *
  * storclass :=
  *	  EXTERN
  *	| STATIC
  *	| VOLATILE
+ *	| storclass CONST
  */
 
 func (p *parser) top() (decls []ast.Decl) {
 	var storage *scan.Token
+	attrs := p.attributes()
+	konst := p.constQual()
 	switch tok := p.peek(); tok.Type {
 	case scan.Extern, scan.Static, scan.Volatile:
 		storage = &tok
 		p.next()
 	}
+	if konst == nil {
+		konst = p.constQual()
+	}
+	attrs = append(attrs, p.attributes()...)
 
 	switch tok := p.peek(); tok.Type {
 	case scan.Enum:
@@ -39,8 +49,7 @@ func (p *parser) top() (decls []ast.Decl) {
 		decls = p.structDecl(storage)
 	case scan.Char, scan.Int, scan.Short, scan.Long, scan.Float,
 		scan.Double, scan.Complex, scan.Bool, scan.Void:
-		p.next()
-		decls = p.decl(storage, &ast.BasicType{Type: tok})
+		decls = p.decl(storage, p.primType(tok))
 	case scan.Ident:
 		decls = p.decl(storage, nil)
 	default:
@@ -49,10 +58,169 @@ func (p *parser) top() (decls []ast.Decl) {
 		badDecl := &ast.BadDecl{span.Start, span.End}
 		decls = append(decls, badDecl)
 	}
+	setConst(decls, konst)
+	p.setAttrs(decls, attrs)
 
 	return
 }
 
+// constQual skips a const qualifier, returning it if there was one.
+func (p *parser) constQual() *scan.Token {
+	if tok := p.peek(); tok.Type == scan.Const {
+		p.next()
+		return &tok
+	}
+	return nil
+}
+
+// setConst marks the variables declared by decls as const.
+func setConst(decls []ast.Decl, konst *scan.Token) {
+	if konst == nil {
+		return
+	}
+	for _, d := range decls {
+		if d, ok := d.(*ast.VarDecl); ok {
+			d.Const = konst
+		}
+	}
+}
+
+/*
+ * attributes :=
+ *	  __attribute__ ( ( attrlist ) )
+ *	| __attribute__ ( ( attrlist ) ) attributes
+ *
+ * attrlist :=
+ *	  attr
+ *	| attr , attrlist
+ *
+ * attr :=
+ *	  IDENT
+ *	| IDENT ( )
+ *	| IDENT ( attrargs )
+ *
+ * attrargs :=
+ *	  asgmnt
+ *	| asgmnt , attrargs
+ */
+
+func (p *parser) attributes() (attrs []*ast.Attr) {
+	for {
+		if tok := p.peek(); tok.Type != scan.Ident || tok.Text != "__attribute__" {
+			return
+		}
+		p.next()
+		p.expect(scan.Lparen)
+		p.expect(scan.Lparen)
+		for {
+			tok := p.peek()
+			if tok.Type != scan.Ident {
+				break
+			}
+			p.next()
+			a := &ast.Attr{Name: &ast.Ident{tok.Pos, attrName(tok.Text)}}
+			if tok := p.peek(); tok.Type == scan.Lparen {
+				p.attrArgs(a)
+			}
+			attrs = append(attrs, a)
+
+			if tok := p.peek(); tok.Type != scan.Comma {
+				break
+			}
+			p.next()
+		}
+		p.expect(scan.Rparen)
+		p.expect(scan.Rparen)
+	}
+}
+
+// attrArgs parses the arguments of attribute a.
+func (p *parser) attrArgs(a *ast.Attr) {
+	p.expect(scan.Lparen)
+	for {
+		if tok := p.peek(); tok.Type == scan.Rparen {
+			break
+		}
+		a.Args = append(a.Args, p.asgmnt())
+		if tok := p.peek(); tok.Type != scan.Comma {
+			break
+		}
+		p.next()
+	}
+	rparen := p.expect(scan.Rparen)
+	a.Rparen = &rparen
+}
+
+// attrName returns the name of an attribute, __name__ is the same as name.
+func attrName(name string) string {
+	if len(name) > 4 && strings.HasPrefix(name, "__") && strings.HasSuffix(name, "__") {
+		return name[2 : len(name)-2]
+	}
+	return name
+}
+
+/*
+ * asmlabel :=
+ *	  __asm__ ( STRING )
+ *	| __asm ( STRING )
+ *	| asm ( STRING )
+ */
+
+// asmLabel parses the asm label of a declaration, which gives the name
+// of its symbol, if it has one. The parameters have none, and asm is
+// only a keyword with the GNU extensions.
+func (p *parser) asmLabel(pmtr bool) *ast.StringLit {
+	tok := p.peek()
+	if pmtr || tok.Type != scan.Ident {
+		return nil
+	}
+	switch {
+	case tok.Text == "__asm__", tok.Text == "__asm":
+	case tok.Text == "asm" && !p.conf.ANSI:
+	default:
+		return nil
+	}
+	p.next()
+	p.expect(scan.Lparen)
+	s := &ast.StringLit{}
+	for {
+		tok := p.peek()
+		if tok.Type != scan.String {
+			break
+		}
+		s.Lits = append(s.Lits, &ast.BasicLit{tok})
+		p.next()
+	}
+	if len(s.Lits) == 0 {
+		tok := p.peek()
+		p.errorf(tok.Pos, "expected the name of a symbol in asm label, got %v", tok.Type)
+		p.synch(tok.Pos, scan.Rparen)
+		return nil
+	}
+	p.expect(scan.Rparen)
+	return s
+}
+
+// setAttrs gives the attributes in front of decls to the functions
+// and the variables they declare, the attributes of anything else are
+// ignored.
+func (p *parser) setAttrs(decls []ast.Decl, attrs []*ast.Attr) {
+	if len(attrs) == 0 {
+		return
+	}
+	for _, d := range decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			d.Attrs = append(attrs[:len(attrs):len(attrs)], d.Attrs...)
+		case *ast.VarDecl:
+			d.Attrs = append(attrs[:len(attrs):len(attrs)], d.Attrs...)
+		default:
+			p.warnf(attrs[0].Span().Start, "attributes of declarations other than functions and variables are ignored")
+			return
+		}
+	}
+}
+
 /*
  * enumdecl := { enumlist } ;
  *
@@ -175,6 +343,7 @@ func (p *parser) structDecl(storage *scan.Token) (decls []ast.Decl) {
 	d := &ast.RecordDecl{}
 	d.Record = typ
 	d.Name = &ast.Ident{name.Pos, name.Text}
+	d.Pack = p.pack
 	d.Lbrace = p.next()
 
 	for {
@@ -276,7 +445,7 @@ func (p *parser) preDeclFunc(tok scan.Token, ident string) (ast.Decl, []ast.Stmt
 	for i := 0; i < len(fn); i++ {
 		fmt.Fprintf(src, "%s[%d] = %d;", ident, i, fn[i])
 	}
-	fmt.Fprintf(src, "%s[%d] = 0;", ident, len(fn)+1)
+	fmt.Fprintf(src, "%s[%d] = 0;", ident, len(fn))
 	fmt.Fprintf(src, "}")
 
 	scanner := scan.New(scan.DefaultConfig, tok.Pos.Filename, scan.StringReader(tok.Pos, src.String(), true))
@@ -297,11 +466,20 @@ func (p *parser) preDeclFunc(tok scan.Token, ident string) (ast.Decl, []ast.Stmt
  *	| * IDENT [ constexpr ]
  *	| IDENT [ constexpr ]
  *	| IDENT = constexpr
+ *	| IDENT [ constexpr ] = initlist
  *	| IDENT [ ] = initlist
  *	| IDENT pmtrdecl
  *	| IDENT [ ]
  *	| * IDENT [ ]
  *	| ( * IDENT ) ( )
+ *	| declarator asmlabel
+ *	| declarator attributes
+ *	| IDENT attributes = constexpr
+ *	| IDENT [ constexpr ] attributes = initlist
+ *	| IDENT [ ] attributes = initlist
+ *
+ * The asm label of a variable or an array comes before its attributes
+ * and its initializer, the one of a function after its parameters.
  */
 
 func (p *parser) declarator(pmtr bool, storage *scan.Token, prim ast.Decl) ast.Decl {
@@ -343,6 +521,9 @@ func (p *parser) declarator(pmtr bool, storage *scan.Token, prim ast.Decl) ast.D
 		f.Rparen[1] = p.expect(scan.Rparen)
 	}
 
+	// the attributes of a variable come before its initializer
+	v.Asm = p.asmLabel(pmtr)
+	attrs := p.attributes()
 	d := ast.Decl(v)
 	switch tok := p.peek(); {
 	case !pmtr && tok.Type == scan.Assign:
@@ -357,6 +538,7 @@ func (p *parser) declarator(pmtr bool, storage *scan.Token, prim ast.Decl) ast.D
 		fd.Lparen = p.next()
 		fd.Params = p.pmtrDecls()
 		fd.Rparen = p.expect(scan.Rparen)
+		fd.Asm = p.asmLabel(pmtr)
 		d = fd
 
 	case tok.Type == scan.Lbrack:
@@ -366,14 +548,18 @@ func (p *parser) declarator(pmtr bool, storage *scan.Token, prim ast.Decl) ast.D
 		if tok := p.peek(); tok.Type == scan.Rbrack {
 			a.Rbrack = tok
 			p.next()
-			if tok := p.peek(); tok.Type == scan.Assign {
-				p.next()
-				v.Value = p.initList()
-			}
 		} else {
 			a.Len = p.constExpr()
 			a.Rbrack = p.expect(scan.Rbrack)
 		}
+		if v.Asm == nil {
+			v.Asm = p.asmLabel(pmtr)
+		}
+		attrs = append(attrs, p.attributes()...)
+		if tok := p.peek(); !pmtr && tok.Type == scan.Assign {
+			p.next()
+			v.Value = p.initList()
+		}
 
 		if s != nil {
 			s.X = a
@@ -381,6 +567,7 @@ func (p *parser) declarator(pmtr bool, storage *scan.Token, prim ast.Decl) ast.D
 			setType(v.Type, a)
 		}
 	}
+	p.setAttrs([]ast.Decl{d}, append(attrs, p.attributes()...))
 
 	return d
 }
@@ -459,6 +646,7 @@ loop:
  *	  primtype ldecl_list ;
  *	| lclass primtype ldecl_list ;
  *	| lclass ldecl_list ;
+ *	| CONST ldecl
  *	| enum_decl
  *	| struct_decl
  *
@@ -468,6 +656,7 @@ loop:
  *	| REGISTER
  *	| STATIC
  *	| VOLATILE
+ *	| lclass CONST
  *
  * ldecl_list :=
  *	  declarator
@@ -490,9 +679,14 @@ func (p *parser) localDecls() []ast.Decl {
 
 		var storage *scan.Token
 		var prim ast.Decl
+		konst := p.constQual()
+		tok = p.peek()
 		if isQualifier(tok.Type) {
 			storage = &tok
 			p.next()
+			if konst == nil {
+				konst = p.constQual()
+			}
 			if tok := p.peek(); isType(tok.Type) {
 				prim = p.primType(tok)
 			}
@@ -500,6 +694,7 @@ func (p *parser) localDecls() []ast.Decl {
 			prim = p.primType(tok)
 		}
 
+		start := len(d)
 		for {
 			if p.eofCheck() {
 				return d
@@ -514,18 +709,25 @@ func (p *parser) localDecls() []ast.Decl {
 				break
 			}
 		}
+		setConst(d[start:], konst)
 		p.expect(scan.Semi)
 	}
 
 	return d
 }
 
-// primType creates a basic type or a record type from token
+// primType creates a basic type or a record type from token. long double
+// is taken as double, there is no wider floating point type.
 func (p *parser) primType(tok scan.Token) ast.Expr {
 	switch tok.Type {
 	case scan.Char, scan.Short, scan.Int, scan.Long, scan.Float,
 		scan.Double, scan.Complex, scan.Bool, scan.Void:
 		p.next()
+		if next := p.peek(); tok.Type == scan.Long && next.Type == scan.Double {
+			p.next()
+			p.warnf(tok.Pos, "long double is treated as double")
+			tok.Type, tok.Text = scan.Double, "long double"
+		}
 		return &ast.BasicType{Type: tok}
 
 	case scan.Union, scan.Struct:
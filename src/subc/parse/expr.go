@@ -199,6 +199,7 @@ func (p *parser) cast() ast.Expr {
  *	| - cast
  *	| ~ cast
  *	| ! cast
+ *	| && IDENT
  *	| SIZEOF ( type )
  *	| SIZEOF ( type * )
  *	| SIZEOF ( type * * )
@@ -238,6 +239,21 @@ func (p *parser) prefix() ast.Expr {
 			X:     n,
 		}
 
+	case scan.Land:
+		// &&label is the address of a label, a GNU extension
+		p.next()
+		if p.conf.ANSI {
+			p.errorf(tok.Pos, "the address of a label is a GNU extension")
+		}
+		if p.curFn == nil {
+			p.errorf(tok.Pos, "address of a label outside of a function")
+		}
+		return &ast.UnaryExpr{
+			Op:    tok,
+			Affix: ast.Prefix,
+			X:     p.expectIdent(),
+		}
+
 	case scan.Sizeof:
 		n := &ast.SizeofExpr{}
 		n.Sizeof = p.next()
@@ -369,6 +385,7 @@ func (p *parser) fnArgs(c *ast.CallExpr) {
  *	| INTLIT
  *	| string
  *	| ( expr )
+ *	| ( compound )
  *
  * string :=
  *	  STRLIT
@@ -398,6 +415,9 @@ func (p *parser) primary() ast.Expr {
 		return n
 
 	case scan.Lparen:
+		if p.peek().Type == scan.Lbrace {
+			return p.stmtExpr(tok)
+		}
 		n := &ast.ParenExpr{}
 		n.Lparen = tok
 		n.X = p.expr()
@@ -411,6 +431,22 @@ func (p *parser) primary() ast.Expr {
 	}
 }
 
+// stmtExpr parses a statement expression, a GNU extension.
+func (p *parser) stmtExpr(lparen scan.Token) ast.Expr {
+	if p.conf.ANSI {
+		p.errorf(lparen.Pos, "statement expressions are a GNU extension")
+	}
+	if p.curFn == nil {
+		p.errorf(lparen.Pos, "statement expression outside of a function")
+	}
+	n := &ast.StmtExpr{Lparen: lparen}
+	lbrace := p.next()
+	n.Decls = p.localDecls()
+	n.Body = p.compound(&lbrace)
+	n.Rparen = p.expect(scan.Rparen)
+	return n
+}
+
 /*
  * asgmnt :=
  *	  condexpr
@@ -12,10 +12,13 @@ import (
 type Config struct {
 	MaxErrors int  // the number of errors before bailing out, if it is 0 or less then it will capture all errors
 	Predecl   bool // inject pre-identified values into the parser while parsing
+	ANSI      bool // the GNU extensions, like case ranges, are errors
 	recursive bool // the parser is calling itself recursively, a flag to stop it from doing infinite recursion
 }
 
 // Parse parses a stream of token and builds an AST tree out of it.
+// The errors and warnings are a *scan.ErrorList, scan.DiagnosticsOf
+// returns them as diagnostics.
 func Parse(conf Config, scanner scan.Interface) (prog *ast.Prog, err error) {
 	p := &parser{
 		conf:    conf,
@@ -44,6 +47,7 @@ func Parse(conf Config, scanner scan.Interface) (prog *ast.Prog, err error) {
 			prog.Decls = append(prog.Decls, decls...)
 		}
 	}
+	p.redefineExtnames(prog)
 
 	return
 }
@@ -61,6 +65,11 @@ type parser struct {
 	curFn *ast.FuncDecl // current function we are parsing
 
 	errors scan.ErrorList // errors during parsing
+
+	pack  int64   // the packing of the structs given by #pragma pack, 0 if none
+	packs []int64 // the packings saved by #pragma pack(push)
+
+	extnames map[string]scan.Token // the symbols given by #pragma redefine_extname, as string literals
 }
 
 // scan gets the next token, ignoring any comment and preprocessor tokens.
@@ -68,7 +77,9 @@ func (p *parser) scan() scan.Token {
 	for {
 		tok := p.scanner.Scan()
 		switch tok.Type {
-		case scan.Comment, scan.Preprocessor, scan.Pragma:
+		case scan.Comment, scan.Preprocessor:
+		case scan.Pragma:
+			p.pragma(tok)
 		case scan.Warning:
 			p.warnf(tok.Pos, "%v", tok.Text)
 		case scan.Error:
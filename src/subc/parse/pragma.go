@@ -0,0 +1,138 @@
+package parse
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"subc/ast"
+	"subc/scan"
+)
+
+// MaxPack is the largest alignment #pragma pack can give.
+const MaxPack = 16
+
+// pragma handles the #pragma directives that the parser understands,
+// the others are ignored as they always were.
+func (p *parser) pragma(tok scan.Token) {
+	text := strings.TrimSpace(tok.Text)
+	name := text
+	if i := strings.IndexAny(text, " \t("); i >= 0 {
+		name = text[:i]
+	}
+	switch name {
+	case "pack":
+	case "redefine_extname":
+		p.extnamePragma(tok, strings.Fields(text[len(name):]))
+		return
+	default:
+		return
+	}
+	text = strings.TrimSpace(text[len(name):])
+	if !strings.HasPrefix(text, "(") || !strings.HasSuffix(text, ")") {
+		p.warnf(tok.Pos, "malformed #pragma pack, ignored")
+		return
+	}
+	if !p.packPragma(strings.Split(text[1:len(text)-1], ",")) {
+		p.warnf(tok.Pos, "malformed #pragma pack, ignored")
+	}
+}
+
+// packPragma sets the packing of the structs that follow from the
+// arguments of a #pragma pack:
+//
+//	pack()          the default packing
+//	pack(n)         the fields are aligned at most to n bytes
+//	pack(push)      the packing is saved
+//	pack(push, n)   the packing is saved and set to n
+//	pack(pop)       the packing saved last is restored
+//
+// It reports whether the arguments are understood.
+func (p *parser) packPragma(args []string) bool {
+	for i := range args {
+		args[i] = strings.TrimSpace(args[i])
+	}
+	switch {
+	case len(args) == 1 && args[0] == "":
+		p.pack = 0
+	case len(args) == 1 && args[0] == "push":
+		p.packs = append(p.packs, p.pack)
+	case len(args) == 2 && args[0] == "push":
+		n, ok := packArg(args[1])
+		if !ok {
+			return false
+		}
+		p.packs = append(p.packs, p.pack)
+		p.pack = n
+	case len(args) == 1 && args[0] == "pop":
+		if len(p.packs) == 0 {
+			p.pack = 0
+			break
+		}
+		p.pack = p.packs[len(p.packs)-1]
+		p.packs = p.packs[:len(p.packs)-1]
+	case len(args) == 1:
+		n, ok := packArg(args[0])
+		if !ok {
+			return false
+		}
+		p.pack = n
+	default:
+		return false
+	}
+	return true
+}
+
+// extnamePragma records the symbol of a #pragma redefine_extname old new,
+// which renames the symbol of the function or the variable old to new as
+// an asm label would.
+func (p *parser) extnamePragma(tok scan.Token, args []string) {
+	if len(args) != 2 || !isName(args[0]) || !isName(args[1]) {
+		p.warnf(tok.Pos, "malformed #pragma redefine_extname, ignored")
+		return
+	}
+	if p.extnames == nil {
+		p.extnames = make(map[string]scan.Token)
+	}
+	p.extnames[args[0]] = scan.Token{Type: scan.String, Text: strconv.Quote(args[1]), Pos: tok.Pos}
+}
+
+// isName reports whether s is an identifier.
+func isName(s string) bool {
+	for i, r := range s {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// redefineExtnames gives the declarations that #pragma redefine_extname
+// renames the asm label of the new name, unless they have one already.
+// The pragma renames the declarations before it too.
+func (p *parser) redefineExtnames(prog *ast.Prog) {
+	if len(p.extnames) == 0 {
+		return
+	}
+	for _, d := range prog.Decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			if tok, found := p.extnames[d.Name.Name]; found && d.Asm == nil {
+				d.Asm = &ast.StringLit{Lits: []*ast.BasicLit{{Token: tok}}}
+			}
+		case *ast.VarDecl:
+			if tok, found := p.extnames[d.Name.Name]; found && d.Asm == nil {
+				d.Asm = &ast.StringLit{Lits: []*ast.BasicLit{{Token: tok}}}
+			}
+		}
+	}
+}
+
+// packArg parses the alignment of a #pragma pack, a power of 2 up to MaxPack.
+func packArg(s string) (int64, bool) {
+	n, err := strconv.ParseInt(s, 0, 64)
+	if err != nil || n < 1 || n > MaxPack || n&(n-1) != 0 {
+		return 0, false
+	}
+	return n, true
+}
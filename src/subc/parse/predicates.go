@@ -26,7 +26,7 @@ func isType(tok scan.Type) bool {
 func isLocalType(tok scan.Type) bool {
 	switch tok {
 	case scan.Auto, scan.Extern, scan.Register, scan.Static,
-		scan.Volatile, scan.Restrict, scan.Int, scan.Char, scan.Short, scan.Long,
+		scan.Const, scan.Volatile, scan.Restrict, scan.Int, scan.Char, scan.Short, scan.Long,
 		scan.Float, scan.Double, scan.Bool, scan.Complex, scan.Void, scan.Enum,
 		scan.Struct, scan.Union:
 		return true
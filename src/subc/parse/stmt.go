@@ -158,13 +158,23 @@ func (p *parser) forStmt() *ast.ForStmt {
 }
 
 /*
- * goto_stmt := GOTO ident ;
+ * goto_stmt :=
+ *	  GOTO ident ;
+ *	| GOTO * expr ;
  */
 
 func (p *parser) gotoStmt() *ast.GotoStmt {
 	s := &ast.GotoStmt{}
 	s.Goto = p.next()
-	s.Label = p.expectIdent()
+	if tok := p.peek(); tok.Type == scan.Mul {
+		if p.conf.ANSI {
+			p.errorf(tok.Pos, "computed gotos are a GNU extension")
+		}
+		p.next()
+		s.X = p.expr()
+	} else {
+		s.Label = p.expectIdent()
+	}
 	p.expect(scan.Semi)
 	return s
 }
@@ -270,6 +280,14 @@ func (p *parser) switchBlock(s *ast.SwitchStmt) {
 			c.Case = p.next()
 			if tok.Type != scan.Default {
 				c.Value = p.constExpr()
+				if tok := p.peek(); tok.Type == scan.Ellipsis {
+					ellipsis := p.next()
+					if p.conf.ANSI {
+						p.errorf(ellipsis.Pos, "case ranges are a GNU extension")
+					}
+					c.Ellipsis = &ellipsis
+					c.High = p.constExpr()
+				}
 			}
 			c.Colon = p.expect(scan.Colon)
 			s.Body.Stmt = append(s.Body.Stmt, c)
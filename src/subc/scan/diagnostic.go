@@ -0,0 +1,160 @@
+package scan
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/scanner"
+)
+
+// Severity is how serious a diagnostic is.
+type Severity int
+
+const (
+	ErrorSeverity Severity = iota
+	WarningSeverity
+)
+
+func (s Severity) String() string {
+	switch s {
+	case ErrorSeverity:
+		return "error"
+	case WarningSeverity:
+		return "warning"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// Diagnostic is an error or a warning of a compile, with the
+// notes that point at the code related to it.
+type Diagnostic struct {
+	Severity Severity
+	Pos      scanner.Position // the position the diagnostic is about, invalid if it is not about the source
+	Message  string
+	Notes    []Note
+}
+
+// Note is a message that explains a diagnostic at another position,
+// like the other declaration of a symbol declared twice.
+type Note struct {
+	Pos     scanner.Position
+	Message string
+}
+
+// Error formats the diagnostic with its notes on the lines that follow,
+// the way the compiler prints it.
+func (d Diagnostic) Error() string {
+	var b strings.Builder
+	if d.Pos.IsValid() {
+		fmt.Fprintf(&b, "%v: ", d.Pos)
+	}
+	fmt.Fprintf(&b, "%v: %v", d.Severity, d.Message)
+	for _, n := range d.Notes {
+		fmt.Fprintf(&b, "\n%v: note: %v", n.Pos, n.Message)
+	}
+	return b.String()
+}
+
+// Diagnostics is the list of diagnostics of a compile, in the
+// order they were reported.
+type Diagnostics []Diagnostic
+
+// Filter returns the diagnostics that keep reports true for.
+func (l Diagnostics) Filter(keep func(d Diagnostic) bool) Diagnostics {
+	var r Diagnostics
+	for _, d := range l {
+		if keep(d) {
+			r = append(r, d)
+		}
+	}
+	return r
+}
+
+// Errors returns the diagnostics that are errors.
+func (l Diagnostics) Errors() Diagnostics {
+	return l.Filter(func(d Diagnostic) bool { return d.Severity == ErrorSeverity })
+}
+
+// Warnings returns the diagnostics that are warnings.
+func (l Diagnostics) Warnings() Diagnostics {
+	return l.Filter(func(d Diagnostic) bool { return d.Severity == WarningSeverity })
+}
+
+// Diagnostics returns the messages of the list as diagnostics, the
+// messages indented by a tab are the notes of the message before them.
+func (l *ErrorList) Diagnostics() Diagnostics {
+	var r Diagnostics
+	for _, m := range l.Messages {
+		if strings.HasPrefix(m.Text, "\t") && len(r) > 0 {
+			d := &r[len(r)-1]
+			d.Notes = append(d.Notes, Note{m.Pos, strings.TrimLeft(m.Text, "\t")})
+			continue
+		}
+		severity := ErrorSeverity
+		if m.Warning {
+			severity = WarningSeverity
+		}
+		r = append(r, Diagnostic{Severity: severity, Pos: m.Pos, Message: strings.TrimLeft(m.Text, "\t")})
+	}
+	return r
+}
+
+// DiagnosticsOf returns the diagnostics of an error returned by the
+// parser, the type checker or the compiler. An error that is not a
+// list of messages, like a failure to read a file, is one error that
+// has no position.
+func DiagnosticsOf(err error) Diagnostics {
+	if err == nil {
+		return nil
+	}
+	var l *ErrorList
+	if errors.As(err, &l) {
+		return l.Diagnostics()
+	}
+	var m ErrorMessage
+	if errors.As(err, &m) {
+		l = new(ErrorList)
+		l.Add(m)
+		return l.Diagnostics()
+	}
+	return Diagnostics{{Severity: ErrorSeverity, Message: err.Error()}}
+}
+
+// DiagnosticSink receives the diagnostics of compiles as they are
+// reported, instead of the compiles printing them. Report may be called
+// from more than one goroutine at a time and takes each diagnostic
+// whole, so the ones of compiles running side by side don't interleave.
+type DiagnosticSink interface {
+	Report(d Diagnostic)
+}
+
+// WriterSink is a DiagnosticSink that writes the diagnostics to a
+// writer, each one as Error formats it on lines of its own.
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterSink returns a sink that writes the diagnostics to w.
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+// Report writes d with one write to the writer, the writes of the
+// diagnostics reported at the same time are made one after the other.
+func (s *WriterSink) Report(d Diagnostic) {
+	text := d.Error() + "\n"
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	io.WriteString(s.w, text)
+}
+
+// ReportError reports the diagnostics of err to sink, as DiagnosticsOf
+// gives them.
+func ReportError(sink DiagnosticSink, err error) {
+	for _, d := range DiagnosticsOf(err) {
+		sink.Report(d)
+	}
+}
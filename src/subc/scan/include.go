@@ -0,0 +1,19 @@
+package scan
+
+import (
+	"text/scanner"
+)
+
+// IncludeEvent describes an #include directive that was resolved to a file.
+type IncludeEvent struct {
+	Pos  scanner.Position // position of the directive in the including file
+	Name string           // the name as written in the directive, with quotes or brackets
+	File string           // the path of the included file
+}
+
+// includeEvent reports an include event to the configured hook, if any.
+func (l *Scanner) includeEvent(name, file string) {
+	if l.conf.IncludeHook != nil {
+		l.conf.IncludeHook(IncludeEvent{l.pos(), name, file})
+	}
+}
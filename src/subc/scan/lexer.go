@@ -0,0 +1,65 @@
+package scan
+
+import "text/scanner"
+
+// Lexeme is a token that NextToken returns, with the comments that come
+// before it.
+type Lexeme struct {
+	Token
+	Comments []Token // the comments between the token before and this one, in order
+}
+
+// Lexer returns the tokens of a source as the compiler scans them, with
+// their comments, for the tools that work on the text of a program, like
+// syntax highlighters and formatters, to tokenize it the same way.
+type Lexer struct {
+	s   *Scanner
+	eof *Lexeme          // the EOF token once it is returned
+	end scanner.Position // the end of the last token or comment
+}
+
+// NewLexer returns a lexer of the source r named name. The comments are
+// scanned whatever conf says. With conf.ApplyPreprocessor false the
+// directives are Preprocessor tokens of the text of their lines and the
+// macros aren't expanded, which keeps the tokens as they are written.
+// With it true they are the tokens after preprocessing, the ones the
+// parser takes, and the tokens of the macros have their Macro set.
+func NewLexer(conf Config, name string, r Reader) *Lexer {
+	conf.ScanComments = true
+	return &Lexer{s: New(conf, name, r)}
+}
+
+// NextToken returns the next token that isn't a comment with the comments
+// before it. The errors and the warnings of the scanner are tokens of
+// their own. At the end it returns the EOF token with the comments after
+// the last token, and then the EOF token again.
+func (l *Lexer) NextToken() Lexeme {
+	if l.eof != nil {
+		return Lexeme{Token: l.eof.Token}
+	}
+	var x Lexeme
+	for {
+		tok := l.s.Scan()
+		switch tok.Type {
+		case Comment:
+			x.Comments = append(x.Comments, tok)
+			l.end = tok.Span().End
+			continue
+		case EOF:
+			if !tok.Pos.IsValid() {
+				tok.Pos = l.end
+			}
+			x.Token = tok
+			l.eof = &x
+			return x
+		}
+		x.Token = tok
+		l.end = tok.Span().End
+		return x
+	}
+}
+
+// Close stops the scanner of the lexer and drains its tokens.
+func (l *Lexer) Close() error {
+	return l.s.Close()
+}
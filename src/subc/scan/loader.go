@@ -4,9 +4,11 @@ import (
 	"bufio"
 	"fmt"
 	"io"
-	"os"
+	"io/fs"
 	"strings"
 	"text/scanner"
+
+	"subc/vfs"
 )
 
 // Reader is an interface that provides input
@@ -24,17 +26,25 @@ type Loader interface {
 	Open(name string) (Reader, error)
 }
 
-// fileLoader is a loader that loads from the filesystem.
-type fileLoader struct{}
+// fsLoader is a loader that loads from a file system.
+type fsLoader struct {
+	fsys fs.FS
+}
+
+// FSLoader returns a loader that opens files from fsys.
+func FSLoader(fsys fs.FS) Loader {
+	return fsLoader{fsys}
+}
 
 type file struct {
 	*bufio.Reader
-	*os.File
+	io.Closer
+	name string
 }
 
 func (f *file) Pos() scanner.Position {
 	return scanner.Position{
-		Filename: f.Name(),
+		Filename: f.name,
 		Line:     1,
 		Column:   1,
 	}
@@ -42,8 +52,8 @@ func (f *file) Pos() scanner.Position {
 
 func (f *file) LockedPos() bool { return false }
 
-func (fileLoader) Open(name string) (Reader, error) {
-	f, err := os.Open(name)
+func (l fsLoader) Open(name string) (Reader, error) {
+	f, err := l.fsys.Open(name)
 	if err != nil {
 		return nil, err
 	}
@@ -54,7 +64,7 @@ func (fileLoader) Open(name string) (Reader, error) {
 		return nil, fmt.Errorf("%v: is a directory", name)
 	}
 
-	return &file{bufio.NewReader(f), f}, nil
+	return &file{bufio.NewReader(f), f, name}, nil
 }
 
 type stringReader struct {
@@ -80,6 +90,5 @@ func StringReader(pos scanner.Position, src string, lockedPos bool) Reader {
 
 // OpenFile returns a reader made from opening a file on the filesystem.
 func OpenFile(name string) (Reader, error) {
-	var l fileLoader
-	return l.Open(name)
+	return FSLoader(vfs.OS()).Open(name)
 }
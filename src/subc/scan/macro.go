@@ -0,0 +1,61 @@
+package scan
+
+import (
+	"text/scanner"
+)
+
+// MacroKind is the kind of preprocessor event reported for a macro.
+type MacroKind int
+
+const (
+	MacroDefine MacroKind = iota // macro was defined, either predefined or by #define
+	MacroUndef                   // macro was removed by #undef
+	MacroExpand                  // macro was expanded in the source
+)
+
+func (k MacroKind) String() string {
+	switch k {
+	case MacroDefine:
+		return "define"
+	case MacroUndef:
+		return "undef"
+	case MacroExpand:
+		return "expand"
+	}
+	return "unknown"
+}
+
+// MacroEvent describes something the preprocessor did with a macro.
+// Predefined macros are reported with an invalid position.
+type MacroEvent struct {
+	Kind MacroKind
+	Name string
+	Text string
+	Pos  scanner.Position
+}
+
+// Expansion is the expansion of a macro that the tokens of its text come
+// from, for the tools that map the tokens after preprocessing back to
+// the source. The tokens of the expansion have the position of the use
+// of the macro, in a file named after the one of the use with (macro)
+// added.
+type Expansion struct {
+	Name   string           // the macro used in the source
+	Pos    scanner.Position // where it is used
+	Macros []string         // the macros expanded for the tokens, Name and then the ones of its text in the order they were expanded
+}
+
+// macroEvent reports a macro event to the configured hook, if any.
+func (l *Scanner) macroEvent(kind MacroKind, name, text string, pos scanner.Position) {
+	if l.conf.MacroHook != nil {
+		l.conf.MacroHook(MacroEvent{kind, name, text, pos})
+	}
+}
+
+// pos returns the position of the token currently being scanned.
+func (l *Scanner) pos() scanner.Position {
+	if l.r.LockedPos() {
+		return l.r.Pos()
+	}
+	return l.emitPos
+}
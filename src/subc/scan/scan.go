@@ -8,12 +8,15 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"runtime/debug"
 	"strconv"
 	"strings"
 	"text/scanner"
 	"time"
 	"unicode"
 	"unicode/utf8"
+
+	"subc/vfs"
 )
 
 // Interface defines an interface for scanning.
@@ -24,14 +27,18 @@ type Interface interface {
 
 // Config specifies the behavior of the scanner.
 type Config struct {
-	ApplyPreprocessor bool        // applies the preprocessor upon encountering macros
-	Loader            Loader      // the loader interface is used for loading include files
-	MaxIncludes       int         // max number of nested includes during macro expansion before aborting
-	IncludePaths      []string    // paths to look for include files
-	Macros            [][2]string // macro definitions in the form of (macro, text expansion)
-	ScanComments      bool        // scan comments as tokens when turned on, otherwise it is ignored
-	scanRaw           bool        // during scanning, used to tell the scanner not to expand anything, for internal processing of macros
-	expandingMacro    bool        // enabled when the scanner is expanding the macro
+	ApplyPreprocessor bool               // applies the preprocessor upon encountering macros
+	Loader            Loader             // the loader interface is used for loading include files
+	MaxIncludes       int                // max number of nested includes during macro expansion before aborting
+	IncludePaths      []string           // paths to look for include files
+	Macros            [][2]string        // macro definitions in the form of (macro, text expansion)
+	MacroHook         func(MacroEvent)   // if set, called whenever a macro is defined, undefined or expanded
+	IncludeHook       func(IncludeEvent) // if set, called whenever an include file is entered
+	ScanComments      bool               // scan comments as tokens when turned on, otherwise it is ignored
+	Trigraphs         bool               // replace trigraphs and digraphs, otherwise they are diagnosed with a warning
+	ANSI              bool               // the GNU extensions, like binary constants, are errors
+	scanRaw           bool               // during scanning, used to tell the scanner not to expand anything, for internal processing of macros
+	expandingMacro    bool               // enabled when the scanner is expanding the macro
 }
 
 // DefaultConfig specifies a reasonable default for the scanner.
@@ -39,7 +46,7 @@ type Config struct {
 // then customizing some of the fields.
 var DefaultConfig = Config{
 	ApplyPreprocessor: true,
-	Loader:            fileLoader{},
+	Loader:            FSLoader(vfs.OS()),
 	MaxIncludes:       16,
 	Macros: [][2]string{
 		{"__SUBC__", ""},
@@ -76,9 +83,22 @@ type Scanner struct {
 	emitPos       scanner.Position
 	state         stateFn
 	macros        map[string]string
+	params        map[string][]string // the parameters of the function-like macros
 	peekDirective bool
 	directive     bool
 	counter       uint64
+	crash         *Panic // the panic that stopped the scanner
+}
+
+// Panic is a panic of the goroutine of a scanner, Scan raises it
+// again so the user of the scanner can recover from it.
+type Panic struct {
+	Value interface{}
+	Stack []byte // the stack of the scanner when it panicked
+}
+
+func (p *Panic) Error() string {
+	return fmt.Sprint(p.Value)
 }
 
 // Special sentinels for the scanner
@@ -118,10 +138,12 @@ func New(conf Config, name string, r Reader) *Scanner {
 		r:         newReader(name, r, "."),
 		peekch:    notPeeked,
 		macros:    make(map[string]string),
+		params:    make(map[string][]string),
 		directive: !conf.expandingMacro,
 	}
 	for _, m := range conf.Macros {
 		l.macros[m[0]] = m[1]
+		l.macroEvent(MacroDefine, m[0], m[1], NoPos)
 	}
 
 	if conf.Macros != nil {
@@ -137,9 +159,14 @@ func New(conf Config, name string, r Reader) *Scanner {
 	return l
 }
 
-// Scan reads the next token from the channel.
+// Scan reads the next token from the channel, it panics with
+// a *Panic if the scanner panicked.
 func (l *Scanner) Scan() Token {
-	return <-l.Tokens
+	tok, ok := <-l.Tokens
+	if !ok && l.crash != nil {
+		panic(l.crash)
+	}
+	return tok
 }
 
 // Close drains the tokens from the channel, it is used for cleaning up.
@@ -172,7 +199,7 @@ func (l *Scanner) emitError(typ Type, force bool, format string, args ...interfa
 	if l.r.LockedPos() {
 		pos = l.r.Pos()
 	}
-	l.Tokens <- Token{typ, pos, fmt.Sprintf(format, args...)}
+	l.Tokens <- Token{Type: typ, Pos: pos, Text: fmt.Sprintf(format, args...)}
 	return lexAny
 }
 
@@ -192,8 +219,13 @@ func (l *Scanner) emit(typ Type, text string) {
 
 // emitp emits a token down a channel if we are not in a disabled macro expansion.
 func (l *Scanner) emitp(typ Type, pos scanner.Position, text string) {
+	l.emitm(typ, pos, text, nil)
+}
+
+// emitm emits a token of the expansion of a macro x, like emitp.
+func (l *Scanner) emitm(typ Type, pos scanner.Position, text string, x *Expansion) {
 	if !l.frozen(1) {
-		l.Tokens <- Token{typ, pos, text}
+		l.Tokens <- Token{typ, pos, text, x}
 	}
 }
 
@@ -221,6 +253,7 @@ func (l *Scanner) loadLine() {
 			break
 		}
 	}
+	l.buf = l.replaceTrigraphs(l.buf)
 	l.input += string(l.buf)
 }
 
@@ -278,6 +311,12 @@ func (l *Scanner) peek() rune {
 
 // run runs the scanner state machine
 func (l *Scanner) run() {
+	defer func() {
+		if e := recover(); e != nil {
+			l.crash = &Panic{e, debug.Stack()}
+			close(l.Tokens)
+		}
+	}()
 	for l.state = lexAny; l.state != nil; {
 		l.state = l.state(l)
 	}
@@ -306,10 +345,17 @@ func (l *Scanner) acceptRunFunc(f func(rune) bool) {
 // lexAny is the main state function that looks at the character and decides
 // what state to dispatch to scan the input.
 func lexAny(l *Scanner) stateFn {
+	directive := l.directive
 	r := l.next()
 	l.rbuf = l.rbuf[:0]
 	l.rbuf = append(l.rbuf, r)
 	l.emitPos = l.prevPos
+	if d, ok := l.digraph(r); ok {
+		r, l.rbuf[0] = d, d
+		if d == '#' {
+			l.directive = directive
+		}
+	}
 	switch {
 	case r == eof:
 		return lexNextReader
@@ -365,93 +411,123 @@ func lexSpace(l *Scanner) stateFn {
 func lexNumber(l *Scanner) stateFn {
 	const digits = "0123456789abcdefABCDEF"
 
-	l.acceptRun(digits[:10])
-	s := string(l.rbuf)
-
-	l.rbuf = l.rbuf[:0]
 	l.acceptRunFunc(func(r rune) bool {
 		return unicode.IsLetter(r) || r == '_' || unicode.IsDigit(r)
 	})
+	s := string(l.rbuf)
 
-	if len(l.rbuf) > 0 {
-		var suffix string
-		hex := false
-		for i, r := range l.rbuf {
-			switch {
-			case i == 0 && (r == 'x' || r == 'X'):
-				hex = true
-				s += string(r)
-			case hex && strings.IndexRune(digits, r) >= 0:
-				s += string(r)
-			default:
-				suffix += string(r)
-			}
-		}
+	// 0b is an extension for binary constants
+	base, prefix, name := 10, "", "decimal"
+	switch {
+	case strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X"):
+		base, prefix, name = 16, s[:2], "hexadecimal"
+	case strings.HasPrefix(s, "0b") || strings.HasPrefix(s, "0B"):
+		base, prefix, name = 2, s[:2], "binary"
+	case s[0] == '0':
+		base, name = 8, "octal"
+	}
 
-		if r := s[len(s)-1]; r == 'x' || r == 'X' {
-			s = s[:len(s)-1]
-			suffix = string(r) + suffix
-		}
+	valid := digits[:10]
+	if base == 16 {
+		valid = digits
+	}
+	n := len(prefix)
+	for n < len(s) && strings.IndexByte(valid, s[n]) >= 0 {
+		n++
+	}
+	num, suffix := s[:n], s[n:]
+	if num == prefix && prefix != "" {
+		num, suffix, prefix = s[:1], s[1:], ""
+	}
 
-		if len(suffix) > 0 {
-			return l.errorf("invalid suffix %q on integer constant: %q", suffix, s)
+	if base == 2 && l.conf.ANSI {
+		// the constant is still scanned so the parse goes on
+		l.errorf("binary constant %q is a GNU extension", num)
+	}
+	for _, r := range num[len(prefix):] {
+		if base < 10 && r-'0' >= rune(base) {
+			return l.errorf("invalid digit %q in %s constant: %q", r, name, num)
 		}
 	}
 
+	unsigned, ok := integerSuffix(suffix)
+	if !ok {
+		return l.errorf("invalid suffix %q on integer constant: %q", suffix, num)
+	}
+	if unsigned {
+		l.pwarnf(false, "unsigned suffix on integer constant %q ignored, there are no unsigned types", s)
+	}
+
 	l.emit(Number, s)
 	return lexAny
 }
 
-// lexWord scans keywords.
-func lexWord(l *Scanner) stateFn {
-	var keywords = map[string]Type{
-		"_Alignas":       Alignas,
-		"_Alignof":       Alignof,
-		"_Atomic":        Atomic,
-		"_Bool":          Bool,
-		"_Complex":       Complex,
-		"_Generic":       Generic,
-		"_Imaginary":     Imaginary,
-		"_Noreturn":      Noreturn,
-		"_Static_assert": Static_assert,
-		"_Thread_local":  Thread_local,
-
-		"auto":     Auto,
-		"break":    Break,
-		"case":     Case,
-		"char":     Char,
-		"const":    Const,
-		"continue": Continue,
-		"default":  Default,
-		"do":       Do,
-		"double":   Double,
-		"else":     Else,
-		"enum":     Enum,
-		"extern":   Extern,
-		"float":    Float,
-		"for":      For,
-		"goto":     Goto,
-		"if":       If,
-		"inline":   Inline,
-		"int":      Int,
-		"long":     Long,
-		"register": Register,
-		"restrict": Restrict,
-		"return":   Return,
-		"short":    Short,
-		"signed":   Signed,
-		"sizeof":   Sizeof,
-		"static":   Static,
-		"struct":   Struct,
-		"switch":   Switch,
-		"typedef":  Typedef,
-		"union":    Union,
-		"unsigned": Unsigned,
-		"void":     Void,
-		"volatile": Volatile,
-		"while":    While,
+// integerSuffix checks that s is one of the integer constant suffixes
+// U, L, UL, LU, LL, ULL and LLU in either case and returns if it has an U.
+func integerSuffix(s string) (unsigned, ok bool) {
+	if strings.HasSuffix(s, "u") || strings.HasSuffix(s, "U") {
+		s, unsigned = s[:len(s)-1], true
+	} else if strings.HasPrefix(s, "u") || strings.HasPrefix(s, "U") {
+		s, unsigned = s[1:], true
+	}
+	switch s {
+	case "", "l", "L", "ll", "LL":
+		return unsigned, true
 	}
+	return unsigned, false
+}
 
+// keywords are the token types of the keywords by their text.
+var keywords = map[string]Type{
+	"_Alignas":       Alignas,
+	"_Alignof":       Alignof,
+	"_Atomic":        Atomic,
+	"_Bool":          Bool,
+	"_Complex":       Complex,
+	"_Generic":       Generic,
+	"_Imaginary":     Imaginary,
+	"_Noreturn":      Noreturn,
+	"_Static_assert": Static_assert,
+	"_Thread_local":  Thread_local,
+
+	"auto":     Auto,
+	"break":    Break,
+	"case":     Case,
+	"char":     Char,
+	"const":    Const,
+	"continue": Continue,
+	"default":  Default,
+	"do":       Do,
+	"double":   Double,
+	"else":     Else,
+	"enum":     Enum,
+	"extern":   Extern,
+	"float":    Float,
+	"for":      For,
+	"goto":     Goto,
+	"if":       If,
+	"inline":   Inline,
+	"int":      Int,
+	"long":     Long,
+	"register": Register,
+	"restrict": Restrict,
+	"return":   Return,
+	"short":    Short,
+	"signed":   Signed,
+	"sizeof":   Sizeof,
+	"static":   Static,
+	"struct":   Struct,
+	"switch":   Switch,
+	"typedef":  Typedef,
+	"union":    Union,
+	"unsigned": Unsigned,
+	"void":     Void,
+	"volatile": Volatile,
+	"while":    While,
+}
+
+// lexWord scans keywords.
+func lexWord(l *Scanner) stateFn {
 	l.acceptRunFunc(func(r rune) bool {
 		return r == '_' || unicode.IsLetter(r)
 	})
@@ -460,6 +536,9 @@ func lexWord(l *Scanner) stateFn {
 	})
 
 	s := string(l.rbuf)
+	if s == "L" && l.peek() == '\'' && !l.conf.scanRaw {
+		return lexWideRune
+	}
 	if l.expandMacro(s) {
 		return lexAny
 	}
@@ -478,15 +557,26 @@ func (l *Scanner) expandMacro(macro string) bool {
 	if _, found := l.macros[macro]; !found {
 		return false
 	}
+	if _, found := l.params[macro]; found {
+		args, ok := l.scanArgs()
+		if !ok {
+			return false
+		}
+		macro += args
+	}
 
-	switch macro {
+	name := macro
+	if i := strings.IndexByte(name, '('); i >= 0 {
+		name = name[:i]
+	}
+	switch name {
 	case "__LINE__":
-		l.macros[macro] = fmt.Sprint(l.r.Line)
+		l.macros[name] = fmt.Sprint(l.r.Line)
 	case "__COUNTER__":
 		if l.counter == math.MaxUint64 {
 			l.errorf("__COUNTER__ overflowed")
 		}
-		l.macros[macro] = fmt.Sprint(l.counter)
+		l.macros[name] = fmt.Sprint(l.counter)
 		if !l.frozen(1) {
 			l.counter++
 		}
@@ -497,18 +587,40 @@ func (l *Scanner) expandMacro(macro string) bool {
 	c.ApplyPreprocessor = false
 	c.Macros = nil
 	c.scanRaw = true
-	p := New(c, "", StringReader(scanner.Position{}, macro, false))
+	exp := &Expansion{Name: name, Pos: l.pos()}
+	macro = l.expandText(c, macro, make(map[string]bool), exp)
+
+	// We started a separate scanner while expanding the text, now feed back
+	// the output to our scanner.
+	c.expandingMacro = true
+	c.scanRaw = false
+	p := New(c, fmt.Sprintf("%v (macro)", l.r.Filename), StringReader(scanner.Position{}, macro, false))
+	for t := range p.Tokens {
+		pos := l.emitPos
+		pos.Filename = t.Pos.Filename
+		l.emitm(t.Type, pos, t.Text, exp)
+	}
 
+	return true
+}
+
+// expandText expands the macros in text, the ones in seen are left
+// alone.
+func (l *Scanner) expandText(c Config, text string, seen map[string]bool, exp *Expansion) string {
 	// Whenever we expand a macro, we will mark it "blue"
 	// to prevent infinite expansion. The expansion
 	// will continue until it reaches a fixed point,
 	// then we know it is a the text after expansion has stopped.
+	// The macros are marked once a pass is done, so one can be
+	// used more than once in the text of another.
 	var x []Token
-	seen := make(map[string]bool)
 	for {
 		fixed := true
 		x = x[:0]
-		for t := range p.Tokens {
+		in := rawTokens(c, "", text)
+		blue := make(map[string]bool)
+		for i := 0; i < len(in); i++ {
+			t := in[i]
 			if !isIdent(t.Text) {
 				x = append(x, t)
 				continue
@@ -519,38 +631,163 @@ func (l *Scanner) expandMacro(macro string) bool {
 				x = append(x, t)
 				continue
 			}
+			params, funcLike := l.params[t.Text]
+			var args []string
+			if funcLike {
+				var n int
+				if args, n = macroArgs(in[i+1:]); n == 0 {
+					x = append(x, t)
+					continue
+				}
+				i += n
+			}
 
 			fixed = false
-			seen[t.Text] = true
+			blue[t.Text] = true
+			exp.Macros = append(exp.Macros, t.Text)
+			if !l.frozen(1) {
+				l.macroEvent(MacroExpand, t.Text, s, l.pos())
+			}
 
-			q := New(c, "(macro)", StringReader(scanner.Position{}, s, false))
-			for t := range q.Tokens {
-				x = append(x, t)
+			if funcLike {
+				// The arguments are expanded on their own before
+				// they replace the parameters, as they can use the
+				// macro that they are the arguments of.
+				if len(params) == 0 && len(args) == 1 && args[0] == "" {
+					args = nil
+				}
+				if len(args) != len(params) {
+					l.errorf("macro %q takes %d arguments, got %d", t.Text, len(params), len(args))
+				}
+				for j := range args {
+					painted := make(map[string]bool)
+					for name := range seen {
+						painted[name] = true
+					}
+					args[j] = l.expandText(c, args[j], painted, exp)
+				}
+				s = substitute(c, s, params, args)
 			}
+			x = append(x, rawTokens(c, "(macro)", s)...)
+		}
+		for name := range blue {
+			seen[name] = true
 		}
 
-		macro = ""
+		text = ""
 		for _, t := range x {
-			macro += t.Text + " "
+			text += t.Text + " "
 		}
 		if fixed {
-			break
+			return text
 		}
-		p = New(c, "", StringReader(scanner.Position{}, macro, false))
 	}
+}
 
-	// We started a separate scanner while expanding the text, now feed back
-	// the output to our scanner.
-	c.expandingMacro = true
-	c.scanRaw = false
-	p = New(c, fmt.Sprintf("%v (macro)", l.r.Filename), StringReader(scanner.Position{}, macro, false))
-	for t := range p.Tokens {
-		pos := l.emitPos
-		pos.Filename = t.Pos.Filename
-		l.emitp(t.Type, pos, t.Text)
+// scanArgs scans the arguments of the use of a function-like macro,
+// with the parentheses around them. It returns false if the name of
+// the macro isn't followed by a (, when it is an identifier.
+func (l *Scanner) scanArgs() (string, bool) {
+	for r := l.peek(); r == ' ' || r == '\t' || r == '\r' || r == '\n'; r = l.peek() {
+		l.next()
+	}
+	if l.peek() != '(' {
+		return "", false
 	}
 
-	return true
+	var b []rune
+	depth := 0
+	quote := rune(0)
+	for {
+		r := l.next()
+		switch {
+		case r == eof:
+			l.errorf("unterminated argument list of a macro")
+			return string(b), true
+		case quote != 0 && r == '\\':
+			b = append(b, r)
+			if r = l.next(); r == eof {
+				continue
+			}
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case r == '\n':
+			r = ' '
+		}
+		b = append(b, r)
+		if depth == 0 {
+			return string(b), true
+		}
+	}
+}
+
+// macroArgs splits the arguments in parentheses at the start of toks
+// at the commas outside of the nested parentheses. It returns the
+// number of tokens they take, 0 if toks doesn't start with them.
+func macroArgs(toks []Token) ([]string, int) {
+	if len(toks) == 0 || toks[0].Text != "(" {
+		return nil, 0
+	}
+	var args []string
+	arg := ""
+	depth := 0
+	for i, t := range toks {
+		switch t.Text {
+		case "(":
+			depth++
+			if depth == 1 {
+				continue
+			}
+		case ")":
+			depth--
+			if depth == 0 {
+				return append(args, strings.TrimSpace(arg)), i + 1
+			}
+		case ",":
+			if depth == 1 {
+				args = append(args, strings.TrimSpace(arg))
+				arg = ""
+				continue
+			}
+		}
+		arg += t.Text + " "
+	}
+	return nil, 0
+}
+
+// substitute replaces the parameters of a function-like macro in its
+// text s with the arguments of a use.
+func substitute(c Config, s string, params, args []string) string {
+	text := ""
+	for _, t := range rawTokens(c, "", s) {
+		for i, p := range params {
+			if t.Text == p && i < len(args) {
+				t.Text = args[i]
+				break
+			}
+		}
+		text += t.Text + " "
+	}
+	return text
+}
+
+// rawTokens returns the tokens of text scanned without expanding any
+// macro.
+func rawTokens(c Config, name, text string) []Token {
+	var x []Token
+	p := New(c, name, StringReader(scanner.Position{}, text, false))
+	for t := range p.Tokens {
+		x = append(x, t)
+	}
+	return x
 }
 
 // scanRune scans rune and handle escape codes in runes if necessary.
@@ -644,34 +881,71 @@ func lexRune(l *Scanner) stateFn {
 	if l.conf.scanRaw {
 		return l.scanRaw(Rune, '\'')
 	}
+	return l.scanChar("")
+}
 
-	r, escapeChar, err := l.scanRune()
-	if err == nil && r == '\'' && !escapeChar {
-		return l.errorf("empty character sequence")
-	}
-	stray := false
-	s := ""
+// lexWideRune scans a wide character constant, the L prefix has been read.
+func lexWideRune(l *Scanner) stateFn {
+	l.next()
+	return l.scanChar("L")
+}
+
+// maxRuneChars is the most characters a multi-character constant can have,
+// it is the size of an int on the smallest target.
+const maxRuneChars = 4
+
+// scanChar scans the characters of a character constant up to the
+// terminating quote. A constant with more than one character is
+// an int with the characters packed in from the most significant byte
+// to the least, as in 'ab' == 'a'<<8 | 'b', which does not depend on
+// the byte order of the target.
+func (l *Scanner) scanChar(prefix string) stateFn {
+	var (
+		chars []rune
+		err   error
+	)
 
 loop:
 	for {
-		switch r := l.next(); r {
+		switch l.peek() {
 		case '\'':
+			l.next()
 			break loop
 		case eof, '\n':
 			return l.errorf("missing terminating ' character")
-		default:
-			s += string(r)
-			stray = true
 		}
-	}
-	if stray {
-		return l.errorf("stray characters %q in character sequence", s)
+		r, _, xerr := l.scanRune()
+		if err == nil {
+			err = xerr
+		}
+		chars = append(chars, r)
 	}
 	if err != nil {
 		return l.errorf("%v", err)
 	}
 
-	l.emit(Rune, "'"+string(r)+"'")
+	switch {
+	case len(chars) == 0:
+		return l.errorf("empty character sequence")
+	case len(chars) == 1 && prefix == "":
+		l.emit(Rune, "'"+string(chars[0])+"'")
+		return lexAny
+	case len(chars) > 1 && prefix != "":
+		return l.errorf("multi-character wide character constant")
+	case len(chars) > maxRuneChars:
+		return l.errorf("character constant too long")
+	case len(chars) > 1:
+		l.pwarnf(false, "multi-character character constant")
+	}
+
+	// The text is quoted so the value can be unquoted without
+	// confusing an escaped character with a literal backslash.
+	s := ""
+	for _, r := range chars {
+		q := strconv.QuoteRune(r)
+		s += q[1 : len(q)-1]
+	}
+	l.emit(Rune, prefix+"'"+s+"'")
 	return lexAny
 }
 
@@ -954,7 +1228,8 @@ func lexPreprocessor(l *Scanner) stateFn {
 	return lexAny
 }
 
-// defineDirective handles #define directives.
+// defineDirective handles #define directives. A ( right after the
+// name starts the parameters of a function-like macro.
 func (l *Scanner) defineDirective(p *Scanner) {
 	if l.frozen(1) {
 		return
@@ -966,20 +1241,60 @@ func (l *Scanner) defineDirective(p *Scanner) {
 		return
 	}
 
+	var params []string
+	funcLike := false
 	s := ""
-	for t := range p.Tokens {
-		s += t.Text + " "
+	for u := range p.Tokens {
+		if s == "" && !funcLike && u.Text == "(" && u.Pos.Offset == t.Pos.Offset+len(t.Text) {
+			if params, funcLike = l.defineParams(p); !funcLike {
+				return
+			}
+			continue
+		}
+		s += u.Text + " "
 	}
 	if s != "" {
 		s = s[:len(s)-1]
 	}
 
-	if r, ok := l.macros[t.Text]; ok && r != s {
+	r, ok := l.macros[t.Text]
+	q, wasFuncLike := l.params[t.Text]
+	if ok && (r != s || wasFuncLike != funcLike || strings.Join(q, ",") != strings.Join(params, ",")) {
 		l.errorf("#define: %q redefined", t.Text)
 		return
 	}
 
 	l.macros[t.Text] = s
+	text := s
+	if funcLike {
+		l.params[t.Text] = params
+		text = "(" + strings.Join(params, ", ") + ") " + s
+	}
+	l.macroEvent(MacroDefine, t.Text, text, l.pos())
+}
+
+// defineParams scans the parameters of a function-like macro up to the
+// ), it returns false on an error.
+func (l *Scanner) defineParams(p *Scanner) ([]string, bool) {
+	var params []string
+	for {
+		t := <-p.Tokens
+		if t.Text == ")" && len(params) == 0 {
+			break
+		}
+		if !isIdent(t.Text) || t.Text == "" {
+			l.errorf("#define: expected parameter name, got %q", t.Text)
+			return nil, false
+		}
+		params = append(params, t.Text)
+		if t = <-p.Tokens; t.Text == ")" {
+			break
+		} else if t.Text != "," {
+			l.errorf("#define: expected , or ) after parameter, got %q", t.Text)
+			return nil, false
+		}
+	}
+	return params, true
 }
 
 // frozen tells if the scanner should discard the tokens it generates
@@ -1095,6 +1410,7 @@ func (l *Scanner) includeDirective(p *Scanner, line string) {
 				l.errorf("#include: max number of %v includes reached", l.conf.MaxIncludes)
 				return
 			}
+			l.includeEvent(xname, filename)
 			r := newReader(filename, f, filepath.Dir(filename))
 			l.s = append(l.s, r)
 			l.r = r
@@ -1134,7 +1450,7 @@ func (l *Scanner) lineDirective(p *Scanner, t Token) {
 		return
 	}
 
-	l.r.Line = line + 1
+	l.r.Line = line
 	l.peekPos.Line = l.r.Line
 
 	t = <-p.Tokens
@@ -1167,6 +1483,8 @@ func (l *Scanner) undefDirective(p *Scanner) {
 		return
 	}
 	delete(l.macros, t.Text)
+	delete(l.params, t.Text)
+	l.macroEvent(MacroUndef, t.Text, "", l.pos())
 }
 
 // lexPreprocessorText gets all the text from the preprocessor line.
@@ -19,9 +19,10 @@ var (
 )
 
 type Token struct {
-	Type Type
-	Pos  scanner.Position
-	Text string
+	Type  Type
+	Pos   scanner.Position
+	Text  string
+	Macro *Expansion // the expansion of a macro that the token comes from, nil if it is written in the source
 }
 
 func (i Token) Span() Span {
@@ -302,3 +303,15 @@ func (op Token) Precedence() int {
 func (op Token) IsLiteral() bool {
 	return op.Type == Rune || op.Type == Number || op.Type == String
 }
+
+// IsKeyword returns whether or not an op was a keyword
+func (op Token) IsKeyword() bool {
+	t, ok := keywords[op.Text]
+	return ok && t == op.Type
+}
+
+// IsOperator returns whether or not an op was an operator or a
+// punctuator, such as + or {
+func (op Token) IsOperator() bool {
+	return Div <= op.Type && op.Type < Number && op.Type != Ident && !op.IsKeyword()
+}
@@ -0,0 +1,85 @@
+package scan
+
+// trigraphs maps the last character of a ??x trigraph sequence
+// to the character it stands for.
+var trigraphs = map[byte]byte{
+	'=':  '#',
+	'/':  '\\',
+	'\'': '^',
+	'(':  '[',
+	')':  ']',
+	'!':  '|',
+	'<':  '{',
+	'>':  '}',
+	'-':  '~',
+}
+
+// replaceTrigraphs replaces the trigraphs in a line that was just loaded,
+// as the first thing done before tokenizing it. Trigraphs are only replaced
+// when they are enabled in the config, otherwise a warning is given so
+// a literal like "??/" doesn't silently mean something else than it
+// does with other compilers.
+func (l *Scanner) replaceTrigraphs(line []byte) []byte {
+	if l.conf.scanRaw || l.conf.expandingMacro {
+		return line
+	}
+
+	for i := 0; i+2 < len(line); i++ {
+		if line[i] != '?' || line[i+1] != '?' {
+			continue
+		}
+		c, found := trigraphs[line[i+2]]
+		if !found {
+			continue
+		}
+
+		if !l.conf.Trigraphs {
+			if !l.frozen(1) {
+				pos := l.r.Position
+				pos.Offset += i
+				pos.Column += i
+				l.Tokens <- Token{Type: Warning, Pos: pos, Text: "trigraph ??" + string(line[i+2]) + " ignored, use -trigraphs to enable"}
+			}
+			i += 2
+			continue
+		}
+
+		line[i] = c
+		line = append(line[:i+1], line[i+3:]...)
+	}
+	return line
+}
+
+// digraph translates the digraphs <: :> <% %> %: into the character
+// they stand for when trigraphs are enabled in the config, r is the
+// character that was just read. Otherwise, it warns about the digraph
+// and leaves it to be scanned as is.
+func (l *Scanner) digraph(r rune) (rune, bool) {
+	if l.conf.scanRaw || (r != '<' && r != ':' && r != '%') {
+		return r, false
+	}
+
+	var d rune
+	c := l.peek()
+	switch {
+	case r == '<' && c == ':':
+		d = '['
+	case r == ':' && c == '>':
+		d = ']'
+	case r == '<' && c == '%':
+		d = '{'
+	case r == '%' && c == '>':
+		d = '}'
+	case r == '%' && c == ':':
+		d = '#'
+	default:
+		return r, false
+	}
+
+	if !l.conf.Trigraphs {
+		l.pwarnf(false, "digraph %c%c ignored, use -trigraphs to enable", r, c)
+		return r, false
+	}
+	l.next()
+	return d, true
+}
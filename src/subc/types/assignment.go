@@ -1,5 +1,10 @@
 package types
 
+import (
+	"subc/ast"
+	"subc/scan"
+)
+
 // assignment tries to see if the assignment of y to x is possible.
 func (c *checker) assignment(x, y *operand) {
 	a, b := ExprString(x.expr), ExprString(y.expr)
@@ -10,13 +15,25 @@ func (c *checker) assignment(x, y *operand) {
 		return
 	}
 
-	// struct/unions are not supported for assignment
-	if isRecord(x.typ) || isRecord(y.typ) {
-		c.errorf(x.pos(), "%v cannot be assigned to %v, assignment of struct/unions are not supported", a, b)
+	if v := c.variableOf(x.expr); v != nil && v.readOnly {
+		c.errorf(x.pos(), "assignment of read-only variable %v", a)
 		x.mode = invalid
 		return
 	}
 
+	// struct/unions can be assigned from a struct/union of the same type
+	// stored somewhere, which is copied
+	if isRecord(x.typ) || isRecord(y.typ) {
+		if !Identical(x.typ, y.typ) {
+			c.errorf(x.pos(), "cannot assign because of type mismatch of %v and %v", x, y)
+			x.mode = invalid
+		} else if y.mode != variable && !isAssignment(y.expr) {
+			c.errorf(x.pos(), "%v cannot be assigned to %v, only a struct/union stored in memory can be assigned", b, a)
+			x.mode = invalid
+		}
+		return
+	}
+
 	// strings can be assigned to a char* only
 	if y.typ == Typ[UntypedString] && isPointer(x.typ) && deref(x.typ) == Typ[Char] {
 		return
@@ -50,3 +67,33 @@ func (c *checker) assignment(x, y *operand) {
 		return
 	}
 }
+
+// variableOf returns the variable named by e, if e is a name.
+func (c *checker) variableOf(e ast.Expr) *Var {
+	for {
+		p, ok := e.(*ast.ParenExpr)
+		if !ok {
+			break
+		}
+		e = p.X
+	}
+	id, ok := e.(*ast.Ident)
+	if !ok {
+		return nil
+	}
+	v, _ := c.Uses[id].(*Var)
+	return v
+}
+
+// isAssignment reports whether e is a plain assignment, ignoring parentheses.
+func isAssignment(e ast.Expr) bool {
+	for {
+		p, ok := e.(*ast.ParenExpr)
+		if !ok {
+			break
+		}
+		e = p.X
+	}
+	b, ok := e.(*ast.BinaryExpr)
+	return ok && b.Op.Type == scan.Assign
+}
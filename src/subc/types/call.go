@@ -10,11 +10,16 @@ func (c *checker) call(x *operand, e *ast.CallExpr) exprType {
 	// if there is no forward declaration of the function
 	// it is assumed to be variadic and returns an int
 	ident, ok := e.Fun.(*ast.Ident)
+	if _, builtin := Builtin(e.Fun); builtin {
+		c.builtin(x, e, ident.Name)
+		return statement
+	}
 	if ok {
 		var y operand
 		c.ident(&y, ident, true)
 		if y.mode == invalid {
 			pos := e.Span().Start
+			c.implicitf(pos, "implicit declaration of function %s", ident.Name)
 			result := NewVar(pos, 0, ident.Name, Typ[Int], nil)
 			sig := NewSignature(nil, result, true)
 			fun := NewFunc(pos, GlobalStatic, ident.Name, sig)
@@ -80,6 +85,53 @@ Error:
 	return statement
 }
 
+// builtins are the functions that the compiler generates the code of in
+// place of a call, by the operator of the arithmetic that they check.
+var builtins = map[string]scan.Type{
+	"__builtin_add_overflow": scan.Plus,
+	"__builtin_sub_overflow": scan.Minus,
+	"__builtin_mul_overflow": scan.Mul,
+}
+
+// Builtin returns the operator of the builtin function that x names, which
+// computes x op y into the int that its third argument points to and gives
+// 1 if the result overflowed the int, 0 if it didn't. ok is false if x
+// doesn't name a builtin.
+func Builtin(x ast.Expr) (op scan.Type, ok bool) {
+	if x, isIdent := x.(*ast.Ident); isIdent {
+		op, ok = builtins[x.Name]
+	}
+	return
+}
+
+// builtin type checks a call of the builtin function name, which needs no
+// declaration: two integers and a pointer to the int of the result.
+func (c *checker) builtin(x *operand, e *ast.CallExpr, name string) {
+	x.mode = value
+	x.typ = Typ[Int]
+	x.expr = e
+	if len(e.Args) != 3 {
+		c.errorf(e.Lparen.Span().Start, "expected 3 arguments, but %s passed %d arguments", name, len(e.Args))
+		x.mode = invalid
+		return
+	}
+
+	var y operand
+	for i, arg := range e.Args {
+		c.expr(&y, arg)
+		switch {
+		case y.mode == invalid:
+			x.mode = invalid
+		case i < 2 && !isInteger(y.typ):
+			c.errorf(arg.Span().Start, "argument %d of %s needs an integer, not %v", i+1, name, y.typ)
+			x.mode = invalid
+		case i == 2 && (!isPointer(y.typ) || deref(y.typ.Underlying()).Underlying() != Typ[Int]):
+			c.errorf(arg.Span().Start, "argument 3 of %s needs a pointer to int, not %v", name, y.typ)
+			x.mode = invalid
+		}
+	}
+}
+
 // selector type checks a selector expression (a.x, a->x, etc).
 func (c *checker) selector(x *operand, e *ast.SelectorExpr) {
 	pos := e.X.Span().Start
@@ -12,10 +12,23 @@ import (
 // Config is used to control the behavior of the type checker while
 // it is running.
 type Config struct {
-	MaxErrors int   // the maximum number of errors before bailing out
-	Sizes     Sizes // used to determine the size, offset of and alignment of types.
+	MaxErrors int          // the maximum number of errors before bailing out
+	Sizes     Sizes        // used to determine the size, offset of and alignment of types.
+	StdTypes  StdTypes     // the types of size_t, ptrdiff_t and wchar_t of the target
+	Implicit  ImplicitMode // how implicit int and implicit function declarations are treated
+	Passes    []Pass       // the passes that inspect the program after it type checks
 }
 
+// ImplicitMode controls how the type checker treats declarations that
+// default to int, and calls to functions that were never declared.
+type ImplicitMode int
+
+const (
+	ImplicitWarn  ImplicitMode = iota // they are allowed with a warning
+	ImplicitAllow                     // they are allowed silently, like the SubC compiler does
+	ImplicitError                     // they are errors
+)
+
 // Info contains the type checked information after the type checker is ran.
 type Info struct {
 	// stores all the type and values of the expression, values are stored if
@@ -36,7 +49,8 @@ type Info struct {
 }
 
 // Check type checks an AST tree and return an type information structure
-// of map structures indexed by the AST tree nodes.
+// of map structures indexed by the AST tree nodes. The errors and warnings
+// are a *scan.ErrorList, scan.DiagnosticsOf returns them as diagnostics.
 func Check(conf Config, prog *ast.Prog) (*Info, error) {
 	if conf.Sizes == nil {
 		return nil, fmt.Errorf("typechecker needs a Sizes interface")
@@ -47,8 +61,9 @@ func Check(conf Config, prog *ast.Prog) (*Info, error) {
 
 // context provides a context for the type checker.
 type context struct {
-	scope *Scope
-	iota  constant.Value
+	scope   *Scope
+	iota    constant.Value
+	stmtCtx stmtContext // the context of the statement being checked, for the statement expressions in it
 }
 
 // checker is the type checker.
@@ -56,6 +71,8 @@ type checker struct {
 	*Info
 	conf   Config
 	errors scan.ErrorList
+	heat   map[string]string // the hot or cold attribute given to a function
+	asms   map[string]string // the symbol given to a global by an asm label
 
 	context
 }
@@ -70,6 +87,8 @@ func (c *checker) Check(prog *ast.Prog) (info *Info, err error) {
 		Selections: make(map[*ast.SelectorExpr]*Selection),
 		Scopes:     make(map[ast.Node]*Scope),
 	}
+	c.heat = make(map[string]string)
+	c.asms = make(map[string]string)
 
 	defer func() {
 		if e := recover(); e != nil {
@@ -81,11 +100,11 @@ func (c *checker) Check(prog *ast.Prog) (info *Info, err error) {
 	}()
 
 	c.top(prog)
+	c.completeArrays(prog)
+	c.runPasses(prog)
 
-	if c.errors.NumErrors > 0 {
-		return c.Info, &c.errors
-	}
-	return c.Info, nil
+	// warnings are returned too for the caller to report
+	return c.Info, c.errors.Err()
 }
 
 // top goes through all the top level declarations
@@ -110,6 +129,26 @@ func (c *checker) top(prog *ast.Prog) {
 	}
 }
 
+// completeArrays gives the global arrays that were declared without
+// a size and never completed one element, as other compilers do at
+// the end of the translation unit.
+func (c *checker) completeArrays(prog *ast.Prog) {
+	for _, d := range prog.Decls {
+		d, ok := d.(*ast.VarDecl)
+		if !ok {
+			continue
+		}
+		v, ok := c.Defs[d.Name].(*Var)
+		if !ok || v.storage == Extern || c.scope.Lookup(Ord, v.name) != v {
+			continue
+		}
+		if a, ok := v.typ.(*Array); ok && a.len < 0 {
+			c.warnf(d.Name.Span().Start, "array %s assumed to have one element", v.name)
+			a.len = 1
+		}
+	}
+}
+
 // recordTypeAndValue records the type and value information of an expression.
 func (c *checker) recordTypeAndValue(x ast.Expr, mode operandMode, typ Type, val constant.Value) {
 	if mode == invalid {
@@ -1,9 +1,11 @@
 package types
 
 import (
+	"strconv"
 	"text/scanner"
 
 	"subc/ast"
+	"subc/constant"
 	"subc/scan"
 )
 
@@ -20,6 +22,7 @@ func (c *checker) enumDecl(d *ast.EnumDecl) {
 func (c *checker) recordDecl(d *ast.RecordDecl) {
 	rec := new(Record)
 	rec.union = d.Record.Type == scan.Union
+	rec.pack = d.Pack
 
 	obj := NewTypeName(d.Record.Span().Start, d.Name.Name, rec)
 	c.declare(Tag, c.scope, d.Name, obj, scan.NoPos)
@@ -29,6 +32,7 @@ func (c *checker) recordDecl(d *ast.RecordDecl) {
 	add := func(field *ast.FieldDecl, ident *ast.Ident, typ Type, pos scanner.Position) {
 		name := ident.Name
 		fld := NewField(pos, name, typ)
+		fld.pack = d.Pack
 		if c.declareInSet(&fset, pos, fld) {
 			fields = append(fields, fld)
 			c.recordDef(ident, fld)
@@ -38,9 +42,23 @@ func (c *checker) recordDecl(d *ast.RecordDecl) {
 			c.errorf(pos, "field %s is declared void", name)
 		}
 	}
-	for _, f := range d.Fields {
+	for i, f := range d.Fields {
 		typ := c.typExpr(f.Type)
 		add(f, f.Name, typ, f.Name.Span().Start)
+
+		// an array of unknown size is only allowed as a flexible
+		// array member, the last field of a struct with other fields
+		if a, ok := typ.(*Array); ok && a.len < 0 {
+			pos := f.Name.Span().Start
+			switch {
+			case rec.union:
+				c.errorf(pos, "flexible array member %s in union", f.Name.Name)
+			case i != len(d.Fields)-1:
+				c.errorf(pos, "flexible array member %s not at end of struct", f.Name.Name)
+			case i == 0:
+				c.errorf(pos, "flexible array member %s in otherwise empty struct", f.Name.Name)
+			}
+		}
 	}
 	rec.fields = fields
 
@@ -86,6 +104,7 @@ func (c *checker) funcDecl(d *ast.FuncDecl) {
 	if d.Result != nil {
 		result = NewVar(d.Result.Span().Start, newStorage(d.Storage, true, true), "", c.typExpr(d.Result), nil)
 	} else {
+		c.implicitf(d.Name.Pos, "return type defaults to int in declaration of %s", name)
 		result = NewVar(d.Span().Start, newStorage(d.Storage, true, true), "", Typ[Int], nil)
 	}
 
@@ -123,6 +142,9 @@ func (c *checker) funcDecl(d *ast.FuncDecl) {
 		}
 	}
 
+	c.funcAttrs(d)
+	c.asmLabel(d.Name, d.Asm, true)
+
 	sig := NewSignature(NewTuple(vars...), result, variadic)
 	fun := NewFunc(d.Name.Pos, newStorage(d.Storage, true, true), name, sig)
 	fwrd := NewFwrd(name, fun)
@@ -138,6 +160,237 @@ func (c *checker) funcDecl(d *ast.FuncDecl) {
 	}
 }
 
+// funcAttrs checks the attributes of a function declaration. The hot
+// and cold attributes are known, a function can't be both in any of its
+// declarations, and no_instrument_function keeps the hooks of
+// -finstrument-functions out of a function.
+func (c *checker) funcAttrs(d *ast.FuncDecl) {
+	name := d.Name.Name
+	for _, a := range d.Attrs {
+		switch a.Name.Name {
+		case "hot", "cold":
+			if heat := c.heat[name]; heat != "" && heat != a.Name.Name {
+				c.errorf(a.Name.Pos, "function %s declared %s was declared %s", name, a.Name.Name, heat)
+				continue
+			}
+			c.heat[name] = a.Name.Name
+		case "no_instrument_function":
+		default:
+			c.warnf(a.Name.Pos, "unknown attribute %s ignored", a.Name.Name)
+		}
+	}
+}
+
+const (
+	DefaultVarAlign = 16      // the alignment of a variable given the aligned attribute without an argument
+	MaxVarAlign     = 1 << 16 // the largest alignment that the aligned attribute can give
+)
+
+// varAttrs checks the attributes of a variable declaration. The aligned
+// attribute is the only one known, a variable on the stack can't be
+// aligned more than the stack is so it is ignored for those.
+func (c *checker) varAttrs(d *ast.VarDecl, global bool) {
+	for _, a := range d.Attrs {
+		switch a.Name.Name {
+		case "aligned":
+			if !global && (d.Storage == nil || d.Storage.Type != scan.Static) {
+				c.warnf(a.Name.Pos, "aligned attribute of local variable %s ignored", d.Name.Name)
+				continue
+			}
+			c.alignArg(a)
+		default:
+			c.warnf(a.Name.Pos, "unknown attribute %s ignored", a.Name.Name)
+		}
+	}
+}
+
+// AsmName returns the symbol that the asm label s gives.
+func AsmName(s *ast.StringLit) string {
+	var name string
+	for _, lit := range s.Lits {
+		name += lit.Text[1 : len(lit.Text)-1]
+	}
+	return name
+}
+
+// asmLabel checks the asm label of a declaration of name, if it has one.
+// Only the globals and the functions have symbols to rename, and all the
+// declarations of one that have a label must give it the same symbol.
+func (c *checker) asmLabel(name *ast.Ident, s *ast.StringLit, global bool) {
+	if s == nil {
+		return
+	}
+	pos := s.Span().Start
+	sym := AsmName(s)
+	switch prev, found := c.asms[name.Name]; {
+	case !global:
+		c.errorf(pos, "asm label of local variable %s not supported", name.Name)
+	case sym == "":
+		c.errorf(pos, "empty asm label of %s", name.Name)
+	case found && prev != sym:
+		c.errorf(pos, "%s declared with asm label %q was declared with %q", name.Name, sym, prev)
+	default:
+		c.asms[name.Name] = sym
+	}
+}
+
+// alignArg checks the argument of an aligned attribute, it is optional
+// and must be a constant power of 2 no larger than MaxVarAlign.
+func (c *checker) alignArg(a *ast.Attr) {
+	var x operand
+
+	switch len(a.Args) {
+	case 0:
+		return
+	case 1:
+	default:
+		c.errorf(a.Name.Pos, "aligned attribute takes one argument")
+		return
+	}
+
+	pos := a.Args[0].Span().Start
+	c.expr(&x, a.Args[0])
+	if x.mode == invalid {
+		return
+	}
+	if x.mode != constant_ || x.val.Type() != constant.Int {
+		c.errorf(pos, "requested alignment is not an integer constant")
+		return
+	}
+	n, err := strconv.ParseInt(x.val.String(), 0, 64)
+	switch {
+	case err != nil || n > MaxVarAlign:
+		c.errorf(pos, "requested alignment %s is larger than %d", x.val, MaxVarAlign)
+	case n <= 0 || n&(n-1) != 0:
+		c.errorf(pos, "requested alignment %s is not a positive power of 2", x.val)
+	}
+}
+
+// arrayInit type checks the initializer of an array. An array without
+// a size gets the size of its initializer, and the elements that the
+// initializer doesn't give are zero. The compiler stores the initializer
+// of an auto array when the function is entered.
+func (c *checker) arrayInit(d *ast.VarDecl, array *Array, global bool) {
+	var x operand
+
+	vpos := d.Value.Span().Start
+	name := d.Name.Name
+	elem := array.Elem()
+	static := global || d.Storage != nil && d.Storage.Type == scan.Static
+	if !static && (isRecord(elem) || isArray(elem)) {
+		c.errorf(vpos, "initialization of local arrays of %v not supported", elem)
+		return
+	}
+
+	switch v := d.Value.(type) {
+	case *ast.CompositeLit:
+		c.expr(&x, d.Value)
+		// the pointers of a static array take address constants, the
+		// ones of an auto array only the addresses of labels
+		for _, e := range v.Elts {
+			switch {
+			case !c.addressConst(e):
+			case !isPointer(elem) && !isSignature(elem), !static && !isLabelAddr(e):
+				c.errorf(e.Span().Start, "initializer element of %s is not constant", name)
+			}
+		}
+		n := int64(len(v.Elts))
+		switch {
+		case n == 0:
+			c.errorf(vpos, "cannot have empty initializer")
+		case array.len < 0:
+			array.len = n
+		case n > array.len:
+			c.errorf(vpos, "too many initializers for %s", name)
+		}
+
+	case *ast.StringLit:
+		var n int64
+		for _, lit := range v.Lits {
+			n += int64(len(lit.Text) - 2)
+		}
+		// a string that fills the array leaves out the terminating nul
+		switch {
+		case array.len < 0:
+			array.len = n + 1
+		case n > array.len:
+			c.errorf(vpos, "initializer string for %s is too long", name)
+		}
+
+	default:
+		c.errorf(vpos, "unsupported array initialization type: %T", v)
+	}
+}
+
+// addressConst reports whether e is an address constant, which a static
+// pointer can be initialized to: a string, the address of a static
+// object or of a label, an array or a function that stands for its
+// address, or one of these plus or minus an integer constant.
+func (c *checker) addressConst(e ast.Expr) bool {
+	switch e := e.(type) {
+	case *ast.StringLit:
+		return true
+	case *ast.ParenExpr:
+		return c.addressConst(e.X)
+	case *ast.CastExpr:
+		return isPointer(c.Types[e].Type) && c.addressConst(e.X)
+	case *ast.UnaryExpr:
+		return isLabelAddr(e) || e.Op.Type == scan.And && c.staticObject(e.X)
+	case *ast.BinaryExpr:
+		x, y := e.X, e.Y
+		if tv := c.Types[x]; e.Op.Type == scan.Plus && tv.Value != nil && tv.Value.Type() == constant.Int {
+			x, y = y, x
+		}
+		isAdd := e.Op.Type == scan.Plus || e.Op.Type == scan.Minus
+		return isAdd && c.Types[y].Value != nil && c.addressConst(x)
+	case *ast.Ident, *ast.IndexExpr, *ast.SelectorExpr:
+		switch t := c.Types[e].Type.(type) {
+		case *Pointer:
+			return t.Decay() != nil && c.staticObject(e)
+		case *Array, *Signature:
+			return c.staticObject(e)
+		}
+	}
+	return false
+}
+
+// isLabelAddr reports whether e is &&label.
+func isLabelAddr(e ast.Expr) bool {
+	u, ok := e.(*ast.UnaryExpr)
+	return ok && u.Op.Type == scan.Land
+}
+
+// staticObject reports whether e names an object whose address is known
+// when the program is linked: a global, a local static or a function, an
+// element of a static array at a constant index, or a field of a static
+// record.
+func (c *checker) staticObject(e ast.Expr) bool {
+	switch e := e.(type) {
+	case *ast.ParenExpr:
+		return c.staticObject(e.X)
+	case *ast.Ident:
+		switch obj := c.Uses[e].(type) {
+		case *Var:
+			return obj.storage != Auto
+		case *Func, *Fwrd:
+			return true
+		}
+	case *ast.IndexExpr:
+		isArray := false
+		switch t := c.Types[e.X].Type.(type) {
+		case *Pointer:
+			isArray = t.Decay() != nil
+		case *Array:
+			isArray = true
+		}
+		return isArray && c.Types[e.Index].Value != nil && c.staticObject(e.X)
+	case *ast.SelectorExpr:
+		sel := c.Selections[e]
+		return sel != nil && !sel.Indirect() && c.staticObject(e.X)
+	}
+	return false
+}
+
 // varDecl type checks a variable declaration.
 func (c *checker) varDecl(d *ast.VarDecl, global bool) {
 	var x operand
@@ -145,6 +398,8 @@ func (c *checker) varDecl(d *ast.VarDecl, global bool) {
 	pos := d.Span().Start
 	typ := c.typExpr(d)
 	name := d.Name.Name
+	c.varAttrs(d, global)
+	c.asmLabel(d.Name, d.Asm, global)
 	array, isArray := typ.(*Array)
 	switch {
 	case isArray:
@@ -153,46 +408,56 @@ func (c *checker) varDecl(d *ast.VarDecl, global bool) {
 		}
 
 		if d.Value != nil {
-			vpos := d.Value.Span().Start
-			if !global {
-				c.errorf(vpos, "initialization of local arrays not supported")
-			} else {
-				c.expr(&x, d.Value)
-				switch v := d.Value.(type) {
-				case *ast.CompositeLit:
-					array.len = int64(len(v.Elts))
-					if array.len == 0 {
-						c.errorf(vpos, "cannot have empty initializer")
-					}
-
-				case *ast.StringLit:
-					array.len = 0
-					for _, lit := range v.Lits {
-						array.len += int64(len(lit.Text))
-					}
-
-				default:
-					c.errorf(vpos, "unsupported array initialization type: %T", v)
-				}
-			}
+			c.arrayInit(d, array, global)
+		}
+
+		if array.len < 0 && !global && d.Value == nil && (d.Storage == nil || d.Storage.Type != scan.Extern) {
+			c.errorf(pos, "array size missing in %s", name)
 		}
 
 	case d.Value != nil:
+		// a global or a local static is initialized when the program is
+		// loaded, to a constant or, if it is a pointer, to an address
+		static := global || d.Storage != nil && d.Storage.Type == scan.Static
 		c.expr(&x, d.Value)
-		if isPointer(typ) && x.mode == constant_ && x.val.String() != "0" {
+		switch {
+		case x.mode == invalid:
+		case static && (isPointer(typ) || isSignature(typ)) && c.addressConst(d.Value):
+		case static && x.mode != constant_:
+			c.errorf(d.Value.Span().Start, "initializer element of %s is not constant", name)
+		case isPointer(typ) && x.mode == constant_ && x.val.String() != "0":
 			c.errorf(d.Value.Span().Start, "non-zero pointer initialization")
+		case typ == Typ[Int] && x.mode == constant_ && x.val.Type() == constant.Int:
+			// a long constant is stored in an int the way it is converted
+			// at run time, keeping the bits that fit
+			v := constant.Wrap(x.val, uint(8*c.conf.Sizes.Sizeof(typ)))
+			if !constant.Compare(v, scan.Eq, x.val) {
+				c.warnf(d.Value.Span().Start, "conversion of %v to int changes the value to %v", x.val, v)
+				x.val = v
+			}
 		}
 	}
 
 	obj := NewVar(d.Span().Start, newStorage(d.Storage, global, false), name, typ, x.val)
+	// only a const scalar is read-only itself, in const char *p
+	// the qualifier is for what p points to
+	_, obj.readOnly = typ.(*Basic)
+	obj.readOnly = obj.readOnly && d.Const != nil
+	obj.register = d.Storage != nil && d.Storage.Type == scan.Register
 	if global {
 		scope, alt := c.scope.LookupParent(Ord, name, scan.NoPos)
 		v, ok := alt.(*Var)
+		if ok {
+			c.checkLinkage(v, obj)
+		}
 		if alt != nil && (ok && v.val == nil) {
 			delete(scope.elems[Ord], name)
 		}
 
-		if !(alt != nil && ok && v.val != nil && x.val == nil) {
+		if alt != nil && ok && v.val != nil && x.val == nil {
+			// a tentative definition after the definition refers to it
+			c.recordDef(d.Name, v)
+		} else {
 			c.declare(Ord, c.scope, d.Name, obj, scan.NoPos)
 		}
 		fwrd := NewFwrd(name, obj)
@@ -210,18 +475,43 @@ func (c *checker) varDecl(d *ast.VarDecl, global bool) {
 	}
 }
 
+// checkLinkage checks that a redeclaration of a global variable
+// agrees with the earlier declaration on whether it is static.
+// An extern declaration takes on the linkage of the earlier one.
+func (c *checker) checkLinkage(x, y *Var) {
+	switch {
+	case x.storage == GlobalStatic && y.storage == Public:
+		c.errorf(y.Pos(), "non-static declaration of %s follows static declaration", y.Name())
+		c.reportAltDecl(x)
+	case x.storage != GlobalStatic && y.storage == GlobalStatic:
+		c.errorf(y.Pos(), "static declaration of %s follows non-static declaration", y.Name())
+		c.reportAltDecl(x)
+	}
+}
+
 // checkFwrd checks if the forward declarations matches so far.
 func (c *checker) checkFwrd(x *Fwrd) {
 	for i := len(x.objs) - 1; i > 0; i-- {
 		a := x.objs[i]
 		b := x.objs[i-1]
-		if !Identical(a.Type(), b.Type()) {
+		if !Identical(a.Type(), b.Type()) && !completes(a.Type(), b.Type()) {
 			c.errorf(a.Pos(), "declaration mismatch from declaration at %v", b.Pos())
 			break
 		}
 	}
 }
 
+// completes reports whether one of x and y is an array of unknown
+// size and the other is the same array with its size given, as in
+//
+//	extern int a[];
+//	int a[10];
+func completes(x, y Type) bool {
+	a, ok := x.(*Array)
+	b, ok2 := y.(*Array)
+	return ok && ok2 && (a.len < 0 || b.len < 0) && Identical(a.elem, b.elem)
+}
+
 // declare declares an object in a name space, it will error out
 // if there is an existing object with the same name in the same scope and namespace.
 func (c *checker) declare(ns Namespace, scope *Scope, id *ast.Ident, obj Object, pos scanner.Position) {
@@ -28,4 +28,18 @@ func (c *checker) errorf(pos scanner.Position, format string, args ...interface{
 	}
 }
 
+func (c *checker) warnf(pos scanner.Position, format string, args ...interface{}) {
+	c.errors.Add(scan.ErrorMessage{pos, fmt.Sprintf(format, args...), true})
+}
+
+// implicitf reports an implicit declaration according to the config.
+func (c *checker) implicitf(pos scanner.Position, format string, args ...interface{}) {
+	switch c.conf.Implicit {
+	case ImplicitWarn:
+		c.warnf(pos, format, args...)
+	case ImplicitError:
+		c.errorf(pos, format, args...)
+	}
+}
+
 type bailout struct{}
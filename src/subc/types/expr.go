@@ -3,6 +3,7 @@ package types
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	"subc/ast"
 	"subc/constant"
@@ -96,8 +97,8 @@ func (c *checker) binaryCast(x, y operand, op scan.Type) Type {
 	case scan.Minus:
 		switch {
 		case p1 && p2:
-			// a pointer-pointer is an integer
-			return Typ[Int]
+			// a pointer-pointer is a ptrdiff_t
+			return c.conf.StdTypes.PtrdiffT()
 		case !p1 && !(i1 && i2):
 			// cannot do integer-pointer
 			c.invalidOp(x.pos(), "cannot apply binary op '-' to types %v %v", x.typ, y.typ)
@@ -146,14 +147,25 @@ func (c *checker) binary(x *operand, lhs, rhs ast.Expr, op scan.Type) {
 		}
 	}
 
+	// a shift by a count the int can't take is left to the target
+	// to do as its shift instruction does, at run time
+	oversized := c.oversizedShift(op, &y)
+
+	// and so is the division of the most negative int by -1
+	overflowed := c.overflowedDiv(op, x, &y)
+
 	if x.mode == constant_ && x.val.Type() != constant.String &&
 		y.mode == constant_ && y.val.Type() != constant.String &&
-		validConstBinOp(op) {
+		validConstBinOp(op) && !oversized && !overflowed {
 		var err error
 		x.val, err = constant.BinaryOp(x.val, op, y.val)
 		if err != nil {
 			c.errorf(y.pos(), "%v", err)
 		}
+		if op == scan.Lsh {
+			// the bits shifted out are lost like they are at run time
+			x.val = constant.Wrap(x.val, uint(8*c.conf.Sizes.Sizeof(Typ[Int])))
+		}
 		return
 	}
 
@@ -161,6 +173,65 @@ func (c *checker) binary(x *operand, lhs, rhs ast.Expr, op scan.Type) {
 	x.mode = value
 }
 
+// oversizedShift warns about a shift by a constant count that is negative
+// or not less than the width of an int, and reports whether it is one.
+func (c *checker) oversizedShift(op scan.Type, y *operand) bool {
+	switch op {
+	case scan.Lsh, scan.Rsh, scan.LshEq, scan.RshEq:
+	default:
+		return false
+	}
+	if y.mode != constant_ || y.val.Type() != constant.Int {
+		return false
+	}
+
+	bits := constant.MakeInt64(8 * c.conf.Sizes.Sizeof(Typ[Int]))
+	switch {
+	case constant.Compare(y.val, scan.Lt, constant.MakeInt64(0)):
+		c.warnf(y.pos(), "shift count %v is negative", y.val)
+	case constant.Compare(y.val, scan.Geq, bits):
+		c.warnf(y.pos(), "shift count %v is not less than the width of int", y.val)
+	default:
+		return false
+	}
+	return true
+}
+
+// overflowedDiv warns about dividing the constant most negative int by -1,
+// as the quotient doesn't fit in an int, and reports whether it is one.
+func (c *checker) overflowedDiv(op scan.Type, x, y *operand) bool {
+	if op != scan.Div && op != scan.Mod {
+		return false
+	}
+	if x.mode != constant_ || x.val.Type() != constant.Int ||
+		y.mode != constant_ || y.val.Type() != constant.Int {
+		return false
+	}
+
+	min, _ := constant.Shift(constant.MakeInt64(-1), scan.Lsh, uint(8*c.conf.Sizes.Sizeof(Typ[Int])-1))
+	if !constant.Compare(x.val, scan.Eq, min) || !constant.Compare(y.val, scan.Eq, constant.MakeInt64(-1)) {
+		return false
+	}
+	c.warnf(y.pos(), "dividing the most negative int by -1 overflows")
+	return true
+}
+
+// intLit gives an integer constant that doesn't fit in an int the type
+// long, and warns about one that doesn't fit in a long either, which is
+// wrapped to the width of a long.
+func (c *checker) intLit(x *operand, e *ast.BasicLit) {
+	bits := uint(8 * c.conf.Sizes.Sizeof(Typ[Int]))
+	if x.typ == Typ[Int] && !constant.Compare(constant.Wrap(x.val, bits), scan.Eq, x.val) {
+		x.typ = Typ[Long]
+	}
+
+	bits = uint(8 * c.conf.Sizes.Sizeof(Typ[Long]))
+	if v := constant.Wrap(x.val, bits); !constant.Compare(v, scan.Eq, x.val) {
+		c.warnf(e.Span().Start, "integer constant %s is too large for long, it is wrapped to %v", e.Text, v)
+		x.val = v
+	}
+}
+
 // validConstBinOp returns if an op is a valid binary operaiton
 // to generate do constant folding for.
 func validConstBinOp(op scan.Type) bool {
@@ -175,7 +246,16 @@ func validConstBinOp(op scan.Type) bool {
 
 // incOrDec type checks an expression for ++/-- operators.
 func (c *checker) incOrDec(x *operand, e *ast.UnaryExpr, op scan.Type) {
-	Y := &ast.BasicLit{scan.Token{scan.Number, e.Span().Start, "1"}}
+	if v := c.variableOf(e.X); v != nil && v.readOnly {
+		what := "increment"
+		if op == scan.Minus {
+			what = "decrement"
+		}
+		c.errorf(x.pos(), "%s of read-only variable %v", what, ExprString(e.X))
+		x.mode = invalid
+		return
+	}
+	Y := &ast.BasicLit{scan.Token{Type: scan.Number, Pos: e.Span().Start, Text: "1"}}
 	c.binary(x, e.X, Y, op)
 }
 
@@ -188,6 +268,11 @@ func (c *checker) unary(x *operand, e *ast.UnaryExpr, op scan.Type) {
 			x.mode = invalid
 			return
 		}
+		if v := c.variableOf(e.X); v != nil && v.register {
+			c.errorf(x.pos(), "address of register variable %s requested", v.name)
+			x.mode = invalid
+			return
+		}
 		x.mode = value
 		x.typ = NewPointer(x.typ, nil)
 		return
@@ -255,11 +340,11 @@ func (c *checker) exprInternal(x *operand, e ast.Expr) exprType {
 		c.ident(x, e, false)
 
 	case *ast.StringLit:
-		var text string
+		var b strings.Builder
 		for _, lit := range e.Lits {
-			text += lit.Text[1 : len(lit.Text)-1]
+			b.WriteString(lit.Text[1 : len(lit.Text)-1])
 		}
-		text = strconv.Quote(text)
+		text := strconv.Quote(b.String())
 		x.setConst(scan.String, text)
 		if x.mode == invalid {
 			c.invalidAST(pos, "invalid literal %v", text)
@@ -271,12 +356,15 @@ func (c *checker) exprInternal(x *operand, e ast.Expr) exprType {
 			c.invalidAST(pos, "invalid literal %v", e.Text)
 			goto Error
 		}
+		if e.Type == scan.Number {
+			c.intLit(x, e)
+		}
 
 	case *ast.CompositeLit:
 		var y operand
 		for _, elt := range e.Elts {
 			c.expr(&y, elt)
-			if y.mode != constant_ {
+			if y.mode != constant_ && y.mode != invalid && !c.addressConst(elt) {
 				c.errorf(y.pos(), "constant expression expected")
 			}
 		}
@@ -334,6 +422,13 @@ func (c *checker) exprInternal(x *operand, e ast.Expr) exprType {
 		c.index(e.Index)
 
 	case *ast.UnaryExpr:
+		if e.Op.Type == scan.Land {
+			c.labelAddr(x, e)
+			if x.mode == invalid {
+				goto Error
+			}
+			break
+		}
 		c.expr(x, e.X)
 		if x.mode == invalid {
 			goto Error
@@ -348,6 +443,12 @@ func (c *checker) exprInternal(x *operand, e ast.Expr) exprType {
 		x.expr = e
 		return exprType
 
+	case *ast.StmtExpr:
+		c.stmtExpr(x, e)
+		if x.mode == invalid {
+			goto Error
+		}
+
 	case *ast.SizeofExpr:
 		c.expr(x, e.X)
 		if x.mode == invalid {
@@ -362,7 +463,7 @@ func (c *checker) exprInternal(x *operand, e ast.Expr) exprType {
 
 		x.mode = constant_
 		x.val = constant.MakeInt64(int64(c.conf.Sizes.Sizeof(typ)))
-		x.typ = Typ[Int]
+		x.typ = c.conf.StdTypes.SizeT()
 
 	case *ast.SelectorExpr:
 		c.selector(x, e)
@@ -397,6 +498,23 @@ Error:
 	return statement
 }
 
+// labelAddr type checks &&label, the address of a label of the function
+// that goto * can jump to. It is a void pointer.
+func (c *checker) labelAddr(x *operand, e *ast.UnaryExpr) {
+	x.mode = invalid
+	id, ok := e.X.(*ast.Ident)
+	if !ok {
+		c.invalidAST(e.Span().Start, "address of a label with no name")
+		return
+	}
+	if obj, _ := c.scope.LookupParent(Lab, id.Name, scan.NoPos); obj == nil {
+		c.errorf(id.Span().Start, "address of non-existent label %v", id.Name)
+		return
+	}
+	x.mode = value
+	x.typ = NewPointer(Typ[Void], nil)
+}
+
 // constExpr type checks an expression for a constant value.
 func (c *checker) constExpr(x *operand, e ast.Expr) {
 	c.expr(x, e)
@@ -405,6 +523,27 @@ func (c *checker) constExpr(x *operand, e ast.Expr) {
 	}
 }
 
+// octalLits warns about the octal constants in e that look like they were
+// meant to be decimal, as in a case label of 010 that is 8, not 10.
+func (c *checker) octalLits(e ast.Expr) {
+	ast.Inspect(e, func(n ast.Node) bool {
+		lit, ok := n.(*ast.BasicLit)
+		if !ok || lit.Type != scan.Number || len(lit.Text) < 2 || lit.Text[0] != '0' {
+			return true
+		}
+
+		text := strings.TrimRight(lit.Text, "uUlL")
+		dec, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return true
+		}
+		if oct, err := strconv.ParseInt(text, 8, 64); err == nil && oct != dec {
+			c.warnf(lit.Span().Start, "octal constant %s is %d, not %d", lit.Text, oct, dec)
+		}
+		return true
+	})
+}
+
 // index checks an expression for array accesses.
 func (c *checker) index(index ast.Expr) {
 	var x operand
@@ -77,6 +77,9 @@ func WriteExpr(buf *bytes.Buffer, x ast.Expr) {
 		WriteExpr(buf, x.X)
 		buf.WriteByte(')')
 
+	case *ast.StmtExpr:
+		buf.WriteString("({...})")
+
 	case *ast.SelectorExpr:
 		WriteExpr(buf, x.X)
 		buf.WriteString(x.Op.Type.String())
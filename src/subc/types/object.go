@@ -52,10 +52,13 @@ type Const struct {
 // Var represents a variable.
 type Var struct {
 	object
-	storage Storage
-	visited bool
-	isField bool
-	val     constant.Value
+	storage  Storage
+	visited  bool
+	isField  bool
+	readOnly bool  // declared const
+	register bool  // declared register
+	pack     int64 // the #pragma pack of the record of a field
+	val      constant.Value
 }
 
 // Label represents a label.
@@ -112,8 +115,12 @@ func NewLabel(pos scanner.Position, name string) *Label {
 
 func (obj *Func) Storage() Storage { return obj.storage }
 
+func (obj *Fwrd) Objs() []Object { return obj.objs }
+
 func (obj *Var) Storage() Storage      { return obj.storage }
 func (obj *Var) Value() constant.Value { return obj.val }
+func (obj *Var) ReadOnly() bool        { return obj.readOnly }
+func (obj *Var) Register() bool        { return obj.register }
 
 func (obj *object) Parent() *Scope                   { return obj.parent }
 func (obj *object) Pos() scanner.Position            { return obj.pos }
@@ -207,6 +214,10 @@ func newStorage(tok *scan.Token, global, isFunc bool) Storage {
 		storage = Extern
 	case scan.Auto:
 		storage = Auto
+	case scan.Register:
+		if !global {
+			storage = Auto
+		}
 	case scan.Static:
 		if global {
 			storage = GlobalStatic
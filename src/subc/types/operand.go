@@ -3,6 +3,7 @@ package types
 import (
 	"bytes"
 	"fmt"
+	"strings"
 	"text/scanner"
 
 	"subc/ast"
@@ -60,8 +61,15 @@ func (x *operand) setConst(typ scan.Type, lit string) {
 	switch typ {
 	case scan.Number:
 		kind = Int
+		if strings.ContainsAny(lit, "lL") {
+			kind = Long
+		}
 	case scan.Rune:
+		// multi-character and wide character constants are ints
 		kind = Char
+		if lit[0] == 'L' || constant.Compare(val, scan.Gt, constant.MakeInt64(255)) {
+			kind = Int
+		}
 	case scan.String:
 		kind = UntypedString
 	default:
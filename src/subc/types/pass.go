@@ -0,0 +1,86 @@
+package types
+
+import (
+	"fmt"
+	"sync"
+	"text/scanner"
+
+	"subc/ast"
+)
+
+// Pass is a pass that inspects a program after it is type checked, such
+// as the lint rules of a style checker. It is given the AST and the type
+// information and must not change them, it can only report diagnostics.
+type Pass struct {
+	Name string // the name of the pass, given with its internal errors
+	Run  func(prog *ast.Prog, info *Info, r *Reporter)
+}
+
+// Reporter reports the diagnostics of a pass along with the ones of the
+// type checker, they count toward the maximum number of errors.
+type Reporter struct {
+	c *checker
+}
+
+// Errorf reports an error at pos.
+func (r *Reporter) Errorf(pos scanner.Position, format string, args ...interface{}) {
+	r.c.errorf(pos, format, args...)
+}
+
+// Warnf reports a warning at pos.
+func (r *Reporter) Warnf(pos scanner.Position, format string, args ...interface{}) {
+	r.c.warnf(pos, format, args...)
+}
+
+var passes struct {
+	sync.Mutex
+	list []Pass
+}
+
+// Register registers a pass that runs in every type check that follows,
+// after the passes of the Config. An embedder registers its passes in
+// an init function, so the compiler built with them runs them without
+// being changed.
+func Register(pass Pass) {
+	if pass.Run == nil {
+		panic(fmt.Sprintf("types: pass %q has no Run", pass.Name))
+	}
+	passes.Lock()
+	defer passes.Unlock()
+	passes.list = append(passes.list, pass)
+}
+
+// Registered returns the passes registered.
+func Registered() []Pass {
+	passes.Lock()
+	defer passes.Unlock()
+	return append([]Pass(nil), passes.list...)
+}
+
+// runPasses runs the passes on prog once it type checks without errors,
+// the information of a program with errors is incomplete. A pass that
+// panics is reported as an error and the passes that follow still run.
+func (c *checker) runPasses(prog *ast.Prog) {
+	if c.errors.NumErrors > 0 {
+		return
+	}
+	r := &Reporter{c}
+	for _, pass := range c.conf.Passes {
+		c.runPass(pass, prog, r)
+	}
+	for _, pass := range Registered() {
+		c.runPass(pass, prog, r)
+	}
+}
+
+func (c *checker) runPass(pass Pass, prog *ast.Prog, r *Reporter) {
+	defer func() {
+		if e := recover(); e != nil {
+			if _, ok := e.(bailout); ok {
+				panic(e)
+			}
+			c.errorf(prog.Span().Start, "internal error in pass %s: %v", pass.Name, e)
+		}
+	}()
+	pass.Run(prog, c.Info, r)
+}
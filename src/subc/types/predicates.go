@@ -40,6 +40,11 @@ func isPointer(typ Type) bool {
 	return ok
 }
 
+func isSignature(typ Type) bool {
+	_, ok := typ.Underlying().(*Signature)
+	return ok
+}
+
 func isRecord(typ Type) bool {
 	_, ok := typ.Underlying().(*Record)
 	return ok
@@ -51,6 +56,19 @@ func isVoidPointer(typ Type) bool {
 
 // Identical returns if two types are identical.
 func Identical(x, y Type) bool {
+	return identical(x, y, false)
+}
+
+// Compatible returns if two types declared in different inputs are the same,
+// it is like Identical, except that named types are the same if they have
+// the same name since every input has its own declaration of them.
+// An array of unknown length and a void pointer are also compatible with
+// any array of the same element type and any pointer.
+func Compatible(x, y Type) bool {
+	return identical(x, y, true)
+}
+
+func identical(x, y Type, byName bool) bool {
 	if x == y {
 		return true
 	}
@@ -59,7 +77,10 @@ func Identical(x, y Type) bool {
 	case *Array:
 		// Two arrays are the same if they have the same length and underlying type
 		if y, ok := y.(*Array); ok {
-			return x.len == y.len && Identical(x.elem, y.elem)
+			if byName && (x.len < 0 || y.len < 0) {
+				return identical(x.elem, y.elem, byName)
+			}
+			return x.len == y.len && identical(x.elem, y.elem, byName)
 		}
 
 	case *Basic:
@@ -75,7 +96,7 @@ func Identical(x, y Type) bool {
 			if x.NumFields() == y.NumFields() {
 				for i, f := range x.fields {
 					g := y.fields[i]
-					if f.name != g.name || !Identical(f.typ, g.typ) {
+					if f.name != g.name || !identical(f.typ, g.typ, byName) {
 						return false
 					}
 				}
@@ -86,7 +107,10 @@ func Identical(x, y Type) bool {
 	case *Pointer:
 		// Two pointer types are identical if they have identical base types.
 		if y, ok := y.(*Pointer); ok {
-			return Identical(x.base, y.base)
+			if byName && (x.base == Typ[Void] || y.base == Typ[Void]) {
+				return true
+			}
+			return identical(x.base, y.base, byName)
 		}
 
 	case *Tuple:
@@ -97,7 +121,7 @@ func Identical(x, y Type) bool {
 				if x != nil {
 					for i, v := range x.vars {
 						w := y.vars[i]
-						if !Identical(v.typ, w.typ) {
+						if !identical(v.typ, w.typ, byName) {
 							return false
 						}
 					}
@@ -113,14 +137,17 @@ func Identical(x, y Type) bool {
 		// names are not required to match.
 		if y, ok := y.(*Signature); ok {
 			return x.variadic == y.variadic &&
-				Identical(x.params, y.params) &&
-				Identical(x.result.typ, y.result.typ)
+				identical(x.params, y.params, byName) &&
+				identical(x.result.typ, y.result.typ, byName)
 		}
 
 	case *Named:
 		// Two named types are identical if their type name
 		// originate in the same type declaration.
 		if y, ok := y.(*Named); ok {
+			if byName {
+				return x.obj.name == y.obj.name
+			}
 			return x.obj == y.obj
 		}
 
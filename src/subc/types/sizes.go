@@ -12,6 +12,32 @@ type Sizes interface {
 	Sizeof(T Type) int64
 }
 
+// StdTypes are the basic types that a target defines the types of the C
+// library with. Size is the type of sizeof and size_t, Ptrdiff the type of
+// the difference of two pointers and ptrdiff_t, and Wchar the type of
+// wchar_t. The ones left Invalid are int.
+type StdTypes struct {
+	Size    BasicType
+	Ptrdiff BasicType
+	Wchar   BasicType
+}
+
+// SizeT returns the type of size_t.
+func (s StdTypes) SizeT() *Basic { return s.basic(s.Size) }
+
+// PtrdiffT returns the type of ptrdiff_t.
+func (s StdTypes) PtrdiffT() *Basic { return s.basic(s.Ptrdiff) }
+
+// WcharT returns the type of wchar_t.
+func (s StdTypes) WcharT() *Basic { return s.basic(s.Wchar) }
+
+func (s StdTypes) basic(t BasicType) *Basic {
+	if t == Invalid {
+		return Typ[Int]
+	}
+	return Typ[t]
+}
+
 // StdSizes is a convenience type for creating commonly used Sizes.
 // It makes the following simplifying assumptions:
 //
@@ -21,6 +47,8 @@ type Sizes interface {
 //	  field's size. As with all element types, if the struct is used
 //	  in an array its size must first be aligned to a multiple of the
 //	  struct's alignment. All alignments are aligned against WordSize.
+//	- int has size IntSize and long has size LongSize, unless they
+//	  are 0 and int is WordSize and long the size of an int.
 //	- All other types have size WordSize.
 //	- Arrays and structs are aligned per spec definition; all other
 //	  types are naturally aligned with a maximum alignment MaxAlign.
@@ -30,6 +58,8 @@ type Sizes interface {
 type StdSizes struct {
 	WordSize int64 // word size in bytes - must be >= 2
 	MaxAlign int64 // maximum alignment in bytes - must be >= 1
+	IntSize  int64 // size of an int in bytes, 0 for WordSize
+	LongSize int64 // size of a long in bytes, 0 for the size of an int
 }
 
 func (s *StdSizes) Alignof(T Type) int64 {
@@ -50,6 +80,9 @@ func (s *StdSizes) Alignof(T Type) int64 {
 				max = a
 			}
 		}
+		if t.pack > 0 && max > t.pack {
+			max = t.pack
+		}
 		return max
 	}
 	a := s.Sizeof(T) // may be 0
@@ -63,9 +96,25 @@ func (s *StdSizes) Alignof(T Type) int64 {
 }
 
 // Offsetof returns the offset of set of fields.
-// All of the fields are aligned to the nearest word size boundary.
+// All of the fields are aligned to the nearest word size boundary,
+// unless they are of a record packed by #pragma pack.
 func (s *StdSizes) Offsetsof(fields []*Var) []int64 {
 	offsets := make([]int64, len(fields))
+	if len(fields) > 0 && fields[0].pack > 0 {
+		// the fields are aligned as they are in other compilers,
+		// but to no more than the packing
+		var o int64
+		for i, f := range fields {
+			a := s.Alignof(f.typ)
+			if a > f.pack {
+				a = f.pack
+			}
+			o = align(o, a)
+			offsets[i] = o
+			o += s.Sizeof(f.typ)
+		}
+		return offsets
+	}
 	intSize := s.Sizeof(Typ[Int])
 	var o int64
 	for i, f := range fields {
@@ -81,6 +130,10 @@ func (s *StdSizes) Sizeof(T Type) int64 {
 	switch t := T.Underlying().(type) {
 	case *Basic:
 		k := t.typ
+		if t == Typ[Long] {
+			// a long is an int to the checker, but it can be wider
+			k = Long
+		}
 		switch k {
 		case Bool:
 			return 1
@@ -89,9 +142,15 @@ func (s *StdSizes) Sizeof(T Type) int64 {
 		case Short:
 			return 2
 		case Int:
+			if s.IntSize > 0 {
+				return s.IntSize
+			}
 			return s.WordSize
 		case Long:
-			return 8
+			if s.LongSize > 0 {
+				return s.LongSize
+			}
+			return s.Sizeof(Typ[Int])
 		case Float:
 			return 4
 		case Double:
@@ -104,8 +163,10 @@ func (s *StdSizes) Sizeof(T Type) int64 {
 			return s.WordSize
 		}
 	case *Array:
+		// arrays of unknown size, like a flexible array
+		// member, take no space
 		n := t.len
-		if n == 0 {
+		if n <= 0 {
 			return 0
 		}
 		a := s.Alignof(t.elem)
@@ -139,10 +200,19 @@ func (s *StdSizes) Sizeof(T Type) int64 {
 			}
 			t.offsets = nil
 
+			if t.pack > 0 {
+				usize = align(usize, s.Alignof(t))
+			}
 			return usize
 		}
 
-		return offsets[n-1] + s.Sizeof(t.fields[n-1].typ)
+		size := offsets[n-1] + s.Sizeof(t.fields[n-1].typ)
+		if t.pack > 0 {
+			// a packed record is padded to its alignment like it
+			// is in other compilers, so the arrays of it are too
+			size = align(size, s.Alignof(t))
+		}
+		return size
 	}
 	return s.WordSize // catch-all
 }
@@ -33,15 +33,16 @@ func (c *checker) checkFuncBody(params []*ast.FieldDecl, paramVars []*Var, decls
 		}
 	}
 
-	// declare all locals
-	c.declList(decls)
-
-	// declare all labels
+	// declare all labels, before the locals whose initializers
+	// can take their addresses
 	for _, l := range labels {
 		label := NewLabel(l.Span().Start, l.Label.Name)
 		c.declare(Lab, c.scope, l.Label, label, scan.NoPos)
 	}
 
+	// declare all locals
+	c.declList(decls)
+
 	// parse statements
 	c.stmtList(0, body.Stmt)
 }
@@ -73,6 +74,10 @@ func (c *checker) stmt(ctx stmtContext, s ast.Stmt) {
 
 	pos := s.Span().Start
 	inner := ctx
+	defer func(ctx stmtContext) {
+		c.stmtCtx = ctx
+	}(c.stmtCtx)
+	c.stmtCtx = ctx
 
 	c.recordScope(s, c.scope)
 	switch s := s.(type) {
@@ -107,6 +112,13 @@ func (c *checker) stmt(ctx stmtContext, s ast.Stmt) {
 		c.expr(&x, s.X)
 
 	case *ast.GotoStmt:
+		if s.X != nil {
+			c.expr(&x, s.X)
+			if x.mode != invalid && !isPointer(x.typ) {
+				c.errorf(pos, "goto * needs a pointer, not %v", &x)
+			}
+			break
+		}
 		obj, _ := c.scope.LookupParent(Lab, s.Label.Name, scan.NoPos)
 		if obj == nil {
 			c.errorf(pos, "goto cannot jump to non-existent label %v", s.Label.Name)
@@ -149,23 +161,33 @@ func (c *checker) stmt(ctx stmtContext, s ast.Stmt) {
 			switch n := n.(type) {
 			case *ast.CaseClause:
 				if n.Value != nil {
-					c.expr(&x, n.Value)
-					xpos := x.pos()
-					if x.typ == Typ[Invalid] {
+					lo, ok := c.caseValue(&x, n.Value)
+					if !ok {
 						continue
 					}
-
-					_, err := strconv.Atoi(x.val.String())
-					if x.mode != constant_ || err != nil {
-						c.errorf(xpos, "non-constant integer in case statement")
-						continue
+					xpos := x.pos()
+					hi := lo
+					if n.High != nil {
+						if hi, ok = c.caseValue(&x, n.High); !ok {
+							continue
+						}
+						switch {
+						case hi < lo:
+							c.warnf(xpos, "empty case range")
+						case hi-lo >= MaxCaseRange || hi-lo < 0:
+							c.errorf(xpos, "case range of %d values, more than %d", hi-lo+1, MaxCaseRange)
+							continue
+						}
 					}
 
-					if cpos, found := sawCases[x.val]; found {
-						c.errorf(xpos, "duplicate case value")
-						c.errorf(cpos, "\tpreviously used here")
-					} else {
-						sawCases[x.val] = n.Case.Span().Start
+					for v := lo; v <= hi; v++ {
+						val := constant.MakeInt64(int64(v))
+						if cpos, found := sawCases[val]; found {
+							c.errorf(xpos, "duplicate case value")
+							c.errorf(cpos, "\tpreviously used here")
+							break
+						}
+						sawCases[val] = n.Case.Span().Start
 					}
 				} else if defaultPos != scan.NoPos {
 					c.errorf(xpos, "multiple defaults in one switch")
@@ -199,6 +221,68 @@ func (c *checker) stmt(ctx stmtContext, s ast.Stmt) {
 	}
 }
 
+// stmtExpr type checks a statement expression, the statements in it are
+// in the context of the statement that it is in. Its value is the value
+// of the last statement if it is an expression, it has no value otherwise.
+func (c *checker) stmtExpr(x *operand, e *ast.StmtExpr) {
+	c.recordScope(e.Body, c.scope)
+	c.openScope(e.Body)
+	defer c.closeScope()
+	c.declList(e.Decls)
+
+	list := e.Body.Stmt
+	last, ok := ast.Stmt(nil), false
+	if len(list) > 0 {
+		last, ok = list[len(list)-1].(*ast.ExprStmt)
+	}
+	if !ok {
+		c.stmtList(c.stmtCtx, list)
+		x.mode = novalue
+		x.typ = Typ[Void]
+		x.expr = e
+		return
+	}
+
+	c.stmtList(c.stmtCtx, list[:len(list)-1])
+	c.recordScope(last, c.scope)
+	c.expr(x, last.(*ast.ExprStmt).X)
+	switch {
+	case x.mode == invalid || x.mode == novalue:
+		x.expr = e
+		return
+	case isRecord(x.typ):
+		c.errorf(e.Span().Start, "statement expression of type %v, the value must be a scalar", x.typ)
+		x.mode = invalid
+		return
+	}
+	// the value is computed when the statements run, even when
+	// it is a constant
+	x.mode = value
+	x.val = nil
+	x.expr = e
+}
+
+// MaxCaseRange is the largest number of values that a case range can
+// have, each of them takes an entry in the table of the switch.
+const MaxCaseRange = 1 << 12
+
+// caseValue checks the value e of a case label, which must be a
+// constant integer.
+func (c *checker) caseValue(x *operand, e ast.Expr) (int, bool) {
+	c.expr(x, e)
+	c.octalLits(e)
+	if x.typ == Typ[Invalid] {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(x.val.String())
+	if x.mode != constant_ || err != nil {
+		c.errorf(x.pos(), "non-constant integer in case statement")
+		return 0, false
+	}
+	return n, true
+}
+
 // simpleStmt types check a statement and just skips it if it is a nil.
 func (c *checker) simpleStmt(s ast.Stmt) {
 	if s != nil {
@@ -80,6 +80,7 @@ type Record struct {
 	union   bool
 	fields  []*Var
 	offsets []int64
+	pack    int64 // the alignment given by #pragma pack, 0 if none
 }
 
 // Enum represent enums.
@@ -160,7 +161,7 @@ func NewEnum(values []*Const) *Enum {
 
 // NewRecord creates a new record.
 func NewRecord(union bool, fields []*Var) *Record {
-	return &Record{union, fields, nil}
+	return &Record{union: union, fields: fields}
 }
 
 // NewPointer creates a new pointer.
@@ -84,6 +84,7 @@ func (c *checker) typExprInternal(e ast.Expr) Type {
 		// defaults to int if there is no type, for declarations such as
 		// volatile count = 0; and such
 		if e.Type == nil {
+			c.implicitf(e.Span().Start, "type defaults to int in declaration of %s", e.Name.Name)
 			return Typ[Int]
 		}
 		return c.typExpr(e.Type)
@@ -160,6 +161,7 @@ loop:
 			length := int64(-1)
 			if m.Len != nil {
 				c.constExpr(&x, m.Len)
+				c.octalLits(m.Len)
 
 				if x.val == nil || x.val.Type() != constant.Int {
 					c.errorf(pos, "array length is not a constant integer")
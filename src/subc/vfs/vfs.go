@@ -0,0 +1,251 @@
+// Package vfs provides the file system abstraction used by the toolchain
+// for all of its file access, so it can run against the host file system
+// or entirely in memory.
+package vfs
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FS is a file system that can be read from and written to.
+// Unlike fs.FS, names are passed through as given so host
+// paths such as absolute paths work with the OS file system.
+type FS interface {
+	fs.FS
+
+	// Create creates or truncates the named file for writing.
+	Create(name string) (io.WriteCloser, error)
+
+	// Remove removes the named file.
+	Remove(name string) error
+
+	// MkdirAll creates a directory along with any parents it needs.
+	MkdirAll(name string) error
+}
+
+// OS returns the file system of the host operating system.
+func OS() FS {
+	return osFS{}
+}
+
+// osFS is the host operating system file system.
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error)          { return os.Open(name) }
+func (osFS) Create(name string) (io.WriteCloser, error) { return os.Create(name) }
+func (osFS) Remove(name string) error                   { return os.Remove(name) }
+func (osFS) MkdirAll(name string) error                 { return os.MkdirAll(name, 0755) }
+
+// MemFS is an in memory file system, it is safe for concurrent use.
+// The zero value is an empty file system ready to use.
+type MemFS struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+// NewMemFS creates a file system holding files, keyed by their name.
+func NewMemFS(files map[string][]byte) *MemFS {
+	m := &MemFS{}
+	for name, data := range files {
+		m.WriteFile(name, data)
+	}
+	return m
+}
+
+// clean turns name into the key used for the file.
+func clean(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// WriteFile sets the contents of the named file.
+func (m *MemFS) WriteFile(name string, data []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.files == nil {
+		m.files = make(map[string][]byte)
+	}
+	m.files[clean(name)] = append([]byte(nil), data...)
+}
+
+// ReadFile returns the contents of the named file.
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[clean(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return append([]byte(nil), data...), nil
+}
+
+// Names returns the sorted names of all the files.
+func (m *MemFS) Names() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var names []string
+	for name := range m.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *MemFS) Open(name string) (fs.File, error) {
+	data, err := m.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return &memFile{
+		Reader: bytes.NewReader(data),
+		info:   memInfo{name: path.Base(clean(name)), size: int64(len(data))},
+	}, nil
+}
+
+func (m *MemFS) Create(name string) (io.WriteCloser, error) {
+	m.WriteFile(name, nil)
+	return &memWriter{fs: m, name: name}, nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := clean(name)
+	if _, ok := m.files[key]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(m.files, key)
+	return nil
+}
+
+// MkdirAll does nothing, directories are implied by the file names.
+func (m *MemFS) MkdirAll(name string) error {
+	return nil
+}
+
+// memFile is a file opened for reading from a MemFS.
+type memFile struct {
+	*bytes.Reader
+	info memInfo
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memFile) Close() error               { return nil }
+
+// memWriter is a file opened for writing to a MemFS,
+// the contents are stored when it is closed.
+type memWriter struct {
+	bytes.Buffer
+	fs   *MemFS
+	name string
+}
+
+func (w *memWriter) Close() error {
+	w.fs.WriteFile(w.name, w.Bytes())
+	return nil
+}
+
+// memInfo describes a file in a MemFS.
+type memInfo struct {
+	name string
+	size int64
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) Mode() fs.FileMode  { return 0644 }
+func (i memInfo) ModTime() time.Time { return time.Time{} }
+func (i memInfo) IsDir() bool        { return false }
+func (i memInfo) Sys() interface{}   { return nil }
+
+// Cached returns a file system that keeps the contents of the regular
+// files read from the host file system fsys in memory, so a process
+// that reads the same headers for many compilations only reads them
+// once. The files are kept by their absolute name, as the working
+// directory can change between the compilations. A file is read again
+// when its size or its modification time changed, and it is dropped
+// when it is written or removed through the cache. It is safe for
+// concurrent use.
+func Cached(fsys FS) FS {
+	return &cachedFS{FS: fsys, files: make(map[string]cachedFile)}
+}
+
+// cachedFS is a file system that keeps the files read in memory.
+type cachedFS struct {
+	FS
+	mu    sync.Mutex
+	files map[string]cachedFile
+}
+
+// cachedFile is a file kept by a cachedFS, with the size and the
+// modification time it had when it was read.
+type cachedFile struct {
+	data    []byte
+	size    int64
+	modTime time.Time
+}
+
+func (c *cachedFS) Open(name string) (fs.File, error) {
+	f, err := c.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return f, err
+	}
+
+	key, err := filepath.Abs(name)
+	if err != nil {
+		return f, nil
+	}
+	c.mu.Lock()
+	cf, ok := c.files[key]
+	c.mu.Unlock()
+	if !ok || cf.size != info.Size() || !cf.modTime.Equal(info.ModTime()) {
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		cf = cachedFile{data: data, size: info.Size(), modTime: info.ModTime()}
+		c.mu.Lock()
+		c.files[key] = cf
+		c.mu.Unlock()
+	} else {
+		f.Close()
+	}
+	return &memFile{
+		Reader: bytes.NewReader(cf.data),
+		info:   memInfo{name: info.Name(), size: int64(len(cf.data))},
+	}, nil
+}
+
+func (c *cachedFS) Create(name string) (io.WriteCloser, error) {
+	c.forget(name)
+	return c.FS.Create(name)
+}
+
+func (c *cachedFS) Remove(name string) error {
+	c.forget(name)
+	return c.FS.Remove(name)
+}
+
+// forget drops the file name from the cache.
+func (c *cachedFS) forget(name string) {
+	key, err := filepath.Abs(name)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.files, key)
+}
@@ -0,0 +1,170 @@
+// Command abidoc prints the ABI of the targets as the compiler sees it:
+// the sizes and alignments of the types, the types of size_t, ptrdiff_t
+// and wchar_t, the layout of the structs, how the arguments are passed
+// and how the code uses the registers. It takes
+// them from the sizes and the register tables of the backends, so the
+// reference that the runtimes and the code calling into SubC programs are
+// written against can't go out of date. The targets with a narrower int
+// are printed once for each -int-size they take.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"text/scanner"
+
+	"subc/compile/arch"
+	"subc/compile/arch/amd64"
+	"subc/compile/arch/arm6"
+	"subc/compile/arch/darwinamd64"
+	"subc/compile/arch/i386"
+	"subc/compile/arch/i8086"
+	"subc/compile/arch/mips"
+	"subc/types"
+)
+
+// target is a target of scc, by the -arch and -os it is chosen with.
+type target struct {
+	name string
+	new  func() *arch.Emitter
+}
+
+var targets = []target{
+	{"amd64", func() *arch.Emitter { return amd64.NewEmitter(ioutil.Discard) }},
+	{"amd64 -os darwin", func() *arch.Emitter { return darwinamd64.NewEmitter(ioutil.Discard) }},
+	{"i386", func() *arch.Emitter { return i386.NewEmitter(ioutil.Discard) }},
+	{"arm6", func() *arch.Emitter { return arm6.NewEmitter(ioutil.Discard) }},
+	{"8086", func() *arch.Emitter { return i8086.NewEmitter(ioutil.Discard) }},
+	{"mips", func() *arch.Emitter { return mips.NewEmitter(ioutil.Discard) }},
+}
+
+var flags struct {
+	Arch   string
+	Output string
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("")
+	parseFlags()
+
+	var b strings.Builder
+	n := 0
+	for _, t := range targets {
+		if flags.Arch != "" && flags.Arch != strings.Fields(t.name)[0] {
+			continue
+		}
+		sizes := append([]int{0}, t.new().IntSizes...)
+		for _, size := range sizes {
+			e := t.new()
+			name := t.name
+			if size != 0 {
+				ck(e.SetInt(size))
+				name += fmt.Sprintf(" -int-size %d", 8*size)
+			}
+			if n++; n > 1 {
+				b.WriteString("\n")
+			}
+			describe(&b, name, e)
+		}
+	}
+	if n == 0 {
+		log.Fatalf("unknown architecture %v", flags.Arch)
+	}
+
+	if flags.Output == "" {
+		fmt.Print(b.String())
+		return
+	}
+	ck(ioutil.WriteFile(flags.Output, []byte(b.String()), 0644))
+}
+
+func parseFlags() {
+	flag.StringVar(&flags.Arch, "arch", "", "print only the targets of the machine architecture [amd64 | i386 | arm6 | 8086 | mips]")
+	flag.StringVar(&flags.Output, "o", "", "write the reference to a file instead of the standard output")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() > 0 {
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [options]\n", os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+// describe writes the ABI of the target that emitter e generates code for.
+func describe(b *strings.Builder, name string, e *arch.Emitter) {
+	fmt.Fprintf(b, "%s\n", name)
+	line := func(what, format string, args ...interface{}) {
+		fmt.Fprintf(b, "\t%-18s%s\n", what, fmt.Sprintf(format, args...))
+	}
+
+	order := "little endian"
+	if e.BigEndian() {
+		order = "big endian"
+	}
+	line("byte order", "%s", order)
+	line("word", "%d bytes", e.Word())
+
+	voidPtr := types.NewPointer(types.Typ[types.Void], nil)
+	for _, t := range []struct {
+		name string
+		typ  types.Type
+	}{
+		{"char", types.Typ[types.Char]},
+		{"short", types.Typ[types.Short]},
+		{"int", types.Typ[types.Int]},
+		{"long", types.Typ[types.Long]},
+		{"pointer", voidPtr},
+		{"function pointer", types.NewPointer(types.NewSignature(nil, nil, false), nil)},
+	} {
+		line(t.name, "size %d, align %d", e.Sizes.Sizeof(t.typ), e.Sizes.Alignof(t.typ))
+	}
+	for _, t := range []struct {
+		name string
+		typ  *types.Basic
+	}{
+		{"size_t", e.StdTypes.SizeT()},
+		{"ptrdiff_t", e.StdTypes.PtrdiffT()},
+		{"wchar_t", e.StdTypes.WcharT()},
+	} {
+		line(t.name, "%s, size %d", t.typ, e.Sizes.Sizeof(t.typ))
+	}
+
+	var pos scanner.Position
+	char := types.NewField(pos, "a", types.Typ[types.Char])
+	chars := types.NewRecord(false, []*types.Var{char, types.NewField(pos, "b", types.Typ[types.Char])})
+	offsets := e.Sizes.Offsetsof([]*types.Var{char, types.NewField(pos, "b", types.Typ[types.Char])})
+	line("struct fields", "padded to a multiple of %d bytes, struct { char a, b; } has b at %d, size %d, align %d",
+		offsets[1], offsets[1], e.Sizes.Sizeof(chars), e.Sizes.Alignof(chars))
+
+	r := e.Registers
+	w := e.Word()
+	line("arguments", "%d byte words pushed from the last to the first, the first at %d(%s)", w, 2*w, r.Frame)
+	line("", "the return address at %d(%s), the caller pops the arguments", w, r.Frame)
+	line("result", "in %s", r.Acc)
+	line("calls", "through %s for a function pointer", r.Acc)
+	line("frame pointer", "%s", r.Frame)
+	line("stack pointer", "%s, aligned to %d bytes", r.Stack, w)
+	line("scratch", "%s", strings.Join(append([]string{r.Acc}, r.Scratch...), " "))
+	preserved := append([]string{r.Frame, r.Stack}, r.Vars...)
+	line("callee saved", "%s", strings.Join(preserved, " "))
+	if len(r.Vars) > 0 {
+		line("register vars", "%s, the first %d int and pointer locals declared register, unless -compat", strings.Join(r.Vars, " "), e.RegVars)
+	} else {
+		line("register vars", "none, they are kept in the frame")
+	}
+}
+
+func ck(err error) {
+	if err != nil {
+		log.Fatal(err)
+	}
+}
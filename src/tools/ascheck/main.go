@@ -0,0 +1,483 @@
+// Command ascheck checks the encodings of the assembler against the GNU
+// assembler. It makes up every form of the instructions of the amd64
+// assembler, from the registers, the immediates and the memory operands
+// that encode differently, and keeps the ones the assembler takes. The
+// forms are assembled by both assemblers in one file, each one after a
+// symbol of its own, and the bytes of the ones that differ are printed.
+// The object of the assembler is then disassembled with objdump and the
+// instructions it prints are assembled again, which must give the same
+// bytes, so the encodings and what the assembler parses agree.
+package main
+
+import (
+	"bytes"
+	"context"
+	"debug/elf"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"subc/asm"
+)
+
+var flags struct {
+	AS      string
+	Objdump string
+	Op      string
+	Verbose bool
+}
+
+var (
+	status = 0
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("")
+	parseFlags()
+
+	var forms []string
+	for _, op := range mnemonics {
+		if flags.Op != "" && op != flags.Op {
+			continue
+		}
+		for _, form := range expand(op) {
+			if takes(form) {
+				forms = append(forms, form)
+			}
+		}
+	}
+	if len(forms) == 0 {
+		log.Fatal("no forms to check")
+	}
+
+	dir, err := ioutil.TempDir("", "ascheck")
+	ck(err)
+	err = check(dir, forms)
+	os.RemoveAll(dir)
+	ck(err)
+	os.Exit(status)
+}
+
+// check assembles the forms with both assemblers in the directory dir
+// and compares them.
+func check(dir string, forms []string) error {
+	forms, failed := ourForms(forms)
+	for _, form := range failed {
+		fmt.Printf("%s\n\ttaken alone, but not with the other forms\n", form)
+		status = 1
+	}
+	forms, rejected, err := gnuForms(dir, forms)
+	if err != nil {
+		return err
+	}
+	if flags.Verbose {
+		for _, form := range rejected {
+			fmt.Fprintf(os.Stderr, "%s\n\ttaken, but not by %s\n", form, flags.AS)
+		}
+	}
+
+	src, _ := source(forms)
+	ours, err := assemble(src)
+	if err != nil {
+		return err
+	}
+	theirs, err := gnuAssemble(dir, src)
+	if err != nil {
+		return err
+	}
+	compare(forms, ours, theirs)
+	if flags.Verbose {
+		fmt.Fprintf(os.Stderr, "%d forms checked\n", len(forms))
+	}
+	if flags.Objdump == "" {
+		return nil
+	}
+	return roundTrip(dir, src, forms, ours)
+}
+
+func parseFlags() {
+	flag.StringVar(&flags.AS, "as", "as", "the GNU assembler to check against")
+	flag.StringVar(&flags.Objdump, "objdump", "objdump", "the GNU objdump to disassemble the forms with, none if empty")
+	flag.StringVar(&flags.Op, "op", "", "check only the forms of the instruction")
+	flag.BoolVar(&flags.Verbose, "v", false, "print the forms that only the assembler takes and the number of forms checked")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 0 {
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [options]\n", os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+// mnemonics are the instructions of the amd64 assembler.
+var mnemonics = []string{
+	"addq", "andq", "call", "cld", "cli", "cmpq", "cqo", "decb", "decq",
+	"divq", "hlt", "idivq", "imulq", "incb", "incq", "int", "ja", "jae",
+	"jb", "jbe", "je", "jg", "jge", "jl", "jle", "jmp", "jne", "jnz", "jz",
+	"leaq", "lodsl", "lodsq", "loop", "loope", "loopne", "movb", "movl",
+	"movq", "movsbq", "movswq", "movzbq", "movzwq", "negq", "notq", "orq",
+	"popq", "pushq", "ret", "sarq", "sbbq", "shlq", "shrq", "sti", "subq",
+	"syscall", "xchgq", "xorq",
+}
+
+// operands are the operands of the forms by their kind, the ones that
+// encode differently: the registers that need a REX prefix or a SIB
+// byte, the immediates at the limits of their sizes, and the memory
+// operands with and without displacements and an index.
+var operands = map[byte][]string{
+	'q': {"%rax", "%rcx", "%rdx", "%rbx", "%rsp", "%rbp", "%rsi", "%rdi", "%r8", "%r12", "%r13", "%r15"},
+	'l': {"%eax", "%ecx", "%esp", "%ebp", "%edi"},
+	'w': {"%ax", "%cx"},
+	'b': {"%al", "%cl", "%dl", "%bl"},
+	'c': {"%cl"},
+	'i': {"$0", "$1", "$-1", "$127", "$128", "$-128", "$-129", "$255", "$32767", "$65536", "$2147483647", "$-2147483648", "$4294967296", "$sym"},
+	'm': {"(%rax)", "(%rsp)", "(%rbp)", "(%r12)", "(%r13)", "8(%rax)", "-8(%rbp)", "128(%rbp)", "-129(%rsp)", "16(%r8)", "(%rax,%rcx)", "8(%rax,%rcx,8)", "(%rsp,%rbp,2)", "sym"},
+	't': {"self", "far", "sym", "*%rax", "*%r9", "*(%rax)", "*8(%rbp)"},
+}
+
+// branches are the instructions that take a target, the forms of the
+// others have no targets.
+var branches = map[string]bool{
+	"call": true, "ja": true, "jae": true, "jb": true, "jbe": true, "je": true,
+	"jg": true, "jge": true, "jl": true, "jle": true, "jmp": true, "jne": true,
+	"jnz": true, "jz": true, "loop": true, "loope": true, "loopne": true,
+}
+
+// short are the branches that only reach the targets near them, the
+// assembler can't relocate them so they only branch to themselves.
+var short = map[string]bool{"loop": true, "loope": true, "loopne": true}
+
+// shapes are the shapes of the operands of the forms, by kind.
+var shapes = []string{"", "q", "l", "b", "m", "i", "t", "qq", "iq", "mq", "qm", "im", "ll", "ml", "lm", "il", "bb", "mb", "bm", "ib", "wq", "cq", "iqq", "imq"}
+
+// expand returns the forms of the instruction op of all of the shapes,
+// most of them the assembler doesn't take.
+func expand(op string) []string {
+	var forms []string
+	for _, shape := range shapes {
+		if strings.Contains(shape, "t") != branches[op] {
+			continue
+		}
+		lists := make([][]string, len(shape))
+		for i := range shape {
+			lists[i] = operands[shape[i]]
+		}
+		var rec func(args []string)
+		rec = func(args []string) {
+			if len(args) == len(lists) {
+				if short[op] && (len(args) != 1 || args[0] != "self") {
+					return
+				}
+				if len(args) == 0 {
+					forms = append(forms, op)
+				} else {
+					forms = append(forms, op+"\t"+strings.Join(args, ", "))
+				}
+				return
+			}
+			for _, arg := range lists[len(args)] {
+				rec(append(args[:len(args):len(args)], arg))
+			}
+		}
+		rec(nil)
+	}
+	return forms
+}
+
+// takes reports whether the assembler assembles form alone.
+func takes(form string) bool {
+	src := "\t.text\n" + form + "\n"
+	return asm.Assemble(context.Background(), asm.Config{Arch: "amd64", OS: "linux"}, "form.s", ioutil.Discard, []byte(src)) == nil
+}
+
+// source returns the assembly of the forms, each after the symbol f<n>
+// that marks where its bytes start and the label l<n>, and the form on
+// each line of the instructions. The target self of a branch is the
+// label of the form itself and far is a label after the form and 256
+// bytes of zeros, which a short branch can't reach. The labels aren't
+// global, so the branches to them need no relocations.
+func source(forms []string) ([]byte, map[int]int) {
+	var b bytes.Buffer
+	lines := make(map[int]int)
+	line := 1
+	b.WriteString("\t.text\n")
+	for i, form := range forms {
+		form = target.ReplaceAllString(form, fmt.Sprintf("${1}l%d", i))
+		far := strings.HasSuffix(form, "\tfar")
+		if far {
+			form = strings.TrimSuffix(form, "far") + fmt.Sprintf("r%d", i)
+		}
+		fmt.Fprintf(&b, "\t.globl\tf%d\nf%d:\nl%d:\n\t%s\n", i, i, i, form)
+		line += 4
+		lines[line] = i
+		if far {
+			b.WriteString(strings.Repeat("\t.quad\t0\n", 32))
+			fmt.Fprintf(&b, "r%d:\n", i)
+			line += 33
+		}
+	}
+	fmt.Fprintf(&b, "\t.globl\tf%d\nf%d:\n", len(forms), len(forms))
+	return b.Bytes(), lines
+}
+
+// target matches the target self of a branch.
+var target = regexp.MustCompile(`(\t)self$`)
+
+// ourLine matches the positions of the errors of the assembler.
+var ourLine = regexp.MustCompile(`forms\.s:(\d+)`)
+
+// ourForms returns the forms that the assembler takes with the others,
+// and the ones it takes alone but not with them.
+func ourForms(forms []string) (taken, failed []string) {
+	for {
+		src, lines := source(forms)
+		err := asm.Assemble(context.Background(), asm.Config{Arch: "amd64", OS: "linux"}, "forms.s", ioutil.Discard, src)
+		if err == nil {
+			return forms, failed
+		}
+		bad := make(map[int]bool)
+		for _, m := range ourLine.FindAllStringSubmatch(err.Error(), -1) {
+			line, _ := strconv.Atoi(m[1])
+			if n, ok := lines[line]; ok {
+				bad[n] = true
+			}
+		}
+		if len(bad) == 0 {
+			// the failure isn't of a form, the comparison reports it
+			return forms, failed
+		}
+		var rest []string
+		for i, form := range forms {
+			if bad[i] {
+				failed = append(failed, form)
+			} else {
+				rest = append(rest, form)
+			}
+		}
+		forms = rest
+	}
+}
+
+// assemble assembles src with the assembler and returns the bytes of
+// each form.
+func assemble(src []byte) (map[int][]byte, error) {
+	var obj bytes.Buffer
+	if err := asm.Assemble(context.Background(), asm.Config{Arch: "amd64", OS: "linux"}, "forms.s", &obj, src); err != nil {
+		return nil, err
+	}
+	f, err := elf.NewFile(bytes.NewReader(obj.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	return formBytes(f)
+}
+
+// errorLine matches the lines of the errors of the GNU assembler.
+var errorLine = regexp.MustCompile(`:(\d+): Error:`)
+
+// gnuForms returns the forms that the GNU assembler takes too, and
+// the ones it doesn't.
+func gnuForms(dir string, forms []string) (taken, rejected []string, err error) {
+	name := filepath.Join(dir, "forms.s")
+	src, lines := source(forms)
+	if err := ioutil.WriteFile(name, src, 0644); err != nil {
+		return nil, nil, err
+	}
+	out, _ := exec.Command(flags.AS, "-o", filepath.Join(dir, "forms.o"), name).CombinedOutput()
+	bad := make(map[int]bool)
+	for _, m := range errorLine.FindAllSubmatch(out, -1) {
+		line, _ := strconv.Atoi(string(m[1]))
+		if n, ok := lines[line]; ok {
+			bad[n] = true
+		}
+	}
+	if len(bad) == 0 && len(out) > 0 && bytes.Contains(out, []byte("Error")) {
+		return nil, nil, fmt.Errorf("%s: %s", flags.AS, out)
+	}
+	for i, form := range forms {
+		if bad[i] {
+			rejected = append(rejected, form)
+		} else {
+			taken = append(taken, form)
+		}
+	}
+	return taken, rejected, nil
+}
+
+// gnuAssemble assembles src with the GNU assembler and returns the bytes
+// of each form.
+func gnuAssemble(dir string, src []byte) (map[int][]byte, error) {
+	name := filepath.Join(dir, "forms.s")
+	obj := filepath.Join(dir, "forms.o")
+	if err := ioutil.WriteFile(name, src, 0644); err != nil {
+		return nil, err
+	}
+	if out, err := exec.Command(flags.AS, "-o", obj, name).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%s: %v\n%s", flags.AS, err, out)
+	}
+	f, err := elf.Open(obj)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return formBytes(f)
+}
+
+// formBytes returns the bytes of the text of f between the symbol of
+// each form and the one that follows it.
+func formBytes(f *elf.File) (map[int][]byte, error) {
+	text := f.Section(".text")
+	if text == nil {
+		return nil, fmt.Errorf("no text section")
+	}
+	code, err := text.Data()
+	if err != nil {
+		return nil, err
+	}
+	syms, err := f.Symbols()
+	if err != nil {
+		return nil, err
+	}
+	offs := make(map[int]uint64)
+	for _, s := range syms {
+		if n, err := strconv.Atoi(strings.TrimPrefix(s.Name, "f")); err == nil && strings.HasPrefix(s.Name, "f") {
+			offs[n] = s.Value
+		}
+	}
+	var nums []int
+	for n := range offs {
+		nums = append(nums, n)
+	}
+	sort.Ints(nums)
+	forms := make(map[int][]byte)
+	for i := 0; i+1 < len(nums); i++ {
+		start, end := offs[nums[i]], offs[nums[i+1]]
+		if start > end || end > uint64(len(code)) {
+			return nil, fmt.Errorf("form %d is at %d to %d, out of the text", nums[i], start, end)
+		}
+		forms[nums[i]] = code[start:end]
+	}
+	return forms, nil
+}
+
+// roundTrip disassembles the object that the assembler makes of src
+// with objdump, and assembles each instruction it prints again, which
+// must give the bytes of the form. The branches are left out, objdump
+// prints their targets as addresses, and so are the forms that the
+// assembler doesn't take as objdump prints them, like its movabsq.
+func roundTrip(dir string, src []byte, forms []string, ours map[int][]byte) error {
+	var obj bytes.Buffer
+	if err := asm.Assemble(context.Background(), asm.Config{Arch: "amd64", OS: "linux"}, "forms.s", &obj, src); err != nil {
+		return err
+	}
+	name := filepath.Join(dir, "ours.o")
+	if err := ioutil.WriteFile(name, obj.Bytes(), 0644); err != nil {
+		return err
+	}
+	out, err := exec.Command(flags.Objdump, "-d", "-w", "--no-show-raw-insn", "-M", "suffix", name).Output()
+	if err != nil {
+		return fmt.Errorf("%s: %v", flags.Objdump, err)
+	}
+	insts := disassembly(out)
+
+	var back []string
+	var nums []int
+	for i, form := range forms {
+		if branches[strings.Fields(form)[0]] || len(insts[i]) != 1 {
+			continue
+		}
+		if !takes(insts[i][0]) {
+			if flags.Verbose {
+				fmt.Fprintf(os.Stderr, "%s\n\t%s prints %s, which isn't taken\n", form, flags.Objdump, insts[i][0])
+			}
+			continue
+		}
+		back = append(back, insts[i][0])
+		nums = append(nums, i)
+	}
+	again, _ := source(back)
+	bytesAgain, err := assemble(again)
+	if err != nil {
+		return err
+	}
+	for k, i := range nums {
+		if !bytes.Equal(ours[i], bytesAgain[k]) {
+			fmt.Printf("%s\n\tassembled % x, %s prints %s, which assembles to % x\n", forms[i], ours[i], flags.Objdump, back[k], bytesAgain[k])
+			status = 1
+		}
+	}
+	if flags.Verbose {
+		fmt.Fprintf(os.Stderr, "%d forms disassembled and assembled again\n", len(nums))
+	}
+	return nil
+}
+
+// symLine and instLine match the lines of the disassembly that start
+// the code of a symbol and that hold an instruction.
+var (
+	symLine  = regexp.MustCompile(`^[0-9a-f]+ <(\w+)>:$`)
+	instLine = regexp.MustCompile(`^ *[0-9a-f]+:\t([^#]*)`)
+)
+
+// disassembly returns the instructions that objdump prints after the
+// symbol of each form.
+func disassembly(out []byte) map[int][]string {
+	insts := make(map[int][]string)
+	n := -1
+	for _, line := range strings.Split(string(out), "\n") {
+		if m := symLine.FindStringSubmatch(line); m != nil {
+			n = -1
+			if i, err := strconv.Atoi(strings.TrimPrefix(m[1], "f")); err == nil && strings.HasPrefix(m[1], "f") {
+				n = i
+			}
+			continue
+		}
+		if m := instLine.FindStringSubmatch(line); m != nil && n >= 0 {
+			insts[n] = append(insts[n], strings.TrimSpace(m[1]))
+		}
+	}
+	return insts
+}
+
+// compare prints the forms whose bytes differ.
+func compare(forms []string, ours, theirs map[int][]byte) {
+	for i, form := range forms {
+		if !bytes.Equal(ours[i], theirs[i]) && !equivalent(ours[i], theirs[i]) {
+			fmt.Printf("%s\n\tassembled % x, %s gives % x\n", form, ours[i], flags.AS, theirs[i])
+			status = 1
+		}
+	}
+}
+
+// equivalent reports whether the encodings ours and theirs of a form
+// do the same, though they differ: a shift by 1 with the immediate byte
+// of c1 or without it with d1, and xchg of %rax with itself, which is a
+// nop with the REX.W prefix or without it.
+func equivalent(ours, theirs []byte) bool {
+	switch {
+	case len(ours) == 4 && len(theirs) == 3:
+		return ours[0] == theirs[0] && ours[1] == 0xc1 && theirs[1] == 0xd1 && ours[2] == theirs[2] && ours[3] == 1
+	case bytes.Equal(ours, []byte{0x48, 0x90}):
+		return bytes.Equal(theirs, []byte{0x90})
+	}
+	return false
+}
+
+func ck(err error) {
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,335 @@
+// Command bloat attributes the bytes of ELF objects or of a linked binary
+// to the functions and the variables that take them, and prints them
+// largest first with the sizes of the sections, to find what makes a
+// program big. The symbols of the compiler don't have sizes, so each one
+// takes the bytes up to the next one of its section, passing over the
+// labels of the code and of the string literals. Given the summaries
+// that scc -fsummary writes, the symbols are named by the declarations
+// of the source and their files, and the variables take only the bytes
+// of their sizes, the rest of a section is left to the bytes that no
+// symbol takes.
+package main
+
+import (
+	"bufio"
+	"debug/elf"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"text/tabwriter"
+)
+
+var flags struct {
+	Summary string
+	Top     int
+}
+
+var (
+	status = 0
+)
+
+// unit is the summary of a translation unit that scc -fsummary writes,
+// the fields that the attribution takes.
+type unit struct {
+	File    string `json:"file"`
+	Object  string `json:"object"`
+	Defined []struct {
+		Symbol string `json:"symbol"`
+		Name   string `json:"name"`
+		Kind   string `json:"kind"`
+		Size   uint64 `json:"size"`
+	} `json:"defined"`
+}
+
+// decl is a function or a variable of the source, by its symbol.
+type decl struct {
+	name string
+	kind string
+	size uint64 // the size of a variable, 0 for a function
+	file string
+}
+
+// entry is the bytes that a symbol takes.
+type entry struct {
+	name    string
+	kind    string
+	section string
+	file    string
+	size    uint64
+}
+
+// section is the bytes of a section, the ones the symbols take and
+// the others.
+type section struct {
+	name       string
+	size       uint64
+	attributed uint64
+}
+
+// label matches the labels that the compiler makes for the code and
+// for the string literals, which are local symbols of the objects.
+var label = regexp.MustCompile(`^L[0-9]+$`)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("")
+	parseFlags()
+
+	var units []unit
+	if flags.Summary != "" {
+		var err error
+		units, err = readSummary(flags.Summary)
+		ck(err)
+	}
+
+	var entries []entry
+	var sections []section
+	for _, name := range flag.Args() {
+		e, s, err := attribute(name, units)
+		if err != nil {
+			errf("%s: %v", name, err)
+			continue
+		}
+		entries = append(entries, e...)
+		sections = append(sections, s...)
+	}
+	print(entries, sections)
+	os.Exit(status)
+}
+
+func parseFlags() {
+	flag.StringVar(&flags.Summary, "summary", "", "read the declarations of the sources from the output of scc -fsummary in file")
+	flag.IntVar(&flags.Top, "n", 0, "print only the n largest functions and variables (default all of them)")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [options] file ...\n", os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+// readSummary reads the summaries of the translation units in the file
+// name, a line of JSON for each.
+func readSummary(name string) ([]unit, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var units []unit
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for dec.More() {
+		var u unit
+		if err := dec.Decode(&u); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		units = append(units, u)
+	}
+	return units, nil
+}
+
+// declarations returns the declarations of the file name by their
+// symbols, the ones of its unit for an object and the ones of all the
+// units for a linked binary. A local symbol that more than one unit
+// defines is left out of a binary, which can't tell them apart. It
+// returns nil if there are no summaries.
+func declarations(name string, f *elf.File, units []unit) map[string]decl {
+	if len(units) == 0 {
+		return nil
+	}
+	decls := make(map[string]decl)
+	if f.Type == elf.ET_REL {
+		for _, u := range units {
+			if filepath.Clean(u.Object) == filepath.Clean(name) {
+				add(decls, u, nil)
+			}
+		}
+		return decls
+	}
+	twice := make(map[string]bool)
+	for _, u := range units {
+		add(decls, u, twice)
+	}
+	for sym := range twice {
+		delete(decls, sym)
+	}
+	return decls
+}
+
+// add adds the declarations of the unit u to decls, recording the
+// symbols already there in twice if it isn't nil.
+func add(decls map[string]decl, u unit, twice map[string]bool) {
+	for _, d := range u.Defined {
+		if _, ok := decls[d.Symbol]; ok && twice != nil {
+			twice[d.Symbol] = true
+		}
+		x := decl{name: d.Name, kind: d.Kind, file: u.File}
+		if d.Kind == "variable" {
+			x.size = d.Size
+		}
+		decls[d.Symbol] = x
+	}
+}
+
+// attribute attributes the bytes of the sections of the ELF file name
+// to its symbols.
+func attribute(name string, units []unit) ([]entry, []section, error) {
+	f, err := elf.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	syms, err := f.Symbols()
+	if err != nil && err != elf.ErrNoSymbols {
+		return nil, nil, err
+	}
+	decls := declarations(name, f, units)
+
+	var entries []entry
+	common := section{name: "COMMON"}
+	bySection := make(map[elf.SectionIndex][]elf.Symbol)
+	for _, sym := range syms {
+		switch {
+		case sym.Name == "", elf.ST_TYPE(sym.Info) == elf.STT_FILE, elf.ST_TYPE(sym.Info) == elf.STT_SECTION:
+			continue
+		case sym.Section == elf.SHN_COMMON:
+			// a tentative definition takes its bytes in the bss of the link
+			entries = append(entries, symbolEntry(name, sym, decls, "COMMON", "variable", sym.Size))
+			common.size += sym.Size
+			common.attributed += sym.Size
+			continue
+		case sym.Section == elf.SHN_UNDEF, int(sym.Section) >= len(f.Sections):
+			continue
+		}
+		if boundary(sym, decls) {
+			bySection[sym.Section] = append(bySection[sym.Section], sym)
+		}
+	}
+
+	var sections []section
+	for i, s := range f.Sections {
+		if s.Flags&elf.SHF_ALLOC == 0 || s.Size == 0 {
+			continue
+		}
+		x := section{name: s.Name, size: s.Size}
+		syms := bySection[elf.SectionIndex(i)]
+		sort.SliceStable(syms, func(i, j int) bool { return syms[i].Value < syms[j].Value })
+		for j, sym := range syms {
+			end := s.Addr + s.Size
+			if j+1 < len(syms) {
+				end = syms[j+1].Value
+			}
+			size := end - sym.Value
+			if d, ok := decls[sym.Name]; ok && d.size > 0 && d.size < size {
+				size = d.size
+			} else if sym.Size > 0 && sym.Size < size {
+				size = sym.Size
+			}
+			kind := "variable"
+			if elf.ST_TYPE(sym.Info) == elf.STT_FUNC || s.Flags&elf.SHF_EXECINSTR != 0 {
+				kind = "function"
+			}
+			x.attributed += size
+			entries = append(entries, symbolEntry(name, sym, decls, s.Name, kind, size))
+		}
+		sections = append(sections, x)
+	}
+	if common.size > 0 {
+		sections = append(sections, common)
+	}
+	return entries, sections, nil
+}
+
+// boundary reports whether the symbol sym starts bytes of its own, the
+// functions and the variables of the source if there are declarations
+// and otherwise all the symbols but the labels of the compiler.
+func boundary(sym elf.Symbol, decls map[string]decl) bool {
+	if decls != nil {
+		if _, ok := decls[sym.Name]; ok {
+			return true
+		}
+	}
+	return !label.MatchString(sym.Name)
+}
+
+// symbolEntry returns the entry of the symbol sym of the file name, of
+// kind in section unless its declaration tells otherwise.
+func symbolEntry(name string, sym elf.Symbol, decls map[string]decl, section, kind string, size uint64) entry {
+	e := entry{name: sym.Name, kind: kind, section: section, file: name, size: size}
+	if d, ok := decls[sym.Name]; ok {
+		e.kind, e.file = d.kind, d.file
+		if d.name != "" && d.name != sym.Name {
+			e.name = fmt.Sprintf("%s (%s)", d.name, sym.Name)
+		}
+	}
+	return e
+}
+
+// print prints the entries largest first and the sections of the files.
+func print(entries []entry, sections []section) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].size != entries[j].size {
+			return entries[i].size > entries[j].size
+		}
+		return entries[i].name < entries[j].name
+	})
+	if flags.Top > 0 && len(entries) > flags.Top {
+		entries = entries[:flags.Top]
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "size\t  kind\t  section\t  name\t  file\t\n")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%d\t  %s\t  %s\t  %s\t  %s\t\n", e.size, e.kind, e.section, e.name, e.file)
+	}
+	w.Flush()
+
+	totals := make(map[string]*section)
+	var names []string
+	for _, s := range sections {
+		t := totals[s.name]
+		if t == nil {
+			t = &section{name: s.name}
+			totals[s.name] = t
+			names = append(names, s.name)
+		}
+		t.size += s.size
+		t.attributed += s.attributed
+	}
+	sort.SliceStable(names, func(i, j int) bool { return totals[names[i]].size > totals[names[j]].size })
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "section\t  size\t  symbols\t  other\t\n")
+	var size, attributed uint64
+	for _, name := range names {
+		t := totals[name]
+		fmt.Fprintf(w, "%s\t  %d\t  %d\t  %d\t\n", t.name, t.size, t.attributed, t.size-t.attributed)
+		size += t.size
+		attributed += t.attributed
+	}
+	fmt.Fprintf(w, "total\t  %d\t  %d\t  %d\t\n", size, attributed, size-attributed)
+	w.Flush()
+}
+
+func errf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	status = 1
+}
+
+func ck(err error) {
+	if err != nil {
+		log.Fatal(err)
+	}
+}
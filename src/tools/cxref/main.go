@@ -0,0 +1,265 @@
+// Command cxref prints a cross reference of the global symbols of a set
+// of translation units, listing where each symbol is defined and every
+// place it is referenced.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/scanner"
+	"text/tabwriter"
+
+	"subc/ast"
+	"subc/compile/arch/amd64"
+	"subc/compile/arch/arm6"
+	"subc/compile/arch/i386"
+	"subc/parse"
+	"subc/scan"
+	"subc/types"
+)
+
+var flags struct {
+	Includes []string
+	Arch     string
+	RootDir  string
+	Compat   bool
+}
+
+var (
+	status = 0
+)
+
+// symbol is a global symbol and the places it is used.
+type symbol struct {
+	name  string
+	kind  string
+	defs  []scanner.Position
+	decls []scanner.Position
+	refs  []scanner.Position
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("")
+	parseFlags()
+
+	syms := make(map[string]*symbol)
+	for _, name := range flag.Args() {
+		ek(xref(syms, name))
+	}
+	print(syms)
+	os.Exit(status)
+}
+
+func parseFlags() {
+	includes := flag.String("I", "", "include paths separated by :, also settable via SCCINC environment variable")
+	flag.StringVar(&flags.Arch, "arch", "amd64", "specify machine architecture the sizes are taken from [amd64 | i386 | arm6]")
+	flag.StringVar(&flags.RootDir, "root", os.Getenv("SCCROOT"), "specify the root directory, also settable via SCCROOT environment variable")
+	flag.BoolVar(&flags.Compat, "compat", false, "compatibility mode with the scc compiler")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+	}
+
+	for _, list := range []string{*includes, os.Getenv("SCCINC")} {
+		for _, include := range strings.Split(list, ":") {
+			if include != "" {
+				flags.Includes = append(flags.Includes, include)
+			}
+		}
+	}
+	if flags.RootDir != "" {
+		runtimeDir := filepath.Join(flags.RootDir, "runtime")
+		flags.Includes = append(flags.Includes, filepath.Join(runtimeDir, "include"))
+		flags.Includes = append(flags.Includes, filepath.Join(runtimeDir, flags.Arch, "include"))
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [options] file ...\n", os.Args[0])
+	fmt.Fprintln(os.Stderr, "declarations that are not definitions are marked with a *")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func sizes() (types.Sizes, error) {
+	switch flags.Arch {
+	case "amd64":
+		return amd64.NewEmitter(ioutil.Discard).Sizes, nil
+	case "i386":
+		return i386.NewEmitter(ioutil.Discard).Sizes, nil
+	case "arm6":
+		return arm6.NewEmitter(ioutil.Discard).Sizes, nil
+	}
+	return nil, fmt.Errorf("unknown architecture %v", flags.Arch)
+}
+
+// xref adds the global symbols defined and used by a file.
+func xref(syms map[string]*symbol, name string) error {
+	sizes, err := sizes()
+	if err != nil {
+		return err
+	}
+
+	reader, err := scan.OpenFile(name)
+	if err != nil {
+		return err
+	}
+	scanConfig := scan.DefaultConfig
+	scanConfig.IncludePaths = flags.Includes
+	scanner := scan.New(scanConfig, name, reader)
+	defer scanner.Close()
+
+	prog, err := parse.Parse(parse.Config{Predecl: !flags.Compat}, scanner)
+	if err = frontEndError(err); err != nil {
+		return err
+	}
+	info, err := types.Check(types.Config{Sizes: sizes}, prog)
+	if err = frontEndError(err); err != nil {
+		return err
+	}
+
+	lookup := func(obj types.Object) *symbol {
+		kind := ""
+		switch obj := obj.(type) {
+		case *types.Func, *types.Fwrd:
+			kind = "function"
+		case *types.Var:
+			if obj.Storage() == types.Auto || obj.Storage() == types.LocalStatic {
+				return nil
+			}
+			kind = "variable"
+		default:
+			return nil
+		}
+		if obj.Parent() == nil || obj.Parent().Parent() != nil {
+			return nil
+		}
+
+		key := obj.Name()
+		if v, ok := obj.(*types.Var); ok && v.Storage() == types.GlobalStatic {
+			key += "\x00" + name
+		}
+		if f, ok := obj.(*types.Func); ok && f.Storage() == types.GlobalStatic {
+			key += "\x00" + name
+		}
+		s := syms[key]
+		if s == nil {
+			s = &symbol{name: obj.Name(), kind: kind}
+			syms[key] = s
+		}
+		return s
+	}
+
+	for _, d := range prog.Decls {
+		var id *ast.Ident
+		def := false
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			id, def = d.Name, d.Body != nil
+		case *ast.VarDecl:
+			id, def = d.Name, d.Storage == nil || d.Storage.Type != scan.Extern
+		default:
+			continue
+		}
+		s := lookup(info.Defs[id])
+		switch {
+		case s == nil:
+		case def:
+			s.defs = append(s.defs, id.Pos)
+		default:
+			s.decls = append(s.decls, id.Pos)
+		}
+	}
+
+	for id, obj := range info.Uses {
+		if s := lookup(obj); s != nil {
+			s.refs = append(s.refs, id.Pos)
+		}
+	}
+	return nil
+}
+
+// print writes the cross reference, symbols never
+// defined nor referenced are left out.
+func print(syms map[string]*symbol) {
+	var list []*symbol
+	for _, s := range syms {
+		if len(s.defs) > 0 || len(s.refs) > 0 {
+			list = append(list, s)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].name != list[j].name {
+			return list[i].name < list[j].name
+		}
+		return fmt.Sprint(list[i].defs) < fmt.Sprint(list[j].defs)
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	fmt.Fprintln(w, "SYMBOL\tKIND\tDEFINED\tREFERENCES")
+	for _, s := range list {
+		refs := append(positions(s.decls, "*"), positions(s.refs, "")...)
+		defs := positions(s.defs, "")
+		if len(defs) == 0 {
+			defs = append(defs, "-")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.name, s.kind, strings.Join(defs, " "), strings.Join(refs, " "))
+	}
+	ck(w.Flush())
+}
+
+// positions returns the sorted file:line strings of p with
+// mark appended, each line is only listed once.
+func positions(p []scanner.Position, mark string) []string {
+	sort.Slice(p, func(i, j int) bool {
+		if p[i].Filename != p[j].Filename {
+			return p[i].Filename < p[j].Filename
+		}
+		return p[i].Offset < p[j].Offset
+	})
+
+	var list []string
+	for _, p := range p {
+		s := fmt.Sprintf("%s:%d%s", p.Filename, p.Line, mark)
+		if len(list) == 0 || list[len(list)-1] != s {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// frontEndError prints the warnings from the front end
+// and only returns err if there were errors.
+func frontEndError(err error) error {
+	l, _ := err.(*scan.ErrorList)
+	if l == nil || l.NumErrors > 0 {
+		return err
+	}
+	for _, m := range l.Messages {
+		fmt.Fprintln(os.Stderr, m)
+	}
+	return nil
+}
+
+func ck(err error) {
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func ek(err error) bool {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		status = 1
+		return true
+	}
+	return false
+}
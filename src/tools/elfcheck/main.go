@@ -0,0 +1,302 @@
+// Command elfcheck checks the structure of ELF objects: the sections
+// are within the file and aligned, the symbols are within their sections
+// and the relocations within the sections they apply to and of symbols
+// that exist. It reads the objects with debug/elf, so the objects that
+// the assembler and the compiler write are checked without readelf.
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+var (
+	status = 0
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("")
+	parseFlags()
+
+	for _, name := range flag.Args() {
+		check(name)
+	}
+	os.Exit(status)
+}
+
+func parseFlags() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s file ...\n", os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func errf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	status = 1
+}
+
+// object is an object being checked.
+type object struct {
+	name string
+	f    *elf.File
+	size uint64 // size of the file
+	syms int    // number of symbols in the symbol table, 0 if there is none
+}
+
+func (o *object) errorf(format string, args ...interface{}) {
+	errf("%s: "+format, append([]interface{}{o.name}, args...)...)
+}
+
+// check checks the object in the file name.
+func check(name string) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		errf("%v", err)
+		return
+	}
+	f, err := elf.Open(name)
+	if err != nil {
+		errf("%v", err)
+		return
+	}
+	defer f.Close()
+
+	o := &object{name: name, f: f, size: uint64(fi.Size())}
+	o.header()
+	o.sections()
+	o.symbols()
+	o.relocations()
+}
+
+// header checks that the section headers are in the file.
+func (o *object) header() {
+	f := o.f
+	if f.Type != elf.ET_REL {
+		o.errorf("type %v, not a relocatable object", f.Type)
+	}
+	if len(f.Sections) == 0 || f.Sections[0].Type != elf.SHT_NULL {
+		o.errorf("the first section is not the null section")
+	}
+	if f.Class == elf.ELFCLASS64 && f.Machine != elf.EM_X86_64 || f.Class == elf.ELFCLASS32 && f.Machine != elf.EM_386 && f.Machine != elf.EM_ARM && f.Machine != elf.EM_MIPS {
+		o.errorf("machine %v of a %v object", f.Machine, f.Class)
+	}
+}
+
+// sections checks that the contents of the sections are in the file,
+// aligned to their alignment, and don't overlap.
+func (o *object) sections() {
+	type extent struct {
+		name     string
+		off, end uint64
+	}
+	var extents []extent
+	for i, s := range o.f.Sections {
+		if i == 0 {
+			continue
+		}
+		if a := s.Addralign; a&(a-1) != 0 {
+			o.errorf("section %s: alignment %d is not a power of 2", s.Name, a)
+		} else if a > 1 && s.Type != elf.SHT_NOBITS && s.Offset%a != 0 {
+			o.errorf("section %s: offset %#x is not aligned to %d", s.Name, s.Offset, a)
+		}
+		if s.Link >= uint32(len(o.f.Sections)) {
+			o.errorf("section %s: link %d is not a section", s.Name, s.Link)
+		}
+		if s.Type == elf.SHT_NOBITS || s.Size == 0 {
+			continue
+		}
+		if s.Offset > o.size || s.Size > o.size-s.Offset {
+			o.errorf("section %s: %#x bytes at %#x are past the end of the file at %#x", s.Name, s.Size, s.Offset, o.size)
+			continue
+		}
+		extents = append(extents, extent{s.Name, s.Offset, s.Offset + s.Size})
+	}
+
+	sort.Slice(extents, func(i, j int) bool { return extents[i].off < extents[j].off })
+	for i := 1; i < len(extents); i++ {
+		if x, y := extents[i-1], extents[i]; y.off < x.end {
+			o.errorf("sections %s and %s overlap at %#x", x.name, y.name, y.off)
+		}
+	}
+}
+
+// symbols checks that the local symbols come first, as the symbol table
+// says, and that the symbols defined are within their sections.
+func (o *object) symbols() {
+	tab := o.section(elf.SHT_SYMTAB)
+	if tab == nil {
+		return
+	}
+	if str := o.f.Sections[tab.Link]; str.Type != elf.SHT_STRTAB {
+		o.errorf("symbol table: link %d is not a string table", tab.Link)
+	}
+	want := uint64(elf.Sym64Size)
+	if o.f.Class == elf.ELFCLASS32 {
+		want = elf.Sym32Size
+	}
+	if tab.Entsize != want {
+		o.errorf("symbol table: entry size %d, not %d", tab.Entsize, want)
+	}
+
+	syms, err := o.f.Symbols()
+	if err != nil {
+		o.errorf("symbol table: %v", err)
+		return
+	}
+	o.syms = len(syms) + 1
+	locals := int(tab.Info)
+	if locals < 1 || locals > o.syms {
+		o.errorf("symbol table: %d local symbols of %d", locals, o.syms)
+	}
+	for i, sym := range syms {
+		// the symbols of debug/elf don't have the null symbol
+		n := i + 1
+		local := elf.ST_BIND(sym.Info) == elf.STB_LOCAL
+		if local != (n < locals) {
+			o.errorf("symbol %s: %v binding at %d, the local symbols end at %d", sym.Name, elf.ST_BIND(sym.Info), n, locals)
+		}
+
+		switch sym.Section {
+		case elf.SHN_UNDEF, elf.SHN_ABS, elf.SHN_COMMON:
+			continue
+		}
+		if int(sym.Section) >= len(o.f.Sections) {
+			o.errorf("symbol %s: section %d does not exist", sym.Name, sym.Section)
+			continue
+		}
+		s := o.f.Sections[sym.Section]
+		if elf.ST_TYPE(sym.Info) == elf.STT_SECTION {
+			continue
+		}
+		if sym.Value > s.Size || sym.Size > s.Size-sym.Value {
+			o.errorf("symbol %s: %d bytes at %#x are past the end of section %s at %#x", sym.Name, sym.Size, sym.Value, s.Name, s.Size)
+		}
+	}
+}
+
+// relocations checks that the relocations apply within their sections
+// and are of symbols of the symbol table.
+func (o *object) relocations() {
+	for _, s := range o.f.Sections {
+		if s.Type != elf.SHT_RELA && s.Type != elf.SHT_REL {
+			continue
+		}
+		if tab := o.f.Sections[s.Link]; tab.Type != elf.SHT_SYMTAB {
+			o.errorf("section %s: link %d is not the symbol table", s.Name, s.Link)
+		}
+		if s.Info == 0 || s.Info >= uint32(len(o.f.Sections)) {
+			o.errorf("section %s: info %d is not a section", s.Name, s.Info)
+			continue
+		}
+		target := o.f.Sections[s.Info]
+
+		relocs, err := o.relocs(s)
+		if err != nil {
+			o.errorf("section %s: %v", s.Name, err)
+			continue
+		}
+		for _, r := range relocs {
+			if r.off > target.Size || r.width > target.Size-r.off {
+				o.errorf("section %s: relocation at %#x is past the end of section %s at %#x", s.Name, r.off, target.Name, target.Size)
+			}
+			if r.sym >= uint32(o.syms) {
+				o.errorf("section %s: relocation at %#x is of symbol %d, there are %d", s.Name, r.off, r.sym, o.syms)
+			}
+		}
+	}
+}
+
+// reloc is a relocation, the offset it applies at, the number of bytes
+// it changes and its symbol.
+type reloc struct {
+	off   uint64
+	width uint64
+	sym   uint32
+}
+
+// relocs returns the relocations of the section s.
+func (o *object) relocs(s *elf.Section) ([]reloc, error) {
+	data, err := s.Data()
+	if err != nil {
+		return nil, err
+	}
+	var entsize uint64
+	switch {
+	case o.f.Class == elf.ELFCLASS64 && s.Type == elf.SHT_RELA:
+		entsize = 24
+	case o.f.Class == elf.ELFCLASS32 && s.Type == elf.SHT_RELA:
+		entsize = 12
+	case o.f.Class == elf.ELFCLASS32:
+		entsize = 8
+	default:
+		return nil, fmt.Errorf("REL relocations in a 64-bit object")
+	}
+	if s.Entsize != entsize || uint64(len(data))%entsize != 0 {
+		return nil, fmt.Errorf("entry size %d of %d bytes, not %d", s.Entsize, len(data), entsize)
+	}
+
+	var relocs []reloc
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		var x reloc
+		if o.f.Class == elf.ELFCLASS64 {
+			var rela elf.Rela64
+			if err := binary.Read(r, o.f.ByteOrder, &rela); err != nil {
+				return nil, err
+			}
+			x = reloc{rela.Off, width64(elf.R_X86_64(elf.R_TYPE64(rela.Info))), elf.R_SYM64(rela.Info)}
+		} else {
+			var rel elf.Rel32
+			if err := binary.Read(r, o.f.ByteOrder, &rel); err != nil {
+				return nil, err
+			}
+			if s.Type == elf.SHT_RELA {
+				var addend int32
+				if err := binary.Read(r, o.f.ByteOrder, &addend); err != nil {
+					return nil, err
+				}
+			}
+			x = reloc{uint64(rel.Off), 4, elf.R_SYM32(rel.Info)}
+		}
+		relocs = append(relocs, x)
+	}
+	return relocs, nil
+}
+
+// width64 returns the number of bytes that an amd64 relocation changes.
+func width64(t elf.R_X86_64) uint64 {
+	switch t {
+	case elf.R_X86_64_64, elf.R_X86_64_PC64:
+		return 8
+	case elf.R_X86_64_16, elf.R_X86_64_PC16:
+		return 2
+	case elf.R_X86_64_8, elf.R_X86_64_PC8:
+		return 1
+	}
+	return 4
+}
+
+// section returns the first section of type t, nil if there is none.
+func (o *object) section(t elf.SectionType) *elf.Section {
+	for _, s := range o.f.Sections {
+		if s.Type == t {
+			return s
+		}
+	}
+	return nil
+}
@@ -0,0 +1,372 @@
+// Command foldcheck checks the constant folding of the compiler and
+// the code it generates for the same expressions against the values
+// that foldcheck gives them. It makes up integer expressions of
+// constants and evaluates them like C does, and a program that computes
+// each of them twice: once as written, which the compiler folds, and
+// once with the constants read from an array, which the program
+// computes when it runs. The program is compiled with scc and run, and
+// the expressions that either of them gets wrong are printed.
+//
+// The evaluator of foldcheck is the oracle rather than an interpreter of
+// the code that scc makes: the compiler has no interpreter to run its
+// code in, and one built on the trees of the compiler would share the
+// bugs of the folding that it is there to find.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var flags struct {
+	Count int
+	Depth int
+	Seed  int64
+	SCC   string
+	Args  string
+	Keep  bool
+}
+
+var (
+	status = 0
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("")
+	parseFlags()
+
+	g := &gen{rand: rand.New(rand.NewSource(flags.Seed))}
+	var exprs []*expr
+	for len(exprs) < flags.Count {
+		exprs = append(exprs, g.valid(flags.Depth))
+	}
+
+	dir, err := ioutil.TempDir("", "foldcheck")
+	ck(err)
+	if flags.Keep {
+		fmt.Fprintln(os.Stderr, "the program is kept in", dir)
+	}
+
+	src := filepath.Join(dir, "fold.c")
+	err = ioutil.WriteFile(src, program(exprs), 0644)
+	if err == nil {
+		var out []byte
+		if out, err = run(dir, src); err == nil {
+			report(exprs, out)
+		}
+	}
+	if !flags.Keep {
+		os.RemoveAll(dir)
+	}
+	ck(err)
+	os.Exit(status)
+}
+
+func parseFlags() {
+	flag.IntVar(&flags.Count, "n", 200, "number of expressions to check")
+	flag.IntVar(&flags.Depth, "depth", 4, "maximum depth of the expressions")
+	flag.Int64Var(&flags.Seed, "seed", 1, "seed of the expressions made up, the same seed makes the same ones")
+	flag.StringVar(&flags.SCC, "scc", "scc", "the compiler to check")
+	flag.StringVar(&flags.Args, "args", "", "options to compile the program with, separated by spaces")
+	flag.BoolVar(&flags.Keep, "keep", false, "keep the program in its temporary directory")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 0 || flags.Count < 1 || flags.Depth < 1 {
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [options]\n", os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+// expr is an expression of int constants. The leaves are literals, the
+// other nodes apply the operator to the operands of x.
+type expr struct {
+	op  string // operator, empty for a literal
+	x   []*expr
+	lit int64
+	val int64 // the value of the expression in C
+}
+
+// operators are the operators of the expressions, by the number of operands.
+var operators = [...][]string{
+	1: {"-", "~", "!"},
+	2: {"+", "-", "*", "/", "%", "<<", ">>", "&", "|", "^",
+		"==", "!=", "<", "<=", ">", ">=", "&&", "||"},
+	3: {"?:"},
+}
+
+// literals are the constants the expressions are made of, the values
+// around the ones the folding treats differently.
+var literals = []int64{0, 1, 2, 3, 4, 5, 7, 8, 10, 15, 16, 31, 100, 127, 128, 255, 256, 1000, 4096, 65535, 65536, 1 << 30, 1<<31 - 1}
+
+type gen struct {
+	rand *rand.Rand
+}
+
+// valid makes up expressions until one has a value that C defines.
+func (g *gen) valid(depth int) *expr {
+	for {
+		if e, ok := g.expr(depth); ok {
+			return e
+		}
+	}
+}
+
+// expr makes up an expression of at most depth levels and computes its
+// value. It reports false if the value is undefined, or is out of the
+// range of an int of 32 bits and so depends on the target.
+func (g *gen) expr(depth int) (*expr, bool) {
+	if depth == 1 || g.rand.Intn(4) == 0 {
+		e := &expr{lit: literals[g.rand.Intn(len(literals))]}
+		if g.rand.Intn(5) == 0 {
+			e.lit = -e.lit
+		}
+		e.val = e.lit
+		return e, true
+	}
+
+	n := 2
+	switch r := g.rand.Intn(10); {
+	case r < 2:
+		n = 1
+	case r == 9:
+		n = 3
+	}
+	e := &expr{op: operators[n][g.rand.Intn(len(operators[n]))]}
+	for i := 0; i < n; i++ {
+		x, ok := g.expr(depth - 1)
+		if !ok {
+			return nil, false
+		}
+		e.x = append(e.x, x)
+	}
+	v, ok := eval(e)
+	if !ok || v < -1<<31 || v > 1<<31-1 {
+		return nil, false
+	}
+	e.val = v
+	return e, true
+}
+
+// eval computes the value of e from the values of its operands like C
+// does, all of the operands are computed so none can be undefined.
+func eval(e *expr) (int64, bool) {
+	a := e.x[0].val
+	var b int64
+	if len(e.x) > 1 {
+		b = e.x[1].val
+	}
+	switch e.op {
+	case "-":
+		if len(e.x) == 1 {
+			return -a, true
+		}
+		return a - b, true
+	case "~":
+		return ^a, true
+	case "!":
+		return truth(a == 0), true
+	case "+":
+		return a + b, true
+	case "*":
+		return a * b, true
+	case "/", "%":
+		if b == 0 {
+			return 0, false
+		}
+		if e.op == "/" {
+			return a / b, true
+		}
+		return a % b, true
+	case "<<":
+		if b < 0 || b >= 31 || a < 0 {
+			return 0, false
+		}
+		return a << uint(b), true
+	case ">>":
+		if b < 0 || b >= 31 {
+			return 0, false
+		}
+		return a >> uint(b), true
+	case "&":
+		return a & b, true
+	case "|":
+		return a | b, true
+	case "^":
+		return a ^ b, true
+	case "==":
+		return truth(a == b), true
+	case "!=":
+		return truth(a != b), true
+	case "<":
+		return truth(a < b), true
+	case "<=":
+		return truth(a <= b), true
+	case ">":
+		return truth(a > b), true
+	case ">=":
+		return truth(a >= b), true
+	case "&&":
+		return truth(a != 0 && b != 0), true
+	case "||":
+		return truth(a != 0 || b != 0), true
+	case "?:":
+		if a != 0 {
+			return b, true
+		}
+		return e.x[2].val, true
+	}
+	panic("unknown operator " + e.op)
+}
+
+func truth(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// format writes e in C, fully parenthesized. The literals are written
+// as they are, or as the elements of the array k that hold them if
+// they are to be read when the program runs.
+func format(b *bytes.Buffer, e *expr, lits *[]int64) {
+	switch {
+	case e.op == "":
+		switch {
+		case lits != nil:
+			fmt.Fprintf(b, "k[%d]", len(*lits))
+			*lits = append(*lits, e.lit)
+		case e.lit < 0:
+			fmt.Fprintf(b, "(%d)", e.lit)
+		default:
+			fmt.Fprintf(b, "%d", e.lit)
+		}
+	case len(e.x) == 1:
+		fmt.Fprintf(b, "(%s", e.op)
+		format(b, e.x[0], lits)
+		b.WriteString(")")
+	case len(e.x) == 2:
+		b.WriteString("(")
+		format(b, e.x[0], lits)
+		fmt.Fprintf(b, " %s ", e.op)
+		format(b, e.x[1], lits)
+		b.WriteString(")")
+	default:
+		b.WriteString("(")
+		format(b, e.x[0], lits)
+		b.WriteString(" ? ")
+		format(b, e.x[1], lits)
+		b.WriteString(" : ")
+		format(b, e.x[2], lits)
+		b.WriteString(")")
+	}
+}
+
+func (e *expr) String() string {
+	var b bytes.Buffer
+	format(&b, e, nil)
+	return b.String()
+}
+
+// program returns the program that checks the expressions, it prints
+// the number and the two values of each expression that one of them is
+// not the value of.
+func program(exprs []*expr) []byte {
+	var lits []int64
+	var body bytes.Buffer
+	for i, e := range exprs {
+		fmt.Fprintf(&body, "\tn = n + check(%d, %s, %v, ", i, cint(e.val), e)
+		format(&body, e, &lits)
+		body.WriteString(");\n")
+	}
+
+	var b bytes.Buffer
+	b.WriteString("int printf(char *fmt, ...);\n\n")
+	fmt.Fprintf(&b, "int k[%d] = {", len(lits))
+	for i, v := range lits {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprint(&b, v)
+	}
+	b.WriteString("};\n\n")
+	b.WriteString("int check(int i, int want, int folded, int computed) {\n")
+	b.WriteString("\tif (folded == want && computed == want)\n\t\treturn 0;\n")
+	b.WriteString("\tprintf(\"%d %d %d\\n\", i, folded, computed);\n")
+	b.WriteString("\treturn 1;\n}\n\n")
+	b.WriteString("int main(void) {\n\tint n;\n\n\tn = 0;\n")
+	b.Write(body.Bytes())
+	b.WriteString("\treturn n != 0;\n}\n")
+	return b.Bytes()
+}
+
+// cint writes the int v in C, the most negative one can't be written as
+// the negation of its magnitude, which doesn't fit in an int.
+func cint(v int64) string {
+	if v == math.MinInt32 {
+		return "(-2147483647-1)"
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+// run compiles the program src in dir and runs it, it returns what it
+// printed. A status of 1 is the program finding differences.
+func run(dir, src string) ([]byte, error) {
+	exe := filepath.Join(dir, "fold")
+	args := append(strings.Fields(flags.Args), "-o", exe, src)
+	cmd := exec.Command(flags.SCC, args...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %v", flags.SCC, err)
+	}
+
+	cmd = exec.Command(exe)
+	out, err := cmd.Output()
+	if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == 1 {
+		err = nil
+	}
+	if err != nil {
+		return out, fmt.Errorf("%s: %v", exe, err)
+	}
+	return out, nil
+}
+
+// report prints the expressions that the program found wrong.
+func report(exprs []*expr, out []byte) {
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		f := strings.Fields(s.Text())
+		if len(f) != 3 {
+			continue
+		}
+		i, err := strconv.Atoi(f[0])
+		if err != nil || i < 0 || i >= len(exprs) {
+			continue
+		}
+		fmt.Printf("%v\n\tfolded %s, computed %s, C gives %d\n", exprs[i], f[1], f[2], exprs[i].val)
+		status = 1
+	}
+}
+
+func ck(err error) {
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,141 @@
+// Command irdump prints the calls that the compiler makes to the backend
+// for each function of a file, one on each line, as arch.Recorder records
+// them. The calls don't depend on a target, so the test/ir script checks
+// the code of the language constructs against them.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"subc/compile"
+	"subc/compile/arch"
+	"subc/compile/arch/amd64"
+	"subc/compile/arch/arm6"
+	"subc/compile/arch/i386"
+	"subc/parse"
+	"subc/scan"
+	"subc/types"
+)
+
+var flags struct {
+	Includes []string
+	Arch     string
+	Compat   bool
+}
+
+var (
+	status = 0
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("")
+	parseFlags()
+
+	for _, name := range flag.Args() {
+		if flag.NArg() > 1 {
+			fmt.Printf("-- %s\n", name)
+		}
+		ek(dump(name))
+	}
+	os.Exit(status)
+}
+
+func parseFlags() {
+	includes := flag.String("I", "", "include paths separated by :, also settable via SCCINC environment variable")
+	flag.StringVar(&flags.Arch, "arch", "amd64", "specify machine architecture the sizes are taken from [amd64 | i386 | arm6]")
+	flag.BoolVar(&flags.Compat, "compat", false, "compatibility mode with the scc compiler")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+	}
+
+	for _, list := range []string{*includes, os.Getenv("SCCINC")} {
+		for _, include := range strings.Split(list, ":") {
+			if include != "" {
+				flags.Includes = append(flags.Includes, include)
+			}
+		}
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [options] file ...\n", os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func sizes() (types.Sizes, error) {
+	switch flags.Arch {
+	case "amd64":
+		return amd64.NewEmitter(ioutil.Discard).Sizes, nil
+	case "i386":
+		return i386.NewEmitter(ioutil.Discard).Sizes, nil
+	case "arm6":
+		return arm6.NewEmitter(ioutil.Discard).Sizes, nil
+	}
+	return nil, fmt.Errorf("unknown architecture %v", flags.Arch)
+}
+
+// dump compiles a file with a recorder for the backend and prints the
+// calls recorded.
+func dump(name string) error {
+	sizes, err := sizes()
+	if err != nil {
+		return err
+	}
+	rec := arch.NewRecorder(sizes)
+
+	reader, err := scan.OpenFile(name)
+	if err != nil {
+		return err
+	}
+	scanConfig := scan.DefaultConfig
+	scanConfig.IncludePaths = flags.Includes
+	scanner := scan.New(scanConfig, name, reader)
+	defer scanner.Close()
+
+	prog, err := parse.Parse(parse.Config{Predecl: !flags.Compat}, scanner)
+	if err = frontEndError(err); err != nil {
+		return err
+	}
+	info, err := types.Check(types.Config{Sizes: sizes}, prog)
+	if err = frontEndError(err); err != nil {
+		return err
+	}
+
+	opt := !flags.Compat
+	conf := compile.Config{Emitter: rec.Emitter, Common: opt, Pool: opt, Hoist: opt, Reduce: opt, Layout: opt, Extend: opt, Bools: opt, Truncate: opt, Index: opt}
+	if err := compile.Compile(context.Background(), conf, prog, info); err != nil {
+		return err
+	}
+	fmt.Print(rec)
+	return nil
+}
+
+func frontEndError(err error) error {
+	l, _ := err.(*scan.ErrorList)
+	if l == nil || l.NumErrors > 0 {
+		return err
+	}
+	for _, m := range l.Messages {
+		fmt.Fprintln(os.Stderr, m)
+	}
+	return nil
+}
+
+func ek(err error) bool {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		status = 1
+		return true
+	}
+	return false
+}
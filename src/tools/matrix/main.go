@@ -0,0 +1,301 @@
+// Command matrix runs the test corpus on every target of scc and prints
+// how far each target gets with it. For each arch/os pair the sources
+// are compiled to assembly, assembled to objects, and the programs, the
+// sources that define main, are linked and run. A program runs on the
+// host when the target is the host, or under the user mode QEMU of the
+// arch when it is in the PATH, and it passes if it exits with 0. The
+// steps that a target can't take on the host, like linking without its
+// cross tools, are counted as failures of that step and the steps after
+// it are skipped, so the matrix shows where each backend stands.
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var flags struct {
+	SCC     string
+	Targets string
+	Args    string
+	Timeout time.Duration
+	Verbose bool
+	Keep    bool
+}
+
+// target is an arch/os pair that scc compiles for.
+type target struct {
+	arch, os string
+	qemu     string // the user mode QEMU that runs the programs of the target, empty if there is none
+}
+
+func (t target) String() string { return t.arch + "/" + t.os }
+
+// targets are the targets of scc, in the order of the matrix.
+var targets = []target{
+	{"amd64", "linux", "qemu-x86_64"},
+	{"i386", "linux", "qemu-i386"},
+	{"arm6", "linux", "qemu-arm"},
+	{"mips", "linux", "qemu-mips"},
+	{"amd64", "darwin", ""},
+	{"8086", "dos", ""},
+	{"8086", "boot", ""},
+}
+
+// hostArchs are the archs of scc by the GOARCH of the host.
+var hostArchs = map[string]string{
+	"amd64": "amd64",
+	"386":   "i386",
+	"arm":   "arm6",
+	"mips":  "mips",
+}
+
+// steps are the steps a source goes through, the ones of a program
+// don't include run for the other sources.
+var steps = []string{"compile", "assemble", "link", "run"}
+
+// result is how many sources passed and failed each step on a target,
+// and why the run step was left out if it was.
+type result struct {
+	passed  map[string]int
+	failed  map[string]int
+	noRun   string
+	fails   []string
+	elapsed time.Duration
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("matrix: ")
+	parseFlags()
+
+	dir := "."
+	if flag.NArg() == 1 {
+		dir = flag.Arg(0)
+	}
+	sources, err := filepath.Glob(filepath.Join(dir, "*.c"))
+	ck(err)
+	if len(sources) == 0 {
+		log.Fatalf("no sources in %s", dir)
+	}
+	sort.Strings(sources)
+
+	selected, err := selectTargets(flags.Targets)
+	ck(err)
+
+	tmp, err := ioutil.TempDir("", "matrix")
+	ck(err)
+	if flags.Keep {
+		fmt.Fprintln(os.Stderr, "the objects and the programs are kept in", tmp)
+	} else {
+		defer os.RemoveAll(tmp)
+	}
+
+	status := 0
+	results := make(map[target]*result)
+	for _, t := range selected {
+		out := filepath.Join(tmp, strings.Replace(t.String(), "/", "-", 1))
+		ck(os.MkdirAll(out, 0755))
+		r := check(t, sources, out)
+		results[t] = r
+		for _, n := range r.failed {
+			if n > 0 {
+				status = 1
+			}
+		}
+	}
+
+	report(os.Stdout, selected, results)
+	os.Exit(status)
+}
+
+func parseFlags() {
+	flag.StringVar(&flags.SCC, "scc", "scc", "the compiler to check")
+	flag.StringVar(&flags.Targets, "targets", "", "comma separated list of the arch/os pairs to check (default all of them)")
+	flag.StringVar(&flags.Args, "args", "", "options to compile the sources with, separated by spaces")
+	flag.DurationVar(&flags.Timeout, "timeout", 10*time.Second, "time a step of a source is given before it fails")
+	flag.BoolVar(&flags.Verbose, "v", false, "print the sources that fail and the output of the step that failed")
+	flag.BoolVar(&flags.Keep, "keep", false, "keep the objects and the programs in their temporary directory")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() > 1 {
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [options] [dir]\n", os.Args[0])
+	fmt.Fprintln(os.Stderr, "dir holds the sources of the corpus, the current directory if it is left out")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+// selectTargets returns the targets in the comma separated list,
+// all of them if it is empty.
+func selectTargets(list string) ([]target, error) {
+	if list == "" {
+		return targets, nil
+	}
+	var selected []target
+	for _, name := range strings.Split(list, ",") {
+		found := false
+		for _, t := range targets {
+			if t.String() == name {
+				selected = append(selected, t)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown target %q", name)
+		}
+	}
+	return selected, nil
+}
+
+// check takes the sources through the steps on target t, writing what
+// it makes in the directory out.
+func check(t target, sources []string, out string) *result {
+	r := &result{passed: make(map[string]int), failed: make(map[string]int)}
+	start := time.Now()
+	runner, why := runnerOf(t)
+	r.noRun = why
+
+	for _, src := range sources {
+		name := strings.TrimSuffix(filepath.Base(src), ".c")
+		base := filepath.Join(out, name)
+
+		step := func(step string, args ...string) bool {
+			output, err := command(args...)
+			if err != nil {
+				r.failed[step]++
+				r.fails = append(r.fails, fmt.Sprintf("%s: %s: %s: %v\n%s", t, step, src, err, output))
+				return false
+			}
+			r.passed[step]++
+			return true
+		}
+
+		if !step("compile", scc(t, "-S", "-o", base+".s", src)...) {
+			continue
+		}
+		if !step("assemble", scc(t, "-c", "-o", base+".o", src)...) {
+			continue
+		}
+		if !isProgram(src) {
+			continue
+		}
+		if !step("link", scc(t, "-o", base, src)...) {
+			continue
+		}
+		if runner != nil {
+			step("run", append(runner, base)...)
+		}
+	}
+	r.elapsed = time.Since(start)
+	return r
+}
+
+// scc returns the command that compiles src for target t with args.
+func scc(t target, args ...string) []string {
+	cmd := []string{flags.SCC, "-arch", t.arch, "-os", t.os}
+	cmd = append(cmd, strings.Fields(flags.Args)...)
+	return append(cmd, args...)
+}
+
+// runnerOf returns the command that the programs of target t are run
+// with, empty if they run on the host, or nil and why they can't run.
+func runnerOf(t target) ([]string, string) {
+	if hostArchs[runtime.GOARCH] == t.arch && runtime.GOOS == t.os {
+		return []string{}, ""
+	}
+	if t.qemu == "" {
+		return nil, "no emulator"
+	}
+	if _, err := exec.LookPath(t.qemu); err != nil {
+		return nil, "no " + t.qemu
+	}
+	return []string{t.qemu}, ""
+}
+
+// isProgram reports whether src defines main, the other sources of
+// the corpus are linked into the programs by them.
+func isProgram(src string) bool {
+	buf, err := ioutil.ReadFile(src)
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(buf), "\n") {
+		if strings.HasPrefix(line, "main(") || strings.HasPrefix(line, "int main(") {
+			return true
+		}
+	}
+	return false
+}
+
+// command runs args with the timeout of a step and no input, and
+// returns what it printed.
+func command(args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), flags.Timeout)
+	defer cancel()
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	return out.Bytes(), err
+}
+
+// report prints the matrix of the results, a row for each target with
+// the sources that passed each step out of the ones that got to it.
+func report(w *os.File, selected []target, results map[target]*result) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "target\t%s\ttime\n", strings.Join(steps, "\t"))
+	for _, t := range selected {
+		r := results[t]
+		fmt.Fprintf(tw, "%s", t)
+		for _, step := range steps {
+			n, m := r.passed[step], r.passed[step]+r.failed[step]
+			switch {
+			case step == "run" && r.noRun != "":
+				fmt.Fprintf(tw, "\t- (%s)", r.noRun)
+			case m == 0:
+				fmt.Fprintf(tw, "\t-")
+			default:
+				fmt.Fprintf(tw, "\t%d/%d", n, m)
+			}
+		}
+		fmt.Fprintf(tw, "\t%v\n", r.elapsed.Round(time.Millisecond))
+	}
+	tw.Flush()
+
+	if !flags.Verbose {
+		return
+	}
+	for _, t := range selected {
+		for _, fail := range results[t].fails {
+			fmt.Fprintln(w)
+			fmt.Fprint(w, fail)
+		}
+	}
+}
+
+func ck(err error) {
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -109,6 +109,8 @@ func (c *celf) compare() {
 	c.symtab()
 	c.rela(".rela.text")
 	c.rela(".rela.data")
+	c.rela(".rel.text")
+	c.rela(".rel.data")
 }
 
 func (c *celf) sections() {
@@ -350,21 +352,32 @@ func (c *celf) rela(name string) {
 		return
 	}
 
+	// the relocations are read in the byte order of the objects, which
+	// is the one of their target, and the ones of a .rel section have
+	// their addends in the code
+	order := c.f1.ByteOrder
 	var r1, r2 []elf.Rela64
 	for {
 		var (
 			v1, v2    elf.Rela64
 			err, xerr error
 		)
-		switch c.f1.Class {
-		case elf.ELFCLASS64:
-			err = binary.Read(b1, binary.LittleEndian, &v1)
-			xerr = binary.Read(b2, binary.LittleEndian, &v2)
-
-		case elf.ELFCLASS32:
+		switch {
+		case c.f1.Class == elf.ELFCLASS64:
+			err = binary.Read(b1, order, &v1)
+			xerr = binary.Read(b2, order, &v2)
+
+		case s1.Type == elf.SHT_REL:
+			var x1, x2 elf.Rel32
+			err = binary.Read(b1, order, &x1)
+			xerr = binary.Read(b2, order, &x2)
+			v1 = elf.Rela64{Off: uint64(x1.Off), Info: uint64(x1.Info)}
+			v2 = elf.Rela64{Off: uint64(x2.Off), Info: uint64(x2.Info)}
+
+		default:
 			var x1, x2 elf.Rela32
-			err = binary.Read(b1, binary.LittleEndian, &x1)
-			xerr = binary.Read(b2, binary.LittleEndian, &x2)
+			err = binary.Read(b1, order, &x1)
+			xerr = binary.Read(b2, order, &x2)
 			v1 = elf.Rela64{uint64(x1.Off), uint64(x1.Info), int64(x1.Addend)}
 			v2 = elf.Rela64{uint64(x2.Off), uint64(x2.Info), int64(x2.Addend)}
 		}
@@ -386,14 +399,18 @@ func (c *celf) rela(name string) {
 		return r2[i].Off < r2[j].Off
 	})
 
+	typeMask := uint64(0xffffffff)
+	if c.f1.Class == elf.ELFCLASS32 {
+		typeMask = 0xff
+	}
 	for i := range r1 {
 		v1 := r1[i]
 		v2 := r2[i]
 		i1 := int64(v1.Info >> 32)
 		i2 := int64(v2.Info >> 32)
 		if c.f1.Class == elf.ELFCLASS32 {
-			i1 = int64(v1.Info >> 16)
-			i2 = int64(v2.Info >> 16)
+			i1 = int64(v1.Info >> 8)
+			i2 = int64(v2.Info >> 8)
 		}
 
 		if i1 == 0 {
@@ -404,7 +421,7 @@ func (c *celf) rela(name string) {
 		}
 
 		if v1.Off != v2.Off || v1.Addend != v2.Addend ||
-			v1.Info&0xffffffff != v2.Info&0xffffffff || p1[i1-1].Name != p2[i2-1].Name {
+			v1.Info&typeMask != v2.Info&typeMask || p1[i1-1].Name != p2[i2-1].Name {
 			errf("rela mismatch\n\t%#v\n\t%#v", v1, v2)
 			errf("\t%q %q\n", p1[i1-1].Name, p2[i2-1].Name)
 		}
@@ -0,0 +1,15 @@
+/*
+ *	gosubc runtime
+ *	__nullderef()
+ */
+
+#include <stdio.h>
+#include <stdlib.h>
+
+/* called by the code compiled with -fsanitize=null
+ * before a null pointer is dereferenced */
+void __nullderef(char *msg) {
+	fflush(stdout);
+	kprintf(2, "%s\n", msg);
+	abort();
+}
@@ -89,6 +89,10 @@ Csetjmp:
 	movq	%rbp,8(%rdx)
 	movq	(%rsp),%rax
 	movq	%rax,16(%rdx)
+	movq	%r12,24(%rdx)	# register variables
+	movq	%r13,32(%rdx)
+	movq	%r14,40(%rdx)
+	movq	%r15,48(%rdx)
 	xorq	%rax,%rax
 	ret
 
@@ -103,6 +107,10 @@ Clongjmp:
 vok:	movq	8(%rsp),%rdx	# env
 	movq	(%rdx),%rsp
 	movq	8(%rdx),%rbp
+	movq	24(%rdx),%r12
+	movq	32(%rdx),%r13
+	movq	40(%rdx),%r14
+	movq	48(%rdx),%r15
 	movq	16(%rdx),%rdx
 	jmp	*%rdx
 
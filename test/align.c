@@ -0,0 +1,36 @@
+/* variables declared aligned */
+
+int printf(char *fmt, ...);
+
+int a __attribute__((aligned(64)));
+char b[3] __attribute__((aligned(32)));
+int c __attribute__((aligned(16))) = 7;
+char d[] __attribute__((aligned(128))) = "aligned";
+static int e[5] __attribute__((aligned));
+static char f __attribute__((aligned(256))) = 'f';
+extern int g __attribute__((aligned(512)));
+int g;
+
+int check(char *name, void *p, int align) {
+	printf("%s %d\n", name, (int)((long)p % align));
+	return 0;
+}
+
+int main(void) {
+	static int h __attribute__((aligned(1024)));
+	static char i[7] __attribute__((aligned(64)));
+	static int j __attribute__((aligned(32))) = 3;
+
+	check("a", &a, 64);
+	check("b", b, 32);
+	check("c", &c, 16);
+	check("d", d, 128);
+	check("e", e, 16);
+	check("f", &f, 256);
+	check("g", &g, 512);
+	check("h", &h, 1024);
+	check("i", i, 64);
+	check("j", &j, 32);
+	printf("%d %s %c %d\n", c, d, f, j);
+	return 0;
+}
@@ -0,0 +1,31 @@
+/* symbols renamed by asm labels and #pragma redefine_extname */
+
+int printf(char *fmt, ...);
+
+/* the runtime functions are the C names with the code prefix */
+int length(char *s) __asm__("Cstrlen");
+
+#pragma redefine_extname compare Cstrcmp
+int compare(char *a, char *b);
+
+/* two names of one symbol */
+int counter __asm__("shared_counter");
+extern int alias __asm__("shared_counter");
+
+int twice(int x) __asm__("twice_impl");
+
+int twice(int x) {
+	counter++;
+	return 2 * x;
+}
+
+int (*fp)() = twice;
+
+int main(void) {
+	int r;
+
+	r = twice(21) + fp(1);
+	printf("%d %d %d\n", r, counter, alias);
+	printf("%d %d\n", length("hello"), compare("a", "a"));
+	return 0;
+}
@@ -0,0 +1,45 @@
+/* binary constants and case ranges */
+
+int printf(char *fmt, ...);
+
+int kind(int c) {
+	switch (c) {
+	case '0' ... '9':
+		return 1;
+	case 'a' ... 'z':
+	case 'A' ... 'Z':
+		return 2;
+	case 0b100000:
+		return 3;
+	case 0b1000000 ... 0b1000000:
+		return 4;
+	}
+	return 0;
+}
+
+int bits(int n) {
+	switch (n & 0b1111) {
+	case 0b0000 ... 0b0011:
+		return 0;
+	case 0b0100 ... 0b0111:
+		return 1;
+	case 0B1000 ... 0B1110:
+		return 2;
+	default:
+		return 3;
+	}
+}
+
+int main(void) {
+	char *s;
+	int i;
+
+	printf("%d %d %d %d\n", 0b0, 0b101, 0B11111111, 0b1010 + 0b1);
+	for (s = "x7 Z@"; *s; s++)
+		printf("%d", kind(*s));
+	printf("\n");
+	for (i = 0; i < 17; i++)
+		printf("%d", bits(i));
+	printf("\n");
+	return 0;
+}
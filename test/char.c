@@ -0,0 +1,71 @@
+/* the conversions to char used right away, which have to give what
+ * a char variable holds, and the comparisons of chars, which
+ * test-char.sh checks against the ones of -compat */
+
+int printf(char *fmt, ...);
+
+int	v[] = { 0, 1, 127, 128, 255, 256, 300, -1, -128, -129, 65535 };
+
+char	c, d;
+
+char ret(int x) {
+	return x;
+}
+
+int conversions(void) {
+	int i, x, bad;
+
+	bad = 0;
+	for (i = 0; i < 11; i++) {
+		x = v[i];
+		c = x;
+		if ((char) x != c) {
+			printf("%d: the cast gives %d, the variable %d\n", x, (char) x, c);
+			bad++;
+		}
+		if ((char) (x + 0) != c) {
+			printf("%d: the cast of a sum gives %d\n", x, (char) (x + 0));
+			bad++;
+		}
+		if ((d = x) != c) {
+			printf("%d: the assignment gives %d\n", x, d = x);
+			bad++;
+		}
+		if (ret(x) != c) {
+			printf("%d: the return gives %d\n", x, ret(x));
+			bad++;
+		}
+		d = c;
+		if ((d += 256) != c) {
+			printf("%d: the compound assignment gives %d\n", x, d);
+			bad++;
+		}
+	}
+	if ((char) 300 != 44 || (char) -1 != 255) {
+		printf("the casts of constants give %d and %d\n", (char) 300, (char) -1);
+		bad++;
+	}
+	return bad;
+}
+
+void comparisons(void) {
+	int i, j;
+
+	for (i = 0; i < 11; i++) {
+		c = v[i];
+		printf("%d: %d %d %d %d\n", v[i], c, c == v[i], c < 128, c > 0);
+		for (j = 0; j < 11; j++) {
+			d = v[j];
+			printf(" %d%d%d", c < d, c == d, c >= d);
+		}
+		printf("\n");
+	}
+}
+
+int main(int argc, char **argv) {
+	if (argc > 1) {
+		comparisons();
+		return 0;
+	}
+	return conversions() != 0;
+}
@@ -0,0 +1,56 @@
+#include <stdio.h>
+
+struct point {
+	int	x, y;
+};
+
+struct block {
+	int	v[20];
+	char	tag;
+};
+
+struct block	g1, g2;
+int	zeros[8] = { 1, 2 };
+char	name[8] = "gsubc";
+
+int sum(int *v, int n)
+{
+	int i, s;
+
+	s = 0;
+	for (i = 0; i < n; i++)
+		s += v[i];
+	return s;
+}
+
+int main(void)
+{
+	struct point p, q, r, *pp;
+	struct block b;
+	int a[] = { 3, 1, 4, 1, 5 };
+	int c[40] = { 9, 0, 7 };
+	char s[] = "copy";
+	char t[10] = "ab";
+	int i;
+
+	p.x = 1;
+	p.y = 2;
+	r = q = p;
+	printf("%d %d %d %d\n", q.x, q.y, r.x, r.y);
+	pp = &r;
+	pp->x = 7;
+	p = *pp;
+	printf("%d %d\n", p.x, p.y);
+
+	for (i = 0; i < 20; i++)
+		g1.v[i] = i * 3;
+	g1.tag = 'z';
+	b = g1;
+	g2 = b;
+	printf("%d %c\n", sum(g2.v, 20), g2.tag);
+
+	printf("%d %d %d\n", sum(a, 5), sum(c, 40), c[39]);
+	printf("%s %d %s %d %d\n", s, (int) sizeof(s), t, t[2], t[9]);
+	printf("%d %d %s\n", sum(zeros, 8), zeros[7], name);
+	return 0;
+}
@@ -0,0 +1,29 @@
+#include <stdio.h>
+#include <limits.h>
+
+int	a[] = { 7, -7, 7, -7, 1, -1, 0 };
+int	b[] = { 2, 2, -2, -2, 3, 3, 5 };
+
+int main(void)
+{
+	int i, x, y;
+
+	for (i = 0; i < 7; i++) {
+		x = a[i];
+		y = b[i];
+		printf("%d / %d = %d, %d %% %d = %d\n", x, y, x / y, x, y, x % y);
+		if ((x / y) * y + x % y != x)
+			printf("%d, %d: quotient and remainder disagree\n", x, y);
+	}
+
+	printf("%d %d %d\n", 7 / -2, -7 / 2, -7 % 2);
+
+	x = -7;
+	printf("%d %d %d %d\n", x / 2, x / 4, x % 2, x % 4);
+
+	x = INT_MIN;
+	printf("%d %d\n", x / 2 == INT_MIN / 2, x % 3 == INT_MIN % 3);
+	printf("%d %d\n", x / x, 1 / x);
+	printf("%d %d\n", x / 1 == INT_MIN, x % 1);
+	return 0;
+}
@@ -0,0 +1,32 @@
+/* loops that read globals and store through pointers that hoistp.c
+ * points at them, test-hoist.sh links the two */
+
+int printf(char *fmt, ...);
+
+int g = 1;
+int *p;
+
+int a[] = { 1, 2, 3, 4 };
+int b[] = { 10, 20, 30, 40 };
+int *w;
+int **q;
+
+void point(void);
+
+int main(void) {
+	int i, s, t;
+
+	point();
+	s = 0;
+	for (i = 0; i < 3; i++) {
+		s += g;
+		*p = *p + 1;
+	}
+	t = 0;
+	for (i = 0; i < 4; i++) {
+		t = t + w[i];
+		*q = b;
+	}
+	printf("%d %d\n", s, t);
+	return 0;
+}
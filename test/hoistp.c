@@ -0,0 +1,11 @@
+/* points the pointers of hoist.c at its globals, which hoist.c can't
+ * see being done */
+
+extern int g, *p;
+extern int a[], *w, **q;
+
+void point(void) {
+	p = &g;
+	w = a;
+	q = &w;
+}
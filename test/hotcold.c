@@ -0,0 +1,38 @@
+#include <stdio.h>
+#include <stdlib.h>
+
+void fail(char *msg) __attribute__((cold, noreturn));
+__attribute__((hot)) int sum(int *v, int n);
+
+int sum(int *v, int n)
+{
+	int i, s;
+
+	s = 0;
+	for (i = 0; i < n; i++)
+		s += v[i];
+	return s;
+}
+
+void fail(char *msg)
+{
+	printf("fail: %s\n", msg);
+	exit(1);
+}
+
+static __attribute__((__cold__)) int report(int n)
+{
+	printf("report %d %s\n", n, "x");
+	return n;
+}
+
+int main(void)
+{
+	int a[4];
+
+	a[0] = 1; a[1] = 2; a[2] = 3; a[3] = 4;
+	if (sum(a, 4) != 10)
+		fail("sum");
+	report(sum(a, 4));
+	return 0;
+}
@@ -0,0 +1,42 @@
+/* loops that copy and fill arrays, replaced by memcpy and memset */
+
+int printf(char *fmt, ...);
+
+char a[16], b[16];
+int x[8], y[8];
+
+int sum(int *v, int n) {
+	int i, s;
+
+	s = 0;
+	for (i = 0; i < n; i++)
+		s = s + v[i];
+	return s;
+}
+
+int main(void) {
+	char *s;
+	int i, n;
+
+	for (i = 0; i < 8; i++)
+		y[i] = i * 3;
+	n = 6;
+	for (i = 0; i < n; i++)
+		x[i] = y[i];
+	printf("%d %d %d\n", sum(x, 8), x[5], i);
+	for (i = 2; i < n; i++) {
+		x[i] = 0;
+	}
+	printf("%d %d\n", sum(x, 8), i);
+	for (i = 9; i < n; i++)
+		x[i] = 0;
+	printf("%d %d\n", sum(x, 8), i);
+	for (i = 0; i < 15; i++)
+		a[i] = 'x';
+	printf("%s %d\n", a, i);
+	s = "hello, world";
+	for (i = 0; i < 13; i++)
+		b[i] = s[i];
+	printf("%s\n", b);
+	return 0;
+}
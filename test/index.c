@@ -0,0 +1,47 @@
+#include <stdio.h>
+
+struct pair {
+	int	a;
+};
+
+int	g[10];
+char	gc[10];
+struct pair	gp[4];
+
+int sum(int *p, int n)
+{
+	int i, s;
+
+	s = 0;
+	i = 0;
+	while (i < n) {
+		s += p[i];
+		i += 2;
+	}
+	return s;
+}
+
+int main(void)
+{
+	int a[10], *p[3];
+	char c[10];
+	int i, j;
+
+	for (i = 0; i < 10; i++) {
+		j = 9 - i;
+		a[j] = i * i;
+		g[i] = a[j] + 1;
+		c[i] = 'a' + i;
+		gc[j] = c[i];
+	}
+	for (i = 0; i < 4; i++)
+		gp[i].a = i + 100;
+	p[0] = a;
+	p[1] = g;
+	p[2] = &a[5];
+	j = 3;
+	printf("%d %d %d %d\n", a[j], g[j + 1], p[1][j], p[2][j - 1]);
+	printf("%c %c %d\n", c[j], gc[j * 2], gp[j].a);
+	printf("%d %d\n", sum(a, 10), sum(g, 10));
+	return 0;
+}
@@ -0,0 +1,49 @@
+/* hooks called on the entry and exit of the functions with
+ * -finstrument-functions, test-instrument.sh runs it with it */
+
+int printf(char *fmt, ...);
+
+int enters, exits, depth, deepest;
+void *last, *site;
+
+void __cyg_profile_func_enter(void *fn, void *caller) {
+	enters++;
+	depth++;
+	if (depth > deepest)
+		deepest = depth;
+}
+
+void __cyg_profile_func_exit(void *fn, void *caller) {
+	exits++;
+	depth--;
+	last = fn;
+	site = caller;
+}
+
+int fact(int n) {
+	if (n < 2)
+		return 1;
+	return n * fact(n - 1);
+}
+
+char *name(void) {
+	return "name";
+}
+
+int untraced(int x) __attribute__((no_instrument_function));
+
+int untraced(int x) {
+	return depth + x;
+}
+
+int main(void) {
+	int r;
+	char *s;
+
+	r = fact(5);
+	printf("fact %d, %d entries, %d exits, %d deep\n", r, enters, exits, deepest);
+	printf("last %d, site %d\n", last == (void *) fact, site != (void *) 0);
+	s = name();
+	printf("%s %d\n", s, untraced(10));
+	return 0;
+}
@@ -0,0 +1,12 @@
+/* integer constants that don't fit in an int are longs, and ones
+ * that don't fit in a long are wrapped to its width with a warning */
+
+int big = 2147483648;
+int small = 70000;
+int sbig = sizeof(2147483648);
+int ssmall = sizeof(70000);
+int huge = 0xffffffffffffffff;
+
+int main() {
+	return 0;
+}
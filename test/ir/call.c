@@ -0,0 +1,14 @@
+/* direct and indirect calls, with string literals and a local static */
+
+int printf(char *fmt, ...);
+
+int count(void) {
+	static int n;
+
+	return ++n;
+}
+
+int apply(int (*fn)()) {
+	printf("%d %s\n", fn(), "done");
+	return count();
+}
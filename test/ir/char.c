@@ -0,0 +1,16 @@
+/* the conversions to char that are used right away and the ones that
+ * are known to fit */
+
+char c;
+
+char f(int x, char y) {
+	int i;
+
+	i = (char) (x * 2) + (char) x;
+	i = (c = x) + (c = y);
+	c = (char) 300;
+	c += x;
+	if (y)
+		return y;
+	return x;
+}
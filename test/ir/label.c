@@ -0,0 +1,13 @@
+/* the addresses of labels and computed gotos */
+
+int step(int op) {
+	static void *ops[] = { &&inc, &&dec };
+	void *p;
+
+	p = ops[op];
+	goto *p;
+inc:
+	return op + 1;
+dec:
+	return op - 1;
+}
@@ -0,0 +1,12 @@
+/* the short circuit operators, in conditions and as values */
+
+int f(int a, int b, int c) {
+	int v;
+
+	if (a && b || !c)
+		v = 1;
+	else
+		v = 2;
+	v = v + (a || b && c);
+	return !(a < b) ? v : -v;
+}
@@ -0,0 +1,20 @@
+/* for, while and do loops, with break and continue */
+
+int sum(int n) {
+	int i, s;
+
+	s = 0;
+	for (i = 0; i < n; i++) {
+		if (i == 3)
+			continue;
+		s = s + i;
+	}
+	while (s > 100)
+		s = s - 100;
+	do {
+		if (s == 7)
+			break;
+		s++;
+	} while (s < 10);
+	return s;
+}
@@ -0,0 +1,14 @@
+/* pointer arithmetic, indexing and the address of locals */
+
+char buf[16];
+
+int g(int *p, int n) {
+	int a[4];
+	int *q;
+
+	q = &a[1];
+	*q = p[n];
+	q = q + 2;
+	buf[n] = *p++;
+	return q - a;
+}
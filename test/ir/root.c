@@ -0,0 +1,11 @@
+/* the trees whose roots the optimizer replaces, which are emitted as
+ * they come out of it: a product by a power of two becomes a shift and
+ * a sum with 0 the other operand */
+
+int twice(int x) {
+	return 2 * x;
+}
+
+int same(int x) {
+	return x + 0;
+}
@@ -0,0 +1,15 @@
+/* static pointers initialized to address constants */
+
+int a[8];
+int x;
+int *p = &a[3];
+int *q = a + 2;
+char *s = "str";
+char *names[] = { "one", "two", 0 };
+
+int *f(void) {
+	static int n;
+	static int *pn = &n;
+
+	return pn;
+}
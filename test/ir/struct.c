@@ -0,0 +1,21 @@
+/* members of structs and unions, through values and pointers */
+
+struct point {
+	int x, y;
+	char tag;
+	struct point *next;
+};
+
+union word {
+	int i;
+	char c[8];
+};
+
+struct point origin;
+
+int area(struct point *p, union word *w) {
+	origin.x = p->x;
+	origin.next = p;
+	w->c[1] = p->tag;
+	return p->x * p->next->y + w->i;
+}
@@ -0,0 +1,20 @@
+/* switch with a default, fall through and cases out of order */
+
+int classify(int c) {
+	int r;
+
+	switch (c) {
+	case 'a':
+	case 'e':
+		r = 1;
+		break;
+	case 9:
+		r = 2;
+	case 20:
+		r = 3;
+		break;
+	default:
+		r = 0;
+	}
+	return r;
+}
@@ -0,0 +1,15 @@
+/* multi-character and wide character constants, which are ints of the
+ * same value on the targets of either byte order */
+
+int printf(char *fmt, ...);
+
+int mc[] = { 'a', 'ab', 'abc', 'abcd', '\377\1', L'x', L'\377', L'\0' };
+
+int main(void) {
+	int i;
+
+	for (i = 0; i < sizeof(mc) / sizeof(int); i++)
+		printf("%d\n", mc[i]);
+	printf("%d %d\n", 'ab' == ('a' << 8 | 'b'), '\1\2\3' == 0x10203);
+	return 0;
+}
@@ -0,0 +1,48 @@
+/* pointers checked against null with -fsanitize=null, test-sanitize.sh
+ * runs it with an argument to dereference a null one */
+
+int printf(char *fmt, ...);
+
+struct node {
+	int val;
+	struct node *next;
+};
+
+struct node n3, n2, n1;
+
+int sum(struct node *p) {
+	int s;
+
+	s = 0;
+	while (p) {
+		s = s + p->val;
+		p = p->next;
+	}
+	return s;
+}
+
+int first(int *v, int n) {
+	int i, s;
+
+	s = 0;
+	for (i = 0; i < n; i++)
+		s = s + v[i];
+	return s;
+}
+
+int main(int argc, char **argv) {
+	struct node *p;
+	int *q;
+
+	n1.val = 1; n1.next = &n2;
+	n2.val = 2; n2.next = &n3;
+	n3.val = 3; n3.next = 0;
+	printf("%d %d\n", sum(&n1), first(0, 0));
+	p = n1.next->next;
+	printf("%d\n", p->val);
+	q = &n1.val;
+	if (argc > 1)
+		q = 0;
+	printf("%d\n", *q);
+	return 0;
+}
@@ -0,0 +1,37 @@
+/* the overflow checking builtins, at the limits of the int */
+
+int printf(char *fmt, ...);
+
+int main(void) {
+	int max, min, r, o;
+	char c;
+
+	max = ~(1 << (sizeof(int) * 8 - 1));
+	min = -max - 1;
+
+	o = __builtin_add_overflow(1, 2, &r);
+	printf("add %d %d\n", o, r);
+	o = __builtin_add_overflow(max, 1, &r);
+	printf("add %d %d\n", o, r == min);
+	o = __builtin_add_overflow(min, -1, &r);
+	printf("add %d %d\n", o, r == max);
+
+	o = __builtin_sub_overflow(5, 7, &r);
+	printf("sub %d %d\n", o, r);
+	o = __builtin_sub_overflow(min, 1, &r);
+	printf("sub %d %d\n", o, r == max);
+	o = __builtin_sub_overflow(0, min, &r);
+	printf("sub %d %d\n", o, r == min);
+
+	o = __builtin_mul_overflow(-6, 7, &r);
+	printf("mul %d %d\n", o, r);
+	o = __builtin_mul_overflow(max / 2, 3, &r);
+	printf("mul %d\n", o);
+	o = __builtin_mul_overflow(min, -1, &r);
+	printf("mul %d %d\n", o, r == min);
+
+	c = 100;
+	if (__builtin_mul_overflow(c, c, &r) || r != 10000)
+		printf("bad\n");
+	return __builtin_add_overflow(max, 0, &r);
+}
@@ -0,0 +1,21 @@
+#include <stdio.h>
+
+int	v[] = { 1, -1, 5, -8, 0x7f };
+
+int main(void)
+{
+	int i, x, n, bits;
+
+	bits = sizeof(int) * 8;
+	for (i = 0; i < 5; i++) {
+		x = v[i];
+		for (n = 0; n < bits; n += 7)
+			printf("%d << %d = %d, %d >> %d = %d\n", x, n, x << n, x, n, x >> n);
+	}
+
+	x = 1;
+	n = bits - 1;
+	printf("%d %d\n", (x << n) == (1 << (sizeof(int) * 8 - 1)), (x << n) >> n);
+	printf("%d %d\n", -1 >> 1, -8 >> 2);
+	return 0;
+}
@@ -0,0 +1,27 @@
+/* statement expressions, written out and in function-like macros */
+
+int printf(char *fmt, ...);
+
+#define V(x) ({ 3; })
+#define SQ(a) ({ int _a; _a = (a); _a * _a; })
+#define MAX(a, b) ({ int _x, _y; _x = (a); _y = (b); _x > _y ? _x : _y; })
+
+int calls;
+
+int next(void) {
+	return ++calls;
+}
+
+int main(void) {
+	int i, s;
+
+	s = ({ int t; t = 0; for (i = 1; i <= 4; i++) t += i; t; });
+	printf("%d %d\n", V(1), s);
+	printf("%d %d\n", SQ(4), SQ(SQ(2)));
+	s = SQ(next());
+	printf("%d %d\n", s, calls);
+	s = MAX(SQ(3),
+		8);
+	printf("%d %d\n", s, MAX(next(), 0));
+	return 0;
+}
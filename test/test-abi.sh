@@ -0,0 +1,16 @@
+#!/bin/sh
+
+# Checks that docs/abi.txt is the ABI that abidoc prints from the tables
+# of the backends, with -u it is updated instead.
+
+export SCCROOT="$(pwd)/.."
+
+if [ "$1" = "-u" ]
+then
+	exec $SCCROOT/bin/abidoc -o $SCCROOT/docs/abi.txt
+fi
+if ! $SCCROOT/bin/abidoc | diff -u $SCCROOT/docs/abi.txt -
+then
+	echo "docs/abi.txt is out of date, test-abi.sh -u updates it"
+	exit 1
+fi
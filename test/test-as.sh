@@ -0,0 +1,8 @@
+#!/bin/sh
+
+# Checks the encodings of every form of the instructions that sas takes
+# against the GNU assembler, and that they come out the same when the
+# disassembly of objdump is assembled again.
+
+export SCCROOT="$(pwd)/.."
+$SCCROOT/bin/ascheck -as ${AS:-as} -objdump ${OBJDUMP:-objdump}
@@ -0,0 +1,26 @@
+#!/bin/sh
+
+# Checks the conversions to char: char.c finds the ones used right away
+# that give something else than a char variable holds, and its
+# comparisons of chars have to come out as they do with -compat, which
+# leaves the values used right away as wide as they were computed.
+
+export SCCROOT="$(pwd)/.."
+
+$SCCROOT/bin/scc -o char char.c || exit 1
+$SCCROOT/bin/scc -compat -o char-compat char.c || exit 1
+status=0
+if ! ./char
+then
+	echo "char converted a value used right away wrong"
+	status=1
+fi
+./char cmp >char.out
+./char-compat cmp >char-compat.out
+if ! diff -u char-compat.out char.out
+then
+	echo "char compared chars differently than with -compat"
+	status=1
+fi
+rm -f char char-compat char.out char-compat.out
+exit $status
@@ -0,0 +1,26 @@
+#!/bin/sh
+
+# Checks that the object of each test program is the same whether it is
+# compiled by a scc of its own or by the daemon after the programs before
+# it, as the labels of a translation unit are numbered in it and nothing
+# the compiler makes up carries over from one compile to the next.
+
+set -e
+
+rm -rf one all
+mkdir one all
+
+export SCCROOT="$(pwd)/.."
+sock="$(pwd)/scc.sock"
+$SCCROOT/bin/scc -daemon $sock &
+daemon=$!
+trap "kill $daemon; rm -rf one all" EXIT
+sleep 1
+
+for i in *.c
+do
+	file=`basename $i .c`
+	$SCCROOT/bin/scc -direct -c -o one/$file.o $i
+	$SCCROOT/bin/scc -connect $sock -direct -c -o all/$file.o $i
+	cmp one/$file.o all/$file.o
+done
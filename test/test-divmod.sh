@@ -0,0 +1,48 @@
+#!/bin/sh
+
+# Checks that / truncates toward zero and % takes the sign of the
+# dividend, with the operands known or not and the divisors powers of
+# two, and that the most negative int divided by -1 is left for the
+# target to trap on at run time with a warning instead of being folded.
+
+export SCCROOT="$(pwd)/.."
+
+status=0
+for opts in "" "-O 2" "-compat"
+do
+	# -compat divides by the powers of two with a right shift like
+	# SubC does, which rounds the negative quotients down
+	case "$opts" in
+	-compat)
+		;;
+	*)
+		$SCCROOT/bin/scc $opts -o divmod divmod.c || exit 1
+		./divmod >divmod.out
+		if ! diff -u divmod.ok divmod.out
+		then
+			echo "divmod printed the wrong output with options \"$opts\""
+			status=1
+		fi
+		;;
+	esac
+
+	warn=`echo '#include <stdio.h>
+#include <limits.h>
+int main(void) { printf("%d", INT_MIN / -1); return 0; }' |
+		$SCCROOT/bin/scc $opts -o divmin - 2>&1`
+	case "$warn" in
+	*"dividing the most negative int by -1 overflows"*)
+		;;
+	*)
+		echo "INT_MIN / -1 warned \"$warn\" with options \"$opts\""
+		status=1
+		;;
+	esac
+	if sh -c ./divmin >/dev/null 2>&1
+	then
+		echo "INT_MIN / -1 didn't trap with options \"$opts\""
+		status=1
+	fi
+done
+rm -f divmod divmod.out divmin
+exit $status
@@ -0,0 +1,29 @@
+#!/bin/sh
+
+# Checks the structure of the objects that sas and scc -direct write for
+# the test programs with elfcheck, on amd64 and on mips.
+
+set -e
+
+rm -f *.S *.o *.O
+
+export SCCROOT="$(pwd)/.."
+for i in *.c
+do
+	file=`basename $i .c`
+	$SCCROOT/bin/scc -compat -S $i > $file.S
+	$SCCROOT/bin/sas -o $file.o $file.S
+	$SCCROOT/bin/scc -direct -c -o $file.O $i
+	case $file in
+	overflow)
+		# the overflow builtins are only on the x86 targets
+		continue
+		;;
+	esac
+	$SCCROOT/bin/scc -compat -S -arch mips -os linux $i > $file.mips.S
+	$SCCROOT/bin/sas -arch mips -o $file.mips.o $file.mips.S
+	$SCCROOT/bin/scc -direct -c -arch mips -os linux -o $file.mips.O $i
+done
+$SCCROOT/bin/elfcheck *.o *.O
+
+rm -f *.S *.o *.O
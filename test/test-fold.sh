@@ -0,0 +1,21 @@
+#!/bin/sh
+
+# Checks the constant folding of the compiler and the code it generates
+# for the same expressions against the values foldcheck evaluates them
+# to, with the options of the compiler that change the folding.
+
+export SCCROOT="$(pwd)/.."
+
+status=0
+for args in "" "-O 2" "-compat"
+do
+	for seed in 1 2 3
+	do
+		if ! $SCCROOT/bin/foldcheck -scc $SCCROOT/bin/scc -args "$args" -seed $seed -n 500
+		then
+			echo "with options \"$args\" and seed $seed"
+			status=1
+		fi
+	done
+done
+exit $status
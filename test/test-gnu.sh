@@ -0,0 +1,40 @@
+#!/bin/sh
+
+# Checks the GNU extensions against the output of the programs in
+# the .ok files, with the builtin preprocessor and with cpp, that
+# -ansi rejects them and that overlapping case ranges are an error.
+
+export SCCROOT="$(pwd)/.."
+
+status=0
+for i in caserange stmtexpr threaded
+do
+	for opts in "" "-cpp"
+	do
+		$SCCROOT/bin/scc $opts -o $i $i.c || exit 1
+		./$i >$i.out
+		if ! diff -u $i.ok $i.out
+		then
+			echo "$i printed the wrong output with options \"$opts\""
+			status=1
+		fi
+	done
+	if $SCCROOT/bin/scc -ansi -o $i $i.c 2>/dev/null
+	then
+		echo "$i compiled with -ansi"
+		status=1
+	fi
+done
+
+err=`echo 'int f(int c) { switch (c) { case 1 ... 5: case 5 ... 9: return 1; } return 0; }' |
+	$SCCROOT/bin/scc -c -o overlap.o - 2>&1`
+case "$err" in
+*"duplicate case value"*)
+	;;
+*)
+	echo "overlapping case ranges gave \"$err\""
+	status=1
+	;;
+esac
+rm -f caserange caserange.out stmtexpr stmtexpr.out threaded threaded.out overlap.o
+exit $status
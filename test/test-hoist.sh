@@ -0,0 +1,22 @@
+#!/bin/sh
+
+# Checks that a loop that stores through a pointer reads a global that
+# isn't static each time round: hoistp.c points the pointers of hoist.c
+# at its globals, so the loops of hoist.c change them without knowing,
+# a counter and the pointer an array is walked through.
+
+export SCCROOT="$(pwd)/.."
+
+status=0
+for opts in "" "-O 2" "-compat"
+do
+	$SCCROOT/bin/scc $opts -o hoist hoist.c hoistp.c || exit 1
+	out=`./hoist`
+	if [ "$out" != "6 91" ]
+	then
+		echo "hoist printed $out instead of 6 91 with options \"$opts\""
+		status=1
+	fi
+done
+rm -f hoist
+exit $status
@@ -0,0 +1,23 @@
+#!/bin/sh
+
+# Checks -finstrument-functions: instrument.c counts the calls of its
+# hooks, which the functions but the untraced one and the hooks make.
+
+export SCCROOT="$(pwd)/.."
+
+$SCCROOT/bin/scc -finstrument-functions -o instrument instrument.c || exit 1
+out=`./instrument`
+want="fact 120, 6 entries, 5 exits, 6 deep
+last 1, site 1
+name 11"
+status=0
+if [ "$out" != "$want" ]
+then
+	echo "instrument printed"
+	echo "$out"
+	echo "instead of"
+	echo "$want"
+	status=1
+fi
+rm -f instrument
+exit $status
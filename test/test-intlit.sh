@@ -0,0 +1,62 @@
+#!/bin/sh
+
+# Checks the types of the integer constants: with -int-size 16 and 32
+# the ones of intlit.c that don't fit in an int are longs, sizeof gives
+# the size of a long for them, and storing one in an int keeps the bits
+# that fit with a warning. A constant that doesn't fit in a long either
+# is wrapped to its width with a warning, with any int size.
+
+export SCCROOT="$(pwd)/.."
+
+status=0
+for size in 16 32 64
+do
+	case $size in
+	16)
+		warn=5
+		want="Cbig:	.short	0
+Csmall:	.short	4464
+Csbig:	.short	4
+Cssmall:	.short	4
+Chuge:	.short	-1"
+		;;
+	32)
+		warn=2
+		want="Cbig:	.long	-2147483648
+Csmall:	.long	70000
+Csbig:	.long	8
+Cssmall:	.long	4
+Chuge:	.long	-1"
+		;;
+	64)
+		warn=1
+		want="Cbig:	.quad	2147483648
+Csmall:	.quad	70000
+Csbig:	.quad	8
+Cssmall:	.quad	8
+Chuge:	.quad	-1"
+		;;
+	esac
+	flag="-int-size $size"
+	if [ $size = 64 ]
+	then
+		flag=
+	fi
+	$SCCROOT/bin/scc $flag -S -o intlit.s intlit.c 2>intlit.err || exit 1
+	n=`grep -c "warning:" intlit.err`
+	if [ "$n" != $warn ]
+	then
+		echo "intlit.c gave $n warnings with $size bit ints, not $warn"
+		cat intlit.err
+		status=1
+	fi
+	got=`grep '^C[a-z]*:	\.' intlit.s`
+	if [ "$got" != "$want" ]
+	then
+		echo "the constants of intlit.c are stored with $size bit ints as"
+		echo "$got"
+		status=1
+	fi
+done
+rm -f intlit.s intlit.err
+exit $status
@@ -0,0 +1,22 @@
+#!/bin/sh
+
+# Checks the calls that the compiler makes to the backend for the
+# language constructs in ir/ against the ones recorded in the .ir files
+# next to them, with -u the .ir files are updated instead.
+
+export SCCROOT="$(pwd)/.."
+cd $SCCROOT/test/ir
+
+status=0
+for i in *.c
+do
+	file=`basename $i .c`
+	if [ "$1" = "-u" ]
+	then
+		$SCCROOT/bin/irdump $i > $file.ir
+	elif ! $SCCROOT/bin/irdump $i | diff -u $file.ir -
+	then
+		status=1
+	fi
+done
+exit $status
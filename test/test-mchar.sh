@@ -0,0 +1,48 @@
+#!/bin/sh
+
+# Checks the multi-character and wide character constants: mchar.c
+# prints their values, which have to be the ones in mchar.ok, each
+# multi-character one gives a warning, and the table of them is laid
+# out in the data with the byte order of the target, little endian on
+# i386 and big endian on mips, holding the same values.
+
+export SCCROOT="$(pwd)/.."
+
+status=0
+warn=`$SCCROOT/bin/scc -o mchar mchar.c 2>&1` || exit 1
+n=`echo "$warn" | grep -c "warning: multi-character character constant"`
+if [ "$n" != 6 ]
+then
+	echo "mchar.c gave $n warnings of multi-character constants, not 6"
+	status=1
+fi
+./mchar >mchar.out
+if ! diff -u mchar.ok mchar.out
+then
+	echo "mchar printed the wrong values"
+	status=1
+fi
+
+for arch in i386 mips
+do
+	case $arch in
+	i386)
+		want=" 0000 61000000 62610000 63626100 64636261  a...ba..cba.dcba
+ 0010 01ff0000 78000000 ff000000 00000000  ....x..........."
+		;;
+	mips)
+		want=" 0000 00000061 00006162 00616263 61626364  ...a..ab.abcabcd
+ 0010 0000ff01 00000078 000000ff 00000000  .......x........"
+		;;
+	esac
+	$SCCROOT/bin/scc -direct -c -arch $arch -os linux -o mchar.$arch.O mchar.c 2>/dev/null || exit 1
+	got=`${OBJDUMP:-objdump} -s -j .data mchar.$arch.O | sed -n '/^ 00[01]0 /p'`
+	if [ "$got" != "$want" ]
+	then
+		echo "the constants of mchar.c are laid out on $arch as"
+		echo "$got"
+		status=1
+	fi
+done
+rm -f mchar mchar.out mchar.i386.O mchar.mips.O
+exit $status
@@ -0,0 +1,23 @@
+#!/bin/sh
+
+# Checks the run time checks of -fsanitize: nullcheck.c runs through
+# its pointers, and reports where it dereferences a null one given an
+# argument.
+
+export SCCROOT="$(pwd)/.."
+
+$SCCROOT/bin/scc -fsanitize=null -o nullcheck nullcheck.c || exit 1
+status=0
+if ! ./nullcheck >/dev/null
+then
+	echo "nullcheck failed without a null pointer"
+	status=1
+fi
+msg=`./nullcheck x 2>&1 >/dev/null`
+if [ $? -eq 0 ] || [ "$msg" != "nullcheck.c:46:17: null pointer dereference in *q" ]
+then
+	echo "nullcheck reported \"$msg\" for the null pointer"
+	status=1
+fi
+rm -f nullcheck
+exit $status
@@ -0,0 +1,50 @@
+#!/bin/sh
+
+# Checks the shifts of shift.c against shift.ok, and that a shift by a
+# constant count out of the range of int warns and is done like the
+# target does it at run time: amd64 masks the count to 6 bits, and the
+# bits shifted out of the int are lost whether the shift is folded or
+# not.
+
+export SCCROOT="$(pwd)/.."
+
+status=0
+for opts in "" "-O 2" "-compat"
+do
+	$SCCROOT/bin/scc $opts -o shift shift.c || exit 1
+	./shift >shift.out
+	if ! diff -u shift.ok shift.out
+	then
+		echo "shift printed the wrong output with options \"$opts\""
+		status=1
+	fi
+
+	warn=`echo 'int printf(char *fmt, ...);
+int main(void) {
+	int x;
+	x = 3;
+	printf("%d %d %d %d %d", 3 << 64, 3 >> 65, 1 << -1, 3 << 63, x << 63);
+	return 0;
+}' | $SCCROOT/bin/scc $opts -o shiftmax - 2>&1`
+	want="<stdin>:5:32: warning: shift count 64 is not less than the width of int
+<stdin>:5:41: warning: shift count 65 is not less than the width of int
+<stdin>:5:50: warning: shift count -1 is negative"
+	if [ "$warn" != "$want" ]
+	then
+		echo "the oversized shifts warned"
+		echo "$warn"
+		echo "instead of"
+		echo "$want"
+		echo "with options \"$opts\""
+		status=1
+	fi
+	out=`./shiftmax`
+	want="3 1 -9223372036854775808 -9223372036854775808 -9223372036854775808"
+	if [ "$out" != "$want" ]
+	then
+		echo "the oversized shifts printed $out instead of $want with options \"$opts\""
+		status=1
+	fi
+done
+rm -f shift shift.out shiftmax
+exit $status
@@ -0,0 +1,36 @@
+#!/bin/sh
+
+# Checks that the strings of the directives that sas takes, with the
+# escapes of the GNU assembler, the nul and high bytes, come out in the
+# data of the object as they do from the GNU assembler, and that the
+# .ident that scc quotes survives to the .comment of the object.
+
+set -e
+
+export SCCROOT="$(pwd)/.."
+cat >strings.S <<'EOF'
+	.data
+	.ascii	"plain", "two\0nuls\0"
+	.asciz	"tab\there\nnew line", "\"quoted\" \\ back"
+	.string	"# not a comment: nor a label"
+	.ascii	"\1\12\123\1234\377\200"
+	.ascii	"\x41\x7f\xff\x00\x1234"
+	.string	"\b\f\r", ""
+	.byte	'a', 'Z', '0'
+	.ascii	"high bytes é ü"
+EOF
+$SCCROOT/bin/sas -o strings.o strings.S
+${AS:-as} -o strings.O strings.S
+objcopy -O binary -j .data strings.o strings.o.data
+objcopy -O binary -j .data strings.O strings.O.data
+cmp strings.o.data strings.O.data
+
+cat >strings.c <<'EOF'
+int main(void) { return 0; }
+EOF
+text='"quoted" \back# : é'
+$SCCROOT/bin/scc -direct -c -D "X=$text" -o strings.o strings.c
+objcopy --dump-section .comment=strings.o.data strings.o
+grep -q -F -- "-D X=$text" strings.o.data
+
+rm -f strings.S strings.c strings.o strings.O strings.o.data strings.O.data
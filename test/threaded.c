@@ -0,0 +1,96 @@
+/* a direct-threaded interpreter, with &&label and goto * */
+
+int printf(char *fmt, ...);
+
+enum { PUSH, LOAD, STORE, ADD, SUB, MUL, JNZ, PRINT, HALT };
+
+/* n! and the sum of 1..n for n in slot 0, threaded into code */
+int prog[] = {
+	PUSH, 1, STORE, 1,
+	PUSH, 0, STORE, 2,
+	LOAD, 1, LOAD, 0, MUL, STORE, 1,
+	LOAD, 2, LOAD, 0, ADD, STORE, 2,
+	LOAD, 0, PUSH, 1, SUB, STORE, 0,
+	LOAD, 0, JNZ, 8,
+	LOAD, 1, PRINT, LOAD, 2, PRINT, HALT
+};
+
+int run(int n) {
+	static void *ops[] = {
+		&&push, &&load, &&store, &&add, &&sub, &&mul, &&jnz, &&print, &&halt
+	};
+	void *code[64];
+	void **ip;
+	int stack[16], slot[4];
+	int i, sp, len;
+
+	/* thread the program: each op becomes the address of its code,
+	 * the operands stay in line and the jumps go to threaded code */
+	len = sizeof(prog) / sizeof(int);
+	for (i = 0; i < len; i++) {
+		code[i] = ops[prog[i]];
+		if (prog[i] == PUSH || prog[i] == LOAD || prog[i] == STORE || prog[i] == JNZ) {
+			i++;
+			code[i] = (void *) prog[i];
+		}
+	}
+	for (i = 0; i < len; i++)
+		if (prog[i] == JNZ)
+			code[i+1] = &code[prog[i+1]];
+
+	slot[0] = n;
+	sp = 0;
+	ip = code;
+	goto **ip++;
+
+push:
+	stack[sp++] = (int) *ip++;
+	goto **ip++;
+load:
+	stack[sp++] = slot[(int) *ip++];
+	goto **ip++;
+store:
+	slot[(int) *ip++] = stack[--sp];
+	goto **ip++;
+add:
+	sp--;
+	stack[sp-1] = stack[sp-1] + stack[sp];
+	goto **ip++;
+sub:
+	sp--;
+	stack[sp-1] = stack[sp-1] - stack[sp];
+	goto **ip++;
+mul:
+	sp--;
+	stack[sp-1] = stack[sp-1] * stack[sp];
+	goto **ip++;
+jnz:
+	if (stack[--sp]) {
+		ip = *ip;
+		goto **ip++;
+	}
+	ip++;
+	goto **ip++;
+print:
+	printf("%d\n", stack[--sp]);
+	goto **ip++;
+halt:
+	return sp;
+}
+
+int main(void) {
+	void *local[] = { &&one, &&two };
+	void *p;
+	int k;
+
+	for (k = 0; k < 2; k++) {
+		p = local[k];
+		goto *p;
+one:
+		printf("one\n");
+		continue;
+two:
+		printf("two\n");
+	}
+	return run(5) + run(10);
+}